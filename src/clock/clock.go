@@ -0,0 +1,66 @@
+// Package clock is where the service reads the time. Expiry, grace windows,
+// SLAs and other time-dependent rules ask Default rather than the system
+// clock, so a test can set the time and move it on by hand.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is the system clock.
+type Real struct{}
+
+func (Real) Now() time.Time { return time.Now() }
+
+// Fake is a clock that stands still until it is set or advanced. It is safe
+// for concurrent use.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	f.now = now
+	f.mu.Unlock()
+}
+
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	f.now = f.now.Add(d)
+	f.mu.Unlock()
+}
+
+// Default is the clock the service runs on. Tests can swap it for a Fake.
+var Default Clock = Real{}
+
+// Now is Default's time.
+func Now() time.Time {
+	return Default.Now()
+}
+
+// Since is how long ago t was on Default.
+func Since(t time.Time) time.Duration {
+	return Now().Sub(t)
+}
+
+// Until is how long until t on Default.
+func Until(t time.Time) time.Duration {
+	return t.Sub(Now())
+}
@@ -0,0 +1,29 @@
+// Command api serves the food delivery REST and gRPC APIs and runs the
+// background jobs that move orders along. Order events are consumed by
+// cmd/notification-worker, deployed and scaled separately; set
+// CONSUMER_IN_API to run the whole service in this one process instead.
+//
+// `api backfill` rebuilds a projection from the order event log and exits;
+// run it with -h for its flags.
+package main
+
+import (
+	"os"
+
+	"myproject/src/internal/app"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		app.RunBackfill(os.Args[2:])
+		return
+	}
+
+	clients := app.Setup("api")
+	defer clients.Release()
+
+	menus := app.NewMenuService(clients.Redis, clients.Analytics, clients.Repositories)
+	orders := app.NewOrderService(clients.Redis, clients.Analytics, clients.Repositories, clients.Config)
+	notifications := app.NewNotificationService(clients.Redis, clients.Repositories)
+	app.RunAPI(menus, orders, notifications)
+}
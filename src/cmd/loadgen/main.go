@@ -0,0 +1,14 @@
+// Command loadgen places orders against a running api at a steady rate, as
+// many customers would, and reports the latency of each call and the Kafka
+// messages the api published. Run it with -h for its flags.
+package main
+
+import (
+	"os"
+
+	"myproject/src/internal/app"
+)
+
+func main() {
+	app.RunLoadgen(os.Args[1:])
+}
@@ -0,0 +1,13 @@
+// Command notification-worker consumes order events from Kafka and sends the
+// notifications, refunds and other follow-ups they call for. Run as many as
+// the event volume needs; they share one consumer group.
+package main
+
+import "myproject/src/internal/app"
+
+func main() {
+	clients := app.Setup("notification-worker")
+	defer clients.Release()
+
+	app.RunNotificationWorker()
+}
@@ -0,0 +1,202 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Schema versions of the envelope's payload. Producers write the version
+// they are configured for and consumers read every version here, so a new
+// version is rolled out by upgrading consumers first and producers after.
+const (
+	// Unversioned is a bare OrderEvent with no envelope, as every event was
+	// published before there were envelopes. Only read, never written.
+	Unversioned = 0
+	// SchemaV1 is the line of text events were published as before they
+	// were JSON, such as "Order Created: <id> | Restaurant: <id> | Total:
+	// 12.50". It is written bare, without an envelope, so consumers from
+	// then can still read it. It carries only the type and order ID, and
+	// for OrderCreated the restaurant and total.
+	SchemaV1 = 1
+	// SchemaV2 carries the event as a JSON object.
+	SchemaV2 = 2
+
+	// CurrentSchema is the version producers write unless told otherwise.
+	CurrentSchema = SchemaV2
+)
+
+// ErrUnknownSchema is an envelope of a version this build cannot read.
+var ErrUnknownSchema = errors.New("unknown event schema version")
+
+// Envelope wraps every order event on the wire with its schema version and
+// type, so a consumer can tell how to read the payload before reading it.
+type Envelope struct {
+	SchemaVersion int             `json:"schema_version"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// Codec encodes events to, and decodes them from, the payload of one
+// schema version.
+type Codec interface {
+	Encode(event OrderEvent) (json.RawMessage, error)
+	Decode(payload json.RawMessage) (OrderEvent, error)
+}
+
+// codecs holds the codec of every schema version that can be written.
+var codecs = map[int]Codec{
+	SchemaV1: textCodec{},
+	SchemaV2: jsonCodec{},
+}
+
+// ValidSchema reports whether producers can write version.
+func ValidSchema(version int) error {
+	if _, ok := codecs[version]; !ok {
+		return fmt.Errorf("%w %d", ErrUnknownSchema, version)
+	}
+	return nil
+}
+
+// Encode wraps event in an envelope of version, or for SchemaV1 writes its
+// line of text.
+func Encode(event OrderEvent, version int) ([]byte, error) {
+	if version == SchemaV1 {
+		return []byte(legacyLine(event)), nil
+	}
+	codec, ok := codecs[version]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownSchema, version)
+	}
+	payload, err := codec.Encode(event)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{SchemaVersion: version, EventType: event.Type, Payload: payload})
+}
+
+// Decode reads the event in data, enveloped or not, and returns it with the
+// schema version it was written in. An envelope's type stands for its
+// payload's if the payload has none, and must agree with it if it does.
+func Decode(data []byte) (OrderEvent, int, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] != '{' {
+		event, err := parseLegacyLine(string(trimmed))
+		return event, SchemaV1, err
+	}
+
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return OrderEvent{}, Unversioned, err
+	}
+	if envelope.SchemaVersion == Unversioned && envelope.Payload == nil {
+		var event OrderEvent
+		err := json.Unmarshal(data, &event)
+		return event, Unversioned, err
+	}
+
+	codec, ok := codecs[envelope.SchemaVersion]
+	if !ok {
+		return OrderEvent{}, envelope.SchemaVersion, fmt.Errorf("%w %d", ErrUnknownSchema, envelope.SchemaVersion)
+	}
+	event, err := codec.Decode(envelope.Payload)
+	if err != nil {
+		return OrderEvent{}, envelope.SchemaVersion, fmt.Errorf("decoding v%d payload: %w", envelope.SchemaVersion, err)
+	}
+	if event.Type == "" {
+		event.Type = envelope.EventType
+	} else if envelope.EventType != "" && envelope.EventType != event.Type {
+		return OrderEvent{}, envelope.SchemaVersion, fmt.Errorf("envelope says %s, payload says %s", envelope.EventType, event.Type)
+	}
+	return event, envelope.SchemaVersion, nil
+}
+
+// textCodec is SchemaV1 inside an envelope: the event's line of text as a
+// JSON string.
+type textCodec struct{}
+
+func (textCodec) Encode(event OrderEvent) (json.RawMessage, error) {
+	return json.Marshal(legacyLine(event))
+}
+
+func (textCodec) Decode(payload json.RawMessage) (OrderEvent, error) {
+	var line string
+	if err := json.Unmarshal(payload, &line); err != nil {
+		return OrderEvent{}, err
+	}
+	return parseLegacyLine(line)
+}
+
+// legacyCreated is the line an OrderCreated event was published as.
+const legacyCreated = "Order Created: %s | Restaurant: %s | Total: %.2f"
+
+// legacyActions end the lines of the other events that had one, after
+// "Order <id> ". Events that had none end with their type instead.
+var legacyActions = map[string]string{
+	OrderAccepted:  "Accept Order",
+	OrderPickedUp:  "Confirm Pickup",
+	OrderDelivered: "Delivered",
+}
+
+// legacyLine is event as a SchemaV1 line of text.
+func legacyLine(event OrderEvent) string {
+	if event.Type == OrderCreated {
+		return fmt.Sprintf(legacyCreated, event.OrderID, event.RestaurantID, event.TotalAmount)
+	}
+	action, ok := legacyActions[event.Type]
+	if !ok {
+		action = event.Type
+	}
+	return "Order " + event.OrderID + " " + action
+}
+
+// parseLegacyLine reads an event from a SchemaV1 line of text.
+func parseLegacyLine(line string) (OrderEvent, error) {
+	if rest, ok := strings.CutPrefix(line, "Order Created: "); ok {
+		parts := strings.Split(rest, " | ")
+		if len(parts) != 3 {
+			return OrderEvent{}, fmt.Errorf("malformed %s line %q", OrderCreated, line)
+		}
+		restaurantID, okRestaurant := strings.CutPrefix(parts[1], "Restaurant: ")
+		total, okTotal := strings.CutPrefix(parts[2], "Total: ")
+		if !okRestaurant || !okTotal {
+			return OrderEvent{}, fmt.Errorf("malformed %s line %q", OrderCreated, line)
+		}
+		amount, err := strconv.ParseFloat(total, 64)
+		if err != nil {
+			return OrderEvent{}, fmt.Errorf("malformed total in %q: %w", line, err)
+		}
+		return OrderEvent{Type: OrderCreated, OrderID: parts[0], RestaurantID: restaurantID, TotalAmount: amount}, nil
+	}
+
+	rest, ok := strings.CutPrefix(line, "Order ")
+	orderID, action, found := strings.Cut(rest, " ")
+	if !ok || !found || orderID == "" {
+		return OrderEvent{}, fmt.Errorf("not an order event line: %q", line)
+	}
+	for eventType, legacy := range legacyActions {
+		if action == legacy {
+			return OrderEvent{Type: eventType, OrderID: orderID}, nil
+		}
+	}
+	if slices.Contains(Types, action) {
+		return OrderEvent{Type: action, OrderID: orderID}, nil
+	}
+	return OrderEvent{}, fmt.Errorf("unknown action in %q", line)
+}
+
+// jsonCodec is SchemaV2: the event as a JSON object.
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(event OrderEvent) (json.RawMessage, error) {
+	return json.Marshal(event)
+}
+
+func (jsonCodec) Decode(payload json.RawMessage) (OrderEvent, error) {
+	var event OrderEvent
+	err := json.Unmarshal(payload, &event)
+	return event, err
+}
@@ -0,0 +1,111 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// baselineMessages are messages as the api published them before events
+// were JSON.
+var baselineMessages = []struct {
+	line  string
+	event OrderEvent
+}{
+	{
+		line:  "Order Created: 0b8e4c1e-5a57-4c3b-9a52-7d1f3f6a2b10 | Restaurant: 2 | Total: 25.50",
+		event: OrderEvent{Type: OrderCreated, OrderID: "0b8e4c1e-5a57-4c3b-9a52-7d1f3f6a2b10", RestaurantID: "2", TotalAmount: 25.5},
+	},
+	{
+		line:  "Order 0b8e4c1e-5a57-4c3b-9a52-7d1f3f6a2b10 Accept Order",
+		event: OrderEvent{Type: OrderAccepted, OrderID: "0b8e4c1e-5a57-4c3b-9a52-7d1f3f6a2b10"},
+	},
+	{
+		line:  "Order 0b8e4c1e-5a57-4c3b-9a52-7d1f3f6a2b10 Confirm Pickup",
+		event: OrderEvent{Type: OrderPickedUp, OrderID: "0b8e4c1e-5a57-4c3b-9a52-7d1f3f6a2b10"},
+	},
+	{
+		line:  "Order 0b8e4c1e-5a57-4c3b-9a52-7d1f3f6a2b10 Delivered",
+		event: OrderEvent{Type: OrderDelivered, OrderID: "0b8e4c1e-5a57-4c3b-9a52-7d1f3f6a2b10"},
+	},
+}
+
+func TestSchemaV1RoundTripsBaselineMessages(t *testing.T) {
+	for _, tc := range baselineMessages {
+		t.Run(tc.event.Type, func(t *testing.T) {
+			event, version, err := Decode([]byte(tc.line))
+			if err != nil {
+				t.Fatalf("Decode(%q): %v", tc.line, err)
+			}
+			if version != SchemaV1 {
+				t.Errorf("version = %d, want %d", version, SchemaV1)
+			}
+			if event != tc.event {
+				t.Errorf("Decode(%q) = %+v, want %+v", tc.line, event, tc.event)
+			}
+
+			data, err := Encode(event, SchemaV1)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if string(data) != tc.line {
+				t.Errorf("Encode = %q, want %q", data, tc.line)
+			}
+		})
+	}
+}
+
+func TestSchemaV1InEnvelope(t *testing.T) {
+	tc := baselineMessages[0]
+	payload, _ := json.Marshal(tc.line)
+	data, _ := json.Marshal(Envelope{SchemaVersion: SchemaV1, EventType: OrderCreated, Payload: payload})
+
+	event, version, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if version != SchemaV1 || event != tc.event {
+		t.Errorf("Decode = %+v at v%d, want %+v at v%d", event, version, tc.event, SchemaV1)
+	}
+}
+
+func TestSchemaV1EventsWithoutBaselineLine(t *testing.T) {
+	event := OrderEvent{Type: OrderPaid, OrderID: "0b8e4c1e-5a57-4c3b-9a52-7d1f3f6a2b10"}
+	data, err := Encode(event, SchemaV1)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if want := "Order 0b8e4c1e-5a57-4c3b-9a52-7d1f3f6a2b10 OrderPaid"; string(data) != want {
+		t.Errorf("Encode = %q, want %q", data, want)
+	}
+	decoded, _, err := Decode(data)
+	if err != nil || decoded != event {
+		t.Errorf("Decode = %+v, %v; want %+v", decoded, err, event)
+	}
+}
+
+func TestSchemaV1RejectsOtherText(t *testing.T) {
+	for _, line := range []string{
+		"Notification: Order 1 Delivered",
+		"Order Created: 1 | Total: 2.00",
+		"Order 1 Teleported",
+	} {
+		if _, _, err := Decode([]byte(line)); err == nil {
+			t.Errorf("Decode(%q) succeeded", line)
+		}
+	}
+}
+
+func TestSchemaV2RoundTrip(t *testing.T) {
+	want := OrderEvent{EventID: "e1", Type: OrderPaid, OrderID: "o1", CustomerID: "c1", Status: "paid", TotalAmount: 12.5}
+	data, err := Encode(want, SchemaV2)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	event, version, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if version != SchemaV2 || event != want {
+		t.Errorf("Decode = %+v at v%d, want %+v at v%d", event, version, want, SchemaV2)
+	}
+}
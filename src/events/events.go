@@ -0,0 +1,80 @@
+// Package events holds the order events the api publishes and the
+// notification worker consumes, so both binaries agree on what is on the
+// wire without importing each other.
+package events
+
+import "myproject/src/model"
+
+// Types of event carried on the orders topics.
+const (
+	OrderCreated   = "OrderCreated"
+	OrderPaid      = "OrderPaid"
+	OrderUpdated   = "OrderUpdated"
+	OrderRefunded  = "OrderRefunded"
+	OrderAccepted  = "OrderAccepted"
+	OrderRejected  = "OrderRejected"
+	OrderPickedUp  = "OrderPickedUp"
+	OrderDelivered = "OrderDelivered"
+	OrderCancelled = "OrderCancelled"
+	OrderExpired   = "OrderExpired"
+	OrderTimedOut  = "OrderTimedOut"
+	RiderAssigned  = "RiderAssigned"
+	RiderArrived   = "RiderArrived"
+	RiderLocation  = "RiderLocationUpdated"
+
+	OrderReadySoon   = "OrderReadySoon"
+	OrderRunningLate = "OrderRunningLate"
+	OrderTipped      = "OrderTipped"
+	RiderUnassigned  = "RiderUnassigned"
+	RiderArriving    = "RiderArriving"
+
+	OrderReadyByChanged = "OrderReadyByChanged"
+)
+
+// Types lists every event type, in lifecycle order.
+var Types = []string{
+	OrderCreated, OrderPaid, OrderUpdated, OrderRefunded, OrderAccepted,
+	OrderRejected, OrderPickedUp, OrderDelivered, OrderCancelled, OrderExpired,
+	OrderTimedOut, RiderAssigned, RiderArrived, RiderLocation, OrderReadySoon,
+	OrderRunningLate, OrderTipped, RiderUnassigned, RiderArriving,
+	OrderReadyByChanged,
+}
+
+// RequestIDHeader is the message header carrying the ID of the request
+// behind an event.
+const RequestIDHeader = "request-id"
+
+// OrderEvent is the payload of every message on the orders topic. It carries
+// enough of the order for consumers to route it without reading Redis.
+type OrderEvent struct {
+	EventID string `json:"event_id"`
+	// Region is where the order lives; consumers refuse events from
+	// another region.
+	Region       string  `json:"region,omitempty"`
+	Type         string  `json:"type"`
+	OrderID      string  `json:"order_id"`
+	OrderCode    string  `json:"order_code"`
+	RestaurantID string  `json:"restaurant_id"`
+	CustomerID   string  `json:"customer_id"`
+	Status       string  `json:"status"`
+	RiderID      string  `json:"rider_id,omitempty"`
+	TotalAmount  float64 `json:"total_amount"`
+	// Tip is the order's tip, included in TotalAmount.
+	Tip    float64  `json:"tip,omitempty"`
+	Reason string   `json:"reason,omitempty"`
+	Lat    *float64 `json:"lat,omitempty"`
+	Lng    *float64 `json:"lng,omitempty"`
+	Gift   bool     `json:"gift,omitempty"`
+	// RefundAmount is what an OrderRefunded event gave back.
+	RefundAmount float64 `json:"refund_amount,omitempty"`
+	// ProofURL is the photo an OrderDelivered order was handed over with,
+	// if the rider took one.
+	ProofURL string `json:"proof_url,omitempty"`
+	// ReadyBy is when the restaurant committed to have the order ready,
+	// once it is accepted.
+	ReadyBy    *model.Timestamp `json:"ready_by,omitempty"`
+	OccurredAt model.Timestamp  `json:"occurred_at"`
+	// RequestID is the API request that caused the event, if one did. It is
+	// also sent as the request-id header.
+	RequestID string `json:"request_id,omitempty"`
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Conditional requests. Read endpoints tag what they serve with an ETag, a
+// hash of the payload, so any change to it, the menu being updated or an
+// item selling out, gives a new one. A client sending the ETag it holds back
+// in If-None-Match is answered 304 with no body while it still matches.
+// Responses are marked no-cache: a client may keep them but revalidates
+// before each use, since availability changes by the minute.
+
+const (
+	headerETag        = "ETag"
+	headerIfNoneMatch = "If-None-Match"
+
+	// RevalidateCacheControl lets clients store a response but not use it
+	// without revalidating.
+	RevalidateCacheControl = "no-cache"
+)
+
+// PayloadETag is the strong ETag of payload as JSON.
+func PayloadETag(payload interface{}) (string, error) {
+	hash := sha256.New()
+	err := json.NewEncoder(hash).Encode(payload)
+	if err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`, nil
+}
+
+// NotModified tags the response with payload's ETag and Cache-Control, and
+// answers 304 if the request's If-None-Match holds that ETag already. It
+// reports whether it did, leaving the caller to write payload if not.
+func NotModified(c echo.Context, payload interface{}) (bool, error) {
+	etag, err := PayloadETag(payload)
+	if err != nil {
+		return false, err
+	}
+	if casing := Casing(c); casing != CasingSnake {
+		// The same payload reads differently in another casing.
+		etag = strings.TrimSuffix(etag, `"`) + "-" + casing + `"`
+	}
+	header := c.Response().Header()
+	header.Set(headerETag, etag)
+	header.Set(echo.HeaderCacheControl, RevalidateCacheControl)
+
+	if !etagListed(c.Request().Header.Get(headerIfNoneMatch), etag) {
+		return false, nil
+	}
+	return true, c.NoContent(http.StatusNotModified)
+}
+
+// etagListed reports whether the If-None-Match value names etag, comparing
+// weakly as RFC 9110 has it for If-None-Match.
+func etagListed(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// TagOrderVersion tags the response with the version of the order it is
+// about, as the ETag to send back in If-Match to change the order again.
+func TagOrderVersion(c echo.Context, version int64) {
+	c.Response().Header().Set(headerETag, `"`+strconv.FormatInt(version, 10)+`"`)
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// JSON casing. The model's json tags are snake_case, and so is the API,
+// unless a caller asks for camelCase: partner clients that expect it are
+// served camelCase keys and may send them. Nothing is tagged twice; the keys
+// are renamed as JSON is written and read, by CasingSerializer for everything
+// going through c.JSON and c.Bind and by RecaseJSON for handlers writing
+// JSON themselves. Only object keys shaped like identifiers are renamed, so
+// maps keyed by a language tag or an ID pass through as they are; values and
+// query parameters are never touched.
+
+const (
+	CasingSnake = "snake_case"
+	CasingCamel = "camelCase"
+
+	// CasingContextKey is where middleware leaves the casing chosen for the
+	// request on the Echo context.
+	CasingContextKey = "json_casing"
+)
+
+var (
+	snakeKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)+$`)
+	camelKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9]*([A-Z][a-z0-9]*)+$`)
+)
+
+// ValidCasing reports whether casing is one of the casings served.
+func ValidCasing(casing string) bool {
+	return casing == CasingSnake || casing == CasingCamel
+}
+
+// Casing returns the casing the caller is served, snake_case unless
+// middleware chose otherwise.
+func Casing(c echo.Context) string {
+	if casing, ok := c.Get(CasingContextKey).(string); ok {
+		return casing
+	}
+	return CasingSnake
+}
+
+// RecaseJSON renames the keys of data, a JSON document as the model tags
+// it, into the caller's casing. data may also be the opening of a document
+// cut between tokens, such as the prefix of a JSONArrayStream.
+func RecaseJSON(c echo.Context, data []byte) ([]byte, error) {
+	if Casing(c) != CasingCamel {
+		return data, nil
+	}
+	return renameKeys(data, camelKey)
+}
+
+// ModelCaseJSON renames the keys of data, a JSON document from the caller,
+// into the model's snake_case.
+func ModelCaseJSON(c echo.Context, data []byte) ([]byte, error) {
+	if Casing(c) != CasingCamel {
+		return data, nil
+	}
+	return renameKeys(data, snakeKey)
+}
+
+// CasingSerializer is the Echo JSON serializer, writing and reading each
+// request's JSON in its casing.
+type CasingSerializer struct {
+	echo.DefaultJSONSerializer
+}
+
+func (s CasingSerializer) Serialize(c echo.Context, i interface{}, indent string) error {
+	if Casing(c) != CasingCamel {
+		return s.DefaultJSONSerializer.Serialize(c, i, indent)
+	}
+	data, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	data, err = renameKeys(data, camelKey)
+	if err != nil {
+		return err
+	}
+	if indent != "" {
+		var indented bytes.Buffer
+		err = json.Indent(&indented, data, "", indent)
+		if err != nil {
+			return err
+		}
+		data = indented.Bytes()
+	}
+	_, err = c.Response().Write(append(data, '\n'))
+	return err
+}
+
+// Deserialize takes keys in either casing from camelCase callers. A body
+// that is not JSON is left for the default serializer to reject.
+func (s CasingSerializer) Deserialize(c echo.Context, i interface{}) error {
+	if Casing(c) != CasingCamel {
+		return s.DefaultJSONSerializer.Deserialize(c, i)
+	}
+	req := c.Request()
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	if renamed, err := ModelCaseJSON(c, body); err == nil {
+		body = renamed
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return s.DefaultJSONSerializer.Deserialize(c, i)
+}
+
+// casedFieldPath puts each name in a field path such as
+// "items[0].menu_id" into the caller's casing.
+func casedFieldPath(c echo.Context, path string) string {
+	if Casing(c) != CasingCamel {
+		return path
+	}
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		name, index, _ := strings.Cut(part, "[")
+		parts[i] = camelKey(name)
+		if index != "" {
+			parts[i] += "[" + index
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// camelKey turns "order_id" into "orderId".
+func camelKey(key string) string {
+	if !snakeKeyPattern.MatchString(key) {
+		return key
+	}
+	words := strings.Split(key, "_")
+	for i, word := range words[1:] {
+		words[i+1] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return strings.Join(words, "")
+}
+
+// snakeKey turns "orderId" into "order_id".
+func snakeKey(key string) string {
+	if !camelKeyPattern.MatchString(key) {
+		return key
+	}
+	var b strings.Builder
+	for _, r := range key {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// renameKeys rewrites data token by token, renaming every object key, so
+// the document keeps its order and its numbers as written. It stops without
+// error where data ends between tokens.
+func renameKeys(data []byte, rename func(string) string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out bytes.Buffer
+	out.Grow(len(data))
+
+	// open holds each object or array entered and not yet left, with how
+	// many tokens have been written in it; in an object, keys are the even
+	// ones.
+	type container struct {
+		object bool
+		tokens int
+	}
+	var open []container
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return out.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			open = open[:len(open)-1]
+			out.WriteRune(rune(delim))
+			continue
+		}
+
+		isKey := false
+		if n := len(open); n > 0 {
+			top := &open[n-1]
+			if top.object && top.tokens%2 == 1 {
+				out.WriteByte(':')
+			} else if top.tokens > 0 {
+				out.WriteByte(',')
+			}
+			isKey = top.object && top.tokens%2 == 0
+			top.tokens++
+		}
+
+		switch tok := tok.(type) {
+		case json.Delim:
+			out.WriteRune(rune(tok))
+			open = append(open, container{object: tok == '{'})
+		case json.Number:
+			out.WriteString(tok.String())
+		case string:
+			if isKey {
+				tok = rename(tok)
+			}
+			encoded, err := json.Marshal(tok)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(encoded)
+		default:
+			encoded, err := json.Marshal(tok)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(encoded)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/model"
+)
+
+// Keys under which middleware leaves the request logger and the caller's
+// token claims on the Echo context.
+const (
+	LoggerContextKey = "logger"
+	ClaimsContextKey = "auth_claims"
+)
+
+func RequestLogger(c echo.Context) *slog.Logger {
+	if logger, ok := c.Get(LoggerContextKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Claims returns the caller's token claims, or nil on routes without auth.
+func Claims(c echo.Context) *model.AuthClaims {
+	claims, _ := c.Get(ClaimsContextKey).(*model.AuthClaims)
+	return claims
+}
+
+type cacheBypassKey struct{}
+
+// WithCacheBypass marks ctx for services to read through their caches to the
+// source, without filling them.
+func WithCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
+}
+
+func CacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(cacheBypassKey{}).(bool)
+	return bypass
+}
+
+type requestIDKey struct{}
+
+// WithRequestID carries the ID of the request being served in ctx, for the
+// events and messages it leads to.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the ID carried by ctx, or "" outside a request.
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
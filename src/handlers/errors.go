@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/model"
+)
+
+// RespondServiceError writes an error returned by a service: a
+// *model.ServiceError as its status and message, anything else as a 500.
+func RespondServiceError(c echo.Context, err error) error {
+	var se *model.ServiceError
+	if !errors.As(err, &se) {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+	if se.Field != "" {
+		return ValidationFailed(c, se.Field, se.Message)
+	}
+	if len(se.Details) == 0 {
+		return c.JSON(se.Status, map[string]string{"error": se.Message})
+	}
+
+	body := make(map[string]interface{}, len(se.Details)+1)
+	for k, v := range se.Details {
+		body[k] = v
+	}
+	body["error"] = se.Message
+	return c.JSON(se.Status, body)
+}
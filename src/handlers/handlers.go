@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/model"
+)
+
+// Handlers serves the menu, order lifecycle and notification routes. It
+// holds no state of its own; everything goes through the services it is
+// built with, so it can be exercised with fakes.
+type Handlers struct {
+	menus         MenuService
+	orders        OrderService
+	notifications NotificationService
+}
+
+func New(menus MenuService, orders OrderService, notifications NotificationService) *Handlers {
+	return &Handlers{menus: menus, orders: orders, notifications: notifications}
+}
+
+// GetMenu serves GET /menu?restaurant_id=. Admins debugging the cache may add
+// cache=bypass to read the menu from its source. Items are named and
+// described in the language asked for by locale=, or else Accept-Language,
+// where the restaurant has translated them. The menu carries an ETag and is
+// not sent again to a client that already holds it; see caching.go.
+func (h *Handlers) GetMenu(c echo.Context) error {
+	restaurantID := c.QueryParam("restaurant_id")
+	if restaurantID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "restaurant_id is required"})
+	}
+	locales, ok := RequestLocales(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "locale is not a language tag"})
+	}
+
+	logger := RequestLogger(c).With("restaurant_id", restaurantID)
+	logger.Debug("view menu called")
+
+	ctx := c.Request().Context()
+	if c.QueryParam("cache") == "bypass" {
+		if claims := Claims(c); claims == nil || claims.Role != model.RoleAdmin {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Only admins may bypass the cache"})
+		}
+		logger.Info("menu cache bypassed")
+		ctx = WithCacheBypass(ctx)
+	}
+	ctx = WithLocales(ctx, locales)
+
+	menu, err := h.menus.Menu(ctx, logger, restaurantID)
+	if err != nil {
+		return RespondServiceError(c, err)
+	}
+
+	c.Response().Header().Add(echo.HeaderVary, "Accept-Language")
+	notModified, err := NotModified(c, menu)
+	if err != nil {
+		logger.Error("error tagging menu", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch menu"})
+	}
+	if notModified {
+		return nil
+	}
+
+	err = streamMenu(c, menu)
+	if err != nil {
+		logger.Error("error streaming menu", "error", err)
+	}
+	return nil
+}
+
+// streamMenu writes the menu item by item rather than encoding it in one go.
+func streamMenu(c echo.Context, menu model.RestaurantMenu) error {
+	prefix, err := JSONObjectPrefix("menu", "restaurant_id", menu.RestaurantID)
+	if err != nil {
+		return err
+	}
+
+	stream, err := StartJSONArrayStream(c, prefix)
+	if err != nil {
+		return err
+	}
+	for _, item := range menu.Menu {
+		err = stream.Write(item)
+		if err != nil {
+			return err
+		}
+	}
+	return stream.Close("]}")
+}
+
+// PlaceOrder serves POST /order.
+func (h *Handlers) PlaceOrder(c echo.Context) error {
+	var order model.Order
+	if err := BindAndValidate(c, &order); err != nil {
+		return RespondRequestError(c, err)
+	}
+
+	order, err := h.orders.PlaceOrder(c.Request().Context(), RequestLogger(c), Claims(c), order)
+	if err != nil {
+		return RespondServiceError(c, err)
+	}
+
+	TagOrderVersion(c, order.Version)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"order_id":       order.OrderID,
+		"order_code":     order.Code,
+		"status":         order.Status,
+		"payment_status": order.PaymentStatus,
+		"pricing":        order.Pricing,
+		"scheduled_at":   order.ScheduledAt,
+	})
+}
+
+// PlaceBulkOrders serves POST /orders/bulk. It answers 207 if some of the
+// orders could not be stored after all of them were accepted.
+func (h *Handlers) PlaceBulkOrders(c echo.Context) error {
+	var req model.BulkOrderRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondRequestError(c, bindError{err: err})
+	}
+	if req.Order != nil || len(req.Slots) > 0 {
+		switch {
+		case len(req.Orders) > 0:
+			return ValidationFailed(c, "orders", "cannot be given with order and slots")
+		case req.Order == nil:
+			return ValidationFailed(c, "order", "is required with slots")
+		case len(req.Slots) == 0:
+			return ValidationFailed(c, "slots", "is required with order")
+		}
+		req.Orders = req.SlotOrders()
+	}
+	if err := c.Validate(&req); err != nil {
+		return RespondRequestError(c, err)
+	}
+
+	results, err := h.orders.PlaceOrders(c.Request().Context(), RequestLogger(c), Claims(c), req.Orders)
+	if err != nil {
+		return RespondServiceError(c, err)
+	}
+
+	resp := model.BulkOrderResponse{Orders: results}
+	for _, result := range results {
+		if result.Status == model.BulkOrderCreated {
+			resp.Created++
+		}
+	}
+	status := http.StatusOK
+	if resp.Created < len(results) {
+		status = http.StatusMultiStatus
+	}
+	return c.JSON(status, resp)
+}
+
+// ModifyOrder serves PATCH /order/:id.
+func (h *Handlers) ModifyOrder(c echo.Context) error {
+	req := model.ModifyOrderRequest{OrderID: c.Param("id")}
+	if err := BindAndValidate(c, &req); err != nil {
+		return RespondRequestError(c, err)
+	}
+
+	order, err := h.orders.ModifyOrder(c.Request().Context(), RequestLogger(c), Claims(c), req)
+	if err != nil {
+		return RespondServiceError(c, err)
+	}
+
+	TagOrderVersion(c, order.Version)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"order_id":       order.OrderID,
+		"order_code":     order.Code,
+		"status":         order.Status,
+		"payment_status": order.PaymentStatus,
+		"items":          order.Items,
+		"pricing":        order.Pricing,
+	})
+}
+
+// AcceptOrder serves POST /restaurant/order/accept.
+func (h *Handlers) AcceptOrder(c echo.Context) error {
+	var req model.AcceptOrderRequest
+	if err := BindAndValidate(c, &req); err != nil {
+		return RespondRequestError(c, err)
+	}
+
+	order, err := h.orders.AcceptOrder(c.Request().Context(), RequestLogger(c), Claims(c), req)
+	if err != nil {
+		return RespondServiceError(c, err)
+	}
+
+	TagOrderVersion(c, order.Version)
+	resp := model.AcceptOrderResponse{Status: "accepted"}
+	if order.ReadyBy != nil {
+		resp.ReadyBy = *order.ReadyBy
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// RejectOrder serves POST /restaurant/order/reject.
+func (h *Handlers) RejectOrder(c echo.Context) error {
+	var req model.RejectOrderRequest
+	if err := BindAndValidate(c, &req); err != nil {
+		return RespondRequestError(c, err)
+	}
+
+	order, err := h.orders.RejectOrder(c.Request().Context(), RequestLogger(c), Claims(c), req)
+	if err != nil {
+		return RespondServiceError(c, err)
+	}
+
+	TagOrderVersion(c, order.Version)
+	return c.JSON(http.StatusOK, map[string]string{"status": "rejected"})
+}
+
+// ConfirmPickup serves POST /rider/order/pickup.
+func (h *Handlers) ConfirmPickup(c echo.Context) error {
+	var req model.PickupRequest
+	if err := BindAndValidate(c, &req); err != nil {
+		return RespondRequestError(c, err)
+	}
+
+	order, err := h.orders.ConfirmPickup(c.Request().Context(), RequestLogger(c), Claims(c), req)
+	if err != nil {
+		return RespondServiceError(c, err)
+	}
+
+	TagOrderVersion(c, order.Version)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":           "picked_up",
+		"delivery_options": order.DeliveryOptions,
+	})
+}
+
+// ConfirmDelivery serves POST /rider/order/deliver.
+func (h *Handlers) ConfirmDelivery(c echo.Context) error {
+	var req model.DeliverRequest
+	if err := BindAndValidate(c, &req); err != nil {
+		return RespondRequestError(c, err)
+	}
+
+	order, err := h.orders.ConfirmDelivery(c.Request().Context(), RequestLogger(c), Claims(c), req)
+	if err != nil {
+		return RespondServiceError(c, err)
+	}
+
+	TagOrderVersion(c, order.Version)
+	return c.JSON(http.StatusOK, map[string]string{"status": "Delivered"})
+}
+
+// SendNotification serves POST /notification/send. A notification that
+// reached no channel is reported as 502.
+func (h *Handlers) SendNotification(c echo.Context) error {
+	var req model.SendNotificationRequest
+	if err := BindAndValidate(c, &req); err != nil {
+		return RespondRequestError(c, err)
+	}
+
+	receipt, err := h.notifications.NotifyOrder(c.Request().Context(), RequestLogger(c), req)
+	if err != nil {
+		return RespondServiceError(c, err)
+	}
+
+	status := http.StatusOK
+	if receipt.Status == model.NotificationFailed {
+		status = http.StatusBadGateway
+	}
+	return c.JSON(status, receipt)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxLocales caps how many languages of an Accept-Language header are
+// considered.
+const maxLocales = 8
+
+// languageTagPattern is the shape of a BCP 47 language tag, such as "th",
+// "pt-BR" or "zh-Hant-TW", without checking the subtags are registered.
+var languageTagPattern = regexp.MustCompile(`^[A-Za-z]{2,8}(-[A-Za-z0-9]{1,8})*$`)
+
+// ValidLanguageTag reports whether tag is shaped like a language tag.
+func ValidLanguageTag(tag string) bool {
+	return languageTagPattern.MatchString(tag)
+}
+
+// RequestLocales returns the languages the caller wants, most wanted first:
+// the locale query parameter if given, otherwise the Accept-Language header
+// by quality. ok is false if the locale parameter is not a language tag.
+func RequestLocales(c echo.Context) (locales []string, ok bool) {
+	if locale := c.QueryParam("locale"); locale != "" {
+		if !ValidLanguageTag(locale) {
+			return nil, false
+		}
+		return []string{locale}, true
+	}
+	return parseAcceptLanguage(c.Request().Header.Get("Accept-Language")), true
+}
+
+// parseAcceptLanguage lists the tags in an Accept-Language header by
+// quality, keeping the header's order among equals. The wildcard, tags
+// refused with q=0 and anything malformed are left out.
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if !ValidLanguageTag(tag) {
+			continue
+		}
+		quality := 1.0
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag, quality})
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].quality > tags[j].quality })
+	locales := make([]string, 0, min(len(tags), maxLocales))
+	for _, tag := range tags[:min(len(tags), maxLocales)] {
+		locales = append(locales, tag.tag)
+	}
+	return locales
+}
+
+type localesKey struct{}
+
+// WithLocales carries the languages the caller wants, most wanted first, in
+// ctx for services that localize what they return.
+func WithLocales(ctx context.Context, locales []string) context.Context {
+	return context.WithValue(ctx, localesKey{}, locales)
+}
+
+// Locales returns the languages carried by ctx, or none.
+func Locales(ctx context.Context) []string {
+	locales, _ := ctx.Value(localesKey{}).([]string)
+	return locales
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+
+	"myproject/src/model"
+)
+
+// The services the handlers call. Each method takes the caller's claims
+// where it needs them and reports problems the caller can act on as
+// *model.ServiceError; anything else is an internal error.
+
+type MenuService interface {
+	// Menu returns a restaurant's menu with each item's live availability,
+	// in the languages asked for with WithLocales where it can.
+	Menu(ctx context.Context, logger *slog.Logger, restaurantID string) (model.RestaurantMenu, error)
+}
+
+type OrderService interface {
+	PlaceOrder(ctx context.Context, logger *slog.Logger, claims *model.AuthClaims, order model.Order) (model.Order, error)
+	// PlaceOrders places every one of orders, or none of them, returning
+	// each one's result in turn.
+	PlaceOrders(ctx context.Context, logger *slog.Logger, claims *model.AuthClaims, orders []model.Order) ([]model.BulkOrderResult, error)
+	// ModifyOrder changes the items of the customer's order while the
+	// restaurant has yet to accept it, and prices it again.
+	ModifyOrder(ctx context.Context, logger *slog.Logger, claims *model.AuthClaims, req model.ModifyOrderRequest) (model.Order, error)
+	AcceptOrder(ctx context.Context, logger *slog.Logger, claims *model.AuthClaims, req model.AcceptOrderRequest) (model.Order, error)
+	RejectOrder(ctx context.Context, logger *slog.Logger, claims *model.AuthClaims, req model.RejectOrderRequest) (model.Order, error)
+	ConfirmPickup(ctx context.Context, logger *slog.Logger, claims *model.AuthClaims, req model.PickupRequest) (model.Order, error)
+	ConfirmDelivery(ctx context.Context, logger *slog.Logger, claims *model.AuthClaims, req model.DeliverRequest) (model.Order, error)
+}
+
+type NotificationService interface {
+	// NotifyOrder sends a free-form update about an order to one of its
+	// parties.
+	NotifyOrder(ctx context.Context, logger *slog.Logger, req model.SendNotificationRequest) (model.NotificationReceipt, error)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// streamFlushEvery is how many array elements are written between flushes.
+const streamFlushEvery = 100
+
+// JSONArrayStream writes a JSON response whose bulk is one array, encoding
+// and flushing it element by element so the whole body is never held in
+// memory. The response is committed on the first write, so errors after
+// that can only be logged, not reported to the client.
+type JSONArrayStream struct {
+	resp    *echo.Response
+	enc     *json.Encoder
+	written int
+	// camel is set for callers served camelCase.
+	camel bool
+}
+
+// StartJSONArrayStream sends the headers and prefix, which must open the
+// array, e.g. `{"menu":[`. The prefix and elements are written in the
+// caller's casing.
+func StartJSONArrayStream(c echo.Context, prefix string) (*JSONArrayStream, error) {
+	opening, err := RecaseJSON(c, []byte(prefix))
+	if err != nil {
+		return nil, err
+	}
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+	resp.WriteHeader(http.StatusOK)
+
+	_, err = resp.Write(opening)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONArrayStream{resp: resp, enc: json.NewEncoder(resp), camel: Casing(c) == CasingCamel}, nil
+}
+
+func (s *JSONArrayStream) Write(v interface{}) error {
+	if s.written > 0 {
+		_, err := s.resp.Write([]byte(","))
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.camel {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		data, err = renameKeys(data, camelKey)
+		if err != nil {
+			return err
+		}
+		v = json.RawMessage(data)
+	}
+	err := s.enc.Encode(v)
+	if err != nil {
+		return err
+	}
+
+	s.written++
+	if s.written%streamFlushEvery == 0 {
+		s.resp.Flush()
+	}
+	return nil
+}
+
+// Close writes suffix, which must close the array and anything the prefix
+// opened, and flushes the remainder.
+func (s *JSONArrayStream) Close(suffix string) error {
+	_, err := s.resp.Write([]byte(suffix))
+	s.resp.Flush()
+	return err
+}
+
+// JSONObjectPrefix renders `{"key":value,...,"arrayField":[` for use as a
+// stream prefix.
+func JSONObjectPrefix(arrayField string, fields ...interface{}) (string, error) {
+	prefix := "{"
+	for i := 0; i+1 < len(fields); i += 2 {
+		key, err := json.Marshal(fields[i])
+		if err != nil {
+			return "", err
+		}
+		value, err := json.Marshal(fields[i+1])
+		if err != nil {
+			return "", err
+		}
+		prefix += string(key) + ":" + string(value) + ","
+	}
+
+	name, err := json.Marshal(arrayField)
+	if err != nil {
+		return "", err
+	}
+	return prefix + string(name) + ":[", nil
+}
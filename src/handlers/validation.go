@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+)
+
+type RequestValidator struct {
+	validate *validator.Validate
+}
+
+func NewRequestValidator() *RequestValidator {
+	v := validator.New()
+	// Report fields by their JSON names so clients can map errors to input.
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return &RequestValidator{validate: v}
+}
+
+func (rv *RequestValidator) Validate(i interface{}) error {
+	return rv.validate.Struct(i)
+}
+
+type bindError struct {
+	err error
+}
+
+func (e bindError) Error() string {
+	return e.err.Error()
+}
+
+// BindAndValidate binds the request into req and runs its struct tag
+// validation. Errors should be passed to RespondRequestError.
+func BindAndValidate(c echo.Context, req interface{}) error {
+	if err := c.Bind(req); err != nil {
+		return bindError{err: err}
+	}
+	return c.Validate(req)
+}
+
+// RespondRequestError writes 400 for malformed input, 413 for a body cut off
+// at the size limit and 422 with per-field messages for input that parsed
+// but failed validation.
+func RespondRequestError(c echo.Context, err error) error {
+	var be bindError
+	if errors.As(err, &be) {
+		var tooLarge *http.MaxBytesError
+		if errors.As(be.err, &tooLarge) {
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit)})
+		}
+		detail := be.err.Error()
+		var he *echo.HTTPError
+		if errors.As(be.err, &he) {
+			detail = fmt.Sprint(he.Message)
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request", "detail": detail})
+	}
+
+	var ve validator.ValidationErrors
+	if errors.As(err, &ve) {
+		fields := make(map[string]string, len(ve))
+		for _, fe := range ve {
+			fields[casedFieldPath(c, FieldPath(fe))] = ValidationMessage(fe)
+		}
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":  "Validation failed",
+			"fields": fields,
+		})
+	}
+
+	return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request", "detail": err.Error()})
+}
+
+// ValidationFailed reports a single semantic failure that struct tags cannot
+// express, in the same shape as tag validation errors.
+func ValidationFailed(c echo.Context, field, message string) error {
+	field = casedFieldPath(c, field)
+	return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+		"error":  "Validation failed",
+		"fields": map[string]string{field: message},
+	})
+}
+
+// FieldPath strips the top-level struct name from the namespace, turning
+// "Order.items[0].quantity" into "items[0].quantity".
+func FieldPath(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+	return ns
+}
+
+func ValidationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "required_unless", "required_without":
+		return "is required"
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "gte":
+		return "must be at least " + fe.Param()
+	case "lte":
+		return "must be at most " + fe.Param()
+	case "min":
+		if fe.Kind() == reflect.String {
+			return "must be at least " + fe.Param() + " characters"
+		}
+		if fe.Kind() == reflect.Slice {
+			return "must have at least " + fe.Param() + " entries"
+		}
+		return "must be at least " + fe.Param()
+	case "max":
+		if fe.Kind() == reflect.String {
+			return "must be at most " + fe.Param() + " characters"
+		}
+		if fe.Kind() == reflect.Slice {
+			return "must have at most " + fe.Param() + " entries"
+		}
+		return "must be at most " + fe.Param()
+	case "oneof":
+		return "must be one of: " + fe.Param()
+	case "uuid":
+		return "must be a valid UUID"
+	case "email":
+		return "must be a valid email address"
+	case "url":
+		return "must be a valid URL"
+	case "lowercase":
+		return "must be lowercase"
+	default:
+		return "failed " + fe.Tag() + " validation"
+	}
+}
@@ -0,0 +1,319 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+
+	"myproject/src/clock"
+)
+
+// Alerting. Every AlertCheckInterval the alerting component looks at the
+// share of requests that failed with a server error and at the order
+// consumer's lag. A measure over its threshold for AlertSustain raises an
+// incident: an ops-incident event, and a page through PagerDuty and
+// Opsgenie where they are configured. The incident is resolved, the same
+// way, once the measure is back under. Once raised, an alert is not raised
+// again by any instance for AlertCooldown, so one that flaps around its
+// threshold pages once.
+
+const (
+	alertErrorRate    = "error_rate"
+	alertConsumerLag  = "consumer_lag"
+	incidentFiring    = "firing"
+	incidentResolved  = "resolved"
+	opsIncidentTopic  = "ops-incident"
+	pagerDutyEventURL = "https://events.pagerduty.com/v2/enqueue"
+	opsgenieAlertURL  = "https://api.opsgenie.com/v2/alerts"
+)
+
+var opsIncidentWriter messageWriter
+
+// incidentClient posts to the paging services operators configure, which
+// unlike webhooks may be on the private network.
+var incidentClient = &http.Client{Timeout: 10 * time.Second}
+
+var opsIncidents = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ops_incidents_total",
+	Help: "Incidents raised, resolved and, within their cooldown, suppressed, by alert.",
+}, []string{"alert", "status"})
+
+func init() {
+	prometheus.MustRegister(opsIncidents)
+}
+
+// OpsIncident is the ops-incident event.
+type OpsIncident struct {
+	IncidentID string  `json:"incident_id"`
+	Alert      string  `json:"alert"`
+	Status     string  `json:"status"`
+	Summary    string  `json:"summary"`
+	Value      float64 `json:"value"`
+	Threshold  float64 `json:"threshold"`
+	// Since is when the measure crossed its threshold.
+	Since    Timestamp `json:"since"`
+	At       Timestamp `json:"at"`
+	Instance string    `json:"instance"`
+	Region   string    `json:"region,omitempty"`
+}
+
+// What the alerts measure, fed by the request logger and the consumer.
+var (
+	requestsServed  atomic.Int64
+	requestsFailed  atomic.Int64
+	consumerLagMu   sync.Mutex
+	consumerLagSeen = map[string]int64{}
+)
+
+// recordRequestOutcome counts a finished request towards the error rate.
+func recordRequestOutcome(status int) {
+	requestsServed.Add(1)
+	if status >= http.StatusInternalServerError {
+		requestsFailed.Add(1)
+	}
+}
+
+// recordConsumerLag notes the consumer's latest lag on a partition of topic.
+func recordConsumerLag(topic string, partition int, lag int64) {
+	consumerLagMu.Lock()
+	consumerLagSeen[topicPartitionName(topic, partition)] = lag
+	consumerLagMu.Unlock()
+}
+
+func maxConsumerLag() int64 {
+	consumerLagMu.Lock()
+	defer consumerLagMu.Unlock()
+	var lag int64
+	for _, partitionLag := range consumerLagSeen {
+		lag = max(lag, partitionLag)
+	}
+	return lag
+}
+
+// alertState tracks one alert between checks.
+type alertState struct {
+	name      string
+	threshold float64
+	// breachingSince is when the measure crossed the threshold; zero while it
+	// is under.
+	breachingSince time.Time
+	incident       *OpsIncident
+}
+
+// check feeds the latest measure to the alert and returns the incident to
+// announce, if it raises or resolves one.
+func (a *alertState) check(ctx context.Context, value float64, breaching bool, now time.Time) *OpsIncident {
+	if !breaching {
+		a.breachingSince = time.Time{}
+		if a.incident == nil {
+			return nil
+		}
+		resolved := *a.incident
+		resolved.Status, resolved.Value, resolved.At = incidentResolved, value, Timestamp{Time: now}
+		resolved.Summary = fmt.Sprintf("%s back under %v", a.name, a.threshold)
+		a.incident = nil
+		return &resolved
+	}
+
+	if a.breachingSince.IsZero() {
+		a.breachingSince = now
+	}
+	if a.incident != nil || now.Sub(a.breachingSince) < appConfig.AlertSustain {
+		return nil
+	}
+	if !takeAlertCooldown(ctx, a.name) {
+		opsIncidents.WithLabelValues(a.name, "suppressed").Inc()
+		return nil
+	}
+
+	id, err := idGenerator.NewID()
+	if err != nil {
+		id = fmt.Sprintf("%s-%d", a.name, now.UnixMilli())
+	}
+	a.incident = &OpsIncident{
+		IncidentID: id,
+		Alert:      a.name,
+		Status:     incidentFiring,
+		Summary:    fmt.Sprintf("%s at %v, over %v for %s", a.name, value, a.threshold, now.Sub(a.breachingSince).Round(time.Second)),
+		Value:      value,
+		Threshold:  a.threshold,
+		Since:      Timestamp{Time: a.breachingSince},
+		At:         Timestamp{Time: now},
+		Instance:   alertInstance(),
+		Region:     appConfig.Region,
+	}
+	return a.incident
+}
+
+func alertCooldownKey(alert string) string {
+	return "ops:alert:" + alert + ":cooldown"
+}
+
+// takeAlertCooldown reports whether the alert may be raised now, starting
+// its cooldown if so. The cooldown is in Redis so every instance shares it;
+// if Redis cannot say, the alert is raised: a duplicate page is better than
+// none.
+func takeAlertCooldown(ctx context.Context, alert string) bool {
+	if appConfig.AlertCooldown <= 0 {
+		return true
+	}
+	ok, err := redisClient.SetNX(ctx, alertCooldownKey(alert), 1, appConfig.AlertCooldown).Result()
+	if err != nil {
+		slog.Warn("error checking alert cooldown, raising anyway", "alert", alert, "error", err)
+		return true
+	}
+	return ok
+}
+
+func alertInstance() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}
+
+// runAlerting checks the alerts every interval until ctx is cancelled.
+func runAlerting(ctx context.Context, interval time.Duration) {
+	errorRate := &alertState{name: alertErrorRate, threshold: appConfig.AlertErrorRate}
+	consumerLag := &alertState{name: alertConsumerLag, threshold: float64(appConfig.AlertConsumerLag)}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := clock.Now()
+		served, failed := requestsServed.Swap(0), requestsFailed.Swap(0)
+		// Too few requests say nothing about the rate, either way: two
+		// failures out of three is not an outage, and a quiet minute is not a
+		// recovery.
+		if served > 0 && served >= int64(appConfig.AlertMinRequests) {
+			rate := float64(failed) / float64(served)
+			if incident := errorRate.check(ctx, rate, rate > appConfig.AlertErrorRate, now); incident != nil {
+				announceIncident(ctx, *incident)
+			}
+		}
+
+		lag := maxConsumerLag()
+		if incident := consumerLag.check(ctx, float64(lag), lag > appConfig.AlertConsumerLag, now); incident != nil {
+			announceIncident(ctx, *incident)
+		}
+	}
+}
+
+// announceIncident publishes the incident and pages for it. Each channel is
+// tried on its own; one being down does not keep the others from hearing.
+func announceIncident(ctx context.Context, incident OpsIncident) {
+	opsIncidents.WithLabelValues(incident.Alert, incident.Status).Inc()
+	logger := slog.With("incident_id", incident.IncidentID, "alert", incident.Alert, "status", incident.Status)
+	if incident.Status == incidentFiring {
+		logger.Error("incident raised", "summary", incident.Summary)
+	} else {
+		logger.Info("incident resolved", "summary", incident.Summary)
+	}
+
+	value, err := json.Marshal(incident)
+	if err != nil {
+		logger.Error("error encoding incident", "error", err)
+		return
+	}
+	err = publishMessage(ctx, opsIncidentWriter, "ops_incident", kafka.Message{Key: []byte(incident.Alert), Value: value})
+	if err != nil {
+		logger.Error("error publishing incident", "error", err)
+	}
+
+	if appConfig.PagerDutyRoutingKey != "" {
+		if _, err := retryWebhook(ctx, func() error { return pageViaPagerDuty(ctx, incident) }); err != nil {
+			logger.Error("error paging through pagerduty", "error", err)
+		}
+	}
+	if appConfig.OpsgenieAPIKey != "" {
+		if _, err := retryWebhook(ctx, func() error { return pageViaOpsgenie(ctx, incident) }); err != nil {
+			logger.Error("error paging through opsgenie", "error", err)
+		}
+	}
+}
+
+// pageViaPagerDuty triggers or resolves a PagerDuty alert through the Events
+// API v2. The alert name is the dedup key, so PagerDuty pairs the two.
+func pageViaPagerDuty(ctx context.Context, incident OpsIncident) error {
+	action := "trigger"
+	if incident.Status == incidentResolved {
+		action = "resolve"
+	}
+	return postIncident(ctx, appConfig.PagerDutyURL, nil, map[string]interface{}{
+		"routing_key":  appConfig.PagerDutyRoutingKey,
+		"event_action": action,
+		"dedup_key":    incident.Alert,
+		"payload": map[string]interface{}{
+			"summary":        incident.Summary,
+			"source":         incident.Instance,
+			"severity":       "critical",
+			"component":      incident.Alert,
+			"custom_details": incident,
+		},
+	})
+}
+
+// pageViaOpsgenie opens an Opsgenie alert, aliased by the alert name, or
+// closes it.
+func pageViaOpsgenie(ctx context.Context, incident OpsIncident) error {
+	headers := map[string]string{"Authorization": "GenieKey " + appConfig.OpsgenieAPIKey}
+	if incident.Status == incidentResolved {
+		return postIncident(ctx, appConfig.OpsgenieURL+"/"+incident.Alert+"/close?identifierType=alias", headers, map[string]interface{}{
+			"note": incident.Summary,
+		})
+	}
+	return postIncident(ctx, appConfig.OpsgenieURL, headers, map[string]interface{}{
+		"message":     incident.Summary,
+		"alias":       incident.Alert,
+		"description": fmt.Sprintf("%s on %s since %s", incident.Alert, incident.Instance, incident.Since.Format(time.RFC3339)),
+		"priority":    "P1",
+		"details":     map[string]string{"incident_id": incident.IncidentID, "region": incident.Region},
+	})
+}
+
+func postIncident(ctx context.Context, url string, headers map[string]string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return permanent(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return permanent(fmt.Errorf("failed to build incident request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := incidentClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("incident request failed: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("incident endpoint returned status %d", resp.StatusCode)
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return permanent(err)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+
+	"myproject/src/clock"
+)
+
+// Analytics events. What the order events do not tell, such as a menu being
+// viewed or a cart started, is published as an analytics event on a topic
+// of its own, beside the orders placed and deliveries completed, so the
+// business metrics can be consumed without reading, or holding up, the
+// order topics. Publishing goes through an async producer of its own and
+// never waits: an event that finds its buffer full is dropped and counted.
+//
+// The analytics aggregator reads the topic in a consumer group of its own,
+// wherever the order consumer runs, and folds each event into an hourly
+// bucket in Redis: counts by type, overall and per restaurant, and the
+// total delivery time. GET /admin/analytics?hours=N reads the buckets back.
+
+const (
+	analyticsTopic = "analytics-events"
+	analyticsGroup = "analytics-aggregator-group"
+
+	analyticsMenuViewed        = "menu_viewed"
+	analyticsCartStarted       = "cart_started"
+	analyticsOrderPlaced       = "order_placed"
+	analyticsDeliveryCompleted = "delivery_completed"
+
+	analyticsDeliverySecondsField = "delivery_seconds"
+
+	// analyticsHourRetention is how long an hourly bucket is kept after its
+	// last event, a little over maxAnalyticsHours.
+	analyticsHourRetention = 8 * 24 * time.Hour
+	maxAnalyticsHours      = 7 * 24
+)
+
+var errAnalyticsDuplicate = errors.New("analytics event already aggregated")
+
+var analyticsEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "analytics_events_total",
+	Help: "Analytics events partitioned by type and result (published, dropped, failed, aggregated, duplicate, invalid).",
+}, []string{"type", "result"})
+
+func init() {
+	prometheus.MustRegister(analyticsEvents)
+}
+
+var (
+	analyticsWriter   messageWriter
+	analyticsProducer *eventProducer
+)
+
+// AnalyticsEvent is one business event on the analytics topic.
+type AnalyticsEvent struct {
+	EventID      string `json:"event_id"`
+	Type         string `json:"type"`
+	RestaurantID string `json:"restaurant_id"`
+	OrderID      string `json:"order_id,omitempty"`
+	// DurationSeconds is, for delivery_completed, how long the order took
+	// from being placed to being delivered.
+	DurationSeconds float64   `json:"duration_seconds,omitempty"`
+	OccurredAt      Timestamp `json:"occurred_at"`
+}
+
+// emitAnalytics publishes event to w, giving it an ID and the time. Like
+// recordDailyStat, failures are logged rather than returned, and it returns
+// without waiting for the write, so analytics never hold up the request.
+func emitAnalytics(w messageWriter, event AnalyticsEvent) {
+	id, err := idGenerator.NewID()
+	if err != nil {
+		slog.Error("error generating analytics event id", "type", event.Type, "error", err)
+		return
+	}
+	event.EventID = id
+	event.OccurredAt = timestampNow()
+	value, _ := json.Marshal(event)
+
+	// The context is already done, so a full buffer drops the event instead
+	// of waiting for room.
+	noWait, cancel := context.WithCancel(context.Background())
+	cancel()
+	msg := kafka.Message{Key: []byte(event.RestaurantID), Value: value}
+	err = analyticsProducer.enqueue(noWait, w, event.Type, msg, func(err error) {
+		if err != nil {
+			analyticsEvents.WithLabelValues(event.Type, "failed").Inc()
+			slog.Warn("error publishing analytics event", "type", event.Type, "error", err)
+			return
+		}
+		analyticsEvents.WithLabelValues(event.Type, "published").Inc()
+	})
+	if err != nil {
+		analyticsEvents.WithLabelValues(event.Type, "dropped").Inc()
+	}
+}
+
+// newAnalyticsConsumer returns the analytics aggregator.
+func newAnalyticsConsumer() *Consumer {
+	return NewConsumer(regionTopic(analyticsGroup), []string{regionTopic(analyticsTopic)}, handleAnalyticsMessage)
+}
+
+// handleAnalyticsMessage aggregates one analytics event, retrying as order
+// events are. An event that still cannot be aggregated is dropped: the
+// metrics are for spotting trends, and the dead-letter queue is for order
+// events.
+func handleAnalyticsMessage(ctx context.Context, msg kafka.Message) {
+	var event AnalyticsEvent
+	err := json.Unmarshal(msg.Value, &event)
+	if err == nil && (event.Type == "" || event.EventID == "") {
+		err = errors.New("event has no type or id")
+	}
+	if err != nil {
+		analyticsEvents.WithLabelValues("unknown", "invalid").Inc()
+		slog.Warn("skipping invalid analytics event", "partition", msg.Partition, "offset", msg.Offset, "error", err)
+		return
+	}
+	logger := slog.With("event_id", event.EventID, "type", event.Type)
+
+	backoff := appConfig.ConsumerRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err = aggregateAnalyticsEvent(ctx, event)
+		if err == nil || err == errAnalyticsDuplicate {
+			break
+		}
+		if attempt == appConfig.ConsumerMaxAttempts {
+			analyticsEvents.WithLabelValues(event.Type, "failed").Inc()
+			logger.Error("dropping analytics event", "attempts", attempt, "error", err)
+			return
+		}
+
+		logger.Warn("retrying analytics event", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	if err == errAnalyticsDuplicate {
+		analyticsEvents.WithLabelValues(event.Type, "duplicate").Inc()
+		return
+	}
+	analyticsEvents.WithLabelValues(event.Type, "aggregated").Inc()
+}
+
+func analyticsHourKey(hour time.Time) string {
+	return "analytics:hour:" + hour.UTC().Format("2006-01-02T15")
+}
+
+func analyticsEventKey(eventID string) string {
+	return "analytics:event:" + eventID + ":aggregated"
+}
+
+// aggregateAnalyticsEvent adds event to the bucket for the hour it occurred
+// in. The event is remembered in the same transaction, so one redelivered
+// is counted once.
+func aggregateAnalyticsEvent(ctx context.Context, event AnalyticsEvent) error {
+	seenKey := analyticsEventKey(event.EventID)
+	hourKey := analyticsHourKey(event.OccurredAt.Time)
+	err := redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		n, err := tx.Exists(ctx, seenKey).Result()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return errAnalyticsDuplicate
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, seenKey, 1, appConfig.EventDedupTTL)
+			pipe.HIncrBy(ctx, hourKey, event.Type, 1)
+			if event.RestaurantID != "" {
+				pipe.HIncrBy(ctx, hourKey, event.Type+":"+event.RestaurantID, 1)
+			}
+			if event.Type == analyticsDeliveryCompleted {
+				pipe.HIncrByFloat(ctx, hourKey, analyticsDeliverySecondsField, event.DurationSeconds)
+				if event.RestaurantID != "" {
+					pipe.HIncrByFloat(ctx, hourKey, analyticsDeliverySecondsField+":"+event.RestaurantID, event.DurationSeconds)
+				}
+			}
+			pipe.Expire(ctx, hourKey, analyticsHourRetention)
+			return nil
+		})
+		return err
+	}, seenKey)
+	if err != nil && err != errAnalyticsDuplicate {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return err
+}
+
+// AnalyticsHour is the business metrics for one hour.
+type AnalyticsHour struct {
+	// Hour is when the hour started, in UTC.
+	Hour Timestamp `json:"hour"`
+	AnalyticsCounts
+	// Restaurants is the same, per restaurant with any events in the hour.
+	Restaurants map[string]AnalyticsCounts `json:"restaurants"`
+}
+
+type AnalyticsCounts struct {
+	MenuViews    int64 `json:"menu_views"`
+	CartsStarted int64 `json:"carts_started"`
+	OrdersPlaced int64 `json:"orders_placed"`
+	// Conversion is orders placed per cart started, 0 if none were started.
+	Conversion float64 `json:"conversion"`
+	Deliveries int64   `json:"deliveries"`
+	// AvgDeliveryMinutes is how long the deliveries took on average, from
+	// the order being placed.
+	AvgDeliveryMinutes float64 `json:"avg_delivery_minutes"`
+
+	deliverySeconds float64
+}
+
+// add counts field, a type or the delivery time, by value.
+func (a *AnalyticsCounts) add(field, value string) {
+	if field == analyticsDeliverySecondsField {
+		a.deliverySeconds, _ = strconv.ParseFloat(value, 64)
+		return
+	}
+	n, _ := strconv.ParseInt(value, 10, 64)
+	switch field {
+	case analyticsMenuViewed:
+		a.MenuViews = n
+	case analyticsCartStarted:
+		a.CartsStarted = n
+	case analyticsOrderPlaced:
+		a.OrdersPlaced = n
+	case analyticsDeliveryCompleted:
+		a.Deliveries = n
+	}
+}
+
+func (a *AnalyticsCounts) finish() {
+	if a.CartsStarted > 0 {
+		a.Conversion = math.Round(float64(a.OrdersPlaced)/float64(a.CartsStarted)*1000) / 1000
+	}
+	if a.Deliveries > 0 {
+		a.AvgDeliveryMinutes = math.Round(a.deliverySeconds/float64(a.Deliveries)/6) / 10
+	}
+}
+
+// getHourlyAnalytics serves GET /admin/analytics?hours=N: the business
+// metrics for each of the last N hours, newest first, the current one so
+// far included.
+func getHourlyAnalytics(c echo.Context) error {
+	hours, err := strconv.Atoi(c.QueryParam("hours"))
+	if err != nil || hours < 1 || hours > maxAnalyticsHours {
+		return validationFailed(c, "hours", fmt.Sprintf("must be between 1 and %d", maxAnalyticsHours))
+	}
+
+	reqCtx := c.Request().Context()
+	now := clock.Now().UTC().Truncate(time.Hour)
+	pipe := redisClient.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, hours)
+	starts := make([]time.Time, hours)
+	for i := range starts {
+		starts[i] = now.Add(-time.Duration(i) * time.Hour)
+		cmds[i] = pipe.HGetAll(reqCtx, analyticsHourKey(starts[i]))
+	}
+	_, err = pipe.Exec(reqCtx)
+	if err != nil {
+		requestLogger(c).Error("error fetching hourly analytics", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch analytics"})
+	}
+
+	result := make([]AnalyticsHour, hours)
+	for i, cmd := range cmds {
+		hour := AnalyticsHour{Hour: Timestamp{Time: starts[i]}, Restaurants: map[string]AnalyticsCounts{}}
+		for field, value := range cmd.Val() {
+			field, restaurantID, ok := strings.Cut(field, ":")
+			if !ok {
+				hour.add(field, value)
+				continue
+			}
+			counts := hour.Restaurants[restaurantID]
+			counts.add(field, value)
+			hour.Restaurants[restaurantID] = counts
+		}
+		hour.finish()
+		for restaurantID, counts := range hour.Restaurants {
+			counts.finish()
+			hour.Restaurants[restaurantID] = counts
+		}
+		result[i] = hour
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"hours": result})
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// API versioning. Every route is served under /v1 and /v2 as well as
+// unprefixed; unprefixed requests may ask for a version with the
+// API-Version header or a version parameter on Accept, e.g.
+// `Accept: application/json; version=2`, and get version 1 otherwise. A
+// prefix wins over either. The version is taken off the path before
+// routing, so each route is registered, and counted in metrics, once.
+//
+// Version 1 is the API as it has always been, and does not change. Version
+// 2 serves every route version 1 does, and richer typed bodies where a
+// handler has them; a version 2 body only adds fields to version 1's, so a
+// client moving up keeps working. Responses name their version in
+// API-Version.
+
+const (
+	apiVersion1 = 1
+	apiVersion2 = 2
+
+	latestAPIVersion = apiVersion2
+
+	apiVersionHeader     = "API-Version"
+	apiVersionContextKey = "api_version"
+)
+
+// apiVersioning is Pre middleware choosing the version the request is
+// served in.
+func apiVersioning(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		version, ok := pathAPIVersion(req)
+		if !ok {
+			var err error
+			version, err = requestedAPIVersion(req)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unsupported API version", "detail": err.Error()})
+			}
+		}
+
+		c.Set(apiVersionContextKey, version)
+		c.Response().Header().Set(apiVersionHeader, strconv.Itoa(version))
+		c.Response().Header().Add(echo.HeaderVary, apiVersionHeader)
+		return next(c)
+	}
+}
+
+// pathAPIVersion takes a /v1 or /v2 prefix off the request's path,
+// returning the version it named.
+func pathAPIVersion(req *http.Request) (int, bool) {
+	for version := apiVersion1; version <= latestAPIVersion; version++ {
+		prefix := "/v" + strconv.Itoa(version)
+		rest, ok := strings.CutPrefix(req.URL.Path, prefix)
+		if !ok || (rest != "" && rest[0] != '/') {
+			continue
+		}
+		req.URL.Path = rootIfEmpty(rest)
+		if req.URL.RawPath != "" {
+			req.URL.RawPath = rootIfEmpty(strings.TrimPrefix(req.URL.RawPath, prefix))
+		}
+		return version, true
+	}
+	return 0, false
+}
+
+func rootIfEmpty(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// requestedAPIVersion reads the version asked for by the API-Version
+// header, or else by the version parameter of the first media range on
+// Accept that has one; version 1 if neither names one.
+func requestedAPIVersion(req *http.Request) (int, error) {
+	value := req.Header.Get(apiVersionHeader)
+	if value == "" {
+		for _, mediaRange := range strings.Split(req.Header.Get(echo.HeaderAccept), ",") {
+			_, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err == nil && params["version"] != "" {
+				value = params["version"]
+				break
+			}
+		}
+	}
+	if value == "" {
+		return apiVersion1, nil
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(value), "v"))
+	if err != nil || version < apiVersion1 || version > latestAPIVersion {
+		return 0, fmt.Errorf("version %s is not served; use 1 to %d", value, latestAPIVersion)
+	}
+	return version, nil
+}
+
+// apiVersion returns the version the request is served in.
+func apiVersion(c echo.Context) int {
+	if version, ok := c.Get(apiVersionContextKey).(int); ok {
+		return version
+	}
+	return apiVersion1
+}
@@ -0,0 +1,405 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+	"myproject/src/rng"
+)
+
+// Restaurant partner API keys. An admin issues a restaurant's POS or
+// integration partner a key, which it sends as X-API-Key instead of a bearer
+// token. A key acts as the restaurant's staff, but only on the routes its
+// scopes cover: "menu" for the menu and "orders" for taking orders.
+//
+// Only a hash of each key is stored; the key itself is shown once, when it
+// is issued or rotated. A rotated key's old value keeps working for
+// APIKeyRotationGrace so the partner can switch over, and a revoked key stops
+// working at once. A key may carry its own rate limits, which then replace
+// the default ones for requests made with it; see ratelimit.go.
+
+const (
+	apiKeyScopeMenu   = "menu"
+	apiKeyScopeOrders = "orders"
+
+	apiKeyIndexKey = "apikeys"
+	// apiKeyPrefix starts every key, so one pasted somewhere it should not
+	// be is recognisable.
+	apiKeyPrefix = "rk_"
+
+	apiKeyContextKey = "api_key"
+)
+
+var (
+	errAPIKeyNotFound = errors.New("api key not found")
+	errAPIKeyInvalid  = errors.New("invalid api key")
+)
+
+// apiKeyRoutes is the scope each route a key may call needs. Routes missing
+// from it are refused to keys whatever their scopes.
+var apiKeyRoutes = map[string]string{
+	"POST /restaurant/order/accept":              apiKeyScopeOrders,
+	"POST /restaurant/order/reject":              apiKeyScopeOrders,
+	"GET /restaurant/order/:id/package-note":     apiKeyScopeOrders,
+	"GET /order/code/:code":                      apiKeyScopeOrders,
+	"GET /order/:id/eta":                         apiKeyScopeOrders,
+	"GET /order/:id/events":                      apiKeyScopeOrders,
+	"GET /dashboard/restaurants/:id":             apiKeyScopeOrders,
+	"PATCH /menu/item/:id/availability":          apiKeyScopeMenu,
+	"PUT /menu/item/:id/price":                   apiKeyScopeMenu,
+	"PUT /menu/item/:id/pairings":                apiKeyScopeMenu,
+	"POST /menu/item/:id/image":                  apiKeyScopeMenu,
+	"GET /restaurant/:id/price-changes":          apiKeyScopeMenu,
+	"POST /restaurant/:id/menu/publish":          apiKeyScopeMenu,
+	"GET /restaurant/:id/menu/compliance":        apiKeyScopeMenu,
+	"GET /restaurant/:id/menu/suggestions/stats": apiKeyScopeMenu,
+}
+
+// APIKey is a restaurant's API key, without the key itself. Zero rate limits
+// leave the key on the default ones.
+type APIKey struct {
+	ID           string `json:"id"`
+	RestaurantID string `json:"restaurant_id"`
+	Name         string `json:"name"`
+	// Prefix is the start of the key, to tell keys apart by.
+	Prefix             string     `json:"prefix"`
+	Scopes             []string   `json:"scopes"`
+	RateLimitPerMinute int        `json:"rate_limit_per_minute,omitempty"`
+	RateLimitBurst     int        `json:"rate_limit_burst,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	RotatedAt          *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt          *time.Time `json:"revoked_at,omitempty"`
+}
+
+// IssuedAPIKey is a key as returned when it is issued or rotated, the only
+// times Key is shown.
+type IssuedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type APIKeyRequest struct {
+	RestaurantID       string   `json:"restaurant_id" validate:"required"`
+	Name               string   `json:"name" validate:"required,max=100"`
+	Scopes             []string `json:"scopes" validate:"omitempty,dive,oneof=menu orders"`
+	RateLimitPerMinute int      `json:"rate_limit_per_minute" validate:"gte=0"`
+	RateLimitBurst     int      `json:"rate_limit_burst" validate:"gte=0"`
+}
+
+func apiKeyKey(keyID string) string {
+	return "apikey:" + keyID
+}
+
+// apiKeySecretKey maps a key's hash to its ID.
+func apiKeySecretKey(hash string) string {
+	return "apikey:secret:" + hash
+}
+
+// apiKeySecretsKey holds the hashes of the key's current value.
+func apiKeySecretsKey(keyID string) string {
+	return "apikey:" + keyID + ":secrets"
+}
+
+func restaurantAPIKeysKey(restaurantID string) string {
+	return "restaurant:" + restaurantID + ":apikeys"
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func newAPIKeySecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rng.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(secret), nil
+}
+
+// allows reports whether the key's scopes cover the route c was matched to.
+func (k APIKey) allows(c echo.Context) bool {
+	scope, ok := apiKeyRoutes[c.Request().Method+" "+c.Path()]
+	return ok && slices.Contains(k.Scopes, scope)
+}
+
+// claims are what requests made with the key act as.
+func (k APIKey) claims() *AuthClaims {
+	claims := &AuthClaims{Role: roleRestaurant, RestaurantID: k.RestaurantID}
+	claims.Subject = "apikey:" + k.ID
+	return claims
+}
+
+func getAPIKey(ctx context.Context, keyID string) (APIKey, error) {
+	data, err := redisClient.Get(ctx, apiKeyKey(keyID)).Result()
+	if err == redis.Nil {
+		return APIKey{}, errAPIKeyNotFound
+	} else if err != nil {
+		return APIKey{}, fmt.Errorf("redis error: %v", err)
+	}
+	var key APIKey
+	if err := json.Unmarshal([]byte(data), &key); err != nil {
+		return APIKey{}, fmt.Errorf("malformed api key %s: %v", keyID, err)
+	}
+	return key, nil
+}
+
+// lookupAPIKey returns the unrevoked key whose value is secret, or
+// errAPIKeyInvalid.
+func lookupAPIKey(ctx context.Context, secret string) (APIKey, error) {
+	keyID, err := redisClient.Get(ctx, apiKeySecretKey(hashAPIKey(secret))).Result()
+	if err == redis.Nil {
+		return APIKey{}, errAPIKeyInvalid
+	} else if err != nil {
+		return APIKey{}, fmt.Errorf("redis error: %v", err)
+	}
+	key, err := getAPIKey(ctx, keyID)
+	if err == errAPIKeyNotFound || (err == nil && key.RevokedAt != nil) {
+		return APIKey{}, errAPIKeyInvalid
+	}
+	return key, err
+}
+
+// requestAPIKey returns the key the request carries, looking it up once per
+// request. It returns nil, and no error, for a request without one.
+func requestAPIKey(c echo.Context) (*APIKey, error) {
+	ctx := c.Request().Context()
+
+	if key, ok := c.Get(apiKeyContextKey).(*APIKey); ok {
+		return key, nil
+	}
+	secret := c.Request().Header.Get(apiKeyHeader)
+	if secret == "" {
+		return nil, nil
+	}
+	key, err := lookupAPIKey(ctx, secret)
+	if err != nil {
+		return nil, err
+	}
+	c.Set(apiKeyContextKey, &key)
+	return &key, nil
+}
+
+// authenticateAPIKey authenticates a request without a bearer token by its
+// API key, refusing it if the key does not cover the route. It reports false
+// once it has responded.
+func authenticateAPIKey(c echo.Context) (*AuthClaims, bool, error) {
+	key, err := requestAPIKey(c)
+	if err == errAPIKeyInvalid || (err == nil && key == nil) {
+		requestLogger(c).Info("rejected unauthenticated request", "error", errAPIKeyInvalid)
+		return nil, false, c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+	} else if err != nil {
+		requestLogger(c).Error("error checking api key", "error", err)
+		return nil, false, c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check API key"})
+	}
+	if !key.allows(c) {
+		return nil, false, c.JSON(http.StatusForbidden, map[string]string{"error": "API key does not cover this route"})
+	}
+	return key.claims(), true, nil
+}
+
+// saveAPIKey stores the key and, if secret is not empty, makes secret its
+// only value, leaving earlier values working for grace.
+func saveAPIKey(ctx context.Context, key APIKey, secret string, grace time.Duration) error {
+	keyJSON, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode api key: %v", err)
+	}
+
+	var previous []string
+	if secret != "" {
+		previous, err = redisClient.SMembers(ctx, apiKeySecretsKey(key.ID)).Result()
+		if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+	}
+
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, apiKeyKey(key.ID), keyJSON, 0)
+	pipe.ZAdd(ctx, apiKeyIndexKey, &redis.Z{Score: float64(key.CreatedAt.UnixMilli()), Member: key.ID})
+	pipe.SAdd(ctx, restaurantAPIKeysKey(key.RestaurantID), key.ID)
+	if secret != "" {
+		for _, hash := range previous {
+			if grace > 0 {
+				pipe.Expire(ctx, apiKeySecretKey(hash), grace)
+			} else {
+				pipe.Del(ctx, apiKeySecretKey(hash))
+			}
+			pipe.SRem(ctx, apiKeySecretsKey(key.ID), hash)
+		}
+		hash := hashAPIKey(secret)
+		pipe.Set(ctx, apiKeySecretKey(hash), key.ID, 0)
+		pipe.SAdd(ctx, apiKeySecretsKey(key.ID), hash)
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// createAPIKey serves POST /admin/apikeys, issuing a key for a restaurant.
+// Without scopes the key covers both the menu and orders.
+func createAPIKey(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req APIKeyRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+	if (req.RateLimitPerMinute == 0) != (req.RateLimitBurst == 0) {
+		return validationFailed(c, "rate_limit_burst", "rate_limit_per_minute and rate_limit_burst must be set together")
+	}
+	if _, err := findRestaurant(ctx, req.RestaurantID); err == errRestaurantNotFound {
+		return validationFailed(c, "restaurant_id", "unknown restaurant")
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant"})
+	}
+
+	scopes := []string{apiKeyScopeMenu, apiKeyScopeOrders}
+	if len(req.Scopes) > 0 {
+		scopes = slices.Compact(slices.Sorted(slices.Values(req.Scopes)))
+	}
+
+	id, err := idGenerator.NewID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create API key"})
+	}
+	secret, err := newAPIKeySecret()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create API key"})
+	}
+	key := APIKey{
+		ID:                 id,
+		RestaurantID:       req.RestaurantID,
+		Name:               req.Name,
+		Prefix:             secret[:len(apiKeyPrefix)+8],
+		Scopes:             scopes,
+		RateLimitPerMinute: req.RateLimitPerMinute,
+		RateLimitBurst:     req.RateLimitBurst,
+		CreatedAt:          clock.Now().UTC(),
+	}
+	err = saveAPIKey(ctx, key, secret, 0)
+	if err != nil {
+		requestLogger(c).Error("error storing api key", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save API key"})
+	}
+
+	requestLogger(c).Info("api key issued", "key_id", key.ID, "restaurant_id", key.RestaurantID, "scopes", key.Scopes, "admin", authClaims(c).Subject)
+	return c.JSON(http.StatusCreated, IssuedAPIKey{APIKey: key, Key: secret})
+}
+
+// listAPIKeys serves GET /admin/apikeys, every key oldest first, or a
+// restaurant's with ?restaurant_id=.
+func listAPIKeys(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var ids []string
+	var err error
+	if restaurantID := c.QueryParam("restaurant_id"); restaurantID != "" {
+		ids, err = redisClient.SMembers(ctx, restaurantAPIKeysKey(restaurantID)).Result()
+	} else {
+		ids, err = redisClient.ZRange(ctx, apiKeyIndexKey, 0, -1).Result()
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch API keys"})
+	}
+
+	keys := make([]APIKey, 0, len(ids))
+	for _, id := range ids {
+		key, err := getAPIKey(ctx, id)
+		if err == errAPIKeyNotFound {
+			continue
+		} else if err != nil {
+			requestLogger(c).Error("error reading api key", "key_id", id, "error", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch API keys"})
+		}
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b APIKey) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return c.JSON(http.StatusOK, map[string]interface{}{"api_keys": keys})
+}
+
+// rotateAPIKey serves POST /admin/apikeys/:id/rotate, giving the key a new
+// value. The old one works for APIKeyRotationGrace more.
+func rotateAPIKey(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	key, err := getAPIKey(ctx, c.Param("id"))
+	if err == errAPIKeyNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "API key not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch API key"})
+	}
+	if key.RevokedAt != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "API key has been revoked"})
+	}
+
+	secret, err := newAPIKeySecret()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to rotate API key"})
+	}
+	now := clock.Now().UTC()
+	key.Prefix = secret[:len(apiKeyPrefix)+8]
+	key.RotatedAt = &now
+	err = saveAPIKey(ctx, key, secret, appConfig.APIKeyRotationGrace)
+	if err != nil {
+		requestLogger(c).Error("error storing rotated api key", "key_id", key.ID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to rotate API key"})
+	}
+
+	requestLogger(c).Info("api key rotated", "key_id", key.ID, "restaurant_id", key.RestaurantID, "grace", appConfig.APIKeyRotationGrace, "admin", authClaims(c).Subject)
+	return c.JSON(http.StatusOK, IssuedAPIKey{APIKey: key, Key: secret})
+}
+
+// revokeAPIKey serves POST /admin/apikeys/:id/revoke. The key stops working
+// at once, including any old value still in its rotation grace period, and
+// stays listed as revoked.
+func revokeAPIKey(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	key, err := getAPIKey(ctx, c.Param("id"))
+	if err == errAPIKeyNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "API key not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch API key"})
+	}
+	if key.RevokedAt != nil {
+		return c.JSON(http.StatusOK, key)
+	}
+
+	hashes, err := redisClient.SMembers(ctx, apiKeySecretsKey(key.ID)).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke API key"})
+	}
+	now := clock.Now().UTC()
+	key.RevokedAt = &now
+	keyJSON, _ := json.Marshal(key)
+
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, apiKeyKey(key.ID), keyJSON, 0)
+	for _, hash := range hashes {
+		pipe.Del(ctx, apiKeySecretKey(hash))
+	}
+	pipe.Del(ctx, apiKeySecretsKey(key.ID))
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		requestLogger(c).Error("error revoking api key", "key_id", key.ID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke API key"})
+	}
+
+	requestLogger(c).Info("api key revoked", "key_id", key.ID, "restaurant_id", key.RestaurantID, "admin", authClaims(c).Subject)
+	return c.JSON(http.StatusOK, key)
+}
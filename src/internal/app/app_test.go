@@ -0,0 +1,260 @@
+package app
+
+// What the tests share, whichever backends they run on: the test server and
+// how to call it, the order lifecycle test and the order path benchmarks. TestMain, which starts the
+// server, is in memory_test.go, or in integration_test.go with
+// -tags=integration.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	testJWTSecret = "test-secret"
+
+	// testRestaurantID is open all day, so the tests pass at any hour.
+	testRestaurantID = "2"
+	testRiderID      = "rider-1"
+
+	testEventTimeout = 30 * time.Second
+)
+
+var testServer *httptest.Server
+
+func testToken(t testing.TB, claims AuthClaims) string {
+	t.Helper()
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return token
+}
+
+// call sends body as JSON to the test server and decodes the response into
+// out, failing the test unless the status is want.
+func call(t testing.TB, method, path, token string, body, out interface{}, want int) {
+	t.Helper()
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("encoding %s %s body: %v", method, path, err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, testServer.URL+path, reqBody)
+	if err != nil {
+		t.Fatalf("building %s %s: %v", method, path, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != want {
+		t.Fatalf("%s %s: status %d, want %d: %s", method, path, resp.StatusCode, want, data)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("decoding %s %s response: %v: %s", method, path, err, data)
+		}
+	}
+}
+
+// publishedEventTypes reads every order event topic from the start until it
+// has seen want events for orderID, and returns their types in the order
+// they occurred.
+func publishedEventTypes(t *testing.T, orderID string, want int) []string {
+	t.Helper()
+
+	deadline := time.Now().Add(testEventTimeout)
+	var events []OrderEvent
+	for len(events) < want && time.Now().Before(deadline) {
+		events = events[:0]
+		for _, topic := range orderEventRouter.topics() {
+			events = append(events, topicEvents(t, topic, orderID)...)
+		}
+	}
+	if len(events) < want {
+		t.Fatalf("saw %d events for order %s, want %d", len(events), orderID, want)
+	}
+
+	// Timestamps are to the millisecond; events within one are taken in
+	// lifecycle order.
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].OccurredAt.Equal(events[j].OccurredAt.Time) {
+			return events[i].OccurredAt.Before(events[j].OccurredAt.Time)
+		}
+		return slices.Index(orderEventTypes, events[i].Type) < slices.Index(orderEventTypes, events[j].Type)
+	})
+	types := make([]string, len(events))
+	for i, event := range events {
+		types[i] = event.Type
+	}
+	return types
+}
+
+// topicEvents reads topic from the start and returns the events keyed
+// orderID, stopping once the topic has been quiet for a second.
+func topicEvents(t *testing.T, topic, orderID string) []OrderEvent {
+	t.Helper()
+
+	r := bus.PartitionReader(topic, 0)
+	defer r.Close()
+	if err := r.SetOffset(kafka.FirstOffset); err != nil {
+		t.Fatalf("seeking %s topic: %v", topic, err)
+	}
+
+	var events []OrderEvent
+	for {
+		readCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		msg, err := r.ReadMessage(readCtx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("reading %s topic after %d events: %v", topic, len(events), err)
+		}
+		if string(msg.Key) != orderID {
+			continue
+		}
+
+		event, err := decodeOrderEvent(msg.Value)
+		if err != nil {
+			t.Fatalf("decoding order event: %v", err)
+		}
+		if event.OrderID != orderID {
+			t.Errorf("%s event keyed %s is for order %s", event.Type, orderID, event.OrderID)
+		}
+		events = append(events, event)
+	}
+}
+
+func TestOrderLifecycle(t *testing.T) {
+	requireBackends(t)
+	customer := testToken(t, AuthClaims{Role: roleCustomer, RegisteredClaims: jwt.RegisteredClaims{Subject: "customer-1"}})
+	restaurant := testToken(t, AuthClaims{Role: roleRestaurant, RestaurantID: testRestaurantID})
+	rider := testToken(t, AuthClaims{Role: roleRider, RiderID: testRiderID})
+
+	var placed struct {
+		OrderID string `json:"order_id"`
+	}
+	call(t, http.MethodPost, "/order", customer, map[string]interface{}{
+		"restaurant_id": testRestaurantID,
+		"items":         []map[string]interface{}{{"menu_id": "1", "quantity": 2}},
+	}, &placed, http.StatusOK)
+	if placed.OrderID == "" {
+		t.Fatal("placed order has no order_id")
+	}
+	orderID := placed.OrderID
+
+	call(t, http.MethodPost, "/order/pay", customer, PayOrderRequest{OrderID: orderID, PaymentToken: "tok_visa"}, nil, http.StatusOK)
+	call(t, http.MethodPost, "/restaurant/order/accept", restaurant, AcceptOrderRequest{OrderID: orderID, RestaurantID: testRestaurantID, PrepMinutes: 5}, nil, http.StatusOK)
+
+	accepted, err := getOrder(context.Background(), orderID)
+	if err != nil {
+		t.Fatalf("fetching accepted order: %v", err)
+	}
+	pickup := PickupRequest{OrderID: orderID, RiderID: testRiderID, OrderCode: accepted.Code}
+	if checklist := accepted.PickupChecklist; checklist != nil {
+		pickup.Checklist = &ChecklistConfirmation{Bags: checklist.Bags, Drinks: checklist.Drinks, Utensils: checklist.Utensils}
+	}
+	call(t, http.MethodPost, "/rider/order/pickup", rider, pickup, nil, http.StatusOK)
+	call(t, http.MethodPost, "/rider/order/deliver", rider, DeliverRequest{OrderID: orderID, RiderID: testRiderID, SignatureHash: "customer-signature"}, nil, http.StatusOK)
+
+	t.Run("kafka events", func(t *testing.T) {
+		want := []string{eventOrderCreated, eventOrderPaid, eventOrderAccepted, eventOrderPickedUp, eventOrderDelivered}
+		got := publishedEventTypes(t, orderID, len(want))
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("events = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("redis state", func(t *testing.T) {
+		order, err := getOrder(context.Background(), orderID)
+		if err != nil {
+			t.Fatalf("fetching delivered order: %v", err)
+		}
+		if order.Status != "delivered" {
+			t.Errorf("status = %q, want delivered", order.Status)
+		}
+		if order.PaymentStatus != paymentPaid {
+			t.Errorf("payment status = %q, want %q", order.PaymentStatus, paymentPaid)
+		}
+		if order.RiderID != testRiderID {
+			t.Errorf("rider = %q, want %q", order.RiderID, testRiderID)
+		}
+		for _, event := range []string{timelineCreated, timelinePaid, "accepted", "picked_up", "delivered"} {
+			if !order.HasTimelineEvent(event) {
+				t.Errorf("timeline %v has no %q", order.Timeline, event)
+			}
+		}
+
+		// Every event was relayed, so nothing is left waiting in the outbox.
+		pending, err := repositories.Orders.PendingEvents(context.Background(), outboxBatch)
+		if err != nil {
+			t.Fatalf("reading outbox: %v", err)
+		}
+		if len(pending) != 0 {
+			t.Errorf("outbox holds %d events, want 0", len(pending))
+		}
+	})
+}
+
+// benchmarkOrder is the order the benchmarks place.
+var benchmarkOrder = map[string]interface{}{
+	"restaurant_id": testRestaurantID,
+	"items":         []map[string]interface{}{{"menu_id": "1", "quantity": 2}},
+}
+
+func BenchmarkPlaceOrder(b *testing.B) {
+	requireBackends(b)
+	customer := testToken(b, AuthClaims{Role: roleCustomer, RegisteredClaims: jwt.RegisteredClaims{Subject: "bench-customer"}})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		call(b, http.MethodPost, "/order", customer, benchmarkOrder, nil, http.StatusOK)
+	}
+}
+
+func BenchmarkPayOrder(b *testing.B) {
+	requireBackends(b)
+	customer := testToken(b, AuthClaims{Role: roleCustomer, RegisteredClaims: jwt.RegisteredClaims{Subject: "bench-customer"}})
+
+	orderIDs := make([]string, b.N)
+	for i := range orderIDs {
+		var placed struct {
+			OrderID string `json:"order_id"`
+		}
+		call(b, http.MethodPost, "/order", customer, benchmarkOrder, &placed, http.StatusOK)
+		orderIDs[i] = placed.OrderID
+	}
+
+	b.ResetTimer()
+	for _, orderID := range orderIDs {
+		call(b, http.MethodPost, "/order/pay", customer, PayOrderRequest{OrderID: orderID, PaymentToken: "tok_visa"}, nil, http.StatusOK)
+	}
+}
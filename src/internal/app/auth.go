@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/handlers"
+	"myproject/src/model"
+)
+
+const (
+	roleCustomer   = model.RoleCustomer
+	roleRestaurant = model.RoleRestaurant
+	roleRider      = model.RoleRider
+	roleAdmin      = model.RoleAdmin
+	roleOwner      = model.RoleOwner
+	rolePartner    = model.RolePartner
+)
+
+// requireRole authenticates the bearer token, or without one the API key, and
+// rejects callers whose role is not in roles. On signedRoutes it then checks
+// the request's signature.
+func requireRole(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Request().Header.Get(echo.HeaderAuthorization)
+			var claims *AuthClaims
+			if header == "" && c.Request().Header.Get(apiKeyHeader) != "" {
+				keyClaims, ok, err := authenticateAPIKey(c)
+				if !ok {
+					return err
+				}
+				claims = keyClaims
+			} else {
+				var err error
+				claims, err = parseBearerToken(header)
+				if err != nil {
+					requestLogger(c).Info("rejected unauthenticated request", "error", err)
+					return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+				}
+			}
+
+			allowed := false
+			for _, role := range roles {
+				if claims.Role == role {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "Forbidden"})
+			}
+
+			c.Set(handlers.ClaimsContextKey, claims)
+			if signedRoutes[c.Request().Method+" "+c.Path()] {
+				return signedRequest(next)(c)
+			}
+			return next(c)
+		}
+	}
+}
+
+// optionalAuth leaves the caller's claims on the context for routes open to
+// everyone that do more for some roles. A missing or invalid token is served
+// as anonymous.
+func optionalAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		header := c.Request().Header.Get(echo.HeaderAuthorization)
+		if header != "" {
+			if claims, err := parseBearerToken(header); err == nil {
+				c.Set(handlers.ClaimsContextKey, claims)
+			}
+		}
+		return next(c)
+	}
+}
+
+var authClaims = handlers.Claims
+
+func parseBearerToken(header string) (*AuthClaims, error) {
+	tokenString, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || tokenString == "" {
+		return nil, errors.New("missing bearer token")
+	}
+
+	claims := &AuthClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(appConfig.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	return claims, nil
+}
+
+// actsForRestaurant reports whether the caller's token is bound to
+// restaurantID, directly or through its brand.
+func actsForRestaurant(c echo.Context, restaurantID string) bool {
+	return staffFor(c.Request().Context(), authClaims(c), roleRestaurant, restaurantID)
+}
+
+// ownsRestaurant reports whether the caller holds an owner token for
+// restaurantID or for its brand.
+func ownsRestaurant(c echo.Context, restaurantID string) bool {
+	return staffFor(c.Request().Context(), authClaims(c), roleOwner, restaurantID)
+}
+
+// staffFor reports whether claims of the given role are bound to
+// restaurantID, or to the brand it belongs to. A token bound to a
+// restaurant is not also a brand token.
+func staffFor(ctx context.Context, claims *AuthClaims, role, restaurantID string) bool {
+	if claims == nil || claims.Role != role {
+		return false
+	}
+	if claims.RestaurantID != "" || claims.BrandID == "" {
+		return claims.RestaurantID == restaurantID
+	}
+
+	brandID, err := restaurantBrand(ctx, restaurantID)
+	if err != nil {
+		slog.Warn("error checking restaurant brand", "restaurant_id", restaurantID, "error", err)
+		return false
+	}
+	return brandID == claims.BrandID
+}
+
+// actsForRider reports whether the caller's token is bound to riderID.
+func actsForRider(c echo.Context, riderID string) bool {
+	return authClaims(c).ActsForRider(riderID)
+}
@@ -0,0 +1,340 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/segmentio/kafka-go"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+)
+
+// Backfill. `api backfill -projection NAME` rebuilds a projection from the
+// order event topics in a process of its own, reporting its progress and
+// reading no faster than -rate messages a second, rather than leaving the
+// runners in every api to replay the topics at full speed. It starts a new
+// generation marked as backfilling, which the runners leave alone, replays
+// every partition into it from the earliest retained offset, or from the
+// first message at or after -from, and clears the mark once each partition
+// has caught up. The runners carry on from the checkpoints it leaves, so a
+// backfill stopped early is finished by them.
+//
+// The restaurant search index is built from the restaurant list rather than
+// the events, so backfilling search rebuilds it from there.
+
+const backfillSearch = "search"
+
+// RunBackfill rebuilds the projection named in args and exits once it has
+// caught up, or been interrupted.
+func RunBackfill(args []string) {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	name := flags.String("projection", "", "projection to rebuild: dashboard, deliveries, revenue, analytics or search")
+	from := flags.String("from", "", "replay messages from this RFC 3339 time on rather than from the earliest retained")
+	perSecond := flags.Float64("rate", 500, "most messages to read from Kafka a second, 0 for no limit")
+	every := flags.Duration("progress", 10*time.Second, "how often to report progress")
+	flags.Parse(args)
+
+	p, isProjection := findProjection(*name)
+	var start time.Time
+	var err error
+	switch {
+	case !isProjection && *name != backfillSearch:
+		err = fmt.Errorf("unknown projection %q", *name)
+	case *name == backfillSearch && *from != "":
+		err = errors.New("-from does not apply to search, which is rebuilt from the restaurant list")
+	case *perSecond < 0:
+		err = errors.New("-rate must not be negative")
+	case *every <= 0:
+		err = errors.New("-progress must be positive")
+	case *from != "":
+		start, err = time.Parse(time.RFC3339, *from)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "backfill:", err)
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	release := setup("backfill", nil)
+	defer release()
+	if appConfig.Backend == backendMemory {
+		slog.Error("backfill needs the kafka backend; the in-memory bus and redis are only seen by the api")
+		os.Exit(1)
+	}
+
+	appCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *name == backfillSearch {
+		err = backfillSearchIndex(appCtx)
+	} else {
+		limit := rate.Inf
+		if *perSecond > 0 {
+			limit = rate.Limit(*perSecond)
+		}
+		limiter := rate.NewLimiter(limit, max(int(*perSecond), 1))
+		err = backfillProjection(appCtx, p, start, limiter, *every)
+	}
+	if err != nil {
+		slog.Error("backfill failed", "projection", *name, "error", err)
+		os.Exit(1)
+	}
+}
+
+// backfillSearchIndex rebuilds the restaurant search index from the
+// restaurant list, reloaded from the store.
+func backfillSearchIndex(ctx context.Context) error {
+	err := invalidateRestaurants(ctx)
+	if err != nil {
+		return err
+	}
+	err = ensureRestaurantIndex(ctx)
+	if err != nil {
+		return err
+	}
+	slog.Info("restaurant search index rebuilt")
+	return nil
+}
+
+// backfillProjection replays every partition of the order event topics into
+// a new generation of p, from the start or from the first message at or
+// after from, reporting progress at every interval.
+func backfillProjection(ctx context.Context, p projection, from time.Time, limiter *rate.Limiter, every time.Duration) error {
+	progress := &backfillProgress{}
+	for _, topic := range orderEventRouter.topics() {
+		partitions, err := bus.Partitions(ctx, topic)
+		if err != nil {
+			return fmt.Errorf("failed to list partitions of %s: %v", topic, err)
+		}
+		for _, partition := range partitions {
+			progress.add(topic, partition)
+		}
+	}
+
+	generation, err := rebuildProjection(ctx, p.name, true)
+	if err != nil {
+		return err
+	}
+	scope := projectionScope{name: p.name, generation: generation}
+	logger := slog.With("projection", p.name, "generation", generation)
+	defer endBackfill(p.name, generation)
+
+	progress.started = time.Now()
+	g, partitionCtx := errgroup.WithContext(ctx)
+	for _, part := range progress.partitions {
+		g.Go(func() error {
+			return backfillPartition(partitionCtx, p, scope, part, from, limiter)
+		})
+	}
+	if from.IsZero() {
+		logger.Info("backfill started", "partitions", len(progress.partitions))
+	} else {
+		logger.Info("backfill started", "partitions", len(progress.partitions), "from", from)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				logger.Info("backfill progress", progress.summary()...)
+			}
+		}
+	}()
+	err = g.Wait()
+	close(done)
+	if err != nil {
+		logger.Warn("backfill stopped; the runners will finish it", progress.summary()...)
+		return err
+	}
+	logger.Info("backfill finished", progress.summary()...)
+	return nil
+}
+
+// backfillPartition replays one partition into scope until it has caught up
+// with the partition's end.
+func backfillPartition(ctx context.Context, p projection, scope projectionScope, part *partitionProgress, from time.Time, limiter *rate.Limiter) error {
+	r := bus.PartitionReader(part.topic, part.partition)
+	defer r.Close()
+
+	var err error
+	if from.IsZero() {
+		err = r.SetOffset(kafka.FirstOffset)
+	} else {
+		err = r.SetOffsetAt(ctx, from)
+		if err == nil {
+			// The runners start here too should nothing be left to replay.
+			err = setBackfillCheckpoint(ctx, scope, topicPartitionName(part.topic, part.partition), r.Offset())
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		err := limiter.Wait(ctx)
+		if err != nil {
+			return err
+		}
+		readCtx, cancel := context.WithTimeout(ctx, projectionIdleCheck)
+		msg, err := r.ReadMessage(readCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			// Nothing, or nothing more, has been written to it.
+			part.finish()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = applyProjectionMessage(ctx, p, scope, msg, true)
+		if err != nil {
+			return err
+		}
+		part.applied(msg)
+		if msg.Offset+1 >= msg.HighWaterMark {
+			part.finish()
+			return nil
+		}
+	}
+}
+
+// setBackfillCheckpoint sets the partition's checkpoint in scope, provided
+// scope is still being backfilled.
+func setBackfillCheckpoint(ctx context.Context, scope projectionScope, field string, offset int64) error {
+	for {
+		err := redisClient.Watch(ctx, func(tx *redis.Tx) error {
+			control, err := getProjectionControl(ctx, tx, scope.name)
+			if err != nil {
+				return err
+			}
+			if control.Generation != scope.generation {
+				return errProjectionReset
+			}
+			if control.idle(true) {
+				return errProjectionPaused
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, scope.checkpointKey(), field, offset)
+				return nil
+			})
+			return err
+		}, projectionControlKey(scope.name))
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
+
+// endBackfill hands the generation back to the runners, unless it has been
+// replaced meanwhile. It runs however the backfill ended, interrupted
+// included, so it does not use the backfill's context.
+func endBackfill(name string, generation int64) {
+	ctx := context.Background()
+	for {
+		err := redisClient.Watch(ctx, func(tx *redis.Tx) error {
+			control, err := getProjectionControl(ctx, tx, name)
+			if err != nil {
+				return err
+			}
+			if control.Generation != generation {
+				return nil
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HDel(ctx, projectionControlKey(name), "backfill")
+				return nil
+			})
+			return err
+		}, projectionControlKey(name))
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			slog.Error("error handing projection back to the runners; delete the backfill field of "+projectionControlKey(name), "projection", name, "error", err)
+		}
+		return
+	}
+}
+
+// backfillProgress tracks how far a backfill has got on each partition.
+type backfillProgress struct {
+	started    time.Time
+	partitions []*partitionProgress
+}
+
+type partitionProgress struct {
+	topic     string
+	partition int
+
+	mu    sync.Mutex
+	count int64
+	// remaining is how many messages were left after the last one applied.
+	remaining int64
+	done      bool
+}
+
+func (b *backfillProgress) add(topic string, partition int) {
+	b.partitions = append(b.partitions, &partitionProgress{topic: topic, partition: partition})
+}
+
+func (p *partitionProgress) applied(msg kafka.Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.count++
+	p.remaining = max(msg.HighWaterMark-msg.Offset-1, 0)
+}
+
+func (p *partitionProgress) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.remaining = 0
+	p.done = true
+}
+
+// summary is the backfill's progress as log attributes. Partitions not yet
+// read from count nothing towards what remains.
+func (b *backfillProgress) summary() []any {
+	var applied, remaining int64
+	done := 0
+	for _, part := range b.partitions {
+		part.mu.Lock()
+		applied += part.count
+		remaining += part.remaining
+		if part.done {
+			done++
+		}
+		part.mu.Unlock()
+	}
+
+	percent := 100.0
+	if applied+remaining > 0 {
+		percent = float64(applied) * 100 / float64(applied+remaining)
+	}
+	elapsed := time.Since(b.started)
+	return []any{
+		"applied", applied,
+		"remaining", remaining,
+		"percent", fmt.Sprintf("%.1f", percent),
+		"per_second", fmt.Sprintf("%.1f", float64(applied)/max(elapsed.Seconds(), 1)),
+		"partitions_done", done,
+		"partitions", len(b.partitions),
+		"elapsed", elapsed.Round(time.Second).String(),
+	}
+}
@@ -0,0 +1,227 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"myproject/src/clock"
+)
+
+// Blob storage. Uploaded media is written through a BlobStore; BLOB_STORE
+// picks which:
+//
+//   - local, the default: files under MEDIA_DIR, served by the api itself
+//     at /media.
+//   - s3: objects in S3_BUCKET on S3 or any service speaking its API, such
+//     as MinIO or R2, served from S3_PUBLIC_URL or the bucket itself.
+//
+// Keys are slash-separated paths such as "menus/1/3/ab12cd.jpg". Their names
+// embed a hash of the content, so a blob is never overwritten with different
+// content and nothing is deleted: a replaced image may still be cached, or
+// shared by a cloned menu.
+
+const (
+	blobLocal = "local"
+	blobS3    = "s3"
+)
+
+// BlobStore keeps uploaded media where clients can fetch it.
+type BlobStore interface {
+	// Put stores data under key, replacing anything already there.
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	// Get returns what is stored under key, or errBlobNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// URL is where clients fetch key from.
+	URL(key string) string
+}
+
+var blobs BlobStore
+
+var errBlobNotFound = errors.New("blob not found")
+
+func validateBlobStore(store string) error {
+	switch store {
+	case blobLocal:
+		return nil
+	case blobS3:
+		if appConfig.S3Endpoint == "" || appConfig.S3Bucket == "" {
+			return fmt.Errorf("blob store %s needs S3_ENDPOINT and S3_BUCKET", store)
+		}
+		if appConfig.S3AccessKeyID == "" || appConfig.S3SecretAccessKey == "" {
+			return fmt.Errorf("blob store %s needs S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY", store)
+		}
+		return nil
+	}
+	return fmt.Errorf("blob store %q must be %s or %s", store, blobLocal, blobS3)
+}
+
+// openBlobStore opens the store BLOB_STORE names.
+func openBlobStore() BlobStore {
+	if appConfig.BlobStore == blobS3 {
+		publicURL := appConfig.S3PublicURL
+		if publicURL == "" {
+			publicURL = strings.TrimRight(appConfig.S3Endpoint, "/") + "/" + appConfig.S3Bucket
+		}
+		return &S3BlobStore{
+			Endpoint:        strings.TrimRight(appConfig.S3Endpoint, "/"),
+			Region:          appConfig.S3Region,
+			Bucket:          appConfig.S3Bucket,
+			AccessKeyID:     appConfig.S3AccessKeyID,
+			SecretAccessKey: appConfig.S3SecretAccessKey,
+			PublicURL:       strings.TrimRight(publicURL, "/"),
+			Client:          &http.Client{Timeout: 30 * time.Second},
+		}
+	}
+	return LocalBlobStore{Dir: appConfig.MediaDir, BaseURL: appConfig.MediaBaseURL}
+}
+
+// LocalBlobStore keeps blobs as files under Dir, served at BaseURL.
+type LocalBlobStore struct {
+	Dir     string
+	BaseURL string
+}
+
+func (s LocalBlobStore) Put(ctx context.Context, key, contentType string, data []byte) error {
+	path := filepath.Join(s.Dir, filepath.FromSlash(key))
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create media directory: %w", err)
+	}
+
+	err = os.WriteFile(path, data, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write media file: %w", err)
+	}
+	return nil
+}
+
+func (s LocalBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errBlobNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read media file: %w", err)
+	}
+	return data, nil
+}
+
+func (s LocalBlobStore) URL(key string) string {
+	return strings.TrimRight(s.BaseURL, "/") + "/" + key
+}
+
+// S3BlobStore keeps blobs in an S3 bucket, addressed by path so it works
+// with S3-compatible services that have no per-bucket host names. Requests
+// are signed with AWS Signature Version 4.
+type S3BlobStore struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PublicURL is the bucket as clients see it, such as a CDN in front.
+	PublicURL string
+	Client    *http.Client
+}
+
+func (s *S3BlobStore) Put(ctx context.Context, key, contentType string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to build s3 request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	_, err = s.do(req, data)
+	return err
+}
+
+func (s *S3BlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build s3 request: %w", err)
+	}
+	return s.do(req, nil)
+}
+
+func (s *S3BlobStore) URL(key string) string {
+	return s.PublicURL + "/" + key
+}
+
+func (s *S3BlobStore) objectURL(key string) string {
+	return s.Endpoint + "/" + s.Bucket + "/" + key
+}
+
+// do sends req, signed, and returns the response body.
+func (s *S3BlobStore) do(req *http.Request, payload []byte) ([]byte, error) {
+	s.sign(req, payload, clock.Now().UTC())
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errBlobNotFound
+	}
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("s3 %s %s returned %d: %s", req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read s3 response: %w", err)
+	}
+	return body, nil
+}
+
+// sign adds the Signature Version 4 headers to req, signing the host, the
+// payload hash and the time.
+func (s *S3BlobStore) sign(req *http.Request, payload []byte, now time.Time) {
+	payloadHash := sha256.Sum256(payload)
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + hex.EncodeToString(payloadHash[:]),
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, part := range []string{day, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,210 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+const blocklistAuditKey = "blocklist:audit"
+
+// platformScope is used in place of a restaurant ID for platform-wide bans.
+const platformScope = "platform"
+
+type BlockEntry struct {
+	CustomerID   string    `json:"customer_id"`
+	RestaurantID string    `json:"restaurant_id,omitempty"`
+	Reason       string    `json:"reason"`
+	BlockedBy    string    `json:"blocked_by"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type BlockAuditRecord struct {
+	Action       string    `json:"action"`
+	CustomerID   string    `json:"customer_id"`
+	RestaurantID string    `json:"restaurant_id,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	Actor        string    `json:"actor"`
+	At           time.Time `json:"at"`
+}
+
+type BlockCustomerRequest struct {
+	CustomerID   string `json:"customer_id" validate:"required"`
+	RestaurantID string `json:"restaurant_id"`
+	Reason       string `json:"reason" validate:"required,max=500"`
+	BlockedBy    string `json:"blocked_by" validate:"required"`
+}
+
+type UnblockCustomerRequest struct {
+	CustomerID   string `json:"customer_id" validate:"required"`
+	RestaurantID string `json:"restaurant_id"`
+	UnblockedBy  string `json:"unblocked_by" validate:"required"`
+}
+
+func blockKey(scope, customerID string) string {
+	return fmt.Sprintf("blocklist:%s:%s", scope, customerID)
+}
+
+func restaurantBlockCustomer(c echo.Context) error {
+	var req BlockCustomerRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	if req.RestaurantID == "" {
+		return validationFailed(c, "restaurant_id", "is required")
+	}
+
+	if !actsForRestaurant(c, req.RestaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	return blockCustomer(c, req.RestaurantID, req)
+}
+
+func adminBlockCustomer(c echo.Context) error {
+	var req BlockCustomerRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	req.RestaurantID = ""
+	return blockCustomer(c, platformScope, req)
+}
+
+func blockCustomer(c echo.Context, scope string, req BlockCustomerRequest) error {
+	ctx := c.Request().Context()
+
+	entry := BlockEntry{
+		CustomerID:   req.CustomerID,
+		RestaurantID: req.RestaurantID,
+		Reason:       req.Reason,
+		BlockedBy:    req.BlockedBy,
+		CreatedAt:    clock.Now().UTC(),
+	}
+
+	entryJSON, _ := json.Marshal(entry)
+	err := redisClient.Set(ctx, blockKey(scope, req.CustomerID), entryJSON, 0).Err()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store block"})
+	}
+
+	recordBlockAudit(ctx, BlockAuditRecord{
+		Action:       "block",
+		CustomerID:   req.CustomerID,
+		RestaurantID: req.RestaurantID,
+		Reason:       req.Reason,
+		Actor:        req.BlockedBy,
+		At:           entry.CreatedAt,
+	})
+
+	requestLogger(c).Info("customer blocked", "customer_id", req.CustomerID, "scope", scope, "blocked_by", req.BlockedBy, "reason", req.Reason)
+	return c.JSON(http.StatusOK, entry)
+}
+
+func restaurantUnblockCustomer(c echo.Context) error {
+	var req UnblockCustomerRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	if req.RestaurantID == "" {
+		return validationFailed(c, "restaurant_id", "is required")
+	}
+
+	if !actsForRestaurant(c, req.RestaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	return unblockCustomer(c, req.RestaurantID, req)
+}
+
+func adminUnblockCustomer(c echo.Context) error {
+	var req UnblockCustomerRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	req.RestaurantID = ""
+	return unblockCustomer(c, platformScope, req)
+}
+
+func unblockCustomer(c echo.Context, scope string, req UnblockCustomerRequest) error {
+	ctx := c.Request().Context()
+
+	removed, err := redisClient.Del(ctx, blockKey(scope, req.CustomerID)).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove block"})
+	}
+	if removed == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Block not found"})
+	}
+
+	recordBlockAudit(ctx, BlockAuditRecord{
+		Action:       "unblock",
+		CustomerID:   req.CustomerID,
+		RestaurantID: req.RestaurantID,
+		Actor:        req.UnblockedBy,
+		At:           clock.Now().UTC(),
+	})
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "unblocked"})
+}
+
+func getBlocklistAudit(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	records, err := redisClient.LRange(ctx, blocklistAuditKey, 0, -1).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch audit trail"})
+	}
+
+	audit := make([]BlockAuditRecord, 0, len(records))
+	for _, record := range records {
+		var r BlockAuditRecord
+		if err := json.Unmarshal([]byte(record), &r); err != nil {
+			requestLogger(c).Warn("skipping malformed audit record", "error", err)
+			continue
+		}
+		audit = append(audit, r)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"audit": audit})
+}
+
+func recordBlockAudit(ctx context.Context, record BlockAuditRecord) {
+	recordJSON, _ := json.Marshal(record)
+	err := redisClient.RPush(ctx, blocklistAuditKey, recordJSON).Err()
+	if err != nil {
+		slog.Error("error writing blocklist audit record", "error", err)
+	}
+}
+
+// findCustomerBlock returns the block that prevents customerID from ordering at
+// restaurantID, checking platform-wide bans before restaurant bans.
+func findCustomerBlock(ctx context.Context, rdb redis.Cmdable, customerID, restaurantID string) (*BlockEntry, error) {
+	for _, scope := range []string{platformScope, restaurantID} {
+		entryData, err := rdb.Get(ctx, blockKey(scope, customerID)).Result()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("redis error: %v", err)
+		}
+
+		var entry BlockEntry
+		err = json.Unmarshal([]byte(entryData), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse block entry: %v", err)
+		}
+		return &entry, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+type RestaurantBranding struct {
+	Logo    *ImageAsset  `json:"logo,omitempty"`
+	Cover   *ImageAsset  `json:"cover,omitempty"`
+	Gallery []ImageAsset `json:"gallery"`
+}
+
+const maxGalleryPhotos = 30
+
+func brandingKey(restaurantID string) string {
+	return "restaurant:" + restaurantID + ":branding"
+}
+
+func getBranding(ctx context.Context, restaurantID string) (RestaurantBranding, error) {
+	data, err := redisClient.Get(ctx, brandingKey(restaurantID)).Result()
+	if err == redis.Nil {
+		return RestaurantBranding{Gallery: []ImageAsset{}}, nil
+	} else if err != nil {
+		return RestaurantBranding{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	var branding RestaurantBranding
+	err = json.Unmarshal([]byte(data), &branding)
+	if err != nil {
+		return RestaurantBranding{}, fmt.Errorf("failed to parse branding: %v", err)
+	}
+	return branding, nil
+}
+
+func saveBranding(ctx context.Context, restaurantID string, branding RestaurantBranding) error {
+	data, _ := json.Marshal(branding)
+	err := redisClient.Set(ctx, brandingKey(restaurantID), data, 0).Err()
+	if err != nil {
+		return fmt.Errorf("failed to store branding: %v", err)
+	}
+	return nil
+}
+
+// attachBranding fills in branding for each restaurant with a single MGET.
+func attachBranding(ctx context.Context, restaurants []Restaurant) ([]Restaurant, error) {
+	if len(restaurants) == 0 {
+		return restaurants, nil
+	}
+
+	keys := make([]string, len(restaurants))
+	for i, restaurant := range restaurants {
+		keys[i] = brandingKey(restaurant.ID)
+	}
+
+	values, err := redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+
+	withBranding := make([]Restaurant, len(restaurants))
+	copy(withBranding, restaurants)
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var branding RestaurantBranding
+		if err := json.Unmarshal([]byte(data), &branding); err == nil {
+			withBranding[i].Branding = &branding
+		}
+	}
+	return withBranding, nil
+}
+
+func uploadRestaurantLogo(c echo.Context) error {
+	return uploadBrandingImage(c, "logo")
+}
+
+func uploadRestaurantCover(c echo.Context) error {
+	return uploadBrandingImage(c, "cover")
+}
+
+func addGalleryPhoto(c echo.Context) error {
+	return uploadBrandingImage(c, "gallery")
+}
+
+func uploadBrandingImage(c echo.Context, slot string) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	if !actsForRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	fh, err := c.FormFile("image")
+	if err != nil {
+		return validationFailed(c, "image", "is required")
+	}
+
+	branding, err := getBranding(ctx, restaurantID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch branding"})
+	}
+
+	if slot == "gallery" && len(branding.Gallery) >= maxGalleryPhotos {
+		return validationFailed(c, "image", fmt.Sprintf("gallery is limited to %d photos", maxGalleryPhotos))
+	}
+
+	asset, err := processImageUpload(c.Request().Context(), fh, "restaurants/"+restaurantID+"/"+slot)
+	if err != nil {
+		requestLogger(c).Warn("rejected branding upload", "restaurant_id", restaurantID, "slot", slot, "error", err)
+		return validationFailed(c, "image", err.Error())
+	}
+
+	switch slot {
+	case "logo":
+		branding.Logo = &asset
+	case "cover":
+		branding.Cover = &asset
+	case "gallery":
+		branding.Gallery = append(branding.Gallery, asset)
+	}
+
+	err = saveBranding(ctx, restaurantID, branding)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store branding"})
+	}
+
+	requestLogger(c).Info("branding image uploaded", "restaurant_id", restaurantID, "slot", slot, "asset_id", asset.ID)
+	return c.JSON(http.StatusOK, branding)
+}
+
+func deleteGalleryPhoto(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	if !actsForRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	branding, err := getBranding(ctx, restaurantID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch branding"})
+	}
+
+	photoID := c.Param("photoId")
+	gallery := make([]ImageAsset, 0, len(branding.Gallery))
+	for _, photo := range branding.Gallery {
+		if photo.ID != photoID {
+			gallery = append(gallery, photo)
+		}
+	}
+	if len(gallery) == len(branding.Gallery) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Photo not found"})
+	}
+	branding.Gallery = gallery
+
+	err = saveBranding(ctx, restaurantID, branding)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store branding"})
+	}
+
+	return c.JSON(http.StatusOK, branding)
+}
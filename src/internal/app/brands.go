@@ -0,0 +1,293 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+// A brand groups the branches of a restaurant chain. Brand staff hold
+// restaurant or owner tokens carrying the brand's ID instead of a restaurant
+// ID and may act for any branch (see staffFor); promos can be limited to a
+// brand's branches; and the brand dashboard totals its branches' dashboards.
+// A restaurant belongs to at most one brand.
+
+const (
+	// brandsKey maps brand IDs to brands.
+	brandsKey = "brands"
+	// brandMembersKey maps restaurant IDs to the brand they belong to.
+	brandMembersKey = "brands:members"
+)
+
+var (
+	errBrandNotFound = errors.New("brand not found")
+	errBrandConflict = errors.New("restaurant belongs to another brand")
+)
+
+type Brand struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name" validate:"required,max=100"`
+	RestaurantIDs []string  `json:"restaurant_ids" validate:"required,min=1,max=500,dive,required"`
+	CreatedAt     Timestamp `json:"created_at"`
+	UpdatedAt     Timestamp `json:"updated_at"`
+}
+
+type BrandDashboard struct {
+	BrandID string `json:"brand_id"`
+	// Totals adds up the branches; its restaurant_id is empty.
+	Totals   RestaurantDashboard   `json:"totals"`
+	Branches []RestaurantDashboard `json:"branches"`
+}
+
+func getBrand(ctx context.Context, brandID string) (Brand, error) {
+	data, err := redisClient.HGet(ctx, brandsKey, brandID).Result()
+	if err == redis.Nil {
+		return Brand{}, errBrandNotFound
+	} else if err != nil {
+		return Brand{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	var brand Brand
+	err = json.Unmarshal([]byte(data), &brand)
+	if err != nil {
+		return Brand{}, fmt.Errorf("failed to parse brand: %v", err)
+	}
+	return brand, nil
+}
+
+// restaurantBrand returns the ID of the brand restaurantID belongs to, or ""
+// if it belongs to none.
+func restaurantBrand(ctx context.Context, restaurantID string) (string, error) {
+	brandID, err := redisClient.HGet(ctx, brandMembersKey, restaurantID).Result()
+	if err == redis.Nil {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("redis error: %v", err)
+	}
+	return brandID, nil
+}
+
+// saveBrand stores brand and points each of its restaurants at it, releasing
+// restaurants it no longer lists. It fails with errBrandConflict if one of
+// them belongs to another brand.
+func saveBrand(ctx context.Context, brand Brand) error {
+	return redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		members, err := tx.HGetAll(ctx, brandMembersKey).Result()
+		if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+
+		listed := make(map[string]bool, len(brand.RestaurantIDs))
+		for _, restaurantID := range brand.RestaurantIDs {
+			listed[restaurantID] = true
+			if owner, ok := members[restaurantID]; ok && owner != brand.ID {
+				return fmt.Errorf("%w: %s is in %s", errBrandConflict, restaurantID, owner)
+			}
+		}
+
+		brandJSON, err := json.Marshal(brand)
+		if err != nil {
+			return fmt.Errorf("failed to marshal brand: %v", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, brandsKey, brand.ID, brandJSON)
+			for restaurantID, owner := range members {
+				if owner == brand.ID && !listed[restaurantID] {
+					pipe.HDel(ctx, brandMembersKey, restaurantID)
+				}
+			}
+			for restaurantID := range listed {
+				pipe.HSet(ctx, brandMembersKey, restaurantID, brand.ID)
+			}
+			return nil
+		})
+		return err
+	}, brandMembersKey)
+}
+
+// brandStaff reports whether the caller holds a staff token for brandID.
+func brandStaff(c echo.Context, brandID string) bool {
+	claims := authClaims(c)
+	return claims != nil && claims.BrandID == brandID && claims.RestaurantID == "" &&
+		(claims.Role == roleRestaurant || claims.Role == roleOwner)
+}
+
+// setBrand serves PUT /admin/brands/:id, creating the brand or replacing its
+// name and branches.
+func setBrand(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var brand Brand
+	if err := bindAndValidate(c, &brand); err != nil {
+		return respondRequestError(c, err)
+	}
+	brand.ID = c.Param("id")
+
+	seen := make(map[string]bool, len(brand.RestaurantIDs))
+	for i, restaurantID := range brand.RestaurantIDs {
+		field := fmt.Sprintf("restaurant_ids[%d]", i)
+		if seen[restaurantID] {
+			return validationFailed(c, field, "is listed more than once")
+		}
+		seen[restaurantID] = true
+
+		_, err := findRestaurant(ctx, restaurantID)
+		if err == errRestaurantNotFound {
+			return validationFailed(c, field, "is not a known restaurant")
+		} else if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant"})
+		}
+	}
+
+	existing, err := getBrand(ctx, brand.ID)
+	if err == nil {
+		brand.CreatedAt = existing.CreatedAt
+	} else if err != errBrandNotFound {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch brand"})
+	}
+	touch(&brand.CreatedAt, &brand.UpdatedAt)
+
+	err = saveBrand(ctx, brand)
+	if errors.Is(err, errBrandConflict) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	} else if err == redis.TxFailedErr {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Brands changed meanwhile, try again"})
+	} else if err != nil {
+		requestLogger(c).Error("error saving brand", "brand_id", brand.ID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save brand"})
+	}
+
+	requestLogger(c).Info("brand saved", "brand_id", brand.ID, "branches", len(brand.RestaurantIDs))
+	return c.JSON(http.StatusOK, brand)
+}
+
+func listBrands(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	entries, err := redisClient.HGetAll(ctx, brandsKey).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch brands"})
+	}
+
+	brands := make([]Brand, 0, len(entries))
+	for id, data := range entries {
+		var brand Brand
+		if err := json.Unmarshal([]byte(data), &brand); err != nil {
+			requestLogger(c).Warn("skipping unreadable brand", "brand_id", id, "error", err)
+			continue
+		}
+		brands = append(brands, brand)
+	}
+	sort.Slice(brands, func(i, j int) bool { return brands[i].ID < brands[j].ID })
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"brands": brands})
+}
+
+// deleteBrand serves DELETE /admin/brands/:id. Its branches carry on as
+// independent restaurants.
+func deleteBrand(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	brandID := c.Param("id")
+	brand, err := getBrand(ctx, brandID)
+	if err == errBrandNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Brand not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch brand"})
+	}
+
+	err = redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		members, err := tx.HGetAll(ctx, brandMembersKey).Result()
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, brandsKey, brandID)
+			for restaurantID, owner := range members {
+				if owner == brandID {
+					pipe.HDel(ctx, brandMembersKey, restaurantID)
+				}
+			}
+			return nil
+		})
+		return err
+	}, brandMembersKey)
+	if err != nil {
+		requestLogger(c).Error("error deleting brand", "brand_id", brandID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete brand"})
+	}
+
+	requestLogger(c).Info("brand deleted", "brand_id", brandID, "branches", len(brand.RestaurantIDs))
+	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// getBrandHandler serves GET /brand/:id to admins and the brand's staff.
+func getBrandHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	brandID := c.Param("id")
+	if authClaims(c).Role != roleAdmin && !brandStaff(c, brandID) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Brand not found"})
+	}
+
+	brand, err := getBrand(ctx, brandID)
+	if err == errBrandNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Brand not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch brand"})
+	}
+	return c.JSON(http.StatusOK, brand)
+}
+
+// getBrandDashboard serves GET /brand/:id/dashboard: each branch's dashboard
+// and their totals.
+func getBrandDashboard(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	brandID := c.Param("id")
+	if authClaims(c).Role != roleAdmin && !brandStaff(c, brandID) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Brand not found"})
+	}
+
+	brand, err := getBrand(ctx, brandID)
+	if err == errBrandNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Brand not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch brand"})
+	}
+
+	scope, err := currentProjectionScope(c.Request().Context(), "dashboard")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch dashboard"})
+	}
+
+	dashboard := BrandDashboard{
+		BrandID:  brandID,
+		Totals:   RestaurantDashboard{OrdersByStatus: map[string]int64{}},
+		Branches: make([]RestaurantDashboard, 0, len(brand.RestaurantIDs)),
+	}
+	for _, restaurantID := range brand.RestaurantIDs {
+		branch, err := readRestaurantDashboard(ctx, scope, restaurantID)
+		if err != nil {
+			requestLogger(c).Error("error fetching dashboard", "brand_id", brandID, "restaurant_id", restaurantID, "error", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch dashboard"})
+		}
+		dashboard.Branches = append(dashboard.Branches, branch)
+
+		for status, count := range branch.OrdersByStatus {
+			dashboard.Totals.OrdersByStatus[status] += count
+		}
+		dashboard.Totals.Active += branch.Active
+		dashboard.Totals.DeliveredRevenue += branch.DeliveredRevenue
+	}
+	dashboard.Totals.DeliveredRevenue = roundMoney(dashboard.Totals.DeliveredRevenue)
+
+	return c.JSON(http.StatusOK, dashboard)
+}
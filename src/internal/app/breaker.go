@@ -0,0 +1,157 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+	"github.com/sony/gobreaker"
+)
+
+// Circuit breakers guard Redis and Kafka. Once a dependency has failed so
+// many times in a row its breaker opens, and calls to it fail at once with
+// errCircuitOpen instead of each waiting out a timeout. After the open
+// period a few trial calls go through; if they succeed the breaker closes.
+//
+// With Redis open, menus are served from menu.json (see cachedMenu). Order
+// events need no fallback of their own: they wait in the outbox until the
+// relay can publish them, and while Kafka's breaker is open the relay does
+// not try.
+
+var errCircuitOpen = errors.New("circuit open")
+
+var dependencyCircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "dependency_circuit_state",
+	Help: "Circuit breaker state by dependency: 0 closed, 1 half open, 2 open.",
+}, []string{"dependency"})
+
+func init() {
+	prometheus.MustRegister(dependencyCircuitState)
+}
+
+var (
+	redisBreaker *gobreaker.TwoStepCircuitBreaker
+	kafkaBreaker *gobreaker.TwoStepCircuitBreaker
+)
+
+// setupBreakers makes the breakers from appConfig. It must run before the
+// Redis client and the Kafka writers are used.
+func setupBreakers() {
+	redisBreaker = newBreaker("redis", appConfig.RedisBreakerFailures, appConfig.RedisBreakerOpenFor)
+	kafkaBreaker = newBreaker("kafka", appConfig.KafkaBreakerFailures, appConfig.KafkaBreakerOpenFor)
+}
+
+func newBreaker(dependency string, failures int, openFor time.Duration) *gobreaker.TwoStepCircuitBreaker {
+	dependencyCircuitState.WithLabelValues(dependency).Set(0)
+	return gobreaker.NewTwoStepCircuitBreaker(gobreaker.Settings{
+		Name:        dependency,
+		MaxRequests: 3,
+		Timeout:     openFor,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= uint32(max(failures, 1))
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			dependencyCircuitState.WithLabelValues(name).Set(float64(to))
+			if to == gobreaker.StateOpen {
+				slog.Error("circuit opened", "dependency", name, "from", from.String(), "open_for", openFor)
+			} else {
+				slog.Warn("circuit state changed", "dependency", name, "from", from.String(), "to", to.String())
+			}
+		},
+	})
+}
+
+// breakerState is the breaker's state as readiness reports it.
+func breakerState(breaker *gobreaker.TwoStepCircuitBreaker) string {
+	if breaker == nil {
+		return ""
+	}
+	return breaker.State().String()
+}
+
+// allow asks breaker whether a call may go ahead, translating its refusals
+// to errCircuitOpen.
+func allow(breaker *gobreaker.TwoStepCircuitBreaker) (func(bool), error) {
+	done, err := breaker.Allow()
+	if err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests {
+		return nil, fmt.Errorf("%w: %s", errCircuitOpen, breaker.Name())
+	}
+	return done, err
+}
+
+// redisFailed reports whether err means Redis could not be reached. Replies
+// Redis made itself, redis.Nil among them, show it is up; so does a caller
+// giving up.
+func redisFailed(err error) bool {
+	if err == nil || err == redis.Nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var replyErr redis.Error
+	return !errors.As(err, &replyErr)
+}
+
+type breakerDoneKey struct{}
+
+// redisBreakerHook runs every command and pipeline through redisBreaker.
+type redisBreakerHook struct{}
+
+func (redisBreakerHook) before(ctx context.Context) (context.Context, error) {
+	done, err := allow(redisBreaker)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, breakerDoneKey{}, done), nil
+}
+
+func (redisBreakerHook) after(ctx context.Context, cmds ...redis.Cmder) {
+	done, ok := ctx.Value(breakerDoneKey{}).(func(bool))
+	if !ok {
+		return
+	}
+	for _, cmd := range cmds {
+		if redisFailed(cmd.Err()) {
+			done(false)
+			return
+		}
+	}
+	done(true)
+}
+
+func (h redisBreakerHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return h.before(ctx)
+}
+
+func (h redisBreakerHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.after(ctx, cmd)
+	return nil
+}
+
+func (h redisBreakerHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return h.before(ctx)
+}
+
+func (h redisBreakerHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	h.after(ctx, cmds...)
+	return nil
+}
+
+// breakerWriter publishes through kafkaBreaker. Every writer shares it: a
+// broker that is down is down for every topic.
+type breakerWriter struct {
+	messageWriter
+}
+
+func (w breakerWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	done, err := allow(kafkaBreaker)
+	if err != nil {
+		return err
+	}
+	err = w.messageWriter.WriteMessages(ctx, msgs...)
+	done(err == nil || errors.Is(err, context.Canceled))
+	return err
+}
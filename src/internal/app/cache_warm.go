@@ -0,0 +1,96 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+type CacheWarmRequest struct {
+	RestaurantIDs []string `json:"restaurant_ids" validate:"max=1000,dive,required"`
+	All           bool     `json:"all"`
+}
+
+type CacheWarmResult struct {
+	RestaurantID string `json:"restaurant_id"`
+	Success      bool   `json:"success"`
+	Error        string `json:"error,omitempty"`
+}
+
+func warmMenuCache(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req CacheWarmRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	restaurantIDs := req.RestaurantIDs
+	if req.All {
+		restaurants, err := repositories.Restaurants.Restaurants(ctx)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurants"})
+		}
+		restaurantIDs = make([]string, 0, len(restaurants))
+		for _, restaurant := range restaurants {
+			restaurantIDs = append(restaurantIDs, restaurant.ID)
+		}
+	}
+
+	if len(restaurantIDs) == 0 {
+		return validationFailed(c, "restaurant_ids", "is required unless all is set")
+	}
+
+	results := warmMenus(ctx, restaurantIDs, appConfig.CacheWarmWorkers)
+
+	warmed := 0
+	for _, result := range results {
+		if result.Success {
+			warmed++
+		}
+	}
+	requestLogger(c).Info("menu cache warmed", "requested", len(restaurantIDs), "warmed", warmed)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"warmed":  warmed,
+		"failed":  len(results) - warmed,
+		"results": results,
+	})
+}
+
+// warmMenus loads each restaurant's menu into the cache using at most workers
+// concurrent loads. Results are returned in the same order as restaurantIDs.
+func warmMenus(ctx context.Context, restaurantIDs []string, workers int) []CacheWarmResult {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]CacheWarmResult, len(restaurantIDs))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				restaurantID := restaurantIDs[i]
+				_, err := fetchMenuFromFile(ctx, restaurantID)
+				results[i] = CacheWarmResult{RestaurantID: restaurantID, Success: err == nil}
+				if err != nil {
+					results[i].Error = err.Error()
+				}
+			}
+		}()
+	}
+
+	for i := range restaurantIDs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+type CancelOrderRequest struct {
+	OrderID string `json:"order_id" validate:"required,uuid"`
+	Reason  string `json:"reason" validate:"max=500"`
+}
+
+func cancelOrder(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req CancelOrderRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+	reason, ok := cleanText(authClaims(c), "reason", req.Reason)
+	if !ok {
+		return validationFailed(c, "reason", textRejectedMessage)
+	}
+
+	order, err := getOrder(ctx, req.OrderID)
+	if err == errOrderNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+
+	if order.CustomerID != authClaims(c).Subject {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	}
+	if err := checkOrderVersion(c.Request().Context(), order); err != nil {
+		return respondServiceError(c, err)
+	}
+
+	now := clock.Now()
+	withinGrace := cancelGraceRemaining(order, now) > 0
+	order.CancellationFee = cancellationFee(order, now)
+
+	// Once the rider has the food there is nothing left to stop.
+	order.StatusReason = reason
+	err = transitionOrder(c.Request().Context(), repositories.Orders, &order, "cancelled", "created", "accepted")
+	if err == errInvalidTransition {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Order cannot be cancelled in status " + order.Status})
+	} else if err == errOrderChanged {
+		return respondServiceError(c, orderChangedFailure(ctx, order.OrderID))
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update order"})
+	}
+
+	recordDailyStat(ctx, redisClient, statOrdersCancelled)
+	requestLogger(c).Info("order cancelled", "order_id", order.OrderID, "reason", reason, "within_grace", withinGrace, "fee", order.CancellationFee)
+
+	// The refund itself is issued asynchronously from the OrderCancelled event.
+	refund := 0.0
+	if order.PaymentStatus == paymentPaid {
+		refund = roundMoney(order.TotalAmount - order.CancellationFee)
+	}
+
+	tagOrderVersion(c, order.Version)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"order_id":         order.OrderID,
+		"status":           order.Status,
+		"refund_amount":    refund,
+		"cancellation_fee": order.CancellationFee,
+		"within_grace":     withinGrace,
+	})
+}
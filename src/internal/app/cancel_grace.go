@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"myproject/src/clock"
+)
+
+// Cancellation grace window. For a short while after placement a customer
+// may cancel without the cancellation fee, and the restaurant is not offered
+// the order until that while has passed, so it never starts on food that is
+// then cancelled. The window is CancelGraceWindow unless the restaurant has
+// its own in CancelGraceWindows.
+//
+// Holding the offer needs the job queue; without it the restaurant is offered
+// the order as soon as it is paid and only the fee is waived.
+
+const jobRestaurantOffer = "restaurant_offer"
+
+type restaurantOfferPayload struct {
+	OrderID string     `json:"order_id"`
+	Event   OrderEvent `json:"event"`
+}
+
+func cancelGraceWindow(restaurantID string) time.Duration {
+	if window, ok := appConfig.CancelGraceWindows[restaurantID]; ok {
+		return window
+	}
+	return appConfig.CancelGraceWindow
+}
+
+// cancelGraceRemaining is how much of order's grace window is left at now.
+func cancelGraceRemaining(order Order, now time.Time) time.Duration {
+	return order.CreatedAt.Add(cancelGraceWindow(order.RestaurantID)).Sub(now)
+}
+
+// cancellationFee is what is kept back when order is cancelled at now:
+// nothing within the grace window or if it was never paid, otherwise
+// CancellationFeePercent of the total.
+func cancellationFee(order Order, now time.Time) float64 {
+	if order.PaymentStatus != paymentPaid || cancelGraceRemaining(order, now) > 0 {
+		return 0
+	}
+	return roundMoney(order.TotalAmount * appConfig.CancellationFeePercent / 100)
+}
+
+// holdForCancelGrace schedules the restaurant's offer of a newly paid order
+// for the end of its grace window, and reports whether it did. An order it
+// cannot hold is offered straight away rather than risk it never being.
+func holdForCancelGrace(ctx context.Context, event OrderEvent) bool {
+	if event.Type != eventOrderPaid || jobQueue == nil {
+		return false
+	}
+
+	order, err := getOrder(ctx, event.OrderID)
+	if err != nil {
+		slog.Warn("error fetching order to hold for cancel grace", "order_id", event.OrderID, "error", err)
+		return false
+	}
+
+	remaining := cancelGraceRemaining(order, clock.Now())
+	if remaining <= 0 {
+		return false
+	}
+
+	payload := restaurantOfferPayload{OrderID: order.OrderID, Event: event}
+	err = jobQueue.Enqueue(ctx, jobRestaurantOffer, payload, clock.Now().Add(remaining))
+	if err != nil {
+		slog.Error("error holding restaurant offer", "order_id", order.OrderID, "error", err)
+		return false
+	}
+
+	slog.Info("restaurant offer held for cancel grace", "order_id", order.OrderID, "remaining", remaining)
+	return true
+}
+
+// offerHeldOrder offers the restaurant an order held for its grace window,
+// unless the customer cancelled it meanwhile.
+func offerHeldOrder(ctx context.Context, job Job) error {
+	var payload restaurantOfferPayload
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return fmt.Errorf("invalid restaurant offer payload: %w", err)
+	}
+
+	order, err := getOrder(ctx, payload.OrderID)
+	if err == errOrderNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if order.Status != "created" {
+		slog.Info("held order no longer awaiting restaurant, not offered", "order_id", order.OrderID, "status", order.Status)
+		return nil
+	}
+
+	// The webhook goes last so a retried notification does not queue it twice.
+	err = notifyOrderPaid(ctx, payload.Event)
+	if err != nil {
+		return err
+	}
+	offerToRestaurant(ctx, payload.Event)
+	return nil
+}
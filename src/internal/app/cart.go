@@ -0,0 +1,525 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+// Carts. A customer's app builds an order up in a cart, item by item, and
+// checks it out as the order once the customer is done, so it never has to
+// assemble an order payload of its own. A cart is priced afresh whenever it
+// is read, against the menu, delivery fee and promo as they are then, but
+// nothing is reserved until checkout, which places the order exactly as
+// POST /order does.
+//
+// Carts live in Redis for CartTTL after they were last changed. A checked
+// out cart is kept for as long again, pointing at its order, so a checkout
+// sent twice does not place two orders. The order's ID is chosen and kept
+// before it is placed, so that a checkout cut short after placing it is
+// finished by the next one rather than placing another.
+
+const cartMaxItems = 100
+
+var errCartNotFound = errors.New("cart not found")
+
+var errCartCheckedOut = errors.New("cart has been checked out")
+
+// Cart is an order being put together by its customer.
+type Cart struct {
+	ID               string          `json:"id"`
+	CustomerID       string          `json:"customer_id"`
+	RestaurantID     string          `json:"restaurant_id"`
+	Items            []OrderItem     `json:"items"`
+	PromoCode        string          `json:"promo_code,omitempty"`
+	DeliveryLocation *GeoPoint       `json:"delivery_location,omitempty"`
+	DeliveryOptions  DeliveryOptions `json:"delivery_options"`
+	ScheduledAt      *Timestamp      `json:"scheduled_at,omitempty"`
+	Gift             *GiftDetails    `json:"gift,omitempty"`
+	Tip              float64         `json:"tip,omitempty"`
+	Utensils         bool            `json:"utensils"`
+	// OrderID is the order the cart was checked out as.
+	OrderID   string    `json:"order_id,omitempty"`
+	CreatedAt Timestamp `json:"created_at"`
+	UpdatedAt Timestamp `json:"updated_at"`
+	ExpiresAt Timestamp `json:"expires_at"`
+}
+
+// order is the order the cart is checked out as.
+func (cart Cart) order() Order {
+	return Order{
+		RestaurantID:     cart.RestaurantID,
+		Items:            cart.Items,
+		PromoCode:        cart.PromoCode,
+		DeliveryLocation: cart.DeliveryLocation,
+		DeliveryOptions:  cart.DeliveryOptions,
+		ScheduledAt:      cart.ScheduledAt,
+		Gift:             cart.Gift,
+		Tip:              cart.Tip,
+		Utensils:         cart.Utensils,
+	}
+}
+
+// CartDetails is everything but the items a cart's order will have. Each
+// is optional, and may be left until checkout.
+type CartDetails struct {
+	PromoCode        *string          `json:"promo_code,omitempty" validate:"omitempty,max=32"`
+	DeliveryLocation *GeoPoint        `json:"delivery_location,omitempty"`
+	DeliveryOptions  *DeliveryOptions `json:"delivery_options,omitempty"`
+	ScheduledAt      *Timestamp       `json:"scheduled_at,omitempty"`
+	Gift             *GiftDetails     `json:"gift,omitempty"`
+	Tip              *float64         `json:"tip,omitempty" validate:"omitempty,gte=0,lte=500"`
+	Utensils         *bool            `json:"utensils,omitempty"`
+}
+
+// apply sets the details given on cart.
+func (d CartDetails) apply(cart *Cart) {
+	if d.PromoCode != nil {
+		cart.PromoCode = *d.PromoCode
+	}
+	if d.DeliveryLocation != nil {
+		cart.DeliveryLocation = d.DeliveryLocation
+	}
+	if d.DeliveryOptions != nil {
+		cart.DeliveryOptions = *d.DeliveryOptions
+	}
+	if d.ScheduledAt != nil {
+		cart.ScheduledAt = d.ScheduledAt
+	}
+	if d.Gift != nil {
+		cart.Gift = d.Gift
+	}
+	if d.Tip != nil {
+		cart.Tip = *d.Tip
+	}
+	if d.Utensils != nil {
+		cart.Utensils = *d.Utensils
+	}
+}
+
+type CreateCartRequest struct {
+	RestaurantID string      `json:"restaurant_id" validate:"required"`
+	Items        []OrderItem `json:"items" validate:"max=100,dive"`
+	CartDetails
+}
+
+// CartItemsRequest sets the quantity of each item given; zero takes the
+// item out of the cart. Items not given are left as they are.
+type CartItemsRequest struct {
+	Items []CartItemChange `json:"items" validate:"required,min=1,max=100,dive"`
+}
+
+type CartItemChange struct {
+	MenuID   string `json:"menu_id" validate:"required"`
+	Quantity int    `json:"quantity" validate:"gte=0,lte=100"`
+}
+
+// CartIssue is something that stops a cart being checked out as it is, such
+// as an item no longer on the menu.
+type CartIssue struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// PricedCart is a cart with its price as of now, or, if it cannot be priced,
+// what is wrong with it.
+type PricedCart struct {
+	Cart
+	Pricing *PriceBreakdown `json:"pricing,omitempty"`
+	Issues  []CartIssue     `json:"issues,omitempty"`
+}
+
+func cartKey(cartID string) string {
+	return "cart:" + cartID
+}
+
+// cartOrderKey holds the ID chosen for the cart's order.
+func cartOrderKey(cartID string) string {
+	return cartKey(cartID) + ":order"
+}
+
+func getCart(ctx context.Context, cartID string) (Cart, error) {
+	return readCart(ctx, redisClient, cartID)
+}
+
+// readCart reads the cart through rdb, which may be a transaction watching
+// it.
+func readCart(ctx context.Context, rdb redis.Cmdable, cartID string) (Cart, error) {
+	data, err := rdb.Get(ctx, cartKey(cartID)).Result()
+	if err == redis.Nil {
+		return Cart{}, errCartNotFound
+	} else if err != nil {
+		return Cart{}, fmt.Errorf("redis error: %v", err)
+	}
+	var cart Cart
+	if err := json.Unmarshal([]byte(data), &cart); err != nil {
+		return Cart{}, fmt.Errorf("malformed cart %s: %v", cartID, err)
+	}
+	return cart, nil
+}
+
+// saveCart stores cart for another CartTTL.
+func saveCart(ctx context.Context, cart *Cart) error {
+	data, err := stampCart(cart)
+	if err != nil {
+		return err
+	}
+	err = redisClient.Set(ctx, cartKey(cart.ID), data, appConfig.CartTTL).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// stampCart marks cart as changed now, to expire CartTTL from now, and
+// encodes it.
+func stampCart(cart *Cart) ([]byte, error) {
+	now := clock.Now()
+	cart.UpdatedAt = Timestamp{Time: now}
+	cart.ExpiresAt = Timestamp{Time: now.Add(appConfig.CartTTL)}
+	data, err := json.Marshal(cart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode cart: %v", err)
+	}
+	return data, nil
+}
+
+// updateCart applies change to the stored cart and stores it for another
+// CartTTL. Watching the cart keeps changes made at once from overwriting
+// each other: if the cart is written meanwhile, the change is applied
+// afresh. A cart checked out meanwhile fails with errCartCheckedOut.
+func updateCart(ctx context.Context, cartID string, change func(*Cart) error) (Cart, error) {
+	for {
+		var cart Cart
+		err := redisClient.Watch(ctx, func(tx *redis.Tx) error {
+			var err error
+			cart, err = readCart(ctx, tx, cartID)
+			if err != nil {
+				return err
+			}
+			if cart.OrderID != "" {
+				return errCartCheckedOut
+			}
+			if err := change(&cart); err != nil {
+				return err
+			}
+			data, err := stampCart(&cart)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, cartKey(cartID), data, appConfig.CartTTL)
+				return nil
+			})
+			return err
+		}, cartKey(cartID))
+		if err != redis.TxFailedErr {
+			return cart, err
+		}
+	}
+}
+
+// cartOrderID returns the ID the cart's order is placed as, choosing one
+// the first time it is checked out.
+func cartOrderID(ctx context.Context, cartID string) (string, error) {
+	orderID, err := redisClient.Get(ctx, cartOrderKey(cartID)).Result()
+	if err == nil {
+		return orderID, nil
+	} else if err != redis.Nil {
+		return "", fmt.Errorf("redis error: %v", err)
+	}
+	orderID, err = idGenerator.NewID()
+	if err != nil {
+		return "", err
+	}
+	err = redisClient.Set(ctx, cartOrderKey(cartID), orderID, appConfig.CartTTL).Err()
+	if err != nil {
+		return "", fmt.Errorf("redis error: %v", err)
+	}
+	return orderID, nil
+}
+
+// customerCart returns the caller's cart c names, answering for the caller
+// if it is not there. A cart of someone else's is not found, like one that
+// has expired.
+func customerCart(c echo.Context) (Cart, bool, error) {
+	cart, err := getCart(c.Request().Context(), c.Param("id"))
+	if err == errCartNotFound || (err == nil && cart.CustomerID != authClaims(c).Subject) {
+		return cart, false, c.JSON(http.StatusNotFound, map[string]string{"error": "Cart not found or expired"})
+	} else if err != nil {
+		requestLogger(c).Error("error fetching cart", "cart_id", c.Param("id"), "error", err)
+		return cart, false, c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch cart"})
+	}
+	return cart, true, nil
+}
+
+// checkedOut answers for a cart already checked out, which cannot change.
+func checkedOut(c echo.Context, cart Cart) error {
+	return respondServiceError(c, &serviceError{
+		Status:  http.StatusConflict,
+		Message: "Cart has been checked out",
+		Details: map[string]interface{}{"order_id": cart.OrderID},
+	})
+}
+
+// priceCart prices cart as its order would be priced now. Problems with
+// what is in the cart are returned as issues; only failing to look
+// something up is an error.
+func (s OrderService) priceCart(ctx context.Context, logger *slog.Logger, cart Cart) (PricedCart, error) {
+	priced := PricedCart{Cart: cart}
+	if len(cart.Items) == 0 {
+		priced.Issues = []CartIssue{{Field: "items", Message: "is empty"}}
+		return priced, nil
+	}
+
+	order := cart.order()
+	checks, err := s.checkOrder(ctx, logger, order)
+	if err != nil {
+		return priced, err
+	}
+	if checks.MenuMissing {
+		priced.Issues = []CartIssue{{Field: "restaurant_id", Message: "is no longer taking orders"}}
+		return priced, nil
+	}
+	for _, pe := range []*pricingError{checks.DeliveryErr, checks.PromoErr} {
+		if pe != nil {
+			priced.Issues = append(priced.Issues, CartIssue{Field: pe.Field, Message: pe.Message})
+		}
+	}
+
+	// Without a promo that cannot be used, the rest of the cart is still
+	// priced, so the customer sees what it comes to without it.
+	promo := checks.Promo
+	if checks.PromoErr != nil {
+		promo = nil
+	}
+	if checks.DeliveryErr != nil {
+		return priced, nil
+	}
+	pricing, err := priceOrder(order, checks.Menu, checks.Delivery, promo)
+	var pe *pricingError
+	if errors.As(err, &pe) {
+		priced.Issues = append(priced.Issues, CartIssue{Field: pe.Field, Message: pe.Message})
+		return priced, nil
+	} else if err != nil {
+		logger.Error("error pricing cart", "cart_id", cart.ID, "restaurant_id", cart.RestaurantID, "error", err)
+		return priced, serviceFailure(http.StatusInternalServerError, "Failed to price cart")
+	}
+	priced.Pricing = &pricing
+	return priced, nil
+}
+
+// respondPricedCart answers with cart and its price as of now.
+func (s OrderService) respondPricedCart(c echo.Context, status int, cart Cart) error {
+	priced, err := s.priceCart(c.Request().Context(), requestLogger(c), cart)
+	if err != nil {
+		return respondServiceError(c, err)
+	}
+	return c.JSON(status, priced)
+}
+
+// createCart serves POST /cart, starting a cart at a restaurant.
+func (s OrderService) createCart(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req CreateCartRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+	if _, err := findRestaurant(ctx, req.RestaurantID); err == errRestaurantNotFound {
+		return validationFailed(c, "restaurant_id", "unknown restaurant")
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant"})
+	}
+
+	id, err := idGenerator.NewID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create cart"})
+	}
+	cart := Cart{
+		ID:           id,
+		CustomerID:   authClaims(c).Subject,
+		RestaurantID: req.RestaurantID,
+		Items:        []OrderItem{},
+		CreatedAt:    timestampNow(),
+	}
+	for _, item := range req.Items {
+		cart.Items = setCartItem(cart.Items, item.MenuID, item.Quantity)
+	}
+	req.CartDetails.apply(&cart)
+
+	err = saveCart(c.Request().Context(), &cart)
+	if err != nil {
+		requestLogger(c).Error("error storing cart", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save cart"})
+	}
+	requestLogger(c).Info("cart created", "cart_id", cart.ID, "restaurant_id", cart.RestaurantID, "items", len(cart.Items))
+	return s.respondPricedCart(c, http.StatusCreated, cart)
+}
+
+// getCartHandler serves GET /cart/:id, the cart priced as of now.
+func (s OrderService) getCartHandler(c echo.Context) error {
+	cart, ok, err := customerCart(c)
+	if !ok {
+		return err
+	}
+	if cart.OrderID != "" {
+		return c.JSON(http.StatusOK, PricedCart{Cart: cart})
+	}
+	return s.respondPricedCart(c, http.StatusOK, cart)
+}
+
+// updateCartItems serves PATCH /cart/:id/items.
+func (s OrderService) updateCartItems(c echo.Context) error {
+	var req CartItemsRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+	cart, ok, err := customerCart(c)
+	if !ok {
+		return err
+	}
+
+	cart, err = updateCart(c.Request().Context(), cart.ID, func(cart *Cart) error {
+		for _, item := range req.Items {
+			cart.Items = setCartItem(cart.Items, item.MenuID, item.Quantity)
+		}
+		if len(cart.Items) > cartMaxItems {
+			return invalidField("items", fmt.Sprintf("cannot hold more than %d different items", cartMaxItems))
+		}
+		return nil
+	})
+	var se *serviceError
+	switch {
+	case err == errCartCheckedOut:
+		return checkedOut(c, cart)
+	case err == errCartNotFound:
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Cart not found or expired"})
+	case errors.As(err, &se):
+		return respondServiceError(c, err)
+	case err != nil:
+		requestLogger(c).Error("error storing cart", "cart_id", cart.ID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save cart"})
+	}
+	return s.respondPricedCart(c, http.StatusOK, cart)
+}
+
+// setCartItem sets the quantity of menuID in items, adding it last if it is
+// new and taking it out at zero.
+func setCartItem(items []OrderItem, menuID string, quantity int) []OrderItem {
+	for i, item := range items {
+		if item.MenuID == menuID {
+			if quantity == 0 {
+				return append(items[:i], items[i+1:]...)
+			}
+			items[i].Quantity = quantity
+			return items
+		}
+	}
+	if quantity == 0 {
+		return items
+	}
+	return append(items, OrderItem{MenuID: menuID, Quantity: quantity})
+}
+
+// checkoutCart serves POST /cart/:id/checkout, placing the cart as an order.
+// The body may give any details the cart was left without, or change them.
+// The order is answered as POST /order answers it.
+func (s OrderService) checkoutCart(c echo.Context) error {
+	var req CartDetails
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+	reqCtx := c.Request().Context()
+	logger := requestLogger(c)
+
+	// Only the cart's customer may check it out, or hold its checkout up.
+	cart, ok, err := customerCart(c)
+	if !ok {
+		return err
+	}
+	if cart.OrderID != "" {
+		return checkedOut(c, cart)
+	}
+
+	// The lock keeps a checkout sent twice at once from placing two orders;
+	// one sent again later finds the cart checked out.
+	lockKey := cartKey(cart.ID) + ":checkout"
+	locked, err := s.redis.SetNX(reqCtx, lockKey, 1, s.cfg.OrderCheckTimeout+time.Minute).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check out cart"})
+	}
+	if !locked {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Cart is already being checked out"})
+	}
+	defer s.redis.Del(reqCtx, lockKey)
+
+	// A checkout may have finished before the lock was taken.
+	cart, ok, err = customerCart(c)
+	if !ok {
+		return err
+	}
+	if cart.OrderID != "" {
+		return checkedOut(c, cart)
+	}
+
+	orderID, err := cartOrderID(reqCtx, cart.ID)
+	if err != nil {
+		logger.Error("error choosing cart's order id", "cart_id", cart.ID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check out cart"})
+	}
+	if order, err := getOrder(reqCtx, orderID); err == nil {
+		// An earlier checkout placed the order but did not get to mark the
+		// cart.
+		return finishCheckout(c, cart, order)
+	} else if err != errOrderNotFound {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+
+	req.apply(&cart)
+	if len(cart.Items) == 0 {
+		return validationFailed(c, "items", "cart is empty")
+	}
+
+	order := cart.order()
+	if err := c.Validate(&order); err != nil {
+		return respondRequestError(c, err)
+	}
+	order, err = s.placeOrderAs(reqCtx, logger, authClaims(c), orderID, order)
+	if err != nil {
+		return respondServiceError(c, err)
+	}
+	return finishCheckout(c, cart, order)
+}
+
+// finishCheckout marks cart as checked out as order, and answers with the
+// order. If the cart cannot be marked the order is still answered with:
+// the next checkout finds it by its ID.
+func finishCheckout(c echo.Context, cart Cart, order Order) error {
+	logger := requestLogger(c)
+	cart.OrderID = order.OrderID
+	if err := saveCart(c.Request().Context(), &cart); err != nil {
+		logger.Error("error marking cart checked out", "cart_id", cart.ID, "order_id", order.OrderID, "error", err)
+	}
+	logger.Info("cart checked out", "cart_id", cart.ID, "order_id", order.OrderID)
+
+	tagOrderVersion(c, order.Version)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"cart_id":        cart.ID,
+		"order_id":       order.OrderID,
+		"order_code":     order.Code,
+		"status":         order.Status,
+		"payment_status": order.PaymentStatus,
+		"pricing":        order.Pricing,
+		"scheduled_at":   order.ScheduledAt,
+	})
+}
@@ -0,0 +1,847 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"myproject/src/events"
+)
+
+type Config struct {
+	HTTPAddr        string
+	GRPCAddr        string
+	LogLevel        string
+	LogFormat       string
+	Backend         string
+	RedisAddr       string
+	Store           string
+	DatabaseURL     string
+	StoreSeed       bool
+	KafkaBrokers    []string
+	ShutdownTimeout time.Duration
+	AppealContact   string
+	JWTSecret       string
+	PaymentProvider string
+	PaymentCurrency string
+	StripeSecretKey string
+	Location        *time.Location
+	// Region names the data residency region this deployment serves; see
+	// region.go.
+	Region string
+
+	// WorkerHTTPAddr is where the notification worker serves health
+	// checks and metrics.
+	WorkerHTTPAddr string
+	// PprofAddr is where the api and notification worker serve runtime
+	// profiles; see pprof.go. Unset, they serve none.
+	PprofAddr string
+	// ConsumerInAPI has the api consume order events as well, so that one
+	// process can run the whole service.
+	ConsumerInAPI bool
+
+	CacheWarmWorkers   int
+	FollowNotifyMax    int
+	FollowNotifyWindow time.Duration
+	IssueAutoCreditMax float64
+	MediaDir           string
+	MediaBaseURL       string
+
+	// Blob storage for uploaded media; see blob.go. MediaDir and
+	// MediaBaseURL are where the local store keeps and serves it.
+	BlobStore         string
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	// S3PublicURL is where clients fetch the bucket's objects, when not from
+	// the bucket itself.
+	S3PublicURL string
+	// MenuImageMaxBytes caps menu item image uploads.
+	MenuImageMaxBytes int64
+
+	// Archival of finished orders; see order_archive.go. An OrderRetention
+	// of 0 keeps orders in the store for good.
+	OrderRetention        time.Duration
+	OrderArchiveStore     string
+	OrderArchiveDir       string
+	OrderArchiveBucket    string
+	OrderArchiveInterval  time.Duration
+	OrderArchiveBatchSize int
+
+	TicketFirstResponseSLA time.Duration
+	TicketResolutionSLA    time.Duration
+
+	// TrackingTicketTTL is how long a ticket to open a tracking channel is
+	// good for, and TrackingMaxSubscriptions how many orders one WebSocket
+	// connection may follow at once; see tracking_channel.go.
+	TrackingTicketTTL        time.Duration
+	TrackingMaxSubscriptions int
+
+	// An order's chat keeps its last OrderChatMaxMessages messages until
+	// OrderChatRetention after the latest.
+	OrderChatMaxMessages int
+	OrderChatRetention   time.Duration
+
+	// CartTTL is how long a cart is kept after it last changed; see cart.go.
+	CartTTL time.Duration
+
+	// OrderVersionRequired has the routes changing an order refuse requests
+	// without the order's version in If-Match; see order_version.go.
+	OrderVersionRequired bool
+
+	// RequestSigningRequired has the rider and restaurant routes confirming
+	// an order's progress refuse unsigned requests, and signatures are good
+	// for RequestSignatureMaxSkew either side of their timestamp; see
+	// request_signing.go.
+	RequestSigningRequired  bool
+	RequestSignatureMaxSkew time.Duration
+
+	// JSONCasing is the casing of JSON keys, snake_case or camelCase, for
+	// callers that do not ask for one; JSONCasingByAPIKey sets it per
+	// X-API-Key. See json_casing.go.
+	JSONCasing         string
+	JSONCasingByAPIKey map[string]string
+
+	// HTTP middleware; see middleware.go. Each can be turned off. Bodies
+	// over BodyLimit, or UploadBodyLimit for multipart uploads, are
+	// refused; zero lifts the limit. HSTSMaxAge and ContentSecurityPolicy
+	// add those headers when set. Requests other than streams and exports
+	// must finish within RequestTimeout; zero lets them run on.
+	// X-Forwarded-For names the client only on requests from a proxy in
+	// TrustedProxies; see clientIPExtractor.
+	RecoverEnabled        bool
+	CORSEnabled           bool
+	CORSAllowOrigins      []string
+	CORSMaxAge            time.Duration
+	BodyLimit             int64
+	UploadBodyLimit       int64
+	GzipEnabled           bool
+	GzipLevel             int
+	GzipMinLength         int
+	SecureHeadersEnabled  bool
+	RequestTimeout        time.Duration
+	HSTSMaxAge            time.Duration
+	ContentSecurityPolicy string
+	TrustedProxies        []string
+
+	RestaurantGeofenceMeters float64
+	DeliveryGeofenceMeters   float64
+	RiderLocationTTL         time.Duration
+	RiderLocationHistory     int
+	RiderSpeedKmh            float64
+	DeliverySLA              time.Duration
+	ConsumerLagThreshold     int64
+	ConsumerMaxAttempts      int
+	ConsumerRetryBackoff     time.Duration
+	ConsumerWorkers          int
+	OutboxPollInterval       time.Duration
+
+	// Order events are buffered and written to Kafka in batches of up to
+	// ProducerBatchSize, at least every ProducerFlushInterval. Publishers
+	// wait once ProducerBufferSize events are buffered.
+	ProducerBufferSize    int
+	ProducerBatchSize     int
+	ProducerFlushInterval time.Duration
+	// EventSchemaVersion is the envelope version order events are published
+	// in; see events.Encode. Consumers read every version.
+	EventSchemaVersion int
+
+	// Restaurants commit to a ready-by time no more than OrderReadyByMax
+	// ahead when accepting. Riders are sought from DispatchReadyLead before
+	// it, and the order is late OrderReadyLateAfter after it.
+	OrderReadyByMax        time.Duration
+	DispatchReadyLead      time.Duration
+	OrderReadyLateAfter    time.Duration
+	ReadyCountdownInterval time.Duration
+
+	// DispatchStrategy is the Dispatcher used in zones without their own
+	// entry in DispatchZoneStrategies. Riders further than
+	// DispatchRadiusMeters from a restaurant are not offered its orders;
+	// zero offers them the whole zone.
+	DispatchStrategy       string
+	DispatchZoneStrategies map[string]string
+	DispatchInterval       time.Duration
+	DispatchOfferTTL       time.Duration
+	DispatchRadiusMeters   float64
+	// The nearest strategy looks for riders within
+	// DispatchSearchRadiusMeters of the restaurant first, widening the
+	// search DispatchSearchExpansion times over until it finds one free or
+	// reaches DispatchRadiusMeters.
+	DispatchSearchRadiusMeters float64
+	DispatchSearchExpansion    float64
+
+	DeliveryBaseFee       float64
+	DeliveryFeePerKm      float64
+	MaxDeliveryDistanceKm float64
+	RestaurantPrepTime    time.Duration
+	SurgeDemandRatio      float64
+	SurgeMultiplier       float64
+	TaxRate               float64
+
+	// FeeShare is how delivery fees are shared where neither the zone nor
+	// the restaurant's contract says otherwise; see fee_sharing.go.
+	FeeShare FeeShareRule
+
+	// Riders' earnings are paid out by RiderPayoutPeriod, day or week, once
+	// RiderPayoutDelay has passed since it closed, checking every
+	// RiderPayoutInterval; see rider_payouts.go.
+	RiderPayoutPeriod   string
+	RiderPayoutDelay    time.Duration
+	RiderPayoutInterval time.Duration
+
+	// Customers may add a tip for TipWindow after their order is delivered.
+	TipWindow time.Duration
+
+	// PickupItemsPerBag is how many food items the pickup checklist expects
+	// in one bag.
+	PickupItemsPerBag int
+
+	// MenuPriceApprovalThreshold is the price change, in percent, above which
+	// an owner must approve a new menu price before it is published.
+	MenuPriceApprovalThreshold float64
+
+	// MenuDescriptionMaxLength is the longest, in characters, a menu item's
+	// description may be in any language.
+	MenuDescriptionMaxLength int
+	// MenuRestrictedKeywords are words a published menu must not mention,
+	// such as products the platform may not sell.
+	MenuRestrictedKeywords []string
+	// MenuComplianceWarnings are the menu compliance checks that are
+	// reported but do not block publishing.
+	MenuComplianceWarnings []string
+
+	// MenuSuggestionLimit is the most items suggested after an item is
+	// added to the cart.
+	MenuSuggestionLimit int
+
+	// A new restaurant within DuplicateRestaurantRadiusMeters of a listed
+	// one, with a name at least DuplicateRestaurantSimilarity (0 to 1) alike,
+	// is taken for a duplicate.
+	DuplicateRestaurantRadiusMeters float64
+	DuplicateRestaurantSimilarity   float64
+
+	// OrderCheckTimeout bounds the lookups made before an order is priced.
+	OrderCheckTimeout time.Duration
+
+	JobQueueEnabled bool
+	JobLease        time.Duration
+	JobPollInterval time.Duration
+	JobMaxAttempts  int
+	OrderExpiry     time.Duration
+	OrderCodeTTL    time.Duration
+
+	// Orders may be scheduled from ScheduledOrderMinLead to
+	// ScheduledOrderMaxAhead ahead, and are released to the restaurant
+	// ScheduledOrderLead before they are due; see scheduled_orders.go.
+	ScheduledOrderMinLead      time.Duration
+	ScheduledOrderMaxAhead     time.Duration
+	ScheduledOrderLead         time.Duration
+	ScheduledOrderPollInterval time.Duration
+
+	// Orders not accepted within OrderExpiry of being placed are expired,
+	// and accepted orders without a rider after DeliveryEscalateAfter are
+	// escalated to support, by a sweep every OrderTimeoutInterval; see
+	// order_expiry.go. A zero DeliveryEscalateAfter turns escalation off.
+	OrderTimeoutInterval  time.Duration
+	DeliveryEscalateAfter time.Duration
+
+	// Compensating sagas retry a failed step after SagaRetryDelay, doubling
+	// each time, and give up on it after SagaMaxAttempts; due retries are
+	// run every SagaInterval. A rider who has not reached the restaurant
+	// RiderNoShowAfter after taking an order is replaced; zero turns that
+	// off. See saga.go.
+	SagaInterval     time.Duration
+	SagaRetryDelay   time.Duration
+	SagaMaxAttempts  int
+	RiderNoShowAfter time.Duration
+
+	// CancelGraceWindow is how long after placement a customer may cancel
+	// without the cancellation fee, the restaurant not yet having been
+	// offered the order; see cancel_grace.go. CancelGraceWindows overrides
+	// it per restaurant.
+	CancelGraceWindow      time.Duration
+	CancelGraceWindows     map[string]time.Duration
+	CancellationFeePercent float64
+
+	// EventTopics gives order event types topics of their own; types not
+	// listed, or listed with no topic, go to the orders topic. See
+	// topic_router.go.
+	EventTopics map[string]string
+
+	NotifyChannels     map[string][]string
+	NotifyMaxAttempts  int
+	NotifyRetryBackoff time.Duration
+
+	// Push notifications; see push.go. FCM is sent to when
+	// FCMCredentialsFile names a service account key file, APNs when
+	// APNsKeyFile names a .p8 key. A recipient's oldest devices are dropped
+	// past PushMaxDevices.
+	FCMCredentialsFile string
+	APNsKeyFile        string
+	APNsKeyID          string
+	APNsTeamID         string
+	APNsTopic          string
+	APNsSandbox        bool
+	PushMaxDevices     int
+
+	// FeatureFlags sets flags to on, off or a percentage of restaurants,
+	// such as surge_pricing=25%; see feature_flags.go. Overrides made at
+	// runtime are picked up every FeatureFlagRefreshInterval.
+	FeatureFlags               map[string]string
+	FeatureFlagRefreshInterval time.Duration
+
+	// A restaurant webhook that fails this many deliveries in a row is not
+	// tried again until the cooldown has passed.
+	RestaurantWebhookFailureThreshold int
+	RestaurantWebhookCooldown         time.Duration
+
+	// Each instance runs at most ExportMaxConcurrent order exports at once,
+	// each sending at most ExportRowsPerSecond orders a second; see
+	// order_export.go.
+	ExportMaxConcurrent int
+	ExportRowsPerSecond int
+
+	// The last WebhookDeliveryLogSize delivery attempts to each webhook
+	// subscription are kept for WebhookDeliveryLogTTL; see webhook_log.go.
+	WebhookDeliveryLogSize int
+	WebhookDeliveryLogTTL  time.Duration
+
+	// NotificationRetention is how long dispatched notifications are kept
+	// for inspection and retry.
+	NotificationRetention time.Duration
+
+	// Order events handled, and the notifications each has sent, are
+	// remembered for EventDedupTTL so redeliveries are not acted on twice.
+	EventDedupTTL time.Duration
+
+	// Requests are traced to TraceExporter, "none" or "stdout"; see
+	// tracing.go. TraceSampleRate of traces are kept, or the route's rate in
+	// TraceRouteSampleRates, keyed "GET /menu" or by gRPC method. With
+	// TraceTailSampling, traces that failed or took TraceSlowThreshold are
+	// kept too.
+	TraceExporter         string
+	TraceSampleRate       float64
+	TraceRouteSampleRates map[string]float64
+	TraceTailSampling     bool
+	TraceSlowThreshold    time.Duration
+
+	// Menus are cached for MenuCacheTTL, or the restaurant's entry in
+	// MenuCacheTTLs, give or take MenuCacheTTLJitter of it so entries cached
+	// together do not all expire together.
+	MenuCacheTTL       time.Duration
+	MenuCacheTTLs      map[string]time.Duration
+	MenuCacheTTLJitter float64
+
+	// MemoryBudgets caps, in bytes, what each Redis key family may use; see
+	// memory_budget.go.
+	MemoryBudgets        map[string]int64
+	MemoryBudgetInterval time.Duration
+
+	// Each client IP, and each API key, may make so many reads (GET) and
+	// writes (everything else) a minute, in bursts of up to the burst size;
+	// see ratelimit.go.
+	RateLimitEnabled        bool
+	RateLimitReadPerMinute  int
+	RateLimitReadBurst      int
+	RateLimitWritePerMinute int
+	RateLimitWriteBurst     int
+
+	// APIKeyRotationGrace is how long a rotated API key's old value keeps
+	// working; see apikeys.go.
+	APIKeyRotationGrace time.Duration
+
+	// After so many consecutive failures talking to Redis or Kafka, calls to
+	// it fail at once for the open period instead of waiting to time out;
+	// see breaker.go.
+	RedisBreakerFailures int
+	RedisBreakerOpenFor  time.Duration
+	KafkaBreakerFailures int
+	KafkaBreakerOpenFor  time.Duration
+
+	// Redis commands that fail transiently are retried up to
+	// RedisMaxRetries times, backing off from RedisRetryBackoff up to
+	// RedisRetryBackoffMax, and each attempt gets RedisOpTimeout; see
+	// redis_retry.go.
+	RedisMaxRetries      int
+	RedisRetryBackoff    time.Duration
+	RedisRetryBackoffMax time.Duration
+	RedisOpTimeout       time.Duration
+
+	// An alert is raised when its measure stays over threshold for
+	// AlertSustain, and not again for AlertCooldown; see alerting.go. The
+	// error rate counts only intervals with at least AlertMinRequests.
+	AlertCheckInterval  time.Duration
+	AlertSustain        time.Duration
+	AlertCooldown       time.Duration
+	AlertErrorRate      float64
+	AlertMinRequests    int
+	AlertConsumerLag    int64
+	PagerDutyRoutingKey string
+	PagerDutyURL        string
+	OpsgenieAPIKey      string
+	OpsgenieURL         string
+
+	// Free text written for another party is filtered by TextFilters; see
+	// text_filter.go. TextFilterMode is off, mask or reject.
+	TextFilters           []string
+	TextFilterMode        string
+	TextFilterBypassRoles []string
+	ProfanityWords        []string
+
+	Email            EmailSender
+	ReportRecipients []string
+	ReportHour       int
+}
+
+func loadConfig() Config {
+	return Config{
+		HTTPAddr:        getEnv("HTTP_ADDR", ":8080"),
+		GRPCAddr:        getEnv("GRPC_ADDR", ":9090"),
+		LogLevel:        getEnv("LOG_LEVEL", "info"),
+		LogFormat:       getEnv("LOG_FORMAT", "json"),
+		Backend:         getEnv("BACKEND", backendExternal),
+		RedisAddr:       getEnv("REDIS_ADDR", "localhost:6379"),
+		Store:           getEnv("STORE", storeRedis),
+		DatabaseURL:     getEnv("DATABASE_URL", ""),
+		StoreSeed:       getEnvBool("STORE_SEED", false),
+		KafkaBrokers:    strings.Split(getEnv("KAFKA_BROKERS", "localhost:9092"), ","),
+		ShutdownTimeout: getEnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
+		AppealContact:   getEnv("APPEAL_CONTACT", "support@example.com"),
+		JWTSecret:       getEnv("JWT_SECRET", ""),
+		PaymentProvider: getEnv("PAYMENT_PROVIDER", "mock"),
+		PaymentCurrency: getEnv("PAYMENT_CURRENCY", "usd"),
+		StripeSecretKey: getEnv("STRIPE_SECRET_KEY", ""),
+		Location:        getEnvLocation("TIMEZONE", time.UTC),
+		Region:          getEnv("REGION", ""),
+
+		WorkerHTTPAddr: getEnv("NOTIFICATION_WORKER_HTTP_ADDR", ":8082"),
+		PprofAddr:      getEnv("PPROF_ADDR", ""),
+		ConsumerInAPI:  getEnvBool("CONSUMER_IN_API", false),
+
+		CacheWarmWorkers:   getEnvInt("CACHE_WARM_WORKERS", 8),
+		FollowNotifyMax:    getEnvInt("FOLLOW_NOTIFY_MAX", 3),
+		FollowNotifyWindow: getEnvDuration("FOLLOW_NOTIFY_WINDOW", 24*time.Hour),
+		IssueAutoCreditMax: getEnvFloat("ISSUE_AUTO_CREDIT_MAX", 10),
+		MediaDir:           getEnv("MEDIA_DIR", "media"),
+		MediaBaseURL:       getEnv("MEDIA_BASE_URL", "/media"),
+
+		BlobStore:         getEnv("BLOB_STORE", blobLocal),
+		S3Endpoint:        getEnv("S3_ENDPOINT", ""),
+		S3Region:          getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:          getEnv("S3_BUCKET", ""),
+		S3AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
+		S3SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+		S3PublicURL:       getEnv("S3_PUBLIC_URL", ""),
+		MenuImageMaxBytes: getEnvByteSize("MENU_IMAGE_MAX_BYTES", "5MB"),
+
+		OrderRetention:        getEnvDuration("ORDER_RETENTION", 0),
+		OrderArchiveStore:     getEnv("ORDER_ARCHIVE_STORE", orderArchiveFile),
+		OrderArchiveDir:       getEnv("ORDER_ARCHIVE_DIR", "archive"),
+		OrderArchiveBucket:    getEnv("ORDER_ARCHIVE_BUCKET", ""),
+		OrderArchiveInterval:  getEnvDuration("ORDER_ARCHIVE_INTERVAL", time.Hour),
+		OrderArchiveBatchSize: getEnvInt("ORDER_ARCHIVE_BATCH_SIZE", 1000),
+
+		TicketFirstResponseSLA: getEnvDuration("TICKET_FIRST_RESPONSE_SLA", 4*time.Hour),
+		TicketResolutionSLA:    getEnvDuration("TICKET_RESOLUTION_SLA", 48*time.Hour),
+
+		TrackingTicketTTL:        getEnvDuration("TRACKING_TICKET_TTL", 30*time.Second),
+		TrackingMaxSubscriptions: getEnvInt("TRACKING_MAX_SUBSCRIPTIONS", 10),
+
+		OrderChatMaxMessages: getEnvInt("ORDER_CHAT_MAX_MESSAGES", 200),
+		OrderChatRetention:   getEnvDuration("ORDER_CHAT_RETENTION", 30*24*time.Hour),
+
+		CartTTL: getEnvDuration("CART_TTL", 24*time.Hour),
+
+		OrderVersionRequired: getEnvBool("ORDER_VERSION_REQUIRED", false),
+
+		RequestSigningRequired:  getEnvBool("REQUEST_SIGNING_REQUIRED", false),
+		RequestSignatureMaxSkew: getEnvDuration("REQUEST_SIGNATURE_MAX_SKEW", 5*time.Minute),
+
+		JSONCasing:         getEnv("JSON_CASING", "snake_case"),
+		JSONCasingByAPIKey: getEnvMap("JSON_CASING_API_KEYS", ""),
+
+		RecoverEnabled:        getEnvBool("RECOVER_ENABLED", true),
+		CORSEnabled:           getEnvBool("CORS_ENABLED", false),
+		CORSAllowOrigins:      getEnvList("CORS_ALLOW_ORIGINS", "*"),
+		CORSMaxAge:            getEnvDuration("CORS_MAX_AGE", 10*time.Minute),
+		BodyLimit:             getEnvByteSize("BODY_LIMIT", "1MB"),
+		UploadBodyLimit:       getEnvByteSize("UPLOAD_BODY_LIMIT", "11MB"),
+		GzipEnabled:           getEnvBool("GZIP_ENABLED", true),
+		GzipLevel:             getEnvInt("GZIP_LEVEL", -1),
+		GzipMinLength:         getEnvInt("GZIP_MIN_LENGTH", 1024),
+		SecureHeadersEnabled:  getEnvBool("SECURE_HEADERS_ENABLED", true),
+		RequestTimeout:        getEnvDuration("REQUEST_TIMEOUT", 30*time.Second),
+		TrustedProxies:        getEnvList("TRUSTED_PROXIES", ""),
+		HSTSMaxAge:            getEnvDuration("HSTS_MAX_AGE", 0),
+		ContentSecurityPolicy: getEnv("CONTENT_SECURITY_POLICY", ""),
+
+		RestaurantGeofenceMeters: getEnvFloat("RESTAURANT_GEOFENCE_METERS", 100),
+		DeliveryGeofenceMeters:   getEnvFloat("DELIVERY_GEOFENCE_METERS", 250),
+		RiderLocationTTL:         getEnvDuration("RIDER_LOCATION_TTL", 5*time.Minute),
+		RiderLocationHistory:     getEnvInt("RIDER_LOCATION_HISTORY", 20),
+		RiderSpeedKmh:            getEnvFloat("RIDER_SPEED_KMH", 20),
+		DeliverySLA:              getEnvDuration("DELIVERY_SLA", 45*time.Minute),
+		ConsumerLagThreshold:     int64(getEnvInt("CONSUMER_LAG_THRESHOLD", 1000)),
+		ConsumerMaxAttempts:      getEnvInt("CONSUMER_MAX_ATTEMPTS", 5),
+		ConsumerRetryBackoff:     getEnvDuration("CONSUMER_RETRY_BACKOFF", 200*time.Millisecond),
+		ConsumerWorkers:          getEnvInt("CONSUMER_WORKERS", 8),
+		OutboxPollInterval:       getEnvDuration("OUTBOX_POLL_INTERVAL", time.Second),
+
+		ProducerBufferSize:    getEnvInt("PRODUCER_BUFFER_SIZE", 1000),
+		ProducerBatchSize:     getEnvInt("PRODUCER_BATCH_SIZE", 100),
+		ProducerFlushInterval: getEnvDuration("PRODUCER_FLUSH_INTERVAL", 20*time.Millisecond),
+		EventSchemaVersion:    getEnvInt("EVENT_SCHEMA_VERSION", events.CurrentSchema),
+
+		OrderReadyByMax:        getEnvDuration("ORDER_READY_BY_MAX", 3*time.Hour),
+		DispatchReadyLead:      getEnvDuration("DISPATCH_READY_LEAD", 10*time.Minute),
+		OrderReadyLateAfter:    getEnvDuration("ORDER_READY_LATE_AFTER", 5*time.Minute),
+		ReadyCountdownInterval: getEnvDuration("READY_COUNTDOWN_INTERVAL", 15*time.Second),
+
+		DispatchStrategy:       getEnv("DISPATCH_STRATEGY", "nearest"),
+		DispatchZoneStrategies: getEnvMap("DISPATCH_ZONE_STRATEGIES", ""),
+		DispatchInterval:       getEnvDuration("DISPATCH_INTERVAL", 5*time.Second),
+		DispatchOfferTTL:       getEnvDuration("DISPATCH_OFFER_TTL", time.Minute),
+		DispatchRadiusMeters:   getEnvFloat("DISPATCH_RADIUS_METERS", 10000),
+
+		DispatchSearchRadiusMeters: getEnvFloat("DISPATCH_SEARCH_RADIUS_METERS", 2000),
+		DispatchSearchExpansion:    getEnvFloat("DISPATCH_SEARCH_EXPANSION", 2),
+
+		DeliveryBaseFee:       getEnvFloat("DELIVERY_BASE_FEE", 1.99),
+		DeliveryFeePerKm:      getEnvFloat("DELIVERY_FEE_PER_KM", 0.5),
+		MaxDeliveryDistanceKm: getEnvFloat("MAX_DELIVERY_DISTANCE_KM", 15),
+		RestaurantPrepTime:    getEnvDuration("RESTAURANT_PREP_TIME", 15*time.Minute),
+		SurgeDemandRatio:      getEnvFloat("SURGE_DEMAND_RATIO", 1.5),
+		SurgeMultiplier:       getEnvFloat("SURGE_MULTIPLIER", 1.5),
+		TaxRate:               getEnvFloat("TAX_RATE", 0.07),
+		PickupItemsPerBag:     getEnvInt("PICKUP_ITEMS_PER_BAG", 4),
+
+		FeeShare: FeeShareRule{
+			RiderPercent:           getEnvFloat("FEE_SHARE_RIDER_PERCENT", 80),
+			RestaurantPercent:      getEnvFloat("FEE_SHARE_RESTAURANT_PERCENT", 0),
+			SurgeRiderPercent:      getEnvFloat("FEE_SHARE_SURGE_RIDER_PERCENT", 100),
+			SurgeRestaurantPercent: getEnvFloat("FEE_SHARE_SURGE_RESTAURANT_PERCENT", 0),
+		},
+
+		RiderPayoutPeriod:   getEnv("RIDER_PAYOUT_PERIOD", payoutPeriodWeek),
+		RiderPayoutDelay:    getEnvDuration("RIDER_PAYOUT_DELAY", time.Hour),
+		RiderPayoutInterval: getEnvDuration("RIDER_PAYOUT_INTERVAL", 5*time.Minute),
+		TipWindow:           getEnvDuration("TIP_WINDOW", 72*time.Hour),
+
+		MenuPriceApprovalThreshold: getEnvFloat("MENU_PRICE_APPROVAL_THRESHOLD", 20),
+		MenuSuggestionLimit:        getEnvInt("MENU_SUGGESTION_LIMIT", 3),
+		MenuDescriptionMaxLength:   getEnvInt("MENU_DESCRIPTION_MAX_LENGTH", 300),
+		MenuRestrictedKeywords:     getEnvList("MENU_RESTRICTED_KEYWORDS", "cannabis,cbd,marijuana,tobacco,nicotine,vape"),
+		MenuComplianceWarnings:     getEnvList("MENU_COMPLIANCE_WARNINGS", "image_present"),
+
+		DuplicateRestaurantRadiusMeters: getEnvFloat("DUPLICATE_RESTAURANT_RADIUS_METERS", 250),
+		DuplicateRestaurantSimilarity:   getEnvFloat("DUPLICATE_RESTAURANT_SIMILARITY", 0.8),
+
+		OrderCheckTimeout: getEnvDuration("ORDER_CHECK_TIMEOUT", 3*time.Second),
+
+		JobQueueEnabled: getEnvBool("JOB_QUEUE_ENABLED", false),
+		JobLease:        getEnvDuration("JOB_LEASE", 30*time.Second),
+		JobPollInterval: getEnvDuration("JOB_POLL_INTERVAL", time.Second),
+		JobMaxAttempts:  getEnvInt("JOB_MAX_ATTEMPTS", 5),
+		OrderExpiry:     getEnvDuration("ORDER_EXPIRY", 15*time.Minute),
+		OrderCodeTTL:    getEnvDuration("ORDER_CODE_TTL", 7*24*time.Hour),
+
+		ScheduledOrderMinLead:      getEnvDuration("SCHEDULED_ORDER_MIN_LEAD", time.Hour),
+		ScheduledOrderMaxAhead:     getEnvDuration("SCHEDULED_ORDER_MAX_AHEAD", 7*24*time.Hour),
+		ScheduledOrderLead:         getEnvDuration("SCHEDULED_ORDER_LEAD", 45*time.Minute),
+		ScheduledOrderPollInterval: getEnvDuration("SCHEDULED_ORDER_POLL_INTERVAL", 15*time.Second),
+
+		OrderTimeoutInterval:  getEnvDuration("ORDER_TIMEOUT_INTERVAL", 30*time.Second),
+		DeliveryEscalateAfter: getEnvDuration("DELIVERY_ESCALATE_AFTER", 20*time.Minute),
+
+		SagaInterval:     getEnvDuration("SAGA_INTERVAL", 15*time.Second),
+		SagaRetryDelay:   getEnvDuration("SAGA_RETRY_DELAY", 30*time.Second),
+		SagaMaxAttempts:  getEnvInt("SAGA_MAX_ATTEMPTS", 8),
+		RiderNoShowAfter: getEnvDuration("RIDER_NO_SHOW_AFTER", 20*time.Minute),
+
+		CancelGraceWindow:      getEnvDuration("CANCEL_GRACE_WINDOW", 0),
+		CancelGraceWindows:     getEnvDurations("CANCEL_GRACE_WINDOWS", ""),
+		CancellationFeePercent: getEnvFloat("CANCELLATION_FEE_PERCENT", 0),
+
+		EventTopics: map[string]string{
+			eventOrderCreated:   getEnv("EVENT_TOPIC_ORDER_CREATED", "orders.created"),
+			eventOrderAccepted:  getEnv("EVENT_TOPIC_ORDER_ACCEPTED", "orders.accepted"),
+			eventOrderPickedUp:  getEnv("EVENT_TOPIC_ORDER_PICKED_UP", "orders.picked_up"),
+			eventOrderDelivered: getEnv("EVENT_TOPIC_ORDER_DELIVERED", "orders.delivered"),
+		},
+
+		NotifyChannels: map[string][]string{
+			"customer":    getEnvList("NOTIFY_CHANNELS_CUSTOMER", "email"),
+			"restaurant":  getEnvList("NOTIFY_CHANNELS_RESTAURANT", "webhook,email"),
+			"rider":       getEnvList("NOTIFY_CHANNELS_RIDER", "webhook"),
+			"owner":       getEnvList("NOTIFY_CHANNELS_OWNER", "email"),
+			recipientGift: getEnvList("NOTIFY_CHANNELS_GIFT_RECIPIENT", "email"),
+		},
+		NotifyMaxAttempts:  getEnvInt("NOTIFY_MAX_ATTEMPTS", 3),
+		NotifyRetryBackoff: getEnvDuration("NOTIFY_RETRY_BACKOFF", 500*time.Millisecond),
+
+		FCMCredentialsFile: getEnv("FCM_CREDENTIALS_FILE", ""),
+		APNsKeyFile:        getEnv("APNS_KEY_FILE", ""),
+		APNsKeyID:          getEnv("APNS_KEY_ID", ""),
+		APNsTeamID:         getEnv("APNS_TEAM_ID", ""),
+		APNsTopic:          getEnv("APNS_TOPIC", ""),
+		APNsSandbox:        getEnvBool("APNS_SANDBOX", false),
+		PushMaxDevices:     getEnvInt("PUSH_MAX_DEVICES", 5),
+
+		FeatureFlags:               getEnvMap("FEATURE_FLAGS", ""),
+		FeatureFlagRefreshInterval: getEnvDuration("FEATURE_FLAG_REFRESH_INTERVAL", 10*time.Second),
+
+		RestaurantWebhookFailureThreshold: getEnvInt("RESTAURANT_WEBHOOK_FAILURE_THRESHOLD", 5),
+		RestaurantWebhookCooldown:         getEnvDuration("RESTAURANT_WEBHOOK_COOLDOWN", time.Minute),
+
+		ExportMaxConcurrent: getEnvInt("EXPORT_MAX_CONCURRENT", 2),
+		ExportRowsPerSecond: getEnvInt("EXPORT_ROWS_PER_SECOND", 5000),
+
+		WebhookDeliveryLogSize: getEnvInt("WEBHOOK_DELIVERY_LOG_SIZE", 100),
+		WebhookDeliveryLogTTL:  getEnvDuration("WEBHOOK_DELIVERY_LOG_TTL", 7*24*time.Hour),
+
+		NotificationRetention: getEnvDuration("NOTIFICATION_RETENTION", 7*24*time.Hour),
+		EventDedupTTL:         getEnvDuration("EVENT_DEDUP_TTL", 7*24*time.Hour),
+
+		TraceExporter:         getEnv("TRACE_EXPORTER", traceExporterNone),
+		TraceSampleRate:       getEnvFloat("TRACE_SAMPLE_RATE", 0.05),
+		TraceRouteSampleRates: getEnvFloats("TRACE_ROUTE_SAMPLE_RATES", "GET /healthz=0,GET /readyz=0,GET /metrics=0"),
+		TraceTailSampling:     getEnvBool("TRACE_TAIL_SAMPLING", true),
+		TraceSlowThreshold:    getEnvDuration("TRACE_SLOW_THRESHOLD", 2*time.Second),
+
+		MenuCacheTTL:       getEnvDuration("MENU_CACHE_TTL", time.Hour),
+		MenuCacheTTLs:      getEnvDurations("MENU_CACHE_TTLS", ""),
+		MenuCacheTTLJitter: getEnvFloat("MENU_CACHE_TTL_JITTER", 0.1),
+
+		MemoryBudgets:        getEnvByteSizes("MEMORY_BUDGETS", "menus=200MB"),
+		MemoryBudgetInterval: getEnvDuration("MEMORY_BUDGET_INTERVAL", 5*time.Minute),
+
+		RateLimitEnabled:        getEnvBool("RATE_LIMIT_ENABLED", true),
+		RateLimitReadPerMinute:  getEnvInt("RATE_LIMIT_READ_PER_MINUTE", 600),
+		RateLimitReadBurst:      getEnvInt("RATE_LIMIT_READ_BURST", 100),
+		RateLimitWritePerMinute: getEnvInt("RATE_LIMIT_WRITE_PER_MINUTE", 60),
+		RateLimitWriteBurst:     getEnvInt("RATE_LIMIT_WRITE_BURST", 20),
+
+		APIKeyRotationGrace: getEnvDuration("API_KEY_ROTATION_GRACE", 24*time.Hour),
+
+		RedisBreakerFailures: getEnvInt("REDIS_BREAKER_FAILURES", 5),
+		RedisBreakerOpenFor:  getEnvDuration("REDIS_BREAKER_OPEN_FOR", 5*time.Second),
+		KafkaBreakerFailures: getEnvInt("KAFKA_BREAKER_FAILURES", 3),
+		KafkaBreakerOpenFor:  getEnvDuration("KAFKA_BREAKER_OPEN_FOR", 30*time.Second),
+
+		RedisMaxRetries:      getEnvInt("REDIS_MAX_RETRIES", 3),
+		RedisRetryBackoff:    getEnvDuration("REDIS_RETRY_BACKOFF", 10*time.Millisecond),
+		RedisRetryBackoffMax: getEnvDuration("REDIS_RETRY_BACKOFF_MAX", 500*time.Millisecond),
+		RedisOpTimeout:       getEnvDuration("REDIS_OP_TIMEOUT", 2*time.Second),
+
+		AlertCheckInterval:  getEnvDuration("ALERT_CHECK_INTERVAL", time.Minute),
+		AlertSustain:        getEnvDuration("ALERT_SUSTAIN", 5*time.Minute),
+		AlertCooldown:       getEnvDuration("ALERT_COOLDOWN", 30*time.Minute),
+		AlertErrorRate:      getEnvFloat("ALERT_ERROR_RATE", 0.05),
+		AlertMinRequests:    getEnvInt("ALERT_MIN_REQUESTS", 20),
+		AlertConsumerLag:    int64(getEnvInt("ALERT_CONSUMER_LAG", getEnvInt("CONSUMER_LAG_THRESHOLD", 1000))),
+		PagerDutyRoutingKey: getEnv("PAGERDUTY_ROUTING_KEY", ""),
+		PagerDutyURL:        getEnv("PAGERDUTY_EVENTS_URL", pagerDutyEventURL),
+		OpsgenieAPIKey:      getEnv("OPSGENIE_API_KEY", ""),
+		OpsgenieURL:         getEnv("OPSGENIE_ALERTS_URL", opsgenieAlertURL),
+
+		TextFilters:           getEnvList("TEXT_FILTERS", "profanity,pii"),
+		TextFilterMode:        getEnv("TEXT_FILTER_MODE", textFilterMask),
+		TextFilterBypassRoles: getEnvList("TEXT_FILTER_BYPASS_ROLES", roleAdmin),
+		ProfanityWords:        getEnvList("PROFANITY_WORDS", ""),
+
+		Email: EmailSender{
+			Addr:     getEnv("SMTP_ADDR", ""),
+			From:     getEnv("SMTP_FROM", "noreply@example.com"),
+			Username: getEnv("SMTP_USERNAME", ""),
+			Password: getEnv("SMTP_PASSWORD", ""),
+		},
+		ReportRecipients: getEnvList("REPORT_RECIPIENTS", ""),
+		ReportHour:       getEnvInt("REPORT_HOUR", 1),
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("invalid duration in environment, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return d
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		slog.Warn("invalid number in environment, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return f
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("invalid integer in environment, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return i
+}
+
+func getEnvList(key, fallback string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, fallback), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvMap parses a comma-separated list of key=value pairs, e.g.
+// "north=batch,south=round_robin". Malformed pairs are skipped.
+func getEnvMap(key, fallback string) map[string]string {
+	m := map[string]string{}
+	for _, item := range getEnvList(key, fallback) {
+		k, v, ok := strings.Cut(item, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" || v == "" {
+			slog.Warn("invalid key=value pair in environment, skipping", "key", key, "value", item)
+			continue
+		}
+		m[k] = v
+	}
+	return m
+}
+
+// getEnvByteSize parses a size such as "1MB"; see parseByteSize.
+func getEnvByteSize(key, fallback string) int64 {
+	n, err := parseByteSize(getEnv(key, fallback))
+	if err != nil {
+		slog.Warn("invalid size in environment, using default", "key", key, "value", os.Getenv(key), "default", fallback)
+		n, _ = parseByteSize(fallback)
+	}
+	return n
+}
+
+// getEnvByteSizes parses key=size pairs such as "menus=200MB,orders=1GB".
+// Pairs whose size does not parse are skipped.
+func getEnvByteSizes(key, fallback string) map[string]int64 {
+	sizes := map[string]int64{}
+	for k, v := range getEnvMap(key, fallback) {
+		n, err := parseByteSize(v)
+		if err != nil {
+			slog.Warn("invalid size in environment, skipping", "key", key, "name", k, "value", v)
+			continue
+		}
+		sizes[k] = n
+	}
+	return sizes
+}
+
+// getEnvDurations parses key=duration pairs such as "r1=30s,r2=2m". Pairs
+// whose duration does not parse are skipped.
+func getEnvDurations(key, fallback string) map[string]time.Duration {
+	durations := map[string]time.Duration{}
+	for k, v := range getEnvMap(key, fallback) {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			slog.Warn("invalid duration in environment, skipping", "key", key, "name", k, "value", v)
+			continue
+		}
+		durations[k] = d
+	}
+	return durations
+}
+
+// getEnvFloats parses key=number pairs such as "GET /menu=0.5". Pairs whose
+// number does not parse are skipped.
+func getEnvFloats(key, fallback string) map[string]float64 {
+	floats := map[string]float64{}
+	for k, v := range getEnvMap(key, fallback) {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			slog.Warn("invalid number in environment, skipping", "key", key, "name", k, "value", v)
+			continue
+		}
+		floats[k] = f
+	}
+	return floats
+}
+
+// parseByteSize reads a size in bytes, optionally suffixed KB, MB or GB
+// (powers of 1024).
+func parseByteSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * multiplier, nil
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("invalid boolean in environment, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return b
+}
+
+func getEnvLocation(key string, fallback *time.Location) *time.Location {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+
+	loc, err := time.LoadLocation(value)
+	if err != nil {
+		slog.Warn("invalid time zone in environment, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return loc
+}
@@ -0,0 +1,395 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"myproject/src/handlers"
+)
+
+const (
+	// consumerRetryDelay is how long the consumer waits before reading
+	// again after the broker returns an error, or before reconnecting.
+	consumerRetryDelay = 5 * time.Second
+	// consumerMaxReadFailures is how many reads in a row may fail before
+	// the reader is replaced.
+	consumerMaxReadFailures = 5
+
+	consumerGroup = "notification-service-group"
+)
+
+type orderEventHandler func(ctx context.Context, event OrderEvent) error
+
+// orderEventHandlers routes each event type to its notification fan-out and,
+// for orders that can no longer be fulfilled, the saga that compensates for
+// them. Types without a handler are acknowledged and skipped.
+var orderEventHandlers = map[string]orderEventHandler{
+	eventOrderPaid:      allOf(notifyOrderPaid, notifyGiftReceipt),
+	eventOrderAccepted:  allOf(notifyOrderAccepted, queueWhenNearlyReady),
+	eventOrderRejected:  compensate,
+	eventOrderCancelled: compensate,
+	eventOrderExpired:   compensate,
+	eventOrderTimedOut:  notifyOrderTimedOut,
+	eventOrderDelivered: allOf(notifyOrderDelivered, recordDeliveryLedger),
+	eventOrderRefunded:  notifyOrderRefunded,
+
+	eventRiderAssigned:    watchRiderPickup,
+	eventOrderReadySoon:   queueForDispatch,
+	eventOrderRunningLate: notifyOrderRunningLate,
+	eventOrderTipped:      recordTipLedger,
+	eventRiderArriving:    notifyRiderArriving,
+}
+
+// allOf runs every handler, even after one fails, and joins their errors.
+// A retried event reruns all of them, so each must be safe to repeat.
+func allOf(handlers ...orderEventHandler) orderEventHandler {
+	return func(ctx context.Context, event OrderEvent) error {
+		errs := make([]error, 0, len(handlers))
+		for _, handler := range handlers {
+			errs = append(errs, handler(ctx, event))
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// Consumer consumes a set of topics, such as every topic order events are
+// published to, as a member of one consumer group, from Start until Stop.
+// Each topic has a reader of its own in the group, so a backlog on one does
+// not hold up the others. A reader that keeps failing is closed and
+// replaced, so the consumer outlives a broker restart.
+type Consumer struct {
+	groupID string
+	topics  []string
+	handle  func(ctx context.Context, msg kafka.Message)
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewConsumer returns a consumer handing each message read from topics in
+// groupID to handle.
+func NewConsumer(groupID string, topics []string, handle func(ctx context.Context, msg kafka.Message)) *Consumer {
+	return &Consumer{groupID: groupID, topics: topics, handle: handle}
+}
+
+// Start consumes in the background until ctx is cancelled or Stop is
+// called.
+func (c *Consumer) Start(ctx context.Context) {
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+	slog.Info("consumer starting", "group", c.groupID, "topics", c.topics)
+
+	go func() {
+		defer close(c.done)
+		var wg sync.WaitGroup
+		for _, topic := range c.topics {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.consumeTopic(ctx, topic)
+			}()
+		}
+		wg.Wait()
+		slog.Info("consumer stopped", "group", c.groupID)
+	}()
+}
+
+// Stop stops reading and waits, until ctx is done, for the messages being
+// handled to be finished with and the readers closed.
+func (c *Consumer) Stop(ctx context.Context) error {
+	c.cancel()
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// consumeTopic reads topic until ctx is cancelled, opening a new reader
+// whenever the last one gives up.
+func (c *Consumer) consumeTopic(ctx context.Context, topic string) {
+	for {
+		err := c.readTopic(ctx, topic)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("reconnecting to topic", "topic", topic, "group", c.groupID, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(consumerRetryDelay):
+		}
+	}
+}
+
+// readTopic reads topic with a reader of its own until ctx is cancelled or
+// consumerMaxReadFailures reads in a row fail, handling each order's events
+// in turn and different orders' at once; see consumer_pool.go. Failing
+// events are retried and then dead-lettered, so one bad message cannot
+// stall the partition or take the process down. The messages being handled
+// are finished with before the reader is closed.
+func (c *Consumer) readTopic(ctx context.Context, topic string) error {
+	r := bus.GroupReader(topic, c.groupID)
+	defer func() {
+		if err := r.Close(); err != nil {
+			slog.Error("error closing reader", "topic", topic, "error", err)
+		}
+	}()
+	offsets := newOffsetTracker(r)
+	pool := newKeyedPool(ctx, appConfig.ConsumerWorkers, offsets, c.handle)
+	defer func() {
+		pool.stop()
+		slog.Info("stopped reading topic", "topic", topic, "group", c.groupID, "committed", offsets.committedOffsets())
+	}()
+
+	// partitions notes those read from so far, to log the offset reading
+	// starts at on each, as assigned to this member of the group.
+	partitions := map[int]bool{}
+	failures := 0
+	lagging := false
+	for {
+		msg, err := r.FetchMessage(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			failures++
+			slog.Error("error reading message", "topic", topic, "failures", failures, "error", err)
+			if failures >= consumerMaxReadFailures {
+				return fmt.Errorf("%d reads in a row failed: %w", failures, err)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(consumerRetryDelay):
+			}
+			continue
+		}
+		failures = 0
+		if !partitions[msg.Partition] {
+			partitions[msg.Partition] = true
+			slog.Info("reading partition", "topic", topic, "partition", msg.Partition, "offset", msg.Offset, "high_water_mark", msg.HighWaterMark)
+		}
+
+		lag := msg.HighWaterMark - msg.Offset - 1
+		kafkaConsumerLag.WithLabelValues(msg.Topic, strconv.Itoa(msg.Partition)).Set(float64(lag))
+		recordConsumerLag(msg.Topic, msg.Partition, lag)
+
+		// Count an incident only when lag crosses the threshold, not for every
+		// message consumed while it stays above it.
+		if lag > appConfig.ConsumerLagThreshold && !lagging {
+			recordDailyStat(ctx, redisClient, statLagIncidents)
+		}
+		lagging = lag > appConfig.ConsumerLagThreshold
+
+		offsets.started(msg)
+		if !pool.submit(ctx, msg) {
+			return ctx.Err()
+		}
+	}
+}
+
+func handleOrderMessage(ctx context.Context, msg kafka.Message) {
+	event, err := decodeOrderEvent(msg.Value)
+	if err == nil && event.Type == "" {
+		err = errors.New("event has no type")
+	}
+	if err == nil && event.Region != "" && event.Region != appConfig.Region {
+		err = fmt.Errorf("event belongs to region %q, not %q", event.Region, appConfig.Region)
+	}
+	if err != nil {
+		deadLetter(ctx, msg, "unknown", 1, permanent(err))
+		return
+	}
+
+	// The request behind the event is traced on to what the event causes in
+	// turn: notifications, refunds and further events.
+	if requestID := messageRequestID(msg); requestID != "" {
+		event.RequestID = requestID
+	}
+	ctx = handlers.WithRequestID(ctx, event.RequestID)
+	logger := eventLogger(event)
+
+	// A redelivered event already handled is skipped; see event_dedup.go.
+	// If that cannot be checked it is handled again, and only its
+	// notifications are kept from repeating.
+	processed, err := eventProcessed(ctx, event)
+	if err != nil {
+		logger.Warn("error checking whether order event was processed", "error", err)
+	}
+	if processed {
+		orderEventsConsumed.WithLabelValues(event.Type, "duplicate").Inc()
+		logger.Info("skipping order event already processed")
+		return
+	}
+
+	publishTrackingUpdate(ctx, event)
+	removeFromKitchen(ctx, event)
+	deliverOrderEventWebhooks(ctx, event)
+
+	// A newly paid order scheduled for later, or still in its cancel grace
+	// window, is offered to the restaurant later, by releaseScheduledOrder or
+	// offerHeldOrder.
+	if holdScheduledOrder(ctx, event) || holdForCancelGrace(ctx, event) {
+		orderEventsConsumed.WithLabelValues(event.Type, "held").Inc()
+		markEventProcessed(ctx, event)
+		return
+	}
+	offerToRestaurant(ctx, event)
+
+	handler, ok := orderEventHandlers[event.Type]
+	if !ok {
+		orderEventsConsumed.WithLabelValues(event.Type, "skipped").Inc()
+		logger.Debug("no handler for order event")
+		markEventProcessed(ctx, event)
+		return
+	}
+
+	attempts, err := processWithRetry(ctx, handler, event)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		deadLetter(ctx, msg, event.Type, attempts, err)
+		return
+	}
+
+	markEventProcessed(ctx, event)
+	orderEventsConsumed.WithLabelValues(event.Type, "success").Inc()
+	logger.Info("order event processed", "attempts", attempts)
+}
+
+// processWithRetry runs handler with exponential backoff between attempts.
+// Permanent errors are not retried.
+func processWithRetry(ctx context.Context, handler orderEventHandler, event OrderEvent) (int, error) {
+	backoff := appConfig.ConsumerRetryBackoff
+	var err error
+	for attempt := 1; attempt <= appConfig.ConsumerMaxAttempts; attempt++ {
+		err = handler(ctx, event)
+		if err == nil || isPermanent(err) {
+			return attempt, err
+		}
+		if attempt == appConfig.ConsumerMaxAttempts {
+			break
+		}
+
+		eventLogger(event).Warn("retrying order event", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return appConfig.ConsumerMaxAttempts, err
+}
+
+func deadLetter(ctx context.Context, msg kafka.Message, eventType string, attempts int, cause error) {
+	orderEventsConsumed.WithLabelValues(eventType, "dead_lettered").Inc()
+	logger := slog.Default()
+	if requestID := messageRequestID(msg); requestID != "" {
+		logger = logger.With("request_id", requestID)
+	}
+	logger.Error("dead-lettering order event", "type", eventType, "partition", msg.Partition, "offset", msg.Offset, "attempts", attempts, "error", cause)
+
+	err := publishToDLQ(ctx, msg, eventType, attempts, cause)
+	if err != nil {
+		logger.Error("error publishing to dlq, event dropped", "type", eventType, "partition", msg.Partition, "offset", msg.Offset, "error", err)
+	}
+}
+
+// notifyOrderPaid tells the restaurant about a new order. Orders are only
+// offered to the restaurant once paid.
+func notifyOrderPaid(ctx context.Context, event OrderEvent) error {
+	return notifyParty(ctx, event, "restaurant", event.RestaurantID, "order_paid", map[string]string{
+		"total": fmt.Sprintf("%.2f", event.TotalAmount),
+	})
+}
+
+func notifyOrderAccepted(ctx context.Context, event OrderEvent) error {
+	return notifyTracking(ctx, event, "order_accepted", "gift_accepted", nil)
+}
+
+// notifyRiderArriving tells whoever is receiving the order to look out for
+// the rider.
+func notifyRiderArriving(ctx context.Context, event OrderEvent) error {
+	return notifyTracking(ctx, event, "rider_arriving", "", nil)
+}
+
+// notifyOrderRefunded tells the customer who paid, even for a gift, what
+// was given back.
+func notifyOrderRefunded(ctx context.Context, event OrderEvent) error {
+	return notifyParty(ctx, event, "customer", event.CustomerID, "order_refunded", map[string]string{
+		"amount": fmt.Sprintf("%.2f", event.RefundAmount),
+	})
+}
+
+func notifyOrderRejected(ctx context.Context, event OrderEvent) error {
+	return notifyParty(ctx, event, "customer", event.CustomerID, "order_rejected", map[string]string{
+		"reason": event.Reason,
+	})
+}
+
+func notifyOrderDelivered(ctx context.Context, event OrderEvent) error {
+	return errors.Join(
+		notifyTracking(ctx, event, "order_delivered_customer", "", nil),
+		notifyParty(ctx, event, "restaurant", event.RestaurantID, "order_delivered_restaurant", nil),
+		notifyGiftDelivered(ctx, event),
+	)
+}
+
+// notifyParty sends one notification about event from the named template;
+// order_ref is always available to it alongside vars. Per-channel failures
+// are recorded by dispatchNotification for retry; only failing to render or
+// to reach the contact store at all is reported as an error. A notification
+// already sent for event is not sent again.
+func notifyParty(ctx context.Context, event OrderEvent, recipientType, recipientID, template string, vars map[string]string) error {
+	if recipientID == "" {
+		return permanent(fmt.Errorf("%s event has no %s id", event.Type, recipientType))
+	}
+
+	claimed, err := claimNotification(ctx, event, recipientType, recipientID, template)
+	if err != nil {
+		return fmt.Errorf("notify %s %s: %w", recipientType, recipientID, err)
+	}
+	if !claimed {
+		eventLogger(event).Info("notification already sent for order event", "recipient_type", recipientType, "template", template)
+		return nil
+	}
+
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	vars["order_ref"] = orderReference(event.OrderID, event.OrderCode)
+
+	_, err = dispatchNotification(ctx, redisClient, Notification{
+		RecipientType: recipientType,
+		RecipientID:   recipientID,
+		OrderID:       event.OrderID,
+		OrderCode:     event.OrderCode,
+		RequestID:     event.RequestID,
+		Template:      template,
+		Vars:          vars,
+	})
+	if err != nil {
+		releaseNotification(ctx, event, recipientType, recipientID, template)
+		return fmt.Errorf("notify %s %s: %w", recipientType, recipientID, err)
+	}
+	return nil
+}
+
+// orderReference is how an order is named to people: by its short code, or
+// by ID for orders placed before codes existed.
+func orderReference(orderID, code string) string {
+	if code != "" {
+		return code
+	}
+	return orderID
+}
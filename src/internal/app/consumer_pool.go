@@ -0,0 +1,141 @@
+package app
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"maps"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Per-order processing. The consumer reads each topic in one goroutine but
+// hands messages to ConsumerWorkers workers. Messages with the same key, that
+// is for the same order, always go to the same worker, so they are handled
+// one at a time in the order they were read; messages for different orders
+// are handled side by side. Offsets are committed only up to the earliest
+// message not yet handled on each partition, so whatever was in flight when
+// the consumer stopped is read again and nothing is skipped.
+
+// consumerQueueSize is how many messages may wait for each worker before
+// the reader stops fetching.
+const consumerQueueSize = 16
+
+// keyedPool runs handle on messages, sequentially per key.
+type keyedPool struct {
+	queues []chan kafka.Message
+	wg     sync.WaitGroup
+}
+
+// newKeyedPool starts workers that handle messages and then commit them
+// through offsets. A message still being handled when ctx is cancelled, or
+// not yet started, is left uncommitted.
+func newKeyedPool(ctx context.Context, workers int, offsets *offsetTracker, handle func(ctx context.Context, msg kafka.Message)) *keyedPool {
+	p := &keyedPool{queues: make([]chan kafka.Message, max(workers, 1))}
+	for i := range p.queues {
+		queue := make(chan kafka.Message, consumerQueueSize)
+		p.queues[i] = queue
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for msg := range queue {
+				if ctx.Err() != nil {
+					continue
+				}
+				handle(ctx, msg)
+				if ctx.Err() != nil {
+					continue
+				}
+				offsets.finished(msg)
+			}
+		}()
+	}
+	return p
+}
+
+// submit queues msg on its key's worker, waiting for room unless ctx is
+// cancelled first.
+func (p *keyedPool) submit(ctx context.Context, msg kafka.Message) bool {
+	h := fnv.New32a()
+	h.Write(msg.Key)
+	select {
+	case p.queues[h.Sum32()%uint32(len(p.queues))] <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// stop waits for the workers to finish what they are doing.
+func (p *keyedPool) stop() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+// offsetTracker commits a reader's messages in offset order per partition,
+// however out of order they finish.
+type offsetTracker struct {
+	r  messageReader
+	mu sync.Mutex
+	// inFlight is each partition's messages read and not yet committed, in
+	// offset order.
+	inFlight map[int][]int64
+	done     map[int]map[int64]bool
+	// committed is each partition's last offset committed.
+	committed map[int]int64
+}
+
+func newOffsetTracker(r messageReader) *offsetTracker {
+	return &offsetTracker{r: r, inFlight: map[int][]int64{}, done: map[int]map[int64]bool{}, committed: map[int]int64{}}
+}
+
+// started notes msg as read, before it is handed to a worker.
+func (t *offsetTracker) started(msg kafka.Message) {
+	t.mu.Lock()
+	t.inFlight[msg.Partition] = append(t.inFlight[msg.Partition], msg.Offset)
+	t.mu.Unlock()
+}
+
+// finished notes msg as handled and commits every message of its partition
+// up to the first still being handled. The commit is made under the lock so
+// commits for a partition are never reordered.
+func (t *offsetTracker) finished(msg kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	done := t.done[msg.Partition]
+	if done == nil {
+		done = map[int64]bool{}
+		t.done[msg.Partition] = done
+	}
+	done[msg.Offset] = true
+
+	inFlight := t.inFlight[msg.Partition]
+	committable := 0
+	for committable < len(inFlight) && done[inFlight[committable]] {
+		delete(done, inFlight[committable])
+		committable++
+	}
+	if committable == 0 {
+		return
+	}
+	last := inFlight[committable-1]
+	t.inFlight[msg.Partition] = inFlight[committable:]
+
+	upTo := kafka.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: last}
+	if err := t.r.CommitMessages(context.Background(), upTo); err != nil {
+		slog.Error("error committing message", "topic", msg.Topic, "partition", msg.Partition, "offset", last, "error", err)
+		return
+	}
+	t.committed[msg.Partition] = last
+}
+
+// committedOffsets returns each partition's last offset committed.
+func (t *offsetTracker) committedOffsets() map[int]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return maps.Clone(t.committed)
+}
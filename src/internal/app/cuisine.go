@@ -0,0 +1,181 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+// The taxonomy is a hash of slug -> display name. Assignments are kept in
+// both directions so listing by cuisine is a set lookup rather than a scan.
+const cuisineTaxonomyKey = "cuisine:taxonomy"
+
+type Cuisine struct {
+	Slug string `json:"slug" validate:"required,max=40,lowercase"`
+	Name string `json:"name" validate:"required,max=80"`
+}
+
+type AssignCuisinesRequest struct {
+	Cuisines []string `json:"cuisines" validate:"max=10,dive,required"`
+}
+
+func restaurantCuisinesKey(restaurantID string) string {
+	return "restaurant:" + restaurantID + ":cuisines"
+}
+
+func cuisineRestaurantsKey(slug string) string {
+	return "cuisine:" + slug + ":restaurants"
+}
+
+func listCuisines(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	taxonomy, err := redisClient.HGetAll(ctx, cuisineTaxonomyKey).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch cuisines"})
+	}
+
+	cuisines := make([]Cuisine, 0, len(taxonomy))
+	for slug, name := range taxonomy {
+		cuisines = append(cuisines, Cuisine{Slug: slug, Name: name})
+	}
+	sort.Slice(cuisines, func(i, j int) bool { return cuisines[i].Slug < cuisines[j].Slug })
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"cuisines": cuisines})
+}
+
+func upsertCuisine(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req Cuisine
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	err := redisClient.HSet(ctx, cuisineTaxonomyKey, req.Slug, req.Name).Err()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store cuisine"})
+	}
+
+	return c.JSON(http.StatusOK, req)
+}
+
+func deleteCuisine(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	slug := c.Param("slug")
+
+	restaurantIDs, err := redisClient.SMembers(ctx, cuisineRestaurantsKey(slug)).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete cuisine"})
+	}
+
+	pipe := redisClient.TxPipeline()
+	removed := pipe.HDel(ctx, cuisineTaxonomyKey, slug)
+	for _, restaurantID := range restaurantIDs {
+		pipe.SRem(ctx, restaurantCuisinesKey(restaurantID), slug)
+	}
+	pipe.Del(ctx, cuisineRestaurantsKey(slug))
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete cuisine"})
+	}
+	if removed.Val() == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Cuisine not found"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func assignRestaurantCuisines(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	claims := authClaims(c)
+	if claims.Role != roleAdmin && !actsForRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	var req AssignCuisinesRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	unknown, err := unknownCuisine(ctx, req.Cuisines)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check cuisine"})
+	}
+	if unknown != "" {
+		return validationFailed(c, "cuisines", fmt.Sprintf("unknown cuisine %q", unknown))
+	}
+
+	err = replaceRestaurantCuisines(ctx, restaurantID, req.Cuisines)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to assign cuisines"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"restaurant_id": restaurantID, "cuisines": req.Cuisines})
+}
+
+// unknownCuisine returns the first of slugs missing from the taxonomy, or ""
+// if all are known.
+func unknownCuisine(ctx context.Context, slugs []string) (string, error) {
+	for _, slug := range slugs {
+		known, err := redisClient.HExists(ctx, cuisineTaxonomyKey, slug).Result()
+		if err != nil {
+			return "", fmt.Errorf("redis error: %v", err)
+		}
+		if !known {
+			return slug, nil
+		}
+	}
+	return "", nil
+}
+
+// replaceRestaurantCuisines sets the restaurant's cuisine tags to slugs,
+// keeping both directions of the assignment in step.
+func replaceRestaurantCuisines(ctx context.Context, restaurantID string, slugs []string) error {
+	previous, err := redisClient.SMembers(ctx, restaurantCuisinesKey(restaurantID)).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	pipe := redisClient.TxPipeline()
+	for _, slug := range previous {
+		pipe.SRem(ctx, cuisineRestaurantsKey(slug), restaurantID)
+	}
+	pipe.Del(ctx, restaurantCuisinesKey(restaurantID))
+	for _, slug := range slugs {
+		pipe.SAdd(ctx, restaurantCuisinesKey(restaurantID), slug)
+		pipe.SAdd(ctx, cuisineRestaurantsKey(slug), restaurantID)
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// attachCuisines fills in cuisine tags for each restaurant in one pipeline.
+func attachCuisines(ctx context.Context, restaurants []Restaurant) error {
+	pipe := redisClient.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(restaurants))
+	for i, restaurant := range restaurants {
+		cmds[i] = pipe.SMembers(ctx, restaurantCuisinesKey(restaurant.ID))
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	for i, cmd := range cmds {
+		cuisines := cmd.Val()
+		sort.Strings(cuisines)
+		restaurants[i].Cuisines = cuisines
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultOrderHistoryLimit = 20
+	maxOrderHistoryLimit     = 100
+)
+
+var errCustomerNotFound = errors.New("customer not found")
+
+// Customer is a customer's profile. Its ID is the subject of the customer's
+// API token, so orders placed with that token belong to it.
+type Customer struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name" validate:"required,max=100"`
+	Email     string    `json:"email" validate:"required,email"`
+	Phone     string    `json:"phone,omitempty" validate:"omitempty,e164"`
+	CreatedAt Timestamp `json:"created_at"`
+	UpdatedAt Timestamp `json:"updated_at"`
+}
+
+func customerKey(customerID string) string {
+	return "customer:" + customerID
+}
+
+// customerOrdersKey indexes a customer's orders by creation time in
+// milliseconds.
+func customerOrdersKey(customerID string) string {
+	return "customer:" + customerID + ":orders"
+}
+
+func getCustomer(ctx context.Context, customerID string) (Customer, error) {
+	data, err := redisClient.Get(ctx, customerKey(customerID)).Result()
+	if err == redis.Nil {
+		return Customer{}, errCustomerNotFound
+	} else if err != nil {
+		return Customer{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	var customer Customer
+	err = json.Unmarshal([]byte(data), &customer)
+	if err != nil {
+		return Customer{}, fmt.Errorf("failed to parse customer: %v", err)
+	}
+	return customer, nil
+}
+
+// canViewCustomer lets customers see only themselves; admins see everyone.
+func canViewCustomer(c echo.Context, customerID string) bool {
+	claims := authClaims(c)
+	return claims.Role == roleAdmin || claims.Subject == customerID
+}
+
+// registerCustomer serves POST /customer, creating the profile of the
+// customer the token was issued to. Their email also becomes their
+// notification address unless one is already set.
+func registerCustomer(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var customer Customer
+	if err := bindAndValidate(c, &customer); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	customer.ID = authClaims(c).Subject
+	if customer.ID == "" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token has no subject"})
+	}
+	touch(&customer.CreatedAt, &customer.UpdatedAt)
+
+	customerJSON, _ := json.Marshal(customer)
+	created, err := redisClient.SetNX(ctx, customerKey(customer.ID), customerJSON, 0).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to register customer"})
+	}
+	if !created {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Customer is already registered"})
+	}
+
+	err = redisClient.HSetNX(ctx, contactKey(roleCustomer, customer.ID), "email", customer.Email).Err()
+	if err != nil {
+		requestLogger(c).Warn("error saving customer contact", "customer_id", customer.ID, "error", err)
+	}
+
+	requestLogger(c).Info("customer registered", "customer_id", customer.ID)
+	return c.JSON(http.StatusCreated, customer)
+}
+
+func getCustomerHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	customerID := c.Param("id")
+	if !canViewCustomer(c, customerID) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Customer not found"})
+	}
+
+	customer, err := getCustomer(ctx, customerID)
+	if err == errCustomerNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Customer not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch customer"})
+	}
+	return c.JSON(http.StatusOK, customer)
+}
+
+// listCustomerOrders serves GET /customer/:id/orders, newest first. Pages
+// hold up to limit orders; pass the returned next_cursor as cursor for the
+// next page. next_cursor is absent on the last page.
+func listCustomerOrders(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	customerID := c.Param("id")
+	if !canViewCustomer(c, customerID) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Customer not found"})
+	}
+
+	limit := defaultOrderHistoryLimit
+	if raw := c.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxOrderHistoryLimit {
+			return validationFailed(c, "limit", fmt.Sprintf("must be between 1 and %d", maxOrderHistoryLimit))
+		}
+		limit = n
+	}
+
+	var before int64
+	if cursor := c.QueryParam("cursor"); cursor != "" {
+		n, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || n <= 0 {
+			return validationFailed(c, "cursor", "is not a valid cursor")
+		}
+		before = n
+	}
+
+	orders, err := repositories.Orders.CustomerOrders(ctx, customerID, before, limit+1)
+	if err != nil {
+		requestLogger(c).Error("error fetching order history", "customer_id", customerID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch orders"})
+	}
+
+	hasMore := len(orders) > limit
+	if hasMore {
+		orders = orders[:limit]
+	}
+	if orders == nil {
+		orders = []Order{}
+	}
+
+	resp := map[string]interface{}{"orders": orders}
+	if hasMore {
+		resp["next_cursor"] = strconv.FormatInt(orders[len(orders)-1].CreatedAt.UnixMilli(), 10)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
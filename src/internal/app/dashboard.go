@@ -0,0 +1,552 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+// Read models built by the dashboard, deliveries, revenue and analytics
+// projections; see projections.go for how they are kept up to date and
+// rebuilt. The restaurant and ops UIs read them under /dashboard, and each
+// is one or a few Redis reads however many orders are behind it.
+
+const (
+	dashboardRevenueField = "revenue"
+	dashboardOrdersField  = "orders"
+
+	defaultAnalyticsDays = 7
+	maxAnalyticsDays     = 90
+)
+
+func dashboardOrdersKey(scope projectionScope) string {
+	return scope.key("orders")
+}
+
+// dashboardOrderTimesKey holds when the status kept for each order was
+// entered, in Unix milliseconds.
+func dashboardOrderTimesKey(scope projectionScope) string {
+	return scope.key("order_times")
+}
+
+func dashboardRestaurantKey(scope projectionScope, restaurantID string) string {
+	return scope.key("restaurant:" + restaurantID)
+}
+
+// dashboardRestaurantsKey is the set of restaurants with a dashboard.
+func dashboardRestaurantsKey(scope projectionScope) string {
+	return scope.key("restaurants")
+}
+
+// deliveriesRiderKey is the order ID to the rider's copy of the order.
+func deliveriesRiderKey(scope projectionScope, riderID string) string {
+	return scope.key("rider:" + riderID)
+}
+
+// deliveriesRidersKey counts each rider's active deliveries.
+func deliveriesRidersKey(scope projectionScope) string {
+	return scope.key("riders")
+}
+
+// deliveriesOrdersKey holds the rider of each order being delivered.
+func deliveriesOrdersKey(scope projectionScope) string {
+	return scope.key("orders")
+}
+
+// revenueTotalsKey holds the most each order has been seen to total.
+func revenueTotalsKey(scope projectionScope) string {
+	return scope.key("totals")
+}
+
+// revenueDaysKey holds the day each delivered order was booked to.
+func revenueDaysKey(scope projectionScope) string {
+	return scope.key("days")
+}
+
+func revenueDayKey(scope projectionScope, day string) string {
+	return scope.key("day:" + day)
+}
+
+func revenueRestaurantDayKey(scope projectionScope, restaurantID, day string) string {
+	return scope.key("restaurant:" + restaurantID + ":day:" + day)
+}
+
+func analyticsDayKey(scope projectionScope, day string) string {
+	return scope.key("day:" + day)
+}
+
+// applyDashboardEvent keeps, per restaurant, how many of its orders are in
+// each status and the revenue of those delivered. The latest status of
+// each order is kept so a move between statuses is counted once. An order's
+// events can arrive out of order, from different topics, so one older than
+// the kept status is ignored.
+func applyDashboardEvent(ctx context.Context, tx *redis.Tx, pipe redis.Pipeliner, scope projectionScope, event OrderEvent) error {
+	if event.Status == "" || event.RestaurantID == "" {
+		return nil
+	}
+
+	previous, err := tx.HGet(ctx, dashboardOrdersKey(scope), event.OrderID).Result()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if previous == event.Status {
+		return nil
+	}
+	at := event.OccurredAt.UnixMilli()
+	previousAt, err := tx.HGet(ctx, dashboardOrderTimesKey(scope), event.OrderID).Int64()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if previous != "" && at < previousAt {
+		return nil
+	}
+
+	restaurantKey := dashboardRestaurantKey(scope, event.RestaurantID)
+	pipe.SAdd(ctx, dashboardRestaurantsKey(scope), event.RestaurantID)
+	pipe.HSet(ctx, dashboardOrdersKey(scope), event.OrderID, event.Status)
+	pipe.HSet(ctx, dashboardOrderTimesKey(scope), event.OrderID, at)
+	if previous != "" {
+		pipe.HIncrBy(ctx, restaurantKey, previous, -1)
+	}
+	pipe.HIncrBy(ctx, restaurantKey, event.Status, 1)
+	if event.Status == "delivered" {
+		pipe.HIncrByFloat(ctx, restaurantKey, dashboardRevenueField, event.TotalAmount)
+	}
+	return nil
+}
+
+// applyDeliveriesEvent keeps the orders each rider is assigned and has not
+// yet delivered, or lost to a cancellation or reassignment. As with the
+// dashboard, an event older than the last one applied for its order is
+// ignored.
+func applyDeliveriesEvent(ctx context.Context, tx *redis.Tx, pipe redis.Pipeliner, scope projectionScope, event OrderEvent) error {
+	if event.Status == "" {
+		return nil
+	}
+	at := event.OccurredAt.UnixMilli()
+	previousAt, err := tx.HGet(ctx, dashboardOrderTimesKey(scope), event.OrderID).Int64()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if err == nil && at < previousAt {
+		return nil
+	}
+	previousRider, err := tx.HGet(ctx, deliveriesOrdersKey(scope), event.OrderID).Result()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	rider := event.RiderID
+	if terminalStatuses[event.Status] {
+		rider = ""
+	}
+	pipe.HSet(ctx, dashboardOrderTimesKey(scope), event.OrderID, at)
+	if previousRider != "" && previousRider != rider {
+		pipe.HDel(ctx, deliveriesRiderKey(scope, previousRider), event.OrderID)
+		pipe.HIncrBy(ctx, deliveriesRidersKey(scope), previousRider, -1)
+		pipe.HDel(ctx, deliveriesOrdersKey(scope), event.OrderID)
+	}
+	if rider == "" {
+		return nil
+	}
+
+	delivery, err := json.Marshal(ActiveDelivery{
+		OrderID:      event.OrderID,
+		OrderCode:    event.OrderCode,
+		RestaurantID: event.RestaurantID,
+		Status:       event.Status,
+		UpdatedAt:    event.OccurredAt,
+	})
+	if err != nil {
+		return err
+	}
+	pipe.HSet(ctx, deliveriesRiderKey(scope, rider), event.OrderID, delivery)
+	if previousRider != rider {
+		pipe.HSet(ctx, deliveriesOrdersKey(scope), event.OrderID, rider)
+		pipe.HIncrBy(ctx, deliveriesRidersKey(scope), rider, 1)
+	}
+	return nil
+}
+
+// applyRevenueEvent books each delivered order's total to the day, in
+// appConfig.Location, it was delivered on, for the platform and for its
+// restaurant. A tip added after delivery raises the total, and is booked to
+// the day of the delivery too; the two events may be folded in either
+// order, since they are on different topics.
+func applyRevenueEvent(ctx context.Context, tx *redis.Tx, pipe redis.Pipeliner, scope projectionScope, event OrderEvent) error {
+	if event.Type != eventOrderDelivered && event.Type != eventOrderTipped {
+		return nil
+	}
+	counted, err := tx.HGet(ctx, revenueTotalsKey(scope), event.OrderID).Float64()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	day, err := tx.HGet(ctx, revenueDaysKey(scope), event.OrderID).Result()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	total := max(counted, event.TotalAmount)
+	book := func(day string, amount float64, orders int64) {
+		for _, key := range []string{revenueDayKey(scope, day), revenueRestaurantDayKey(scope, event.RestaurantID, day)} {
+			pipe.HIncrByFloat(ctx, key, dashboardRevenueField, amount)
+			if orders > 0 {
+				pipe.HIncrBy(ctx, key, dashboardOrdersField, orders)
+			}
+		}
+	}
+	switch {
+	case event.Type == eventOrderDelivered && day == "":
+		day = event.OccurredAt.In(appConfig.Location).Format(time.DateOnly)
+		pipe.HSet(ctx, revenueDaysKey(scope), event.OrderID, day)
+		book(day, total, 1)
+	case day != "" && total > counted:
+		book(day, total-counted, 0)
+	}
+	if total > counted {
+		pipe.HSet(ctx, revenueTotalsKey(scope), event.OrderID, total)
+	}
+	return nil
+}
+
+// applyAnalyticsEvent counts events of each type per day, by when they
+// occurred rather than when they were consumed, and the revenue delivered.
+func applyAnalyticsEvent(ctx context.Context, tx *redis.Tx, pipe redis.Pipeliner, scope projectionScope, event OrderEvent) error {
+	dayKey := analyticsDayKey(scope, event.OccurredAt.UTC().Format("2006-01-02"))
+	pipe.HIncrBy(ctx, dayKey, event.Type, 1)
+	if event.Type == eventOrderDelivered {
+		pipe.HIncrByFloat(ctx, dayKey, dashboardRevenueField, event.TotalAmount)
+	}
+	return nil
+}
+
+type RestaurantDashboard struct {
+	RestaurantID     string           `json:"restaurant_id"`
+	OrdersByStatus   map[string]int64 `json:"orders_by_status"`
+	Active           int64            `json:"active"`
+	DeliveredRevenue float64          `json:"delivered_revenue"`
+}
+
+// getRestaurantDashboard serves GET /restaurant/:id/dashboard.
+func getRestaurantDashboard(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	if authClaims(c).Role != roleAdmin && !actsForRestaurant(c, restaurantID) && !ownsRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	scope, err := currentProjectionScope(c.Request().Context(), "dashboard")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch dashboard"})
+	}
+	dashboard, err := readRestaurantDashboard(ctx, scope, restaurantID)
+	if err != nil {
+		requestLogger(c).Error("error fetching dashboard", "restaurant_id", restaurantID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch dashboard"})
+	}
+	return c.JSON(http.StatusOK, dashboard)
+}
+
+// listRestaurantDashboards serves GET /dashboard/restaurants: every
+// restaurant's dashboard, by restaurant ID.
+func listRestaurantDashboards(c echo.Context) error {
+	reqCtx := c.Request().Context()
+	scope, err := currentProjectionScope(reqCtx, "dashboard")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch dashboards"})
+	}
+	restaurantIDs, err := redisClient.SMembers(reqCtx, dashboardRestaurantsKey(scope)).Result()
+	if err != nil {
+		requestLogger(c).Error("error listing dashboards", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch dashboards"})
+	}
+	sort.Strings(restaurantIDs)
+
+	pipe := redisClient.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(restaurantIDs))
+	for i, restaurantID := range restaurantIDs {
+		cmds[i] = pipe.HGetAll(reqCtx, dashboardRestaurantKey(scope, restaurantID))
+	}
+	if len(cmds) > 0 {
+		if _, err := pipe.Exec(reqCtx); err != nil {
+			requestLogger(c).Error("error fetching dashboards", "error", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch dashboards"})
+		}
+	}
+
+	dashboards := make([]RestaurantDashboard, len(restaurantIDs))
+	for i, restaurantID := range restaurantIDs {
+		dashboards[i] = restaurantDashboard(restaurantID, cmds[i].Val())
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"restaurants": dashboards})
+}
+
+// readRestaurantDashboard reads the restaurant's dashboard from the
+// projection in scope.
+func readRestaurantDashboard(ctx context.Context, scope projectionScope, restaurantID string) (RestaurantDashboard, error) {
+	fields, err := redisClient.HGetAll(ctx, dashboardRestaurantKey(scope, restaurantID)).Result()
+	if err != nil {
+		return RestaurantDashboard{}, fmt.Errorf("redis error: %v", err)
+	}
+	return restaurantDashboard(restaurantID, fields), nil
+}
+
+// restaurantDashboard reads a dashboard from its hash's fields.
+func restaurantDashboard(restaurantID string, fields map[string]string) RestaurantDashboard {
+	dashboard := RestaurantDashboard{RestaurantID: restaurantID, OrdersByStatus: map[string]int64{}}
+	for field, value := range fields {
+		if field == dashboardRevenueField {
+			dashboard.DeliveredRevenue, _ = strconv.ParseFloat(value, 64)
+			continue
+		}
+		count, _ := strconv.ParseInt(value, 10, 64)
+		if count == 0 {
+			continue
+		}
+		dashboard.OrdersByStatus[field] = count
+		if !terminalStatuses[field] {
+			dashboard.Active += count
+		}
+	}
+	return dashboard
+}
+
+// ActiveDelivery is an order a rider is assigned and has yet to deliver,
+// as of its last event.
+type ActiveDelivery struct {
+	OrderID      string    `json:"order_id"`
+	OrderCode    string    `json:"order_code,omitempty"`
+	RestaurantID string    `json:"restaurant_id"`
+	Status       string    `json:"status"`
+	UpdatedAt    Timestamp `json:"updated_at"`
+}
+
+type RiderDeliveries struct {
+	RiderID    string           `json:"rider_id"`
+	Active     int              `json:"active"`
+	Deliveries []ActiveDelivery `json:"deliveries"`
+}
+
+// listRiderDeliveries serves GET /dashboard/riders: the riders with
+// deliveries under way, by rider ID.
+func listRiderDeliveries(c echo.Context) error {
+	reqCtx := c.Request().Context()
+	scope, err := currentProjectionScope(reqCtx, "deliveries")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch deliveries"})
+	}
+	counts, err := redisClient.HGetAll(reqCtx, deliveriesRidersKey(scope)).Result()
+	if err != nil {
+		requestLogger(c).Error("error listing rider deliveries", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch deliveries"})
+	}
+	var riderIDs []string
+	for riderID, count := range counts {
+		if n, _ := strconv.Atoi(count); n > 0 {
+			riderIDs = append(riderIDs, riderID)
+		}
+	}
+	sort.Strings(riderIDs)
+
+	pipe := redisClient.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(riderIDs))
+	for i, riderID := range riderIDs {
+		cmds[i] = pipe.HGetAll(reqCtx, deliveriesRiderKey(scope, riderID))
+	}
+	if len(cmds) > 0 {
+		if _, err := pipe.Exec(reqCtx); err != nil {
+			requestLogger(c).Error("error fetching rider deliveries", "error", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch deliveries"})
+		}
+	}
+
+	riders := make([]RiderDeliveries, len(riderIDs))
+	for i, riderID := range riderIDs {
+		riders[i] = riderDeliveries(riderID, cmds[i].Val())
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"riders": riders})
+}
+
+// getRiderDeliveries serves GET /dashboard/riders/:id to the rider and
+// admins.
+func getRiderDeliveries(c echo.Context) error {
+	riderID := c.Param("id")
+	if authClaims(c).Role != roleAdmin && !actsForRider(c, riderID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this rider"})
+	}
+
+	reqCtx := c.Request().Context()
+	scope, err := currentProjectionScope(reqCtx, "deliveries")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch deliveries"})
+	}
+	fields, err := redisClient.HGetAll(reqCtx, deliveriesRiderKey(scope, riderID)).Result()
+	if err != nil {
+		requestLogger(c).Error("error fetching rider deliveries", "rider_id", riderID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch deliveries"})
+	}
+	return c.JSON(http.StatusOK, riderDeliveries(riderID, fields))
+}
+
+// riderDeliveries reads a rider's deliveries from their hash's fields,
+// oldest update first.
+func riderDeliveries(riderID string, fields map[string]string) RiderDeliveries {
+	rider := RiderDeliveries{RiderID: riderID, Deliveries: make([]ActiveDelivery, 0, len(fields))}
+	for orderID, value := range fields {
+		var delivery ActiveDelivery
+		if err := json.Unmarshal([]byte(value), &delivery); err != nil {
+			slog.Warn("skipping malformed delivery in read model", "rider_id", riderID, "order_id", orderID, "error", err)
+			continue
+		}
+		rider.Deliveries = append(rider.Deliveries, delivery)
+	}
+	sort.Slice(rider.Deliveries, func(i, j int) bool {
+		return rider.Deliveries[i].UpdatedAt.Before(rider.Deliveries[j].UpdatedAt.Time)
+	})
+	rider.Active = len(rider.Deliveries)
+	return rider
+}
+
+type DailyRevenue struct {
+	Date    string  `json:"date"`
+	Orders  int64   `json:"orders"`
+	Revenue float64 `json:"revenue"`
+}
+
+// getDailyRevenue serves GET /dashboard/revenue: the orders delivered and
+// their revenue on each of the last `days` days, newest first.
+func getDailyRevenue(c echo.Context) error {
+	return respondDailyRevenue(c, "", func(scope projectionScope, day string) string {
+		return revenueDayKey(scope, day)
+	})
+}
+
+// getRestaurantRevenue serves GET /dashboard/restaurants/:id/revenue, as
+// getDailyRevenue for one restaurant.
+func getRestaurantRevenue(c echo.Context) error {
+	restaurantID := c.Param("id")
+	if authClaims(c).Role != roleAdmin && !actsForRestaurant(c, restaurantID) && !ownsRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+	return respondDailyRevenue(c, restaurantID, func(scope projectionScope, day string) string {
+		return revenueRestaurantDayKey(scope, restaurantID, day)
+	})
+}
+
+func respondDailyRevenue(c echo.Context, restaurantID string, dayKey func(scope projectionScope, day string) string) error {
+	days, ok := queryDays(c)
+	if !ok {
+		return validationFailed(c, "days", fmt.Sprintf("must be between 1 and %d", maxAnalyticsDays))
+	}
+
+	reqCtx := c.Request().Context()
+	scope, err := currentProjectionScope(reqCtx, "revenue")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch revenue"})
+	}
+
+	today := clock.Now().In(appConfig.Location)
+	pipe := redisClient.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, days)
+	dates := make([]string, days)
+	for i := range dates {
+		dates[i] = today.AddDate(0, 0, -i).Format(time.DateOnly)
+		cmds[i] = pipe.HGetAll(reqCtx, dayKey(scope, dates[i]))
+	}
+	_, err = pipe.Exec(reqCtx)
+	if err != nil {
+		requestLogger(c).Error("error fetching revenue", "restaurant_id", restaurantID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch revenue"})
+	}
+
+	result := make([]DailyRevenue, days)
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		revenue, _ := strconv.ParseFloat(fields[dashboardRevenueField], 64)
+		orders, _ := strconv.ParseInt(fields[dashboardOrdersField], 10, 64)
+		result[i] = DailyRevenue{Date: dates[i], Orders: orders, Revenue: roundMoney(revenue)}
+	}
+	if restaurantID != "" {
+		return c.JSON(http.StatusOK, map[string]interface{}{"restaurant_id": restaurantID, "days": result})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"days": result})
+}
+
+// queryDays reads the days query parameter, defaultAnalyticsDays if it is
+// not given.
+func queryDays(c echo.Context) (int, bool) {
+	raw := c.QueryParam("days")
+	if raw == "" {
+		return defaultAnalyticsDays, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > maxAnalyticsDays {
+		return 0, false
+	}
+	return n, true
+}
+
+type AnalyticsDay struct {
+	Date    string           `json:"date"`
+	Events  map[string]int64 `json:"events"`
+	Revenue float64          `json:"revenue"`
+}
+
+// getAnalytics serves GET /admin/analytics: event counts and delivered
+// revenue for each of the last `days` days, newest first, or with `hours`
+// the hourly business metrics; see analytics.go.
+func getAnalytics(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if c.QueryParam("hours") != "" {
+		return getHourlyAnalytics(c)
+	}
+	days, ok := queryDays(c)
+	if !ok {
+		return validationFailed(c, "days", fmt.Sprintf("must be between 1 and %d", maxAnalyticsDays))
+	}
+
+	scope, err := currentProjectionScope(c.Request().Context(), "analytics")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch analytics"})
+	}
+
+	today := clock.Now().UTC()
+	pipe := redisClient.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, days)
+	dates := make([]string, days)
+	for i := range dates {
+		dates[i] = today.AddDate(0, 0, -i).Format("2006-01-02")
+		cmds[i] = pipe.HGetAll(ctx, analyticsDayKey(scope, dates[i]))
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		requestLogger(c).Error("error fetching analytics", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch analytics"})
+	}
+
+	result := make([]AnalyticsDay, days)
+	for i, cmd := range cmds {
+		day := AnalyticsDay{Date: dates[i], Events: map[string]int64{}}
+		for field, value := range cmd.Val() {
+			if field == dashboardRevenueField {
+				day.Revenue, _ = strconv.ParseFloat(value, 64)
+				continue
+			}
+			day.Events[field], _ = strconv.ParseInt(value, 10, 64)
+		}
+		result[i] = day
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"days": result})
+}
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Delivery areas. A restaurant delivers within a polygon it draws, or within
+// a radius of itself, or, if it sets neither, anywhere up to
+// MaxDeliveryDistanceKm away. No restaurant delivers further than that,
+// whatever its area.
+
+// DeliveryArea is where a restaurant delivers to. Polygon takes precedence
+// over RadiusKm when both are set.
+type DeliveryArea struct {
+	RadiusKm float64 `json:"radius_km,omitempty" validate:"gte=0"`
+	// Polygon lists the area's corners in order; it is closed
+	// automatically.
+	Polygon []GeoPoint `json:"polygon,omitempty" validate:"omitempty,min=3,max=200,dive"`
+}
+
+// Reasons a location is not served.
+const (
+	notServedOutsideArea = "outside_delivery_area"
+	notServedTooFar      = "too_far"
+)
+
+// Serviceability says whether a restaurant delivers to a location and, if it
+// does, for what fee.
+type Serviceability struct {
+	RestaurantID   string  `json:"restaurant_id"`
+	Serviceable    bool    `json:"serviceable"`
+	Reason         string  `json:"reason,omitempty"`
+	DistanceMeters float64 `json:"distance_m"`
+	DeliveryFee    float64 `json:"delivery_fee,omitempty"`
+	Currency       string  `json:"currency,omitempty"`
+	Surge          bool    `json:"surge,omitempty"`
+}
+
+// validateDeliveryArea reports the first problem with area as a field and
+// message.
+func validateDeliveryArea(area *DeliveryArea) (string, string) {
+	if area == nil {
+		return "", ""
+	}
+	if area.RadiusKm > appConfig.MaxDeliveryDistanceKm {
+		return "delivery_area.radius_km", "must be at most " + strconv.FormatFloat(appConfig.MaxDeliveryDistanceKm, 'f', -1, 64)
+	}
+	if len(area.Polygon) == 0 && area.RadiusKm == 0 {
+		return "delivery_area", "needs a radius_km or a polygon"
+	}
+	return "", ""
+}
+
+// deliveryRefusal is why restaurant does not deliver to to, which is
+// distance metres away, or "" if it does.
+func deliveryRefusal(restaurant Restaurant, to GeoPoint, distance float64) string {
+	if distance > appConfig.MaxDeliveryDistanceKm*1000 {
+		return notServedTooFar
+	}
+	area := restaurant.DeliveryArea
+	switch {
+	case area == nil:
+		return ""
+	case len(area.Polygon) > 0:
+		if !insidePolygon(to, area.Polygon) {
+			return notServedOutsideArea
+		}
+	case area.RadiusKm > 0:
+		if distance > area.RadiusKm*1000 {
+			return notServedOutsideArea
+		}
+	}
+	return ""
+}
+
+// insidePolygon reports whether p lies within polygon, by counting how many
+// of its edges a ray from p crosses. Areas are small enough for latitude and
+// longitude to be treated as flat.
+func insidePolygon(p GeoPoint, polygon []GeoPoint) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lng < (b.Lng-a.Lng)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lng {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// checkServiceability serves GET /restaurant/:id/serviceable?lat=&lng=:
+// whether the restaurant delivers there and, if so, the delivery fee.
+func checkServiceability(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	lat, errLat := strconv.ParseFloat(c.QueryParam("lat"), 64)
+	lng, errLng := strconv.ParseFloat(c.QueryParam("lng"), 64)
+	if errLat != nil || errLng != nil || math.Abs(lat) > 90 || math.Abs(lng) > 180 {
+		return validationFailed(c, "lat", "lat and lng must both be valid coordinates")
+	}
+	to := GeoPoint{Lat: lat, Lng: lng}
+
+	restaurant, err := findRestaurant(ctx, c.Param("id"))
+	if err == errRestaurantNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Restaurant not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant"})
+	}
+
+	distance := distanceMeters(restaurant.Lat, restaurant.Lng, lat, lng)
+	result := Serviceability{RestaurantID: restaurant.ID, DistanceMeters: math.Round(distance)}
+	if result.Reason = deliveryRefusal(restaurant, to, distance); result.Reason != "" {
+		return c.JSON(http.StatusOK, result)
+	}
+
+	quote, err := deliveryQuote(ctx, restaurant, to)
+	if err != nil {
+		requestLogger(c).Error("error quoting delivery", "restaurant_id", restaurant.ID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to quote delivery"})
+	}
+	result.Serviceable = true
+	result.DeliveryFee, result.Currency, result.Surge = quote.DeliveryFee, quote.Currency, quote.Surge
+	return c.JSON(http.StatusOK, result)
+}
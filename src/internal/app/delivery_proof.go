@@ -0,0 +1,96 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+// Proof of delivery. Before confirming a delivery the rider may upload a
+// photo of the drop-off to POST /rider/order/:id/proof-photo, then name it
+// by id in POST /rider/order/deliver with the customer's signature hash and
+// where they are. All of it is kept on the order as its DeliveryProof, and
+// the photo's URL goes out in the OrderDelivered event.
+
+// proofPhotoTTL is how long an uploaded photo can be named in a delivery.
+const proofPhotoTTL = 24 * time.Hour
+
+// proofPhotoSize is the rendition of the photo the proof links to.
+const proofPhotoSize = "large"
+
+func proofPhotosKey(orderID string) string {
+	return "order:" + orderID + ":proof-photos"
+}
+
+// uploadProofPhoto serves POST /rider/order/:id/proof-photo, a multipart
+// form with photo, for the rider carrying the order. The response's id is
+// the photo_ref to deliver the order with.
+func uploadProofPhoto(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	order, err := getOrder(ctx, c.Param("id"))
+	if err == errOrderNotFound || (err == nil && (order.RiderID == "" || !actsForRider(c, order.RiderID))) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+
+	if order.Status != "picked_up" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Proof photos can only be uploaded for picked-up orders"})
+	}
+
+	fh, err := c.FormFile("photo")
+	if err != nil {
+		return validationFailed(c, "photo", "is required")
+	}
+
+	asset, err := processImageUpload(ctx, fh, "orders/"+order.OrderID+"/proof")
+	if err != nil {
+		requestLogger(c).Warn("rejected proof photo", "order_id", order.OrderID, "error", err)
+		return validationFailed(c, "photo", err.Error())
+	}
+
+	assetJSON, _ := json.Marshal(asset)
+	pipe := redisClient.TxPipeline()
+	pipe.HSet(ctx, proofPhotosKey(order.OrderID), asset.ID, assetJSON)
+	pipe.Expire(ctx, proofPhotosKey(order.OrderID), proofPhotoTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store proof photo"})
+	}
+
+	requestLogger(c).Info("proof photo uploaded", "order_id", order.OrderID, "asset_id", asset.ID)
+	return c.JSON(http.StatusCreated, asset)
+}
+
+// deliveryProof is the proof req delivers order with. Its photo must have
+// been uploaded for the order.
+func deliveryProof(ctx context.Context, order Order, req DeliverRequest) (*DeliveryProof, error) {
+	proof := &DeliveryProof{
+		SignatureHash: req.SignatureHash,
+		Location:      req.Location,
+		RiderID:       req.RiderID,
+		DeliveredAt:   timestampNow(),
+	}
+	if req.PhotoRef == "" {
+		return proof, nil
+	}
+
+	assetJSON, err := redisClient.HGet(ctx, proofPhotosKey(order.OrderID), req.PhotoRef).Result()
+	if err == redis.Nil {
+		return nil, invalidField("photo_ref", "is not a photo uploaded for this order")
+	} else if err != nil {
+		return nil, serviceFailure(http.StatusInternalServerError, "Failed to fetch proof photo")
+	}
+	var asset ImageAsset
+	if err := json.Unmarshal([]byte(assetJSON), &asset); err != nil {
+		return nil, serviceFailure(http.StatusInternalServerError, "Failed to fetch proof photo")
+	}
+
+	proof.PhotoRef = asset.ID
+	proof.PhotoURL = asset.URLs[proofPhotoSize]
+	return proof, nil
+}
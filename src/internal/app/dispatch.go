@@ -0,0 +1,601 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"myproject/src/clock"
+)
+
+// Rider dispatch. Accepted orders wait in dispatch:pending until a rider
+// takes them. Each round the zone's Dispatcher proposes a rider per order,
+// who gets an offer to accept or decline within DispatchOfferTTL; a declined
+// or lapsed offer sends the order back for the next round, to someone else.
+
+const (
+	dispatchPendingKey = "dispatch:pending"
+	dispatchOffersKey  = "dispatch:offers"
+	dispatchLockKey    = "dispatch:lock"
+	dispatchLoadKey    = "dispatch:load"
+
+	defaultDispatchZone = "default"
+)
+
+var (
+	dispatchAssignmentLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dispatch_assignment_latency_seconds",
+		Help:    "Time from an order being queued for dispatch to a rider accepting it.",
+		Buckets: []float64{5, 15, 30, 60, 120, 300, 600, 1200},
+	}, []string{"zone", "strategy"})
+
+	dispatchOffers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dispatch_offers_total",
+		Help: "Rider offers partitioned by zone, strategy and result (offered, accepted, declined, expired).",
+	}, []string{"zone", "strategy", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(dispatchAssignmentLatency, dispatchOffers)
+}
+
+var dispatchWake = make(chan struct{}, 1)
+
+// wakeDispatch asks for a dispatch round without waiting for the next tick.
+func wakeDispatch() {
+	select {
+	case dispatchWake <- struct{}{}:
+	default:
+	}
+}
+
+type DispatchOffer struct {
+	OrderID   string    `json:"order_id"`
+	RiderID   string    `json:"rider_id"`
+	Zone      string    `json:"zone"`
+	Strategy  string    `json:"strategy"`
+	OfferedAt time.Time `json:"offered_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ZoneLoad is a zone's demand and supply as of the last dispatch round:
+// orders waiting for a rider and riders free to take one.
+type ZoneLoad struct {
+	Pending    int       `json:"pending"`
+	FreeRiders int       `json:"free_riders"`
+	At         time.Time `json:"at"`
+}
+
+type DispatchOfferRequest struct {
+	OrderID string `json:"order_id" validate:"required,uuid"`
+	RiderID string `json:"rider_id" validate:"required"`
+}
+
+func dispatchDeclinedKey(orderID string) string {
+	return "dispatch:declined:" + orderID
+}
+
+// dispatcherForZone returns the strategy configured for zone, falling back
+// to the default strategy.
+func dispatcherForZone(zone string) Dispatcher {
+	if name, ok := appConfig.DispatchZoneStrategies[zone]; ok {
+		return dispatchers[name]
+	}
+	return dispatchers[appConfig.DispatchStrategy]
+}
+
+// validateDispatchConfig checks that every configured strategy exists and
+// that the rider search widens.
+func validateDispatchConfig() error {
+	if appConfig.DispatchSearchExpansion <= 1 {
+		return fmt.Errorf("DISPATCH_SEARCH_EXPANSION must be more than 1")
+	}
+	if _, ok := dispatchers[appConfig.DispatchStrategy]; !ok {
+		return fmt.Errorf("unknown dispatch strategy %q", appConfig.DispatchStrategy)
+	}
+	for zone, name := range appConfig.DispatchZoneStrategies {
+		if _, ok := dispatchers[name]; !ok {
+			return fmt.Errorf("unknown dispatch strategy %q for zone %s", name, zone)
+		}
+	}
+	return nil
+}
+
+func zoneOrDefault(zone string) string {
+	if zone == "" {
+		return defaultDispatchZone
+	}
+	return zone
+}
+
+// queueForDispatch handles OrderAccepted: the order now needs a rider.
+func queueForDispatch(ctx context.Context, event OrderEvent) error {
+	err := redisClient.ZAddNX(ctx, dispatchPendingKey, &redis.Z{
+		Score:  float64(event.OccurredAt.Unix()),
+		Member: event.OrderID,
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	wakeDispatch()
+	return nil
+}
+
+// runDispatcher runs dispatch rounds until ctx is cancelled, every interval
+// or sooner when woken.
+func runDispatcher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-dispatchWake:
+		}
+
+		err := dispatchRound(ctx, interval)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("dispatch round failed", "error", err)
+		}
+	}
+}
+
+// dispatchRound expires lapsed offers and makes new ones for every waiting
+// order without one. Only one instance runs a round at a time.
+func dispatchRound(ctx context.Context, interval time.Duration) error {
+	locked, err := redisClient.SetNX(ctx, dispatchLockKey, 1, interval).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if !locked {
+		return nil
+	}
+	defer redisClient.Del(ctx, dispatchLockKey)
+
+	offers, err := getDispatchOffers(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := clock.Now().UTC()
+	busy := map[string]bool{}
+	for orderID, offer := range offers {
+		if now.After(offer.ExpiresAt) {
+			err := closeOffer(ctx, offer, "expired")
+			if err != nil {
+				return err
+			}
+			delete(offers, orderID)
+			continue
+		}
+		busy[offer.RiderID] = true
+	}
+
+	pending, err := redisClient.ZRangeWithScores(ctx, dispatchPendingKey, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	ordersByZone := map[string][]DispatchOrder{}
+	for _, entry := range pending {
+		orderID := entry.Member.(string)
+		if _, offered := offers[orderID]; offered {
+			continue
+		}
+
+		order, zone, ok, err := dispatchCandidateOrder(ctx, orderID, time.Unix(int64(entry.Score), 0))
+		if err != nil {
+			slog.Error("error loading order for dispatch", "order_id", orderID, "error", err)
+			continue
+		}
+		if ok {
+			ordersByZone[zone] = append(ordersByZone[zone], order)
+		}
+	}
+
+	ridersByZone, err := availableRiders(ctx, busy)
+	if err != nil {
+		return err
+	}
+
+	err = recordZoneLoad(ctx, ordersByZone, ridersByZone, now)
+	if err != nil {
+		slog.Warn("error recording zone load", "error", err)
+	}
+
+	for zone, orders := range ordersByZone {
+		dispatcher := dispatcherForZone(zone)
+		for _, assignment := range dispatcher.Assign(ctx, zone, orders, ridersByZone[zone]) {
+			offer := DispatchOffer{
+				OrderID:   assignment.OrderID,
+				RiderID:   assignment.RiderID,
+				Zone:      zone,
+				Strategy:  dispatcher.Name(),
+				OfferedAt: now,
+				ExpiresAt: now.Add(appConfig.DispatchOfferTTL),
+			}
+			err := makeOffer(ctx, offer)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recordZoneLoad saves each zone's load for pricing. Zones missing from the
+// snapshot had nothing waiting and no free riders.
+func recordZoneLoad(ctx context.Context, orders map[string][]DispatchOrder, riders map[string][]RiderCandidate, at time.Time) error {
+	loads := map[string]*ZoneLoad{}
+	load := func(zone string) *ZoneLoad {
+		if loads[zone] == nil {
+			loads[zone] = &ZoneLoad{At: at}
+		}
+		return loads[zone]
+	}
+	for zone, zoneOrders := range orders {
+		load(zone).Pending = len(zoneOrders)
+	}
+	for zone, zoneRiders := range riders {
+		load(zone).FreeRiders = len(zoneRiders)
+	}
+
+	values := make(map[string]interface{}, len(loads))
+	for zone, zoneLoad := range loads {
+		values[zone], _ = json.Marshal(zoneLoad)
+	}
+
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx, dispatchLoadKey)
+	if len(values) > 0 {
+		pipe.HSet(ctx, dispatchLoadKey, values)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// getZoneLoad returns the zone's load from the last dispatch round.
+func getZoneLoad(ctx context.Context, zone string) (ZoneLoad, error) {
+	data, err := redisClient.HGet(ctx, dispatchLoadKey, zone).Result()
+	if err == redis.Nil {
+		return ZoneLoad{}, nil
+	} else if err != nil {
+		return ZoneLoad{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	var load ZoneLoad
+	err = json.Unmarshal([]byte(data), &load)
+	if err != nil {
+		return ZoneLoad{}, fmt.Errorf("failed to parse zone load: %v", err)
+	}
+	return load, nil
+}
+
+// dispatchCandidateOrder loads a waiting order. Orders that no longer need
+// a rider, because they were cancelled or someone took them, leave the
+// queue and are reported as not ok.
+func dispatchCandidateOrder(ctx context.Context, orderID string, queuedAt time.Time) (DispatchOrder, string, bool, error) {
+	order, err := getOrder(ctx, orderID)
+	if err != nil && err != errOrderNotFound {
+		return DispatchOrder{}, "", false, err
+	}
+	if err == errOrderNotFound || order.Status != "accepted" || order.RiderID != "" {
+		return DispatchOrder{}, "", false, finishDispatch(ctx, orderID)
+	}
+
+	restaurant, err := findRestaurant(ctx, order.RestaurantID)
+	if err != nil {
+		return DispatchOrder{}, "", false, err
+	}
+
+	declined, err := redisClient.SMembers(ctx, dispatchDeclinedKey(orderID)).Result()
+	if err != nil {
+		return DispatchOrder{}, "", false, fmt.Errorf("redis error: %v", err)
+	}
+	declinedBy := make(map[string]bool, len(declined))
+	for _, riderID := range declined {
+		declinedBy[riderID] = true
+	}
+
+	return DispatchOrder{
+		OrderID:  orderID,
+		Pickup:   GeoPoint{Lat: restaurant.Lat, Lng: restaurant.Lng},
+		QueuedAt: queuedAt,
+		Declined: declinedBy,
+	}, zoneOrDefault(restaurant.Zone), true, nil
+}
+
+// availableRiders returns, by zone, the riders who are on shift, have
+// reported a position within RiderLocationTTL and are not holding an offer.
+func availableRiders(ctx context.Context, busy map[string]bool) (map[string][]RiderCandidate, error) {
+	riders, err := repositories.Riders.Riders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	online, err := getOnlineRiders(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byZone := map[string][]RiderCandidate{}
+	for _, rider := range riders {
+		if _, onShift := online[rider.ID]; !onShift || busy[rider.ID] {
+			continue
+		}
+		position, err := latestRiderPosition(ctx, rider.ID)
+		if err != nil {
+			return nil, err
+		}
+		if position == nil {
+			continue
+		}
+		zone := zoneOrDefault(rider.Zone)
+		byZone[zone] = append(byZone[zone], RiderCandidate{
+			RiderID:  rider.ID,
+			Position: GeoPoint{Lat: position.Lat, Lng: position.Lng},
+		})
+	}
+	return byZone, nil
+}
+
+func getDispatchOffers(ctx context.Context) (map[string]DispatchOffer, error) {
+	entries, err := redisClient.HGetAll(ctx, dispatchOffersKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+
+	offers := make(map[string]DispatchOffer, len(entries))
+	for orderID, data := range entries {
+		var offer DispatchOffer
+		err := json.Unmarshal([]byte(data), &offer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse dispatch offer for order %s: %v", orderID, err)
+		}
+		offers[orderID] = offer
+	}
+	return offers, nil
+}
+
+func getDispatchOffer(ctx context.Context, orderID string) (*DispatchOffer, error) {
+	data, err := redisClient.HGet(ctx, dispatchOffersKey, orderID).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+
+	var offer DispatchOffer
+	err = json.Unmarshal([]byte(data), &offer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse dispatch offer: %v", err)
+	}
+	return &offer, nil
+}
+
+func makeOffer(ctx context.Context, offer DispatchOffer) error {
+	offerJSON, _ := json.Marshal(offer)
+	err := redisClient.HSet(ctx, dispatchOffersKey, offer.OrderID, offerJSON).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	dispatchOffers.WithLabelValues(offer.Zone, offer.Strategy, "offered").Inc()
+	slog.Info("rider offered order", "order_id", offer.OrderID, "rider_id", offer.RiderID, "zone", offer.Zone, "strategy", offer.Strategy)
+	go notifyRiderOffer(context.WithoutCancel(ctx), offer)
+	return nil
+}
+
+// closeOffer withdraws an offer the rider declined or let lapse. They are
+// not offered that order again.
+func closeOffer(ctx context.Context, offer DispatchOffer, result string) error {
+	pipe := redisClient.TxPipeline()
+	pipe.HDel(ctx, dispatchOffersKey, offer.OrderID)
+	pipe.SAdd(ctx, dispatchDeclinedKey(offer.OrderID), offer.RiderID)
+	pipe.Expire(ctx, dispatchDeclinedKey(offer.OrderID), 24*time.Hour)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	dispatchOffers.WithLabelValues(offer.Zone, offer.Strategy, result).Inc()
+	slog.Info("rider offer closed", "order_id", offer.OrderID, "rider_id", offer.RiderID, "result", result)
+	return nil
+}
+
+// finishDispatch forgets an order that no longer needs a rider.
+func finishDispatch(ctx context.Context, orderID string) error {
+	pipe := redisClient.TxPipeline()
+	pipe.ZRem(ctx, dispatchPendingKey, orderID)
+	pipe.HDel(ctx, dispatchOffersKey, orderID)
+	pipe.Del(ctx, dispatchDeclinedKey(orderID))
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+func notifyRiderOffer(ctx context.Context, offer DispatchOffer) {
+	order, err := getOrder(ctx, offer.OrderID)
+	if err != nil {
+		slog.Error("error loading order for rider offer", "order_id", offer.OrderID, "error", err)
+		return
+	}
+
+	_, err = dispatchNotification(ctx, redisClient, Notification{
+		RecipientType: "rider",
+		RecipientID:   offer.RiderID,
+		OrderID:       order.OrderID,
+		OrderCode:     order.Code,
+		Template:      "dispatch_offer",
+		Vars: map[string]string{
+			"order_ref":  orderReference(order.OrderID, order.Code),
+			"expires_in": appConfig.DispatchOfferTTL.String(),
+		},
+	})
+	if err != nil {
+		slog.Error("error notifying rider about offer", "order_id", offer.OrderID, "rider_id", offer.RiderID, "error", err)
+	}
+}
+
+func getRiderOffers(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	riderID := c.QueryParam("rider_id")
+	if riderID == "" {
+		return validationFailed(c, "rider_id", "is required")
+	}
+	if !actsForRider(c, riderID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this rider"})
+	}
+
+	offers, err := getDispatchOffers(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch offers"})
+	}
+
+	now := clock.Now()
+	mine := []DispatchOffer{}
+	for _, offer := range offers {
+		if offer.RiderID == riderID && now.Before(offer.ExpiresAt) {
+			mine = append(mine, offer)
+		}
+	}
+	sort.Slice(mine, func(i, j int) bool { return mine[i].OfferedAt.Before(mine[j].OfferedAt) })
+	return c.JSON(http.StatusOK, map[string]interface{}{"offers": mine})
+}
+
+// acceptOffer assigns the order to the rider holding its offer.
+func acceptOffer(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req DispatchOfferRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	if !actsForRider(c, req.RiderID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this rider"})
+	}
+
+	offer, err := getOpenOffer(ctx, req.OrderID, req.RiderID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch offer"})
+	}
+	if offer == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "No open offer for this order"})
+	}
+
+	order, err := getOrder(ctx, req.OrderID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+	if order.Status != "accepted" || order.RiderID != "" {
+		finishDispatch(ctx, order.OrderID)
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Order no longer needs a rider"})
+	}
+
+	order.RiderID = req.RiderID
+	order.Timeline = append(order.Timeline, TimelineEvent{Event: timelineRiderAssigned, At: timestampNow()})
+	err = saveOrder(c.Request().Context(), repositories.Orders, &order, newOrderEvent(c.Request().Context(), eventRiderAssigned, order))
+	if err == errOrderChanged {
+		return respondServiceError(c, orderChangedFailure(ctx, order.OrderID))
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to assign order"})
+	}
+
+	queuedAt, err := redisClient.ZScore(ctx, dispatchPendingKey, order.OrderID).Result()
+	if err == nil {
+		dispatchAssignmentLatency.WithLabelValues(offer.Zone, offer.Strategy).Observe(clock.Since(time.Unix(int64(queuedAt), 0)).Seconds())
+	}
+	dispatchOffers.WithLabelValues(offer.Zone, offer.Strategy, "accepted").Inc()
+
+	logger := requestLogger(c).With("order_id", order.OrderID, "rider_id", req.RiderID)
+	err = finishDispatch(ctx, order.OrderID)
+	if err != nil {
+		logger.Error("error clearing dispatch state", "error", err)
+	}
+
+	logger.Info("rider accepted order", "zone", offer.Zone, "strategy", offer.Strategy)
+	return c.JSON(http.StatusOK, map[string]string{"status": "assigned", "order_id": order.OrderID, "rider_id": req.RiderID})
+}
+
+func declineOffer(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req DispatchOfferRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	if !actsForRider(c, req.RiderID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this rider"})
+	}
+
+	offer, err := getOpenOffer(ctx, req.OrderID, req.RiderID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch offer"})
+	}
+	if offer == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "No open offer for this order"})
+	}
+
+	err = closeOffer(ctx, *offer, "declined")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to decline offer"})
+	}
+	wakeDispatch()
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "declined"})
+}
+
+// getOpenOffer returns the unexpired offer of orderID to riderID, or nil.
+func getOpenOffer(ctx context.Context, orderID, riderID string) (*DispatchOffer, error) {
+	offer, err := getDispatchOffer(ctx, orderID)
+	if err != nil || offer == nil {
+		return nil, err
+	}
+	if offer.RiderID != riderID || clock.Now().After(offer.ExpiresAt) {
+		return nil, nil
+	}
+	return offer, nil
+}
+
+// dispatchQueueStatus serves GET /admin/dispatch for operators.
+func dispatchQueueStatus(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	pending, err := redisClient.ZCard(ctx, dispatchPendingKey).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch dispatch queue"})
+	}
+	offers, err := getDispatchOffers(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch offers"})
+	}
+
+	strategies := map[string]string{defaultDispatchZone: appConfig.DispatchStrategy}
+	for zone, name := range appConfig.DispatchZoneStrategies {
+		strategies[zone] = name
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"pending":     pending,
+		"open_offers": len(offers),
+		"strategies":  strategies,
+		"offer_ttl":   appConfig.DispatchOfferTTL.String(),
+	})
+}
@@ -0,0 +1,246 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"myproject/src/rng"
+)
+
+// DispatchOrder is an order waiting for a rider, as a Dispatcher sees it.
+type DispatchOrder struct {
+	OrderID  string
+	Pickup   GeoPoint
+	QueuedAt time.Time
+	// Declined holds riders who turned the order down or let an offer for it
+	// lapse; they are not offered it again.
+	Declined map[string]bool
+}
+
+// RiderCandidate is an online rider with no outstanding offer.
+type RiderCandidate struct {
+	RiderID  string
+	Position GeoPoint
+}
+
+type Assignment struct {
+	OrderID string
+	RiderID string
+}
+
+// Dispatcher decides which rider each waiting order is offered to. It is
+// given every waiting order in a zone, oldest first, with the riders free to
+// take them, and may leave orders unassigned; they are offered again on the
+// next round. A rider gets at most one order per round.
+type Dispatcher interface {
+	Name() string
+	Assign(ctx context.Context, zone string, orders []DispatchOrder, riders []RiderCandidate) []Assignment
+}
+
+var dispatchers = map[string]Dispatcher{}
+
+func registerDispatcher(d Dispatcher) {
+	dispatchers[d.Name()] = d
+}
+
+func init() {
+	registerDispatcher(nearestRiderDispatcher{})
+	registerDispatcher(batchDispatcher{})
+	registerDispatcher(&roundRobinDispatcher{next: map[string]int{}})
+}
+
+func pickupDistance(order DispatchOrder, rider RiderCandidate) float64 {
+	return distanceMeters(order.Pickup.Lat, order.Pickup.Lng, rider.Position.Lat, rider.Position.Lng)
+}
+
+// offerable reports whether rider may be offered order: they have not
+// turned it down and are within DispatchRadiusMeters of the restaurant.
+func offerable(order DispatchOrder, rider RiderCandidate) bool {
+	if order.Declined[rider.RiderID] {
+		return false
+	}
+	return appConfig.DispatchRadiusMeters <= 0 || pickupDistance(order, rider) <= appConfig.DispatchRadiusMeters
+}
+
+// nearestRiderDispatcher gives each order, oldest first, the closest free
+// rider to its restaurant, picking at random between riders equally close.
+// Riders are found with a geo search around the restaurant, starting at
+// DispatchSearchRadiusMeters and widening to DispatchRadiusMeters; with no
+// dispatch radius, or should the search fail, every free rider in the zone
+// is measured instead.
+type nearestRiderDispatcher struct{}
+
+func (nearestRiderDispatcher) Name() string { return "nearest" }
+
+func (nearestRiderDispatcher) Assign(ctx context.Context, zone string, orders []DispatchOrder, riders []RiderCandidate) []Assignment {
+	free := make(map[string]bool, len(riders))
+	for _, rider := range riders {
+		free[rider.RiderID] = true
+	}
+
+	var assignments []Assignment
+	for _, order := range orders {
+		var best string
+		if appConfig.DispatchRadiusMeters > 0 {
+			var err error
+			best, err = searchNearestRider(ctx, order, free)
+			if err != nil {
+				slog.Warn("error searching for riders near restaurant, measuring the zone", "zone", zone, "order_id", order.OrderID, "error", err)
+				best = scanNearestRider(order, riders, free)
+			}
+		} else {
+			best = scanNearestRider(order, riders, free)
+		}
+		if best != "" {
+			delete(free, best)
+			assignments = append(assignments, Assignment{OrderID: order.OrderID, RiderID: best})
+		}
+	}
+	return assignments
+}
+
+// searchNearestRider finds the closest of the free riders to order's
+// restaurant by geo search, widening the search until one is found or the
+// dispatch radius is reached.
+func searchNearestRider(ctx context.Context, order DispatchOrder, free map[string]bool) (string, error) {
+	limit := appConfig.DispatchRadiusMeters
+	radius := min(appConfig.DispatchSearchRadiusMeters, limit)
+	if radius <= 0 {
+		radius = limit
+	}
+
+	for {
+		found, err := searchRiders(ctx, order.Pickup, radius)
+		if err != nil {
+			return "", err
+		}
+		// found is closest first, so the first free rider is the closest
+		// and any tied with them come straight after.
+		best, bestDistance, ties := "", 0.0, 0
+		for _, rider := range found {
+			if !free[rider.RiderID] || order.Declined[rider.RiderID] {
+				continue
+			}
+			if best == "" {
+				best, bestDistance, ties = rider.RiderID, rider.DistanceMeters, 1
+				continue
+			}
+			if rider.DistanceMeters > bestDistance {
+				break
+			}
+			ties++
+			if rng.IntN(ties) == 0 {
+				best = rider.RiderID
+			}
+		}
+		if best != "" || radius >= limit {
+			return best, nil
+		}
+		radius = min(radius*appConfig.DispatchSearchExpansion, limit)
+		slog.Debug("no free rider near restaurant, widening search", "order_id", order.OrderID, "radius_m", radius)
+	}
+}
+
+// scanNearestRider finds the closest of the free riders to order's
+// restaurant by measuring the distance to each.
+func scanNearestRider(order DispatchOrder, riders []RiderCandidate, free map[string]bool) string {
+	best, bestDistance, ties := "", 0.0, 0
+	for _, rider := range riders {
+		if !free[rider.RiderID] || !offerable(order, rider) {
+			continue
+		}
+		distance := pickupDistance(order, rider)
+		switch {
+		case best == "" || distance < bestDistance:
+			best, bestDistance, ties = rider.RiderID, distance, 1
+		case distance == bestDistance:
+			// Each of the tied riders ends up best with equal chance.
+			ties++
+			if rng.IntN(ties) == 0 {
+				best = rider.RiderID
+			}
+		}
+	}
+	return best
+}
+
+// batchDispatcher matches the whole round at once, repeatedly pairing the
+// closest remaining order and rider. Unlike nearest, an old order cannot
+// claim a rider who is far from it but right next to a newer one, so total
+// pickup distance across the batch is lower.
+type batchDispatcher struct{}
+
+func (batchDispatcher) Name() string { return "batch" }
+
+func (batchDispatcher) Assign(ctx context.Context, zone string, orders []DispatchOrder, riders []RiderCandidate) []Assignment {
+	type pair struct {
+		order, rider int
+		distance     float64
+	}
+
+	var pairs []pair
+	for i, order := range orders {
+		for j, rider := range riders {
+			if offerable(order, rider) {
+				pairs = append(pairs, pair{order: i, rider: j, distance: pickupDistance(order, rider)})
+			}
+		}
+	}
+	// Shuffled first, so pairs equally close are taken in no fixed order.
+	rng.Shuffle(len(pairs), func(a, b int) { pairs[a], pairs[b] = pairs[b], pairs[a] })
+	sort.SliceStable(pairs, func(a, b int) bool { return pairs[a].distance < pairs[b].distance })
+
+	orderTaken := make([]bool, len(orders))
+	riderTaken := make([]bool, len(riders))
+	var assignments []Assignment
+	for _, p := range pairs {
+		if orderTaken[p.order] || riderTaken[p.rider] {
+			continue
+		}
+		orderTaken[p.order], riderTaken[p.rider] = true, true
+		assignments = append(assignments, Assignment{OrderID: orders[p.order].OrderID, RiderID: riders[p.rider].RiderID})
+	}
+	return assignments
+}
+
+// roundRobinDispatcher spreads orders evenly over the riders within reach,
+// regardless of distance, cycling through them in ID order. The position in the cycle is
+// kept per zone in memory, so it restarts with the process.
+type roundRobinDispatcher struct {
+	mu   sync.Mutex
+	next map[string]int
+}
+
+func (*roundRobinDispatcher) Name() string { return "round_robin" }
+
+func (d *roundRobinDispatcher) Assign(ctx context.Context, zone string, orders []DispatchOrder, riders []RiderCandidate) []Assignment {
+	if len(riders) == 0 {
+		return nil
+	}
+
+	sorted := append([]RiderCandidate(nil), riders...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].RiderID < sorted[j].RiderID })
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	taken := map[string]bool{}
+	var assignments []Assignment
+	for _, order := range orders {
+		for k := 0; k < len(sorted); k++ {
+			i := (d.next[zone] + k) % len(sorted)
+			rider := sorted[i]
+			if taken[rider.RiderID] || !offerable(order, rider) {
+				continue
+			}
+			taken[rider.RiderID] = true
+			d.next[zone] = i + 1
+			assignments = append(assignments, Assignment{OrderID: order.OrderID, RiderID: rider.RiderID})
+			break
+		}
+	}
+	return assignments
+}
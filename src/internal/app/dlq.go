@@ -0,0 +1,144 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/segmentio/kafka-go"
+
+	"myproject/src/clock"
+)
+
+const (
+	dlqTopic = "orders-dlq"
+
+	// dlqHeaderPrefix marks the headers added when a message is dead-lettered;
+	// they are stripped again on re-drive.
+	dlqHeaderPrefix = "dlq-"
+
+	// dlqRedriveIdle is how long a re-drive waits for the next message before
+	// deciding the queue is drained. It covers the consumer group join too.
+	dlqRedriveIdle = 10 * time.Second
+)
+
+var kafkaDLQWriter messageWriter
+
+// permanentError marks a processing failure that retrying cannot fix, such
+// as a malformed payload. Such messages go straight to the DLQ.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return permanentError{err: err}
+}
+
+func isPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+type RedriveRequest struct {
+	Limit int `json:"limit" validate:"omitempty,gt=0,lte=1000"`
+}
+
+// publishToDLQ copies msg to the dead-letter topic with headers describing
+// where it came from and why it failed.
+func publishToDLQ(ctx context.Context, msg kafka.Message, eventType string, attempts int, cause error) error {
+	headers := make([]kafka.Header, 0, len(msg.Headers)+7)
+	headers = append(headers, msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: dlqHeaderPrefix + "error", Value: []byte(cause.Error())},
+		kafka.Header{Key: dlqHeaderPrefix + "event-type", Value: []byte(eventType)},
+		kafka.Header{Key: dlqHeaderPrefix + "attempts", Value: []byte(strconv.Itoa(attempts))},
+		kafka.Header{Key: dlqHeaderPrefix + "original-topic", Value: []byte(msg.Topic)},
+		kafka.Header{Key: dlqHeaderPrefix + "original-partition", Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: dlqHeaderPrefix + "original-offset", Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafka.Header{Key: dlqHeaderPrefix + "failed-at", Value: []byte(clock.Now().UTC().Format(time.RFC3339))},
+	)
+
+	return publishMessage(ctx, kafkaDLQWriter, "dead_letter", kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+	})
+}
+
+// redriveDLQ serves POST /admin/dlq/redrive. It moves up to limit messages
+// from the dead-letter topic back onto the topic their event type is
+// published to, stopping early once the queue is drained.
+func redriveDLQ(c echo.Context) error {
+	var req RedriveRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+	if req.Limit == 0 {
+		req.Limit = 100
+	}
+
+	r := bus.GroupReader(regionTopic(dlqTopic), regionTopic("orders-dlq-redrive"))
+	defer r.Close()
+
+	logger := requestLogger(c)
+	redriven := 0
+	for redriven < req.Limit {
+		fetchCtx, cancel := context.WithTimeout(c.Request().Context(), dlqRedriveIdle)
+		msg, err := r.FetchMessage(fetchCtx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			logger.Error("error reading dlq", "error", err)
+			return c.JSON(http.StatusBadGateway, map[string]interface{}{"error": "Failed to read dead-letter queue", "redriven": redriven})
+		}
+
+		w := orderEventRouter.writer(dlqHeader(msg.Headers, "event-type"))
+		err = publishMessage(c.Request().Context(), w, "redrive", kafka.Message{
+			Key:     msg.Key,
+			Value:   msg.Value,
+			Headers: withoutDLQHeaders(msg.Headers),
+		})
+		if err != nil {
+			logger.Error("error re-driving dlq message", "offset", msg.Offset, "error", err)
+			return c.JSON(http.StatusBadGateway, map[string]interface{}{"error": "Failed to re-drive message", "redriven": redriven})
+		}
+
+		err = r.CommitMessages(context.Background(), msg)
+		if err != nil {
+			logger.Error("error committing dlq message", "offset", msg.Offset, "error", err)
+		}
+		redriven++
+	}
+
+	logger.Info("dlq re-driven", "redriven", redriven)
+	return c.JSON(http.StatusOK, map[string]int{"redriven": redriven})
+}
+
+// dlqHeader is the value of the dead-letter header name, or "".
+func dlqHeader(headers []kafka.Header, name string) string {
+	for _, h := range headers {
+		if h.Key == dlqHeaderPrefix+name {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func withoutDLQHeaders(headers []kafka.Header) []kafka.Header {
+	kept := make([]kafka.Header, 0, len(headers))
+	for _, h := range headers {
+		if !strings.HasPrefix(h.Key, dlqHeaderPrefix) {
+			kept = append(kept, h)
+		}
+	}
+	return kept
+}
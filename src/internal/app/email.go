@@ -0,0 +1,42 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+type EmailSender struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s EmailSender) Send(to []string, subject, body string) error {
+	if s.Addr == "" {
+		return fmt.Errorf("smtp address is not configured")
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid smtp address %q: %w", s.Addr, err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	msg := "From: " + s.From + "\r\n" +
+		"To: " + strings.Join(to, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body
+
+	err := smtp.SendMail(s.Addr, auth, s.From, to, []byte(msg))
+	if err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
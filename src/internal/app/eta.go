@@ -0,0 +1,107 @@
+package app
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+// etaRoadFactor scales straight-line distance up to a typical distance by
+// road.
+const etaRoadFactor = 1.3
+
+const (
+	etaBasisRider      = "rider_location"
+	etaBasisRestaurant = "restaurant"
+)
+
+type OrderETA struct {
+	OrderID        string         `json:"order_id"`
+	Status         string         `json:"status"`
+	Basis          string         `json:"basis"`
+	DistanceMeters float64        `json:"distance_m"`
+	ETASeconds     int            `json:"eta_seconds"`
+	ETA            time.Time      `json:"eta"`
+	RiderPosition  *RiderPosition `json:"rider_position,omitempty"`
+	// ReadyBy is the restaurant's committed ready time, until pickup.
+	// RunningLate is set once the order is OrderReadyLateAfter past it.
+	ReadyBy     *Timestamp `json:"ready_by,omitempty"`
+	RunningLate bool       `json:"running_late,omitempty"`
+}
+
+// getOrderETA serves GET /order/:id/eta. Once the order is picked up the
+// estimate runs from the rider's latest position to the delivery location;
+// before that, or if the rider has stopped reporting, it runs from the
+// restaurant, setting off no sooner than the order is due to be ready.
+func getOrderETA(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	order, err := getOrder(ctx, c.Param("id"))
+	if err == errOrderNotFound || (err == nil && !canViewOrder(c, order)) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+
+	if terminalStatuses[order.Status] {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Order is " + order.Status})
+	}
+	if order.DeliveryLocation == nil {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": "Order has no delivery location"})
+	}
+
+	restaurant, err := findRestaurant(ctx, order.RestaurantID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant"})
+	}
+
+	eta := OrderETA{
+		OrderID: order.OrderID,
+		Status:  order.Status,
+		Basis:   etaBasisRestaurant,
+	}
+	from := GeoPoint{Lat: restaurant.Lat, Lng: restaurant.Lng}
+
+	if order.Status == "picked_up" && order.RiderID != "" {
+		position, err := latestRiderPosition(ctx, order.RiderID)
+		if err != nil {
+			requestLogger(c).Error("error fetching rider position", "order_id", order.OrderID, "rider_id", order.RiderID, "error", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch rider location"})
+		}
+		if position != nil {
+			eta.Basis = etaBasisRider
+			eta.RiderPosition = position
+			from = GeoPoint{Lat: position.Lat, Lng: position.Lng}
+		}
+	}
+
+	now := clock.Now().UTC()
+	departs := now
+	if order.Status == "accepted" && order.ReadyBy != nil {
+		eta.ReadyBy = order.ReadyBy
+		eta.RunningLate = now.After(order.ReadyBy.Add(appConfig.OrderReadyLateAfter))
+		if order.ReadyBy.After(now) {
+			departs = order.ReadyBy.Time
+		}
+	}
+
+	eta.DistanceMeters = math.Round(distanceMeters(from.Lat, from.Lng, order.DeliveryLocation.Lat, order.DeliveryLocation.Lng))
+	eta.ETA = departs.Add(travelTime(eta.DistanceMeters)).Truncate(time.Second)
+	eta.ETASeconds = int(eta.ETA.Sub(now).Seconds())
+
+	return c.JSON(http.StatusOK, eta)
+}
+
+// travelTime estimates how long a rider takes to cover a straight-line
+// distance at RiderSpeedKmh.
+func travelTime(meters float64) time.Duration {
+	metersPerSecond := appConfig.RiderSpeedKmh * 1000 / 3600
+	if metersPerSecond <= 0 {
+		return 0
+	}
+	return time.Duration(meters * etaRoadFactor / metersPerSecond * float64(time.Second)).Round(time.Second)
+}
@@ -0,0 +1,161 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// The event bus carries order events between the service and its consumers.
+// It is Kafka unless BACKEND=memory, when memoryBus stands in so the service
+// can run on its own; see memory_backend.go.
+
+// messageWriter publishes to one topic.
+type messageWriter interface {
+	Topic() string
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// messageReader reads one topic, either as a member of a consumer group or
+// from a single partition at an offset chosen with SetOffset or SetOffsetAt.
+// *kafka.Reader satisfies it.
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	ReadMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	SetOffset(offset int64) error
+	SetOffsetAt(ctx context.Context, t time.Time) error
+	// Offset is the offset of the next message a partition reader reads.
+	Offset() int64
+	Close() error
+}
+
+type eventBus interface {
+	Writer(topic string) messageWriter
+	GroupReader(topic, groupID string) messageReader
+	PartitionReader(topic string, partition int) messageReader
+	Partitions(ctx context.Context, topic string) ([]int, error)
+	// Ping reports whether the bus can be reached, for readiness checks.
+	Ping(ctx context.Context) error
+}
+
+var bus eventBus
+
+type kafkaBus struct {
+	brokers []string
+}
+
+func newKafkaBus(brokers []string) kafkaBus {
+	return kafkaBus{brokers: brokers}
+}
+
+type kafkaTopicWriter struct {
+	*kafka.Writer
+}
+
+func (w kafkaTopicWriter) Topic() string {
+	return w.Writer.Topic
+}
+
+func (b kafkaBus) Writer(topic string) messageWriter {
+	return breakerWriter{kafkaTopicWriter{&kafka.Writer{
+		Addr:  kafka.TCP(b.brokers...),
+		Topic: topic,
+		// Keyed messages go by key, so those for one order stay in order.
+		Balancer: &kafka.Hash{},
+		// Order events come batched from the eventProducer, so what the
+		// writer is given goes out at once rather than after the default
+		// second's wait for more.
+		BatchSize:    max(appConfig.ProducerBatchSize, 1),
+		BatchTimeout: time.Millisecond,
+	}}}
+}
+
+func (b kafkaBus) GroupReader(topic, groupID string) messageReader {
+	logger := slog.With("topic", topic, "group", groupID)
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     b.brokers,
+		GroupID:     groupID,
+		Topic:       topic,
+		Logger:      groupLogger(logger),
+		ErrorLogger: kafka.LoggerFunc(func(msg string, args ...interface{}) { logger.Warn(fmt.Sprintf(msg, args...)) }),
+	})
+}
+
+// groupMembershipLogs are the starts of the reader's log lines about
+// joining the group and the partitions it is given, logged at info so a
+// rebalance can be followed; the rest of its chatter is logged at debug.
+var groupMembershipLogs = []string{
+	"joined group",
+	"selected as leader",
+	"assigned member",
+	"received empty assignments",
+	"subscribed to topics and partitions",
+	"Partition changes found",
+}
+
+func groupLogger(logger *slog.Logger) kafka.Logger {
+	return kafka.LoggerFunc(func(msg string, args ...interface{}) {
+		line := fmt.Sprintf(msg, args...)
+		for _, prefix := range groupMembershipLogs {
+			if strings.HasPrefix(line, prefix) {
+				logger.Info(line)
+				return
+			}
+		}
+		logger.Debug(line)
+	})
+}
+
+func (b kafkaBus) PartitionReader(topic string, partition int) messageReader {
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   b.brokers,
+		Topic:     topic,
+		Partition: partition,
+	})
+}
+
+func (b kafkaBus) Partitions(ctx context.Context, topic string) ([]int, error) {
+	var lastErr error
+	for _, broker := range b.brokers {
+		conn, err := kafka.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		partitions, err := conn.ReadPartitions(topic)
+		conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		ids := make([]int, len(partitions))
+		for i, partition := range partitions {
+			ids[i] = partition.ID
+		}
+		return ids, nil
+	}
+	return nil, fmt.Errorf("no kafka broker reachable: %v", lastErr)
+}
+
+// Ping succeeds if at least one broker accepts a connection, which is enough
+// for the writers to discover the rest of the cluster.
+func (b kafkaBus) Ping(ctx context.Context) error {
+	var lastErr error
+	for _, broker := range b.brokers {
+		conn, err := kafka.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		conn.Close()
+		return nil
+	}
+	return fmt.Errorf("no kafka broker reachable: %v", lastErr)
+}
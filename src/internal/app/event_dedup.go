@@ -0,0 +1,79 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Event deduplication. Offsets are committed only once an event has been
+// handled, so an event being handled when the consumer stops is read again
+// when it restarts, and the broker may deliver one twice anyway. Each event
+// handled successfully is remembered by ID for EventDedupTTL and skipped if
+// it comes round again. Notifications are also claimed one by one, so an
+// event retried after some of its handlers succeeded, or redelivered before
+// it was remembered, does not send the same notification twice.
+//
+// An event sent to the dead-letter queue is not remembered, so redriving it
+// runs it again.
+
+func processedEventKey(eventID string) string {
+	return "event:" + eventID + ":processed"
+}
+
+func notificationClaimKey(eventID, recipientType, recipientID, template string) string {
+	return fmt.Sprintf("event:%s:notified:%s:%s:%s", eventID, recipientType, recipientID, template)
+}
+
+// eventProcessed reports whether event has already been handled. Events
+// without an ID cannot be told apart and never have.
+func eventProcessed(ctx context.Context, event OrderEvent) (bool, error) {
+	if event.EventID == "" {
+		return false, nil
+	}
+	n, err := redisClient.Exists(ctx, processedEventKey(event.EventID)).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis error: %v", err)
+	}
+	return n > 0, nil
+}
+
+// markEventProcessed remembers event as handled. Failing to is only logged:
+// at worst the event is handled again if it is redelivered.
+func markEventProcessed(ctx context.Context, event OrderEvent) {
+	if event.EventID == "" {
+		return
+	}
+	err := redisClient.Set(ctx, processedEventKey(event.EventID), 1, appConfig.EventDedupTTL).Err()
+	if err != nil {
+		eventLogger(event).Warn("error marking order event processed", "error", err)
+	}
+}
+
+// claimNotification reserves sending template to the recipient for event. It
+// returns false if the notification was claimed before, in which case it
+// must not be sent again.
+func claimNotification(ctx context.Context, event OrderEvent, recipientType, recipientID, template string) (bool, error) {
+	if event.EventID == "" {
+		return true, nil
+	}
+	key := notificationClaimKey(event.EventID, recipientType, recipientID, template)
+	claimed, err := redisClient.SetNX(ctx, key, 1, appConfig.EventDedupTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis error: %v", err)
+	}
+	return claimed, nil
+}
+
+// releaseNotification gives up a claim on a notification that could not be
+// sent, so a retry sends it.
+func releaseNotification(ctx context.Context, event OrderEvent, recipientType, recipientID, template string) {
+	if event.EventID == "" {
+		return
+	}
+	key := notificationClaimKey(event.EventID, recipientType, recipientID, template)
+	err := redisClient.Del(ctx, key).Err()
+	if err != nil {
+		slog.Warn("error releasing notification claim", "event_id", event.EventID, "template", template, "error", err)
+	}
+}
@@ -0,0 +1,235 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+)
+
+// Async publishing. Order events are handed to an eventProducer, which
+// buffers them and writes them to Kafka in batches, one WriteMessages call
+// per topic, once ProducerBatchSize are waiting or every
+// ProducerFlushInterval. Each event's callback is told how its write went.
+// When the buffer is full, handing over an event waits for room, so a burst
+// slows its producers down rather than growing the buffer without bound.
+//
+// Events for a topic are written in the order they were handed over. When a
+// write to a topic fails, the events for that topic handed over before the
+// failure and still waiting fail too, without being written, so a caller
+// that retries failed events in order cannot get a later event in ahead of
+// an earlier one.
+
+var (
+	errProducerClosed = errors.New("event producer closed")
+	// errEarlierWriteFailed fails records queued behind a failed write.
+	errEarlierWriteFailed = errors.New("an earlier write to the topic failed")
+)
+
+var (
+	kafkaProducerQueued = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kafka_producer_queued_messages",
+		Help: "Messages buffered by the async producer, waiting to be written.",
+	})
+
+	kafkaProducerBackpressure = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kafka_producer_backpressure_total",
+		Help: "Times a publisher had to wait for room in the async producer's buffer.",
+	})
+
+	kafkaProducerBatchSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kafka_producer_batch_size",
+		Help:    "Messages written per batch by the async producer.",
+		Buckets: []float64{1, 2, 5, 10, 25, 50, 100, 250, 500},
+	}, []string{"topic"})
+)
+
+func init() {
+	prometheus.MustRegister(kafkaProducerQueued, kafkaProducerBackpressure, kafkaProducerBatchSize)
+}
+
+// producerRecord is one message waiting to be written.
+type producerRecord struct {
+	w     messageWriter
+	event string
+	msg   kafka.Message
+	// done is called once with the result of writing msg. It runs on the
+	// producer's goroutine, so it must not block.
+	done func(error)
+	seq  uint64
+}
+
+type eventProducer struct {
+	records       chan producerRecord
+	batchSize     int
+	flushInterval time.Duration
+
+	// mu guards closed; enqueue holds it for reading while it hands a
+	// record over, so none can arrive once close has started draining.
+	mu     sync.RWMutex
+	closed bool
+	// seq numbers records in the order they are handed over.
+	seq atomic.Uint64
+
+	closing chan struct{}
+	stopped chan struct{}
+	// failedUpTo is, per topic, the seq of the last record handed over
+	// when a write to the topic failed; records up to it fail unwritten.
+	failedUpTo map[string]uint64
+}
+
+// newEventProducer starts a producer buffering up to bufferSize messages.
+func newEventProducer(bufferSize, batchSize int, flushInterval time.Duration) *eventProducer {
+	p := &eventProducer{
+		records:       make(chan producerRecord, max(bufferSize, 1)),
+		batchSize:     max(batchSize, 1),
+		flushInterval: flushInterval,
+		closing:       make(chan struct{}),
+		stopped:       make(chan struct{}),
+		failedUpTo:    map[string]uint64{},
+	}
+	go p.run()
+	return p
+}
+
+// enqueue hands msg over to be written to w, stamped with the request ID in
+// ctx, waiting for room in the buffer if need be. It returns an error only if msg was not taken, because ctx
+// ended first or the producer is closed; otherwise done is told the result.
+func (p *eventProducer) enqueue(ctx context.Context, w messageWriter, event string, msg kafka.Message, done func(error)) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errProducerClosed
+	}
+
+	msg.Headers = withRequestIDHeader(ctx, msg.Headers)
+	record := producerRecord{w: w, event: event, msg: msg, done: done, seq: p.seq.Add(1)}
+	select {
+	case p.records <- record:
+		return nil
+	default:
+	}
+
+	kafkaProducerBackpressure.Inc()
+	select {
+	case p.records <- record:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *eventProducer) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	var pending []producerRecord
+	for {
+		select {
+		case record := <-p.records:
+			pending = append(pending, record)
+			if len(pending) < p.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-p.closing:
+			// Nothing more can be handed over, so whatever is buffered is
+			// the last of it.
+			for len(p.records) > 0 {
+				pending = append(pending, <-p.records)
+			}
+			p.flush(pending)
+			return
+		}
+		p.flush(pending)
+		pending = pending[:0]
+	}
+}
+
+// flush writes pending, one batch per topic, and reports each record's
+// result to its callback.
+func (p *eventProducer) flush(pending []producerRecord) {
+	kafkaProducerQueued.Set(float64(len(p.records)))
+	if len(pending) == 0 {
+		return
+	}
+
+	var topics []string
+	byTopic := map[string][]producerRecord{}
+	for _, record := range pending {
+		topic := record.w.Topic()
+		if _, ok := byTopic[topic]; !ok {
+			topics = append(topics, topic)
+		}
+		byTopic[topic] = append(byTopic[topic], record)
+	}
+
+	for _, topic := range topics {
+		var records []producerRecord
+		for _, record := range byTopic[topic] {
+			if record.seq <= p.failedUpTo[topic] {
+				kafkaPublishTotal.WithLabelValues(topic, record.event, "failure").Inc()
+				record.done(errEarlierWriteFailed)
+				continue
+			}
+			records = append(records, record)
+		}
+		if len(records) > 0 {
+			p.write(topic, records)
+		}
+	}
+}
+
+func (p *eventProducer) write(topic string, records []producerRecord) {
+	msgs := make([]kafka.Message, len(records))
+	for i, record := range records {
+		msgs[i] = record.msg
+		msgs[i].Headers = withBuildHeaders(msgs[i].Headers)
+	}
+
+	start := time.Now()
+	err := records[0].w.WriteMessages(context.Background(), msgs...)
+	elapsed := time.Since(start).Seconds()
+	kafkaProducerBatchSize.WithLabelValues(topic).Observe(float64(len(msgs)))
+
+	var writeErrs kafka.WriteErrors
+	perMessage := errors.As(err, &writeErrs) && len(writeErrs) == len(records)
+	for i, record := range records {
+		recordErr := err
+		if perMessage {
+			recordErr = writeErrs[i]
+		}
+		result := "success"
+		if recordErr != nil {
+			result = "failure"
+		}
+		kafkaPublishDuration.WithLabelValues(topic, record.event).Observe(elapsed)
+		kafkaPublishTotal.WithLabelValues(topic, record.event, result).Inc()
+		record.done(recordErr)
+	}
+
+	if err != nil {
+		p.failedUpTo[topic] = p.seq.Load()
+	}
+}
+
+// close writes what is buffered and stops the producer, waiting until ctx
+// ends at most.
+func (p *eventProducer) close(ctx context.Context) {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.closing)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.stopped:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,161 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/segmentio/kafka-go"
+
+	"myproject/src/events"
+	"myproject/src/handlers"
+)
+
+// The event types, under the names the rest of package app uses.
+const (
+	eventOrderCreated   = events.OrderCreated
+	eventOrderPaid      = events.OrderPaid
+	eventOrderUpdated   = events.OrderUpdated
+	eventOrderRefunded  = events.OrderRefunded
+	eventOrderAccepted  = events.OrderAccepted
+	eventOrderRejected  = events.OrderRejected
+	eventOrderPickedUp  = events.OrderPickedUp
+	eventOrderDelivered = events.OrderDelivered
+	eventOrderCancelled = events.OrderCancelled
+	eventOrderExpired   = events.OrderExpired
+	eventOrderTimedOut  = events.OrderTimedOut
+	eventRiderAssigned  = events.RiderAssigned
+	eventRiderArrived   = events.RiderArrived
+	eventRiderLocation  = events.RiderLocation
+
+	eventOrderReadySoon   = events.OrderReadySoon
+	eventOrderRunningLate = events.OrderRunningLate
+	eventOrderTipped      = events.OrderTipped
+	eventRiderUnassigned  = events.RiderUnassigned
+	eventRiderArriving    = events.RiderArriving
+
+	eventOrderReadyByChanged = events.OrderReadyByChanged
+
+	requestIDHeader = events.RequestIDHeader
+)
+
+var orderEventTypes = events.Types
+
+// decodeOrderEvent reads the order event in a message's value, whichever
+// schema version it was published in, and counts the version.
+func decodeOrderEvent(value []byte) (OrderEvent, error) {
+	event, version, err := events.Decode(value)
+	result := "success"
+	if err != nil {
+		result = "invalid"
+	}
+	orderEventsDecoded.WithLabelValues(strconv.Itoa(version), result).Inc()
+	return event, err
+}
+
+type OrderEvent = events.OrderEvent
+
+// newOrderEvent describes order for an event of eventType, caused by the
+// request in ctx if there is one.
+func newOrderEvent(ctx context.Context, eventType string, order Order) OrderEvent {
+	event := OrderEvent{
+		RequestID:    handlers.RequestID(ctx),
+		Type:         eventType,
+		Region:       appConfig.Region,
+		OrderID:      order.OrderID,
+		OrderCode:    order.Code,
+		RestaurantID: order.RestaurantID,
+		CustomerID:   order.CustomerID,
+		Status:       order.Status,
+		RiderID:      order.RiderID,
+		TotalAmount:  order.TotalAmount,
+		Tip:          order.Tip,
+		Gift:         order.Gift != nil,
+		Reason:       order.StatusReason,
+		ReadyBy:      order.ReadyBy,
+		OccurredAt:   timestampNow(),
+	}
+	if eventType == eventOrderDelivered && order.DeliveryProof != nil {
+		event.ProofURL = order.DeliveryProof.PhotoURL
+	}
+	return event
+}
+
+// publishOrderEvent hands event to the producer for its topic, keyed by
+// order ID so all events for one order land on the same partition in order.
+// It waits while the producer's buffer is full, and returns an error if ctx
+// ends first. done, if not nil, is called with the result of the write once
+// Kafka has answered; like every producer callback it must not block.
+func publishOrderEvent(ctx context.Context, event OrderEvent, done func(error)) error {
+	msg, err := orderEventMessage(event)
+	if err != nil {
+		return err
+	}
+
+	logger := eventLogger(event)
+	logger.Debug("publishing to kafka")
+
+	err = orderEventRouter.publish(ctx, event.Type, msg, func(err error) {
+		if err == nil {
+			logger.Info("event published to kafka")
+		} else {
+			err = fmt.Errorf("failed to publish to Kafka: %w", err)
+		}
+		if done != nil {
+			done(err)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to queue %s event: %w", event.Type, err)
+	}
+	return nil
+}
+
+// orderEventMessage encodes event as it is published, in an envelope of the
+// configured schema version and keyed by order ID.
+func orderEventMessage(event OrderEvent) (kafka.Message, error) {
+	value, err := events.Encode(event, appConfig.EventSchemaVersion)
+	if err != nil {
+		return kafka.Message{}, fmt.Errorf("failed to encode %s event: %v", event.Type, err)
+	}
+	msg := kafka.Message{Key: []byte(event.OrderID), Value: value}
+	if event.RequestID != "" {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: requestIDHeader, Value: []byte(event.RequestID)})
+	}
+	return msg, nil
+}
+
+// eventLogger logs about event, with the request that caused it.
+func eventLogger(event OrderEvent) *slog.Logger {
+	logger := slog.With("type", event.Type, "order_id", event.OrderID)
+	if event.RequestID != "" {
+		logger = logger.With("request_id", event.RequestID)
+	}
+	return logger
+}
+
+// withRequestIDHeader adds the request ID carried by ctx to headers, unless
+// there is none or they already have one.
+func withRequestIDHeader(ctx context.Context, headers []kafka.Header) []kafka.Header {
+	requestID := handlers.RequestID(ctx)
+	if requestID == "" {
+		return headers
+	}
+	for _, h := range headers {
+		if h.Key == requestIDHeader {
+			return headers
+		}
+	}
+	return append(headers, kafka.Header{Key: requestIDHeader, Value: []byte(requestID)})
+}
+
+// messageRequestID returns the request ID in msg's headers, or "".
+func messageRequestID(msg kafka.Message) string {
+	for _, h := range msg.Headers {
+		if h.Key == requestIDHeader {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/segmentio/kafka-go"
+)
+
+type FavoriteRequest struct {
+	RestaurantID string `json:"restaurant_id" validate:"required"`
+}
+
+type AnnouncementRequest struct {
+	Type    string `json:"type" validate:"required,oneof=menu_item campaign"`
+	Title   string `json:"title" validate:"required,max=120"`
+	Message string `json:"message" validate:"required,max=500"`
+}
+
+func customerFavoritesKey(customerID string) string {
+	return "customer:" + customerID + ":favorites"
+}
+
+func restaurantFollowersKey(restaurantID string) string {
+	return "restaurant:" + restaurantID + ":followers"
+}
+
+func followNotifyCapKey(customerID string) string {
+	return "follow-notify:" + customerID
+}
+
+func addFavorite(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req FavoriteRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	_, err := findRestaurant(ctx, req.RestaurantID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Restaurant not found"})
+	}
+
+	customerID := authClaims(c).Subject
+	pipe := redisClient.TxPipeline()
+	pipe.SAdd(ctx, customerFavoritesKey(customerID), req.RestaurantID)
+	pipe.SAdd(ctx, restaurantFollowersKey(req.RestaurantID), customerID)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save favorite"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "favorited"})
+}
+
+func removeFavorite(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("restaurantId")
+	customerID := authClaims(c).Subject
+
+	pipe := redisClient.TxPipeline()
+	removed := pipe.SRem(ctx, customerFavoritesKey(customerID), restaurantID)
+	pipe.SRem(ctx, restaurantFollowersKey(restaurantID), customerID)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove favorite"})
+	}
+	if removed.Val() == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Favorite not found"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "removed"})
+}
+
+func listFavorites(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	favorites, err := redisClient.SMembers(ctx, customerFavoritesKey(authClaims(c).Subject)).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch favorites"})
+	}
+	sort.Strings(favorites)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"restaurant_ids": favorites})
+}
+
+// publishAnnouncement lets a restaurant announce a new menu item or campaign
+// to the customers who follow it.
+func publishAnnouncement(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	if !actsForRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	var req AnnouncementRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+	var ok bool
+	if req.Title, ok = cleanText(authClaims(c), "title", req.Title); !ok {
+		return validationFailed(c, "title", textRejectedMessage)
+	}
+	if req.Message, ok = cleanText(authClaims(c), "message", req.Message); !ok {
+		return validationFailed(c, "message", textRejectedMessage)
+	}
+
+	followers, err := redisClient.SMembers(ctx, restaurantFollowersKey(restaurantID)).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch followers"})
+	}
+
+	notified, capped, failed := 0, 0, 0
+	for _, customerID := range followers {
+		allowed, err := takeFollowNotificationSlot(ctx, customerID)
+		if err != nil {
+			failed++
+			continue
+		}
+		if !allowed {
+			capped++
+			continue
+		}
+
+		err = publishFollowNotification(ctx, customerID, restaurantID, req)
+		if err != nil {
+			failed++
+			continue
+		}
+		notified++
+	}
+
+	requestLogger(c).Info("announcement fanned out", "restaurant_id", restaurantID, "type", req.Type, "notified", notified, "capped", capped, "failed", failed)
+	return c.JSON(http.StatusOK, map[string]int{
+		"followers": len(followers),
+		"notified":  notified,
+		"capped":    capped,
+		"failed":    failed,
+	})
+}
+
+// countFollowNotification counts one more notification in the customer's
+// window and starts the window on the first, in one step so a counter is
+// never left without an expiry.
+var countFollowNotification = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if redis.call("PTTL", KEYS[1]) < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// takeFollowNotificationSlot enforces the per-customer cap on follow
+// notifications within the configured window.
+func takeFollowNotificationSlot(ctx context.Context, customerID string) (bool, error) {
+	count, err := countFollowNotification.Run(ctx, redisClient, []string{followNotifyCapKey(customerID)}, appConfig.FollowNotifyWindow.Milliseconds()).Int64()
+	if err != nil {
+		return false, fmt.Errorf("redis error: %v", err)
+	}
+	return count <= int64(appConfig.FollowNotifyMax), nil
+}
+
+func publishFollowNotification(ctx context.Context, customerID, restaurantID string, req AnnouncementRequest) error {
+	message := fmt.Sprintf("Notification: customer %s | restaurant %s | %s: %s - %s", customerID, restaurantID, req.Type, req.Title, req.Message)
+
+	err := publishMessage(ctx, kafkaNotiWriter, "follow_notification", kafka.Message{
+		Key:   []byte(customerID),
+		Value: []byte(message),
+	})
+	if err != nil {
+		slog.Error("error publishing follow notification", "customer_id", customerID, "restaurant_id", restaurantID, "error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Feature flags. A flag turns a flow on for some restaurants while it is
+// rolled out: every restaurant, those listed, or a percentage of them picked
+// by hashing the restaurant ID with the flag's name, so a restaurant stays on
+// as the percentage grows and different flags pick different restaurants.
+// Each flag starts from its default below, which FEATURE_FLAGS overrides per
+// deployment. Admins override either at runtime under /admin/flags; the
+// overrides are kept in Redis, and every instance picks them up within
+// FEATURE_FLAG_REFRESH_INTERVAL. Deleting an override returns the flag to its
+// configured setting.
+
+const featureFlagsKey = "feature_flags"
+
+const (
+	flagSurgePricing    = "surge_pricing"
+	flagOrderChat       = "order_chat"
+	flagMenuSuggestions = "menu_suggestions"
+)
+
+const (
+	flagSourceDefault  = "default"
+	flagSourceConfig   = "config"
+	flagSourceOverride = "override"
+)
+
+// FeatureFlag is a flag's setting.
+type FeatureFlag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Enabled is the kill switch: a flag not enabled is off everywhere.
+	Enabled bool `json:"enabled"`
+	// Percentage is the share of restaurants the flag is on for.
+	Percentage int `json:"percentage"`
+	// Restaurants have the flag on whatever the percentage.
+	Restaurants []string `json:"restaurants,omitempty"`
+	// Source is where the setting comes from: default, config or override.
+	Source string `json:"source"`
+}
+
+type FeatureFlagRequest struct {
+	Enabled     bool     `json:"enabled"`
+	Percentage  int      `json:"percentage" validate:"min=0,max=100"`
+	Restaurants []string `json:"restaurants" validate:"max=1000,dive,required"`
+}
+
+// featureFlagDefaults are the flags there are. Flows already rolled out
+// everywhere default to on, so a deployment can turn them off.
+var featureFlagDefaults = map[string]FeatureFlag{
+	flagSurgePricing:    {Description: "Surge multipliers on delivery fees in zones short of riders", Enabled: true, Percentage: 100},
+	flagOrderChat:       {Description: "Messages between an order's customer and rider", Enabled: true, Percentage: 100},
+	flagMenuSuggestions: {Description: "Cross-sell suggestions as items are added to the cart", Enabled: true, Percentage: 100},
+}
+
+// featureFlagOverrides holds the runtime overrides as last read from Redis.
+var featureFlagOverrides = struct {
+	mu    sync.RWMutex
+	flags map[string]FeatureFlag
+}{flags: map[string]FeatureFlag{}}
+
+// validateFeatureFlags checks that FEATURE_FLAGS names known flags and
+// gives each on, off or a percentage such as 25%.
+func validateFeatureFlags() error {
+	for name, setting := range appConfig.FeatureFlags {
+		if _, ok := featureFlagDefaults[name]; !ok {
+			return fmt.Errorf("unknown feature flag %q", name)
+		}
+		if _, err := parseFlagSetting(setting); err != nil {
+			return fmt.Errorf("feature flag %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// parseFlagSetting reads on, off or a percentage such as 25%.
+func parseFlagSetting(setting string) (FeatureFlag, error) {
+	switch setting {
+	case "on":
+		return FeatureFlag{Enabled: true, Percentage: 100}, nil
+	case "off":
+		return FeatureFlag{}, nil
+	}
+	percentage, err := strconv.Atoi(strings.TrimSuffix(setting, "%"))
+	if err != nil || !strings.HasSuffix(setting, "%") || percentage < 0 || percentage > 100 {
+		return FeatureFlag{}, fmt.Errorf("setting %q is not on, off or a percentage from 0%% to 100%%", setting)
+	}
+	return FeatureFlag{Enabled: true, Percentage: percentage}, nil
+}
+
+// configuredFeatureFlag returns the flag's setting without any override.
+func configuredFeatureFlag(name string) FeatureFlag {
+	flag := featureFlagDefaults[name]
+	flag.Name, flag.Source = name, flagSourceDefault
+	if setting, ok := appConfig.FeatureFlags[name]; ok {
+		configured, err := parseFlagSetting(setting)
+		if err == nil {
+			flag.Enabled, flag.Percentage, flag.Source = configured.Enabled, configured.Percentage, flagSourceConfig
+		}
+	}
+	return flag
+}
+
+// currentFeatureFlag returns the flag's setting in force.
+func currentFeatureFlag(name string) FeatureFlag {
+	featureFlagOverrides.mu.RLock()
+	flag, ok := featureFlagOverrides.flags[name]
+	featureFlagOverrides.mu.RUnlock()
+	if ok {
+		return flag
+	}
+	return configuredFeatureFlag(name)
+}
+
+// featureEnabled reports whether the flag is on for the restaurant.
+func featureEnabled(name, restaurantID string) bool {
+	flag := currentFeatureFlag(name)
+	if !flag.Enabled {
+		return false
+	}
+	if slices.Contains(flag.Restaurants, restaurantID) {
+		return true
+	}
+	return rolloutBucket(name, restaurantID) < flag.Percentage
+}
+
+// rolloutBucket places the restaurant in one of 100 buckets for the flag.
+func rolloutBucket(name, restaurantID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + restaurantID))
+	return int(h.Sum32() % 100)
+}
+
+// refreshFeatureFlags reads the overrides from Redis. Overrides of flags no
+// longer known are ignored.
+func refreshFeatureFlags(ctx context.Context) error {
+	raw, err := redisClient.HGetAll(ctx, featureFlagsKey).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	flags := make(map[string]FeatureFlag, len(raw))
+	for name, data := range raw {
+		var flag FeatureFlag
+		if _, ok := featureFlagDefaults[name]; !ok || json.Unmarshal([]byte(data), &flag) != nil {
+			continue
+		}
+		flags[name] = flag
+	}
+
+	featureFlagOverrides.mu.Lock()
+	featureFlagOverrides.flags = flags
+	featureFlagOverrides.mu.Unlock()
+	return nil
+}
+
+// runFeatureFlags refreshes the overrides every interval until ctx is
+// cancelled. Until the first refresh, flags are as configured.
+func runFeatureFlags(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := refreshFeatureFlags(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("error refreshing feature flags", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// listFeatureFlags serves GET /admin/flags: every flag's setting in force,
+// by name.
+func listFeatureFlags(c echo.Context) error {
+	flags := make([]FeatureFlag, 0, len(featureFlagDefaults))
+	for name := range featureFlagDefaults {
+		flags = append(flags, currentFeatureFlag(name))
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return c.JSON(http.StatusOK, map[string]interface{}{"flags": flags})
+}
+
+// setFeatureFlag serves PUT /admin/flags/:name, overriding the flag's
+// setting on every instance.
+func setFeatureFlag(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	name := c.Param("name")
+	defaults, ok := featureFlagDefaults[name]
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Feature flag not found"})
+	}
+	var req FeatureFlagRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	flag := FeatureFlag{
+		Name:        name,
+		Description: defaults.Description,
+		Enabled:     req.Enabled,
+		Percentage:  req.Percentage,
+		Restaurants: req.Restaurants,
+		Source:      flagSourceOverride,
+	}
+	flagJSON, _ := json.Marshal(flag)
+	err := redisClient.HSet(ctx, featureFlagsKey, name, flagJSON).Err()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store feature flag"})
+	}
+
+	featureFlagOverrides.mu.Lock()
+	featureFlagOverrides.flags[name] = flag
+	featureFlagOverrides.mu.Unlock()
+
+	requestLogger(c).Info("feature flag overridden", "flag", name, "enabled", flag.Enabled, "percentage", flag.Percentage, "restaurants", len(flag.Restaurants))
+	return c.JSON(http.StatusOK, flag)
+}
+
+// clearFeatureFlag serves DELETE /admin/flags/:name, returning the flag to
+// its configured setting.
+func clearFeatureFlag(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	name := c.Param("name")
+	if _, ok := featureFlagDefaults[name]; !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Feature flag not found"})
+	}
+
+	err := redisClient.HDel(ctx, featureFlagsKey, name).Err()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to clear feature flag"})
+	}
+
+	featureFlagOverrides.mu.Lock()
+	delete(featureFlagOverrides.flags, name)
+	featureFlagOverrides.mu.Unlock()
+
+	requestLogger(c).Info("feature flag override cleared", "flag", name)
+	return c.JSON(http.StatusOK, configuredFeatureFlag(name))
+}
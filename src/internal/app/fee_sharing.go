@@ -0,0 +1,477 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+// Delivery fee sharing. Each order's delivery fee is split between its rider,
+// its restaurant and the platform by a FeeShareRule: the restaurant's
+// contract if it has one, else its zone's rule, else appConfig.FeeShare. The
+// split is fixed on the order when it is placed, and once it is delivered
+// each party's share is entered in its ledger: riders' earnings, restaurants'
+// payouts and the platform's own. The ledgers only ever copy the order's
+// split, so they always agree with each other and with the order. A rider's
+// entry adds the order's tip, which is theirs in full; see rider_payouts.go.
+
+const (
+	feeShareZonesKey       = "feeshare:zones"
+	feeShareRestaurantsKey = "feeshare:restaurants"
+
+	partyRider      = "rider"
+	partyRestaurant = "restaurant"
+	partyPlatform   = "platform"
+
+	defaultLedgerDays = 30
+	maxLedgerDays     = 366
+)
+
+// FeeShareRule gives the percentage of the base delivery fee, and of any
+// surge on top of it, that goes to the rider and to the restaurant. The
+// platform keeps the rest.
+type FeeShareRule struct {
+	RiderPercent           float64 `json:"rider_percent" validate:"gte=0,lte=100"`
+	RestaurantPercent      float64 `json:"restaurant_percent" validate:"gte=0,lte=100"`
+	SurgeRiderPercent      float64 `json:"surge_rider_percent" validate:"gte=0,lte=100"`
+	SurgeRestaurantPercent float64 `json:"surge_restaurant_percent" validate:"gte=0,lte=100"`
+}
+
+// check reports which field makes the rule give away more than the fee, if
+// any does.
+func (r FeeShareRule) check() (string, string) {
+	if r.RiderPercent+r.RestaurantPercent > 100 {
+		return "restaurant_percent", "and rider_percent add up to more than 100"
+	}
+	if r.SurgeRiderPercent+r.SurgeRestaurantPercent > 100 {
+		return "surge_restaurant_percent", "and surge_rider_percent add up to more than 100"
+	}
+	return "", ""
+}
+
+func validateFeeShare() error {
+	rule := appConfig.FeeShare
+	for _, percent := range []float64{rule.RiderPercent, rule.RestaurantPercent, rule.SurgeRiderPercent, rule.SurgeRestaurantPercent} {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("fee share percentages must be between 0 and 100, got %v", percent)
+		}
+	}
+	if field, message := rule.check(); field != "" {
+		return fmt.Errorf("default fee share %s %s", field, message)
+	}
+	return nil
+}
+
+// feeShareRule finds the rule for an order from restaurantID, in zone.
+func feeShareRule(ctx context.Context, restaurantID, zone string) (FeeShareRule, string, error) {
+	pipe := redisClient.Pipeline()
+	contractCmd := pipe.HGet(ctx, feeShareRestaurantsKey, restaurantID)
+	zoneCmd := pipe.HGet(ctx, feeShareZonesKey, zone)
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return FeeShareRule{}, "", fmt.Errorf("redis error: %v", err)
+	}
+
+	for _, candidate := range []struct {
+		cmd  *redis.StringCmd
+		name string
+	}{{contractCmd, "restaurant:" + restaurantID}, {zoneCmd, "zone:" + zone}} {
+		data, err := candidate.cmd.Result()
+		if err == redis.Nil {
+			continue
+		}
+		var rule FeeShareRule
+		if err := json.Unmarshal([]byte(data), &rule); err != nil {
+			return FeeShareRule{}, "", fmt.Errorf("failed to parse fee share rule %s: %v", candidate.name, err)
+		}
+		return rule, candidate.name, nil
+	}
+	return appConfig.FeeShare, "default", nil
+}
+
+// splitDeliveryFee shares the delivery fee by rule. Shares are rounded to
+// the minor unit and the platform's is what is left, so they add up to the
+// fee exactly. The rider's share of the base and distance parts is worked
+// out separately, so its breakdown adds up too.
+func splitDeliveryFee(rule FeeShareRule, ruleName string, delivery deliveryPrice) FeeSplit {
+	currency := appConfig.PaymentCurrency
+	total, surge := toMinor(delivery.Fee, currency), toMinor(delivery.SurgeFee, currency)
+	distance := min(toMinor(delivery.DistanceFee, currency), total-surge)
+	base := total - surge - distance
+	riderDistance := distance.times(rule.RiderPercent / 100)
+	riderSurge := surge.times(rule.SurgeRiderPercent / 100)
+	rider := base.times(rule.RiderPercent/100) + riderDistance + riderSurge
+	restaurant := (base + distance).times(rule.RestaurantPercent/100) + surge.times(rule.SurgeRestaurantPercent/100)
+	return FeeSplit{
+		Rule:          ruleName,
+		DeliveryFee:   total.amount(currency),
+		SurgeFee:      surge.amount(currency),
+		DistanceFee:   distance.amount(currency),
+		Rider:         rider.amount(currency),
+		RiderDistance: riderDistance.amount(currency),
+		RiderSurge:    riderSurge.amount(currency),
+		Restaurant:    restaurant.amount(currency),
+		Platform:      (total - rider - restaurant).amount(currency),
+	}
+}
+
+// orderFeeSplit splits the order's delivery fee by the rule now in force for
+// its restaurant. Restaurants not on file (nil) are in the default zone.
+func orderFeeSplit(ctx context.Context, restaurantID string, restaurant *Restaurant, delivery deliveryPrice) (FeeSplit, error) {
+	zone := defaultDispatchZone
+	if restaurant != nil {
+		zone = zoneOrDefault(restaurant.Zone)
+	}
+	rule, name, err := feeShareRule(ctx, restaurantID, zone)
+	if err != nil {
+		return FeeSplit{}, err
+	}
+	return splitDeliveryFee(rule, name, delivery), nil
+}
+
+// LedgerEntry is one party's share of one delivered order's fee. A rider's
+// entry also breaks its Amount down: their share of the base, distance and
+// surge parts of the fee, and the customer's tip. A tip added after delivery
+// is a rider's entry of its own, under the rule "tip".
+type LedgerEntry struct {
+	OrderID     string    `json:"order_id"`
+	Rule        string    `json:"rule"`
+	DeliveryFee float64   `json:"delivery_fee"`
+	SurgeFee    float64   `json:"surge_fee"`
+	Amount      float64   `json:"amount"`
+	Base        float64   `json:"base,omitempty"`
+	Distance    float64   `json:"distance,omitempty"`
+	Surge       float64   `json:"surge,omitempty"`
+	Tip         float64   `json:"tip,omitempty"`
+	At          Timestamp `json:"at"`
+}
+
+type Ledger struct {
+	Party   string        `json:"party"`
+	PartyID string        `json:"party_id,omitempty"`
+	Days    int           `json:"days"`
+	Entries []LedgerEntry `json:"entries"`
+	Total   float64       `json:"total"`
+}
+
+// ledgerKey holds a party's entries by order ID; ledgerKey + ":index" orders
+// them by time.
+func ledgerKey(party, partyID string) string {
+	if party == partyPlatform {
+		return "ledger:" + partyPlatform
+	}
+	return "ledger:" + party + ":" + partyID
+}
+
+func ledgerIndexKey(party, partyID string) string {
+	return ledgerKey(party, partyID) + ":index"
+}
+
+// recordLedgerEntries enters an order in each ledger given, once: an order
+// already in a ledger is left as it is, so a redelivered event changes
+// nothing.
+// KEYS pairs of ledger and index; ARGV[1] order ID, ARGV[2] time in
+// milliseconds, ARGV[3..] an entry for each ledger.
+var recordLedgerEntries = redis.NewScript(`
+local recorded = 0
+for i = 1, #KEYS, 2 do
+	if redis.call("HSETNX", KEYS[i], ARGV[1], ARGV[2 + (i + 1) / 2]) == 1 then
+		redis.call("ZADD", KEYS[i + 1], ARGV[2], ARGV[1])
+		recorded = recorded + 1
+	end
+end
+return recorded
+`)
+
+// recordDeliveryLedger handles OrderDelivered: the order's fee split goes
+// into its rider's, its restaurant's and the platform's ledgers. Orders
+// placed before splits were kept are split now.
+func recordDeliveryLedger(ctx context.Context, event OrderEvent) error {
+	order, err := getOrder(ctx, event.OrderID)
+	if err == errOrderNotFound {
+		return permanent(err)
+	} else if err != nil {
+		return err
+	}
+
+	split := order.FeeSplit
+	if split == nil {
+		delivery := deliveryPrice{Fee: appConfig.DeliveryBaseFee}
+		if order.Pricing != nil {
+			delivery.Fee = order.Pricing.DeliveryFee
+		}
+		var restaurant *Restaurant
+		if found, err := findRestaurant(ctx, order.RestaurantID); err == nil {
+			restaurant = &found
+		} else if err != errRestaurantNotFound {
+			return err
+		}
+		computed, err := orderFeeSplit(ctx, order.RestaurantID, restaurant, delivery)
+		if err != nil {
+			return err
+		}
+		split = &computed
+	}
+
+	entry := func(amount float64) LedgerEntry {
+		return LedgerEntry{
+			OrderID:     order.OrderID,
+			Rule:        split.Rule,
+			DeliveryFee: split.DeliveryFee,
+			SurgeFee:    split.SurgeFee,
+			Amount:      amount,
+			At:          event.OccurredAt,
+		}
+	}
+	riderEntry := entry(split.Rider)
+	riderEntry.Base = roundMoney(split.Rider - split.RiderDistance - split.RiderSurge)
+	riderEntry.Distance = split.RiderDistance
+	riderEntry.Surge = split.RiderSurge
+	// A tip added after delivery is entered on its own; see order_tip.go.
+	if order.Pricing != nil && order.Pricing.Tip-order.LateTip > 0 {
+		riderEntry.Tip = roundMoney(order.Pricing.Tip - order.LateTip)
+		riderEntry.Amount = roundMoney(split.Rider + riderEntry.Tip)
+	}
+	shares := []struct {
+		party, partyID string
+		entry          LedgerEntry
+	}{
+		{partyRider, order.RiderID, riderEntry},
+		{partyRestaurant, order.RestaurantID, entry(split.Restaurant)},
+		{partyPlatform, "", entry(split.Platform)},
+	}
+
+	var keys []string
+	args := []interface{}{order.OrderID, event.OccurredAt.UnixMilli()}
+	for _, share := range shares {
+		if share.party != partyPlatform && share.partyID == "" {
+			slog.Warn("delivered order has no "+share.party+", leaving its share unrecorded", "order_id", order.OrderID, "amount", share.entry.Amount)
+			continue
+		}
+		entry, err := json.Marshal(share.entry)
+		if err != nil {
+			return permanent(err)
+		}
+		keys = append(keys, ledgerKey(share.party, share.partyID), ledgerIndexKey(share.party, share.partyID))
+		args = append(args, string(entry))
+	}
+
+	recorded, err := recordLedgerEntries.Run(ctx, redisClient, keys, args...).Int()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	slog.Info("delivery fee entered in ledgers", "order_id", order.OrderID, "rule", split.Rule, "rider", split.Rider, "restaurant", split.Restaurant, "platform", split.Platform, "recorded", recorded)
+	return nil
+}
+
+// readLedger returns the party's entries from the last days days, newest
+// first, and their total.
+func readLedger(ctx context.Context, party, partyID string, days int) (Ledger, error) {
+	ledger := Ledger{Party: party, PartyID: partyID, Days: days}
+
+	since := clock.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	var err error
+	ledger.Entries, err = ledgerEntries(ctx, party, partyID, &redis.ZRangeBy{
+		Min: strconv.FormatInt(since.UnixMilli(), 10),
+		Max: "+inf",
+	})
+	if err != nil {
+		return Ledger{}, err
+	}
+	for _, entry := range ledger.Entries {
+		ledger.Total += entry.Amount
+	}
+	ledger.Total = roundMoney(ledger.Total)
+	return ledger, nil
+}
+
+// ledgerEntries returns the party's entries entered at times, in
+// milliseconds, within span, newest first.
+func ledgerEntries(ctx context.Context, party, partyID string, span *redis.ZRangeBy) ([]LedgerEntry, error) {
+	entries := []LedgerEntry{}
+	orderIDs, err := redisClient.ZRevRangeByScore(ctx, ledgerIndexKey(party, partyID), span).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	if len(orderIDs) == 0 {
+		return entries, nil
+	}
+
+	values, err := redisClient.HMGet(ctx, ledgerKey(party, partyID), orderIDs...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var entry LedgerEntry
+		if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+			slog.Warn("skipping unreadable ledger entry", "party", party, "party_id", partyID, "order_id", orderIDs[i], "error", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ledgerDays reads how many days of ledger the query asks for, 30 by
+// default. ok is false if it asks for a number out of range.
+func ledgerDays(c echo.Context) (days int, ok bool) {
+	raw := c.QueryParam("days")
+	if raw == "" {
+		return defaultLedgerDays, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > maxLedgerDays {
+		return 0, false
+	}
+	return n, true
+}
+
+// respondLedger answers with the party's ledger over the days asked for in
+// the query, 30 by default.
+func respondLedger(c echo.Context, party, partyID string) error {
+	ctx := c.Request().Context()
+
+	days, ok := ledgerDays(c)
+	if !ok {
+		return validationFailed(c, "days", fmt.Sprintf("must be between 1 and %d", maxLedgerDays))
+	}
+
+	ledger, err := readLedger(ctx, party, partyID, days)
+	if err != nil {
+		requestLogger(c).Error("error fetching ledger", "party", party, "party_id", partyID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch ledger"})
+	}
+	return c.JSON(http.StatusOK, ledger)
+}
+
+// getRestaurantPayouts serves GET /restaurant/:id/payouts.
+func getRestaurantPayouts(c echo.Context) error {
+	restaurantID := c.Param("id")
+	if authClaims(c).Role != roleAdmin && !actsForRestaurant(c, restaurantID) && !ownsRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+	return respondLedger(c, partyRestaurant, restaurantID)
+}
+
+// getPlatformLedger serves GET /admin/ledger/platform.
+func getPlatformLedger(c echo.Context) error {
+	return respondLedger(c, partyPlatform, "")
+}
+
+// FeeSharing is every rule in force.
+type FeeSharing struct {
+	Default     FeeShareRule            `json:"default"`
+	Zones       map[string]FeeShareRule `json:"zones"`
+	Restaurants map[string]FeeShareRule `json:"restaurants"`
+}
+
+func readFeeShareRules(ctx context.Context, key string) (map[string]FeeShareRule, error) {
+	entries, err := redisClient.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	rules := make(map[string]FeeShareRule, len(entries))
+	for name, data := range entries {
+		var rule FeeShareRule
+		if err := json.Unmarshal([]byte(data), &rule); err != nil {
+			return nil, fmt.Errorf("failed to parse fee share rule %s: %v", name, err)
+		}
+		rules[name] = rule
+	}
+	return rules, nil
+}
+
+// getFeeSharing serves GET /admin/fee-sharing.
+func getFeeSharing(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	sharing := FeeSharing{Default: appConfig.FeeShare}
+	var err error
+	sharing.Zones, err = readFeeShareRules(ctx, feeShareZonesKey)
+	if err == nil {
+		sharing.Restaurants, err = readFeeShareRules(ctx, feeShareRestaurantsKey)
+	}
+	if err != nil {
+		requestLogger(c).Error("error fetching fee sharing rules", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch fee sharing rules"})
+	}
+	return c.JSON(http.StatusOK, sharing)
+}
+
+// putFeeShareRule stores the rule in the request under name in key. Orders
+// already placed keep the split they were placed with.
+func putFeeShareRule(c echo.Context, key, name string) error {
+	ctx := c.Request().Context()
+
+	var rule FeeShareRule
+	if err := bindAndValidate(c, &rule); err != nil {
+		return respondRequestError(c, err)
+	}
+	if field, message := rule.check(); field != "" {
+		return validationFailed(c, field, message)
+	}
+
+	ruleJSON, _ := json.Marshal(rule)
+	if err := redisClient.HSet(ctx, key, name, ruleJSON).Err(); err != nil {
+		requestLogger(c).Error("error saving fee sharing rule", "key", key, "name", name, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save fee sharing rule"})
+	}
+
+	requestLogger(c).Info("fee sharing rule set", "key", key, "name", name, "rider_percent", rule.RiderPercent, "restaurant_percent", rule.RestaurantPercent)
+	return c.JSON(http.StatusOK, rule)
+}
+
+func deleteFeeShareRule(c echo.Context, key, name string) error {
+	ctx := c.Request().Context()
+
+	removed, err := redisClient.HDel(ctx, key, name).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete fee sharing rule"})
+	}
+	if removed == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Fee sharing rule not found"})
+	}
+	requestLogger(c).Info("fee sharing rule removed", "key", key, "name", name)
+	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// setZoneFeeShare serves PUT /admin/fee-sharing/zones/:zone.
+func setZoneFeeShare(c echo.Context) error {
+	return putFeeShareRule(c, feeShareZonesKey, c.Param("zone"))
+}
+
+func deleteZoneFeeShare(c echo.Context) error {
+	return deleteFeeShareRule(c, feeShareZonesKey, c.Param("zone"))
+}
+
+// setRestaurantFeeShare serves PUT /admin/fee-sharing/restaurants/:id, the
+// restaurant's contract.
+func setRestaurantFeeShare(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	_, err := findRestaurant(ctx, restaurantID)
+	if err == errRestaurantNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Restaurant not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant"})
+	}
+	return putFeeShareRule(c, feeShareRestaurantsKey, restaurantID)
+}
+
+func deleteRestaurantFeeShare(c echo.Context) error {
+	return deleteFeeShareRule(c, feeShareRestaurantsKey, c.Param("id"))
+}
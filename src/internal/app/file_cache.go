@@ -0,0 +1,91 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileCaches holds one *fileCache per path.
+var fileCaches sync.Map
+
+// fileCache remembers the last decoded contents of a file together with the
+// mtime and size they were decoded from.
+type fileCache struct {
+	mu      sync.RWMutex
+	modTime time.Time
+	size    int64
+	value   any
+}
+
+// fileStamped is implemented by file contents whose entities carry
+// timestamps; entries the file leaves undated take the file's mtime.
+type fileStamped interface {
+	stampModTime(modTime time.Time)
+}
+
+// loadJSONFile decodes the JSON file at path into a T. The decoded value is
+// reused until the file's mtime or size changes, so cache misses elsewhere no
+// longer reread and reparse the file each time. Concurrent callers that find
+// the file changed wait for a single reload rather than each parsing it.
+//
+// The returned value is shared between callers and must not be modified;
+// copy any slice before changing its elements.
+func loadJSONFile[T any](path string) (T, error) {
+	var zero T
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return zero, err
+	}
+
+	entry, _ := fileCaches.LoadOrStore(path, &fileCache{})
+	cache := entry.(*fileCache)
+
+	cache.mu.RLock()
+	value, fresh := cache.lookup(info)
+	cache.mu.RUnlock()
+	if fresh {
+		if v, ok := value.(T); ok {
+			return v, nil
+		}
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	// Another caller may have reloaded the file while this one waited.
+	if value, fresh := cache.lookup(info); fresh {
+		if v, ok := value.(T); ok {
+			return v, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return zero, err
+	}
+
+	var v T
+	err = json.Unmarshal(data, &v)
+	if err != nil {
+		return zero, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if stamped, ok := any(&v).(fileStamped); ok {
+		stamped.stampModTime(info.ModTime())
+	}
+
+	cache.modTime = info.ModTime()
+	cache.size = info.Size()
+	cache.value = v
+	return v, nil
+}
+
+func (c *fileCache) lookup(info os.FileInfo) (any, bool) {
+	if c.value == nil || !c.modTime.Equal(info.ModTime()) || c.size != info.Size() {
+		return nil, false
+	}
+	return c.value, true
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+// Gift orders. The customer pays and the order goes to someone else, named
+// on the order with a phone number for the rider. Updates on the food's
+// progress go to the recipient, reached as recipient type gift_recipient
+// under the order's ID; the customer gets a receipt when the order is paid
+// and word when the gift is delivered. The package note put in the bag
+// carries the customer's message and no prices.
+
+const recipientGift = "gift_recipient"
+
+// saveGiftContact records where the recipient of a gift order is sent
+// updates.
+func saveGiftContact(ctx context.Context, rdb redis.Cmdable, order Order) error {
+	if order.Gift == nil || order.Gift.RecipientEmail == "" {
+		return nil
+	}
+	err := rdb.HSet(ctx, contactKey(recipientGift, order.OrderID), "email", order.Gift.RecipientEmail).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// notifyTracking sends an update on the order's progress to whoever is
+// waiting for the food: the recipient of a gift, otherwise the customer.
+// Gifts use giftTemplate if it is set.
+func notifyTracking(ctx context.Context, event OrderEvent, template, giftTemplate string, vars map[string]string) error {
+	if !event.Gift {
+		return notifyParty(ctx, event, "customer", event.CustomerID, template, vars)
+	}
+	if giftTemplate == "" {
+		giftTemplate = template
+	}
+	return notifyParty(ctx, event, recipientGift, event.OrderID, giftTemplate, vars)
+}
+
+// notifyGiftReceipt sends the customer who paid for a gift their receipt.
+func notifyGiftReceipt(ctx context.Context, event OrderEvent) error {
+	if !event.Gift {
+		return nil
+	}
+	return notifyParty(ctx, event, "customer", event.CustomerID, "gift_receipt", map[string]string{
+		"total": fmt.Sprintf("%.2f", event.TotalAmount),
+	})
+}
+
+// notifyGiftDelivered tells the customer who paid for a gift that it
+// arrived.
+func notifyGiftDelivered(ctx context.Context, event OrderEvent) error {
+	if !event.Gift {
+		return nil
+	}
+	return notifyParty(ctx, event, "customer", event.CustomerID, "gift_delivered", nil)
+}
+
+type PackageNoteLine struct {
+	Name     string   `json:"name"`
+	Quantity int      `json:"quantity"`
+	Price    *float64 `json:"price,omitempty"`
+}
+
+// PackageNote is the slip the restaurant puts in the bag. A gift's note
+// names the recipient, carries the customer's message and leaves out
+// prices.
+type PackageNote struct {
+	OrderID   string            `json:"order_id"`
+	OrderCode string            `json:"order_code"`
+	Gift      bool              `json:"gift"`
+	To        string            `json:"to,omitempty"`
+	Message   string            `json:"message,omitempty"`
+	Items     []PackageNoteLine `json:"items"`
+	Total     *float64          `json:"total,omitempty"`
+	Currency  string            `json:"currency,omitempty"`
+}
+
+func buildPackageNote(order Order) PackageNote {
+	note := PackageNote{OrderID: order.OrderID, OrderCode: order.Code, Items: []PackageNoteLine{}}
+	if gift := order.Gift; gift != nil {
+		note.Gift = true
+		note.To = gift.RecipientName
+		note.Message = gift.Message
+	}
+
+	if order.Pricing == nil {
+		for _, item := range order.Items {
+			note.Items = append(note.Items, PackageNoteLine{Name: item.MenuID, Quantity: item.Quantity})
+		}
+		return note
+	}
+	for _, item := range order.Pricing.Items {
+		line := PackageNoteLine{Name: item.Name, Quantity: item.Quantity}
+		if !note.Gift {
+			lineTotal := item.LineTotal
+			line.Price = &lineTotal
+		}
+		note.Items = append(note.Items, line)
+	}
+	if !note.Gift {
+		total := order.TotalAmount
+		note.Total, note.Currency = &total, order.Currency
+	}
+	return note
+}
+
+// getPackageNote serves GET /restaurant/order/:id/package-note.
+func getPackageNote(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	order, err := getOrder(ctx, c.Param("id"))
+	if err == errOrderNotFound || (err == nil && !actsForRestaurant(c, order.RestaurantID)) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+	return c.JSON(http.StatusOK, buildPackageNote(order))
+}
@@ -0,0 +1,525 @@
+package app
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative orderspb/orders.proto
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"myproject/src/handlers"
+	"myproject/src/orderspb"
+)
+
+// The gRPC API for internal services. It runs on its own port and calls the
+// same service layer as the REST handlers; only the encoding and the way
+// errors are reported differ. Callers authenticate with the same bearer
+// tokens, sent as "authorization" metadata.
+
+// grpcRequestIDKey is the metadata key carrying the request ID.
+const grpcRequestIDKey = "x-request-id"
+
+// grpcIfMatchKey and grpcETagKey carry an order's version, to and from the
+// server, as If-Match and ETag do over HTTP.
+const (
+	grpcIfMatchKey = "if-match"
+	grpcETagKey    = "etag"
+)
+
+type grpcContextKey string
+
+const (
+	grpcClaimsKey grpcContextKey = "auth_claims"
+	grpcLoggerKey grpcContextKey = "logger"
+)
+
+// grpcMethodRoles lists the roles allowed to call each method, as the REST
+// routes do. Methods not listed need no token.
+var grpcMethodRoles = map[string][]string{
+	orderspb.OrderService_PlaceOrder_FullMethodName:      {roleCustomer},
+	orderspb.OrderService_AcceptOrder_FullMethodName:     {roleRestaurant},
+	orderspb.OrderService_ConfirmPickup_FullMethodName:   {roleRider},
+	orderspb.OrderService_ConfirmDelivery_FullMethodName: {roleRider},
+}
+
+// grpcMethodRoutes are the REST routes the methods stand in for, so that a
+// method is signed whenever its route is in signedRoutes, and takes the
+// order's version whenever its route is in orderVersionRoutes.
+var grpcMethodRoutes = map[string]string{
+	orderspb.OrderService_PlaceOrder_FullMethodName:      "POST /order",
+	orderspb.OrderService_AcceptOrder_FullMethodName:     "POST /restaurant/order/accept",
+	orderspb.OrderService_ConfirmPickup_FullMethodName:   "POST /rider/order/pickup",
+	orderspb.OrderService_ConfirmDelivery_FullMethodName: "POST /rider/order/deliver",
+}
+
+var grpcValidator = newRequestValidator()
+
+func newGRPCServer(menus handlers.MenuService, orders handlers.OrderService) *grpc.Server {
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(grpcTracing, grpcLogging, grpcAuth, grpcSigned, grpcOrderVersion))
+	orderspb.RegisterOrderServiceServer(server, orderGRPCServer{menus: menus, orders: orders})
+	return server
+}
+
+// serveGRPC listens on addr until the server is stopped.
+func serveGRPC(server *grpc.Server, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return server.Serve(listener)
+}
+
+// stopGRPC drains in-flight calls, cutting them off if ctx expires first.
+func stopGRPC(ctx context.Context, server *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("timed out draining grpc server")
+		server.Stop()
+	}
+}
+
+// grpcLogging attaches a logger to the call and logs one line when it
+// completes, like requestLogging. The request ID is taken from the caller's
+// "x-request-id" metadata or made up, and sent back in the response header.
+func grpcLogging(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var requestID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(grpcRequestIDKey); len(values) > 0 {
+			requestID = values[0]
+		}
+	}
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(grpcRequestIDKey, requestID))
+	ctx = handlers.WithRequestID(ctx, requestID)
+
+	logger := slog.Default().With("request_id", requestID, "grpc_method", info.FullMethod)
+	ctx = context.WithValue(ctx, grpcLoggerKey, logger)
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logger.Info("grpc call completed",
+		"code", status.Code(err).String(),
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+	return resp, err
+}
+
+// grpcAuth checks the bearer token of calls to methods that need one.
+func grpcAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	roles, ok := grpcMethodRoles[info.FullMethod]
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			header = values[0]
+		}
+	}
+	claims, err := parseBearerToken(header)
+	if err != nil {
+		grpcLogger(ctx).Info("rejected unauthenticated call", "error", err)
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+
+	allowed := false
+	for _, role := range roles {
+		if claims.Role == role {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, status.Error(codes.PermissionDenied, "Forbidden")
+	}
+	return handler(context.WithValue(ctx, grpcClaimsKey, claims), req)
+}
+
+// grpcSigned checks the signature of calls to methods whose routes are
+// signed, as signedRequest does for the routes. The signature is sent as
+// metadata under the lowercased header names, and signs POST, the method's
+// full name as the path and the request's deterministic protobuf encoding
+// as the body.
+func grpcSigned(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !signedRoutes[grpcMethodRoutes[info.FullMethod]] {
+		return handler(ctx, req)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	value := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	call := signedCall{
+		keyID:     value(strings.ToLower(headerSignatureKey)),
+		signature: value(strings.ToLower(headerSignature)),
+		timestamp: value(strings.ToLower(headerSignatureTimestamp)),
+		nonce:     value(strings.ToLower(headerSignatureNonce)),
+	}
+	if call.unsigned() {
+		signedRequests.WithLabelValues("unsigned").Inc()
+		if appConfig.RequestSigningRequired {
+			return nil, status.Error(codes.Unauthenticated, "This request must be signed")
+		}
+		return handler(ctx, req)
+	}
+
+	message, ok := req.(proto.Message)
+	if !ok {
+		return nil, status.Error(codes.Internal, "Failed to check request signature")
+	}
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(message)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Failed to check request signature")
+	}
+
+	err = verifySignature(ctx, grpcClaims(ctx), call, http.MethodPost, info.FullMethod, body)
+	var refusal *signatureRefusal
+	if errors.As(err, &refusal) {
+		signedRequests.WithLabelValues(refusal.result).Inc()
+		grpcLogger(ctx).Info("rejected signed call", "key_id", call.keyID, "reason", refusal.reason)
+		return nil, status.Error(codes.Unauthenticated, "Invalid request signature")
+	} else if err != nil {
+		grpcLogger(ctx).Error("error checking request signature", "key_id", call.keyID, "error", err)
+		return nil, status.Error(codes.Internal, "Failed to check request signature")
+	}
+
+	signedRequests.WithLabelValues("valid").Inc()
+	return handler(ctx, req)
+}
+
+func grpcLogger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(grpcLoggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+func grpcClaims(ctx context.Context) *AuthClaims {
+	claims, _ := ctx.Value(grpcClaimsKey).(*AuthClaims)
+	return claims
+}
+
+// grpcOrderVersion holds calls to methods whose routes take If-Match to the
+// version in their "if-match" metadata, as orderVersioning does for the
+// routes. Without one they are refused with FAILED_PRECONDITION while
+// ORDER_VERSION_REQUIRED is set.
+func grpcOrderVersion(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !orderVersionRoutes[grpcMethodRoutes[info.FullMethod]] {
+		return handler(ctx, req)
+	}
+
+	var value string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(grpcIfMatchKey); len(values) > 0 {
+			value = values[0]
+		}
+	}
+	version, ok, err := parseOrderVersion(value)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, `if-match must be the order's version, e.g. "3"`)
+	}
+	if !ok {
+		if value == "" && appConfig.OrderVersionRequired {
+			return nil, status.Error(codes.FailedPrecondition, "if-match with the order's version is required")
+		}
+		return handler(ctx, req)
+	}
+	return handler(withOrderVersion(ctx, version), req)
+}
+
+// grpcTagOrderVersion sends the order's version back in "etag" metadata,
+// as tagOrderVersion does, to be sent as if-match next time. A call that
+// lost to another change gets the version that beat it.
+func grpcTagOrderVersion(ctx context.Context, order Order, err error) {
+	version := order.Version
+	if err != nil {
+		var se *serviceError
+		if !errors.As(err, &se) {
+			return
+		}
+		current, ok := se.Details["version"].(int64)
+		if !ok {
+			return
+		}
+		version = current
+	}
+	if version > 0 {
+		grpc.SetHeader(ctx, metadata.Pairs(grpcETagKey, `"`+strconv.FormatInt(version, 10)+`"`))
+	}
+}
+
+// grpcError turns a service or validation error into a status carrying the
+// same message the REST API would give.
+func grpcError(err error) error {
+	var ve validator.ValidationErrors
+	if errors.As(err, &ve) {
+		fields := make([]string, len(ve))
+		for i, fe := range ve {
+			fields[i] = fieldPath(fe) + " " + validationMessage(fe)
+		}
+		return status.Error(codes.InvalidArgument, "Validation failed: "+strings.Join(fields, "; "))
+	}
+
+	var se *serviceError
+	if !errors.As(err, &se) {
+		return status.Error(codes.Internal, "Internal server error")
+	}
+	if se.Field != "" {
+		return status.Error(codes.InvalidArgument, "Validation failed: "+se.Error())
+	}
+
+	code := codes.Internal
+	switch se.Status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		code = codes.InvalidArgument
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict, http.StatusPreconditionRequired:
+		code = codes.FailedPrecondition
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, se.Message)
+}
+
+type orderGRPCServer struct {
+	orderspb.UnimplementedOrderServiceServer
+	menus  handlers.MenuService
+	orders handlers.OrderService
+}
+
+func (s orderGRPCServer) GetMenu(ctx context.Context, req *orderspb.GetMenuRequest) (*orderspb.Menu, error) {
+	if req.GetRestaurantId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "restaurant_id is required")
+	}
+
+	if locale := req.GetLocale(); locale != "" {
+		if !handlers.ValidLanguageTag(locale) {
+			return nil, status.Error(codes.InvalidArgument, "locale is not a language tag")
+		}
+		ctx = handlers.WithLocales(ctx, []string{locale})
+	}
+
+	logger := grpcLogger(ctx).With("restaurant_id", req.GetRestaurantId())
+	menu, err := s.menus.Menu(ctx, logger, req.GetRestaurantId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return menuToProto(menu), nil
+}
+
+func (s orderGRPCServer) PlaceOrder(ctx context.Context, req *orderspb.PlaceOrderRequest) (*orderspb.Order, error) {
+	order := Order{
+		RestaurantID:    req.GetRestaurantId(),
+		Items:           make([]OrderItem, len(req.GetItems())),
+		DeliveryOptions: deliveryOptionsFromProto(req.GetDeliveryOptions()),
+		PromoCode:       req.GetPromoCode(),
+		Utensils:        req.GetUtensils(),
+	}
+	for i, item := range req.GetItems() {
+		order.Items[i] = OrderItem{MenuID: item.GetMenuId(), Quantity: int(item.GetQuantity())}
+	}
+	if location := req.GetDeliveryLocation(); location != nil {
+		order.DeliveryLocation = &GeoPoint{Lat: location.GetLat(), Lng: location.GetLng()}
+	}
+	if gift := req.GetGift(); gift != nil {
+		order.Gift = &GiftDetails{
+			RecipientName:  gift.GetRecipientName(),
+			RecipientPhone: gift.GetRecipientPhone(),
+			RecipientEmail: gift.GetRecipientEmail(),
+			Message:        gift.GetMessage(),
+		}
+	}
+	if err := grpcValidator.Validate(&order); err != nil {
+		return nil, grpcError(err)
+	}
+
+	order, err := s.orders.PlaceOrder(ctx, grpcLogger(ctx), grpcClaims(ctx), order)
+	grpcTagOrderVersion(ctx, order, err)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return orderToProto(order), nil
+}
+
+func (s orderGRPCServer) AcceptOrder(ctx context.Context, req *orderspb.AcceptOrderRequest) (*orderspb.Order, error) {
+	accept := AcceptOrderRequest{OrderID: req.GetOrderId(), RestaurantID: req.GetRestaurantId(), PrepMinutes: int(req.GetPrepMinutes())}
+	if req.GetReadyBy() != nil {
+		accept.ReadyBy = &Timestamp{Time: req.GetReadyBy().AsTime()}
+	}
+	if err := grpcValidator.Validate(&accept); err != nil {
+		return nil, grpcError(err)
+	}
+
+	order, err := s.orders.AcceptOrder(ctx, grpcLogger(ctx), grpcClaims(ctx), accept)
+	grpcTagOrderVersion(ctx, order, err)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return orderToProto(order), nil
+}
+
+func (s orderGRPCServer) ConfirmPickup(ctx context.Context, req *orderspb.ConfirmPickupRequest) (*orderspb.Order, error) {
+	pickup := PickupRequest{OrderID: req.GetOrderId(), RiderID: req.GetRiderId(), OrderCode: req.GetOrderCode()}
+	if checklist := req.GetChecklist(); checklist != nil {
+		pickup.Checklist = &ChecklistConfirmation{
+			Bags:     int(checklist.GetBags()),
+			Drinks:   int(checklist.GetDrinks()),
+			Utensils: checklist.GetUtensils(),
+		}
+	}
+	if err := grpcValidator.Validate(&pickup); err != nil {
+		return nil, grpcError(err)
+	}
+
+	order, err := s.orders.ConfirmPickup(ctx, grpcLogger(ctx), grpcClaims(ctx), pickup)
+	grpcTagOrderVersion(ctx, order, err)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return orderToProto(order), nil
+}
+
+func (s orderGRPCServer) ConfirmDelivery(ctx context.Context, req *orderspb.ConfirmDeliveryRequest) (*orderspb.Order, error) {
+	deliver := DeliverRequest{OrderID: req.GetOrderId(), RiderID: req.GetRiderId(), SignatureHash: req.GetSignatureHash()}
+	if err := grpcValidator.Validate(&deliver); err != nil {
+		return nil, grpcError(err)
+	}
+
+	order, err := s.orders.ConfirmDelivery(ctx, grpcLogger(ctx), grpcClaims(ctx), deliver)
+	grpcTagOrderVersion(ctx, order, err)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return orderToProto(order), nil
+}
+
+func deliveryOptionsFromProto(options *orderspb.DeliveryOptions) DeliveryOptions {
+	return DeliveryOptions{
+		LeaveAtDoor:   options.GetLeaveAtDoor(),
+		CallOnArrival: options.GetCallOnArrival(),
+		GateCode:      options.GetGateCode(),
+		Contactless:   options.GetContactless(),
+	}
+}
+
+func menuToProto(menu RestaurantMenu) *orderspb.Menu {
+	items := make([]*orderspb.MenuItem, len(menu.Menu))
+	for i, item := range menu.Menu {
+		items[i] = &orderspb.MenuItem{
+			Id:          item.ID,
+			Name:        item.Name,
+			Price:       item.Price,
+			Description: item.Description,
+			Category:    item.Category,
+			Available:   item.Available,
+			Currency:    item.Currency,
+			Locale:      item.Locale,
+		}
+		if item.Quantity != nil {
+			quantity := int32(*item.Quantity)
+			items[i].Quantity = &quantity
+		}
+	}
+	return &orderspb.Menu{RestaurantId: menu.RestaurantID, Items: items}
+}
+
+func orderToProto(order Order) *orderspb.Order {
+	pb := &orderspb.Order{
+		OrderId:       order.OrderID,
+		Code:          order.Code,
+		RestaurantId:  order.RestaurantID,
+		CustomerId:    order.CustomerID,
+		Items:         make([]*orderspb.OrderItem, len(order.Items)),
+		TotalAmount:   order.TotalAmount,
+		Currency:      order.Currency,
+		Status:        order.Status,
+		StatusReason:  order.StatusReason,
+		PaymentStatus: order.PaymentStatus,
+		RiderId:       order.RiderID,
+		DeliveryOptions: &orderspb.DeliveryOptions{
+			LeaveAtDoor:   order.DeliveryOptions.LeaveAtDoor,
+			CallOnArrival: order.DeliveryOptions.CallOnArrival,
+			GateCode:      order.DeliveryOptions.GateCode,
+			Contactless:   order.DeliveryOptions.Contactless,
+		},
+		PromoCode: order.PromoCode,
+		Utensils:  order.Utensils,
+	}
+	for i, item := range order.Items {
+		pb.Items[i] = &orderspb.OrderItem{MenuId: item.MenuID, Quantity: int32(item.Quantity)}
+	}
+	if order.DeliveryLocation != nil {
+		pb.DeliveryLocation = &orderspb.GeoPoint{Lat: order.DeliveryLocation.Lat, Lng: order.DeliveryLocation.Lng}
+	}
+	if order.ReadyBy != nil {
+		pb.ReadyBy = timestamppb.New(order.ReadyBy.Time)
+	}
+	if gift := order.Gift; gift != nil {
+		pb.Gift = &orderspb.GiftDetails{
+			RecipientName:  gift.RecipientName,
+			RecipientPhone: gift.RecipientPhone,
+			RecipientEmail: gift.RecipientEmail,
+			Message:        gift.Message,
+		}
+	}
+	if p := order.Pricing; p != nil {
+		pb.Pricing = &orderspb.PriceBreakdown{
+			Subtotal:    p.Subtotal,
+			DeliveryFee: p.DeliveryFee,
+			Surge:       p.Surge,
+			PromoCode:   p.PromoCode,
+			Discount:    p.Discount,
+			TaxRate:     p.TaxRate,
+			Tax:         p.Tax,
+			Total:       p.Total,
+			Currency:    p.Currency,
+		}
+	}
+	if c := order.PickupChecklist; c != nil {
+		pb.PickupChecklist = &orderspb.PickupChecklist{
+			Items:    make([]*orderspb.ChecklistItem, len(c.Items)),
+			Bags:     int32(c.Bags),
+			Drinks:   int32(c.Drinks),
+			Utensils: c.Utensils,
+		}
+		for i, item := range c.Items {
+			pb.PickupChecklist.Items[i] = &orderspb.ChecklistItem{MenuId: item.MenuID, Name: item.Name, Quantity: int32(item.Quantity)}
+		}
+	}
+	if !order.CreatedAt.IsZero() {
+		pb.CreatedAt = timestamppb.New(order.CreatedAt.Time)
+	}
+	if !order.UpdatedAt.IsZero() {
+		pb.UpdatedAt = timestamppb.New(order.UpdatedAt.Time)
+	}
+	return pb
+}
@@ -0,0 +1,16 @@
+package app
+
+import "myproject/src/handlers"
+
+// Request helpers shared with the handlers package, under the names the
+// handlers still in package app use.
+var (
+	newRequestValidator = handlers.NewRequestValidator
+	bindAndValidate     = handlers.BindAndValidate
+	respondRequestError = handlers.RespondRequestError
+	respondServiceError = handlers.RespondServiceError
+	tagOrderVersion     = handlers.TagOrderVersion
+	validationFailed    = handlers.ValidationFailed
+	fieldPath           = handlers.FieldPath
+	validationMessage   = handlers.ValidationMessage
+)
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sony/gobreaker"
+)
+
+const readinessCheckTimeout = 2 * time.Second
+
+type DependencyStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Circuit is the state of the dependency's circuit breaker.
+	Circuit string `json:"circuit,omitempty"`
+}
+
+func healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func readyz(c echo.Context) error {
+	checkCtx, cancel := context.WithTimeout(c.Request().Context(), readinessCheckTimeout)
+	defer cancel()
+
+	checks := map[string]DependencyStatus{
+		"redis": dependencyStatus(redisClient.Ping(checkCtx).Err(), redisBreaker),
+		"kafka": dependencyStatus(bus.Ping(checkCtx), kafkaBreaker),
+	}
+	if repositories.ping != nil {
+		checks["store"] = dependencyStatus(repositories.ping(checkCtx), nil)
+	}
+
+	status := http.StatusOK
+	overall := "ok"
+	for _, check := range checks {
+		if check.Status != "ok" {
+			status = http.StatusServiceUnavailable
+			overall = "unavailable"
+		}
+	}
+
+	return c.JSON(status, map[string]interface{}{
+		"status": overall,
+		"checks": checks,
+	})
+}
+
+func dependencyStatus(err error, breaker *gobreaker.TwoStepCircuitBreaker) DependencyStatus {
+	if err != nil {
+		return DependencyStatus{Status: "error", Error: err.Error(), Circuit: breakerState(breaker)}
+	}
+	return DependencyStatus{Status: "ok", Circuit: breakerState(breaker)}
+}
@@ -0,0 +1,30 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"myproject/src/rng"
+)
+
+// IDGenerator produces identifiers for new entities. Tests can swap the
+// package-level idGenerator for a deterministic implementation.
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// UUIDv7Generator returns time-ordered UUIDv7 strings, so IDs sort by
+// creation time and stay unique across restarts and replicas. The random
+// bits come from rng.Default.
+type UUIDv7Generator struct{}
+
+func (UUIDv7Generator) NewID() (string, error) {
+	id, err := uuid.NewV7FromReader(rng.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate uuid: %w", err)
+	}
+	return id.String(), nil
+}
+
+var idGenerator IDGenerator = UUIDv7Generator{}
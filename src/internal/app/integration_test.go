@@ -0,0 +1,187 @@
+//go:build integration
+
+package app
+
+// Integration tests run the service against real Redis and Kafka, started in
+// containers with testcontainers, so they need a Docker daemon, and are
+// skipped without one:
+//
+//	go test -tags=integration ./src/internal/app/
+//
+// The benchmarks in app_test.go time the order path on them, too:
+//
+//	go test -tags=integration -run='^$' -bench=. ./src/internal/app/
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/segmentio/kafka-go"
+	"github.com/testcontainers/testcontainers-go"
+	tckafka "github.com/testcontainers/testcontainers-go/modules/kafka"
+	tcredis "github.com/testcontainers/testcontainers-go/modules/redis"
+
+	"myproject/src/handlers"
+)
+
+// dockerUnavailable is why the containers could not be started, if Docker
+// is not there to start them; every test is then skipped.
+var dockerUnavailable error
+
+func TestMain(m *testing.M) {
+	os.Exit(runIntegration(m))
+}
+
+// requireBackends skips t if there is no Docker to run the containers on.
+func requireBackends(t testing.TB) {
+	t.Helper()
+	if dockerUnavailable != nil {
+		t.Skipf("docker is unavailable: %v", dockerUnavailable)
+	}
+}
+
+// runIntegration starts the containers and the service on them, runs the
+// tests and tears everything down again.
+func runIntegration(m *testing.M) int {
+	// The menu, restaurant and rider files are read from the working
+	// directory, which for the binaries is src.
+	err := os.Chdir("../..")
+	if err != nil {
+		slog.Error("error changing to the src directory", "error", err)
+		return 1
+	}
+
+	setupCtx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+
+	dockerUnavailable = dockerHealth(setupCtx)
+	if dockerUnavailable != nil {
+		return m.Run()
+	}
+
+	redisContainer, err := tcredis.Run(setupCtx, "redis:6.2")
+	defer testcontainers.TerminateContainer(redisContainer)
+	if err != nil {
+		slog.Error("error starting redis container", "error", err)
+		return 1
+	}
+
+	kafkaContainer, err := tckafka.Run(setupCtx, "confluentinc/confluent-local:7.5.0", tckafka.WithClusterID("integration"))
+	defer testcontainers.TerminateContainer(kafkaContainer)
+	if err != nil {
+		slog.Error("error starting kafka container", "error", err)
+		return 1
+	}
+
+	redisURL, err := redisContainer.ConnectionString(setupCtx)
+	if err != nil {
+		slog.Error("error reading redis address", "error", err)
+		return 1
+	}
+	redisOptions, err := redis.ParseURL(redisURL)
+	if err != nil {
+		slog.Error("error parsing redis address", "url", redisURL, "error", err)
+		return 1
+	}
+	brokers, err := kafkaContainer.Brokers(setupCtx)
+	if err != nil {
+		slog.Error("error reading kafka brokers", "error", err)
+		return 1
+	}
+
+	// The service is set up as the api sets itself up, from its environment,
+	// on the containers.
+	for key, value := range map[string]string{
+		"BACKEND":              backendExternal,
+		"STORE":                storeRedis,
+		"REDIS_ADDR":           redisOptions.Addr,
+		"KAFKA_BROKERS":        strings.Join(brokers, ","),
+		"JWT_SECRET":           testJWTSecret,
+		"PAYMENT_PROVIDER":     "mock",
+		"OUTBOX_POLL_INTERVAL": "100ms",
+		// Every request comes from the one address, which the benchmarks
+		// would soon run out of requests for.
+		"RATE_LIMIT_ENABLED": "false",
+	} {
+		os.Setenv(key, value)
+	}
+	client := redis.NewClient(redisOptions)
+	defer client.Close()
+	release := setup("integration", &backends{redis: client, bus: newKafkaBus(brokers)})
+	defer release()
+
+	// The writers do not ask the broker to create topics, so make them up
+	// front as a deployment would.
+	topics := append(orderEventRouter.topics(),
+		regionTopic("order-delivered"), regionTopic(dlqTopic), regionTopic(opsIncidentTopic),
+		regionTopic(riderPayoutsTopic), regionTopic(closeoutsTopic), regionTopic(analyticsTopic))
+	err = createTopics(brokers, topics...)
+	if err != nil {
+		slog.Error("error creating kafka topics", "error", err)
+		return 1
+	}
+	defer closeWriters(context.Background())
+
+	appCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go runOutboxRelay(appCtx, appConfig.OutboxPollInterval)
+	newOrderConsumer().Start(appCtx)
+
+	orders := NewOrderService(redisClient, analyticsWriter, repositories, appConfig)
+	h := handlers.New(NewMenuService(redisClient, analyticsWriter, repositories), orders, NewNotificationService(redisClient, repositories))
+	testServer = httptest.NewServer(newRouter(h, orders))
+	defer testServer.Close()
+
+	return m.Run()
+}
+
+// dockerHealth reports why no Docker daemon can be reached, or nil.
+// Finding none, testcontainers panics rather than returning an error.
+func dockerHealth(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	provider, err := testcontainers.ProviderDocker.GetProvider()
+	if err != nil {
+		return err
+	}
+	defer provider.Close()
+	return provider.Health(ctx)
+}
+
+// createTopics creates each topic with one partition on the cluster's
+// controller. Topics that already exist are left alone.
+func createTopics(brokers []string, topics ...string) error {
+	conn, err := kafka.Dial("tcp", brokers[0])
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	controller, err := conn.Controller()
+	if err != nil {
+		return err
+	}
+	controllerConn, err := kafka.Dial("tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	if err != nil {
+		return err
+	}
+	defer controllerConn.Close()
+
+	configs := make([]kafka.TopicConfig, len(topics))
+	for i, topic := range topics {
+		configs[i] = kafka.TopicConfig{Topic: topic, NumPartitions: 1, ReplicationFactor: 1}
+	}
+	return controllerConn.CreateTopics(configs...)
+}
@@ -0,0 +1,220 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+const (
+	issueMissingItem = "missing_item"
+	issueWrongItem   = "wrong_item"
+	issueColdFood    = "cold_food"
+
+	issueStatusCredited  = "credited"
+	issueStatusEscalated = "escalated"
+
+	maxIssuePhotos = 5
+)
+
+// coldFoodCompensationRate is the share of the order total offered for a
+// cold-food complaint, since no single item is at fault.
+const coldFoodCompensationRate = 0.2
+
+type OrderIssue struct {
+	ID          string       `json:"id"`
+	OrderID     string       `json:"order_id"`
+	CustomerID  string       `json:"customer_id"`
+	Type        string       `json:"type"`
+	MenuID      string       `json:"menu_id,omitempty"`
+	Quantity    int          `json:"quantity,omitempty"`
+	Description string       `json:"description"`
+	Photos      []ImageAsset `json:"photos"`
+	ClaimAmount float64      `json:"claim_amount"`
+	Status      string       `json:"status"`
+	TicketID    string       `json:"ticket_id,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+type ReportIssueForm struct {
+	Type        string `form:"type" validate:"required,oneof=missing_item wrong_item cold_food"`
+	MenuID      string `form:"menu_id" validate:"required_unless=Type cold_food"`
+	Quantity    int    `form:"quantity" validate:"omitempty,gt=0,lte=100"`
+	Description string `form:"description" validate:"required,max=1000"`
+}
+
+func orderIssuesKey(orderID string) string {
+	return "order:" + orderID + ":issues"
+}
+
+func customerCreditKey(customerID string) string {
+	return "customer:" + customerID + ":credit"
+}
+
+func reportOrderIssue(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	order, err := getOrder(ctx, c.Param("id"))
+	if err == errOrderNotFound || (err == nil && order.CustomerID != authClaims(c).Subject) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+
+	if order.Status != "delivered" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Issues can only be reported for delivered orders"})
+	}
+
+	var form ReportIssueForm
+	if err := bindAndValidate(c, &form); err != nil {
+		return respondRequestError(c, err)
+	}
+	if form.Quantity == 0 {
+		form.Quantity = 1
+	}
+
+	claim, err := issueClaimAmount(ctx, order, form)
+	if err != nil {
+		return validationFailed(c, "menu_id", err.Error())
+	}
+
+	issueID, err := idGenerator.NewID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create issue"})
+	}
+
+	photos, err := uploadIssuePhotos(c, order.OrderID, issueID)
+	if err != nil {
+		return validationFailed(c, "photos", err.Error())
+	}
+
+	issue := OrderIssue{
+		ID:          issueID,
+		OrderID:     order.OrderID,
+		CustomerID:  order.CustomerID,
+		Type:        form.Type,
+		MenuID:      form.MenuID,
+		Quantity:    form.Quantity,
+		Description: form.Description,
+		Photos:      photos,
+		ClaimAmount: claim,
+		CreatedAt:   clock.Now().UTC(),
+	}
+
+	// Claims above the auto-credit limit go to a support agent instead.
+	var ticket Ticket
+	if claim <= appConfig.IssueAutoCreditMax {
+		issue.Status = issueStatusCredited
+	} else {
+		issue.Status = issueStatusEscalated
+		ticket, err = newTicket(ticketSourceOrderIssue, order, "Order issue: "+issue.Type, issue.Description, claim)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create ticket"})
+		}
+		ticket.IssueID = issue.ID
+		issue.TicketID = ticket.ID
+	}
+
+	issueJSON, _ := json.Marshal(issue)
+	pipe := redisClient.TxPipeline()
+	pipe.RPush(ctx, orderIssuesKey(order.OrderID), issueJSON)
+	if issue.Status == issueStatusCredited {
+		pipe.IncrByFloat(ctx, customerCreditKey(order.CustomerID), claim)
+	} else {
+		queueTicket(ctx, pipe, ticket)
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		slog.Error("error storing order issue", "order_id", order.OrderID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store issue"})
+	}
+
+	requestLogger(c).Info("order issue reported", "order_id", order.OrderID, "issue_id", issue.ID, "type", issue.Type, "claim", claim, "status", issue.Status)
+	return c.JSON(http.StatusCreated, issue)
+}
+
+func issueClaimAmount(ctx context.Context, order Order, form ReportIssueForm) (float64, error) {
+	if form.Type == issueColdFood {
+		return math.Round(order.TotalAmount*coldFoodCompensationRate*100) / 100, nil
+	}
+
+	ordered := 0
+	for _, item := range order.Items {
+		if item.MenuID == form.MenuID {
+			ordered += item.Quantity
+		}
+	}
+	if ordered == 0 {
+		return 0, fmt.Errorf("item %s is not part of this order", form.MenuID)
+	}
+	if form.Quantity > ordered {
+		return 0, fmt.Errorf("only %d of item %s were ordered", ordered, form.MenuID)
+	}
+
+	menu, err := getMenuFromCache(ctx, order.RestaurantID)
+	if err != nil {
+		return 0, err
+	}
+	for _, menuItem := range menu.Menu {
+		if menuItem.ID == form.MenuID {
+			return menuItem.Price * float64(form.Quantity), nil
+		}
+	}
+	return 0, fmt.Errorf("item %s is no longer on the menu", form.MenuID)
+}
+
+func uploadIssuePhotos(c echo.Context, orderID, issueID string) ([]ImageAsset, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return []ImageAsset{}, nil
+	}
+
+	files := form.File["photos"]
+	if len(files) > maxIssuePhotos {
+		return nil, fmt.Errorf("at most %d photos are allowed", maxIssuePhotos)
+	}
+
+	photos := make([]ImageAsset, 0, len(files))
+	for i, fh := range files {
+		asset, err := processImageUpload(c.Request().Context(), fh, "orders/"+orderID+"/issues/"+issueID)
+		if err != nil {
+			return nil, fmt.Errorf("photo %d: %w", i+1, err)
+		}
+		photos = append(photos, asset)
+	}
+	return photos, nil
+}
+
+func listOrderIssues(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	order, err := getOrder(ctx, c.Param("id"))
+	if err == errOrderNotFound || (err == nil && order.CustomerID != authClaims(c).Subject) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+
+	records, err := redisClient.LRange(ctx, orderIssuesKey(order.OrderID), 0, -1).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch issues"})
+	}
+
+	issues := make([]OrderIssue, 0, len(records))
+	for _, record := range records {
+		var issue OrderIssue
+		if err := json.Unmarshal([]byte(record), &issue); err == nil {
+			issues = append(issues, issue)
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"issues": issues})
+}
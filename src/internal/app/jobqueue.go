@@ -0,0 +1,215 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"myproject/src/clock"
+)
+
+const (
+	jobsScheduledKey = "jobs:scheduled"
+	jobsInflightKey  = "jobs:inflight"
+	jobsDataKey      = "jobs:data"
+	jobsDeadKey      = "jobs:dead"
+
+	jobClaimBatch = 10
+)
+
+// moveDueJobs atomically moves up to ARGV[2] members of KEYS[1] scored at or
+// below ARGV[1] into KEYS[2] with score ARGV[3]. It is used both to claim due
+// jobs (scheduled -> inflight with a lease deadline) and to recover jobs whose
+// lease expired (inflight -> scheduled, due now).
+var moveDueJobs = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, id in ipairs(ids) do
+	redis.call('ZREM', KEYS[1], id)
+	redis.call('ZADD', KEYS[2], ARGV[3], id)
+end
+return ids
+`)
+
+type Job struct {
+	ID       string          `json:"id"`
+	Type     string          `json:"type"`
+	Payload  json.RawMessage `json:"payload"`
+	Attempts int             `json:"attempts"`
+}
+
+type JobHandler func(ctx context.Context, job Job) error
+
+// JobQueue is a durable Redis-backed queue with at-least-once delivery: a job
+// stays in the in-flight set until its handler succeeds, and jobs whose worker
+// died are picked up again once their lease expires.
+type JobQueue struct {
+	client       *redis.Client
+	handlers     map[string]JobHandler
+	lease        time.Duration
+	pollInterval time.Duration
+	maxAttempts  int
+}
+
+func NewJobQueue(client *redis.Client, lease, pollInterval time.Duration, maxAttempts int) *JobQueue {
+	return &JobQueue{
+		client:       client,
+		handlers:     make(map[string]JobHandler),
+		lease:        lease,
+		pollInterval: pollInterval,
+		maxAttempts:  maxAttempts,
+	}
+}
+
+func (q *JobQueue) Register(jobType string, handler JobHandler) {
+	q.handlers[jobType] = handler
+}
+
+func (q *JobQueue) Enqueue(ctx context.Context, jobType string, payload interface{}, runAt time.Time) error {
+	id, err := idGenerator.NewID()
+	if err != nil {
+		return err
+	}
+
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job payload: %w", err)
+	}
+
+	jobJSON, _ := json.Marshal(Job{ID: id, Type: jobType, Payload: payloadJSON})
+
+	pipe := q.client.TxPipeline()
+	pipe.HSet(ctx, jobsDataKey, id, jobJSON)
+	pipe.ZAdd(ctx, jobsScheduledKey, &redis.Z{Score: float64(runAt.UnixMilli()), Member: id})
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return nil
+}
+
+// Run polls for due jobs until ctx is cancelled.
+func (q *JobQueue) Run(ctx context.Context) {
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		q.recoverExpiredLeases(ctx)
+
+		for {
+			ids, err := q.claim(ctx)
+			if err != nil {
+				slog.Error("error claiming jobs", "error", err)
+				break
+			}
+			if len(ids) == 0 {
+				break
+			}
+			for _, id := range ids {
+				q.process(ctx, id)
+			}
+		}
+	}
+}
+
+func (q *JobQueue) claim(ctx context.Context) ([]string, error) {
+	now := clock.Now()
+	return moveDueJobs.Run(ctx, q.client,
+		[]string{jobsScheduledKey, jobsInflightKey},
+		now.UnixMilli(), jobClaimBatch, now.Add(q.lease).UnixMilli(),
+	).StringSlice()
+}
+
+func (q *JobQueue) recoverExpiredLeases(ctx context.Context) {
+	now := clock.Now().UnixMilli()
+	ids, err := moveDueJobs.Run(ctx, q.client,
+		[]string{jobsInflightKey, jobsScheduledKey},
+		now, jobClaimBatch, now,
+	).StringSlice()
+	if err != nil {
+		slog.Error("error recovering expired job leases", "error", err)
+		return
+	}
+	if len(ids) > 0 {
+		slog.Warn("recovered jobs with expired leases", "count", len(ids))
+	}
+}
+
+func (q *JobQueue) process(ctx context.Context, id string) {
+	jobJSON, err := q.client.HGet(ctx, jobsDataKey, id).Result()
+	if err == redis.Nil {
+		q.client.ZRem(ctx, jobsInflightKey, id)
+		return
+	} else if err != nil {
+		slog.Error("error loading job", "job_id", id, "error", err)
+		return
+	}
+
+	var job Job
+	err = json.Unmarshal([]byte(jobJSON), &job)
+	if err != nil {
+		slog.Error("dropping malformed job", "job_id", id, "error", err)
+		q.moveToDead(ctx, id)
+		return
+	}
+
+	logger := slog.Default().With("job_id", job.ID, "job_type", job.Type)
+
+	handler, ok := q.handlers[job.Type]
+	if !ok {
+		logger.Error("no handler registered for job type")
+		q.moveToDead(ctx, id)
+		return
+	}
+
+	job.Attempts++
+	err = handler(ctx, job)
+	if err == nil {
+		pipe := q.client.TxPipeline()
+		pipe.ZRem(ctx, jobsInflightKey, id)
+		pipe.HDel(ctx, jobsDataKey, id)
+		_, err = pipe.Exec(ctx)
+		if err != nil {
+			logger.Error("error acknowledging job", "error", err)
+		}
+		return
+	}
+
+	if job.Attempts >= q.maxAttempts {
+		logger.Error("job failed permanently", "attempts", job.Attempts, "error", err)
+		q.moveToDead(ctx, id)
+		return
+	}
+
+	backoff := time.Duration(1<<uint(job.Attempts-1)) * time.Second
+	logger.Warn("job failed, retrying", "attempts", job.Attempts, "backoff", backoff, "error", err)
+
+	updated, _ := json.Marshal(job)
+	pipe := q.client.TxPipeline()
+	pipe.HSet(ctx, jobsDataKey, id, updated)
+	pipe.ZRem(ctx, jobsInflightKey, id)
+	pipe.ZAdd(ctx, jobsScheduledKey, &redis.Z{Score: float64(clock.Now().Add(backoff).UnixMilli()), Member: id})
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		logger.Error("error rescheduling job", "error", err)
+	}
+}
+
+func (q *JobQueue) moveToDead(ctx context.Context, id string) {
+	pipe := q.client.TxPipeline()
+	pipe.ZRem(ctx, jobsInflightKey, id)
+	pipe.RPush(ctx, jobsDeadKey, id)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		slog.Error("error moving job to dead list", "job_id", id, "error", err)
+	}
+}
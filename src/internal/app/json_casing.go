@@ -0,0 +1,60 @@
+package app
+
+import (
+	"mime"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/handlers"
+)
+
+// JSON casing. Each request is served in one casing, snake_case or
+// camelCase, chosen by, in order:
+//
+//   - a profile on its Accept header, e.g.
+//     `Accept: application/json; profile=camelCase`;
+//   - the casing JSON_CASING_API_KEYS gives its X-API-Key, for partners
+//     that cannot set headers per request;
+//   - JSON_CASING, snake_case unless set.
+//
+// The keys are renamed by handlers.CasingSerializer; see handlers/casing.go.
+// Responses vary by both headers, so caches keep each casing apart.
+
+// jsonCasing chooses the casing the request is served in.
+func jsonCasing(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		casing := acceptedCasing(c.Request().Header.Get(echo.HeaderAccept))
+		if casing == "" {
+			if key := c.Request().Header.Get(apiKeyHeader); key != "" {
+				casing = appConfig.JSONCasingByAPIKey[key]
+			}
+		}
+		if !handlers.ValidCasing(casing) {
+			casing = appConfig.JSONCasing
+		}
+		if handlers.ValidCasing(casing) {
+			c.Set(handlers.CasingContextKey, casing)
+		}
+
+		c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept+", "+apiKeyHeader)
+		return next(c)
+	}
+}
+
+// acceptedCasing returns the casing named by the profile of the first media
+// range in accept that has one, or "" if none does.
+func acceptedCasing(accept string) string {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		for _, casing := range []string{handlers.CasingCamel, handlers.CasingSnake} {
+			if strings.EqualFold(params["profile"], casing) {
+				return casing
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,472 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+// Kitchen display. GET /restaurant/:id/orders/pending streams the orders
+// waiting for the restaurant to accept them, as Server-Sent Events: a
+// snapshot of the queue, then each order as it is offered and each as it
+// leaves the queue, accepted, rejected, cancelled or expired. An order joins
+// the queue when it is offered, alongside the restaurant's webhook, so one
+// held until it is scheduled or out of its cancel grace window shows up once
+// it is released. Offers and departures go out on a Redis channel per
+// restaurant, as tracking updates do per order, so a display connected to
+// any instance sees orders offered by any other.
+//
+// POST /restaurant/:id/orders/batch accepts and rejects many queued orders
+// at once, and PUT /restaurant/order/:id/ready-by moves an accepted order's
+// ready-by time when the kitchen runs ahead or behind. The customer's ETA
+// and tracking stream follow it.
+
+const (
+	kitchenSnapshot = "snapshot"
+	kitchenOffered  = "offered"
+	kitchenRemoved  = "removed"
+
+	// kitchenBatchMax is how many orders one batch may accept and reject.
+	kitchenBatchMax = 50
+
+	timelineReadyByChanged = "ready_by_changed"
+)
+
+// kitchenLeavingEvents take an order off its restaurant's queue.
+var kitchenLeavingEvents = map[string]bool{
+	eventOrderAccepted:  true,
+	eventOrderRejected:  true,
+	eventOrderCancelled: true,
+	eventOrderExpired:   true,
+}
+
+// KitchenOrder is a queued order as the kitchen display shows it.
+type KitchenOrder struct {
+	OrderID     string          `json:"order_id"`
+	Code        string          `json:"code"`
+	Items       []OrderItem     `json:"items"`
+	Utensils    bool            `json:"utensils"`
+	Gift        bool            `json:"gift"`
+	Options     DeliveryOptions `json:"delivery_options"`
+	ScheduledAt *Timestamp      `json:"scheduled_at,omitempty"`
+	CreatedAt   Timestamp       `json:"created_at"`
+	// ExpiresAt is when the order expires unless accepted.
+	ExpiresAt Timestamp `json:"expires_at"`
+	// Version is what to accept or reject the order at; see
+	// order_version.go.
+	Version int64 `json:"version"`
+}
+
+// KitchenUpdate is one event of the kitchen stream: the queue, on a
+// snapshot; the order, when offered; or the order and its new status, when
+// removed.
+type KitchenUpdate struct {
+	Type    string         `json:"type"`
+	Orders  []KitchenOrder `json:"orders,omitempty"`
+	Order   *KitchenOrder  `json:"order,omitempty"`
+	OrderID string         `json:"order_id,omitempty"`
+	Status  string         `json:"status,omitempty"`
+	At      Timestamp      `json:"at"`
+}
+
+func newKitchenOrder(order Order) KitchenOrder {
+	return KitchenOrder{
+		OrderID:     order.OrderID,
+		Code:        order.Code,
+		Items:       order.Items,
+		Utensils:    order.Utensils,
+		Gift:        order.Gift != nil,
+		Options:     order.DeliveryOptions,
+		ScheduledAt: order.ScheduledAt,
+		CreatedAt:   order.CreatedAt,
+		ExpiresAt:   Timestamp{Time: orderAcceptDeadline(order)},
+		Version:     order.Version,
+	}
+}
+
+func restaurantKitchenChannel(restaurantID string) string {
+	return "restaurant:" + restaurantID + ":kitchen"
+}
+
+// offeredToRestaurant reports whether order is waiting on its restaurant at
+// now: paid, not yet accepted, and not held back from it.
+func offeredToRestaurant(order Order, now time.Time) bool {
+	if order.Status != "created" || order.PaymentStatus != paymentPaid {
+		return false
+	}
+	if release := releaseTime(order); release.After(now) {
+		return false
+	}
+	return jobQueue == nil || cancelGraceRemaining(order, now) <= 0
+}
+
+// offerToRestaurant puts the newly paid order in event before its
+// restaurant: on the kitchen stream and through its webhook.
+func offerToRestaurant(ctx context.Context, event OrderEvent) {
+	if event.Type != eventOrderPaid || event.RestaurantID == "" {
+		return
+	}
+	order, err := getOrder(ctx, event.OrderID)
+	if err != nil {
+		eventLogger(event).Warn("error fetching order for kitchen stream", "error", err)
+	} else {
+		kitchenOrder := newKitchenOrder(order)
+		publishKitchenUpdate(ctx, event.RestaurantID, KitchenUpdate{Type: kitchenOffered, Order: &kitchenOrder, At: event.OccurredAt})
+	}
+	deliverRestaurantWebhook(ctx, event)
+}
+
+// removeFromKitchen takes the order in event off its restaurant's kitchen
+// stream once it is no longer waiting there.
+func removeFromKitchen(ctx context.Context, event OrderEvent) {
+	if !kitchenLeavingEvents[event.Type] || event.RestaurantID == "" {
+		return
+	}
+	publishKitchenUpdate(ctx, event.RestaurantID, KitchenUpdate{
+		Type:    kitchenRemoved,
+		OrderID: event.OrderID,
+		Status:  event.Status,
+		At:      event.OccurredAt,
+	})
+}
+
+// publishKitchenUpdate is best effort, like publishTrackingUpdate: a display
+// that misses one catches up on its next snapshot.
+func publishKitchenUpdate(ctx context.Context, restaurantID string, update KitchenUpdate) {
+	data, _ := json.Marshal(update)
+	err := redisClient.Publish(ctx, restaurantKitchenChannel(restaurantID), data).Err()
+	if err != nil {
+		slog.Warn("error publishing kitchen update", "restaurant_id", restaurantID, "type", update.Type, "error", err)
+	}
+}
+
+// pendingOrders lists the orders waiting on the restaurant, oldest first.
+// Scheduled orders may be placed up to ScheduledOrderMaxAhead before they
+// are offered, so that is how far back the restaurant's orders are read.
+func pendingOrders(ctx context.Context, restaurantID string) ([]KitchenOrder, error) {
+	now := clock.Now()
+	orders, err := repositories.Orders.RestaurantOrders(ctx, restaurantID, now.Add(-appConfig.ScheduledOrderMaxAhead-appConfig.OrderExpiry), now)
+	if err != nil {
+		return nil, err
+	}
+	pending := []KitchenOrder{}
+	for _, order := range orders {
+		if offeredToRestaurant(order, now) {
+			pending = append(pending, newKitchenOrder(order))
+		}
+	}
+	return pending, nil
+}
+
+// streamPendingOrders serves GET /restaurant/:id/orders/pending as
+// Server-Sent Events, for the restaurant's staff.
+func streamPendingOrders(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	if !staffFor(ctx, authClaims(c), roleRestaurant, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	logger := requestLogger(c).With("restaurant_id", restaurantID)
+
+	// Subscribe before taking the snapshot, as streamOrder does, so no
+	// offer made in between is lost.
+	sub := redisClient.Subscribe(ctx, regionKey(restaurantKitchenChannel(restaurantID)))
+	defer sub.Close()
+	_, err := sub.Receive(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to subscribe to orders"})
+	}
+
+	pending, err := pendingOrders(ctx, restaurantID)
+	if err != nil {
+		logger.Error("error listing pending orders", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch orders"})
+	}
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set(echo.HeaderCacheControl, "no-cache")
+	resp.Header().Set(echo.HeaderConnection, "keep-alive")
+	resp.Header().Set("X-Accel-Buffering", "no")
+	resp.WriteHeader(http.StatusOK)
+
+	snapshot, _ := json.Marshal(KitchenUpdate{Type: kitchenSnapshot, Orders: pending, At: timestampNow()})
+	if err := writeSSE(c, kitchenSnapshot, snapshot); err != nil {
+		return nil
+	}
+	logger.Debug("kitchen stream opened", "pending", len(pending))
+
+	heartbeat := time.NewTicker(trackingHeartbeat)
+	defer heartbeat.Stop()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Debug("kitchen stream closed by client")
+			return nil
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(resp, ": keep-alive\n\n")
+			if err != nil {
+				return nil
+			}
+			resp.Flush()
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			var update KitchenUpdate
+			if json.Unmarshal([]byte(msg.Payload), &update) != nil {
+				continue
+			}
+			if writeSSE(c, update.Type, []byte(msg.Payload)) != nil {
+				return nil
+			}
+		}
+	}
+}
+
+type KitchenBatchRequest struct {
+	Accept []KitchenAccept `json:"accept" validate:"dive"`
+	Reject []KitchenReject `json:"reject" validate:"dive"`
+}
+
+// KitchenAccept accepts one order, committing to a ready-by time as
+// POST /restaurant/order/accept does. Version, if set, holds the order to
+// it as If-Match would, and must be set with ORDER_VERSION_REQUIRED.
+type KitchenAccept struct {
+	OrderID     string     `json:"order_id" validate:"required,uuid"`
+	ReadyBy     *Timestamp `json:"ready_by,omitempty"`
+	PrepMinutes int        `json:"prep_minutes,omitempty" validate:"omitempty,gte=1,lte=240"`
+	Version     *int64     `json:"version,omitempty" validate:"omitempty,gte=0"`
+}
+
+type KitchenReject struct {
+	OrderID string `json:"order_id" validate:"required,uuid"`
+	Reason  string `json:"reason" validate:"required,max=500"`
+	Version *int64 `json:"version,omitempty" validate:"omitempty,gte=0"`
+}
+
+const (
+	kitchenAccepted = "accepted"
+	kitchenRejected = "rejected"
+	kitchenFailed   = "failed"
+)
+
+// KitchenBatchResult is what became of one order of a batch: accepted,
+// rejected, or failed with the error it would have had on its own.
+type KitchenBatchResult struct {
+	OrderID string                 `json:"order_id"`
+	Status  string                 `json:"status"`
+	ReadyBy *Timestamp             `json:"ready_by,omitempty"`
+	Version int64                  `json:"version,omitempty"`
+	Code    int                    `json:"code,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+	Field   string                 `json:"field,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+type KitchenBatchResponse struct {
+	Accepted int                  `json:"accepted"`
+	Rejected int                  `json:"rejected"`
+	Failed   int                  `json:"failed"`
+	Results  []KitchenBatchResult `json:"results"`
+}
+
+// batchKitchenOrders serves POST /restaurant/:id/orders/batch. Each order is
+// accepted or rejected on its own, accepts first, and one failing leaves the
+// rest be; the response answers 207 if any did.
+func (s OrderService) batchKitchenOrders(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	claims := authClaims(c)
+	if !staffFor(ctx, claims, roleRestaurant, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	var req KitchenBatchRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+	switch n := len(req.Accept) + len(req.Reject); {
+	case n == 0:
+		return validationFailed(c, "accept", "is required, or reject")
+	case n > kitchenBatchMax:
+		return validationFailed(c, "accept", fmt.Sprintf("and reject may hold at most %d orders together", kitchenBatchMax))
+	}
+
+	logger := requestLogger(c).With("restaurant_id", restaurantID)
+	var resp KitchenBatchResponse
+
+	for _, item := range req.Accept {
+		var order Order
+		itemCtx, err := kitchenBatchContext(ctx, item.Version)
+		if err == nil {
+			order, err = s.AcceptOrder(itemCtx, logger, claims, AcceptOrderRequest{
+				OrderID:      item.OrderID,
+				RestaurantID: restaurantID,
+				ReadyBy:      item.ReadyBy,
+				PrepMinutes:  item.PrepMinutes,
+			})
+		}
+		result := kitchenBatchResult(item.OrderID, kitchenAccepted, order, err)
+		if err == nil {
+			result.ReadyBy = order.ReadyBy
+		}
+		resp.add(result)
+	}
+	for _, item := range req.Reject {
+		var order Order
+		itemCtx, err := kitchenBatchContext(ctx, item.Version)
+		if err == nil {
+			order, err = s.RejectOrder(itemCtx, logger, claims, RejectOrderRequest{
+				OrderID:      item.OrderID,
+				RestaurantID: restaurantID,
+				Reason:       item.Reason,
+			})
+		}
+		resp.add(kitchenBatchResult(item.OrderID, kitchenRejected, order, err))
+	}
+
+	logger.Info("kitchen batch handled", "accepted", resp.Accepted, "rejected", resp.Rejected, "failed", resp.Failed)
+	status := http.StatusOK
+	if resp.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	return c.JSON(status, resp)
+}
+
+// kitchenBatchContext holds the order of one batch item to its version, if
+// it gives one. With ORDER_VERSION_REQUIRED set it must, as the single
+// order routes need If-Match; an item without one fails on its own.
+func kitchenBatchContext(ctx context.Context, version *int64) (context.Context, error) {
+	if version == nil {
+		if appConfig.OrderVersionRequired {
+			return ctx, orderVersionMissing()
+		}
+		return ctx, nil
+	}
+	return withOrderVersion(ctx, *version), nil
+}
+
+func kitchenBatchResult(orderID, status string, order Order, err error) KitchenBatchResult {
+	if err == nil {
+		return KitchenBatchResult{OrderID: orderID, Status: status, Version: order.Version}
+	}
+	var se *serviceError
+	if !errors.As(err, &se) {
+		se = serviceFailure(http.StatusInternalServerError, "Internal server error")
+	}
+	return KitchenBatchResult{
+		OrderID: orderID,
+		Status:  kitchenFailed,
+		Code:    se.Status,
+		Error:   se.Message,
+		Field:   se.Field,
+		Details: se.Details,
+	}
+}
+
+func (r *KitchenBatchResponse) add(result KitchenBatchResult) {
+	switch result.Status {
+	case kitchenAccepted:
+		r.Accepted++
+	case kitchenRejected:
+		r.Rejected++
+	default:
+		r.Failed++
+	}
+	r.Results = append(r.Results, result)
+}
+
+// ReadyByRequest moves an accepted order's ready-by time: to ReadyBy, or
+// PrepMinutes from now.
+type ReadyByRequest struct {
+	ReadyBy     *Timestamp `json:"ready_by,omitempty"`
+	PrepMinutes int        `json:"prep_minutes,omitempty" validate:"omitempty,gte=1,lte=240"`
+}
+
+// setOrderReadyBy serves PUT /restaurant/order/:id/ready-by.
+func setOrderReadyBy(c echo.Context) error {
+	var req ReadyByRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+	order, err := moveReadyBy(c.Request().Context(), requestLogger(c), authClaims(c), c.Param("id"), req)
+	if err != nil {
+		return respondServiceError(c, err)
+	}
+
+	tagOrderVersion(c, order.Version)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"order_id": order.OrderID,
+		"status":   order.Status,
+		"ready_by": order.ReadyBy,
+		"version":  order.Version,
+	})
+}
+
+// moveReadyBy gives the restaurant's accepted order the ready-by time req
+// commits to, with an OrderReadyByChanged event, and moves its countdown to
+// match.
+func moveReadyBy(ctx context.Context, logger *slog.Logger, claims *AuthClaims, orderID string, req ReadyByRequest) (Order, error) {
+	order, err := fetchOrder(ctx, repositories.Orders, orderID)
+	if err != nil {
+		return order, err
+	}
+	if !staffFor(ctx, claims, roleRestaurant, order.RestaurantID) {
+		return order, serviceFailure(http.StatusNotFound, "Order not found")
+	}
+	if err := checkOrderVersion(ctx, order); err != nil {
+		return order, err
+	}
+	if order.Status != "accepted" {
+		return order, serviceFailure(http.StatusConflict, "Ready-by time cannot be changed in status "+order.Status)
+	}
+
+	readyBy, err := commitReadyBy(order, AcceptOrderRequest{ReadyBy: req.ReadyBy, PrepMinutes: req.PrepMinutes})
+	if err != nil {
+		return order, err
+	}
+
+	updated, err := updateOrder(ctx, repositories.Orders, orderID, func(current *Order) ([]OrderEvent, error) {
+		if current.Status != "accepted" {
+			return nil, serviceFailure(http.StatusConflict, "Ready-by time cannot be changed in status "+current.Status)
+		}
+		if current.Version != order.Version {
+			return nil, errOrderChanged
+		}
+		current.ReadyBy = &Timestamp{Time: readyBy}
+		current.Timeline = append(current.Timeline, TimelineEvent{Event: timelineReadyByChanged, At: timestampNow()})
+		return []OrderEvent{newOrderEvent(ctx, eventOrderReadyByChanged, *current)}, nil
+	})
+	var se *serviceError
+	switch {
+	case errors.As(err, &se):
+		return order, err
+	case errors.Is(err, errOrderChanged):
+		return order, orderChangedFailure(ctx, orderID)
+	case err != nil:
+		logger.Error("error saving ready-by time", "order_id", orderID, "error", err)
+		return order, serviceFailure(http.StatusInternalServerError, "Failed to update order")
+	}
+
+	// The order is saved; a countdown left at the old time fires early or
+	// late, but emitCountdown still finds the order waiting.
+	if err := rescheduleReadyCountdown(ctx, orderID, readyBy); err != nil {
+		logger.Error("error rescheduling ready countdown", "order_id", orderID, "error", err)
+	}
+	logger.Info("ready-by time changed", "order_id", orderID, "ready_by", readyBy)
+	return updated, nil
+}
@@ -0,0 +1,340 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Load generation. The loadgen command plays -customers customers placing
+// -rate orders a second, between them, against a running API for -duration,
+// and paying for them with -pay. Orders are sent on schedule whether or not
+// earlier ones have been answered, as real customers would, up to
+// -concurrency in flight; orders due while that many are waiting are
+// skipped and counted. At the end it reports the latency percentiles and
+// status codes of each call, and how many Kafka messages the API published
+// a second, from the kafka_publish_total counters on its /metrics.
+//
+// The tokens are signed with -jwt-secret, JWT_SECRET by default, which must
+// be the API's. Rate limits are per client address, so for more than a few
+// orders a second point it at an API with RATE_LIMIT_ENABLED=false.
+
+const loadgenPublishedMetric = "kafka_publish_total"
+
+// loadgenOptions are the loadgen flags.
+type loadgenOptions struct {
+	url          string
+	secret       string
+	customers    int
+	perSecond    float64
+	duration     time.Duration
+	restaurantID string
+	items        []string
+	pay          bool
+	concurrency  int
+	timeout      time.Duration
+}
+
+// RunLoadgen generates the load described by args and prints the report.
+func RunLoadgen(args []string) {
+	flags := flag.NewFlagSet("loadgen", flag.ExitOnError)
+	url := flags.String("url", "http://localhost:8080", "base URL of the API")
+	secret := flags.String("jwt-secret", os.Getenv("JWT_SECRET"), "secret the API verifies tokens with")
+	customers := flags.Int("customers", 100, "customers placing the orders")
+	perSecond := flags.Float64("rate", 10, "orders placed a second, across all customers")
+	duration := flags.Duration("duration", time.Minute, "how long to place orders for")
+	restaurantID := flags.String("restaurant", "1", "restaurant the orders are placed at")
+	items := flags.String("items", "1", "comma-separated menu item IDs; each order has one of each")
+	pay := flags.Bool("pay", false, "pay for each order placed")
+	concurrency := flags.Int("concurrency", 256, "most orders in flight at once")
+	timeout := flags.Duration("timeout", 10*time.Second, "how long to wait for each call")
+	flags.Parse(args)
+
+	opts := loadgenOptions{
+		url:          strings.TrimRight(*url, "/"),
+		secret:       *secret,
+		customers:    *customers,
+		perSecond:    *perSecond,
+		duration:     *duration,
+		restaurantID: *restaurantID,
+		items:        strings.Split(*items, ","),
+		pay:          *pay,
+		concurrency:  *concurrency,
+		timeout:      *timeout,
+	}
+	var err error
+	switch {
+	case opts.secret == "":
+		err = errors.New("-jwt-secret or JWT_SECRET is required")
+	case opts.customers < 1:
+		err = errors.New("-customers must be positive")
+	case opts.perSecond <= 0:
+		err = errors.New("-rate must be positive")
+	case opts.duration <= 0:
+		err = errors.New("-duration must be positive")
+	case opts.concurrency < 1:
+		err = errors.New("-concurrency must be positive")
+	case slices.ContainsFunc(opts.items, func(id string) bool { return strings.TrimSpace(id) == "" }):
+		err = errors.New("-items must not have empty IDs")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "loadgen:", err)
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := generateLoad(ctx, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "loadgen:", err)
+		os.Exit(1)
+	}
+	report.print(os.Stdout)
+}
+
+// loadgenCall is the outcome of one call to the API.
+type loadgenCall struct {
+	latency time.Duration
+	status  int
+	err     error
+}
+
+// loadgenStep collects the calls to one endpoint.
+type loadgenStep struct {
+	name      string
+	latencies []time.Duration
+	statuses  map[int]int
+	errors    int
+}
+
+type loadgenReport struct {
+	mu      sync.Mutex
+	steps   []*loadgenStep
+	sent    int
+	skipped int
+	elapsed time.Duration
+	// published is how many Kafka messages the API published during the
+	// run, or -1 if its metrics could not be read.
+	published float64
+}
+
+func (r *loadgenReport) step(name string) *loadgenStep {
+	for _, step := range r.steps {
+		if step.name == name {
+			return step
+		}
+	}
+	step := &loadgenStep{name: name, statuses: map[int]int{}}
+	r.steps = append(r.steps, step)
+	return step
+}
+
+func (r *loadgenReport) record(name string, call loadgenCall) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	step := r.step(name)
+	if call.err != nil {
+		step.errors++
+		return
+	}
+	step.latencies = append(step.latencies, call.latency)
+	step.statuses[call.status]++
+}
+
+// generateLoad places orders on schedule until the duration is up or ctx
+// is cancelled, then waits for the calls in flight.
+func generateLoad(ctx context.Context, opts loadgenOptions) (*loadgenReport, error) {
+	client := &http.Client{
+		Timeout:   opts.timeout,
+		Transport: &http.Transport{MaxIdleConnsPerHost: opts.concurrency},
+	}
+	tokens := make([]string, opts.customers)
+	for i := range tokens {
+		claims := AuthClaims{Role: roleCustomer, RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   fmt.Sprintf("loadgen-customer-%d", i+1),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(opts.duration + time.Hour)),
+		}}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(opts.secret))
+		if err != nil {
+			return nil, fmt.Errorf("signing token: %v", err)
+		}
+		tokens[i] = token
+	}
+	items := make([]map[string]interface{}, len(opts.items))
+	for i, id := range opts.items {
+		items[i] = map[string]interface{}{"menu_id": strings.TrimSpace(id), "quantity": 1}
+	}
+	body := map[string]interface{}{"restaurant_id": opts.restaurantID, "items": items}
+
+	report := &loadgenReport{published: -1}
+	before, scrapeErr := scrapePublished(client, opts.url)
+	if scrapeErr != nil {
+		fmt.Fprintln(os.Stderr, "loadgen: not reporting kafka throughput:", scrapeErr)
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.perSecond))
+	defer ticker.Stop()
+	inFlight := make(chan struct{}, opts.concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+send:
+	for n := 0; ; n++ {
+		select {
+		case <-runCtx.Done():
+			break send
+		case <-ticker.C:
+		}
+		select {
+		case inFlight <- struct{}{}:
+		default:
+			report.skipped++
+			continue
+		}
+		report.sent++
+		wg.Add(1)
+		go func(token string) {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+			placeLoadOrder(client, opts, token, body, report)
+		}(tokens[n%len(tokens)])
+	}
+	wg.Wait()
+	report.elapsed = time.Since(start)
+
+	if scrapeErr == nil {
+		after, err := scrapePublished(client, opts.url)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "loadgen: not reporting kafka throughput:", err)
+		} else {
+			report.published = after - before
+		}
+	}
+	return report, nil
+}
+
+// placeLoadOrder places one order and, with -pay, pays for it.
+func placeLoadOrder(client *http.Client, opts loadgenOptions, token string, body interface{}, report *loadgenReport) {
+	var placed struct {
+		OrderID string `json:"order_id"`
+	}
+	call := loadgenDo(client, http.MethodPost, opts.url+"/order", token, body, &placed)
+	report.record("place", call)
+	if !opts.pay || call.err != nil || call.status != http.StatusOK || placed.OrderID == "" {
+		return
+	}
+	pay := PayOrderRequest{OrderID: placed.OrderID, PaymentToken: "tok_visa"}
+	report.record("pay", loadgenDo(client, http.MethodPost, opts.url+"/order/pay", token, pay, nil))
+}
+
+// loadgenDo sends body as JSON and times the call until the whole response
+// is read, decoding it into out if it succeeded.
+func loadgenDo(client *http.Client, method, url, token string, body, out interface{}) loadgenCall {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return loadgenCall{err: err}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		return loadgenCall{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return loadgenCall{err: err}
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	call := loadgenCall{latency: time.Since(start), status: resp.StatusCode, err: err}
+	if err == nil && out != nil && resp.StatusCode < 300 {
+		json.Unmarshal(respBody, out)
+	}
+	return call
+}
+
+// scrapePublished sums the API's successful Kafka publishes from its
+// metrics.
+func scrapePublished(client *http.Client, baseURL string) (float64, error) {
+	resp, err := client.Get(baseURL + "/metrics")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("GET /metrics: status %d", resp.StatusCode)
+	}
+
+	var total float64
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, loadgenPublishedMetric+"{") || !strings.Contains(line, `result="success"`) {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[strings.LastIndexByte(line, ' ')+1:], 64)
+		if err == nil {
+			total += value
+		}
+	}
+	return total, scanner.Err()
+}
+
+// percentile returns the latency p of the way up sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+func (r *loadgenReport) print(w io.Writer) {
+	seconds := r.elapsed.Seconds()
+	fmt.Fprintf(w, "orders: %d sent in %s (%.1f/s), %d skipped at the concurrency limit\n",
+		r.sent, r.elapsed.Round(time.Millisecond), float64(r.sent)/seconds, r.skipped)
+	for _, step := range r.steps {
+		sort.Slice(step.latencies, func(i, j int) bool { return step.latencies[i] < step.latencies[j] })
+		codes := make([]int, 0, len(step.statuses))
+		for code := range step.statuses {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		statuses := make([]string, len(codes))
+		for i, code := range codes {
+			statuses[i] = fmt.Sprintf("%d: %d", code, step.statuses[code])
+		}
+		fmt.Fprintf(w, "%s: %d responses, %d errors; p50 %s, p95 %s, p99 %s; %s\n",
+			step.name, len(step.latencies), step.errors,
+			percentile(step.latencies, 0.50).Round(time.Microsecond),
+			percentile(step.latencies, 0.95).Round(time.Microsecond),
+			percentile(step.latencies, 0.99).Round(time.Microsecond),
+			strings.Join(statuses, ", "))
+	}
+	if r.published >= 0 {
+		fmt.Fprintf(w, "kafka: %.0f messages published (%.1f/s)\n", r.published, r.published/seconds)
+	}
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/handlers"
+)
+
+// newLogger builds the process logger. format is "json" or "console"; level is
+// one of debug, info, warn or error.
+func newLogger(level, format string) *slog.Logger {
+	var lvl slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		lvl = slog.LevelDebug
+	case "warn":
+		lvl = slog.LevelWarn
+	case "error":
+		lvl = slog.LevelError
+	default:
+		lvl = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	if strings.ToLower(format) == "console" {
+		return slog.New(slog.NewTextHandler(os.Stdout, opts))
+	}
+	return slog.New(slog.NewJSONHandler(os.Stdout, opts))
+}
+
+// requestLogging attaches a logger carrying the request ID to the context and
+// logs one line per completed request. The ID is also left on the request's
+// context, so the events the request causes carry it on. It must run after
+// the RequestID middleware.
+func requestLogging(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		requestID := c.Response().Header().Get(echo.HeaderXRequestID)
+		c.SetRequest(c.Request().WithContext(handlers.WithRequestID(c.Request().Context(), requestID)))
+		logger := slog.Default().With(
+			"request_id", requestID,
+			"method", c.Request().Method,
+			"path", c.Path(),
+		)
+		if id := traceID(c.Request().Context()); id != "" {
+			logger = logger.With("trace_id", id)
+		}
+		c.Set(handlers.LoggerContextKey, logger)
+
+		start := time.Now()
+		err := next(c)
+		if err != nil {
+			c.Error(err)
+		}
+
+		recordRequestOutcome(c.Response().Status)
+		logger.Info("request completed",
+			"status", c.Response().Status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+		return nil
+	}
+}
+
+var requestLogger = handlers.RequestLogger
+
+// newRequestID makes up an ID for a request that did not bring one.
+func newRequestID() string {
+	id, err := idGenerator.NewID()
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return id
+}
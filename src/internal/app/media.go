@@ -0,0 +1,120 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const maxImageUploadBytes = 10 << 20
+
+// imageSizes are the widths every uploaded image is rendered at. Images
+// narrower than a size are stored at their original width.
+var imageSizes = map[string]int{
+	"thumb":  320,
+	"medium": 800,
+	"large":  1600,
+}
+
+type ImageAsset struct {
+	ID   string            `json:"id"`
+	URLs map[string]string `json:"urls"`
+}
+
+// imageTypes are the image formats uploads may be in, by the content type
+// their first bytes sniff as.
+var imageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// processImageUpload decodes an uploaded image, renders it at each configured
+// size and stores the renditions under prefix. File names embed a content
+// hash so the URLs can be cached indefinitely by a CDN.
+func processImageUpload(ctx context.Context, fh *multipart.FileHeader, prefix string) (ImageAsset, error) {
+	src, id, err := readImageUpload(fh, maxImageUploadBytes)
+	if err != nil {
+		return ImageAsset{}, err
+	}
+
+	asset := ImageAsset{
+		ID:   id,
+		URLs: make(map[string]string, len(imageSizes)),
+	}
+
+	for name, width := range imageSizes {
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, resizeToWidth(src, width), &jpeg.Options{Quality: 85})
+		if err != nil {
+			return ImageAsset{}, fmt.Errorf("failed to encode %s rendition: %w", name, err)
+		}
+
+		key := regionMediaPath(fmt.Sprintf("%s/%s-%s.jpg", prefix, asset.ID, name))
+		err = blobs.Put(ctx, key, "image/jpeg", buf.Bytes())
+		if err != nil {
+			return ImageAsset{}, err
+		}
+		asset.URLs[name] = blobs.URL(key)
+	}
+
+	return asset, nil
+}
+
+// readImageUpload decodes an uploaded image of at most maxBytes in one of
+// imageTypes, returning it with an ID derived from its content.
+func readImageUpload(fh *multipart.FileHeader, maxBytes int64) (image.Image, string, error) {
+	if fh.Size > maxBytes {
+		return nil, "", fmt.Errorf("image exceeds %d bytes", maxBytes)
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxBytes+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read upload: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, "", fmt.Errorf("image exceeds %d bytes", maxBytes)
+	}
+
+	contentType := http.DetectContentType(data)
+	if !imageTypes[contentType] {
+		return nil, "", fmt.Errorf("unsupported image type %s; use JPEG, PNG or WebP", contentType)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("unsupported image: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return src, hex.EncodeToString(sum[:8]), nil
+}
+
+func resizeToWidth(src image.Image, width int) image.Image {
+	b := src.Bounds()
+	if b.Dx() <= width {
+		return src
+	}
+
+	height := b.Dy() * width / b.Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
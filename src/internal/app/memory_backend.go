@@ -0,0 +1,228 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/segmentio/kafka-go"
+)
+
+// In-memory backends, for running the service without Redis or Kafka
+// (BACKEND=memory) and for tests. Redis is miniredis, an in-process server
+// speaking the Redis protocol, so the client and everything built on it,
+// Lua scripts and pub/sub included, work unchanged. Kafka is memoryBus, a
+// single-partition log per topic. Nothing survives a restart.
+
+const (
+	backendExternal = "external"
+	backendMemory   = "memory"
+)
+
+func validateBackend(backend string) error {
+	if backend != backendExternal && backend != backendMemory {
+		return fmt.Errorf("backend %q must be %s or %s", backend, backendExternal, backendMemory)
+	}
+	return nil
+}
+
+// startMemoryRedis starts an in-process Redis and returns a client for it.
+// Stop the server once the client is closed.
+func startMemoryRedis() (*miniredis.Miniredis, *redis.Client, error) {
+	server, err := miniredis.Run()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start in-memory redis: %v", err)
+	}
+	return server, redis.NewClient(redisOptions(server.Addr())), nil
+}
+
+// memoryBus keeps every message written to each topic, in one partition,
+// for as long as the process runs.
+type memoryBus struct {
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+}
+
+type memoryTopic struct {
+	messages []kafka.Message
+	// committed is each consumer group's next offset.
+	committed map[string]int64
+	// written is closed, and replaced, whenever a message is appended.
+	written chan struct{}
+}
+
+func newMemoryBus() *memoryBus {
+	return &memoryBus{topics: map[string]*memoryTopic{}}
+}
+
+// topic returns the named topic, creating it on first use as Kafka would
+// with auto-creation on. The caller must hold b.mu.
+func (b *memoryBus) topic(name string) *memoryTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &memoryTopic{committed: map[string]int64{}, written: make(chan struct{})}
+		b.topics[name] = t
+	}
+	return t
+}
+
+func (b *memoryBus) Writer(topic string) messageWriter {
+	return memoryWriter{bus: b, topic: topic}
+}
+
+func (b *memoryBus) GroupReader(topic, groupID string) messageReader {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &memoryReader{bus: b, topic: topic, group: groupID, next: b.topic(topic).committed[groupID]}
+}
+
+func (b *memoryBus) PartitionReader(topic string, partition int) messageReader {
+	return &memoryReader{bus: b, topic: topic}
+}
+
+func (b *memoryBus) Partitions(ctx context.Context, topic string) ([]int, error) {
+	return []int{0}, nil
+}
+
+func (b *memoryBus) Ping(ctx context.Context) error {
+	return nil
+}
+
+type memoryWriter struct {
+	bus   *memoryBus
+	topic string
+}
+
+func (w memoryWriter) Topic() string {
+	return w.topic
+}
+
+func (w memoryWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.bus.mu.Lock()
+	defer w.bus.mu.Unlock()
+
+	t := w.bus.topic(w.topic)
+	for _, msg := range msgs {
+		msg.Topic = w.topic
+		msg.Partition = 0
+		msg.Offset = int64(len(t.messages))
+		if msg.Time.IsZero() {
+			msg.Time = time.Now()
+		}
+		t.messages = append(t.messages, msg)
+	}
+	close(t.written)
+	t.written = make(chan struct{})
+	return nil
+}
+
+func (w memoryWriter) Close() error {
+	return nil
+}
+
+// memoryReader reads a topic from next. Readers in a group start at the
+// group's committed offset; partition readers start wherever SetOffset puts
+// them, the beginning by default.
+type memoryReader struct {
+	bus   *memoryBus
+	topic string
+	group string
+	next  int64
+}
+
+func (r *memoryReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		r.bus.mu.Lock()
+		t := r.bus.topic(r.topic)
+		if r.next < int64(len(t.messages)) {
+			msg := t.messages[r.next]
+			msg.HighWaterMark = int64(len(t.messages))
+			r.next++
+			r.bus.mu.Unlock()
+			return msg, nil
+		}
+		written := t.written
+		r.bus.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		case <-written:
+		}
+	}
+}
+
+// ReadMessage is FetchMessage; as with kafka-go, it commits straight away
+// when the reader is in a group.
+func (r *memoryReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	msg, err := r.FetchMessage(ctx)
+	if err != nil {
+		return msg, err
+	}
+	if r.group != "" {
+		return msg, r.CommitMessages(ctx, msg)
+	}
+	return msg, nil
+}
+
+func (r *memoryReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if r.group == "" {
+		return fmt.Errorf("commit on a reader without a consumer group")
+	}
+
+	r.bus.mu.Lock()
+	defer r.bus.mu.Unlock()
+	t := r.bus.topic(r.topic)
+	for _, msg := range msgs {
+		t.committed[r.group] = max(t.committed[r.group], msg.Offset+1)
+	}
+	return nil
+}
+
+// SetOffset positions a partition reader, accepting kafka.FirstOffset and
+// kafka.LastOffset as Kafka does.
+func (r *memoryReader) SetOffset(offset int64) error {
+	if r.group != "" {
+		return fmt.Errorf("set offset on a reader in a consumer group")
+	}
+
+	r.bus.mu.Lock()
+	defer r.bus.mu.Unlock()
+	switch offset {
+	case kafka.FirstOffset:
+		r.next = 0
+	case kafka.LastOffset:
+		r.next = int64(len(r.bus.topic(r.topic).messages))
+	default:
+		r.next = offset
+	}
+	return nil
+}
+
+// SetOffsetAt positions a partition reader at the first message written at
+// or after t.
+func (r *memoryReader) SetOffsetAt(ctx context.Context, t time.Time) error {
+	if r.group != "" {
+		return fmt.Errorf("set offset on a reader in a consumer group")
+	}
+
+	r.bus.mu.Lock()
+	defer r.bus.mu.Unlock()
+	messages := r.bus.topic(r.topic).messages
+	r.next = int64(sort.Search(len(messages), func(i int) bool { return !messages[i].Time.Before(t) }))
+	return nil
+}
+
+func (r *memoryReader) Offset() int64 {
+	r.bus.mu.Lock()
+	defer r.bus.mu.Unlock()
+	return r.next
+}
+
+func (r *memoryReader) Close() error {
+	return nil
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func writeTestMessages(t *testing.T, bus *memoryBus, topic string, values ...string) {
+	t.Helper()
+	msgs := make([]kafka.Message, len(values))
+	for i, value := range values {
+		msgs[i] = kafka.Message{Value: []byte(value)}
+	}
+	if err := bus.Writer(topic).WriteMessages(context.Background(), msgs...); err != nil {
+		t.Fatalf("writing to %s: %v", topic, err)
+	}
+}
+
+func readTestMessage(t *testing.T, r messageReader) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, err := r.ReadMessage(ctx)
+	if err != nil {
+		t.Fatalf("reading: %v", err)
+	}
+	return string(msg.Value)
+}
+
+func TestMemoryBusGroupResumesFromCommit(t *testing.T) {
+	bus := newMemoryBus()
+	writeTestMessages(t, bus, "orders", "a", "b", "c")
+
+	r := bus.GroupReader("orders", "workers")
+	if got := readTestMessage(t, r); got != "a" {
+		t.Fatalf("first read = %q, want a", got)
+	}
+	fetchCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := r.FetchMessage(fetchCtx); err != nil {
+		t.Fatalf("fetching: %v", err)
+	}
+	r.Close()
+
+	// ReadMessage committed a; b was fetched but not committed, so the
+	// group's next reader gets it again.
+	if got := readTestMessage(t, bus.GroupReader("orders", "workers")); got != "b" {
+		t.Errorf("group resumed at %q, want b", got)
+	}
+	if got := readTestMessage(t, bus.GroupReader("orders", "other")); got != "a" {
+		t.Errorf("new group started at %q, want a", got)
+	}
+}
+
+func TestMemoryBusPartitionReaderOffsets(t *testing.T) {
+	bus := newMemoryBus()
+	writeTestMessages(t, bus, "orders", "a", "b")
+
+	r := bus.PartitionReader("orders", 0)
+	if err := r.SetOffset(kafka.LastOffset); err != nil {
+		t.Fatalf("seeking to the end: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := r.ReadMessage(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("read at the end = %v, want it to wait until the deadline", err)
+	}
+
+	// A reader waiting at the end gets what is written next.
+	go bus.Writer("orders").WriteMessages(context.Background(), kafka.Message{Value: []byte("c")})
+	if got := readTestMessage(t, r); got != "c" {
+		t.Errorf("read after write = %q, want c", got)
+	}
+
+	if err := r.SetOffset(kafka.FirstOffset); err != nil {
+		t.Fatalf("seeking to the start: %v", err)
+	}
+	if got := readTestMessage(t, r); got != "a" {
+		t.Errorf("read from the start = %q, want a", got)
+	}
+	if err := r.CommitMessages(context.Background(), kafka.Message{}); err == nil {
+		t.Error("commit on a partition reader succeeded")
+	}
+}
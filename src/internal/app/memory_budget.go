@@ -0,0 +1,320 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"myproject/src/clock"
+)
+
+// Redis memory budgets. Every MemoryBudgetInterval the keyspace is walked and
+// each key's MEMORY USAGE added to its family's total, which is exported as a
+// metric. A family over its cap in MemoryBudgets is logged; cached menus, the
+// one family that can be rebuilt at will, are also trimmed back under their
+// cap by dropping the least recently used.
+
+const (
+	memoryScanCount = 500
+
+	// menuRecencyKey scores each cached menu's restaurant ID by when the
+	// menu was last read, in Unix milliseconds.
+	menuRecencyKey = "menus:lru"
+
+	menuEvictionBatch = 50
+)
+
+// keyFamilies maps the first segment of a key to the family it is
+// accounted under. Keys not listed are counted as "other".
+var keyFamilies = map[string]string{
+	"brands":       "restaurants",
+	"contact":      "customers",
+	"cuisine":      "restaurants",
+	"customer":     "customers",
+	"event":        "events",
+	"feeshare":     "ledgers",
+	"ledger":       "ledgers",
+	"menu":         "menu_state",
+	"menus":        "menus",
+	"notification": "notifications",
+	"ops":          "ops",
+	"order":        "orders",
+	"orders":       "orders",
+	"payment":      "orders",
+	"price_change": "menu_state",
+	"projection":   "projections",
+	"promo":        "promos",
+	"ratelimit":    "ratelimits",
+	"restaurant":   "restaurants",
+	"rider":        "riders",
+	"ticket":       "tickets",
+	"webhooks":     "webhooks",
+}
+
+// keyFamily returns the family key belongs to. The cached menu documents,
+// menu:{id}, are a family of their own, apart from the prices and stock kept
+// alongside them which cannot be rebuilt from menu.json.
+func keyFamily(key string) string {
+	segment, _, _ := strings.Cut(key, ":")
+	if segment == "menu" && strings.Count(key, ":") == 1 {
+		return "menus"
+	}
+	if family, ok := keyFamilies[segment]; ok {
+		return family
+	}
+	return "other"
+}
+
+var (
+	redisFamilyBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redis_key_family_bytes",
+		Help: "Memory used by each key family as of the last scan, from MEMORY USAGE.",
+	}, []string{"family"})
+
+	redisFamilyKeys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redis_key_family_keys",
+		Help: "Keys in each key family as of the last scan.",
+	}, []string{"family"})
+
+	redisFamilyBudget = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redis_key_family_budget_bytes",
+		Help: "Configured memory cap of each key family.",
+	}, []string{"family"})
+
+	redisFamilyEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_key_family_evictions_total",
+		Help: "Keys removed to bring a key family back under its memory cap.",
+	}, []string{"family"})
+
+	redisUsedMemory = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "redis_used_memory_bytes",
+		Help: "Memory Redis reports in use, from INFO memory.",
+	})
+
+	redisEvictedKeys = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "redis_evicted_keys",
+		Help: "Keys Redis itself has evicted under maxmemory since it started, from INFO stats.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(redisFamilyBytes, redisFamilyKeys, redisFamilyBudget, redisFamilyEvictions, redisUsedMemory, redisEvictedKeys)
+}
+
+// touchMenu records that restaurantID's cached menu was just used.
+func touchMenu(ctx context.Context, restaurantID string) {
+	err := redisClient.ZAdd(ctx, menuRecencyKey, &redis.Z{
+		Score:  float64(clock.Now().UnixMilli()),
+		Member: restaurantID,
+	}).Err()
+	if err != nil {
+		slog.Warn("error recording menu use", "restaurant_id", restaurantID, "error", err)
+	}
+}
+
+// familyUsage is one scan's totals for a key family.
+type familyUsage struct {
+	Bytes int64
+	Keys  int64
+}
+
+// measureKeyFamilies walks the keyspace and totals MEMORY USAGE by family.
+func measureKeyFamilies(ctx context.Context) (map[string]familyUsage, error) {
+	usage := map[string]familyUsage{}
+	var cursor uint64
+	for {
+		keys, next, err := redisClient.Scan(ctx, cursor, "*", memoryScanCount).Result()
+		if err != nil {
+			return nil, fmt.Errorf("redis error: %v", err)
+		}
+
+		if len(keys) > 0 {
+			pipe := redisClient.Pipeline()
+			cmds := make([]*redis.IntCmd, len(keys))
+			for i, key := range keys {
+				cmds[i] = pipe.MemoryUsage(ctx, key)
+			}
+			// Keys that expired since the scan come back as redis.Nil.
+			_, err = pipe.Exec(ctx)
+			if err != nil && err != redis.Nil {
+				return nil, fmt.Errorf("redis error: %v", err)
+			}
+
+			for i, key := range keys {
+				family := keyFamily(key)
+				u := usage[family]
+				u.Bytes += cmds[i].Val()
+				u.Keys++
+				usage[family] = u
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return usage, nil
+		}
+	}
+}
+
+// evictMenu drops a cached menu unless it was used after it was picked for
+// eviction, and returns the bytes it freed, or -1 if it was kept.
+// KEYS[1] menu recency set, KEYS[2] cached menu; ARGV[1] restaurant ID,
+// ARGV[2] its recency score when picked.
+var evictMenu = redis.NewScript(`
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if score and tonumber(score) > tonumber(ARGV[2]) then
+	return -1
+end
+local size = redis.call("MEMORY", "USAGE", KEYS[2]) or 0
+redis.call("DEL", KEYS[2])
+redis.call("ZREM", KEYS[1], ARGV[1])
+return size
+`)
+
+// trimMenuCache evicts the least recently used menus until the cached menus
+// take no more than budget bytes, given they took used bytes when measured.
+// Menus are rebuilt from menu.json on their next read.
+func trimMenuCache(ctx context.Context, used, budget int64) (int, error) {
+	evicted := 0
+	for used > budget {
+		oldest, err := redisClient.ZRangeWithScores(ctx, menuRecencyKey, 0, menuEvictionBatch-1).Result()
+		if err != nil {
+			return evicted, fmt.Errorf("redis error: %v", err)
+		}
+		if len(oldest) == 0 {
+			// Menus cached without a recorded use, e.g. before this was
+			// deployed, are left to their TTL.
+			return evicted, nil
+		}
+
+		kept := 0
+		for _, z := range oldest {
+			restaurantID := z.Member.(string)
+			freed, err := evictMenu.Run(ctx, redisClient, []string{menuRecencyKey, menuKey(restaurantID)},
+				restaurantID, strconv.FormatFloat(z.Score, 'f', -1, 64)).Int64()
+			if err != nil {
+				return evicted, fmt.Errorf("redis error: %v", err)
+			}
+			if freed < 0 {
+				kept++
+				continue
+			}
+
+			// A menu that had already expired frees nothing but still
+			// leaves the recency set.
+			if freed > 0 {
+				used -= freed
+				evicted++
+				redisFamilyEvictions.WithLabelValues("menus").Inc()
+			}
+			if used <= budget {
+				break
+			}
+		}
+		if kept == len(oldest) {
+			// Everything left has just been used; stop rather than spin.
+			return evicted, nil
+		}
+	}
+	return evicted, nil
+}
+
+// recordRedisInfo exports Redis's own view of its memory and evictions.
+func recordRedisInfo(ctx context.Context) error {
+	info, err := redisClient.Info(ctx, "memory", "stats").Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	for _, line := range strings.Split(info, "\r\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		switch name {
+		case "used_memory":
+			redisUsedMemory.Set(n)
+		case "evicted_keys":
+			redisEvictedKeys.Set(n)
+		}
+	}
+	return nil
+}
+
+// enforceMemoryBudgets measures every key family once, exports the totals,
+// and acts on any family over its budget.
+func enforceMemoryBudgets(ctx context.Context, budgets map[string]int64) error {
+	// The in-memory Redis has no INFO memory or stats to report.
+	if appConfig.Backend != backendMemory {
+		if err := recordRedisInfo(ctx); err != nil {
+			slog.Warn("error reading redis info", "error", err)
+		}
+	}
+
+	usage, err := measureKeyFamilies(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Families that have since emptied are reported as zero rather than
+	// keeping their last total.
+	for family := range budgets {
+		if _, ok := usage[family]; !ok {
+			usage[family] = familyUsage{}
+		}
+	}
+	for family, u := range usage {
+		redisFamilyBytes.WithLabelValues(family).Set(float64(u.Bytes))
+		redisFamilyKeys.WithLabelValues(family).Set(float64(u.Keys))
+	}
+
+	for family, budget := range budgets {
+		used := usage[family].Bytes
+		if used <= budget {
+			continue
+		}
+
+		if family != "menus" {
+			slog.Warn("redis key family over memory budget", "family", family, "bytes", used, "budget", budget)
+			continue
+		}
+
+		evicted, err := trimMenuCache(ctx, used, budget)
+		if err != nil {
+			return err
+		}
+		slog.Info("menu cache trimmed to memory budget", "bytes", used, "budget", budget, "evicted", evicted)
+	}
+	return nil
+}
+
+// runMemoryBudgets enforces budgets every interval until ctx is done.
+func runMemoryBudgets(ctx context.Context, budgets map[string]int64, interval time.Duration) {
+	for family, budget := range budgets {
+		redisFamilyBudget.WithLabelValues(family).Set(float64(budget))
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := enforceMemoryBudgets(ctx, budgets); err != nil && ctx.Err() == nil {
+			slog.Error("error enforcing redis memory budgets", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,91 @@
+//go:build !integration
+
+package app
+
+// Unit tests run the service in the process, on the in-memory Redis and
+// event bus of BACKEND=memory, so they need nothing else running:
+//
+//	go test ./src/internal/app/
+//
+// The benchmarks in app_test.go time the order path on them without
+// containers:
+//
+//	go test -run='^$' -bench=. ./src/internal/app/
+
+import (
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"myproject/src/clock"
+	"myproject/src/handlers"
+)
+
+// testRedis is the in-memory Redis the tests run on.
+var testRedis *miniredis.Miniredis
+
+// testClock is the service's clock while the tests run. It only moves when
+// a test moves it on.
+var testClock = clock.NewFake(time.Date(2026, time.March, 2, 12, 0, 0, 0, time.UTC))
+
+func TestMain(m *testing.M) {
+	os.Exit(runInMemory(m))
+}
+
+// requireBackends never skips: the in-memory backends are always there.
+func requireBackends(testing.TB) {}
+
+// runInMemory starts the service on the in-memory backends, runs the tests
+// and tears everything down again.
+func runInMemory(m *testing.M) int {
+	// The menu, restaurant and rider files are read from the working
+	// directory, which for the binaries is src.
+	err := os.Chdir("../..")
+	if err != nil {
+		slog.Error("error changing to the src directory", "error", err)
+		return 1
+	}
+
+	for key, value := range map[string]string{
+		"BACKEND":              backendMemory,
+		"STORE":                storeRedis,
+		"JWT_SECRET":           testJWTSecret,
+		"PAYMENT_PROVIDER":     "mock",
+		"OUTBOX_POLL_INTERVAL": "100ms",
+		"LOG_LEVEL":            "error",
+		// Every request comes from the one address, which the benchmarks
+		// would soon run out of requests for.
+		"RATE_LIMIT_ENABLED": "false",
+	} {
+		os.Setenv(key, value)
+	}
+	clock.Default = testClock
+
+	server, client, err := startMemoryRedis()
+	if err != nil {
+		slog.Error("error starting in-memory redis", "error", err)
+		return 1
+	}
+	defer server.Close()
+	testRedis = server
+	release := setup("test", &backends{redis: client, bus: newMemoryBus()})
+	defer release()
+	defer closeWriters(context.Background())
+
+	appCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go runOutboxRelay(appCtx, appConfig.OutboxPollInterval)
+	newOrderConsumer().Start(appCtx)
+
+	orders := NewOrderService(redisClient, analyticsWriter, repositories, appConfig)
+	h := handlers.New(NewMenuService(redisClient, analyticsWriter, repositories), orders, NewNotificationService(redisClient, repositories))
+	testServer = httptest.NewServer(newRouter(h, orders))
+	defer testServer.Close()
+
+	return m.Run()
+}
@@ -0,0 +1,196 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+// Chains run one menu across their branches. Cloning copies a branch's menu,
+// at its published prices, to other branches, each of which may price items
+// differently or leave some off. A cloned menu is stored in Redis and takes
+// the place of the branch's menu in menu.json.
+
+type MenuCloneBranch struct {
+	RestaurantID string `json:"restaurant_id" validate:"required"`
+	// Prices overrides the source price of the menu items listed.
+	Prices map[string]float64 `json:"prices" validate:"dive,gte=0"`
+	// Unavailable lists the menu items the branch does not offer for now.
+	Unavailable []string `json:"unavailable"`
+}
+
+type MenuCloneRequest struct {
+	Branches []MenuCloneBranch `json:"branches" validate:"required,min=1,max=50,dive"`
+}
+
+type MenuCloneResult struct {
+	RestaurantID   string `json:"restaurant_id"`
+	Items          int    `json:"items"`
+	PriceOverrides int    `json:"price_overrides"`
+	Unavailable    int    `json:"unavailable"`
+}
+
+// menuDocumentKey holds a menu cloned to the restaurant, which is served in
+// place of its menu.json entry.
+func menuDocumentKey(restaurantID string) string {
+	return "menu:" + restaurantID + ":document"
+}
+
+// storedMenu returns the menu cloned to the restaurant, or errMenuNotFound if
+// it has none.
+func storedMenu(ctx context.Context, restaurantID string) (RestaurantMenu, error) {
+	data, err := redisClient.Get(ctx, menuDocumentKey(restaurantID)).Result()
+	if err == redis.Nil {
+		return RestaurantMenu{}, errMenuNotFound
+	} else if err != nil {
+		return RestaurantMenu{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	var menu RestaurantMenu
+	err = json.Unmarshal([]byte(data), &menu)
+	if err != nil {
+		return RestaurantMenu{}, fmt.Errorf("failed to parse stored menu: %v", err)
+	}
+	return menu, nil
+}
+
+// cloneBranchMenu is source as served at branch.
+func cloneBranchMenu(source RestaurantMenu, branch MenuCloneBranch) RestaurantMenu {
+	menu := RestaurantMenu{RestaurantID: branch.RestaurantID, Menu: make([]MenuItem, len(source.Menu))}
+	for i, item := range source.Menu {
+		item.Available = false
+		item.Quantity = nil
+		if price, ok := branch.Prices[item.ID]; ok {
+			item.Price = price
+		}
+		menu.Menu[i] = item
+	}
+	touch(&menu.CreatedAt, &menu.UpdatedAt)
+	return menu
+}
+
+// cloneMenu serves POST /restaurant/:id/menu/clone. Each branch's menu,
+// published prices, availability, pairings and images are replaced; its
+// stock counts are its own and are kept.
+func cloneMenu(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req MenuCloneRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	sourceID := c.Param("id")
+	// Owners clone within what they own, which for a brand owner is the
+	// brand's branches.
+	admin := authClaims(c).Role == roleAdmin
+	if !admin && !ownsRestaurant(c, sourceID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	source, err := getMenuFromCache(ctx, sourceID)
+	if err == errMenuNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Restaurant not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant menu"})
+	}
+
+	pairings, err := redisClient.HGetAll(ctx, menuPairingsKey(sourceID)).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant menu"})
+	}
+	images, err := redisClient.HGetAll(ctx, menuImagesKey(sourceID)).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant menu"})
+	}
+
+	items := make(map[string]bool, len(source.Menu))
+	for _, item := range source.Menu {
+		items[item.ID] = true
+	}
+
+	seen := map[string]bool{}
+	for i, branch := range req.Branches {
+		field := fmt.Sprintf("branches[%d]", i)
+		if branch.RestaurantID == sourceID {
+			return validationFailed(c, field+".restaurant_id", "cannot be the restaurant cloned from")
+		}
+		if seen[branch.RestaurantID] {
+			return validationFailed(c, field+".restaurant_id", "is listed more than once")
+		}
+		seen[branch.RestaurantID] = true
+		if !admin && !ownsRestaurant(c, branch.RestaurantID) {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for " + branch.RestaurantID})
+		}
+
+		_, err := findRestaurant(ctx, branch.RestaurantID)
+		if err == errRestaurantNotFound {
+			return validationFailed(c, field+".restaurant_id", "is not a known restaurant")
+		} else if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant"})
+		}
+
+		for itemID := range branch.Prices {
+			if !items[itemID] {
+				return validationFailed(c, field+".prices", "has unknown menu item "+itemID)
+			}
+		}
+		for _, itemID := range branch.Unavailable {
+			if !items[itemID] {
+				return validationFailed(c, field+".unavailable", "has unknown menu item "+itemID)
+			}
+		}
+	}
+
+	logger := requestLogger(c).With("source_id", sourceID)
+	results := make([]MenuCloneResult, 0, len(req.Branches))
+	for _, branch := range req.Branches {
+		menu := cloneBranchMenu(source, branch)
+		menuJSON, err := json.Marshal(menu)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to clone menu"})
+		}
+
+		pipe := redisClient.TxPipeline()
+		pipe.Set(ctx, menuDocumentKey(branch.RestaurantID), menuJSON, 0)
+		pipe.Del(ctx, menuKey(branch.RestaurantID), menuPricesKey(branch.RestaurantID), menuUnavailableKey(branch.RestaurantID), menuPairingsKey(branch.RestaurantID), menuImagesKey(branch.RestaurantID))
+		if len(pairings) > 0 {
+			pipe.HSet(ctx, menuPairingsKey(branch.RestaurantID), pairings)
+		}
+		if len(images) > 0 {
+			pipe.HSet(ctx, menuImagesKey(branch.RestaurantID), images)
+		}
+		pipe.ZRem(ctx, menuRecencyKey, branch.RestaurantID)
+		if len(branch.Unavailable) > 0 {
+			members := make([]interface{}, len(branch.Unavailable))
+			for i, itemID := range branch.Unavailable {
+				members[i] = itemID
+			}
+			pipe.SAdd(ctx, menuUnavailableKey(branch.RestaurantID), members...)
+		}
+		_, err = pipe.Exec(ctx)
+		if err != nil {
+			// Branches already cloned stay cloned; the caller can retry the
+			// request as a whole.
+			logger.Error("error storing cloned menu", "restaurant_id", branch.RestaurantID, "error", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to clone menu to " + branch.RestaurantID})
+		}
+
+		results = append(results, MenuCloneResult{
+			RestaurantID:   branch.RestaurantID,
+			Items:          len(menu.Menu),
+			PriceOverrides: len(branch.Prices),
+			Unavailable:    len(branch.Unavailable),
+		})
+		logger.Info("menu cloned", "restaurant_id", branch.RestaurantID, "price_overrides", len(branch.Prices), "unavailable", len(branch.Unavailable))
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"source_id": sourceID,
+		"branches":  results,
+	})
+}
@@ -0,0 +1,249 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+// Menu publishing. A restaurant publishes a whole new menu, which replaces
+// its menu.json entry the way a cloned menu does. Before it goes live the
+// menu is run through compliance checks; a check that fails is an error,
+// which blocks publishing, unless it is listed in MenuComplianceWarnings.
+// The report of the last check is kept for the restaurant to look back on.
+
+const (
+	complianceError   = "error"
+	complianceWarning = "warning"
+
+	checkItemIdentity      = "item_identity"
+	checkPricePositive     = "price_positive"
+	checkImagePresent      = "image_present"
+	checkDescriptionLength = "description_length"
+	checkRestrictedWords   = "restricted_keywords"
+	checkCategoryPresent   = "category_present"
+	checkPriceApproval     = "price_approval"
+)
+
+type MenuPublishRequest struct {
+	Menu []MenuItem `json:"menu" validate:"required,min=1,max=500"`
+}
+
+// ComplianceIssue is one check an item, or the menu as a whole, failed.
+type ComplianceIssue struct {
+	ItemID   string `json:"item_id,omitempty"`
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+type ComplianceReport struct {
+	RestaurantID string            `json:"restaurant_id"`
+	Passed       bool              `json:"passed"`
+	Errors       int               `json:"errors"`
+	Warnings     int               `json:"warnings"`
+	Issues       []ComplianceIssue `json:"issues"`
+	CheckedAt    Timestamp         `json:"checked_at"`
+}
+
+func (r *ComplianceReport) add(itemID, check, message string) {
+	severity := complianceError
+	for _, warning := range appConfig.MenuComplianceWarnings {
+		if warning == check {
+			severity = complianceWarning
+		}
+	}
+	if severity == complianceError {
+		r.Errors++
+	} else {
+		r.Warnings++
+	}
+	r.Issues = append(r.Issues, ComplianceIssue{ItemID: itemID, Check: check, Severity: severity, Message: message})
+}
+
+func menuComplianceKey(restaurantID string) string {
+	return "menu:" + restaurantID + ":compliance"
+}
+
+// restrictedWords matches MenuRestrictedKeywords the way the profanity
+// filter matches its words: whole and ignoring case.
+var restrictedWords = sync.OnceValue(func() profanityFilter {
+	return newProfanityFilter(appConfig.MenuRestrictedKeywords)
+})
+
+// checkMenuCompliance checks items, to be published as restaurantID's menu in
+// place of current. Price changes to current items beyond
+// MenuPriceApprovalThreshold need an owner unless ownerApproved.
+func checkMenuCompliance(restaurantID string, items []MenuItem, current []MenuItem, ownerApproved bool) ComplianceReport {
+	report := ComplianceReport{RestaurantID: restaurantID, Issues: []ComplianceIssue{}, CheckedAt: timestampNow()}
+
+	currentPrices := make(map[string]float64, len(current))
+	for _, item := range current {
+		currentPrices[item.ID] = item.Price
+	}
+
+	seen := make(map[string]bool, len(items))
+	for i, item := range items {
+		switch {
+		case item.ID == "":
+			report.add("", checkItemIdentity, fmt.Sprintf("menu[%d] has no id", i))
+			continue
+		case seen[item.ID]:
+			report.add(item.ID, checkItemIdentity, "id is listed more than once")
+			continue
+		}
+		seen[item.ID] = true
+		if strings.TrimSpace(item.Name) == "" {
+			report.add(item.ID, checkItemIdentity, "has no name")
+		}
+
+		if item.Price <= 0 {
+			report.add(item.ID, checkPricePositive, "price must be more than 0")
+		}
+		if item.ImageURL == "" {
+			report.add(item.ID, checkImagePresent, "has no image")
+		}
+		if strings.TrimSpace(item.Category) == "" {
+			report.add(item.ID, checkCategoryPresent, "has no category")
+		}
+
+		texts := map[string]MenuItemText{"": {Name: item.Name, Description: item.Description}}
+		for locale, text := range item.Translations {
+			texts[locale] = text
+		}
+		for locale, text := range texts {
+			in := ""
+			if locale != "" {
+				in = " in " + locale
+			}
+			if n := utf8.RuneCountInString(text.Description); n > appConfig.MenuDescriptionMaxLength {
+				report.add(item.ID, checkDescriptionLength, fmt.Sprintf("description%s is %d characters, over the limit of %d", in, n, appConfig.MenuDescriptionMaxLength))
+			}
+			if pattern := restrictedWords().pattern; pattern != nil {
+				if word := pattern.FindString(text.Name + "\n" + text.Description); word != "" {
+					report.add(item.ID, checkRestrictedWords, fmt.Sprintf("mentions restricted keyword %q%s", strings.ToLower(word), in))
+				}
+			}
+		}
+
+		if oldPrice, ok := currentPrices[item.ID]; ok && !ownerApproved && item.Price > 0 {
+			percent := priceChangePercent(oldPrice, roundMoney(item.Price))
+			if percent > appConfig.MenuPriceApprovalThreshold {
+				report.add(item.ID, checkPriceApproval, "price changes by more than the approval threshold; request it through the item's price change so an owner can approve it")
+			}
+		}
+	}
+
+	report.Passed = report.Errors == 0
+	return report
+}
+
+// publishMenu serves POST /restaurant/:id/menu/publish. The menu is checked
+// and, if it passes, replaces the restaurant's menu. Published prices give
+// way to those in the new menu; availability, stock counts and pairings are
+// kept. With dry_run=true the menu is only checked.
+func publishMenu(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	claims := authClaims(c)
+	owner := claims.Role == roleAdmin || ownsRestaurant(c, restaurantID)
+	if !owner && !actsForRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	var req MenuPublishRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	if _, err := findRestaurant(ctx, restaurantID); err == errRestaurantNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Restaurant not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant"})
+	}
+
+	current, err := getMenuFromCache(ctx, restaurantID)
+	if err != nil && err != errMenuNotFound {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant menu"})
+	}
+
+	logger := requestLogger(c).With("restaurant_id", restaurantID)
+	report := checkMenuCompliance(restaurantID, req.Menu, current.Menu, owner)
+	dryRun := c.QueryParam("dry_run") == "true"
+	if !dryRun {
+		reportJSON, _ := json.Marshal(report)
+		err := redisClient.Set(ctx, menuComplianceKey(restaurantID), reportJSON, 0).Err()
+		if err != nil {
+			logger.Warn("error saving menu compliance report", "error", err)
+		}
+	}
+
+	switch {
+	case !report.Passed:
+		logger.Info("menu publish blocked by compliance checks", "errors", report.Errors, "warnings", report.Warnings)
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":  "Menu failed compliance checks",
+			"report": report,
+		})
+	case dryRun:
+		return c.JSON(http.StatusOK, map[string]interface{}{"status": "checked", "report": report})
+	}
+
+	menu := RestaurantMenu{RestaurantID: restaurantID, Menu: make([]MenuItem, len(req.Menu)), CreatedAt: current.CreatedAt}
+	for i, item := range req.Menu {
+		// Live state is kept apart from the menu and filled in on read.
+		item.Price = roundMoney(item.Price)
+		item.Available, item.Quantity, item.GoesWellWith, item.Locale = false, nil, nil, ""
+		menu.Menu[i] = item
+	}
+	touch(&menu.CreatedAt, &menu.UpdatedAt)
+	menuJSON, err := json.Marshal(menu)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to publish menu"})
+	}
+
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, menuDocumentKey(restaurantID), menuJSON, 0)
+	pipe.Del(ctx, menuKey(restaurantID), menuPricesKey(restaurantID))
+	pipe.ZRem(ctx, menuRecencyKey, restaurantID)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		logger.Error("error publishing menu", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to publish menu"})
+	}
+
+	logger.Info("menu published", "items", len(menu.Menu), "warnings", report.Warnings)
+	return c.JSON(http.StatusOK, map[string]interface{}{"status": "published", "report": report})
+}
+
+// getMenuCompliance serves GET /restaurant/:id/menu/compliance, the report
+// of the restaurant's last publish.
+func getMenuCompliance(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	if authClaims(c).Role != roleAdmin && !actsForRestaurant(c, restaurantID) && !ownsRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	data, err := redisClient.Get(ctx, menuComplianceKey(restaurantID)).Result()
+	if err == redis.Nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Menu has not been checked"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch compliance report"})
+	}
+
+	var report ComplianceReport
+	err = json.Unmarshal([]byte(data), &report)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch compliance report"})
+	}
+	return c.JSON(http.StatusOK, report)
+}
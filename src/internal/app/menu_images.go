@@ -0,0 +1,106 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/jpeg"
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+// Menu item images. A restaurant uploads one image per item; it is scaled
+// down to menuImageWidth, stored through the blob store, and its key kept in
+// Redis beside the menu. The image replaces the catalog's image_url on the
+// item wherever the menu is served.
+
+// menuImageWidth is the widest a menu item image is stored.
+const menuImageWidth = 800
+
+func menuImagesKey(restaurantID string) string {
+	return "menu:" + restaurantID + ":images"
+}
+
+// applyMenuImages sets the image URL of each item with an uploaded image.
+func applyMenuImages(ctx context.Context, rdb redis.Cmdable, menu *RestaurantMenu) error {
+	images, err := rdb.HGetAll(ctx, menuImagesKey(menu.RestaurantID)).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	for i := range menu.Menu {
+		if key, ok := images[menu.Menu[i].ID]; ok {
+			menu.Menu[i].ImageURL = blobs.URL(key)
+		}
+	}
+	return nil
+}
+
+// uploadMenuItemImage serves POST /menu/item/:id/image, replacing the item's
+// image with the JPEG, PNG or WebP in the image field of the form.
+func uploadMenuItemImage(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.FormValue("restaurant_id")
+	if restaurantID == "" {
+		return validationFailed(c, "restaurant_id", "is required")
+	}
+	if !actsForRestaurant(c, restaurantID) && !ownsRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	fh, err := c.FormFile("image")
+	if err != nil {
+		return validationFailed(c, "image", "is required")
+	}
+
+	itemID := c.Param("id")
+	menu, err := getMenuFromCache(ctx, restaurantID)
+	if err == errMenuNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Restaurant not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant menu"})
+	}
+
+	var item *MenuItem
+	for i := range menu.Menu {
+		if menu.Menu[i].ID == itemID {
+			item = &menu.Menu[i]
+		}
+	}
+	if item == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Menu item not found"})
+	}
+
+	logger := requestLogger(c).With("restaurant_id", restaurantID, "menu_id", itemID)
+	src, imageID, err := readImageUpload(fh, appConfig.MenuImageMaxBytes)
+	if err != nil {
+		logger.Warn("rejected menu image upload", "error", err)
+		return validationFailed(c, "image", err.Error())
+	}
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, resizeToWidth(src, menuImageWidth), &jpeg.Options{Quality: 85})
+	if err != nil {
+		logger.Error("error encoding menu image", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store image"})
+	}
+
+	key := regionMediaPath(fmt.Sprintf("menus/%s/%s/%s.jpg", restaurantID, itemID, imageID))
+	err = blobs.Put(c.Request().Context(), key, "image/jpeg", buf.Bytes())
+	if err != nil {
+		logger.Error("error storing menu image", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store image"})
+	}
+
+	err = redisClient.HSet(ctx, menuImagesKey(restaurantID), itemID, key).Err()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store image"})
+	}
+
+	logger.Info("menu image uploaded", "image_id", imageID)
+	item.ImageURL = blobs.URL(key)
+	return c.JSON(http.StatusOK, item)
+}
@@ -0,0 +1,71 @@
+package app
+
+import "strings"
+
+// Menu localization. Restaurants may translate their items' names and
+// descriptions in menu.json; localizeMenu serves each item in the first of
+// the caller's languages it has, and prices in the platform's currency.
+
+// localizeMenu fills in each item's currency and, when the caller asked for
+// languages, swaps in the best translation of its name and description.
+// Items without one stay as listed. Translations are left out of a menu
+// served in a language, being of no further use to its reader.
+func localizeMenu(menu *RestaurantMenu, locales []string) {
+	for i := range menu.Menu {
+		item := &menu.Menu[i]
+		if item.Currency == "" {
+			item.Currency = appConfig.PaymentCurrency
+		}
+		if len(locales) == 0 {
+			continue
+		}
+
+		if locale, text, ok := matchTranslation(item.Translations, locales); ok {
+			item.Name = text.Name
+			if text.Description != "" {
+				item.Description = text.Description
+			}
+			item.Locale = locale
+		}
+		item.Translations = nil
+	}
+}
+
+// matchTranslation finds the translation for the first of locales that
+// translations has, matching tags case-insensitively and, failing an exact
+// match, by language alone: "th-TH" is served by "th", and "pt" by "pt-BR".
+func matchTranslation(translations map[string]MenuItemText, locales []string) (string, MenuItemText, bool) {
+	if len(translations) == 0 {
+		return "", MenuItemText{}, false
+	}
+	for _, locale := range locales {
+		for tag, text := range translations {
+			if strings.EqualFold(tag, locale) && text.Name != "" {
+				return tag, text, true
+			}
+		}
+
+		language := languageOf(locale)
+		var best string
+		for tag, text := range translations {
+			if text.Name == "" || languageOf(tag) != language {
+				continue
+			}
+			// The bare language beats a regional variant; among variants
+			// the first alphabetically, so the choice does not vary.
+			if best == "" || !strings.Contains(tag, "-") || (strings.Contains(best, "-") && tag < best) {
+				best = tag
+			}
+		}
+		if best != "" {
+			return best, translations[best], true
+		}
+	}
+	return "", MenuItemText{}, false
+}
+
+// languageOf is the language subtag of tag, in lower case.
+func languageOf(tag string) string {
+	language, _, _ := strings.Cut(tag, "-")
+	return strings.ToLower(language)
+}
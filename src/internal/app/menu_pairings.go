@@ -0,0 +1,378 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"myproject/src/clock"
+)
+
+// Menu cross-sell. A restaurant can name, per item, the items that go well
+// with it. The pairings live in Redis beside the menu and are listed on the
+// item in GET /menu; after an item is added to the cart the app asks for
+// suggestions, and reports which of them the customer tapped, so restaurants
+// can see how often each suggestion is shown and taken up.
+
+var menuSuggestionEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "menu_suggestion_events_total",
+	Help: "Cross-sell suggestions partitioned by event (shown, clicked).",
+}, []string{"event"})
+
+func init() {
+	prometheus.MustRegister(menuSuggestionEvents)
+}
+
+const (
+	suggestionShown   = "shown"
+	suggestionClicked = "clicked"
+)
+
+type ItemPairingsRequest struct {
+	RestaurantID string   `json:"restaurant_id" validate:"required"`
+	GoesWellWith []string `json:"goes_well_with" validate:"max=10,dive,required"`
+}
+
+type MenuSuggestionsRequest struct {
+	RestaurantID string `json:"restaurant_id" validate:"required"`
+	// ItemIDs are the items in the cart, the one just added last.
+	ItemIDs []string `json:"item_ids" validate:"required,min=1,max=50,dive,required"`
+}
+
+type SuggestionClickRequest struct {
+	RestaurantID string `json:"restaurant_id" validate:"required"`
+	ItemID       string `json:"item_id" validate:"required"`
+	// SuggestedWith is the cart item the suggestion was shown for.
+	SuggestedWith string `json:"suggested_with" validate:"required"`
+}
+
+// MenuSuggestion is an item suggested for the cart, with the cart item that
+// led to it.
+type MenuSuggestion struct {
+	MenuItem
+	SuggestedWith string `json:"suggested_with"`
+}
+
+// SuggestionStats is how one suggested item fared on one day.
+type SuggestionStats struct {
+	ItemID       string  `json:"item_id"`
+	Shown        int64   `json:"shown"`
+	Clicked      int64   `json:"clicked"`
+	ClickThrough float64 `json:"click_through"`
+}
+
+func menuPairingsKey(restaurantID string) string {
+	return "menu:" + restaurantID + ":pairings"
+}
+
+func suggestionStatsKey(restaurantID, day string) string {
+	return "menu:" + restaurantID + ":suggestions:" + day
+}
+
+// applyPairings fills in each item's GoesWellWith, leaving out items no
+// longer on the menu or not available right now. It expects availability to
+// have been applied already.
+func applyPairings(ctx context.Context, menu *RestaurantMenu) error {
+	pairings, err := menuPairings(ctx, menu.RestaurantID)
+	if err != nil {
+		return err
+	}
+
+	available := map[string]bool{}
+	for _, item := range menu.Menu {
+		available[item.ID] = item.Available
+	}
+	for i := range menu.Menu {
+		item := &menu.Menu[i]
+		item.GoesWellWith = nil
+		for _, id := range pairings[item.ID] {
+			if available[id] {
+				item.GoesWellWith = append(item.GoesWellWith, id)
+			}
+		}
+	}
+	return nil
+}
+
+// menuPairings returns the restaurant's pairings by item ID, as set.
+func menuPairings(ctx context.Context, restaurantID string) (map[string][]string, error) {
+	raw, err := redisClient.HGetAll(ctx, menuPairingsKey(restaurantID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+
+	pairings := make(map[string][]string, len(raw))
+	for itemID, data := range raw {
+		var ids []string
+		if json.Unmarshal([]byte(data), &ids) == nil {
+			pairings[itemID] = ids
+		}
+	}
+	return pairings, nil
+}
+
+// setItemPairings serves PUT /menu/item/:id/pairings, replacing the items
+// suggested with the item. An empty list stops suggesting anything with it.
+func setItemPairings(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req ItemPairingsRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	if !actsForRestaurant(c, req.RestaurantID) && !ownsRestaurant(c, req.RestaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	itemID := c.Param("id")
+	menu, err := getMenuFromCache(ctx, req.RestaurantID)
+	if err == errMenuNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Restaurant not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant menu"})
+	}
+
+	var item *MenuItem
+	onMenu := map[string]bool{}
+	for i := range menu.Menu {
+		onMenu[menu.Menu[i].ID] = true
+		if menu.Menu[i].ID == itemID {
+			item = &menu.Menu[i]
+		}
+	}
+	if item == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Menu item not found"})
+	}
+
+	var pairings []string
+	for _, id := range req.GoesWellWith {
+		if id == itemID {
+			return validationFailed(c, "goes_well_with", "cannot include the item itself")
+		}
+		if !onMenu[id] {
+			return validationFailed(c, "goes_well_with", "has unknown menu item "+id)
+		}
+		if !slices.Contains(pairings, id) {
+			pairings = append(pairings, id)
+		}
+	}
+
+	if len(pairings) == 0 {
+		err = redisClient.HDel(ctx, menuPairingsKey(req.RestaurantID), itemID).Err()
+	} else {
+		pairingsJSON, _ := json.Marshal(pairings)
+		err = redisClient.HSet(ctx, menuPairingsKey(req.RestaurantID), itemID, pairingsJSON).Err()
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store pairings"})
+	}
+
+	requestLogger(c).Info("menu pairings set", "restaurant_id", req.RestaurantID, "menu_id", itemID, "goes_well_with", pairings)
+	item.GoesWellWith = pairings
+	return c.JSON(http.StatusOK, item)
+}
+
+// suggestMenuItems serves POST /menu/suggestions: the items that go well
+// with what is in the cart, for the app to offer after an item is added.
+// Pairings of the item added last come first. Items already in the cart or
+// not available are left out, and at most MenuSuggestionLimit are returned.
+func suggestMenuItems(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req MenuSuggestionsRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	menu, err := getMenuFromCache(ctx, req.RestaurantID)
+	if err == errMenuNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Restaurant not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant menu"})
+	}
+	err = applyAvailability(ctx, redisClient, &menu)
+	if err == nil {
+		err = applyPairings(ctx, &menu)
+	}
+	if err == nil {
+		err = applyMenuImages(ctx, redisClient, &menu)
+	}
+	if err != nil {
+		requestLogger(c).Error("error fetching menu pairings", "restaurant_id", req.RestaurantID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch suggestions"})
+	}
+
+	items := make(map[string]MenuItem, len(menu.Menu))
+	for _, item := range menu.Menu {
+		items[item.ID] = item
+	}
+	inCart := map[string]bool{}
+	for _, id := range req.ItemIDs {
+		inCart[id] = true
+	}
+
+	// Where suggestions are not rolled out the app is told there are none.
+	limit := appConfig.MenuSuggestionLimit
+	if !featureEnabled(flagMenuSuggestions, req.RestaurantID) {
+		limit = 0
+	}
+	suggestions := []MenuSuggestion{}
+	for i := len(req.ItemIDs) - 1; i >= 0 && len(suggestions) < limit; i-- {
+		for _, id := range items[req.ItemIDs[i]].GoesWellWith {
+			if inCart[id] {
+				continue
+			}
+			inCart[id] = true
+			suggested := items[id]
+			suggested.GoesWellWith = nil
+			suggestions = append(suggestions, MenuSuggestion{MenuItem: suggested, SuggestedWith: req.ItemIDs[i]})
+			if len(suggestions) == limit {
+				break
+			}
+		}
+	}
+
+	shown := make([]string, len(suggestions))
+	for i, suggestion := range suggestions {
+		shown[i] = suggestion.ID
+	}
+	recordSuggestionEvent(c, req.RestaurantID, suggestionShown, shown...)
+	// The app asks as each item is added, so asking with one item in the
+	// cart is the cart being started.
+	if len(req.ItemIDs) == 1 {
+		emitAnalytics(analyticsWriter, AnalyticsEvent{Type: analyticsCartStarted, RestaurantID: req.RestaurantID})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"restaurant_id": req.RestaurantID,
+		"suggestions":   suggestions,
+	})
+}
+
+// recordSuggestionClick serves POST /menu/suggestions/click, reporting that
+// the customer tapped a suggested item.
+func recordSuggestionClick(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req SuggestionClickRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	pairings, err := menuPairings(ctx, req.RestaurantID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to record click"})
+	}
+	if !slices.Contains(pairings[req.SuggestedWith], req.ItemID) {
+		return validationFailed(c, "item_id", "is not suggested with "+req.SuggestedWith)
+	}
+
+	recordSuggestionEvent(c, req.RestaurantID, suggestionClicked, req.ItemID)
+	requestLogger(c).Info("menu suggestion clicked", "restaurant_id", req.RestaurantID, "menu_id", req.ItemID, "suggested_with", req.SuggestedWith)
+	return c.JSON(http.StatusOK, map[string]string{"status": "recorded"})
+}
+
+// recordSuggestionEvent counts event for each item in the restaurant's
+// suggestion stats for today. Like recordDailyStat, failures are logged
+// rather than returned so analytics never fail the request.
+func recordSuggestionEvent(c echo.Context, restaurantID, event string, itemIDs ...string) {
+	ctx := c.Request().Context()
+
+	if len(itemIDs) == 0 {
+		return
+	}
+	menuSuggestionEvents.WithLabelValues(event).Add(float64(len(itemIDs)))
+
+	key := suggestionStatsKey(restaurantID, clock.Now().UTC().Format("2006-01-02"))
+	pipe := redisClient.TxPipeline()
+	for _, id := range itemIDs {
+		pipe.HIncrBy(ctx, key, id+":"+event, 1)
+	}
+	pipe.Expire(ctx, key, dailyStatRetention)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		requestLogger(c).Error("error recording suggestion event", "restaurant_id", restaurantID, "event", event, "error", err)
+	}
+}
+
+// getSuggestionStats serves GET /restaurant/:id/menu/suggestions/stats: how
+// often each item was suggested and clicked on a day, today by default.
+// Stats are kept for a week.
+func getSuggestionStats(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	if !actsForRestaurant(c, restaurantID) && !ownsRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	day := clock.Now().UTC().Format("2006-01-02")
+	if raw := c.QueryParam("date"); raw != "" {
+		_, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return validationFailed(c, "date", "must be a date (YYYY-MM-DD)")
+		}
+		day = raw
+	}
+
+	counts, err := redisClient.HGetAll(ctx, suggestionStatsKey(restaurantID, day)).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch suggestion stats"})
+	}
+
+	byItem := map[string]*SuggestionStats{}
+	for field, raw := range counts {
+		itemID, event, ok := cutLast(field, ":")
+		if !ok {
+			continue
+		}
+		count, _ := strconv.ParseInt(raw, 10, 64)
+		stats := byItem[itemID]
+		if stats == nil {
+			stats = &SuggestionStats{ItemID: itemID}
+			byItem[itemID] = stats
+		}
+		switch event {
+		case suggestionShown:
+			stats.Shown = count
+		case suggestionClicked:
+			stats.Clicked = count
+		}
+	}
+
+	items := make([]SuggestionStats, 0, len(byItem))
+	for _, stats := range byItem {
+		if stats.Shown > 0 {
+			stats.ClickThrough = float64(stats.Clicked) / float64(stats.Shown)
+		}
+		items = append(items, *stats)
+	}
+	slices.SortFunc(items, func(a, b SuggestionStats) int {
+		return cmp.Or(cmp.Compare(b.Shown, a.Shown), strings.Compare(a.ItemID, b.ItemID))
+	})
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"restaurant_id": restaurantID,
+		"date":          day,
+		"items":         items,
+	})
+}
+
+// cutLast is strings.Cut on the last sep, as item IDs may contain it.
+func cutLast(s, sep string) (before, after string, found bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
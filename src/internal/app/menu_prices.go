@@ -0,0 +1,356 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+// Menu prices. menu.json holds the prices a restaurant launched with; prices
+// changed since are published to Redis and override the file. Changes larger
+// than MenuPriceApprovalThreshold wait as pending changes until an owner
+// other than the requester approves them.
+
+const (
+	priceChangePending    = "pending"
+	priceChangeApproved   = "approved"
+	priceChangeRejected   = "rejected"
+	priceChangeSuperseded = "superseded"
+)
+
+var errPriceChangeNotFound = errors.New("price change not found")
+
+// decidePriceChange settles a pending change only if it is still the item's
+// pending change, publishing the price when it is approved.
+//
+// KEYS[1] pending hash, KEYS[2] price hash, KEYS[3] change record; ARGV[1]
+// menu item ID, ARGV[2] change ID, ARGV[3] updated record, ARGV[4] price to
+// publish or "" to publish nothing.
+var decidePriceChange = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+if ARGV[4] ~= '' then
+	redis.call('HSET', KEYS[2], ARGV[1], ARGV[4])
+end
+redis.call('SET', KEYS[3], ARGV[3])
+return 1
+`)
+
+// PriceChange is a requested price held for review. ChangePercent is 0 when
+// the item was free before.
+type PriceChange struct {
+	ID            string    `json:"id"`
+	RestaurantID  string    `json:"restaurant_id"`
+	MenuID        string    `json:"menu_id"`
+	ItemName      string    `json:"item_name"`
+	OldPrice      float64   `json:"old_price"`
+	NewPrice      float64   `json:"new_price"`
+	ChangePercent float64   `json:"change_percent"`
+	Status        string    `json:"status"`
+	RequestedBy   string    `json:"requested_by"`
+	ReviewedBy    string    `json:"reviewed_by,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+	CreatedAt     Timestamp `json:"created_at"`
+	UpdatedAt     Timestamp `json:"updated_at"`
+}
+
+type PriceChangeRequest struct {
+	RestaurantID string  `json:"restaurant_id" validate:"required"`
+	Price        float64 `json:"price" validate:"required,gt=0"`
+}
+
+type PriceChangeDecision struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+func menuPricesKey(restaurantID string) string {
+	return "menu:" + restaurantID + ":prices"
+}
+
+// menuPendingPricesKey maps menu item IDs to the ID of their pending change;
+// an item has at most one.
+func menuPendingPricesKey(restaurantID string) string {
+	return "menu:" + restaurantID + ":price_changes"
+}
+
+func priceChangeKey(changeID string) string {
+	return "price_change:" + changeID
+}
+
+// applyPublishedPrices replaces file prices with those published since.
+func applyPublishedPrices(ctx context.Context, menu *RestaurantMenu) error {
+	prices, err := redisClient.HGetAll(ctx, menuPricesKey(menu.RestaurantID)).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	for i := range menu.Menu {
+		item := &menu.Menu[i]
+		if raw, ok := prices[item.ID]; ok {
+			if price, err := strconv.ParseFloat(raw, 64); err == nil {
+				item.Price = price
+			}
+		}
+	}
+	return nil
+}
+
+func getPriceChange(ctx context.Context, changeID string) (PriceChange, error) {
+	data, err := redisClient.Get(ctx, priceChangeKey(changeID)).Result()
+	if err == redis.Nil {
+		return PriceChange{}, errPriceChangeNotFound
+	} else if err != nil {
+		return PriceChange{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	var change PriceChange
+	err = json.Unmarshal([]byte(data), &change)
+	if err != nil {
+		return PriceChange{}, fmt.Errorf("failed to parse price change: %v", err)
+	}
+	return change, nil
+}
+
+// priceChangePercent is how far newPrice is from oldPrice, in percent of
+// oldPrice. Pricing a free item is always treated as a large change.
+func priceChangePercent(oldPrice, newPrice float64) float64 {
+	if oldPrice == 0 {
+		return math.Inf(1)
+	}
+	return math.Round(math.Abs(newPrice-oldPrice)/oldPrice*10000) / 100
+}
+
+// changeMenuPrice serves PUT /menu/item/:id/price. Small changes are
+// published at once; larger ones are held for an owner's approval and the
+// restaurant's owners are notified.
+func changeMenuPrice(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req PriceChangeRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	if !actsForRestaurant(c, req.RestaurantID) && !ownsRestaurant(c, req.RestaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	itemID := c.Param("id")
+	menu, err := getMenuFromCache(ctx, req.RestaurantID)
+	if err == errMenuNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Restaurant not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant menu"})
+	}
+
+	var item *MenuItem
+	for i := range menu.Menu {
+		if menu.Menu[i].ID == itemID {
+			item = &menu.Menu[i]
+			break
+		}
+	}
+	if item == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Menu item not found"})
+	}
+
+	logger := requestLogger(c).With("restaurant_id", req.RestaurantID, "menu_id", itemID)
+	newPrice := roundMoney(req.Price)
+	percent := priceChangePercent(item.Price, newPrice)
+
+	if percent <= appConfig.MenuPriceApprovalThreshold {
+		err := redisClient.HSet(ctx, menuPricesKey(req.RestaurantID), itemID, newPrice).Err()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to publish price"})
+		}
+		logger.Info("menu price published", "old_price", item.Price, "new_price", newPrice)
+		item.Price = newPrice
+		return c.JSON(http.StatusOK, map[string]interface{}{"status": "published", "item": item})
+	}
+
+	id, err := idGenerator.NewID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create price change"})
+	}
+	change := PriceChange{
+		ID:            id,
+		RestaurantID:  req.RestaurantID,
+		MenuID:        itemID,
+		ItemName:      item.Name,
+		OldPrice:      item.Price,
+		NewPrice:      newPrice,
+		ChangePercent: percent,
+		Status:        priceChangePending,
+		RequestedBy:   authClaims(c).Subject,
+	}
+	if math.IsInf(percent, 1) {
+		change.ChangePercent = 0
+	}
+	touch(&change.CreatedAt, &change.UpdatedAt)
+
+	previousID, err := storePendingPriceChange(ctx, change)
+	if err != nil {
+		logger.Error("error storing price change", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create price change"})
+	}
+	if previousID != "" {
+		supersedePriceChange(ctx, previousID, change.ID)
+	}
+
+	_, err = dispatchNotification(c.Request().Context(), redisClient, Notification{
+		RecipientType: roleOwner,
+		RecipientID:   req.RestaurantID,
+		Template:      "price_change_pending",
+		Vars: map[string]string{
+			"change_id": change.ID,
+			"item":      change.ItemName,
+			"old_price": fmt.Sprintf("%.2f", change.OldPrice),
+			"new_price": fmt.Sprintf("%.2f", change.NewPrice),
+			"change":    describePriceChange(change),
+		},
+	})
+	if err != nil {
+		logger.Warn("error notifying owners of price change", "change_id", change.ID, "error", err)
+	}
+
+	logger.Info("menu price change awaiting approval", "change_id", change.ID, "old_price", change.OldPrice, "new_price", change.NewPrice)
+	return c.JSON(http.StatusAccepted, change)
+}
+
+func describePriceChange(change PriceChange) string {
+	if change.OldPrice == 0 {
+		return "previously free"
+	}
+	return fmt.Sprintf("%+.2f%%", (change.NewPrice-change.OldPrice)/change.OldPrice*100)
+}
+
+// storePendingPriceChange saves change as its item's pending change and
+// returns the ID of the one it replaces, if any.
+func storePendingPriceChange(ctx context.Context, change PriceChange) (string, error) {
+	changeJSON, _ := json.Marshal(change)
+	pendingKey := menuPendingPricesKey(change.RestaurantID)
+
+	pipe := redisClient.TxPipeline()
+	previousCmd := pipe.HGet(ctx, pendingKey, change.MenuID)
+	pipe.Set(ctx, priceChangeKey(change.ID), changeJSON, 0)
+	pipe.HSet(ctx, pendingKey, change.MenuID, change.ID)
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return "", fmt.Errorf("redis error: %v", err)
+	}
+	return previousCmd.Val(), nil
+}
+
+// supersedePriceChange marks a pending change replaced by a newer request for
+// the same item. The pending hash already points at the newer change.
+func supersedePriceChange(ctx context.Context, changeID, replacedBy string) {
+	change, err := getPriceChange(ctx, changeID)
+	if err != nil {
+		return
+	}
+	change.Status = priceChangeSuperseded
+	change.Reason = "replaced by change " + replacedBy
+	touch(&change.CreatedAt, &change.UpdatedAt)
+
+	changeJSON, _ := json.Marshal(change)
+	redisClient.Set(ctx, priceChangeKey(change.ID), changeJSON, 0)
+}
+
+// listPriceChanges serves GET /restaurant/:id/price-changes: the pending
+// changes of a restaurant, oldest first.
+func listPriceChanges(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	if !actsForRestaurant(c, restaurantID) && !ownsRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	pending, err := redisClient.HGetAll(ctx, menuPendingPricesKey(restaurantID)).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch price changes"})
+	}
+
+	changes := make([]PriceChange, 0, len(pending))
+	for _, changeID := range pending {
+		change, err := getPriceChange(ctx, changeID)
+		if err != nil {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].CreatedAt.Before(changes[j].CreatedAt.Time) })
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"price_changes": changes})
+}
+
+func approvePriceChange(c echo.Context) error {
+	return reviewPriceChange(c, priceChangeApproved)
+}
+
+func rejectPriceChange(c echo.Context) error {
+	return reviewPriceChange(c, priceChangeRejected)
+}
+
+// reviewPriceChange settles a pending change as approved or rejected. Only
+// an owner of the restaurant may do so, and not the one who requested it.
+func reviewPriceChange(c echo.Context, status string) error {
+	ctx := c.Request().Context()
+
+	var decision PriceChangeDecision
+	if err := bindAndValidate(c, &decision); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	restaurantID := c.Param("id")
+	if !ownsRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Only an owner of this restaurant can review price changes"})
+	}
+
+	change, err := getPriceChange(ctx, c.Param("changeId"))
+	if err == errPriceChangeNotFound || (err == nil && change.RestaurantID != restaurantID) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Price change not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch price change"})
+	}
+	if change.Status != priceChangePending {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Price change is already " + change.Status})
+	}
+
+	reviewer := authClaims(c).Subject
+	if reviewer == "" || reviewer == change.RequestedBy {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Price changes must be reviewed by someone other than the requester"})
+	}
+
+	change.Status = status
+	change.ReviewedBy = reviewer
+	change.Reason = decision.Reason
+	touch(&change.CreatedAt, &change.UpdatedAt)
+
+	publish := ""
+	if status == priceChangeApproved {
+		publish = strconv.FormatFloat(change.NewPrice, 'f', -1, 64)
+	}
+	changeJSON, _ := json.Marshal(change)
+	keys := []string{menuPendingPricesKey(restaurantID), menuPricesKey(restaurantID), priceChangeKey(change.ID)}
+	decided, err := decidePriceChange.Run(ctx, redisClient, keys, change.MenuID, change.ID, changeJSON, publish).Int()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to review price change"})
+	}
+	if decided == 0 {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Price change is no longer pending"})
+	}
+
+	requestLogger(c).Info("menu price change reviewed", "change_id", change.ID, "restaurant_id", restaurantID, "menu_id", change.MenuID, "status", status)
+	return c.JSON(http.StatusOK, change)
+}
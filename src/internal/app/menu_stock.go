@@ -0,0 +1,256 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+// Menu item availability. The menu file says what a restaurant sells; what
+// it can sell right now lives in Redis beside it: items switched off by the
+// restaurant, and a stock count for items it chose to track. Items without a
+// count are unlimited.
+
+const restockMarkerTTL = 7 * 24 * time.Hour
+
+// reserveStock checks every item of an order and, only if all of them can be
+// served, takes the quantities off the tracked counts, so two orders can
+// never both get the last portion.
+//
+// KEYS[1] stock hash, KEYS[2] unavailable set; ARGV item ID and quantity
+// pairs. Returns {"ok"} or {reason, item ID, remaining}.
+var reserveStock = redis.NewScript(`
+for i = 1, #ARGV, 2 do
+	if redis.call('SISMEMBER', KEYS[2], ARGV[i]) == 1 then
+		return {'unavailable', ARGV[i], 0}
+	end
+	local stock = redis.call('HGET', KEYS[1], ARGV[i])
+	if stock and tonumber(stock) < tonumber(ARGV[i + 1]) then
+		return {'insufficient', ARGV[i], tonumber(stock)}
+	end
+end
+for i = 1, #ARGV, 2 do
+	if redis.call('HEXISTS', KEYS[1], ARGV[i]) == 1 then
+		redis.call('HINCRBY', KEYS[1], ARGV[i], -tonumber(ARGV[i + 1]))
+	end
+end
+return {'ok'}
+`)
+
+// returnStock puts quantities back on tracked counts. With a second key it
+// runs at most once per marker, so a retried event cannot restock twice.
+//
+// KEYS[1] stock hash, optional KEYS[2] marker; ARGV[1] marker TTL in
+// seconds, then item ID and quantity pairs.
+var returnStock = redis.NewScript(`
+if #KEYS == 2 and not redis.call('SET', KEYS[2], 1, 'NX', 'EX', ARGV[1]) then
+	return 0
+end
+for i = 2, #ARGV, 2 do
+	if redis.call('HEXISTS', KEYS[1], ARGV[i]) == 1 then
+		redis.call('HINCRBY', KEYS[1], ARGV[i], tonumber(ARGV[i + 1]))
+	end
+end
+return 1
+`)
+
+// stockError reports an order item that cannot be served.
+type stockError struct {
+	MenuID    string
+	Reason    string
+	Remaining int
+}
+
+func (e *stockError) Error() string {
+	if e.Reason == "insufficient" {
+		return fmt.Sprintf("only %d left of menu item %s", e.Remaining, e.MenuID)
+	}
+	return fmt.Sprintf("menu item %s is unavailable", e.MenuID)
+}
+
+type ItemAvailabilityRequest struct {
+	RestaurantID string `json:"restaurant_id" validate:"required"`
+	Available    *bool  `json:"available"`
+	Quantity     *int   `json:"quantity" validate:"omitempty,gte=0"`
+	// Untracked stops counting stock for the item.
+	Untracked bool `json:"untracked"`
+}
+
+func menuStockKey(restaurantID string) string {
+	return "menu:" + restaurantID + ":stock"
+}
+
+func menuUnavailableKey(restaurantID string) string {
+	return "menu:" + restaurantID + ":unavailable"
+}
+
+func orderRestockedKey(orderID string) string {
+	return "order:" + orderID + ":restocked"
+}
+
+// applyAvailability fills in each item's live Available and Quantity.
+func applyAvailability(ctx context.Context, rdb redis.Cmdable, menu *RestaurantMenu) error {
+	pipe := rdb.Pipeline()
+	stockCmd := pipe.HGetAll(ctx, menuStockKey(menu.RestaurantID))
+	unavailableCmd := pipe.SMembers(ctx, menuUnavailableKey(menu.RestaurantID))
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	unavailable := map[string]bool{}
+	for _, id := range unavailableCmd.Val() {
+		unavailable[id] = true
+	}
+	stock := stockCmd.Val()
+
+	for i := range menu.Menu {
+		item := &menu.Menu[i]
+		item.Available = !unavailable[item.ID]
+		item.Quantity = nil
+		if count, ok := stock[item.ID]; ok {
+			quantity, _ := strconv.Atoi(count)
+			item.Quantity = &quantity
+			item.Available = item.Available && quantity > 0
+		}
+	}
+	return nil
+}
+
+// orderQuantities totals the quantity ordered per menu item as the flat ID
+// and quantity pairs the stock scripts take.
+func orderQuantities(items []OrderItem) []interface{} {
+	totals := map[string]int{}
+	var ids []string
+	for _, item := range items {
+		if _, ok := totals[item.MenuID]; !ok {
+			ids = append(ids, item.MenuID)
+		}
+		totals[item.MenuID] += item.Quantity
+	}
+
+	args := make([]interface{}, 0, 2*len(ids))
+	for _, id := range ids {
+		args = append(args, id, totals[id])
+	}
+	return args
+}
+
+// reserveOrderStock takes the order's items out of stock, or returns a
+// *stockError naming the first item that cannot be served.
+func reserveOrderStock(ctx context.Context, rdb redis.Cmdable, order Order) error {
+	keys := []string{menuStockKey(order.RestaurantID), menuUnavailableKey(order.RestaurantID)}
+	result, err := reserveStock.Run(ctx, rdb, keys, orderQuantities(order.Items)...).Slice()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	if result[0] == "ok" {
+		return nil
+	}
+	remaining, _ := result[2].(int64)
+	return &stockError{MenuID: result[1].(string), Reason: result[0].(string), Remaining: int(remaining)}
+}
+
+// releaseOrderStock hands back stock taken for an order that was never
+// stored.
+func releaseOrderStock(ctx context.Context, rdb redis.Cmdable, order Order) error {
+	args := append([]interface{}{0}, orderQuantities(order.Items)...)
+	err := returnStock.Run(ctx, rdb, []string{menuStockKey(order.RestaurantID)}, args...).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// restockOrder handles orders that end without being delivered: their items
+// go back on sale.
+func restockOrder(ctx context.Context, event OrderEvent) error {
+	order, err := getOrder(ctx, event.OrderID)
+	if err == errOrderNotFound {
+		return permanent(err)
+	} else if err != nil {
+		return err
+	}
+
+	keys := []string{menuStockKey(order.RestaurantID), orderRestockedKey(order.OrderID)}
+	args := append([]interface{}{int(restockMarkerTTL.Seconds())}, orderQuantities(order.Items)...)
+	err = returnStock.Run(ctx, redisClient, keys, args...).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// setItemAvailability serves PATCH /menu/item/:id/availability, letting a
+// restaurant switch an item on or off and set or stop tracking its stock.
+func setItemAvailability(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req ItemAvailabilityRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	if req.Available == nil && req.Quantity == nil && !req.Untracked {
+		return validationFailed(c, "available", "one of available, quantity or untracked is required")
+	}
+	if req.Quantity != nil && req.Untracked {
+		return validationFailed(c, "untracked", "cannot be combined with quantity")
+	}
+
+	if !actsForRestaurant(c, req.RestaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	itemID := c.Param("id")
+	menu, err := getMenuFromCache(ctx, req.RestaurantID)
+	if err == errMenuNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Restaurant not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant menu"})
+	}
+
+	index := -1
+	for i, item := range menu.Menu {
+		if item.ID == itemID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Menu item not found"})
+	}
+
+	pipe := redisClient.TxPipeline()
+	if req.Available != nil {
+		if *req.Available {
+			pipe.SRem(ctx, menuUnavailableKey(req.RestaurantID), itemID)
+		} else {
+			pipe.SAdd(ctx, menuUnavailableKey(req.RestaurantID), itemID)
+		}
+	}
+	if req.Quantity != nil {
+		pipe.HSet(ctx, menuStockKey(req.RestaurantID), itemID, *req.Quantity)
+	}
+	if req.Untracked {
+		pipe.HDel(ctx, menuStockKey(req.RestaurantID), itemID)
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update item availability"})
+	}
+
+	err = applyAvailability(ctx, redisClient, &menu)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch item availability"})
+	}
+
+	requestLogger(c).Info("menu item availability updated", "restaurant_id", req.RestaurantID, "menu_id", itemID, "available", menu.Menu[index].Available, "quantity", menu.Menu[index].Quantity)
+	return c.JSON(http.StatusOK, menu.Menu[index])
+}
@@ -0,0 +1,186 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+
+	"myproject/src/rng"
+)
+
+// menuFile holds every restaurant's menu, unless the store keeps them; see
+// repository.go. Menus are cached in Redis one per restaurant under
+// menu:{id}, clear of the other keys sharing the keyspace, and trimmed least
+// recently used first when over their memory budget.
+const menuFile = "menu.json"
+
+// menuLoads coalesces concurrent misses for a restaurant's menu into a
+// single load, so a popular menu expiring does not send every request
+// waiting on it to the store and back to Redis.
+var menuLoads singleflight.Group
+
+var (
+	errMenuNotFound = errors.New("menu not found")
+	// errMenuCacheDown means Redis could not be reached for the menu.
+	errMenuCacheDown = errors.New("menu cache unavailable")
+)
+
+// menuCatalog is menu.json indexed by restaurant ID. The file lists menus
+// under "menus"; a file holding a single menu object, the original format,
+// is still accepted.
+type menuCatalog map[string]RestaurantMenu
+
+func (m *menuCatalog) UnmarshalJSON(data []byte) error {
+	var file struct {
+		Menus []RestaurantMenu `json:"menus"`
+		RestaurantMenu
+	}
+	err := json.Unmarshal(data, &file)
+	if err != nil {
+		return err
+	}
+	if file.Menus == nil && file.RestaurantID != "" {
+		file.Menus = []RestaurantMenu{file.RestaurantMenu}
+	}
+
+	catalog := make(menuCatalog, len(file.Menus))
+	for _, menu := range file.Menus {
+		if menu.RestaurantID == "" {
+			return errors.New("menu without restaurant_id")
+		}
+		if _, ok := catalog[menu.RestaurantID]; ok {
+			return fmt.Errorf("duplicate menu for restaurant %s", menu.RestaurantID)
+		}
+		catalog[menu.RestaurantID] = menu
+	}
+	*m = catalog
+	return nil
+}
+
+func (m *menuCatalog) stampModTime(modTime time.Time) {
+	for id, menu := range *m {
+		defaultTimestamps(&menu.CreatedAt, &menu.UpdatedAt, modTime)
+		(*m)[id] = menu
+	}
+}
+
+func menuKey(restaurantID string) string {
+	return "menu:" + restaurantID
+}
+
+func fetchMenuFromJSON(restaurantID string) (RestaurantMenu, error) {
+	catalog, err := loadJSONFile[menuCatalog](menuFile)
+	if err != nil {
+		return RestaurantMenu{}, fmt.Errorf("failed to load menu file: %v", err)
+	}
+
+	menu, ok := catalog[restaurantID]
+	if !ok {
+		return RestaurantMenu{}, errMenuNotFound
+	}
+	return menu, nil
+}
+
+// menuCacheTTL is how long to cache the restaurant's menu this time.
+func menuCacheTTL(restaurantID string) time.Duration {
+	ttl, ok := appConfig.MenuCacheTTLs[restaurantID]
+	if !ok {
+		ttl = appConfig.MenuCacheTTL
+	}
+	jitter := min(max(appConfig.MenuCacheTTLJitter, 0), 1)
+	return time.Duration(float64(ttl) * (1 + jitter*(2*rng.Float64()-1)))
+}
+
+// getMenuFromCache returns the restaurant's menu with its published prices.
+func getMenuFromCache(ctx context.Context, restaurantID string) (RestaurantMenu, error) {
+	menu, err := cachedMenu(ctx, restaurantID)
+	if err != nil {
+		return RestaurantMenu{}, err
+	}
+	return withPublishedPrices(ctx, menu)
+}
+
+// getMenuUncached is getMenuFromCache reading the menu from its source and
+// leaving the cache alone, for debugging the cache.
+func getMenuUncached(ctx context.Context, restaurantID string) (RestaurantMenu, error) {
+	menu, err := loadMenu(ctx, restaurantID)
+	if err != nil {
+		return RestaurantMenu{}, err
+	}
+	return withPublishedPrices(ctx, menu)
+}
+
+func withPublishedPrices(ctx context.Context, menu RestaurantMenu) (RestaurantMenu, error) {
+
+	// Prices are not cached with the menu so a published price applies
+	// straight away.
+	err := applyPublishedPrices(ctx, &menu)
+	if err != nil {
+		return RestaurantMenu{}, err
+	}
+	return menu, nil
+}
+
+func cachedMenu(ctx context.Context, restaurantID string) (RestaurantMenu, error) {
+	menuData, err := redisClient.Get(ctx, menuKey(restaurantID)).Result()
+	if err == redis.Nil {
+		menuCacheRequests.WithLabelValues("miss").Inc()
+		loaded, err, _ := menuLoads.Do(restaurantID, func() (interface{}, error) {
+			return fetchMenuFromFile(ctx, restaurantID)
+		})
+		if err != nil {
+			return RestaurantMenu{}, err
+		}
+		// Callers sharing the load each get their own items to price.
+		menu := loaded.(RestaurantMenu)
+		menu.Menu = slices.Clone(menu.Menu)
+		return menu, nil
+	} else if redisFailed(err) {
+		menuCacheRequests.WithLabelValues("error").Inc()
+		return RestaurantMenu{}, fmt.Errorf("%w: %v", errMenuCacheDown, err)
+	} else if err != nil {
+		menuCacheRequests.WithLabelValues("error").Inc()
+		return RestaurantMenu{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	menuCacheRequests.WithLabelValues("hit").Inc()
+	touchMenu(ctx, restaurantID)
+
+	var menu RestaurantMenu
+	err = json.Unmarshal([]byte(menuData), &menu)
+	if err != nil {
+		return RestaurantMenu{}, fmt.Errorf("failed to parse cached menu: %v", err)
+	}
+
+	return menu, nil
+}
+
+// loadMenu reads the restaurant's menu from the store, or the menu cloned or
+// published to it if there is one.
+func loadMenu(ctx context.Context, restaurantID string) (RestaurantMenu, error) {
+	menu, err := storedMenu(ctx, restaurantID)
+	if err == errMenuNotFound {
+		menu, err = repositories.Menus.Menu(ctx, restaurantID)
+	}
+	return menu, err
+}
+
+// fetchMenuFromFile loads the restaurant's menu and caches it.
+func fetchMenuFromFile(ctx context.Context, restaurantID string) (RestaurantMenu, error) {
+	menu, err := loadMenu(ctx, restaurantID)
+	if err != nil {
+		return RestaurantMenu{}, err
+	}
+
+	menuJSON, _ := json.Marshal(menu)
+	redisClient.Set(ctx, menuKey(restaurantID), menuJSON, menuCacheTTL(restaurantID))
+	touchMenu(ctx, restaurantID)
+
+	return menu, nil
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	menuCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "menu_cache_requests_total",
+		Help: "Menu cache lookups partitioned by result (hit, miss, error, fallback).",
+	}, []string{"result"})
+
+	kafkaPublishTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_publish_total",
+		Help: "Kafka publish attempts partitioned by topic, event and result.",
+	}, []string{"topic", "event", "result"})
+
+	kafkaPublishDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kafka_publish_duration_seconds",
+		Help:    "Latency of Kafka publish calls.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"topic", "event"})
+
+	kafkaConsumerLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kafka_consumer_lag",
+		Help: "Messages between the last consumed offset and the partition high watermark.",
+	}, []string{"topic", "partition"})
+
+	orderEventsConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "order_events_consumed_total",
+		Help: "Order events consumed partitioned by type and result (success, skipped, held, duplicate, dead_lettered).",
+	}, []string{"type", "result"})
+
+	orderEventsDecoded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "order_events_decoded_total",
+		Help: "Order events read off Kafka partitioned by envelope schema version (0 for none) and result (success, invalid).",
+	}, []string{"schema_version", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(menuCacheRequests, kafkaPublishTotal, kafkaPublishDuration, kafkaConsumerLag, orderEventsConsumed, orderEventsDecoded)
+}
+
+// publishMessage writes a single message stamped with the build headers and
+// the request ID in ctx, and records publish metrics under the given event
+// name.
+func publishMessage(ctx context.Context, w messageWriter, event string, msg kafka.Message) error {
+	msg.Headers = withRequestIDHeader(ctx, withBuildHeaders(msg.Headers))
+	start := time.Now()
+	err := w.WriteMessages(ctx, msg)
+	kafkaPublishDuration.WithLabelValues(w.Topic(), event).Observe(time.Since(start).Seconds())
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	kafkaPublishTotal.WithLabelValues(w.Topic(), event, result).Inc()
+	return err
+}
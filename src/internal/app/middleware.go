@@ -0,0 +1,208 @@
+package app
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HTTP middleware for the API, each switched on and tuned in the config:
+//
+//   - recovery turns a panic in a handler into a 500, logged with its stack,
+//     instead of a dropped connection.
+//   - CORS lets browsers on CORSAllowOrigins call the API.
+//   - body limits refuse request bodies over BodyLimit, or UploadBodyLimit
+//     for multipart uploads, with 413.
+//   - gzip compresses responses of at least GzipMinLength bytes for clients
+//     that accept it, except on streams.
+//   - secure headers stop browsers sniffing content types or framing the
+//     API, and add HSTS and a Content-Security-Policy when configured.
+//   - request deadlines give each request RequestTimeout, except streams
+//     and exports, which run as long as the client reads. Redis calls made
+//     with the request's context keep to it; see redis_retry.go.
+//   - client IPs, which rate limits are kept by, are the connection's peer
+//     unless it is one of TrustedProxies, whose X-Forwarded-For is believed.
+
+var panicsRecovered = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "http_panics_recovered_total",
+	Help: "Panics in HTTP handlers turned into 500 responses.",
+})
+
+func init() {
+	prometheus.MustRegister(panicsRecovered)
+}
+
+// uncompressedRoutes are streamed to the client as they are produced, or
+// compress themselves.
+var uncompressedRoutes = map[string]bool{
+	"GET /order/:id/stream":              true,
+	"GET /restaurant/:id/orders/pending": true,
+	"GET /tracking/ws":                   true,
+	"GET /metrics":                       true,
+}
+
+// untimedRoutes run as long as the client keeps reading, or long-poll for
+// longer than a request may take, so have no request deadline.
+var untimedRoutes = map[string]bool{
+	"GET /order/:id/stream":              true,
+	"GET /order/:id/status":              true,
+	"GET /restaurant/:id/orders/pending": true,
+	"GET /tracking/ws":                   true,
+	"GET /admin/orders/export":           true,
+}
+
+// corsAllowHeaders are the request headers browsers may send, and
+// corsExposeHeaders the response headers scripts may read.
+var (
+	corsAllowHeaders  = []string{echo.HeaderAuthorization, echo.HeaderContentType, echo.HeaderAccept, apiKeyHeader, apiVersionHeader, "If-None-Match", headerIfMatch, headerSignature, headerSignatureKey, headerSignatureTimestamp, headerSignatureNonce}
+	corsExposeHeaders = []string{apiVersionHeader, echo.HeaderXRequestID, echo.HeaderRetryAfter, "ETag"}
+)
+
+// validateHTTPMiddleware checks the middleware config that cannot fall back
+// to a default on its own.
+func validateHTTPMiddleware() error {
+	if appConfig.GzipLevel < gzip.HuffmanOnly || appConfig.GzipLevel > gzip.BestCompression {
+		return fmt.Errorf("GZIP_LEVEL must be from %d to %d", gzip.HuffmanOnly, gzip.BestCompression)
+	}
+	if appConfig.CORSEnabled && len(appConfig.CORSAllowOrigins) == 0 {
+		return fmt.Errorf("CORS_ALLOW_ORIGINS must name at least one origin when CORS is enabled")
+	}
+	if _, err := trustedProxyRanges(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// trustedProxyRanges parses TrustedProxies, each a CIDR range or a single
+// address.
+func trustedProxyRanges() ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	for _, proxy := range appConfig.TrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an IP address or CIDR range", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			ranges = append(ranges, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipRange, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an IP address or CIDR range", proxy)
+		}
+		ranges = append(ranges, ipRange)
+	}
+	return ranges, nil
+}
+
+// clientIPExtractor finds the client's IP for c.RealIP. With no trusted
+// proxies it is the connection's peer, so a client cannot pick its own by
+// sending X-Forwarded-For. Behind proxies it is the last address in
+// X-Forwarded-For not in their ranges. Loopback and private addresses are
+// not trusted unless listed.
+func clientIPExtractor() echo.IPExtractor {
+	ranges, _ := trustedProxyRanges()
+	if len(ranges) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, ipRange := range ranges {
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// useHTTPMiddleware adds the configured middleware to e, in the order they
+// must run.
+func useHTTPMiddleware(e *echo.Echo) {
+	if appConfig.RecoverEnabled {
+		e.Use(recovery)
+	}
+	if appConfig.CORSEnabled {
+		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins:  appConfig.CORSAllowOrigins,
+			AllowHeaders:  corsAllowHeaders,
+			ExposeHeaders: corsExposeHeaders,
+			MaxAge:        int(appConfig.CORSMaxAge.Seconds()),
+		}))
+	}
+	if appConfig.SecureHeadersEnabled {
+		e.Use(middleware.SecureWithConfig(middleware.SecureConfig{
+			ContentTypeNosniff:    "nosniff",
+			XFrameOptions:         "DENY",
+			ReferrerPolicy:        "no-referrer",
+			HSTSMaxAge:            int(appConfig.HSTSMaxAge.Seconds()),
+			ContentSecurityPolicy: appConfig.ContentSecurityPolicy,
+		}))
+	}
+	e.Use(bodyLimit)
+	if appConfig.RequestTimeout > 0 {
+		e.Use(requestDeadline)
+	}
+	if appConfig.GzipEnabled {
+		e.Use(middleware.GzipWithConfig(middleware.GzipConfig{
+			Level:     appConfig.GzipLevel,
+			MinLength: appConfig.GzipMinLength,
+			Skipper: func(c echo.Context) bool {
+				return uncompressedRoutes[c.Request().Method+" "+c.Path()]
+			},
+		}))
+	}
+}
+
+// recovery answers a request whose handler panicked with a 500.
+var recovery = middleware.RecoverWithConfig(middleware.RecoverConfig{
+	LogErrorFunc: func(c echo.Context, err error, stack []byte) error {
+		panicsRecovered.Inc()
+		requestLogger(c).Error("panic in handler", "error", err, "stack", string(stack))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	},
+	// The panicking goroutine's stack is enough; the others are noise.
+	DisableStackAll:     true,
+	DisableErrorHandler: true,
+})
+
+// requestDeadline gives the request's context RequestTimeout, so what the
+// handler does with it stops once the client has most likely given up.
+func requestDeadline(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if untimedRoutes[c.Request().Method+" "+c.Path()] {
+			return next(c)
+		}
+		reqCtx, cancel := context.WithTimeout(c.Request().Context(), appConfig.RequestTimeout)
+		defer cancel()
+		c.SetRequest(c.Request().WithContext(reqCtx))
+		return next(c)
+	}
+}
+
+// bodyLimit refuses request bodies over the limit for their kind. A body
+// without a declared length is cut off at the limit, failing as malformed.
+func bodyLimit(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		limit := appConfig.BodyLimit
+		if strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+			limit = appConfig.UploadBodyLimit
+		}
+		if limit <= 0 || req.Body == nil {
+			return next(c)
+		}
+		if req.ContentLength > limit {
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("Request body is larger than %d bytes", limit)})
+		}
+		req.Body = http.MaxBytesReader(c.Response(), req.Body, limit)
+		return next(c)
+	}
+}
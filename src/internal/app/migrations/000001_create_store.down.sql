@@ -0,0 +1,5 @@
+DROP TABLE order_outbox;
+DROP TABLE orders;
+DROP TABLE menus;
+DROP TABLE riders;
+DROP TABLE restaurants;
@@ -0,0 +1,38 @@
+-- Records are kept whole, as the JSON the API serves, with the fields
+-- looked up by copied out into columns.
+
+CREATE TABLE restaurants (
+    id   text PRIMARY KEY,
+    data jsonb NOT NULL
+);
+
+CREATE TABLE riders (
+    id   text PRIMARY KEY,
+    data jsonb NOT NULL
+);
+
+CREATE TABLE menus (
+    restaurant_id text PRIMARY KEY,
+    data          jsonb NOT NULL
+);
+
+CREATE TABLE orders (
+    id          text PRIMARY KEY,
+    -- seq numbers orders as they are created, for export cursors.
+    seq         bigserial UNIQUE,
+    customer_id text NOT NULL DEFAULT '',
+    rider_id    text NOT NULL DEFAULT '',
+    status      text NOT NULL,
+    created_at  timestamptz NOT NULL,
+    data        jsonb NOT NULL
+);
+
+CREATE INDEX orders_customer_created ON orders (customer_id, created_at DESC) WHERE customer_id <> '';
+CREATE INDEX orders_rider_status ON orders (rider_id, status) WHERE rider_id <> '';
+
+-- Events waiting for the relay, written with the order change that raised
+-- them.
+CREATE TABLE order_outbox (
+    id    bigserial PRIMARY KEY,
+    event text NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP INDEX orders_restaurant_created;
+ALTER TABLE orders DROP COLUMN restaurant_id;
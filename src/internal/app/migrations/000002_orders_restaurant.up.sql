@@ -0,0 +1,8 @@
+-- Restaurants' orders are looked up by the day they were placed, for
+-- closeouts.
+
+ALTER TABLE orders ADD COLUMN restaurant_id text NOT NULL DEFAULT '';
+
+UPDATE orders SET restaurant_id = data->>'restaurant_id';
+
+CREATE INDEX orders_restaurant_created ON orders (restaurant_id, created_at);
@@ -0,0 +1,6 @@
+-- Orders are saved only in place of the version they were read at, which
+-- the upsert compares against this column.
+
+ALTER TABLE orders ADD COLUMN version bigint NOT NULL DEFAULT 0;
+
+UPDATE orders SET version = COALESCE((data->>'version')::bigint, 0);
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"time"
+
+	"myproject/src/model"
+)
+
+// The types shared with the handlers package live in model; these aliases
+// keep their names unqualified in the rest of package app.
+
+type (
+	Timestamp             = model.Timestamp
+	GeoPoint              = model.GeoPoint
+	MenuItem              = model.MenuItem
+	MenuItemText          = model.MenuItemText
+	RestaurantMenu        = model.RestaurantMenu
+	OrderItem             = model.OrderItem
+	DeliveryOptions       = model.DeliveryOptions
+	GiftDetails           = model.GiftDetails
+	Order                 = model.Order
+	TimelineEvent         = model.TimelineEvent
+	PricedItem            = model.PricedItem
+	PriceBreakdown        = model.PriceBreakdown
+	FeeSplit              = model.FeeSplit
+	Refund                = model.Refund
+	RefundItem            = model.RefundItem
+	ChecklistItem         = model.ChecklistItem
+	PickupChecklist       = model.PickupChecklist
+	ChecklistConfirmation = model.ChecklistConfirmation
+	PickupConfirmation    = model.PickupConfirmation
+	DeliveryProof         = model.DeliveryProof
+	AuthClaims            = model.AuthClaims
+	ChannelResult         = model.ChannelResult
+	Notification          = model.Notification
+	Contact               = model.Contact
+	Device                = model.Device
+
+	ModifyOrderRequest      = model.ModifyOrderRequest
+	BulkOrderRequest        = model.BulkOrderRequest
+	BulkOrderSlot           = model.BulkOrderSlot
+	BulkOrderResult         = model.BulkOrderResult
+	BulkOrderResponse       = model.BulkOrderResponse
+	AcceptOrderRequest      = model.AcceptOrderRequest
+	AcceptOrderResponse     = model.AcceptOrderResponse
+	RejectOrderRequest      = model.RejectOrderRequest
+	PickupRequest           = model.PickupRequest
+	DeliverRequest          = model.DeliverRequest
+	SendNotificationRequest = model.SendNotificationRequest
+
+	serviceError = model.ServiceError
+)
+
+const (
+	BulkOrderCreated   = model.BulkOrderCreated
+	BulkOrderRejected  = model.BulkOrderRejected
+	BulkOrderNotPlaced = model.BulkOrderNotPlaced
+	BulkOrderFailed    = model.BulkOrderFailed
+)
+
+const (
+	notificationSent    = model.NotificationSent
+	notificationPartial = model.NotificationPartial
+	notificationFailed  = model.NotificationFailed
+)
+
+var timestampNow = model.Now
+
+// defaultTimestamps fills created and updated with t where they are unset.
+func defaultTimestamps(created, updated *Timestamp, t time.Time) {
+	if created.IsZero() {
+		*created = model.NewTimestamp(t)
+	}
+	if updated.IsZero() {
+		*updated = model.NewTimestamp(t)
+	}
+}
+
+// touch sets created, if unset, and updated to now.
+func touch(created, updated *Timestamp) {
+	now := timestampNow()
+	if created.IsZero() {
+		*created = now
+	}
+	*updated = now
+}
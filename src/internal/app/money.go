@@ -0,0 +1,56 @@
+package app
+
+import (
+	"math"
+	"strings"
+)
+
+// Money. Amounts are stored, returned and sent in events as float64 in the
+// currency's major unit, as clients have always read them; they are not
+// integers. Only the arithmetic is exact: code that adds up or splits
+// amounts, as pricing, closeouts and rider earnings do, converts each to
+// minorUnits, works in whole numbers there and converts the result back.
+// A single amount that has been scaled or subtracted is put back on the
+// minor unit with roundMoney.
+
+// minorUnits is an amount in its currency's smallest unit: cents for USD,
+// satang for THB, yen for JPY.
+type minorUnits int64
+
+// currencyExponents are the currencies whose minor unit is not a
+// hundredth, keyed by lower-case ISO 4217 code.
+var currencyExponents = map[string]int{
+	"bif": 0, "clp": 0, "djf": 0, "gnf": 0, "isk": 0, "jpy": 0, "kmf": 0, "krw": 0,
+	"pyg": 0, "rwf": 0, "ugx": 0, "vnd": 0, "vuv": 0, "xaf": 0, "xof": 0, "xpf": 0,
+	"bhd": 3, "iqd": 3, "jod": 3, "kwd": 3, "lyd": 3, "omr": 3, "tnd": 3,
+}
+
+// currencyExponent is how many decimal places currency has.
+func currencyExponent(currency string) int {
+	if exponent, ok := currencyExponents[strings.ToLower(currency)]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// toMinor converts amount to currency's minor unit, rounding half away from
+// zero.
+func toMinor(amount float64, currency string) minorUnits {
+	return minorUnits(math.Round(amount * math.Pow10(currencyExponent(currency))))
+}
+
+// amount is m in currency's major unit.
+func (m minorUnits) amount(currency string) float64 {
+	return float64(m) / math.Pow10(currencyExponent(currency))
+}
+
+// times is m multiplied by factor, a rate or a percentage over 100, rounded
+// to the minor unit.
+func (m minorUnits) times(factor float64) minorUnits {
+	return minorUnits(math.Round(float64(m) * factor))
+}
+
+// roundMoney rounds amount to the minor unit of the platform's currency.
+func roundMoney(amount float64) float64 {
+	return toMinor(amount, appConfig.PaymentCurrency).amount(appConfig.PaymentCurrency)
+}
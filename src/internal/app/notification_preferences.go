@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+// NotificationPreferences are what a customer or rider chose to hear about.
+// Anything not mentioned follows the deployment's NOTIFY_CHANNELS_*: a
+// preference can turn a channel off, but not on for a recipient type it is
+// not configured for.
+type NotificationPreferences struct {
+	// Channels turns channels, such as push or email, on or off.
+	Channels map[string]bool `json:"channels,omitempty"`
+	// Notifications turns kinds of notification on or off, by template
+	// name, such as rider_arriving.
+	Notifications map[string]bool `json:"notifications,omitempty"`
+}
+
+// notificationChannels are the channels a preference can name, whether or
+// not this deployment has them set up.
+var notificationChannels = []string{"email", "push", "webhook"}
+
+func notificationPreferencesKey(recipientType, recipientID string) string {
+	return "notification_prefs:" + recipientType + ":" + recipientID
+}
+
+func getNotificationPreferences(ctx context.Context, rdb redis.Cmdable, recipientType, recipientID string) (NotificationPreferences, error) {
+	var prefs NotificationPreferences
+	data, err := rdb.Get(ctx, notificationPreferencesKey(recipientType, recipientID)).Result()
+	if err == redis.Nil {
+		return prefs, nil
+	} else if err != nil {
+		return prefs, fmt.Errorf("redis error: %v", err)
+	}
+
+	err = json.Unmarshal([]byte(data), &prefs)
+	if err != nil {
+		return prefs, fmt.Errorf("failed to parse notification preferences: %v", err)
+	}
+	return prefs, nil
+}
+
+// channelsFor returns the channels a notification from template goes out
+// on: none if the recipient turned it off, otherwise the configured channels
+// they have not turned off.
+func (p NotificationPreferences) channelsFor(template string, configured []string) []string {
+	if on, ok := p.Notifications[template]; ok && !on {
+		return nil
+	}
+
+	channels := make([]string, 0, len(configured))
+	for _, channel := range configured {
+		if on, ok := p.Channels[channel]; !ok || on {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// getMyNotificationPreferences serves GET /notification/preferences.
+func getMyNotificationPreferences(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	recipientType, recipientID := notificationRecipient(c)
+	prefs, err := getNotificationPreferences(ctx, redisClient, recipientType, recipientID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch notification preferences"})
+	}
+	return c.JSON(http.StatusOK, prefs)
+}
+
+// setMyNotificationPreferences serves PUT /notification/preferences,
+// replacing the caller's preferences.
+func setMyNotificationPreferences(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var prefs NotificationPreferences
+	if err := bindAndValidate(c, &prefs); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	for channel := range prefs.Channels {
+		if !slices.Contains(notificationChannels, channel) {
+			return validationFailed(c, "channels", "has unknown channel "+channel+"; must be one of: "+strings.Join(notificationChannels, " "))
+		}
+	}
+	for template := range prefs.Notifications {
+		if _, ok := notificationTemplates[template]; !ok {
+			return validationFailed(c, "notifications", "has unknown notification "+template)
+		}
+	}
+
+	recipientType, recipientID := notificationRecipient(c)
+	if recipientID == "" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token does not name a recipient"})
+	}
+
+	prefsJSON, _ := json.Marshal(prefs)
+	err := redisClient.Set(ctx, notificationPreferencesKey(recipientType, recipientID), prefsJSON, 0).Err()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store notification preferences"})
+	}
+
+	requestLogger(c).Info("notification preferences set", "recipient_type", recipientType, "recipient_id", recipientID)
+	return c.JSON(http.StatusOK, prefs)
+}
@@ -0,0 +1,188 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+const notificationRetryLockTTL = time.Minute
+
+var errNotificationNotFound = errors.New("notification not found")
+
+// NotificationRecord is what happened to one dispatched notification, kept
+// for NotificationRetention so admins can inspect and retry failures.
+type NotificationRecord struct {
+	Notification
+	Status    string          `json:"status"`
+	Channels  []ChannelResult `json:"channels"`
+	Attempts  int             `json:"attempts"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+func notificationKey(id string) string {
+	return "notification:" + id
+}
+
+func notificationRetryLockKey(id string) string {
+	return "notification:" + id + ":retrying"
+}
+
+// deliveryStatus is sent if every channel delivered, failed if none did and
+// partial otherwise. A recipient with no channels configured counts as sent.
+func (r NotificationRecord) deliveryStatus() string {
+	delivered := 0
+	for _, result := range r.Channels {
+		if result.Success {
+			delivered++
+		}
+	}
+	switch {
+	case delivered == len(r.Channels):
+		return notificationSent
+	case delivered == 0:
+		return notificationFailed
+	}
+	return notificationPartial
+}
+
+// retryableChannels lists the channels that failed for a reason another
+// attempt might fix.
+func (r NotificationRecord) retryableChannels() []string {
+	var channels []string
+	for _, result := range r.Channels {
+		if !result.Success && !result.Terminal {
+			channels = append(channels, result.Channel)
+		}
+	}
+	return channels
+}
+
+func saveNotificationRecord(ctx context.Context, rdb redis.Cmdable, record NotificationRecord) error {
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %v", err)
+	}
+	err = rdb.Set(ctx, notificationKey(record.ID), recordJSON, appConfig.NotificationRetention).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+func getNotificationRecord(ctx context.Context, id string) (NotificationRecord, error) {
+	data, err := redisClient.Get(ctx, notificationKey(id)).Result()
+	if err == redis.Nil {
+		return NotificationRecord{}, errNotificationNotFound
+	} else if err != nil {
+		return NotificationRecord{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	var record NotificationRecord
+	err = json.Unmarshal([]byte(data), &record)
+	if err != nil {
+		return NotificationRecord{}, fmt.Errorf("failed to parse notification: %v", err)
+	}
+	return record, nil
+}
+
+func getNotification(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	record, err := getNotificationRecord(ctx, c.Param("id"))
+	if err == errNotificationNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Notification not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch notification"})
+	}
+	return c.JSON(http.StatusOK, record)
+}
+
+// retryNotification serves POST /admin/notifications/:id/retry. The stored
+// template and variables are rendered again and resent over the channels
+// that failed, except those whose failure was terminal, such as a missing or
+// rejected address: those need the contact fixed, not another attempt.
+func retryNotification(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id := c.Param("id")
+
+	locked, err := redisClient.SetNX(ctx, notificationRetryLockKey(id), 1, notificationRetryLockTTL).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start retry"})
+	}
+	if !locked {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Retry already in progress"})
+	}
+	defer redisClient.Del(ctx, notificationRetryLockKey(id))
+
+	record, err := getNotificationRecord(ctx, id)
+	if err == errNotificationNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Notification not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch notification"})
+	}
+
+	if record.Status == notificationSent {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Notification was already sent"})
+	}
+
+	channels := record.retryableChannels()
+	if len(channels) == 0 {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error":    "Notification failed permanently on every channel",
+			"channels": record.Channels,
+		})
+	}
+
+	logger := requestLogger(c).With("notification_id", id, "order_id", record.OrderID)
+
+	n := record.Notification
+	err = renderNotification(&n)
+	if err != nil {
+		logger.Error("error rendering notification for retry", "template", n.Template, "error", err)
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": "Notification can no longer be rendered"})
+	}
+
+	contact, err := getContact(ctx, redisClient, n.RecipientType, n.RecipientID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch contact"})
+	}
+
+	retried := sendOverChannels(c.Request().Context(), channels, contact, n)
+	delivered := false
+	for _, result := range retried {
+		for i := range record.Channels {
+			if record.Channels[i].Channel == result.Channel {
+				record.Channels[i] = result
+			}
+		}
+		delivered = delivered || result.Success
+	}
+
+	record.Notification = n
+	record.Attempts++
+	record.UpdatedAt = clock.Now().UTC()
+	record.Status = record.deliveryStatus()
+
+	err = saveNotificationRecord(ctx, redisClient, record)
+	if err != nil {
+		logger.Error("error storing notification retry", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store notification"})
+	}
+
+	logger.Info("notification retried", "channels", channels, "status", record.Status, "attempts", record.Attempts)
+	if !delivered {
+		return c.JSON(http.StatusBadGateway, record)
+	}
+	return c.JSON(http.StatusOK, record)
+}
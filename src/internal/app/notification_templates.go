@@ -0,0 +1,109 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// notificationTemplate renders a notification's subject and message from its
+// variables. Notifications name a template rather than carrying text so a
+// failed one can be rendered again, with the current wording, when retried.
+type notificationTemplate struct {
+	subject *template.Template
+	message *template.Template
+}
+
+var notificationTemplates = map[string]notificationTemplate{}
+
+func registerNotificationTemplate(name, subject, message string) {
+	notificationTemplates[name] = notificationTemplate{
+		subject: template.Must(template.New(name + ".subject").Option("missingkey=error").Parse(subject)),
+		message: template.Must(template.New(name + ".message").Option("missingkey=error").Parse(message)),
+	}
+}
+
+func init() {
+	registerNotificationTemplate("order_paid",
+		"New order {{.order_ref}}",
+		"Order {{.order_ref}} has been placed for {{.total}} and is waiting for you to accept it.")
+	registerNotificationTemplate("order_accepted",
+		"Your order has been accepted",
+		"The restaurant has accepted order {{.order_ref}} and is preparing it.")
+	registerNotificationTemplate("order_running_late",
+		"Your order is running late",
+		"Order {{.order_ref}} is taking longer than the restaurant expected. We will let you know as soon as it is on its way.")
+	registerNotificationTemplate("gift_accepted",
+		"A gift is on its way to you",
+		"Someone has sent you a meal. The restaurant is preparing order {{.order_ref}} and we will let you know when it arrives.")
+	registerNotificationTemplate("gift_receipt",
+		"Receipt for your gift order {{.order_ref}}",
+		"Thank you for your gift. You paid {{.total}} for order {{.order_ref}}. We will keep the recipient posted and tell you when it has been delivered.")
+	registerNotificationTemplate("gift_delivered",
+		"Your gift has been delivered",
+		"Order {{.order_ref}} has been delivered to its recipient.")
+	registerNotificationTemplate("order_rejected",
+		"Your order was rejected",
+		"Your order {{.order_ref}} was rejected by the restaurant: {{.reason}}")
+	registerNotificationTemplate("order_refunded",
+		"Refund for order {{.order_ref}}",
+		"We have refunded {{.amount}} for order {{.order_ref}}. It may take a few days to reach your account.")
+	registerNotificationTemplate("order_timed_out",
+		"Your order was cancelled",
+		"The restaurant did not accept order {{.order_ref}} in time, so it has been cancelled and your payment will be refunded.")
+	registerNotificationTemplate("order_timed_out_unpaid",
+		"Your order was cancelled",
+		"Order {{.order_ref}} was not paid in time and has been cancelled.")
+	registerNotificationTemplate("delivery_delayed",
+		"Your order is delayed",
+		"We are still looking for a rider to bring order {{.order_ref}}. Our support team is on it.")
+	registerNotificationTemplate("rider_reassigned",
+		"We are finding you a new rider",
+		"The rider for order {{.order_ref}} could not make it to the restaurant, so we are finding another one.")
+	registerNotificationTemplate("rider_unassigned",
+		"Order {{.order_ref}} was reassigned",
+		"You did not reach the restaurant for order {{.order_ref}} in time, so it has been given to another rider.")
+	registerNotificationTemplate("rider_arriving",
+		"Your rider is almost there",
+		"The rider with order {{.order_ref}} is nearly at the drop-off. Keep your phone close.")
+	registerNotificationTemplate("order_delivered_customer",
+		"Your order has been delivered",
+		"Order {{.order_ref}} has been delivered. Enjoy your meal!")
+	registerNotificationTemplate("order_delivered_restaurant",
+		"Order delivered",
+		"Order {{.order_ref}} has been delivered to the customer.")
+	registerNotificationTemplate("dispatch_offer",
+		"New delivery offer",
+		"Order {{.order_ref}} is ready for a rider. Accept it within {{.expires_in}}.")
+	registerNotificationTemplate("price_change_pending",
+		"Menu price change needs your approval",
+		"{{.item}} is set to change from {{.old_price}} to {{.new_price}} ({{.change}}). Approve or reject change {{.change_id}} before it is published.")
+	registerNotificationTemplate("order_update",
+		"Update on order {{.order_ref}}",
+		"{{.message}}")
+	registerNotificationTemplate("ticket_reply",
+		"Re: {{.subject}}",
+		"{{.message}}")
+}
+
+// renderNotification fills in n's Subject and Message from its template.
+func renderNotification(n *Notification) error {
+	tmpl, ok := notificationTemplates[n.Template]
+	if !ok {
+		return fmt.Errorf("unknown notification template %q", n.Template)
+	}
+
+	var subject, message strings.Builder
+	err := tmpl.subject.Execute(&subject, n.Vars)
+	if err != nil {
+		return fmt.Errorf("render %s subject: %w", n.Template, err)
+	}
+	err = tmpl.message.Execute(&message, n.Vars)
+	if err != nil {
+		return fmt.Errorf("render %s message: %w", n.Template, err)
+	}
+
+	n.Subject = subject.String()
+	n.Message = message.String()
+	return nil
+}
@@ -0,0 +1,228 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/textproto"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"myproject/src/clock"
+)
+
+var errNoContact = errors.New("recipient has no contact for this channel")
+
+// Notifier delivers a notification over one channel. Errors that resending
+// cannot fix, such as a rejected address, are wrapped with permanent.
+type Notifier interface {
+	Name() string
+	Send(ctx context.Context, contact Contact, n Notification) error
+}
+
+type WebhookNotifier struct {
+	Client *http.Client
+}
+
+func (WebhookNotifier) Name() string { return "webhook" }
+
+func (w WebhookNotifier) Send(ctx context.Context, contact Contact, n Notification) error {
+	if contact.WebhookURL == "" {
+		return errNoContact
+	}
+
+	body, _ := json.Marshal(n)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, contact.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return permanent(fmt.Errorf("failed to build webhook request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.RequestID != "" {
+		req.Header.Set("X-Request-ID", n.RequestID)
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("webhook returned status %d", resp.StatusCode)
+		// Apart from timeouts and rate limiting, a 4xx means the endpoint
+		// refuses this request and will keep doing so.
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+			resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			return permanent(err)
+		}
+		return err
+	}
+	return nil
+}
+
+type EmailNotifier struct {
+	Sender EmailSender
+}
+
+func (EmailNotifier) Name() string { return "email" }
+
+func (e EmailNotifier) Send(ctx context.Context, contact Contact, n Notification) error {
+	if contact.Email == "" {
+		return errNoContact
+	}
+
+	err := e.Sender.Send([]string{contact.Email}, n.Subject, n.Message)
+
+	// A 5xx SMTP reply, e.g. 550 mailbox unavailable, will not change on
+	// retry.
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return permanent(err)
+	}
+	return err
+}
+
+var notifiers = map[string]Notifier{}
+
+func registerNotifier(n Notifier) {
+	notifiers[n.Name()] = n
+}
+
+func contactKey(recipientType, recipientID string) string {
+	return "contact:" + recipientType + ":" + recipientID
+}
+
+// getContact returns the recipient's contact with the devices they have
+// registered for push.
+func getContact(ctx context.Context, rdb redis.Cmdable, recipientType, recipientID string) (Contact, error) {
+	pipe := rdb.Pipeline()
+	contactCmd := pipe.HGetAll(ctx, contactKey(recipientType, recipientID))
+	devicesCmd := pipe.HGetAll(ctx, devicesKey(recipientType, recipientID))
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return Contact{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	var contact Contact
+	err = contactCmd.Scan(&contact)
+	if err != nil {
+		return Contact{}, fmt.Errorf("redis error: %v", err)
+	}
+	contact.Devices = parseDevices(devicesCmd.Val())
+	return contact, nil
+}
+
+// dispatchNotification renders n and sends it over every channel enabled for
+// its recipient type and not turned off by the recipient, retrying each
+// channel independently. The outcome is stored so failed deliveries can be
+// retried later.
+func dispatchNotification(ctx context.Context, rdb redis.Cmdable, n Notification) (NotificationRecord, error) {
+	if n.ID == "" {
+		id, err := idGenerator.NewID()
+		if err != nil {
+			return NotificationRecord{}, err
+		}
+		n.ID = id
+	}
+
+	// A notification that cannot be rendered never will be.
+	err := renderNotification(&n)
+	if err != nil {
+		return NotificationRecord{}, permanent(err)
+	}
+
+	contact, err := getContact(ctx, rdb, n.RecipientType, n.RecipientID)
+	if err != nil {
+		return NotificationRecord{}, err
+	}
+	prefs, err := getNotificationPreferences(ctx, rdb, n.RecipientType, n.RecipientID)
+	if err != nil {
+		return NotificationRecord{}, err
+	}
+	channels := prefs.channelsFor(n.Template, appConfig.NotifyChannels[n.RecipientType])
+
+	now := clock.Now().UTC()
+	record := NotificationRecord{
+		Notification: n,
+		Channels:     sendOverChannels(ctx, channels, contact, n),
+		Attempts:     1,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	record.Status = record.deliveryStatus()
+
+	err = saveNotificationRecord(ctx, rdb, record)
+	if err != nil {
+		slog.Warn("error storing notification record", "notification_id", n.ID, "error", err)
+	}
+	return record, nil
+}
+
+func sendOverChannels(ctx context.Context, channels []string, contact Contact, n Notification) []ChannelResult {
+	results := make([]ChannelResult, 0, len(channels))
+	for _, channel := range channels {
+		notifier, ok := notifiers[channel]
+		if !ok {
+			results = append(results, ChannelResult{Channel: channel, Error: "channel not available"})
+			continue
+		}
+
+		attempts, err := sendWithRetry(ctx, notifier, contact, n)
+		result := ChannelResult{Channel: channel, Success: err == nil, Attempts: attempts}
+		if err != nil {
+			result.Error = err.Error()
+			result.Terminal = isTerminalDeliveryError(err)
+			slog.Warn("notification delivery failed", "notification_id", n.ID, "channel", channel, "recipient_type", n.RecipientType, "recipient_id", n.RecipientID, "order_id", n.OrderID, "request_id", n.RequestID, "attempts", attempts, "terminal", result.Terminal, "error", err)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func isTerminalDeliveryError(err error) bool {
+	return errors.Is(err, errNoContact) || isPermanent(err)
+}
+
+func sendWithRetry(ctx context.Context, notifier Notifier, contact Contact, n Notification) (int, error) {
+	backoff := appConfig.NotifyRetryBackoff
+	var err error
+	for attempt := 1; attempt <= appConfig.NotifyMaxAttempts; attempt++ {
+		err = notifier.Send(ctx, contact, n)
+		if err == nil || isTerminalDeliveryError(err) {
+			return attempt, err
+		}
+		if attempt == appConfig.NotifyMaxAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return appConfig.NotifyMaxAttempts, err
+}
+
+// resolveRecipientID finds who, on the order, a recipient type refers to.
+func resolveRecipientID(order Order, recipientType string) string {
+	switch recipientType {
+	case "customer":
+		return order.CustomerID
+	case "restaurant":
+		return order.RestaurantID
+	case "rider":
+		return order.RiderID
+	case recipientGift:
+		if order.Gift != nil {
+			return order.OrderID
+		}
+	}
+	return ""
+}
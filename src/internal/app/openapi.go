@@ -0,0 +1,1055 @@
+package app
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// OpenAPI documentation, generated from the code rather than written by
+// hand. The paths are the routes registered on the server, so a new route
+// shows up without anyone remembering to add it. Request and response bodies
+// are described by reflecting over the structs the handlers bind and return,
+// using their json and validate tags, so the schemas change with the code.
+// apiDocs adds what the router cannot tell: a summary, who may call it, and
+// which structs go in and out.
+
+const openAPIVersion = "3.0.3"
+
+const openAPIDescription = "Keys are shown in snake_case. Send `Accept: application/json; profile=camelCase` to read and write them in camelCase. " +
+	"Every path is also served under /v1 and /v2; unprefixed paths are version 1 unless the API-Version header asks for 2."
+
+// apiOperation documents one route.
+type apiOperation struct {
+	Summary string
+	Tag     string
+	// Roles may call the route with a bearer token; none means public.
+	Roles []string
+	Query []apiParam
+	// Request is a value of the JSON body type; Form of the multipart form
+	// type, described by its form tags.
+	Request interface{}
+	Form    interface{}
+	// Response is a value of the success body type, and ResponseV2 of the
+	// richer one served under API version 2, if there is one. Stream is the
+	// content type of responses that are not a JSON document.
+	Response   interface{}
+	ResponseV2 interface{}
+	Stream     string
+	// Status is the success status, 200 if unset.
+	Status int
+	// Conditional routes send an ETag and answer If-None-Match with 304.
+	Conditional bool
+}
+
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+	Required    bool
+}
+
+var trackingTicketParam = apiParam{Name: "ticket", Type: "string", Description: "A ticket from POST /tracking/ticket, in place of the Authorization header"}
+
+// apiStatus is the body of the many routes that only report a status.
+type apiStatus struct {
+	Status string `json:"status"`
+}
+
+// APIError is the body of every error response.
+type APIError struct {
+	Error  string            `json:"error"`
+	Detail string            `json:"detail,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+var (
+	customerRoles   = []string{roleCustomer}
+	restaurantRoles = []string{roleRestaurant}
+	riderRoles      = []string{roleRider}
+	adminRoles      = []string{roleAdmin}
+	partnerRoles    = []string{rolePartner}
+	ownerRoles      = []string{roleRestaurant, roleOwner}
+	orderViewRoles  = []string{roleCustomer, roleRestaurant, roleRider, roleAdmin}
+	recipientRoles  = []string{roleCustomer, roleRider}
+
+	restaurantSearchQuery = []apiParam{
+		{Name: "q", Type: "string", Description: "Name prefix search"},
+		{Name: "cuisine", Type: "string", Description: "Cuisine slug"},
+		{Name: "open_now", Type: "boolean", Description: "Only restaurants open now"},
+		{Name: "lat", Type: "number", Description: "Latitude to measure distance from"},
+		{Name: "lng", Type: "number", Description: "Longitude to measure distance from"},
+		{Name: "radius_km", Type: "number", Description: "Only restaurants within this distance"},
+		{Name: "sort", Type: "string", Description: "name, rating or distance"},
+		{Name: "limit", Type: "integer", Description: "Page size, at most 100"},
+		{Name: "offset", Type: "integer", Description: "Results to skip"},
+	}
+
+	webhookDeliveriesQuery = []apiParam{{Name: "failed", Type: "boolean", Description: "Only failed attempts"}}
+	webhookDeliveryLog     = struct {
+		WebhookID  string            `json:"webhook_id"`
+		Deliveries []WebhookDelivery `json:"deliveries"`
+	}{}
+)
+
+// apiDocs documents routes by "METHOD path", with the path as registered.
+var apiDocs = map[string]apiOperation{
+	"GET /healthz": {Summary: "Liveness check", Tag: "operations", Response: apiStatus{}},
+	"GET /readyz":  {Summary: "Readiness check of Redis and Kafka", Tag: "operations", Response: map[string]DependencyStatus{}},
+	"GET /metrics": {Summary: "Prometheus metrics", Tag: "operations", Stream: "text/plain"},
+	"GET /version": {Summary: "Build information", Tag: "operations", Response: BuildInfo{}},
+
+	"GET /menu": {
+		Summary: "A restaurant's menu with live availability", Tag: "menus",
+		Query: []apiParam{
+			{Name: "restaurant_id", Type: "string", Required: true},
+			{Name: "cache", Type: "string", Description: "bypass, with an admin token, to read the menu from its source"},
+			{Name: "locale", Type: "string", Description: "language tag to name and describe items in, instead of Accept-Language"},
+		},
+		Response:    RestaurantMenu{},
+		Conditional: true,
+	},
+	"PATCH /menu/item/:id/availability": {Summary: "Set an item's availability or stock", Tag: "menus", Roles: restaurantRoles, Request: ItemAvailabilityRequest{}, Response: MenuItem{}},
+	"POST /menu/item/:id/image": {
+		Summary: "Upload an item's image", Tag: "menus", Roles: ownerRoles,
+		Form: menuImageUploadForm{}, Response: MenuItem{},
+	},
+	"PUT /menu/item/:id/price": {
+		Summary: "Change an item's price; large changes wait for owner approval", Tag: "menus", Roles: ownerRoles,
+		Request: PriceChangeRequest{}, Response: PriceChange{},
+	},
+	"PUT /menu/item/:id/pairings": {Summary: "Set the items suggested with an item", Tag: "menus", Roles: ownerRoles, Request: ItemPairingsRequest{}, Response: MenuItem{}},
+	"POST /menu/suggestions": {Summary: "Suggest items that go well with the cart", Tag: "menus", Request: MenuSuggestionsRequest{}, Response: struct {
+		RestaurantID string           `json:"restaurant_id"`
+		Suggestions  []MenuSuggestion `json:"suggestions"`
+	}{}},
+	"POST /menu/suggestions/click": {Summary: "Record a click on a suggested item", Tag: "menus", Request: SuggestionClickRequest{}, Response: apiStatus{}},
+	"GET /restaurant/:id/menu/suggestions/stats": {
+		Summary: "Suggestion impressions and clicks per item for a day", Tag: "menus", Roles: ownerRoles,
+		Query: []apiParam{{Name: "date", Type: "string", Description: "Day to report, YYYY-MM-DD in UTC; today if unset"}},
+		Response: struct {
+			RestaurantID string            `json:"restaurant_id"`
+			Date         string            `json:"date"`
+			Items        []SuggestionStats `json:"items"`
+		}{},
+	},
+
+	"GET /restaurant": {Summary: "Search restaurants", Tag: "restaurants", Query: restaurantSearchQuery, Conditional: true, Response: struct {
+		Restaurant []RestaurantListing `json:"restaurant"`
+		Count      int                 `json:"count"`
+		Total      int                 `json:"total"`
+		Limit      int                 `json:"limit"`
+		Offset     int                 `json:"offset"`
+	}{}},
+	"GET /restaurants": {Summary: "Search restaurants", Tag: "restaurants", Query: restaurantSearchQuery, Conditional: true, Response: struct {
+		Restaurants []RestaurantListing `json:"restaurants"`
+		Count       int                 `json:"count"`
+		Total       int                 `json:"total"`
+		Limit       int                 `json:"limit"`
+		Offset      int                 `json:"offset"`
+	}{}},
+	"POST /restaurant": {
+		Summary: "Onboard a restaurant; lookalikes of listed restaurants are refused with 409", Tag: "restaurants", Roles: adminRoles,
+		Query:   []apiParam{{Name: "allow_duplicate", Type: "boolean", Description: "Register even if it looks like a listed restaurant"}},
+		Request: RestaurantProfile{}, Response: Restaurant{}, Status: http.StatusCreated,
+	},
+	"PUT /restaurant/:id": {Summary: "Update a restaurant's profile", Tag: "restaurants", Roles: []string{roleRestaurant, roleOwner, roleAdmin}, Request: RestaurantProfile{}, Response: Restaurant{}},
+	"GET /cuisines": {Summary: "List cuisines", Tag: "restaurants", Response: struct {
+		Cuisines []Cuisine `json:"cuisines"`
+	}{}},
+	"POST /admin/cuisines":         {Summary: "Create or update a cuisine", Tag: "restaurants", Roles: adminRoles, Request: Cuisine{}, Response: Cuisine{}},
+	"DELETE /admin/cuisines/:slug": {Summary: "Delete a cuisine", Tag: "restaurants", Roles: adminRoles, Response: apiStatus{}},
+	"PUT /restaurant/:id/cuisines": {Summary: "Assign cuisines to a restaurant", Tag: "restaurants", Roles: []string{roleRestaurant, roleAdmin}, Request: AssignCuisinesRequest{}, Response: struct {
+		RestaurantID string   `json:"restaurant_id"`
+		Cuisines     []string `json:"cuisines"`
+	}{}},
+	"PUT /restaurant/:id/branding/logo":       {Summary: "Upload the restaurant logo", Tag: "restaurants", Roles: restaurantRoles, Form: brandingUploadForm{}, Response: RestaurantBranding{}},
+	"PUT /restaurant/:id/branding/cover":      {Summary: "Upload the restaurant cover image", Tag: "restaurants", Roles: restaurantRoles, Form: brandingUploadForm{}, Response: RestaurantBranding{}},
+	"POST /restaurant/:id/gallery":            {Summary: "Add a gallery photo", Tag: "restaurants", Roles: restaurantRoles, Form: brandingUploadForm{}, Response: RestaurantBranding{}},
+	"DELETE /restaurant/:id/gallery/:photoId": {Summary: "Remove a gallery photo", Tag: "restaurants", Roles: restaurantRoles, Response: RestaurantBranding{}},
+	"POST /restaurant/:id/announcements":      {Summary: "Announce to customers who favourited the restaurant", Tag: "restaurants", Roles: restaurantRoles, Request: AnnouncementRequest{}, Response: map[string]int{}},
+	"GET /restaurant/:id/price-changes": {Summary: "List pending price changes", Tag: "restaurants", Roles: ownerRoles, Response: struct {
+		PriceChanges []PriceChange `json:"price_changes"`
+	}{}},
+	"POST /restaurant/:id/price-changes/:changeId/approve": {Summary: "Approve a price change", Tag: "restaurants", Roles: []string{roleOwner}, Request: PriceChangeDecision{}, Response: PriceChange{}},
+	"POST /restaurant/:id/price-changes/:changeId/reject":  {Summary: "Reject a price change", Tag: "restaurants", Roles: []string{roleOwner}, Request: PriceChangeDecision{}, Response: PriceChange{}},
+	"POST /restaurant/:id/menu/clone": {Summary: "Copy the restaurant's menu to other branches of its chain", Tag: "restaurants", Roles: []string{roleOwner, roleAdmin}, Request: MenuCloneRequest{}, Response: struct {
+		SourceID string            `json:"source_id"`
+		Branches []MenuCloneResult `json:"branches"`
+	}{}},
+	"POST /restaurant/:id/menu/publish": {Summary: "Check a new menu for compliance and publish it if it passes", Tag: "restaurants", Roles: []string{roleRestaurant, roleOwner, roleAdmin}, Request: MenuPublishRequest{},
+		Query: []apiParam{{Name: "dry_run", Type: "boolean", Description: "Only check the menu"}},
+		Response: struct {
+			Status string           `json:"status"`
+			Report ComplianceReport `json:"report"`
+		}{}},
+	"GET /restaurant/:id/menu/compliance": {Summary: "The compliance report of the restaurant's last menu publish", Tag: "restaurants", Roles: []string{roleRestaurant, roleOwner, roleAdmin}, Response: ComplianceReport{}},
+	"GET /restaurant/:id/webhook": {Summary: "The restaurant's new-order webhook and its circuit", Tag: "restaurants", Roles: ownerRoles, Response: struct {
+		Webhook RestaurantWebhook `json:"webhook"`
+		Circuit WebhookBreaker    `json:"circuit"`
+	}{}},
+	"PUT /restaurant/:id/webhook":       {Summary: "Set the restaurant's new-order webhook", Tag: "restaurants", Roles: ownerRoles, Request: RestaurantWebhook{}, Response: RestaurantWebhook{}},
+	"DELETE /restaurant/:id/webhook":    {Summary: "Remove the restaurant's new-order webhook", Tag: "restaurants", Roles: ownerRoles, Response: apiStatus{}},
+	"GET /restaurant/:id/dashboard":     {Summary: "Order counts and revenue for a restaurant", Tag: "restaurants", Roles: []string{roleRestaurant, roleOwner, roleAdmin}, Response: RestaurantDashboard{}},
+	"POST /restaurant/customer/block":   {Summary: "Block a customer from ordering", Tag: "restaurants", Roles: restaurantRoles, Request: BlockCustomerRequest{}, Response: BlockEntry{}},
+	"POST /restaurant/customer/unblock": {Summary: "Unblock a customer", Tag: "restaurants", Roles: restaurantRoles, Request: UnblockCustomerRequest{}, Response: apiStatus{}},
+
+	"GET /restaurant/:id/serviceable": {
+		Summary: "Whether a restaurant delivers to a location, and for what fee", Tag: "restaurants",
+		Query: []apiParam{
+			{Name: "lat", Type: "number", Required: true},
+			{Name: "lng", Type: "number", Required: true},
+		},
+		Response: Serviceability{},
+	},
+	"POST /quote": {Summary: "Quote delivery fee and time", Tag: "orders", Request: QuoteRequest{}, Response: Quote{}},
+	"POST /order": {Summary: "Place an order", Tag: "orders", Roles: customerRoles, Request: Order{}, Response: struct {
+		OrderID       string          `json:"order_id"`
+		OrderCode     string          `json:"order_code"`
+		Status        string          `json:"status"`
+		PaymentStatus string          `json:"payment_status"`
+		Pricing       *PriceBreakdown `json:"pricing"`
+		ScheduledAt   *Timestamp      `json:"scheduled_at"`
+	}{}},
+	"POST /orders/bulk":     {Summary: "Place many orders, or one order in scheduled slots, all or none", Tag: "orders", Roles: customerRoles, Request: BulkOrderRequest{}, Response: BulkOrderResponse{}},
+	"POST /cart":            {Summary: "Start a cart at a restaurant, priced as of now", Tag: "orders", Roles: customerRoles, Request: CreateCartRequest{}, Response: PricedCart{}},
+	"GET /cart/:id":         {Summary: "A cart priced as of now, or what stops it being checked out", Tag: "orders", Roles: customerRoles, Response: PricedCart{}},
+	"PATCH /cart/:id/items": {Summary: "Set the quantities of items in a cart; zero takes an item out", Tag: "orders", Roles: customerRoles, Request: CartItemsRequest{}, Response: PricedCart{}},
+	"POST /cart/:id/checkout": {Summary: "Place a cart as an order, with any details given in the body", Tag: "orders", Roles: customerRoles, Request: CartDetails{}, Response: struct {
+		CartID        string          `json:"cart_id"`
+		OrderID       string          `json:"order_id"`
+		OrderCode     string          `json:"order_code"`
+		Status        string          `json:"status"`
+		PaymentStatus string          `json:"payment_status"`
+		Pricing       *PriceBreakdown `json:"pricing"`
+		ScheduledAt   *Timestamp      `json:"scheduled_at"`
+	}{}},
+	"PATCH /order/:id": {Summary: "Change the items of an order the restaurant has not accepted", Tag: "orders", Roles: customerRoles, Request: ModifyOrderRequest{}, Response: struct {
+		OrderID       string          `json:"order_id"`
+		OrderCode     string          `json:"order_code"`
+		Status        string          `json:"status"`
+		PaymentStatus string          `json:"payment_status"`
+		Items         []OrderItem     `json:"items"`
+		Pricing       *PriceBreakdown `json:"pricing"`
+	}{}},
+	"POST /order/cancel": {Summary: "Cancel an order", Tag: "orders", Roles: customerRoles, Request: CancelOrderRequest{}, Response: struct {
+		OrderID         string  `json:"order_id"`
+		Status          string  `json:"status"`
+		RefundAmount    float64 `json:"refund_amount"`
+		CancellationFee float64 `json:"cancellation_fee"`
+		WithinGrace     bool    `json:"within_grace"`
+	}{}},
+	"POST /order/pay": {Summary: "Pay for an order", Tag: "orders", Roles: customerRoles, Request: PayOrderRequest{}, Response: struct {
+		OrderID       string `json:"order_id"`
+		PaymentID     string `json:"payment_id"`
+		PaymentStatus string `json:"payment_status"`
+	}{}},
+	"POST /order/:id/tip": {Summary: "Tip the rider of a delivered order, within TIP_WINDOW of delivery", Tag: "orders", Roles: customerRoles, Request: TipOrderRequest{}, Response: struct {
+		OrderID       string  `json:"order_id"`
+		PaymentID     string  `json:"payment_id"`
+		PaymentStatus string  `json:"payment_status"`
+		Tip           float64 `json:"tip"`
+		TotalAmount   float64 `json:"total_amount"`
+	}{}},
+	"GET /order/:id/stream": {
+		Summary: "Follow an order as Server-Sent Events; for its customer, rider and restaurant", Tag: "orders", Roles: trackingRoles,
+		Query: []apiParam{trackingTicketParam}, Stream: "text/event-stream",
+	},
+	"POST /tracking/ticket": {
+		Summary: "A single-use ticket for opening a tracking channel without the Authorization header", Tag: "orders", Roles: trackingRoles,
+		Response: TrackingTicket{}, Status: http.StatusCreated,
+	},
+	"GET /tracking/ws": {
+		Summary: "Follow many orders over a WebSocket, subscribing and unsubscribing by message", Tag: "orders", Roles: trackingRoles,
+		Query: []apiParam{trackingTicketParam}, Status: http.StatusSwitchingProtocols,
+	},
+	"GET /order/:id/status": {
+		Summary: "An order's status, optionally long-polling for a change", Tag: "orders", Roles: customerRoles,
+		Query: []apiParam{
+			{Name: "wait", Type: "string", Description: "How long to wait for a change, e.g. 30s; at most 60s"},
+			{Name: "since", Type: "string", Description: "The status the client last saw"},
+		},
+		Response: OrderStatusResponse{}, ResponseV2: OrderStatusResponseV2{},
+	},
+	"POST /order/:id/message": {
+		Summary: "Message the other party to an order: its customer or its rider", Tag: "orders", Roles: recipientRoles,
+		Request: OrderMessageRequest{}, Response: OrderMessage{}, Status: http.StatusCreated,
+	},
+	"GET /order/:id/messages": {Summary: "An order's chat, oldest first", Tag: "orders", Roles: []string{roleCustomer, roleRider, roleAdmin}, Response: struct {
+		OrderID  string         `json:"order_id"`
+		Closed   bool           `json:"closed"`
+		Messages []OrderMessage `json:"messages"`
+	}{}},
+	"GET /order/:id/eta":     {Summary: "Estimated delivery time", Tag: "orders", Roles: orderViewRoles, Response: OrderETA{}},
+	"GET /order/:id":         {Summary: "An order, with its proof of delivery once delivered", Tag: "orders", Roles: orderViewRoles, Response: Order{}},
+	"GET /order/code/:code":  {Summary: "Look up an order by its short code", Tag: "orders", Roles: orderViewRoles, Response: Order{}},
+	"POST /order/:id/issues": {Summary: "Report a problem with an order", Tag: "orders", Roles: customerRoles, Form: ReportIssueForm{}, Response: OrderIssue{}, Status: http.StatusCreated},
+	"GET /order/:id/issues": {Summary: "List an order's reported problems", Tag: "orders", Roles: customerRoles, Response: struct {
+		Issues []OrderIssue `json:"issues"`
+	}{}},
+	"GET /order/:id/events": {Summary: "The order's published events, as they occurred", Tag: "orders", Roles: orderViewRoles, Response: struct {
+		OrderID string         `json:"order_id"`
+		Events  []AuditedEvent `json:"events"`
+	}{}},
+	"POST /order/:id/refund": {
+		Summary: "Refund what is left of a paid order's payment, or some of its items", Tag: "orders", Roles: adminRoles,
+		Request: RefundOrderRequest{}, Status: http.StatusCreated, Response: struct {
+			OrderID        string  `json:"order_id"`
+			PaymentStatus  string  `json:"payment_status"`
+			RefundedAmount float64 `json:"refunded_amount"`
+			Refund         Refund  `json:"refund"`
+		}{},
+	},
+	"POST /order/:id/refund-request": {Summary: "Ask for a refund", Tag: "orders", Roles: customerRoles, Request: RefundRequest{}, Response: struct {
+		TicketID string `json:"ticket_id"`
+		Status   string `json:"status"`
+	}{}, Status: http.StatusCreated},
+	"POST /restaurant/order/accept":          {Summary: "Accept a paid order", Tag: "orders", Roles: restaurantRoles, Request: AcceptOrderRequest{}, Response: AcceptOrderResponse{}},
+	"POST /restaurant/order/reject":          {Summary: "Reject an order", Tag: "orders", Roles: restaurantRoles, Request: RejectOrderRequest{}, Response: apiStatus{}},
+	"GET /restaurant/order/:id/package-note": {Summary: "The note to put in the order's bag; a gift's has its message and no prices", Tag: "orders", Roles: restaurantRoles, Response: PackageNote{}},
+	"PUT /restaurant/order/:id/ready-by": {Summary: "Move an accepted order's ready-by time; the customer's ETA follows", Tag: "orders", Roles: restaurantRoles, Request: ReadyByRequest{}, Response: struct {
+		OrderID string     `json:"order_id"`
+		Status  string     `json:"status"`
+		ReadyBy *Timestamp `json:"ready_by"`
+		Version int64      `json:"version"`
+	}{}},
+	"GET /restaurant/:id/orders/pending": {
+		Summary: "Follow the orders waiting to be accepted as Server-Sent Events, for a kitchen display", Tag: "orders", Roles: restaurantRoles,
+		Query: []apiParam{trackingTicketParam}, Stream: "text/event-stream",
+	},
+	"POST /restaurant/:id/orders/batch": {
+		Summary: "Accept and reject many orders at once; 207 if any fails", Tag: "orders", Roles: restaurantRoles,
+		Request: KitchenBatchRequest{}, Response: KitchenBatchResponse{},
+	},
+
+	"POST /customer":    {Summary: "Register the calling customer", Tag: "customers", Roles: customerRoles, Request: Customer{}, Response: Customer{}, Status: http.StatusCreated},
+	"GET /customer/:id": {Summary: "A customer's profile", Tag: "customers", Roles: []string{roleCustomer, roleAdmin}, Response: Customer{}},
+	"GET /customer/:id/orders": {
+		Summary: "A customer's orders, newest first", Tag: "customers", Roles: []string{roleCustomer, roleAdmin},
+		Query: []apiParam{
+			{Name: "limit", Type: "integer", Description: "Page size, at most 100"},
+			{Name: "cursor", Type: "string", Description: "next_cursor from the previous page"},
+		},
+		Response: struct {
+			Orders     []Order `json:"orders"`
+			NextCursor string  `json:"next_cursor,omitempty"`
+		}{},
+	},
+	"GET /customer/favorites": {Summary: "The calling customer's favourite restaurants", Tag: "customers", Roles: customerRoles, Response: struct {
+		RestaurantIDs []string `json:"restaurant_ids"`
+	}{}},
+	"POST /customer/favorites":                 {Summary: "Favourite a restaurant", Tag: "customers", Roles: customerRoles, Request: FavoriteRequest{}, Response: apiStatus{}},
+	"DELETE /customer/favorites/:restaurantId": {Summary: "Unfavourite a restaurant", Tag: "customers", Roles: customerRoles, Response: apiStatus{}},
+
+	"GET /rider": {Summary: "List riders", Tag: "riders", Response: struct {
+		Rider []Rider `json:"rider"`
+	}{}},
+	"POST /rider/order/pickup": {Summary: "Confirm pickup against the checklist", Tag: "riders", Roles: riderRoles, Request: PickupRequest{}, Response: struct {
+		Status          string          `json:"status"`
+		DeliveryOptions DeliveryOptions `json:"delivery_options"`
+	}{}},
+	"POST /rider/order/deliver": {Summary: "Confirm delivery, with proof of it", Tag: "riders", Roles: riderRoles, Request: DeliverRequest{}, Response: apiStatus{}},
+	"POST /rider/order/:id/proof-photo": {
+		Summary: "Upload a photo of the drop-off; its id is the photo_ref to deliver with", Tag: "riders", Roles: riderRoles,
+		Form: proofPhotoUploadForm{}, Response: ImageAsset{}, Status: http.StatusCreated,
+	},
+	"GET /rider/order/:id/checklist": {Summary: "What to collect at pickup", Tag: "riders", Roles: riderRoles, Response: struct {
+		OrderID      string              `json:"order_id"`
+		OrderCode    string              `json:"order_code"`
+		Checklist    PickupChecklist     `json:"checklist"`
+		Confirmation *PickupConfirmation `json:"confirmation"`
+	}{}},
+	"GET /rider/:id/orders/active": {Summary: "Orders the rider is carrying, with both stops", Tag: "riders", Roles: riderRoles, Response: struct {
+		Orders []RiderAssignment `json:"orders"`
+	}{}},
+	"POST /rider/location": {Summary: "Report the rider's position", Tag: "riders", Roles: riderRoles, Request: RiderLocationRequest{}, Response: apiStatus{}},
+	"POST /rider/status": {Summary: "Go online or offline", Tag: "riders", Roles: riderRoles, Request: RiderStatusRequest{}, Response: struct {
+		Status string      `json:"status"`
+		Shift  *RiderShift `json:"shift,omitempty"`
+	}{}},
+	"GET /rider/offers": {
+		Summary: "Dispatch offers waiting on the rider", Tag: "riders", Roles: riderRoles,
+		Query: []apiParam{{Name: "rider_id", Type: "string", Required: true}},
+		Response: struct {
+			Offers []DispatchOffer `json:"offers"`
+		}{},
+	},
+	"POST /rider/offers/accept":  {Summary: "Accept a dispatch offer", Tag: "riders", Roles: riderRoles, Request: DispatchOfferRequest{}, Response: map[string]string{}},
+	"POST /rider/offers/decline": {Summary: "Decline a dispatch offer", Tag: "riders", Roles: riderRoles, Request: DispatchOfferRequest{}, Response: apiStatus{}},
+	"GET /riders/available": {
+		Summary: "Online riders", Tag: "riders", Roles: adminRoles,
+		Query: []apiParam{{Name: "zone", Type: "string"}},
+		Response: struct {
+			Riders []AvailableRider `json:"riders"`
+		}{},
+	},
+	"GET /restaurant/:id/riders/nearby": {
+		Summary: "Online riders within the dispatch radius of a restaurant", Tag: "riders", Roles: adminRoles,
+		Query:    []apiParam{{Name: "radius_m", Type: "number", Description: "Search radius; defaults to the dispatch radius"}},
+		Response: NearbyRiders{},
+	},
+
+	"POST /notification/send": {Summary: "Send a notification about an order", Tag: "notifications", Roles: adminRoles, Request: SendNotificationRequest{}, Response: struct {
+		Status         string          `json:"status"`
+		NotificationID string          `json:"notification_id"`
+		Channels       []ChannelResult `json:"channels"`
+	}{}},
+	"POST /notification/devices":           {Summary: "Register a device for push notifications", Tag: "notifications", Roles: recipientRoles, Request: Device{}, Response: Device{}},
+	"DELETE /notification/devices/:token":  {Summary: "Stop push notifications to a device", Tag: "notifications", Roles: recipientRoles, Response: apiStatus{}},
+	"GET /notification/preferences":        {Summary: "The caller's notification preferences", Tag: "notifications", Roles: recipientRoles, Response: NotificationPreferences{}},
+	"PUT /notification/preferences":        {Summary: "Set which notifications the caller receives, and how", Tag: "notifications", Roles: recipientRoles, Request: NotificationPreferences{}, Response: NotificationPreferences{}},
+	"PUT /notification/contacts/:type/:id": {Summary: "Set how a party is contacted", Tag: "notifications", Roles: adminRoles, Request: Contact{}, Response: Contact{}},
+	"GET /admin/notifications/:id":         {Summary: "A notification and its delivery attempts", Tag: "notifications", Roles: adminRoles, Response: NotificationRecord{}},
+	"POST /admin/notifications/:id/retry":  {Summary: "Retry a notification's failed channels", Tag: "notifications", Roles: adminRoles, Response: NotificationRecord{}},
+	"GET /admin/webhooks": {Summary: "List order event webhooks", Tag: "notifications", Roles: adminRoles, Response: struct {
+		Webhooks []WebhookSubscription `json:"webhooks"`
+	}{}},
+	"POST /admin/webhooks":               {Summary: "Subscribe a webhook to order events", Tag: "notifications", Roles: adminRoles, Request: WebhookSubscription{}, Response: WebhookSubscription{}, Status: http.StatusCreated},
+	"DELETE /admin/webhooks/:id":         {Summary: "Remove an order event webhook", Tag: "notifications", Roles: adminRoles, Response: apiStatus{}},
+	"GET /admin/webhooks/:id/deliveries": {Summary: "Recent delivery attempts to an order event webhook", Tag: "notifications", Roles: adminRoles, Query: webhookDeliveriesQuery, Response: webhookDeliveryLog},
+	"GET /webhooks": {Summary: "List the partner's order event webhooks", Tag: "partners", Roles: partnerRoles, Response: struct {
+		Webhooks []WebhookSubscription `json:"webhooks"`
+	}{}},
+	"POST /webhooks":               {Summary: "Subscribe the partner to order events", Tag: "partners", Roles: partnerRoles, Request: WebhookSubscription{}, Response: WebhookSubscription{}, Status: http.StatusCreated},
+	"DELETE /webhooks/:id":         {Summary: "Remove one of the partner's webhooks", Tag: "partners", Roles: partnerRoles, Response: apiStatus{}},
+	"GET /webhooks/:id/deliveries": {Summary: "Recent delivery attempts to one of the partner's webhooks", Tag: "partners", Roles: partnerRoles, Query: webhookDeliveriesQuery, Response: webhookDeliveryLog},
+
+	"POST /admin/customer/block":   {Summary: "Block a customer everywhere", Tag: "admin", Roles: adminRoles, Request: BlockCustomerRequest{}, Response: BlockEntry{}},
+	"POST /admin/customer/unblock": {Summary: "Lift a platform-wide block", Tag: "admin", Roles: adminRoles, Request: UnblockCustomerRequest{}, Response: apiStatus{}},
+	"GET /admin/blocklist/audit": {Summary: "Block and unblock history", Tag: "admin", Roles: adminRoles, Response: struct {
+		Audit []BlockAuditRecord `json:"audit"`
+	}{}},
+	"POST /admin/cache/warm":  {Summary: "Load menus into the cache", Tag: "admin", Roles: adminRoles, Request: CacheWarmRequest{}, Response: CacheWarmResult{}},
+	"POST /admin/dlq/redrive": {Summary: "Re-publish dead-lettered events", Tag: "admin", Roles: adminRoles, Request: RedriveRequest{}, Response: map[string]int{}},
+	"POST /admin/orders/:id/events/:event_id/replay": {Summary: "Publish an order's event again from its audit log", Tag: "admin", Roles: adminRoles, Response: struct {
+		OrderID    string    `json:"order_id"`
+		EventID    string    `json:"event_id"`
+		Type       string    `json:"type"`
+		Topic      string    `json:"topic"`
+		ReplayedAt Timestamp `json:"replayed_at"`
+	}{}},
+	"GET /admin/orders/:id/sagas": {Summary: "An order's compensating sagas and their steps", Tag: "admin", Roles: adminRoles, Response: struct {
+		OrderID string `json:"order_id"`
+		Sagas   []Saga `json:"sagas"`
+	}{}},
+	"GET /admin/promos": {Summary: "List promo codes", Tag: "admin", Roles: adminRoles, Response: struct {
+		Promos []Promo `json:"promos"`
+	}{}},
+	"PUT /admin/promos/:code":                   {Summary: "Create or update a promo code", Tag: "admin", Roles: adminRoles, Request: Promo{}, Response: Promo{}},
+	"DELETE /admin/promos/:code":                {Summary: "Delete a promo code", Tag: "admin", Roles: adminRoles, Response: apiStatus{}},
+	"GET /admin/fee-sharing":                    {Summary: "Delivery fee sharing rules in force", Tag: "admin", Roles: adminRoles, Response: FeeSharing{}},
+	"PUT /admin/fee-sharing/zones/:zone":        {Summary: "Set how a zone's delivery fees are shared", Tag: "admin", Roles: adminRoles, Request: FeeShareRule{}, Response: FeeShareRule{}},
+	"DELETE /admin/fee-sharing/zones/:zone":     {Summary: "Share a zone's delivery fees by the default rule", Tag: "admin", Roles: adminRoles, Response: apiStatus{}},
+	"PUT /admin/fee-sharing/restaurants/:id":    {Summary: "Set a restaurant's contracted delivery fee sharing", Tag: "admin", Roles: adminRoles, Request: FeeShareRule{}, Response: FeeShareRule{}},
+	"DELETE /admin/fee-sharing/restaurants/:id": {Summary: "Remove a restaurant's contracted delivery fee sharing", Tag: "admin", Roles: adminRoles, Response: apiStatus{}},
+	"GET /admin/ledger/platform": {
+		Summary: "The platform's share of delivery fees", Tag: "admin", Roles: adminRoles,
+		Query:    []apiParam{{Name: "days", Type: "integer", Description: "How many days back, 30 by default"}},
+		Response: Ledger{},
+	},
+	"GET /rider/:id/earnings": {
+		Summary: "The rider's earnings from each delivery: their share of the fee and the tip", Tag: "riders", Roles: []string{roleRider, roleAdmin},
+		Query: []apiParam{
+			{Name: "days", Type: "integer", Description: "How many days back, 30 by default"},
+			{Name: "by", Type: "string", Description: "day or week, to total the earnings by period too"},
+		},
+		Response: RiderEarnings{},
+	},
+	"GET /rider/:id/payouts": {Summary: "Payouts requested for the rider, newest first", Tag: "riders", Roles: []string{roleRider, roleAdmin}, Response: struct {
+		RiderID string        `json:"rider_id"`
+		Payouts []RiderPayout `json:"payouts"`
+	}{}},
+	"GET /restaurant/:id/payouts": {
+		Summary: "The restaurant's share of delivery fees", Tag: "restaurants", Roles: []string{roleRestaurant, roleOwner, roleAdmin},
+		Query:    []apiParam{{Name: "days", Type: "integer", Description: "How many days back, 30 by default"}},
+		Response: Ledger{},
+	},
+	"PUT /restaurant/:id/pos/:date": {
+		Summary: "Upload the POS's figures for a day not yet closed out", Tag: "restaurants", Roles: []string{roleRestaurant, roleOwner, roleAdmin},
+		Request: POSFigures{}, Response: POSFigures{},
+	},
+	"POST /restaurant/:id/closeout": {
+		Summary: "Close out a day, today by default, reconciling its orders against the POS's figures", Tag: "restaurants", Roles: []string{roleRestaurant, roleOwner, roleAdmin},
+		Request: CloseoutRequest{}, Response: RestaurantCloseout{}, Status: http.StatusCreated,
+	},
+	"GET /restaurant/:id/closeout/:date": {
+		Summary: "A day's closeout", Tag: "restaurants", Roles: []string{roleRestaurant, roleOwner, roleAdmin},
+		Response: RestaurantCloseout{},
+	},
+	"GET /admin/brands": {Summary: "List brands", Tag: "admin", Roles: adminRoles, Response: struct {
+		Brands []Brand `json:"brands"`
+	}{}},
+	"PUT /admin/brands/:id":    {Summary: "Create or update a brand and its branches", Tag: "admin", Roles: adminRoles, Request: Brand{}, Response: Brand{}},
+	"DELETE /admin/brands/:id": {Summary: "Delete a brand, leaving its branches independent", Tag: "admin", Roles: adminRoles, Response: apiStatus{}},
+	"GET /brand/:id":           {Summary: "A brand and its branches", Tag: "restaurants", Roles: []string{roleRestaurant, roleOwner, roleAdmin}, Response: Brand{}},
+	"GET /brand/:id/dashboard": {Summary: "Order counts and revenue for each of a brand's branches and in total", Tag: "restaurants", Roles: []string{roleRestaurant, roleOwner, roleAdmin}, Response: BrandDashboard{}},
+	"GET /admin/dispatch":      {Summary: "Dispatch queue and outstanding offers", Tag: "admin", Roles: adminRoles, Response: map[string]interface{}{}},
+	"GET /admin/apikeys": {
+		Summary: "List API keys, oldest first", Tag: "admin", Roles: adminRoles,
+		Query: []apiParam{{Name: "restaurant_id", Type: "string", Description: "Only this restaurant's keys"}},
+		Response: struct {
+			APIKeys []APIKey `json:"api_keys"`
+		}{},
+	},
+	"POST /admin/apikeys":            {Summary: "Issue a restaurant an API key, shown only in this response", Tag: "admin", Roles: adminRoles, Request: APIKeyRequest{}, Response: IssuedAPIKey{}},
+	"POST /admin/apikeys/:id/rotate": {Summary: "Give an API key a new value; the old one works for API_KEY_ROTATION_GRACE more", Tag: "admin", Roles: adminRoles, Response: IssuedAPIKey{}},
+	"POST /admin/apikeys/:id/revoke": {Summary: "Revoke an API key at once", Tag: "admin", Roles: adminRoles, Response: APIKey{}},
+	"GET /admin/signing-keys": {
+		Summary: "List request signing keys, oldest first", Tag: "admin", Roles: adminRoles,
+		Query: []apiParam{
+			{Name: "rider_id", Type: "string", Description: "Only this rider's keys"},
+			{Name: "restaurant_id", Type: "string", Description: "Only this restaurant's keys"},
+		},
+		Response: struct {
+			SigningKeys []SigningKey `json:"signing_keys"`
+		}{},
+	},
+	"POST /admin/signing-keys":            {Summary: "Provision a rider or restaurant a request signing key, its secret shown only in this response", Tag: "admin", Roles: adminRoles, Request: SigningKeyRequest{}, Response: IssuedSigningKey{}},
+	"POST /admin/signing-keys/:id/revoke": {Summary: "Revoke a request signing key at once", Tag: "admin", Roles: adminRoles, Response: SigningKey{}},
+	"GET /admin/orders/export": {
+		Summary: "Export orders as one streamed JSON document or, with format=ndjson, as NDJSON", Tag: "admin", Roles: adminRoles,
+		Query: []apiParam{
+			{Name: "status", Type: "string", Description: "Only orders in this status"},
+			{Name: "from", Type: "string", Description: "Only orders placed at or after this RFC 3339 time or date"},
+			{Name: "to", Type: "string", Description: "Only orders placed before this RFC 3339 time or date"},
+			{Name: "format", Type: "string", Description: "json or ndjson"},
+			{Name: "cursor", Type: "integer", Description: "NDJSON only: resume from the X-Export-Cursor trailer of an earlier export"},
+			{Name: "limit", Type: "integer", Description: "NDJSON only: stop after about this many orders"},
+		},
+		Response: struct {
+			Orders []Order `json:"orders"`
+		}{},
+	},
+	"GET /admin/analytics": {
+		Summary: "Daily event counts and revenue, or hourly business metrics", Tag: "admin", Roles: adminRoles,
+		Query: []apiParam{
+			{Name: "days", Type: "integer", Description: "Days to cover, at most 90"},
+			{Name: "hours", Type: "integer", Description: "Hours of business metrics to cover instead, at most 168"},
+		},
+		Response: struct {
+			Days  []AnalyticsDay  `json:"days,omitempty"`
+			Hours []AnalyticsHour `json:"hours,omitempty"`
+		}{},
+	},
+	"GET /admin/flags": {Summary: "Every feature flag's setting in force", Tag: "admin", Roles: adminRoles, Response: struct {
+		Flags []FeatureFlag `json:"flags"`
+	}{}},
+	"PUT /admin/flags/:name": {
+		Summary: "Override a feature flag on every instance: on or off, for listed restaurants or a percentage of them", Tag: "admin", Roles: adminRoles,
+		Request: FeatureFlagRequest{}, Response: FeatureFlag{},
+	},
+	"DELETE /admin/flags/:name": {Summary: "Return a feature flag to its configured setting", Tag: "admin", Roles: adminRoles, Response: FeatureFlag{}},
+	"GET /admin/orders/archive": {
+		Summary: "Look up an archived order, or list the latest archive files", Tag: "admin", Roles: adminRoles,
+		Query: []apiParam{{Name: "order_id", Type: "string", Description: "Order to look up; the latest archive files if unset"}},
+		Response: struct {
+			Archive  string              `json:"archive,omitempty"`
+			Order    *Order              `json:"order,omitempty"`
+			Archives []OrderArchiveBatch `json:"archives,omitempty"`
+		}{},
+	},
+	"GET /dashboard/restaurants": {Summary: "Order counts and revenue for every restaurant", Tag: "dashboards", Roles: adminRoles, Response: struct {
+		Restaurants []RestaurantDashboard `json:"restaurants"`
+	}{}},
+	"GET /dashboard/restaurants/:id": {Summary: "Order counts and revenue for a restaurant", Tag: "dashboards", Roles: []string{roleRestaurant, roleOwner, roleAdmin}, Response: RestaurantDashboard{}},
+	"GET /dashboard/restaurants/:id/revenue": {
+		Summary: "A restaurant's delivered orders and revenue by day", Tag: "dashboards", Roles: []string{roleRestaurant, roleOwner, roleAdmin},
+		Query: []apiParam{{Name: "days", Type: "integer", Description: "Days to cover, at most 90"}},
+		Response: struct {
+			RestaurantID string         `json:"restaurant_id"`
+			Days         []DailyRevenue `json:"days"`
+		}{},
+	},
+	"GET /dashboard/riders": {Summary: "Riders with deliveries under way, and those deliveries", Tag: "dashboards", Roles: adminRoles, Response: struct {
+		Riders []RiderDeliveries `json:"riders"`
+	}{}},
+	"GET /dashboard/riders/:id": {Summary: "A rider's deliveries under way", Tag: "dashboards", Roles: []string{roleRider, roleAdmin}, Response: RiderDeliveries{}},
+	"GET /dashboard/revenue": {
+		Summary: "Delivered orders and revenue by day", Tag: "dashboards", Roles: adminRoles,
+		Query: []apiParam{{Name: "days", Type: "integer", Description: "Days to cover, at most 90"}},
+		Response: struct {
+			Days []DailyRevenue `json:"days"`
+		}{},
+	},
+	"GET /admin/projections": {Summary: "Projection generations and checkpoints", Tag: "admin", Roles: adminRoles, Response: struct {
+		Projections []ProjectionStatus `json:"projections"`
+	}{}},
+	"POST /admin/projections/:name/rebuild": {Summary: "Rebuild a projection from the start of the topic", Tag: "admin", Roles: adminRoles, Response: map[string]interface{}{}, Status: http.StatusAccepted},
+	"POST /admin/projections/:name/pause":   {Summary: "Pause a projection at its checkpoint", Tag: "admin", Roles: adminRoles, Response: ProjectionStatus{}},
+	"POST /admin/projections/:name/resume":  {Summary: "Resume a paused projection", Tag: "admin", Roles: adminRoles, Response: ProjectionStatus{}},
+
+	"GET /admin/tickets": {
+		Summary: "List support tickets", Tag: "support", Roles: adminRoles,
+		Query: []apiParam{
+			{Name: "status", Type: "string"},
+			{Name: "assignee", Type: "string"},
+			{Name: "breached", Type: "boolean", Description: "Only tickets past their SLA"},
+		},
+		Response: struct {
+			Count   int      `json:"count"`
+			Tickets []Ticket `json:"tickets"`
+		}{},
+	},
+	"GET /admin/tickets/canned": {Summary: "List canned replies", Tag: "support", Roles: adminRoles, Response: struct {
+		CannedReplies []CannedReply `json:"canned_replies"`
+	}{}},
+	"PUT /admin/tickets/canned/:name":    {Summary: "Create or update a canned reply", Tag: "support", Roles: adminRoles, Request: CannedReply{}, Response: CannedReply{}},
+	"DELETE /admin/tickets/canned/:name": {Summary: "Delete a canned reply", Tag: "support", Roles: adminRoles, Response: apiStatus{}},
+	"GET /admin/tickets/:id":             {Summary: "A support ticket", Tag: "support", Roles: adminRoles, Response: Ticket{}},
+	"PUT /admin/tickets/:id/assign":      {Summary: "Assign a ticket", Tag: "support", Roles: adminRoles, Request: AssignTicketRequest{}, Response: Ticket{}},
+	"PUT /admin/tickets/:id/status":      {Summary: "Change a ticket's status", Tag: "support", Roles: adminRoles, Request: TicketStatusRequest{}, Response: Ticket{}},
+	"POST /admin/tickets/:id/replies":    {Summary: "Reply to a ticket", Tag: "support", Roles: adminRoles, Request: TicketReplyRequest{}, Response: Ticket{}},
+}
+
+// brandingUploadForm is the multipart form of the image upload routes.
+type brandingUploadForm struct {
+	Image string `form:"image" format:"binary" validate:"required"`
+}
+
+// proofPhotoUploadForm is the multipart form of POST
+// /rider/order/:id/proof-photo.
+type proofPhotoUploadForm struct {
+	Photo string `form:"photo" format:"binary" validate:"required"`
+}
+
+// menuImageUploadForm is the multipart form of POST /menu/item/:id/image.
+type menuImageUploadForm struct {
+	RestaurantID string `form:"restaurant_id" validate:"required"`
+	Image        string `form:"image" format:"binary" validate:"required"`
+}
+
+// openAPIBuilder turns routes and their documentation into a spec, collecting
+// every named struct it meets as a reusable schema.
+type openAPIBuilder struct {
+	schemas map[string]interface{}
+}
+
+func buildOpenAPISpec(routes []*echo.Route) map[string]interface{} {
+	b := &openAPIBuilder{schemas: map[string]interface{}{}}
+	errorSchema := b.schema(reflect.TypeOf(APIError{}))
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	paths := map[string]map[string]interface{}{}
+	for _, route := range routes {
+		if strings.HasPrefix(route.Path, "/swagger") || strings.Contains(route.Path, "*") || route.Method == echo.RouteNotFound {
+			continue
+		}
+		doc := apiDocs[route.Method+" "+route.Path]
+		path, params := openAPIPath(route.Path)
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		op := b.operation(doc, params, errorSchema)
+		if scope, ok := apiKeyRoutes[route.Method+" "+route.Path]; ok {
+			op["security"] = []map[string][]string{{"bearerAuth": {}}, {"apiKeyAuth": {}}}
+			op["description"] = op["description"].(string) + ", or a restaurant API key with the " + scope + " scope"
+		}
+		if orderVersionRoutes[route.Method+" "+route.Path] {
+			versionedOperation(op, errorSchema)
+		}
+		if signedRoutes[route.Method+" "+route.Path] {
+			signedOperation(op)
+		}
+		paths[path][strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":       "Food delivery API",
+			"version":     buildInfo.Version,
+			"description": openAPIDescription,
+		},
+		"servers": []map[string]string{{"url": "/"}, {"url": "/v1"}, {"url": "/v2"}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]string{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKeyAuth": map[string]string{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+			},
+		},
+	}
+}
+
+// openAPIPath rewrites Echo's :param segments as {param} and lists them.
+func openAPIPath(path string) (string, []string) {
+	segments := strings.Split(path, "/")
+	var params []string
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			params = append(params, name)
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// versionedOperation documents If-Match on a route changing one order.
+func versionedOperation(op map[string]interface{}, errorSchema interface{}) {
+	params, _ := op["parameters"].([]map[string]interface{})
+	op["parameters"] = append(params, map[string]interface{}{
+		"name": headerIfMatch, "in": "header", "required": appConfig.OrderVersionRequired, "schema": map[string]string{"type": "string"},
+		"description": `The order's version as last seen, e.g. "3"; the ETag of responses about the order`,
+	})
+	errorBody := map[string]interface{}{"application/json": map[string]interface{}{"schema": errorSchema}}
+	responses := op["responses"].(map[string]interface{})
+	responses["409"] = map[string]interface{}{"description": "The order has changed since; the body gives its version", "content": errorBody}
+	if appConfig.OrderVersionRequired {
+		responses["428"] = map[string]interface{}{"description": "If-Match is missing", "content": errorBody}
+	}
+}
+
+// signedOperation documents the signature headers of a route that takes
+// them; see request_signing.go.
+func signedOperation(op map[string]interface{}) {
+	params, _ := op["parameters"].([]map[string]interface{})
+	for _, header := range []struct{ name, description string }{
+		{headerSignatureKey, "ID of the caller's signing key"},
+		{headerSignatureTimestamp, "Unix seconds when the request was signed"},
+		{headerSignatureNonce, "8 to 128 characters never sent with the key before"},
+		{headerSignature, "sha256= and the hex HMAC-SHA256 of the timestamp, nonce, method, path and hex SHA-256 of the body, joined by newlines"},
+	} {
+		params = append(params, map[string]interface{}{
+			"name": header.name, "in": "header", "required": appConfig.RequestSigningRequired, "schema": map[string]string{"type": "string"},
+			"description": header.description,
+		})
+	}
+	op["parameters"] = params
+}
+
+func (b *openAPIBuilder) operation(doc apiOperation, pathParams []string, errorSchema interface{}) map[string]interface{} {
+	op := map[string]interface{}{}
+	if doc.Summary != "" {
+		op["summary"] = doc.Summary
+	}
+	if doc.Tag != "" {
+		op["tags"] = []string{doc.Tag}
+	}
+
+	var params []map[string]interface{}
+	for _, name := range pathParams {
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "path", "required": true, "schema": map[string]string{"type": "string"},
+		})
+	}
+	for _, q := range doc.Query {
+		param := map[string]interface{}{
+			"name": q.Name, "in": "query", "required": q.Required, "schema": map[string]string{"type": q.Type},
+		}
+		if q.Description != "" {
+			param["description"] = q.Description
+		}
+		params = append(params, param)
+	}
+	if doc.Conditional {
+		params = append(params, map[string]interface{}{
+			"name": "If-None-Match", "in": "header", "schema": map[string]string{"type": "string"},
+			"description": "ETag of the response already held",
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	errorBody := map[string]interface{}{"application/json": map[string]interface{}{"schema": errorSchema}}
+	responses := map[string]interface{}{}
+
+	switch {
+	case doc.Request != nil:
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": b.schema(reflect.TypeOf(doc.Request))},
+			},
+		}
+	case doc.Form != nil:
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"multipart/form-data": map[string]interface{}{"schema": b.formSchema(reflect.TypeOf(doc.Form))},
+			},
+		}
+	}
+	if doc.Request != nil || doc.Form != nil {
+		responses["400"] = map[string]interface{}{"description": "Malformed request", "content": errorBody}
+		responses["422"] = map[string]interface{}{"description": "Validation failed", "content": errorBody}
+	}
+
+	if len(doc.Roles) > 0 {
+		op["security"] = []map[string][]string{{"bearerAuth": {}}}
+		op["description"] = "Roles: " + strings.Join(doc.Roles, ", ")
+		responses["401"] = map[string]interface{}{"description": "Missing or invalid token", "content": errorBody}
+		responses["403"] = map[string]interface{}{"description": "Not allowed for this caller", "content": errorBody}
+	}
+
+	success := map[string]interface{}{"description": "Success"}
+	switch {
+	case doc.Stream != "":
+		success["content"] = map[string]interface{}{doc.Stream: map[string]interface{}{"schema": map[string]string{"type": "string"}}}
+	case doc.ResponseV2 != nil:
+		success["description"] = "Success: the first schema under API version 1, the second under version 2"
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": map[string]interface{}{
+				"oneOf": []interface{}{b.schema(reflect.TypeOf(doc.Response)), b.schema(reflect.TypeOf(doc.ResponseV2))},
+			}},
+		}
+	case doc.Response != nil:
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": b.schema(reflect.TypeOf(doc.Response))},
+		}
+	}
+	status := doc.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	responses[strconv.Itoa(status)] = success
+	if doc.Conditional {
+		responses["304"] = map[string]interface{}{"description": "The response held, by its ETag, is current"}
+	}
+	op["responses"] = responses
+	return op
+}
+
+var timestampType = reflect.TypeOf(Timestamp{})
+
+// schema describes t as it marshals to JSON. Named structs are added to the
+// components once and referenced from then on.
+func (b *openAPIBuilder) schema(t reflect.Type) map[string]interface{} {
+	if t == timestampType || t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time", "nullable": true}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		if _, ok := b.schemas[t.Name()]; !ok {
+			// Reserve the name first so recursive types terminate.
+			b.schemas[t.Name()] = map[string]interface{}{}
+			b.schemas[t.Name()] = b.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func (b *openAPIBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	b.addFields(t, properties, &required)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addFields adds t's JSON fields to properties, flattening embedded structs
+// as encoding/json does.
+func (b *openAPIBuilder) addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct && fieldType != timestampType {
+			b.addFields(fieldType, properties, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := b.schema(field.Type)
+		if applyValidateTag(property, field.Tag.Get("validate"))["required"] {
+			*required = append(*required, name)
+		}
+		properties[name] = property
+	}
+}
+
+// formSchema describes a form struct by its form tags.
+func (b *openAPIBuilder) formSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("form")
+		if name == "" {
+			continue
+		}
+		property := b.schema(field.Type)
+		if format := field.Tag.Get("format"); format != "" {
+			property["format"] = format
+		}
+		if applyValidateTag(property, field.Tag.Get("validate"))["required"] {
+			required = append(required, name)
+		}
+		properties[name] = property
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// applyValidateTag carries the validate rules OpenAPI can express over to
+// property, and reports which rules were present.
+func applyValidateTag(property map[string]interface{}, tag string) map[string]bool {
+	present := map[string]bool{}
+	if tag == "" {
+		return present
+	}
+
+	kind, _ := property["type"].(string)
+	for _, rule := range strings.Split(tag, ",") {
+		if rule == "dive" {
+			// Later rules apply to the elements.
+			if items, ok := property["items"].(map[string]interface{}); ok {
+				property = items
+				kind, _ = property["type"].(string)
+			}
+			continue
+		}
+
+		name, param, _ := strings.Cut(rule, "=")
+		present[name] = true
+		n, numeric := strconv.ParseFloat(param, 64)
+		isNumber := numeric == nil
+
+		switch name {
+		case "oneof":
+			values := strings.Fields(param)
+			enum := make([]interface{}, len(values))
+			for i, v := range values {
+				enum[i] = v
+			}
+			property["enum"] = enum
+		case "uuid":
+			property["format"] = "uuid"
+		case "email":
+			property["format"] = "email"
+		case "url", "http_url":
+			property["format"] = "uri"
+		case "min", "gte", "gt", "max", "lte", "lt", "len":
+			if !isNumber {
+				continue
+			}
+			setBound(property, kind, name, n)
+		}
+	}
+	return present
+}
+
+func setBound(property map[string]interface{}, kind, rule string, n float64) {
+	lower := rule == "min" || rule == "gte" || rule == "gt" || rule == "len"
+	upper := rule == "max" || rule == "lte" || rule == "lt" || rule == "len"
+
+	switch kind {
+	case "string":
+		if lower {
+			property["minLength"] = int(n)
+		}
+		if upper {
+			property["maxLength"] = int(n)
+		}
+	case "array":
+		if lower {
+			property["minItems"] = int(n)
+		}
+		if upper {
+			property["maxItems"] = int(n)
+		}
+	case "integer", "number":
+		if lower {
+			property["minimum"] = n
+			if rule == "gt" {
+				property["exclusiveMinimum"] = true
+			}
+		}
+		if upper {
+			property["maximum"] = n
+			if rule == "lt" {
+				property["exclusiveMaximum"] = true
+			}
+		}
+	}
+}
+
+// swaggerUIPage loads Swagger UI from its CDN and points it at the spec.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Food delivery API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "/swagger/openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
+
+// registerOpenAPIRoutes serves the spec at /swagger/openapi.json and Swagger
+// UI at /swagger. It must be called after every other route is registered;
+// the spec is built on first request.
+func registerOpenAPIRoutes(e *echo.Echo) {
+	var once sync.Once
+	var spec map[string]interface{}
+
+	e.GET("/swagger/openapi.json", func(c echo.Context) error {
+		once.Do(func() {
+			spec = buildOpenAPISpec(e.Routes())
+		})
+		return c.JSON(http.StatusOK, spec)
+	})
+	e.GET("/swagger", func(c echo.Context) error {
+		return c.HTML(http.StatusOK, swaggerUIPage)
+	})
+}
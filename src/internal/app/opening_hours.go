@@ -0,0 +1,51 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+// OpeningHours is a daily window in "HH:MM" local time. A window whose close
+// is earlier than its open runs past midnight; equal values mean open all day.
+type OpeningHours struct {
+	Open  string `json:"open"`
+	Close string `json:"close"`
+}
+
+func (h OpeningHours) IsOpenAt(t time.Time) (bool, error) {
+	open, err := minutesOfDay(h.Open)
+	if err != nil {
+		return false, err
+	}
+	closing, err := minutesOfDay(h.Close)
+	if err != nil {
+		return false, err
+	}
+
+	now := t.In(appConfig.Location).Hour()*60 + t.In(appConfig.Location).Minute()
+	switch {
+	case open == closing:
+		return true, nil
+	case open < closing:
+		return now >= open && now < closing, nil
+	default:
+		return now >= open || now < closing, nil
+	}
+}
+
+func minutesOfDay(hhmm string) (int, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", hhmm)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// restaurantOpenAt treats restaurants without configured hours as always open.
+func restaurantOpenAt(r Restaurant, t time.Time) bool {
+	if r.OpeningHours == nil {
+		return true
+	}
+	open, err := r.OpeningHours.IsOpenAt(t)
+	return err == nil && open
+}
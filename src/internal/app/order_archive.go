@@ -0,0 +1,287 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"myproject/src/clock"
+)
+
+// Order archival. With ORDER_RETENTION set, orders that finished longer ago
+// than that are moved to cold storage every ORDER_ARCHIVE_INTERVAL, to keep
+// the order store small. ORDER_ARCHIVE_STORE picks where:
+//
+//   - file, the default: files under ORDER_ARCHIVE_DIR, which nothing
+//     serves.
+//   - s3: objects in ORDER_ARCHIVE_BUCKET, reached with the S3_* settings
+//     of the media store; the bucket should not be public.
+//
+// Each run writes the orders it archives as NDJSON files of up to
+// ORDER_ARCHIVE_BATCH_SIZE orders, notes in Redis which file holds each,
+// and only then deletes them, and what Redis keeps beside them, from the
+// store. An order that could not be deleted is archived again by the next
+// run, and the newer copy is the one looked up. Archived orders are gone from the API but for
+// GET /admin/orders/archive.
+
+const (
+	orderArchiveFile = "file"
+	orderArchiveS3   = "s3"
+
+	orderArchiveLockKey    = "orders:archive:lock"
+	orderArchiveIndexKey   = "orders:archive:index"
+	orderArchiveBatchesKey = "orders:archive:batches"
+
+	// orderArchiveBatchesKept is how many archive files are listed.
+	orderArchiveBatchesKept = 1000
+	orderArchiveScanCount   = 500
+
+	// minOrderRetention keeps orders in the store while tips, issues,
+	// closeouts and payouts may still read them.
+	minOrderRetention = 7 * 24 * time.Hour
+)
+
+var ordersArchived = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "orders_archived_total",
+	Help: "Orders moved from the order store to the archive.",
+})
+
+func init() {
+	prometheus.MustRegister(ordersArchived)
+}
+
+var orderArchive BlobStore
+
+// OrderArchiveBatch is one archive file.
+type OrderArchiveBatch struct {
+	Key        string    `json:"key"`
+	Orders     int       `json:"orders"`
+	ArchivedAt Timestamp `json:"archived_at"`
+}
+
+func validateOrderArchive() error {
+	if appConfig.OrderRetention == 0 {
+		return nil
+	}
+	if appConfig.OrderRetention < minOrderRetention {
+		return fmt.Errorf("ORDER_RETENTION must be at least %s", minOrderRetention)
+	}
+	switch appConfig.OrderArchiveStore {
+	case orderArchiveFile:
+		return nil
+	case orderArchiveS3:
+		if appConfig.S3Endpoint == "" || appConfig.OrderArchiveBucket == "" {
+			return fmt.Errorf("order archive %s needs S3_ENDPOINT and ORDER_ARCHIVE_BUCKET", orderArchiveS3)
+		}
+		if appConfig.S3AccessKeyID == "" || appConfig.S3SecretAccessKey == "" {
+			return fmt.Errorf("order archive %s needs S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY", orderArchiveS3)
+		}
+		return nil
+	}
+	return fmt.Errorf("order archive %q must be %s or %s", appConfig.OrderArchiveStore, orderArchiveFile, orderArchiveS3)
+}
+
+// openOrderArchive opens the store ORDER_ARCHIVE_STORE names. Archives are
+// never served to clients, so the stores' URLs go unused.
+func openOrderArchive() BlobStore {
+	if appConfig.OrderArchiveStore == orderArchiveS3 {
+		endpoint := strings.TrimRight(appConfig.S3Endpoint, "/")
+		return &S3BlobStore{
+			Endpoint:        endpoint,
+			Region:          appConfig.S3Region,
+			Bucket:          appConfig.OrderArchiveBucket,
+			AccessKeyID:     appConfig.S3AccessKeyID,
+			SecretAccessKey: appConfig.S3SecretAccessKey,
+			PublicURL:       endpoint + "/" + appConfig.OrderArchiveBucket,
+			Client:          &http.Client{Timeout: 5 * time.Minute},
+		}
+	}
+	return LocalBlobStore{Dir: appConfig.OrderArchiveDir}
+}
+
+// archivable reports whether order finished before cutoff.
+func archivable(order Order, cutoff time.Time) bool {
+	finished := order.UpdatedAt
+	if finished.IsZero() {
+		finished = order.CreatedAt
+	}
+	return terminalStatuses[order.Status] && !finished.IsZero() && finished.Before(cutoff)
+}
+
+// runOrderArchiver archives orders past retention every interval until ctx
+// is cancelled.
+func runOrderArchiver(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		archived, err := archiveOrders(ctx, interval)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("error archiving orders", "archived", archived, "error", err)
+		} else if archived > 0 {
+			slog.Info("orders archived", "archived", archived)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// archiveOrders walks the store once, archiving every order finished longer
+// than ORDER_RETENTION ago, and returns how many it archived. Only one
+// instance archives at a time.
+func archiveOrders(ctx context.Context, interval time.Duration) (int, error) {
+	locked, err := redisClient.SetNX(ctx, orderArchiveLockKey, 1, interval).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis error: %v", err)
+	}
+	if !locked {
+		return 0, nil
+	}
+	defer redisClient.Del(ctx, orderArchiveLockKey)
+
+	cutoff := clock.Now().Add(-appConfig.OrderRetention)
+	archived := 0
+	batch := make([]Order, 0, appConfig.OrderArchiveBatchSize)
+	var cursor uint64
+	for {
+		raw, next, err := repositories.Orders.Scan(ctx, cursor, orderArchiveScanCount)
+		if err != nil {
+			return archived, err
+		}
+		for _, data := range raw {
+			var order Order
+			if json.Unmarshal([]byte(data), &order) != nil || !archivable(order, cutoff) {
+				continue
+			}
+			batch = append(batch, order)
+			if len(batch) == cap(batch) {
+				n, err := archiveOrderBatch(ctx, batch)
+				archived += n
+				if err != nil {
+					return archived, err
+				}
+				batch = batch[:0]
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	if len(batch) > 0 {
+		n, err := archiveOrderBatch(ctx, batch)
+		archived += n
+		if err != nil {
+			return archived, err
+		}
+	}
+	return archived, nil
+}
+
+// archiveOrderBatch writes orders to one archive file, indexes them, and
+// deletes them from the store, returning how many were deleted.
+func archiveOrderBatch(ctx context.Context, orders []Order) (int, error) {
+	id, err := idGenerator.NewID()
+	if err != nil {
+		return 0, err
+	}
+	now := clock.Now().UTC()
+	key := regionMediaPath(fmt.Sprintf("orders/%s/%s.ndjson", now.Format(time.DateOnly), id))
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, order := range orders {
+		if err := enc.Encode(order); err != nil {
+			return 0, fmt.Errorf("failed to marshal order %s: %v", order.OrderID, err)
+		}
+	}
+	err = orderArchive.Put(ctx, key, "application/x-ndjson", buf.Bytes())
+	if err != nil {
+		return 0, fmt.Errorf("failed to write archive %s: %v", key, err)
+	}
+
+	index := make(map[string]interface{}, len(orders))
+	for _, order := range orders {
+		index[order.OrderID] = key
+	}
+	batchJSON, _ := json.Marshal(OrderArchiveBatch{Key: key, Orders: len(orders), ArchivedAt: Timestamp{Time: now}})
+	pipe := redisClient.TxPipeline()
+	pipe.HSet(ctx, orderArchiveIndexKey, index)
+	pipe.LPush(ctx, orderArchiveBatchesKey, batchJSON)
+	pipe.LTrim(ctx, orderArchiveBatchesKey, 0, orderArchiveBatchesKept-1)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("redis error: %v", err)
+	}
+
+	deleted := 0
+	for _, order := range orders {
+		err := repositories.Orders.Delete(ctx, order)
+		if err != nil {
+			slog.Warn("error deleting archived order, archiving it again next run", "order_id", order.OrderID, "archive", key, "error", err)
+			continue
+		}
+		deleted++
+	}
+	ordersArchived.Add(float64(deleted))
+	slog.Info("order archive written", "archive", key, "orders", len(orders), "deleted", deleted)
+	return deleted, nil
+}
+
+// getArchivedOrders serves GET /admin/orders/archive: with order_id, the
+// archived order and the file it is in; without, the latest archive files,
+// newest first.
+func getArchivedOrders(c echo.Context) error {
+	reqCtx := c.Request().Context()
+	orderID := c.QueryParam("order_id")
+	if orderID == "" {
+		raw, err := redisClient.LRange(reqCtx, orderArchiveBatchesKey, 0, -1).Result()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch archives"})
+		}
+		batches := make([]OrderArchiveBatch, 0, len(raw))
+		for _, data := range raw {
+			var batch OrderArchiveBatch
+			if json.Unmarshal([]byte(data), &batch) == nil {
+				batches = append(batches, batch)
+			}
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"archives": batches})
+	}
+
+	key, err := redisClient.HGet(reqCtx, orderArchiveIndexKey, orderID).Result()
+	if err == redis.Nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Archived order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch archived order"})
+	}
+
+	logger := requestLogger(c).With("order_id", orderID, "archive", key)
+	data, err := orderArchive.Get(reqCtx, key)
+	if err != nil {
+		logger.Error("error reading order archive", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch archived order"})
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var order Order
+		if json.Unmarshal(line, &order) == nil && order.OrderID == orderID {
+			return c.JSON(http.StatusOK, map[string]interface{}{"archive": key, "order": order})
+		}
+	}
+	logger.Error("archived order missing from its archive")
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch archived order"})
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// Bulk orders. POST /orders/bulk places many orders for one customer at
+// once, for corporate and catering customers. Every order is checked and
+// priced, and then has its stock and promo use reserved, before any is
+// created; if one fails, what the others reserved is given back and none is
+// placed. Only storing them can then fail an order on its own. The outbox
+// relay is woken once the last is stored, so their OrderCreated events are
+// published together, in one batch to the producer.
+
+// PlaceOrders places every one of orders for the calling customer, or none
+// of them. When none is placed the error's details give each order's result
+// under "orders", and its status is that of the first order to fail.
+func (s OrderService) PlaceOrders(ctx context.Context, logger *slog.Logger, claims *AuthClaims, orders []Order) ([]BulkOrderResult, error) {
+	results := make([]BulkOrderResult, len(orders))
+	prepared := make([]preparedOrder, len(orders))
+	var failure *serviceError
+	for i, order := range orders {
+		results[i].Index = i
+		p, err := s.prepareOrder(ctx, logger, claims, order)
+		if err != nil {
+			failure = cmp.Or(failure, rejectBulkOrder(&results[i], err))
+			continue
+		}
+		prepared[i] = p
+	}
+
+	if failure == nil {
+		for i, p := range prepared {
+			err := s.reserveOrder(ctx, logger, p)
+			if err != nil {
+				failure = rejectBulkOrder(&results[i], err)
+				for _, reserved := range prepared[:i] {
+					s.releaseOrder(ctx, logger, reserved)
+				}
+				break
+			}
+		}
+	}
+
+	if failure != nil {
+		for i := range results {
+			if results[i].Status == "" {
+				results[i].Status = BulkOrderNotPlaced
+			}
+		}
+		logger.Info("bulk order not placed", "orders", len(orders), "error", failure.Message)
+		return results, &serviceError{
+			Status:  failure.Status,
+			Message: "Orders could not be placed",
+			Details: map[string]interface{}{"orders": results},
+		}
+	}
+
+	created := 0
+	for i, p := range prepared {
+		order, err := s.placeOrder(ctx, logger, p, false)
+		if err != nil {
+			results[i].Status = BulkOrderFailed
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i] = BulkOrderResult{
+			Index:       i,
+			Status:      BulkOrderCreated,
+			OrderID:     order.OrderID,
+			OrderCode:   order.Code,
+			ScheduledAt: order.ScheduledAt,
+			Pricing:     order.Pricing,
+		}
+		created++
+	}
+	if created > 0 {
+		wakeOutboxRelay()
+	}
+
+	logger.Info("bulk order placed", "orders", len(orders), "created", created)
+	return results, nil
+}
+
+// rejectBulkOrder records err as the reason result's order was rejected,
+// returning it as a service error.
+func rejectBulkOrder(result *BulkOrderResult, err error) *serviceError {
+	var se *serviceError
+	if !errors.As(err, &se) {
+		se = serviceFailure(http.StatusInternalServerError, "Internal server error")
+	}
+	result.Status = BulkOrderRejected
+	result.Error = se.Message
+	result.Field = se.Field
+	result.Details = se.Details
+	return se
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Order chat. The customer and the rider carrying an order can message each
+// other about the delivery, such as where to meet or which gate to use. The
+// messages are kept with the order, run through the text filters like other
+// free text, and sent to both as "message" updates on the order's tracking
+// channel. Once the order is finished the chat is closed; its messages stay
+// readable until OrderChatRetention after the last.
+
+const trackingMessage = "message"
+
+type OrderMessageRequest struct {
+	Text string `json:"text" validate:"required,max=1000"`
+}
+
+// OrderMessage is one message in an order's chat.
+type OrderMessage struct {
+	ID      string `json:"id"`
+	OrderID string `json:"order_id"`
+	// SenderRole is customer or rider; SenderID is who they are.
+	SenderRole string    `json:"sender_role"`
+	SenderID   string    `json:"sender_id"`
+	Text       string    `json:"text"`
+	SentAt     Timestamp `json:"sent_at"`
+}
+
+func orderMessagesKey(orderID string) string {
+	return "order:" + orderID + ":messages"
+}
+
+// chatParticipant returns who claims are in the order's chat: the customer
+// who placed it or the rider carrying it. ok is false for anyone else.
+func chatParticipant(claims *AuthClaims, order Order) (string, string, bool) {
+	switch {
+	case claims == nil:
+		return "", "", false
+	case claims.Role == roleCustomer && order.CustomerID == claims.Subject:
+		return roleCustomer, claims.Subject, true
+	case claims.Role == roleRider && order.RiderID != "" && claims.ActsForRider(order.RiderID):
+		return roleRider, order.RiderID, true
+	}
+	return "", "", false
+}
+
+// sendOrderMessage serves POST /order/:id/message.
+func sendOrderMessage(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req OrderMessageRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+	claims := authClaims(c)
+	text, ok := cleanText(claims, "text", req.Text)
+	if !ok {
+		return validationFailed(c, "text", textRejectedMessage)
+	}
+
+	order, err := getOrder(ctx, c.Param("id"))
+	if err != nil && err != errOrderNotFound {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+	role, senderID, ok := chatParticipant(claims, order)
+	if err == errOrderNotFound || !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	}
+	if !featureEnabled(flagOrderChat, order.RestaurantID) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Chat is not available for this order"})
+	}
+	if terminalStatuses[order.Status] {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Chat is closed once the order is " + order.Status})
+	}
+
+	id, err := idGenerator.NewID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to send message"})
+	}
+	msg := OrderMessage{
+		ID:         id,
+		OrderID:    order.OrderID,
+		SenderRole: role,
+		SenderID:   senderID,
+		Text:       text,
+		SentAt:     timestampNow(),
+	}
+	msgJSON, _ := json.Marshal(msg)
+
+	key := orderMessagesKey(order.OrderID)
+	pipe := redisClient.TxPipeline()
+	pipe.RPush(ctx, key, msgJSON)
+	pipe.LTrim(ctx, key, -int64(appConfig.OrderChatMaxMessages), -1)
+	pipe.Expire(ctx, key, appConfig.OrderChatRetention)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		requestLogger(c).Error("error storing order message", "order_id", order.OrderID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to send message"})
+	}
+
+	update, _ := json.Marshal(TrackingUpdate{
+		Type:    trackingMessage,
+		OrderID: order.OrderID,
+		Message: &msg,
+		At:      msg.SentAt,
+	})
+	err = redisClient.Publish(ctx, orderTrackingChannel(order.OrderID), update).Err()
+	if err != nil {
+		// The message is stored; the other party sees it when they next
+		// list the chat.
+		requestLogger(c).Warn("error publishing order message", "order_id", order.OrderID, "error", err)
+	}
+
+	requestLogger(c).Info("order message sent", "order_id", order.OrderID, "sender_role", role, "message_id", msg.ID)
+	return c.JSON(http.StatusCreated, msg)
+}
+
+// listOrderMessages serves GET /order/:id/messages: the chat, oldest first.
+// Support staff can read it too.
+func listOrderMessages(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	claims := authClaims(c)
+	order, err := getOrder(ctx, c.Param("id"))
+	if err != nil && err != errOrderNotFound {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+	_, _, ok := chatParticipant(claims, order)
+	if err == errOrderNotFound || !(ok || claims.Role == roleAdmin) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	}
+
+	messages, err := orderMessages(ctx, order.OrderID)
+	if err != nil {
+		requestLogger(c).Error("error fetching order messages", "order_id", order.OrderID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch messages"})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"order_id": order.OrderID,
+		"closed":   terminalStatuses[order.Status],
+		"messages": messages,
+	})
+}
+
+func orderMessages(ctx context.Context, orderID string) ([]OrderMessage, error) {
+	raw, err := redisClient.LRange(ctx, orderMessagesKey(orderID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+
+	messages := make([]OrderMessage, 0, len(raw))
+	for _, data := range raw {
+		var msg OrderMessage
+		if json.Unmarshal([]byte(data), &msg) == nil {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/rng"
+)
+
+// Order codes look like "A7X-42": three characters from an alphabet without
+// look-alikes (0/O, 1/I/L, 2/Z, 5/S, 8/B) and two digits, so they survive
+// being read out over the phone.
+const (
+	orderCodeAlphabet = "ACDEFGHJKMNPQRTUVWXY34679"
+	orderCodeDigits   = "0123456789"
+
+	maxOrderCodeAttempts = 10
+)
+
+var errOrderCodeNotFound = errors.New("order code not found")
+
+func orderCodeKey(code string) string {
+	return "order-code:" + code
+}
+
+func generateOrderCode() string {
+	var b strings.Builder
+	for i := 0; i < 5; i++ {
+		if i == 3 {
+			b.WriteByte('-')
+		}
+		alphabet := orderCodeAlphabet
+		if i >= 3 {
+			alphabet = orderCodeDigits
+		}
+		b.WriteByte(alphabet[rng.IntN(len(alphabet))])
+	}
+	return b.String()
+}
+
+// reserveOrderCode claims a free code for orderID. Codes expire after
+// OrderCodeTTL so the small code space is recycled once orders are done.
+func reserveOrderCode(ctx context.Context, rdb redis.Cmdable, orderID string) (string, error) {
+	for attempt := 0; attempt < maxOrderCodeAttempts; attempt++ {
+		code := generateOrderCode()
+		ok, err := rdb.SetNX(ctx, orderCodeKey(code), orderID, appConfig.OrderCodeTTL).Result()
+		if err != nil {
+			return "", fmt.Errorf("failed to reserve order code: %v", err)
+		}
+		if ok {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("failed to allocate a unique order code after %d attempts", maxOrderCodeAttempts)
+}
+
+// releaseOrderCodeOf frees a code only while it is still orderID's, as it
+// may have expired and gone to another order since.
+var releaseOrderCodeOf = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func releaseOrderCode(ctx context.Context, rdb redis.Cmdable, code string) {
+	rdb.Del(ctx, orderCodeKey(code))
+}
+
+// normalizeOrderCode accepts codes as people type them: any case, with or
+// without the dash.
+func normalizeOrderCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	if len(code) == 5 {
+		code = code[:3] + "-" + code[3:]
+	}
+	return code
+}
+
+func getOrderByCode(ctx context.Context, code string) (Order, error) {
+	orderID, err := redisClient.Get(ctx, orderCodeKey(normalizeOrderCode(code))).Result()
+	if err == redis.Nil {
+		return Order{}, errOrderCodeNotFound
+	} else if err != nil {
+		return Order{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	order, err := getOrder(ctx, orderID)
+	if err == errOrderNotFound {
+		return Order{}, errOrderCodeNotFound
+	}
+	return order, err
+}
+
+// canViewOrder reports whether the caller is a party to the order. Riders
+// may look up orders not yet picked up, since the code is how they confirm
+// they are collecting the right one.
+func canViewOrder(c echo.Context, order Order) bool {
+	claims := authClaims(c)
+	switch claims.Role {
+	case roleAdmin:
+		return true
+	case roleCustomer:
+		return order.CustomerID == claims.Subject
+	case roleRestaurant:
+		return actsForRestaurant(c, order.RestaurantID)
+	case roleRider:
+		return order.RiderID == "" || actsForRider(c, order.RiderID)
+	}
+	return false
+}
+
+func getOrderByCodeHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	order, err := getOrderByCode(ctx, c.Param("code"))
+	if err == errOrderCodeNotFound || (err == nil && !canViewOrder(c, order)) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+
+	return c.JSON(http.StatusOK, order)
+}
+
+// getOrderHandler serves GET /order/:id to whoever may see the order. Its
+// version is sent as the ETag, for If-Match on the next change.
+func getOrderHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	order, err := getOrder(ctx, c.Param("id"))
+	if err == errOrderNotFound || (err == nil && !canViewOrder(c, order)) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+
+	tagOrderVersion(c, order.Version)
+	return c.JSON(http.StatusOK, order)
+}
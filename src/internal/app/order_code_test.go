@@ -0,0 +1,72 @@
+//go:build !integration
+
+package app
+
+import (
+	"context"
+	"testing"
+
+	"myproject/src/rng"
+)
+
+// seedRNG has the service draw from a generator seeded with seed until the
+// test ends.
+func seedRNG(t *testing.T, seed uint64) {
+	t.Helper()
+	previous := rng.Default
+	rng.Default = rng.NewSeeded(seed)
+	t.Cleanup(func() { rng.Default = previous })
+}
+
+func TestReserveOrderCodeSkipsTakenCodes(t *testing.T) {
+	ctx := context.Background()
+
+	seedRNG(t, 1295)
+	first, err := reserveOrderCode(ctx, redisClient, "order-code-first")
+	if err != nil {
+		t.Fatalf("reserving the first code: %v", err)
+	}
+	t.Cleanup(func() { releaseOrderCode(ctx, redisClient, first) })
+	if normalizeOrderCode(first) != first || len(first) != 6 {
+		t.Fatalf("code %q is not in the A7X-42 form", first)
+	}
+	next := generateOrderCode()
+
+	// The same seed draws first again, which is taken, so the second order
+	// gets the draw after it.
+	seedRNG(t, 1295)
+	second, err := reserveOrderCode(ctx, redisClient, "order-code-second")
+	if err != nil {
+		t.Fatalf("reserving the second code: %v", err)
+	}
+	t.Cleanup(func() { releaseOrderCode(ctx, redisClient, second) })
+	if second != next {
+		t.Errorf("second code = %q, want %q after %q", second, next, first)
+	}
+
+	owner, err := redisClient.Get(ctx, orderCodeKey(normalizeOrderCode(second[:3]+second[4:]))).Result()
+	if err != nil || owner != "order-code-second" {
+		t.Errorf("code %q is held by %q (%v), want order-code-second", second, owner, err)
+	}
+}
+
+func TestUUIDv7GeneratorRandomBitsFollowSeed(t *testing.T) {
+	// The first 8 bytes carry the time, which the seed has no say over; the
+	// rest, after the variant, come from rng.Default.
+	randomBits := func(t *testing.T, seed uint64) string {
+		t.Helper()
+		seedRNG(t, seed)
+		id, err := idGenerator.NewID()
+		if err != nil {
+			t.Fatalf("generating an ID: %v", err)
+		}
+		return id[20:]
+	}
+
+	if a, b := randomBits(t, 1), randomBits(t, 1); a != b {
+		t.Errorf("same seed gave %q and %q", a, b)
+	}
+	if a, b := randomBits(t, 1), randomBits(t, 2); a == b {
+		t.Errorf("seeds 1 and 2 both gave %q", a)
+	}
+}
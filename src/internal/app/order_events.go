@@ -0,0 +1,160 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/segmentio/kafka-go"
+
+	"myproject/src/clock"
+)
+
+// Order event audit log. Every lifecycle event the outbox relay publishes is
+// kept, once Kafka has acknowledged it, in an append-only log per order: a
+// hash of the events by ID and an index of them by when they occurred. An
+// event published twice, as the relay may, is kept once. The log is what GET
+// /order/:id/events returns, and what an admin replays an event from, for a
+// downstream consumer that lost it, with POST
+// /admin/orders/:id/events/:event_id/replay.
+//
+// A replayed event is published as it was, with its ID, so consumers that
+// remember events they have handled skip it; this service's own consumer
+// does for EventDedupTTL.
+
+// replayedAtHeader marks a replayed event with when it was replayed.
+const replayedAtHeader = "replayed-at"
+
+// AuditedEvent is a published event as the audit log keeps it.
+type AuditedEvent struct {
+	OrderEvent
+	Topic       string    `json:"topic"`
+	PublishedAt Timestamp `json:"published_at"`
+}
+
+func orderEventsKey(orderID string) string {
+	return "order:" + orderID + ":events"
+}
+
+func orderEventsIndexKey(orderID string) string {
+	return "order:" + orderID + ":events:index"
+}
+
+// recordPublishedEvents adds events, published just now, to their orders'
+// audit logs, leaving any already there as they are.
+func recordPublishedEvents(ctx context.Context, events []OrderEvent) error {
+	publishedAt := Timestamp{Time: clock.Now().UTC()}
+	pipe := redisClient.TxPipeline()
+	for _, event := range events {
+		record, err := json.Marshal(AuditedEvent{
+			OrderEvent:  event,
+			Topic:       orderEventRouter.writer(event.Type).Topic(),
+			PublishedAt: publishedAt,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode %s event: %v", event.Type, err)
+		}
+		pipe.HSetNX(ctx, orderEventsKey(event.OrderID), event.EventID, record)
+		pipe.ZAddNX(ctx, orderEventsIndexKey(event.OrderID), &redis.Z{Score: float64(event.OccurredAt.UnixMilli()), Member: event.EventID})
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// orderEvents returns the order's audit log, in the order the events
+// occurred.
+func orderEvents(ctx context.Context, orderID string) ([]AuditedEvent, error) {
+	ids, err := redisClient.ZRange(ctx, orderEventsIndexKey(orderID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	if len(ids) == 0 {
+		return []AuditedEvent{}, nil
+	}
+	records, err := redisClient.HMGet(ctx, orderEventsKey(orderID), ids...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+
+	events := make([]AuditedEvent, 0, len(records))
+	for i, record := range records {
+		data, ok := record.(string)
+		if !ok {
+			continue
+		}
+		var event AuditedEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return nil, fmt.Errorf("malformed audit record for event %s: %v", ids[i], err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// getOrderEvents serves GET /order/:id/events to the parties to the order.
+func getOrderEvents(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	order, err := getOrder(ctx, c.Param("id"))
+	if err == errOrderNotFound || (err == nil && !canViewOrder(c, order)) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+
+	events, err := orderEvents(c.Request().Context(), order.OrderID)
+	if err != nil {
+		requestLogger(c).Error("error reading order events", "order_id", order.OrderID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order events"})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"order_id": order.OrderID, "events": events})
+}
+
+// replayOrderEvent serves POST /admin/orders/:id/events/:event_id/replay,
+// publishing the event again to the topic its type goes to now.
+func replayOrderEvent(c echo.Context) error {
+	orderID, eventID := c.Param("id"), c.Param("event_id")
+	record, err := redisClient.HGet(c.Request().Context(), orderEventsKey(orderID), eventID).Result()
+	if err == redis.Nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Event not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch event"})
+	}
+	var audited AuditedEvent
+	if err := json.Unmarshal([]byte(record), &audited); err != nil {
+		requestLogger(c).Error("malformed audit record", "order_id", orderID, "event_id", eventID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch event"})
+	}
+
+	event := audited.OrderEvent
+	msg, err := orderEventMessage(event)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to encode event"})
+	}
+	replayedAt := clock.Now().UTC()
+	msg.Headers = append(msg.Headers, kafka.Header{Key: replayedAtHeader, Value: []byte(replayedAt.Format(time.RFC3339))})
+
+	w := orderEventRouter.writer(event.Type)
+	logger := requestLogger(c).With("order_id", orderID, "event_id", eventID, "type", event.Type, "topic", w.Topic())
+	err = publishMessage(c.Request().Context(), w, "replay", msg)
+	if err != nil {
+		logger.Error("error replaying order event", "error", err)
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": "Failed to publish event"})
+	}
+
+	logger.Info("order event replayed", "admin", authClaims(c).Subject)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"order_id":    orderID,
+		"event_id":    eventID,
+		"type":        event.Type,
+		"topic":       w.Topic(),
+		"replayed_at": Timestamp{Time: replayedAt},
+	})
+}
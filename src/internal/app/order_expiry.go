@@ -0,0 +1,268 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"myproject/src/clock"
+)
+
+// Order timeouts. Every OrderTimeoutInterval a sweep, run by one instance at
+// a time, looks for orders that have stalled:
+//
+//   - an order still "created" OrderExpiry after it was placed, because it was
+//     never paid or the restaurant never accepted it, is expired. Its money and
+//     stock go back through the OrderExpired handlers.
+//   - an accepted order still without a rider DeliveryEscalateAfter after it
+//     was queued for dispatch is escalated to support with a ticket.
+//
+// Either way an OrderTimedOut event says why, and the customer is told.
+
+const (
+	jobOrderExpiry = "order_expiry"
+
+	// orderExpiryKey scores each order awaiting acceptance by when it
+	// expires, in Unix milliseconds.
+	orderExpiryKey    = "orders:expiry"
+	orderTimeoutsLock = "orders:timeouts:lock"
+	// orderEscalatedUntilKey holds the dispatch queue score up to which
+	// waiting orders have been escalated.
+	orderEscalatedUntilKey = "orders:timeouts:escalated_until"
+	orderTimeoutsBatch     = 100
+
+	timeoutNotPaid     = "not_paid"
+	timeoutNotAccepted = "not_accepted"
+	timeoutNoRider     = "no_rider"
+
+	timelineDeliveryEscalated = "delivery_escalated"
+)
+
+var orderTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "order_timeouts_total",
+	Help: "Orders that stalled, by reason: not_paid, not_accepted or no_rider.",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(orderTimeouts)
+}
+
+// timeoutTemplates is the customer notification for each kind of timeout.
+var timeoutTemplates = map[string]string{
+	timeoutNotPaid:     "order_timed_out_unpaid",
+	timeoutNotAccepted: "order_timed_out",
+	timeoutNoRider:     "delivery_delayed",
+}
+
+type orderExpiryPayload struct {
+	OrderID string `json:"order_id"`
+}
+
+// orderAcceptDeadline is the time by which the order must be accepted:
+// OrderExpiry after it was placed or, if it is scheduled, after it is
+// released to the restaurant.
+func orderAcceptDeadline(order Order) time.Time {
+	if release := releaseTime(order); !release.IsZero() {
+		return release.Add(appConfig.OrderExpiry)
+	}
+	return order.CreatedAt.Add(appConfig.OrderExpiry)
+}
+
+// scheduleOrderExpiry expires the order at its accept deadline.
+func scheduleOrderExpiry(ctx context.Context, rdb redis.Cmdable, order Order) {
+	deadline := orderAcceptDeadline(order)
+	err := rdb.ZAdd(ctx, orderExpiryKey, &redis.Z{Score: float64(deadline.UnixMilli()), Member: order.OrderID}).Err()
+	if err != nil {
+		slog.Error("error scheduling order expiry", "order_id", order.OrderID, "error", err)
+	}
+}
+
+// expireOrder runs order expiry jobs queued before the sweep took over.
+func expireOrder(ctx context.Context, job Job) error {
+	var payload orderExpiryPayload
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return fmt.Errorf("invalid order expiry payload: %w", err)
+	}
+	return timeOutOrder(ctx, payload.OrderID)
+}
+
+// timeOutOrder expires the order if it is still waiting to be accepted, with
+// an OrderTimedOut event alongside the OrderExpired one.
+func timeOutOrder(ctx context.Context, orderID string) error {
+	order, err := getOrder(ctx, orderID)
+	if err == errOrderNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	reason := timeoutNotAccepted
+	order.StatusReason = "The restaurant did not accept the order in time"
+	if order.PaymentStatus != paymentPaid {
+		reason = timeoutNotPaid
+		order.StatusReason = "The order was not paid in time"
+	}
+
+	events, err := applyTransition(ctx, &order, "expired", "created")
+	if err == errInvalidTransition {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	timedOut := newOrderEvent(ctx, eventOrderTimedOut, order)
+	timedOut.Reason = reason
+	err = saveOrder(ctx, repositories.Orders, &order, append(events, timedOut)...)
+	if err != nil {
+		return err
+	}
+
+	orderTimeouts.WithLabelValues(reason).Inc()
+	slog.Info("order expired", "order_id", order.OrderID, "reason", reason)
+	return nil
+}
+
+// escalateUndispatched opens a support ticket for an accepted order no rider
+// has taken, unless it has been escalated already.
+func escalateUndispatched(ctx context.Context, orderID string, queuedAt time.Time) error {
+	order, err := getOrder(ctx, orderID)
+	if err == errOrderNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if order.Status != "accepted" || order.RiderID != "" || order.HasTimelineEvent(timelineDeliveryEscalated) {
+		return nil
+	}
+
+	waited := clock.Since(queuedAt).Round(time.Second)
+	ticket, err := newTicket(ticketSourceDeliveryEscalation, order, "No rider for order "+order.OrderID,
+		fmt.Sprintf("Order %s has waited %s for a rider since it was accepted.", order.OrderID, waited), 0)
+	if err != nil {
+		return err
+	}
+	pipe := redisClient.TxPipeline()
+	queueTicket(ctx, pipe, ticket)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	order.Timeline = append(order.Timeline, TimelineEvent{Event: timelineDeliveryEscalated, At: timestampNow()})
+	timedOut := newOrderEvent(ctx, eventOrderTimedOut, order)
+	timedOut.Reason = timeoutNoRider
+	err = saveOrder(ctx, repositories.Orders, &order, timedOut)
+	if err != nil {
+		return err
+	}
+
+	orderTimeouts.WithLabelValues(timeoutNoRider).Inc()
+	slog.Warn("undispatched order escalated", "order_id", order.OrderID, "ticket_id", ticket.ID, "waited", waited)
+	return nil
+}
+
+// runOrderTimeouts sweeps for stalled orders every interval until ctx is
+// cancelled.
+func runOrderTimeouts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := sweepOrderTimeouts(ctx, interval)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("order timeout sweep failed", "error", err)
+		}
+	}
+}
+
+// sweepOrderTimeouts expires orders past their deadline and escalates those
+// waiting too long for a rider. Only one instance sweeps at a time. An order
+// that fails is left for the next sweep.
+func sweepOrderTimeouts(ctx context.Context, interval time.Duration) error {
+	locked, err := redisClient.SetNX(ctx, orderTimeoutsLock, 1, interval).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if !locked {
+		return nil
+	}
+	defer redisClient.Del(ctx, orderTimeoutsLock)
+
+	now := clock.Now()
+	expired, err := redisClient.ZRangeByScore(ctx, orderExpiryKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: orderTimeoutsBatch,
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	for _, orderID := range expired {
+		err := timeOutOrder(ctx, orderID)
+		if err != nil {
+			slog.Error("error expiring order", "order_id", orderID, "error", err)
+			continue
+		}
+		redisClient.ZRem(ctx, orderExpiryKey, orderID)
+	}
+
+	if appConfig.DeliveryEscalateAfter <= 0 {
+		return nil
+	}
+	// dispatch:pending is scored by when each order was queued, in seconds.
+	// Escalated orders stay in it until a rider is found, so the sweep
+	// carries on from where the last one got to rather than reading them
+	// every time.
+	from, err := redisClient.Get(ctx, orderEscalatedUntilKey).Result()
+	if err == redis.Nil {
+		from = "-inf"
+	} else if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	waiting, err := redisClient.ZRangeByScoreWithScores(ctx, dispatchPendingKey, &redis.ZRangeBy{
+		Min:   from,
+		Max:   strconv.FormatInt(now.Add(-appConfig.DeliveryEscalateAfter).Unix(), 10),
+		Count: orderTimeoutsBatch,
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	for _, z := range waiting {
+		orderID, _ := z.Member.(string)
+		err := escalateUndispatched(ctx, orderID, time.Unix(int64(z.Score), 0))
+		if err != nil {
+			// Resume here next time.
+			slog.Error("error escalating undispatched order", "order_id", orderID, "error", err)
+			break
+		}
+		from = strconv.FormatInt(int64(z.Score), 10)
+	}
+	return redisClient.Set(ctx, orderEscalatedUntilKey, from, 0).Err()
+}
+
+// notifyOrderTimedOut tells the customer their order stalled and what
+// happens next.
+func notifyOrderTimedOut(ctx context.Context, event OrderEvent) error {
+	template, ok := timeoutTemplates[event.Reason]
+	if !ok {
+		return permanent(fmt.Errorf("unknown timeout reason %q", event.Reason))
+	}
+	// A delay is news for whoever waits for the food; a cancellation is
+	// for whoever paid.
+	if event.Reason == timeoutNoRider {
+		return notifyTracking(ctx, event, template, "", nil)
+	}
+	return notifyParty(ctx, event, "customer", event.CustomerID, template, nil)
+}
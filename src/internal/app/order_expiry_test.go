@@ -0,0 +1,87 @@
+//go:build !integration
+
+package app
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestOrderExpiry(t *testing.T) {
+	customer := testToken(t, AuthClaims{Role: roleCustomer, RegisteredClaims: jwt.RegisteredClaims{Subject: "expiry-customer"}})
+	place := func(t *testing.T) string {
+		t.Helper()
+		var placed struct {
+			OrderID string `json:"order_id"`
+		}
+		call(t, http.MethodPost, "/order", customer, map[string]interface{}{
+			"restaurant_id": testRestaurantID,
+			"items":         []map[string]interface{}{{"menu_id": "1", "quantity": 1}},
+		}, &placed, http.StatusOK)
+		return placed.OrderID
+	}
+	sweep := func(t *testing.T) {
+		t.Helper()
+		if err := sweepOrderTimeouts(context.Background(), time.Second); err != nil {
+			t.Fatalf("sweeping order timeouts: %v", err)
+		}
+	}
+	status := func(t *testing.T, orderID string) Order {
+		t.Helper()
+		order, err := getOrder(context.Background(), orderID)
+		if err != nil {
+			t.Fatalf("fetching order: %v", err)
+		}
+		return order
+	}
+
+	tests := []struct {
+		name   string
+		pay    bool
+		reason string
+	}{
+		{name: "unpaid", reason: "The order was not paid in time"},
+		{name: "not accepted", pay: true, reason: "The restaurant did not accept the order in time"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			orderID := place(t)
+			if tc.pay {
+				call(t, http.MethodPost, "/order/pay", customer, PayOrderRequest{OrderID: orderID, PaymentToken: "tok_visa"}, nil, http.StatusOK)
+			}
+
+			testClock.Advance(appConfig.OrderExpiry - time.Second)
+			sweep(t)
+			if order := status(t, orderID); order.Status != "created" {
+				t.Fatalf("status a second before the deadline = %q, want created", order.Status)
+			}
+
+			testClock.Advance(time.Second)
+			sweep(t)
+			order := status(t, orderID)
+			if order.Status != "expired" {
+				t.Fatalf("status at the deadline = %q, want expired", order.Status)
+			}
+			if order.StatusReason != tc.reason {
+				t.Errorf("reason = %q, want %q", order.StatusReason, tc.reason)
+			}
+		})
+	}
+
+	t.Run("accepted", func(t *testing.T) {
+		restaurant := testToken(t, AuthClaims{Role: roleRestaurant, RestaurantID: testRestaurantID})
+		orderID := place(t)
+		call(t, http.MethodPost, "/order/pay", customer, PayOrderRequest{OrderID: orderID, PaymentToken: "tok_visa"}, nil, http.StatusOK)
+		call(t, http.MethodPost, "/restaurant/order/accept", restaurant, AcceptOrderRequest{OrderID: orderID, RestaurantID: testRestaurantID, PrepMinutes: 5}, nil, http.StatusOK)
+
+		testClock.Advance(appConfig.OrderExpiry)
+		sweep(t)
+		if order := status(t, orderID); order.Status != "accepted" {
+			t.Errorf("status after the deadline = %q, want accepted", order.Status)
+		}
+	})
+}
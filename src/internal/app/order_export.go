@@ -0,0 +1,262 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/handlers"
+)
+
+const (
+	exportScanCount = 500
+
+	// exportCursorTrailer carries, after the body, the cursor to pass back
+	// to carry on from where the export stopped; it is empty once every
+	// order has been sent. A cursor of 0 starts from the beginning.
+	exportCursorTrailer = "X-Export-Cursor"
+
+	mimeNDJSON = "application/x-ndjson"
+)
+
+// exportSlots caps the exports this instance runs at once.
+var exportSlots chan struct{}
+
+func setupExportSlots() {
+	exportSlots = make(chan struct{}, max(appConfig.ExportMaxConcurrent, 1))
+}
+
+// exportFilter picks the orders to export: those in status, if set, placed
+// in [from, to), where either end may be open.
+type exportFilter struct {
+	status   string
+	from, to time.Time
+}
+
+func (f exportFilter) matches(raw string) bool {
+	if f.status == "" && f.from.IsZero() && f.to.IsZero() {
+		return true
+	}
+	var order struct {
+		Status    string    `json:"status"`
+		CreatedAt Timestamp `json:"created_at"`
+	}
+	if json.Unmarshal([]byte(raw), &order) != nil {
+		return false
+	}
+	if f.status != "" && order.Status != f.status {
+		return false
+	}
+	if !f.from.IsZero() && order.CreatedAt.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && !order.CreatedAt.Before(f.to) {
+		return false
+	}
+	return true
+}
+
+// parseExportTime reads an RFC 3339 time or a date, taken as the start of
+// that day in the service's time zone.
+func parseExportTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, value, appConfig.Location)
+}
+
+// exportOrders serves GET /admin/orders/export, streaming stored orders
+// placed between from and to, optionally filtered by status. Orders are read
+// from Redis a batch at a time and written out as stored, and the next batch
+// is only read once the client has taken the last, so memory use stays flat
+// however many orders there are and however slowly they are read.
+//
+// By default the body is one JSON array. With format=ndjson, or an Accept of
+// application/x-ndjson, it is one order per line, and limit and cursor page
+// through the orders: the export stops after the batch that reaches limit,
+// and the X-Export-Cursor trailer says where to resume. A failure mid-stream
+// leaves the cursor at the start of the batch being sent, so resuming may
+// repeat a few orders but never skips any. As with any SCAN, an order written
+// during the export may be missed or, rarely, appear twice.
+//
+// Each instance runs at most ExportMaxConcurrent exports and sends each at
+// no more than ExportRowsPerSecond orders a second.
+func exportOrders(c echo.Context) error {
+	filter := exportFilter{status: c.QueryParam("status")}
+	for _, bound := range []struct {
+		name string
+		into *time.Time
+	}{{"from", &filter.from}, {"to", &filter.to}} {
+		raw := c.QueryParam(bound.name)
+		if raw == "" {
+			continue
+		}
+		t, err := parseExportTime(raw)
+		if err != nil {
+			return validationFailed(c, bound.name, "must be an RFC 3339 time or a date")
+		}
+		*bound.into = t
+	}
+	if !filter.from.IsZero() && !filter.to.IsZero() && !filter.to.After(filter.from) {
+		return validationFailed(c, "to", "must be after from")
+	}
+
+	ndjson := c.QueryParam("format") == "ndjson" || strings.Contains(c.Request().Header.Get(echo.HeaderAccept), mimeNDJSON)
+	var cursor uint64
+	limit := 0
+	if ndjson {
+		var err error
+		if raw := c.QueryParam("cursor"); raw != "" {
+			cursor, err = strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				return validationFailed(c, "cursor", "is not a cursor from a previous export")
+			}
+		}
+		if raw := c.QueryParam("limit"); raw != "" {
+			limit, err = strconv.Atoi(raw)
+			if err != nil || limit < 1 {
+				return validationFailed(c, "limit", "must be a positive integer")
+			}
+		}
+	}
+
+	select {
+	case exportSlots <- struct{}{}:
+		defer func() { <-exportSlots }()
+	default:
+		c.Response().Header().Set("Retry-After", "30")
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "Too many exports running, try again later"})
+	}
+
+	if ndjson {
+		return exportOrdersNDJSON(c, filter, cursor, limit)
+	}
+	return exportOrdersJSON(c, filter)
+}
+
+func exportOrdersJSON(c echo.Context, filter exportFilter) error {
+	logger := requestLogger(c)
+	stream, err := handlers.StartJSONArrayStream(c, `{"orders":[`)
+	if err != nil {
+		return err
+	}
+
+	exported := 0
+	_, err = scanExportBatches(c, filter, 0, 0, func(batch []string) error {
+		for _, raw := range batch {
+			err := stream.Write(json.RawMessage(raw))
+			if err != nil {
+				return err
+			}
+		}
+		exported += len(batch)
+		return nil
+	})
+	if err != nil {
+		logger.Error("order export failed", "exported", exported, "error", err)
+		return nil
+	}
+
+	err = stream.Close("]}")
+	if err != nil {
+		logger.Error("error finishing order export", "error", err)
+	}
+	logger.Info("orders exported", "count", exported, "status", filter.status, "from", filter.from, "to", filter.to)
+	return nil
+}
+
+func exportOrdersNDJSON(c echo.Context, filter exportFilter, cursor uint64, limit int) error {
+	logger := requestLogger(c)
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, mimeNDJSON)
+	resp.Header().Set("Trailer", exportCursorTrailer)
+	resp.WriteHeader(http.StatusOK)
+
+	exported := 0
+	next, err := scanExportBatches(c, filter, cursor, limit, func(batch []string) error {
+		for _, raw := range batch {
+			_, err := resp.Write(append([]byte(raw), '\n'))
+			if err != nil {
+				return err
+			}
+		}
+		resp.Flush()
+		exported += len(batch)
+		return nil
+	})
+	if err == nil && next == 0 {
+		resp.Header().Set(exportCursorTrailer, "")
+	} else {
+		resp.Header().Set(exportCursorTrailer, strconv.FormatUint(next, 10))
+	}
+	if err != nil {
+		logger.Error("order export failed", "exported", exported, "resume_cursor", next, "error", err)
+		return nil
+	}
+	logger.Info("orders exported", "count", exported, "status", filter.status, "from", filter.from, "to", filter.to, "resume_cursor", next)
+	return nil
+}
+
+// scanExportBatches passes write each scanned batch of orders matching filter,
+// starting at cursor, until every order has been passed or, with a limit, at
+// least limit have. It returns the cursor to resume from, 0 if there is
+// nothing left; after an error that is the cursor of the failed batch.
+func scanExportBatches(c echo.Context, filter exportFilter, cursor uint64, limit int, write func(batch []string) error) (uint64, error) {
+	reqCtx := c.Request().Context()
+	started := time.Now()
+	sent := 0
+	for {
+		orders, next, err := repositories.Orders.Scan(reqCtx, cursor, exportScanCount)
+		if err != nil {
+			return cursor, err
+		}
+
+		batch := make([]string, 0, len(orders))
+		for _, raw := range orders {
+			if filter.matches(raw) {
+				batch = append(batch, raw)
+			}
+		}
+		if len(batch) > 0 {
+			err = write(batch)
+			if err != nil {
+				return cursor, err
+			}
+			sent += len(batch)
+		}
+
+		cursor = next
+		if cursor == 0 || (limit > 0 && sent >= limit) {
+			return cursor, nil
+		}
+		err = paceExport(c, started, sent)
+		if err != nil {
+			return cursor, err
+		}
+	}
+}
+
+// paceExport waits, if need be, until sending sent orders since started is
+// within ExportRowsPerSecond.
+func paceExport(c echo.Context, started time.Time, sent int) error {
+	if appConfig.ExportRowsPerSecond <= 0 {
+		return nil
+	}
+	due := started.Add(time.Duration(float64(sent) / float64(appConfig.ExportRowsPerSecond) * float64(time.Second)))
+	wait := time.Until(due)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-c.Request().Context().Done():
+		return c.Request().Context().Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,182 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Changing an order. Until the restaurant accepts it, a customer may add or
+// remove items or change quantities; the order is priced again and stock is
+// taken or given back for the difference. Only unpaid orders can change, as
+// a paid order's charge would no longer match it.
+
+// checkModifiable reports why order can no longer be changed, if it can't.
+func checkModifiable(order Order) error {
+	if order.Status != "created" {
+		return serviceFailure(http.StatusConflict, "Order cannot be changed in status "+order.Status)
+	}
+	if order.PaymentStatus == paymentPaid {
+		return serviceFailure(http.StatusConflict, "Order has been paid; cancel it and order again to change it")
+	}
+	return nil
+}
+
+// itemChanges lists how much more of each item after has than before, and
+// how much less.
+func itemChanges(before, after []OrderItem) (added, removed []OrderItem) {
+	delta := map[string]int{}
+	var ids []string
+	for _, item := range after {
+		if _, ok := delta[item.MenuID]; !ok {
+			ids = append(ids, item.MenuID)
+		}
+		delta[item.MenuID] += item.Quantity
+	}
+	for _, item := range before {
+		if _, ok := delta[item.MenuID]; !ok {
+			ids = append(ids, item.MenuID)
+		}
+		delta[item.MenuID] -= item.Quantity
+	}
+
+	for _, id := range ids {
+		switch {
+		case delta[id] > 0:
+			added = append(added, OrderItem{MenuID: id, Quantity: delta[id]})
+		case delta[id] < 0:
+			removed = append(removed, OrderItem{MenuID: id, Quantity: -delta[id]})
+		}
+	}
+	return added, removed
+}
+
+// ModifyOrder replaces the items of the customer's order and prices it
+// again, keeping its promo code and delivery details.
+func (s OrderService) ModifyOrder(ctx context.Context, logger *slog.Logger, claims *AuthClaims, req ModifyOrderRequest) (Order, error) {
+	order, err := fetchOrder(ctx, s.repos.Orders, req.OrderID)
+	if err != nil {
+		return order, err
+	}
+	if order.CustomerID != claims.Subject {
+		return order, serviceFailure(http.StatusNotFound, "Order not found")
+	}
+	if err := checkOrderVersion(ctx, order); err != nil {
+		return order, err
+	}
+	if err := checkModifiable(order); err != nil {
+		return order, err
+	}
+
+	changed := order
+	changed.Items = req.Items
+	checks, err := s.checkOrder(ctx, logger, changed)
+	if err != nil {
+		return order, err
+	}
+	if checks.MenuMissing {
+		return order, serviceFailure(http.StatusNotFound, "Restaurant not found")
+	}
+	for _, pe := range []*pricingError{checks.DeliveryErr, checks.PromoErr} {
+		if pe != nil {
+			return order, invalidField(pe.Field, pe.Message)
+		}
+	}
+
+	logger = logger.With("order_id", order.OrderID, "restaurant_id", order.RestaurantID)
+	pricing, err := priceOrder(changed, checks.Menu, checks.Delivery, checks.Promo)
+	var pe *pricingError
+	if errors.As(err, &pe) {
+		return order, invalidField(pe.Field, pe.Message)
+	} else if err != nil {
+		logger.Error("error pricing order", "error", err)
+		return order, serviceFailure(http.StatusInternalServerError, "Failed to price order")
+	}
+	split, err := orderFeeSplit(ctx, order.RestaurantID, checks.Restaurant, checks.Delivery)
+	if err != nil {
+		logger.Error("error splitting delivery fee", "error", err)
+		return order, serviceFailure(http.StatusInternalServerError, "Failed to price order")
+	}
+
+	changed.Pricing = &pricing
+	changed.TotalAmount = pricing.Total
+	changed.Currency = pricing.Currency
+	changed.FeeSplit = &split
+	checklist := buildPickupChecklist(changed, checks.Menu)
+	changed.PickupChecklist = &checklist
+	changed.Timeline = append(changed.Timeline, TimelineEvent{Event: timelineItemsChanged, At: timestampNow()})
+
+	added, removed := itemChanges(order.Items, changed.Items)
+	if len(added) > 0 {
+		err = reserveOrderStock(ctx, s.redis, Order{RestaurantID: order.RestaurantID, Items: added})
+		var se *stockError
+		if errors.As(err, &se) {
+			return order, &serviceError{
+				Status:  http.StatusConflict,
+				Message: "Item cannot be ordered",
+				Details: map[string]interface{}{
+					"detail":    se.Error(),
+					"menu_id":   se.MenuID,
+					"remaining": se.Remaining,
+				},
+			}
+		} else if err != nil {
+			return order, serviceFailure(http.StatusInternalServerError, "Failed to reserve items")
+		}
+	}
+
+	err = s.saveModifiedOrder(ctx, order, changed)
+	if err != nil {
+		if len(added) > 0 {
+			if err := releaseOrderStock(ctx, s.redis, Order{RestaurantID: order.RestaurantID, Items: added}); err != nil {
+				logger.Error("error releasing reserved stock", "error", err)
+			}
+		}
+		var se *serviceError
+		switch {
+		case errors.As(err, &se):
+			return order, err
+		case errors.Is(err, errOrderChanged):
+			return order, orderChangedFailure(ctx, order.OrderID)
+		}
+		logger.Error("error saving modified order", "error", err)
+		return order, serviceFailure(http.StatusInternalServerError, "Failed to update order")
+	}
+
+	if len(removed) > 0 {
+		if err := releaseOrderStock(ctx, s.redis, Order{RestaurantID: order.RestaurantID, Items: removed}); err != nil {
+			logger.Error("error returning stock of removed items", "error", err)
+		}
+	}
+
+	logger.Info("order modified", "items", changed.Items, "old_total", order.TotalAmount, "total_amount", changed.TotalAmount)
+	return changed, nil
+}
+
+// saveModifiedOrder stores changed in place of was with its OrderUpdated
+// event, provided the order is stored as it was and no payment has started
+// on it. A restaurant accepting the order, or the customer paying for it,
+// while it is being modified fails the save.
+func (s OrderService) saveModifiedOrder(ctx context.Context, was, changed Order) error {
+	_, err := updateOrder(ctx, s.repos.Orders, was.OrderID, func(current *Order) ([]OrderEvent, error) {
+		if err := checkModifiable(*current); err != nil {
+			return nil, err
+		}
+		if current.Version != was.Version {
+			return nil, errOrderChanged
+		}
+		paying, err := s.redis.Exists(ctx, paymentLockKey(was.OrderID)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("redis error: %v", err)
+		}
+		if paying > 0 {
+			return nil, serviceFailure(http.StatusConflict, "Order is being paid for")
+		}
+
+		*current = changed
+		return []OrderEvent{newOrderEvent(ctx, eventOrderUpdated, changed)}, nil
+	})
+	return err
+}
@@ -0,0 +1,252 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Refunds. Support gives money back on a paid order through
+// POST /order/:id/refund: all that is left of its payment, or the price of
+// some of its items. An item is refunded at its unit price, less its share
+// of the order's discount, plus tax at the order's rate; the delivery fee
+// and tip only go back with a full refund. Each refund is made through the
+// payment provider and recorded on the order with an OrderRefunded event
+// carrying the amount. The order stays paid, and open to more refunds, until
+// nothing of its payment is left, and then it is refunded. The automatic
+// refund of an order that cannot be fulfilled, refundOrderPayment, only
+// gives back what an earlier refund has not.
+//
+// Refunds of one order take its payment lock, so two cannot be worked out
+// against the same remainder. An order whose day the restaurant has closed
+// out cannot be refunded.
+
+const (
+	// refundReasonCancelled is the reason of automatic refunds; support
+	// gives one of those RefundOrderRequest allows.
+	refundReasonCancelled = "cancelled"
+
+	timelineRefunded = "refunded"
+)
+
+// errRefundInProgress is an automatic refund finding another refund of the
+// order under way; it is retried.
+var errRefundInProgress = errors.New("refund in progress")
+
+type RefundOrderRequest struct {
+	Reason string `json:"reason" validate:"required,oneof=missing_item wrong_item quality late_delivery damaged goodwill other"`
+	Note   string `json:"note,omitempty" validate:"max=1000"`
+	// Items, if given, are refunded; otherwise everything left is.
+	Items []RefundItem `json:"items,omitempty" validate:"max=100,dive"`
+}
+
+// refundOrder serves POST /order/:id/refund.
+func refundOrder(c echo.Context) error {
+	var req RefundOrderRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+	order, refund, err := issueRefund(c.Request().Context(), requestLogger(c), authClaims(c), c.Param("id"), req)
+	if err != nil {
+		return respondServiceError(c, err)
+	}
+
+	tagOrderVersion(c, order.Version)
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"order_id":        order.OrderID,
+		"payment_status":  order.PaymentStatus,
+		"refunded_amount": order.RefundedAmount,
+		"refund":          refund,
+	})
+}
+
+// issueRefund refunds req of the order through the payment provider and
+// records it.
+func issueRefund(ctx context.Context, logger *slog.Logger, claims *AuthClaims, orderID string, req RefundOrderRequest) (Order, Refund, error) {
+	order, err := fetchOrder(ctx, repositories.Orders, orderID)
+	if err != nil {
+		return order, Refund{}, err
+	}
+	if err := checkOrderVersion(ctx, order); err != nil {
+		return order, Refund{}, err
+	}
+	if order.PaymentStatus != paymentPaid {
+		return order, Refund{}, serviceFailure(http.StatusConflict, "Only paid orders can be refunded; this one is "+order.PaymentStatus)
+	}
+
+	locked, err := redisClient.SetNX(ctx, paymentLockKey(orderID), 1, paymentLockTTL).Result()
+	if err != nil {
+		return order, Refund{}, serviceFailure(http.StatusInternalServerError, "Failed to start refund")
+	}
+	if !locked {
+		return order, Refund{}, serviceFailure(http.StatusConflict, "Payment already in progress")
+	}
+	defer redisClient.Del(ctx, paymentLockKey(orderID))
+
+	// Read again under the lock, so the remainder is not one another refund
+	// has since taken from.
+	order, err = fetchOrder(ctx, repositories.Orders, orderID)
+	if err != nil {
+		return order, Refund{}, err
+	}
+	if err := checkOrderVersion(ctx, order); err != nil {
+		return order, Refund{}, err
+	}
+	if order.PaymentStatus != paymentPaid {
+		return order, Refund{}, serviceFailure(http.StatusConflict, "Only paid orders can be refunded; this one is "+order.PaymentStatus)
+	}
+	if err := checkOrderDayOpen(ctx, logger, order); err != nil {
+		return order, Refund{}, err
+	}
+	payment, err := getPayment(ctx, order.PaymentID)
+	if err != nil {
+		logger.Error("error fetching payment to refund", "order_id", orderID, "payment_id", order.PaymentID, "error", err)
+		return order, Refund{}, serviceFailure(http.StatusInternalServerError, "Failed to fetch payment")
+	}
+	if payment.Provider != paymentProvider.Name() {
+		return order, Refund{}, serviceFailure(http.StatusConflict, "Payment was taken by "+payment.Provider+" and cannot be refunded here")
+	}
+
+	currency := cmp.Or(payment.Currency, appConfig.PaymentCurrency)
+	remaining := toMinor(payment.Amount, currency) - toMinor(order.RefundedAmount, currency)
+	due := remaining
+	var items []RefundItem
+	if len(req.Items) > 0 {
+		items, err = priceRefundItems(order, req.Items)
+		if err != nil {
+			return order, Refund{}, err
+		}
+		due = 0
+		for _, item := range items {
+			due += toMinor(item.Amount, currency)
+		}
+		if due > remaining {
+			return order, Refund{}, invalidField("items", fmt.Sprintf("come to %.2f, more than the %.2f left to refund", due.amount(currency), remaining.amount(currency)))
+		}
+	}
+	amount := due.amount(currency)
+	if due <= 0 {
+		return order, Refund{}, serviceFailure(http.StatusConflict, "Nothing is left to refund")
+	}
+
+	refundID, err := idGenerator.NewID()
+	if err != nil {
+		return order, Refund{}, serviceFailure(http.StatusInternalServerError, "Failed to start refund")
+	}
+	refund := Refund{
+		ID:        refundID,
+		PaymentID: payment.ID,
+		Amount:    amount,
+		Reason:    req.Reason,
+		Note:      req.Note,
+		Items:     items,
+		IssuedBy:  claims.Subject,
+		CreatedAt: timestampNow(),
+	}
+
+	err = paymentProvider.Refund(ctx, payment.Reference, amount, "refund-"+refundID)
+	if errors.Is(err, errPaymentDeclined) {
+		logger.Warn("refund declined by provider", "order_id", orderID, "payment_id", payment.ID, "amount", amount, "error", err)
+		return order, Refund{}, serviceFailure(http.StatusUnprocessableEntity, "The payment provider declined the refund")
+	} else if err != nil {
+		logger.Error("error refunding payment", "order_id", orderID, "payment_id", payment.ID, "amount", amount, "error", err)
+		return order, Refund{}, serviceFailure(http.StatusBadGateway, "Payment provider unavailable")
+	}
+
+	// The money has gone back, so a write racing this one is retried
+	// rather than failing the request, as for payOrder.
+	for attempt := 1; ; attempt++ {
+		order, err = updateOrder(ctx, repositories.Orders, orderID, func(order *Order) ([]OrderEvent, error) {
+			return recordRefund(ctx, order, refund, payment, len(items) == 0), nil
+		})
+		if !errors.Is(err, errOrderChanged) || attempt == paymentUpdateAttempts {
+			break
+		}
+	}
+	if err != nil {
+		// Support settles it from the provider's record of the refund.
+		logger.Error("error recording refund", "order_id", orderID, "refund_id", refundID, "amount", amount, "error", err)
+		return order, Refund{}, serviceFailure(http.StatusInternalServerError, "Refund was made but could not be recorded; support will reconcile it")
+	}
+
+	if order.PaymentStatus == paymentRefunded {
+		payment.Status = paymentRefunded
+		if err := savePayment(ctx, &payment); err != nil {
+			logger.Error("error storing refunded payment", "payment_id", payment.ID, "error", err)
+		}
+	}
+	logger.Info("order refunded", "order_id", orderID, "refund_id", refundID, "amount", amount, "reason", req.Reason, "items", len(items))
+	return order, refund, nil
+}
+
+// recordRefund adds refund to order, marking it refunded if it is final or
+// nothing of payment is left, and returns its OrderRefunded event.
+func recordRefund(ctx context.Context, order *Order, refund Refund, payment Payment, final bool) []OrderEvent {
+	currency := cmp.Or(payment.Currency, appConfig.PaymentCurrency)
+	refunded := toMinor(order.RefundedAmount, currency) + toMinor(refund.Amount, currency)
+	order.Refunds = append(order.Refunds, refund)
+	order.RefundedAmount = refunded.amount(currency)
+	if final || refunded >= toMinor(payment.Amount, currency) {
+		order.PaymentStatus = paymentRefunded
+	}
+	order.Timeline = append(order.Timeline, TimelineEvent{Event: timelineRefunded, At: refund.CreatedAt})
+	event := newOrderEvent(ctx, eventOrderRefunded, *order)
+	event.Reason = refund.Reason
+	event.RefundAmount = refund.Amount
+	return []OrderEvent{event}
+}
+
+// priceRefundItems prices each of items as the order charged for it, or
+// reports the first that is not on the order, or is refunded more times
+// than it was ordered.
+func priceRefundItems(order Order, items []RefundItem) ([]RefundItem, error) {
+	pricing := order.Pricing
+	if pricing == nil {
+		return nil, invalidField("items", "cannot be refunded one by one on an order without a price breakdown; refund it in full")
+	}
+
+	ordered := map[string]PricedItem{}
+	left := map[string]int{}
+	for _, item := range pricing.Items {
+		ordered[item.MenuID] = item
+		left[item.MenuID] += item.Quantity
+	}
+	for _, refund := range order.Refunds {
+		for _, item := range refund.Items {
+			left[item.MenuID] -= item.Quantity
+		}
+	}
+
+	// Each item bears the discount in proportion to its share of the
+	// subtotal, and tax is charged on what is left.
+	currency := pricing.Currency
+	if currency == "" {
+		currency = appConfig.PaymentCurrency
+	}
+	subtotal := toMinor(pricing.Subtotal, currency)
+	discount := toMinor(pricing.Discount, currency)
+	priced := make([]RefundItem, len(items))
+	for i, item := range items {
+		orderedItem, ok := ordered[item.MenuID]
+		if !ok {
+			return nil, invalidField(fmt.Sprintf("items[%d].menu_id", i), "is not on this order")
+		}
+		left[item.MenuID] -= item.Quantity
+		if left[item.MenuID] < 0 {
+			return nil, invalidField(fmt.Sprintf("items[%d].quantity", i), "is more than is left of the item to refund")
+		}
+
+		line := toMinor(orderedItem.UnitPrice, currency) * minorUnits(item.Quantity)
+		if subtotal > 0 {
+			line -= minorUnits(int64(discount) * int64(line) / int64(subtotal))
+		}
+		line += line.times(pricing.TaxRate)
+		priced[i] = RefundItem{MenuID: item.MenuID, Quantity: item.Quantity, Amount: line.amount(currency)}
+	}
+	return priced, nil
+}
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Order status polling, for clients that cannot hold a stream open. A plain
+// request returns the status at once; with wait it is held until the status
+// changes or wait runs out, whichever is first. It listens on the same
+// tracking channel as the order stream.
+
+const maxStatusWait = 60 * time.Second
+
+type OrderStatusResponse struct {
+	OrderID   string    `json:"order_id"`
+	Status    string    `json:"status"`
+	RiderID   string    `json:"rider_id,omitempty"`
+	Changed   bool      `json:"changed"`
+	UpdatedAt Timestamp `json:"updated_at"`
+}
+
+// OrderStatusResponseV2 is the status under API version 2, with how the
+// order got there and what it costs.
+type OrderStatusResponseV2 struct {
+	OrderStatusResponse
+	StatusReason  string          `json:"status_reason,omitempty"`
+	PaymentStatus string          `json:"payment_status"`
+	History       []TimelineEvent `json:"history"`
+	Pricing       *PriceBreakdown `json:"pricing,omitempty"`
+}
+
+// orderStatusResponse is the order's status in the request's API version.
+// The response is tagged with the order's version, for If-Match.
+func orderStatusResponse(c echo.Context, order Order, changed bool) interface{} {
+	tagOrderVersion(c, order.Version)
+	resp := OrderStatusResponse{
+		OrderID:   order.OrderID,
+		Status:    order.Status,
+		RiderID:   order.RiderID,
+		Changed:   changed,
+		UpdatedAt: order.UpdatedAt,
+	}
+	if apiVersion(c) < apiVersion2 {
+		return resp
+	}
+	history := order.Timeline
+	if history == nil {
+		history = []TimelineEvent{}
+	}
+	return OrderStatusResponseV2{
+		OrderStatusResponse: resp,
+		StatusReason:        order.StatusReason,
+		PaymentStatus:       order.PaymentStatus,
+		History:             history,
+		Pricing:             order.Pricing,
+	}
+}
+
+// getOrderStatus serves GET /order/:id/status. wait (e.g. 30s, at most 60s)
+// long-polls for a change; since names the status the client last saw, so a
+// change made between two polls is returned straight away rather than
+// waited past.
+func getOrderStatus(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var wait time.Duration
+	if raw := c.QueryParam("wait"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 || d > maxStatusWait {
+			return validationFailed(c, "wait", "must be a duration between 0s and "+maxStatusWait.String())
+		}
+		wait = d
+	}
+
+	order, err := getOrder(ctx, c.Param("id"))
+	if err == errOrderNotFound || (err == nil && order.CustomerID != authClaims(c).Subject) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+
+	since := c.QueryParam("since")
+	if since == "" {
+		since = order.Status
+	}
+	if wait == 0 || order.Status != since || terminalStatuses[order.Status] {
+		return c.JSON(http.StatusOK, orderStatusResponse(c, order, order.Status != since))
+	}
+
+	// As with the stream, subscribe before re-reading the order so a change
+	// in between is not missed.
+	sub := redisClient.Subscribe(ctx, regionKey(orderTrackingChannel(order.OrderID)))
+	defer sub.Close()
+	_, err = sub.Receive(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to subscribe to order updates"})
+	}
+
+	order, err = getOrder(ctx, order.OrderID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+	if order.Status != since {
+		return c.JSON(http.StatusOK, orderStatusResponse(c, order, true))
+	}
+
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timeout.C:
+			return c.JSON(http.StatusOK, orderStatusResponse(c, order, false))
+		case msg, ok := <-messages:
+			if !ok {
+				return c.JSON(http.StatusOK, orderStatusResponse(c, order, false))
+			}
+
+			// Rider positions arrive on the same channel without changing
+			// the status; keep waiting through them.
+			var update TrackingUpdate
+			if err := json.Unmarshal([]byte(msg.Payload), &update); err != nil || update.Status == "" || update.Status == since {
+				continue
+			}
+
+			latest, err := getOrder(ctx, order.OrderID)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+			}
+			if latest.Status != since {
+				return c.JSON(http.StatusOK, orderStatusResponse(c, latest, true))
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const maxOrderIDAttempts = 3
+
+var errOrderNotFound = errors.New("order not found")
+
+var errOrderExists = errors.New("an order with that id already exists")
+
+var errInvalidTransition = errors.New("invalid order status transition")
+
+var errOrderChanged = errors.New("order changed while it was being updated")
+
+func orderKey(orderID string) string {
+	return "order:" + orderID
+}
+
+// insertOrder gives the order an order code and, unless it has one, a fresh
+// ID, and stores it together with its OrderCreated event, leaving the
+// outbox relay to be woken. An ID collision never overwrites an existing
+// order: the code is released and a new ID generated and the write retried,
+// or, for an order that came with its ID, errOrderExists returned.
+func insertOrder(ctx context.Context, rdb redis.Cmdable, orders OrderRepository, order *Order) error {
+	chosenID := order.OrderID
+	for attempt := 0; attempt < maxOrderIDAttempts; attempt++ {
+		id := chosenID
+		var err error
+		if id == "" {
+			id, err = idGenerator.NewID()
+			if err != nil {
+				return err
+			}
+		}
+		order.OrderID = id
+		order.Version = 1
+		touch(&order.CreatedAt, &order.UpdatedAt)
+
+		order.Code, err = reserveOrderCode(ctx, rdb, id)
+		if err != nil {
+			return err
+		}
+
+		stored, err := orders.Create(ctx, *order, newOrderEvent(ctx, eventOrderCreated, *order))
+		if err != nil {
+			releaseOrderCode(ctx, rdb, order.Code)
+			return err
+		}
+		if stored {
+			return nil
+		}
+		releaseOrderCode(ctx, rdb, order.Code)
+		if chosenID != "" {
+			return errOrderExists
+		}
+	}
+	return fmt.Errorf("failed to allocate a unique order id after %d attempts", maxOrderIDAttempts)
+}
+
+func getOrder(ctx context.Context, orderID string) (Order, error) {
+	return repositories.Orders.Get(ctx, orderID)
+}
+
+// saveOrder stores the order as its next version and queues events in the
+// outbox in one transaction, failing with errOrderChanged if anything else
+// has written the order since it was read.
+func saveOrder(ctx context.Context, orders OrderRepository, order *Order, events ...OrderEvent) error {
+	// Orders stored before timestamps existed were created when their
+	// timeline says.
+	if order.CreatedAt.IsZero() {
+		for _, e := range order.Timeline {
+			if e.Event == timelineCreated {
+				order.CreatedAt = e.At
+			}
+		}
+	}
+	next := *order
+	next.Version++
+	touch(&next.CreatedAt, &next.UpdatedAt)
+	err := orders.Save(ctx, next, events...)
+	if err != nil {
+		return err
+	}
+	*order = next
+
+	if len(events) > 0 {
+		wakeOutboxRelay()
+	}
+	return nil
+}
+
+// updateOrder applies change to the order as stored and saves it with the
+// events change returns, failing with errOrderChanged if anything else
+// writes the order meanwhile.
+func updateOrder(ctx context.Context, orders OrderRepository, orderID string, change func(order *Order) ([]OrderEvent, error)) (Order, error) {
+	var updated Order
+	events := 0
+	err := orders.Update(ctx, orderID, func(order *Order) ([]OrderEvent, error) {
+		changed, err := change(order)
+		if err != nil {
+			return nil, err
+		}
+		order.Version++
+		touch(&order.CreatedAt, &order.UpdatedAt)
+		updated, events = *order, len(changed)
+		return changed, nil
+	})
+	if err != nil {
+		return Order{}, err
+	}
+
+	if events > 0 {
+		wakeOutboxRelay()
+	}
+	return updated, nil
+}
+
+// transitionOrder moves order to status to, provided it is currently in one of
+// the from statuses, records the change on the timeline and queues the
+// matching lifecycle event.
+func transitionOrder(ctx context.Context, orders OrderRepository, order *Order, to string, from ...string) error {
+	events, err := applyTransition(ctx, order, to, from...)
+	if err != nil {
+		return err
+	}
+	return saveOrder(ctx, orders, order, events...)
+}
+
+// applyTransition is transitionOrder without the save: it changes order and
+// returns the events to store with it.
+func applyTransition(ctx context.Context, order *Order, to string, from ...string) ([]OrderEvent, error) {
+	allowed := false
+	for _, status := range from {
+		if order.Status == status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, errInvalidTransition
+	}
+
+	order.Status = to
+	order.Timeline = append(order.Timeline, TimelineEvent{Event: to, At: timestampNow()})
+
+	var events []OrderEvent
+	if eventType, ok := statusEvents[to]; ok {
+		events = append(events, newOrderEvent(ctx, eventType, *order))
+	}
+	return events, nil
+}
@@ -0,0 +1,220 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+// Tips. A customer may tip when placing an order, and once more within
+// TipWindow of its delivery with POST /order/:id/tip. Either way the tip is
+// added to the order's total and goes to the rider in full. A tip given with
+// the order is entered in the rider's ledger with the delivery; one added
+// afterwards is charged on its own and entered on its own, when the
+// OrderTipped event is consumed, so it is paid out with the period it was
+// given in. An order whose day the restaurant has closed out can no longer
+// be tipped.
+
+const (
+	// maxTip caps an order's tip, as Order.Tip's validation does.
+	maxTip = 500
+
+	timelineTipped = "tipped"
+
+	// ledgerRuleTip marks a ledger entry for a tip added after delivery.
+	ledgerRuleTip = "tip"
+)
+
+type TipOrderRequest struct {
+	Amount       float64 `json:"amount" validate:"gt=0,lte=500"`
+	PaymentToken string  `json:"payment_token" validate:"required"`
+}
+
+// deliveredAt is when the order was delivered, if it was.
+func deliveredAt(order Order) (Timestamp, bool) {
+	for i := len(order.Timeline) - 1; i >= 0; i-- {
+		if order.Timeline[i].Event == "delivered" {
+			return order.Timeline[i].At, true
+		}
+	}
+	return Timestamp{}, false
+}
+
+// tipOrder serves POST /order/:id/tip, charging the customer for a tip on
+// an order delivered within TipWindow. An order takes one such tip.
+func tipOrder(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	order, err := getOrder(ctx, c.Param("id"))
+	if err == errOrderNotFound || (err == nil && order.CustomerID != authClaims(c).Subject) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+	if err := checkOrderVersion(c.Request().Context(), order); err != nil {
+		return respondServiceError(c, err)
+	}
+
+	var req TipOrderRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	delivered, ok := deliveredAt(order)
+	if order.Status != "delivered" || !ok {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Only delivered orders can be tipped"})
+	}
+	if clock.Now().Sub(delivered.Time) > appConfig.TipWindow {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Order can no longer be tipped"})
+	}
+	if order.TipPaymentID != "" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Order has already been tipped"})
+	}
+	if order.RiderID == "" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Order has no rider to tip"})
+	}
+	if order.Tip+req.Amount > maxTip {
+		return validationFailed(c, "amount", fmt.Sprintf("must be at most %.2f", maxTip-order.Tip))
+	}
+	if err := checkOrderDayOpen(ctx, requestLogger(c), order); err != nil {
+		return respondServiceError(c, err)
+	}
+
+	// The payment lock keeps a double-submitted tip from being charged twice.
+	locked, err := redisClient.SetNX(ctx, paymentLockKey(order.OrderID), 1, paymentLockTTL).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start payment"})
+	}
+	if !locked {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Payment already in progress"})
+	}
+	defer redisClient.Del(ctx, paymentLockKey(order.OrderID))
+
+	paymentID, err := idGenerator.NewID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start payment"})
+	}
+	payment := Payment{
+		ID:         paymentID,
+		OrderID:    order.OrderID,
+		CustomerID: order.CustomerID,
+		Provider:   paymentProvider.Name(),
+		Amount:     req.Amount,
+		Currency:   cmp.Or(order.Currency, appConfig.PaymentCurrency),
+		Status:     paymentPending,
+		CreatedAt:  clock.Now().UTC(),
+	}
+	logger := requestLogger(c).With("order_id", order.OrderID, "payment_id", payment.ID, "provider", payment.Provider)
+
+	reference, chargeErr := paymentProvider.Charge(c.Request().Context(), ChargeRequest{
+		OrderID:        order.OrderID,
+		CustomerID:     order.CustomerID,
+		Amount:         req.Amount,
+		Currency:       payment.Currency,
+		Token:          req.PaymentToken,
+		IdempotencyKey: payment.ID,
+	})
+	switch {
+	case chargeErr == nil:
+		payment.Status = paymentPaid
+		payment.Reference = reference
+	case errors.Is(chargeErr, errPaymentDeclined):
+		payment.Status = paymentFailed
+		payment.FailureReason = chargeErr.Error()
+	default:
+		logger.Error("payment provider error", "error", chargeErr)
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": "Payment provider unavailable"})
+	}
+
+	err = savePayment(ctx, &payment)
+	if err != nil {
+		logger.Error("error storing tip payment", "status", payment.Status, "reference", payment.Reference, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to record payment"})
+	}
+	if payment.Status == paymentFailed {
+		logger.Info("tip payment declined", "reason", payment.FailureReason)
+		return c.JSON(http.StatusPaymentRequired, map[string]interface{}{
+			"order_id":       order.OrderID,
+			"payment_id":     payment.ID,
+			"payment_status": payment.Status,
+			"error":          "Payment declined",
+		})
+	}
+
+	// The card has been charged, so a write racing this one is retried
+	// rather than failing the request, as for payOrder.
+	orderID := order.OrderID
+	for attempt := 1; ; attempt++ {
+		order, err = updateOrder(ctx, repositories.Orders, orderID, func(order *Order) ([]OrderEvent, error) {
+			order.Tip = roundMoney(order.Tip + req.Amount)
+			order.LateTip = req.Amount
+			order.TipPaymentID = payment.ID
+			order.TotalAmount = roundMoney(order.TotalAmount + req.Amount)
+			if order.Pricing != nil {
+				order.Pricing.Tip = roundMoney(order.Pricing.Tip + req.Amount)
+				order.Pricing.Total = roundMoney(order.Pricing.Total + req.Amount)
+			}
+			order.Timeline = append(order.Timeline, TimelineEvent{Event: timelineTipped, At: timestampNow()})
+			return []OrderEvent{newOrderEvent(ctx, eventOrderTipped, *order)}, nil
+		})
+		if !errors.Is(err, errOrderChanged) || attempt == paymentUpdateAttempts {
+			break
+		}
+	}
+	if err != nil {
+		// The customer has been charged; support settles it from the
+		// payment, which is kept.
+		logger.Error("error adding tip to order", "amount", req.Amount, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update order"})
+	}
+
+	logger.Info("order tipped", "amount", req.Amount, "rider_id", order.RiderID)
+	tagOrderVersion(c, order.Version)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"order_id":       order.OrderID,
+		"payment_id":     payment.ID,
+		"payment_status": payment.Status,
+		"tip":            order.Tip,
+		"total_amount":   order.TotalAmount,
+	})
+}
+
+// recordTipLedger handles OrderTipped: the tip added after delivery goes
+// into the rider's ledger, once, as of when it was given.
+func recordTipLedger(ctx context.Context, event OrderEvent) error {
+	order, err := getOrder(ctx, event.OrderID)
+	if err == errOrderNotFound {
+		return permanent(err)
+	} else if err != nil {
+		return err
+	}
+	if order.LateTip <= 0 || order.RiderID == "" {
+		return nil
+	}
+
+	entry, err := json.Marshal(LedgerEntry{
+		OrderID: order.OrderID,
+		Rule:    ledgerRuleTip,
+		Amount:  order.LateTip,
+		Tip:     order.LateTip,
+		At:      event.OccurredAt,
+	})
+	if err != nil {
+		return permanent(err)
+	}
+	keys := []string{ledgerKey(partyRider, order.RiderID), ledgerIndexKey(partyRider, order.RiderID)}
+	recorded, err := recordLedgerEntries.Run(ctx, redisClient, keys, order.OrderID+":"+ledgerRuleTip, event.OccurredAt.UnixMilli(), string(entry)).Int()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	slog.Info("tip entered in rider's ledger", "order_id", order.OrderID, "rider_id", order.RiderID, "amount", order.LateTip, "recorded", recorded)
+	return nil
+}
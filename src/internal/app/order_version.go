@@ -0,0 +1,124 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Order versions. Every write to an order stores it as the next version,
+// and only in place of the version it was read at, so two requests racing
+// to change an order, say the restaurant accepting it as the customer
+// cancels, cannot both succeed: the second save fails with errOrderChanged
+// and its caller answers 409.
+//
+// Clients can hold the order to the version they last saw, too, by sending
+// it as the If-Match of the routes below, e.g. `If-Match: "3"`. A request
+// whose order has moved on since is answered 409 before anything is done,
+// with the version now stored to fetch the order at and decide again. With
+// ORDER_VERSION_REQUIRED set, those routes answer 428 to requests without
+// an If-Match. Routes changing many orders at once take each order's
+// version in the body instead, and gRPC calls in "if-match" metadata; see
+// kitchen.go and grpc_server.go.
+
+const headerIfMatch = "If-Match"
+
+// orderVersionRoutes change one order, and take its version in If-Match.
+var orderVersionRoutes = map[string]bool{
+	"PATCH /order/:id":                   true,
+	"POST /order/cancel":                 true,
+	"POST /order/pay":                    true,
+	"POST /order/:id/tip":                true,
+	"POST /order/:id/refund":             true,
+	"POST /restaurant/order/accept":      true,
+	"POST /restaurant/order/reject":      true,
+	"POST /rider/order/pickup":           true,
+	"POST /rider/order/deliver":          true,
+	"PUT /restaurant/order/:id/ready-by": true,
+}
+
+type orderVersionKey struct{}
+
+// orderVersioning is middleware putting the If-Match version of the order
+// routes in the request context, for checkOrderVersion.
+func orderVersioning(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !orderVersionRoutes[c.Request().Method+" "+c.Path()] {
+			return next(c)
+		}
+
+		value := c.Request().Header.Get(headerIfMatch)
+		version, ok, err := parseOrderVersion(value)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": `If-Match must be the order's version, e.g. "3"`})
+		}
+		if !ok {
+			if value == "" && appConfig.OrderVersionRequired {
+				return c.JSON(http.StatusPreconditionRequired, map[string]string{"error": "If-Match with the order's version is required"})
+			}
+			return next(c)
+		}
+
+		req := c.Request()
+		c.SetRequest(req.WithContext(withOrderVersion(req.Context(), version)))
+		return next(c)
+	}
+}
+
+// parseOrderVersion parses an If-Match value, e.g. "3". One that is empty,
+// or *, holds the order to no version, and ok is false.
+func parseOrderVersion(value string) (version int64, ok bool, err error) {
+	if value == "" || value == "*" {
+		return 0, false, nil
+	}
+	version, err = strconv.ParseInt(strings.Trim(strings.TrimPrefix(value, "W/"), `"`), 10, 64)
+	if err != nil || version < 0 {
+		return 0, false, errors.New("malformed order version")
+	}
+	return version, true, nil
+}
+
+// withOrderVersion holds the order changed under ctx to version, as
+// If-Match does.
+func withOrderVersion(ctx context.Context, version int64) context.Context {
+	return context.WithValue(ctx, orderVersionKey{}, version)
+}
+
+// checkOrderVersion fails with a conflict if the request holds the order to
+// a version other than order's. Handlers call it once they have read the
+// order and checked the caller may change it, before doing anything.
+func checkOrderVersion(ctx context.Context, order Order) error {
+	version, ok := ctx.Value(orderVersionKey{}).(int64)
+	if !ok || version == order.Version {
+		return nil
+	}
+	return orderVersionConflict(order.Version)
+}
+
+// orderVersionMissing refuses a change that gives no version while
+// ORDER_VERSION_REQUIRED is set.
+func orderVersionMissing() *serviceError {
+	return serviceFailure(http.StatusPreconditionRequired, "The order's version is required")
+}
+
+func orderVersionConflict(current int64) *serviceError {
+	return &serviceError{
+		Status:  http.StatusConflict,
+		Message: "Order changed meanwhile; fetch it and try again",
+		Details: map[string]interface{}{"version": current},
+	}
+}
+
+// orderChangedFailure is the conflict for a save that failed with
+// errOrderChanged, naming the version that beat it.
+func orderChangedFailure(ctx context.Context, orderID string) *serviceError {
+	order, err := getOrder(ctx, orderID)
+	if err != nil {
+		return serviceFailure(http.StatusConflict, "Order changed meanwhile; fetch it and try again")
+	}
+	return orderVersionConflict(order.Version)
+}
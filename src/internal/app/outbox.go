@@ -0,0 +1,160 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Order events are not published by request handlers. The order repository
+// writes them to its outbox in the same transaction as the order change that
+// caused them, and a relay moves them to Kafka afterwards, so an order and
+// its events can never diverge. In Redis the outbox is a sorted set.
+const (
+	outboxKey   = "outbox"
+	outboxBatch = 100
+)
+
+// statusEvents is the event emitted when an order enters each status.
+var statusEvents = map[string]string{
+	"accepted":  eventOrderAccepted,
+	"rejected":  eventOrderRejected,
+	"picked_up": eventOrderPickedUp,
+	"delivered": eventOrderDelivered,
+	"cancelled": eventOrderCancelled,
+	"expired":   eventOrderExpired,
+}
+
+// outboxWake lets writers nudge the relay instead of waiting for its next
+// poll.
+var outboxWake = make(chan struct{}, 1)
+
+func wakeOutboxRelay() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// encodeOutboxEvent encodes event for the outbox, giving it an ID first so
+// consumers can recognise redeliveries.
+func encodeOutboxEvent(event *OrderEvent) (string, error) {
+	if event.EventID == "" {
+		id, err := idGenerator.NewID()
+		if err != nil {
+			return "", err
+		}
+		event.EventID = id
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s event: %v", event.Type, err)
+	}
+	return string(data), nil
+}
+
+// runOutboxRelay publishes outbox events until ctx is cancelled, polling at
+// interval or sooner when woken by a write.
+func runOutboxRelay(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		relayOutbox(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-outboxWake:
+		}
+	}
+}
+
+// relayOutbox publishes pending events oldest first, a batch at a time, and
+// removes each one once Kafka has acknowledged it. The whole batch is handed
+// to the producer at once, so it goes out in a few writes rather than one
+// per event, and the next batch is only read once every event in this one
+// has been answered for. After any failure the relay stops until its next
+// pass; the producer fails the events queued behind a failed one, so those
+// left in the outbox are retried in order and no order's events are
+// published out of order within a topic. An event published but not removed
+// is sent again, so delivery is at-least-once. Each event published is
+// added to its order's audit log before it is removed; see order_events.go.
+func relayOutbox(ctx context.Context) {
+	for {
+		pending, err := repositories.Orders.PendingEvents(ctx, outboxBatch)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, errCircuitOpen) {
+				slog.Error("error reading outbox", "error", err)
+			}
+			return
+		}
+
+		var (
+			mu         sync.Mutex
+			sent       []outboxEvent
+			sentEvents []OrderEvent
+			failed     bool
+			wg         sync.WaitGroup
+		)
+		for _, entry := range pending {
+			var event OrderEvent
+			err := json.Unmarshal([]byte(entry.Data), &event)
+			if err != nil {
+				slog.Error("dropping unreadable outbox entry", "entry", entry.Data, "error", err)
+				repositories.Orders.RemoveEvents(ctx, entry)
+				continue
+			}
+
+			wg.Add(1)
+			err = publishOrderEvent(ctx, event, func(err error) {
+				defer wg.Done()
+				mu.Lock()
+				defer mu.Unlock()
+				if err == nil {
+					sent = append(sent, entry)
+					sentEvents = append(sentEvents, event)
+					return
+				}
+				// Kafka being known to be down, or an earlier event having
+				// failed, needs no report of its own; the events wait here.
+				if !failed && !errors.Is(err, errCircuitOpen) && !errors.Is(err, errEarlierWriteFailed) {
+					slog.Warn("outbox relay publish failed, will retry", "order_id", event.OrderID, "type", event.Type, "error", err)
+				}
+				failed = true
+			})
+			if err != nil {
+				wg.Done()
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+				break
+			}
+		}
+		wg.Wait()
+
+		if len(sent) > 0 {
+			// Events stay in the outbox until they are in the audit log too,
+			// so one the log misses is published again and logged then.
+			err = recordPublishedEvents(ctx, sentEvents)
+			if err != nil {
+				slog.Error("error recording published events", "count", len(sent), "error", err)
+				return
+			}
+			err = repositories.Orders.RemoveEvents(ctx, sent...)
+			if err != nil {
+				slog.Error("error marking outbox events sent", "count", len(sent), "error", err)
+				return
+			}
+		}
+		if failed || len(pending) < outboxBatch {
+			return
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+const (
+	paymentPending  = "pending"
+	paymentPaid     = "paid"
+	paymentFailed   = "failed"
+	paymentRefunded = "refunded"
+
+	timelinePaid = "paid"
+
+	paymentLockTTL = 30 * time.Second
+
+	// paymentUpdateAttempts is how many times a charged order is written
+	// before giving up on others writing it first.
+	paymentUpdateAttempts = 3
+)
+
+// errPaymentDeclined is returned by a provider when the charge was refused,
+// as opposed to the provider being unreachable.
+var errPaymentDeclined = errors.New("payment declined")
+
+var errPaymentNotFound = errors.New("payment not found")
+
+// errOrderClosed is a charged order found to have left the created status.
+var errOrderClosed = errors.New("order closed during payment")
+
+type ChargeRequest struct {
+	OrderID        string
+	CustomerID     string
+	Amount         float64
+	Currency       string
+	Token          string
+	IdempotencyKey string
+}
+
+type PaymentProvider interface {
+	Name() string
+	// Charge returns the provider's reference for the payment.
+	Charge(ctx context.Context, req ChargeRequest) (string, error)
+	Refund(ctx context.Context, reference string, amount float64, idempotencyKey string) error
+}
+
+// Payment records one charge attempt against an order.
+type Payment struct {
+	ID            string    `json:"id"`
+	OrderID       string    `json:"order_id"`
+	CustomerID    string    `json:"customer_id"`
+	Provider      string    `json:"provider"`
+	Reference     string    `json:"reference,omitempty"`
+	Amount        float64   `json:"amount"`
+	Currency      string    `json:"currency"`
+	Status        string    `json:"status"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type PayOrderRequest struct {
+	OrderID      string `json:"order_id" validate:"required,uuid"`
+	PaymentToken string `json:"payment_token" validate:"required"`
+}
+
+var paymentProvider PaymentProvider
+
+func newPaymentProvider(name string) (PaymentProvider, error) {
+	switch name {
+	case "mock":
+		return MockPaymentProvider{}, nil
+	case "stripe":
+		if appConfig.StripeSecretKey == "" {
+			return nil, errors.New("STRIPE_SECRET_KEY must be set for the stripe provider")
+		}
+		return StripeProvider{
+			SecretKey: appConfig.StripeSecretKey,
+			BaseURL:   "https://api.stripe.com",
+			Client:    &http.Client{Timeout: 15 * time.Second},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown payment provider %q", name)
+}
+
+// MockPaymentProvider approves every charge except those made with the token
+// "tok_decline", for local development and testing.
+type MockPaymentProvider struct{}
+
+func (MockPaymentProvider) Name() string { return "mock" }
+
+func (MockPaymentProvider) Charge(ctx context.Context, req ChargeRequest) (string, error) {
+	if req.Token == "tok_decline" {
+		return "", errPaymentDeclined
+	}
+	return "mock_" + req.IdempotencyKey, nil
+}
+
+func (MockPaymentProvider) Refund(ctx context.Context, reference string, amount float64, idempotencyKey string) error {
+	return nil
+}
+
+func paymentKey(paymentID string) string {
+	return "payment:" + paymentID
+}
+
+func paymentLockKey(orderID string) string {
+	return "order:" + orderID + ":paying"
+}
+
+func savePayment(ctx context.Context, payment *Payment) error {
+	payment.UpdatedAt = clock.Now().UTC()
+	paymentJSON, err := json.Marshal(payment)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payment: %v", err)
+	}
+	err = redisClient.Set(ctx, paymentKey(payment.ID), paymentJSON, 0).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+func getPayment(ctx context.Context, paymentID string) (Payment, error) {
+	data, err := redisClient.Get(ctx, paymentKey(paymentID)).Result()
+	if err == redis.Nil {
+		return Payment{}, errPaymentNotFound
+	} else if err != nil {
+		return Payment{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	var payment Payment
+	err = json.Unmarshal([]byte(data), &payment)
+	if err != nil {
+		return Payment{}, fmt.Errorf("failed to parse payment: %v", err)
+	}
+	return payment, nil
+}
+
+// payOrder charges the customer for an order. The restaurant only sees the
+// order as ready to accept once OrderPaid has been emitted.
+func payOrder(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req PayOrderRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	order, err := getOrder(ctx, req.OrderID)
+	if err == errOrderNotFound || (err == nil && order.CustomerID != authClaims(c).Subject) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+	if err := checkOrderVersion(c.Request().Context(), order); err != nil {
+		return respondServiceError(c, err)
+	}
+
+	if order.Status != "created" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Order cannot be paid in status " + order.Status})
+	}
+	if order.PaymentStatus == paymentPaid {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Order has already been paid"})
+	}
+
+	// Hold a short lock so a double-submitted request cannot charge twice.
+	locked, err := redisClient.SetNX(ctx, paymentLockKey(order.OrderID), 1, paymentLockTTL).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start payment"})
+	}
+	if !locked {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Payment already in progress"})
+	}
+	defer redisClient.Del(ctx, paymentLockKey(order.OrderID))
+
+	paymentID, err := idGenerator.NewID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start payment"})
+	}
+
+	payment := Payment{
+		ID:         paymentID,
+		OrderID:    order.OrderID,
+		CustomerID: order.CustomerID,
+		Provider:   paymentProvider.Name(),
+		Amount:     order.TotalAmount,
+		Currency:   cmp.Or(order.Currency, appConfig.PaymentCurrency),
+		Status:     paymentPending,
+		CreatedAt:  clock.Now().UTC(),
+	}
+
+	logger := requestLogger(c).With("order_id", order.OrderID, "payment_id", payment.ID, "provider", payment.Provider)
+
+	reference, chargeErr := paymentProvider.Charge(c.Request().Context(), ChargeRequest{
+		OrderID:        order.OrderID,
+		CustomerID:     order.CustomerID,
+		Amount:         order.TotalAmount,
+		Currency:       payment.Currency,
+		Token:          req.PaymentToken,
+		IdempotencyKey: payment.ID,
+	})
+	switch {
+	case chargeErr == nil:
+		payment.Status = paymentPaid
+		payment.Reference = reference
+	case errors.Is(chargeErr, errPaymentDeclined):
+		payment.Status = paymentFailed
+		payment.FailureReason = chargeErr.Error()
+	default:
+		logger.Error("payment provider error", "error", chargeErr)
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": "Payment provider unavailable"})
+	}
+
+	err = savePayment(ctx, &payment)
+	if err != nil {
+		logger.Error("error storing payment", "status", payment.Status, "reference", payment.Reference, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to record payment"})
+	}
+
+	// The charge can take a while; update the order as it is stored now so
+	// a status change made meanwhile, such as the order expiring, is not
+	// overwritten. The card has been charged, so a write racing this one
+	// does not fail the request: it is retried on the order as that left it.
+	var closed Order
+	orderID := order.OrderID
+	for attempt := 1; ; attempt++ {
+		order, err = updateOrder(c.Request().Context(), repositories.Orders, orderID, func(current *Order) ([]OrderEvent, error) {
+			if payment.Status == paymentPaid && current.Status != "created" {
+				closed = *current
+				return nil, errOrderClosed
+			}
+			current.PaymentID = payment.ID
+			current.PaymentStatus = payment.Status
+			if payment.Status != paymentPaid {
+				return nil, nil
+			}
+			current.Timeline = append(current.Timeline, TimelineEvent{Event: timelinePaid, At: timestampNow()})
+			return []OrderEvent{newOrderEvent(c.Request().Context(), eventOrderPaid, *current)}, nil
+		})
+		if !errors.Is(err, errOrderChanged) || attempt == paymentUpdateAttempts {
+			break
+		}
+	}
+	if errors.Is(err, errOrderClosed) {
+		return refundLateCharge(c, logger, closed, &payment)
+	} else if err != nil {
+		logger.Error("error updating order payment status", "status", payment.Status, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update order"})
+	}
+
+	tagOrderVersion(c, order.Version)
+	resp := map[string]interface{}{
+		"order_id":       order.OrderID,
+		"payment_id":     payment.ID,
+		"payment_status": payment.Status,
+	}
+	if payment.Status == paymentFailed {
+		logger.Info("payment declined", "reason", payment.FailureReason)
+		resp["error"] = "Payment declined"
+		return c.JSON(http.StatusPaymentRequired, resp)
+	}
+
+	logger.Info("order paid", "amount", payment.Amount)
+	return c.JSON(http.StatusOK, resp)
+}
+
+// refundLateCharge gives the money straight back when the order left the
+// created status while the customer's card was being charged.
+func refundLateCharge(c echo.Context, logger *slog.Logger, order Order, payment *Payment) error {
+	ctx := c.Request().Context()
+
+	err := paymentProvider.Refund(c.Request().Context(), payment.Reference, payment.Amount, "refund-"+payment.ID)
+	if err != nil {
+		logger.Error("error refunding charge on closed order", "order_status", order.Status, "reference", payment.Reference, "error", err)
+		escalateFailedRefund(ctx, order, *payment, err)
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": "Order was closed during payment and the automatic refund failed; support will refund you"})
+	}
+
+	payment.Status = paymentRefunded
+	err = savePayment(ctx, payment)
+	if err != nil {
+		logger.Error("error storing refunded payment", "error", err)
+	}
+
+	logger.Warn("charge refunded, order closed during payment", "order_status", order.Status)
+	return c.JSON(http.StatusConflict, map[string]string{"error": "Order cannot be paid in status " + order.Status})
+}
+
+// escalateFailedRefund opens a support ticket for a refund that has to be
+// finished by hand.
+func escalateFailedRefund(ctx context.Context, order Order, payment Payment, cause error) {
+	ticket, err := newTicket(ticketSourceRefundRequest, order, "Automatic refund failed for order "+order.OrderID,
+		fmt.Sprintf("Payment %s (%s reference %s) could not be refunded: %v", payment.ID, payment.Provider, payment.Reference, cause), payment.Amount)
+	if err == nil {
+		pipe := redisClient.TxPipeline()
+		queueTicket(ctx, pipe, ticket)
+		_, err = pipe.Exec(ctx)
+	}
+	if err != nil {
+		slog.Error("error opening ticket for failed refund", "order_id", order.OrderID, "payment_id", payment.ID, "error", err)
+	}
+}
+
+// refundOrderPayment returns the customer's money once a paid order can no
+// longer be fulfilled. It runs from the event consumer, so it is idempotent:
+// an order that is not in the paid state is left alone, and the provider call
+// carries an idempotency key. It gives back what earlier refunds have not,
+// taking the payment lock as they do; see order_refund.go.
+func refundOrderPayment(ctx context.Context, event OrderEvent) error {
+	locked, err := redisClient.SetNX(ctx, paymentLockKey(event.OrderID), 1, paymentLockTTL).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if !locked {
+		return errRefundInProgress
+	}
+	defer redisClient.Del(ctx, paymentLockKey(event.OrderID))
+
+	order, err := getOrder(ctx, event.OrderID)
+	if err == errOrderNotFound {
+		return permanent(err)
+	} else if err != nil {
+		return err
+	}
+	if order.PaymentStatus != paymentPaid {
+		return nil
+	}
+
+	payment, err := getPayment(ctx, order.PaymentID)
+	if err == errPaymentNotFound {
+		return permanent(fmt.Errorf("payment %s for order %s not found", order.PaymentID, order.OrderID))
+	} else if err != nil {
+		return err
+	}
+
+	provider := paymentProvider
+	if payment.Provider != provider.Name() {
+		return permanent(fmt.Errorf("payment %s was taken by %s, not %s", payment.ID, payment.Provider, provider.Name()))
+	}
+
+	// A late cancellation keeps its fee back.
+	currency := cmp.Or(payment.Currency, appConfig.PaymentCurrency)
+	amount := max(toMinor(payment.Amount, currency)-toMinor(order.CancellationFee, currency)-toMinor(order.RefundedAmount, currency), 0).amount(currency)
+	if amount > 0 {
+		err = provider.Refund(ctx, payment.Reference, amount, "refund-"+payment.ID)
+		if err != nil {
+			return fmt.Errorf("refund payment %s: %w", payment.ID, err)
+		}
+	}
+
+	payment.Status = paymentRefunded
+	err = savePayment(ctx, &payment)
+	if err != nil {
+		return err
+	}
+
+	// The refund ID is the payment's, as its idempotency key is, so a
+	// retry records the same refund.
+	events := recordRefund(ctx, &order, Refund{
+		ID:        payment.ID,
+		PaymentID: payment.ID,
+		Amount:    amount,
+		Reason:    refundReasonCancelled,
+		CreatedAt: timestampNow(),
+	}, payment, true)
+	err = saveOrder(ctx, repositories.Orders, &order, events...)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("order payment refunded", "order_id", order.OrderID, "payment_id", payment.ID, "amount", amount)
+	return nil
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// StripeProvider charges through Stripe's PaymentIntents API. The payment
+// token is a Stripe PaymentMethod ID collected by the client.
+type StripeProvider struct {
+	SecretKey string
+	BaseURL   string
+	Client    *http.Client
+}
+
+type stripeResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  *struct {
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (StripeProvider) Name() string { return "stripe" }
+
+func (s StripeProvider) Charge(ctx context.Context, req ChargeRequest) (string, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(int64(toMinor(req.Amount, req.Currency)), 10))
+	form.Set("currency", req.Currency)
+	form.Set("payment_method", req.Token)
+	form.Set("confirm", "true")
+	form.Set("metadata[order_id]", req.OrderID)
+	form.Set("metadata[customer_id]", req.CustomerID)
+	form.Set("automatic_payment_methods[enabled]", "true")
+	form.Set("automatic_payment_methods[allow_redirects]", "never")
+
+	resp, err := s.post(ctx, "/v1/payment_intents", form, req.IdempotencyKey)
+	if err != nil {
+		return "", err
+	}
+	if resp.Status != "succeeded" {
+		return "", fmt.Errorf("%w: payment intent %s is %s", errPaymentDeclined, resp.ID, resp.Status)
+	}
+	return resp.ID, nil
+}
+
+func (s StripeProvider) Refund(ctx context.Context, reference string, amount float64, idempotencyKey string) error {
+	form := url.Values{}
+	form.Set("payment_intent", reference)
+	// Refunds are in the currency charged, which is the platform's.
+	form.Set("amount", strconv.FormatInt(int64(toMinor(amount, appConfig.PaymentCurrency)), 10))
+
+	_, err := s.post(ctx, "/v1/refunds", form, idempotencyKey)
+	return err
+}
+
+// post sends a form-encoded request and maps card errors to
+// errPaymentDeclined so callers can tell them apart from outages.
+func (s StripeProvider) post(ctx context.Context, path string, form url.Values, idempotencyKey string) (stripeResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.BaseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return stripeResponse{}, fmt.Errorf("failed to build stripe request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.SecretKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+
+	httpResp, err := s.Client.Do(req)
+	if err != nil {
+		return stripeResponse{}, fmt.Errorf("stripe request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	var resp stripeResponse
+	err = json.NewDecoder(httpResp.Body).Decode(&resp)
+	if err != nil {
+		return stripeResponse{}, fmt.Errorf("failed to parse stripe response (status %d): %v", httpResp.StatusCode, err)
+	}
+
+	if resp.Error != nil {
+		if resp.Error.Type == "card_error" {
+			return resp, fmt.Errorf("%w: %s", errPaymentDeclined, resp.Error.Message)
+		}
+		return resp, fmt.Errorf("stripe error (status %d): %s", httpResp.StatusCode, resp.Error.Message)
+	}
+	if httpResp.StatusCode >= 300 {
+		return resp, fmt.Errorf("stripe returned status %d", httpResp.StatusCode)
+	}
+	return resp, nil
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Pickup checklists. When an order is placed we work out what the rider
+// should be handed: how many bags, how many drinks, whether utensils were
+// asked for. The rider confirms each count at pickup, so a missing bag is
+// noticed at the counter rather than at the customer's door.
+
+const menuCategoryDrink = "drink"
+
+// buildPickupChecklist lists what the rider should collect for order. Drinks
+// travel in a carrier of their own, so only food counts towards the bags.
+func buildPickupChecklist(order Order, menu RestaurantMenu) PickupChecklist {
+	items := make(map[string]MenuItem, len(menu.Menu))
+	for _, item := range menu.Menu {
+		items[item.ID] = item
+	}
+
+	checklist := PickupChecklist{
+		Items:    make([]ChecklistItem, 0, len(order.Items)),
+		Utensils: order.Utensils,
+	}
+	food := 0
+	for _, ordered := range order.Items {
+		item := items[ordered.MenuID]
+		checklist.Items = append(checklist.Items, ChecklistItem{MenuID: ordered.MenuID, Name: item.Name, Quantity: ordered.Quantity})
+		if item.Category == menuCategoryDrink {
+			checklist.Drinks += ordered.Quantity
+		} else {
+			food += ordered.Quantity
+		}
+	}
+	if food > 0 {
+		perBag := max(appConfig.PickupItemsPerBag, 1)
+		checklist.Bags = int(math.Ceil(float64(food) / float64(perBag)))
+	}
+	return checklist
+}
+
+// checklistMismatches lists where the rider's counts differ from the
+// checklist.
+func checklistMismatches(checklist PickupChecklist, confirmed ChecklistConfirmation) []string {
+	var mismatches []string
+	if confirmed.Bags != checklist.Bags {
+		mismatches = append(mismatches, fmt.Sprintf("expected %d bags, counted %d", checklist.Bags, confirmed.Bags))
+	}
+	if confirmed.Drinks != checklist.Drinks {
+		mismatches = append(mismatches, fmt.Sprintf("expected %d drinks, counted %d", checklist.Drinks, confirmed.Drinks))
+	}
+	if confirmed.Utensils != checklist.Utensils {
+		if checklist.Utensils {
+			mismatches = append(mismatches, "utensils were requested")
+		} else {
+			mismatches = append(mismatches, "utensils were not requested")
+		}
+	}
+	return mismatches
+}
+
+// getPickupChecklist serves GET /rider/order/:id/checklist.
+func getPickupChecklist(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	order, err := getOrder(ctx, c.Param("id"))
+	if err == errOrderNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+
+	if order.RiderID != "" && !actsForRider(c, order.RiderID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Order is assigned to a different rider"})
+	}
+	if order.PickupChecklist == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order has no pickup checklist"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"order_id":     order.OrderID,
+		"order_code":   order.Code,
+		"checklist":    order.PickupChecklist,
+		"confirmation": order.PickupConfirmation,
+	})
+}
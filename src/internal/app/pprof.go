@@ -0,0 +1,53 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// Runtime profiles. With PPROF_ADDR set, the api and notification worker
+// serve Go's profiles under /debug/pprof/ on that address, e.g.
+//
+//	go tool pprof http://localhost:6060/debug/pprof/profile?seconds=30
+//
+// for 30 seconds of CPU profile. The listener is separate from the API's:
+// the profiles are neither authenticated nor rate limited, so bind it to an
+// address only operators reach.
+
+// pprofWriteTimeout leaves room for the longest profiles and traces asked
+// for, which stream for as many seconds as requested.
+const pprofWriteTimeout = 5 * time.Minute
+
+func newPprofMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// servePprof serves the profiles on addr until ctx is cancelled. A listener
+// that fails is logged; profiling is not worth taking the process down for.
+func servePprof(ctx context.Context, addr string) {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           newPprofMux(),
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      pprofWriteTimeout,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	slog.Info("pprof listening", "addr", addr)
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		slog.Error("pprof server failed", "addr", addr, "error", err)
+	}
+}
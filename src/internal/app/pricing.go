@@ -0,0 +1,158 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"myproject/src/clock"
+)
+
+// pricingError is a problem with what the customer asked for, reported
+// against the request field it concerns.
+type pricingError struct {
+	Field   string
+	Message string
+}
+
+func (e *pricingError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// priceOrder prices order against menu, given the delivery quoted by
+// orderDeliveryFee and the promo found by lookupOrderPromo. Promo usage
+// limits are only checked here; the use itself is counted by redeemPromo
+// once the order is placed. The sums are worked out in minor units; see
+// money.go.
+func priceOrder(order Order, menu RestaurantMenu, delivery deliveryPrice, promo *Promo) (PriceBreakdown, error) {
+	items := make(map[string]MenuItem, len(menu.Menu))
+	for _, item := range menu.Menu {
+		items[item.ID] = item
+	}
+
+	currency := appConfig.PaymentCurrency
+	breakdown := PriceBreakdown{
+		Items:    make([]PricedItem, 0, len(order.Items)),
+		TaxRate:  appConfig.TaxRate,
+		Currency: currency,
+	}
+	var subtotal minorUnits
+	for i, item := range order.Items {
+		menuItem, ok := items[item.MenuID]
+		if !ok {
+			return PriceBreakdown{}, &pricingError{Field: fmt.Sprintf("items[%d].menu_id", i), Message: "is not on this restaurant's menu"}
+		}
+		if menuItem.Currency != "" && !strings.EqualFold(menuItem.Currency, currency) {
+			return PriceBreakdown{}, &pricingError{Field: fmt.Sprintf("items[%d].menu_id", i), Message: "is priced in another currency"}
+		}
+		unitPrice := toMinor(menuItem.Price, currency)
+		lineTotal := unitPrice * minorUnits(item.Quantity)
+		breakdown.Items = append(breakdown.Items, PricedItem{
+			MenuID:    item.MenuID,
+			Name:      menuItem.Name,
+			UnitPrice: unitPrice.amount(currency),
+			Quantity:  item.Quantity,
+			LineTotal: lineTotal.amount(currency),
+		})
+		subtotal += lineTotal
+	}
+	breakdown.Subtotal = subtotal.amount(currency)
+
+	deliveryFee := toMinor(delivery.Fee, currency)
+	breakdown.DeliveryFee, breakdown.Surge = deliveryFee.amount(currency), delivery.Surge
+
+	var discount minorUnits
+	if promo != nil {
+		if reason := checkPromo(*promo, order.RestaurantID, breakdown.Subtotal, clock.Now()); reason != "" {
+			return PriceBreakdown{}, &pricingError{Field: "promo_code", Message: reason}
+		}
+		breakdown.PromoCode = promo.Code
+		discount = promoDiscount(*promo, subtotal, deliveryFee, currency)
+		breakdown.Discount = discount.amount(currency)
+	}
+
+	taxable := subtotal - discount + deliveryFee
+	tax := taxable.times(breakdown.TaxRate)
+	breakdown.Tax = tax.amount(currency)
+	tip := toMinor(order.Tip, currency)
+	breakdown.Tip = tip.amount(currency)
+	breakdown.Total = (taxable + tax + tip).amount(currency)
+	return breakdown, nil
+}
+
+// lookupOrderPromo finds the promo order asks for, or nil if it asks for
+// none.
+func lookupOrderPromo(ctx context.Context, order Order) (*Promo, error) {
+	if order.PromoCode == "" {
+		return nil, nil
+	}
+	promo, err := getPromo(ctx, normalizePromoCode(order.PromoCode))
+	if err == errPromoNotFound {
+		return nil, &pricingError{Field: "promo_code", Message: "is not a valid promo code"}
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Whether a brand campaign applies depends on the restaurant's brand,
+	// which priceOrder has no way to look up, so it is checked here.
+	if promo.BrandID != "" {
+		brandID, err := restaurantBrand(ctx, order.RestaurantID)
+		if err != nil {
+			return nil, err
+		}
+		if brandID != promo.BrandID {
+			return nil, &pricingError{Field: "promo_code", Message: "is not valid at this restaurant"}
+		}
+	}
+	return &promo, nil
+}
+
+// promoDiscount is what promo takes off an order, in currency's minor
+// units.
+func promoDiscount(promo Promo, subtotal, deliveryFee minorUnits, currency string) minorUnits {
+	switch promo.Type {
+	case promoPercent:
+		discount := subtotal.times(promo.Value / 100)
+		if promo.MaxDiscount > 0 {
+			discount = min(discount, toMinor(promo.MaxDiscount, currency))
+		}
+		return discount
+	case promoFixed:
+		return min(toMinor(promo.Value, currency), subtotal)
+	case promoFreeDelivery:
+		return deliveryFee
+	}
+	return 0
+}
+
+type deliveryPrice struct {
+	Fee   float64
+	Surge bool
+	// SurgeFee is the part of Fee added by surge pricing, and DistanceFee
+	// the part charged by distance before surge.
+	SurgeFee    float64
+	DistanceFee float64
+}
+
+// orderDeliveryFee prices delivery from restaurant to the order's location,
+// checking it is within range. Orders without a location, or from
+// restaurants not on file (nil), pay the base fee.
+func orderDeliveryFee(ctx context.Context, order Order, restaurant *Restaurant) (deliveryPrice, error) {
+	if order.DeliveryLocation == nil || restaurant == nil {
+		return deliveryPrice{Fee: roundMoney(appConfig.DeliveryBaseFee)}, nil
+	}
+
+	quote, err := deliveryQuote(ctx, *restaurant, *order.DeliveryLocation)
+	if err != nil {
+		return deliveryPrice{}, err
+	}
+	price := deliveryPrice{
+		Fee:         quote.DeliveryFee,
+		Surge:       quote.Surge,
+		DistanceFee: roundMoney(appConfig.DeliveryFeePerKm * quote.DistanceMeters / 1000),
+	}
+	if quote.Surge && quote.SurgeMultiplier > 0 {
+		price.SurgeFee = roundMoney(quote.DeliveryFee - quote.DeliveryFee/quote.SurgeMultiplier)
+	}
+	return price, nil
+}
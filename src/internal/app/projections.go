@@ -0,0 +1,444 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+)
+
+// Projections. A projection is a read model built by folding the order event
+// topics into Redis, one partition at a time. Each write is committed in the same
+// transaction as the offset it came from, so a projection never applies an
+// event twice or skips one, whichever instance or restart picks it up.
+//
+// Every projection has a generation, and its keys and checkpoints live under
+// projection:{name}:{generation}. Rebuilding bumps the generation: the
+// runners start over from the earliest retained offset into an empty key
+// space, and the old generation is deleted. Replaying the same events
+// always yields the same state, so a handler bug is fixed by deploying the
+// fix and rebuilding. Pausing stops a projection at its checkpoint, e.g.
+// while such a fix is deployed; resuming carries on from there. A backfill,
+// run with `api backfill`, rebuilds a projection out of process while the
+// runners wait; see backfill.go.
+//
+// The restaurant search index is rebuilt from the restaurant list rather
+// than the event stream, so it is not a projection.
+
+const (
+	// projectionIdleCheck is how often a runner waiting for messages looks
+	// for a rebuild or pause.
+	projectionIdleCheck = 5 * time.Second
+	projectionScanCount = 100
+)
+
+var (
+	errProjectionReset  = errors.New("projection was rebuilt")
+	errProjectionPaused = errors.New("projection is paused")
+)
+
+var projectionEventsApplied = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "projection_events_applied_total",
+	Help: "Order events folded into projections partitioned by projection.",
+}, []string{"projection"})
+
+func init() {
+	prometheus.MustRegister(projectionEventsApplied)
+}
+
+// projectionScope is one generation of a projection.
+type projectionScope struct {
+	name       string
+	generation int64
+}
+
+func (s projectionScope) key(suffix string) string {
+	return fmt.Sprintf("projection:%s:%d:%s", s.name, s.generation, suffix)
+}
+
+func (s projectionScope) checkpointKey() string {
+	return s.key("checkpoint")
+}
+
+// projection folds one event into its read model. apply may read the current
+// state through tx and queues its writes on pipe; it must depend only on the
+// event and that state, never on the clock, so a rebuild is deterministic.
+type projection struct {
+	name  string
+	apply func(ctx context.Context, tx *redis.Tx, pipe redis.Pipeliner, scope projectionScope, event OrderEvent) error
+}
+
+var projections = []projection{
+	{name: "dashboard", apply: applyDashboardEvent},
+	{name: "deliveries", apply: applyDeliveriesEvent},
+	{name: "revenue", apply: applyRevenueEvent},
+	{name: "analytics", apply: applyAnalyticsEvent},
+}
+
+func findProjection(name string) (projection, bool) {
+	for _, p := range projections {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return projection{}, false
+}
+
+// projectionControlKey holds a projection's generation and whether it is
+// paused. It sits outside the generation's key space so a rebuild keeps it.
+func projectionControlKey(name string) string {
+	return "projection:" + name
+}
+
+type projectionControl struct {
+	Generation int64 `redis:"generation"`
+	Paused     bool  `redis:"paused"`
+	// Backfilling means a backfill is building the generation, and the
+	// runners leave it alone until it is done.
+	Backfilling bool `redis:"backfill"`
+}
+
+// idle reports whether the generation is not to be applied to, by a
+// backfill if backfill is true and by the runners if not.
+func (c projectionControl) idle(backfill bool) bool {
+	return c.Paused || c.Backfilling != backfill
+}
+
+func getProjectionControl(ctx context.Context, cmd redis.Cmdable, name string) (projectionControl, error) {
+	var control projectionControl
+	err := cmd.HGetAll(ctx, projectionControlKey(name)).Scan(&control)
+	if err != nil {
+		return control, fmt.Errorf("redis error: %v", err)
+	}
+	return control, nil
+}
+
+// currentProjectionScope is the generation readers of a projection should
+// look at.
+func currentProjectionScope(ctx context.Context, name string) (projectionScope, error) {
+	control, err := getProjectionControl(ctx, redisClient, name)
+	if err != nil {
+		return projectionScope{}, err
+	}
+	return projectionScope{name: name, generation: control.Generation}, nil
+}
+
+// runProjections keeps every projection up to date on every partition of
+// the order event topics until ctx is cancelled.
+func runProjections(ctx context.Context) {
+	for _, topic := range orderEventRouter.topics() {
+		go runProjectionTopic(ctx, topic)
+	}
+}
+
+func runProjectionTopic(ctx context.Context, topic string) {
+	var partitions []int
+	for {
+		var err error
+		partitions, err = bus.Partitions(ctx, topic)
+		if err == nil {
+			break
+		}
+		slog.Error("error listing order partitions for projections", "topic", topic, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(consumerRetryDelay):
+		}
+	}
+
+	for _, p := range projections {
+		for _, partition := range partitions {
+			go runProjectionPartition(ctx, p, topic, partition)
+		}
+	}
+}
+
+func runProjectionPartition(ctx context.Context, p projection, topic string, partition int) {
+	logger := slog.With("projection", p.name, "topic", topic, "partition", partition)
+	for {
+		err := followProjection(ctx, p, topic, partition)
+		if ctx.Err() != nil {
+			return
+		}
+
+		delay := consumerRetryDelay
+		switch {
+		case errors.Is(err, errProjectionReset):
+			logger.Info("projection rebuilt, starting over")
+			continue
+		case errors.Is(err, errProjectionPaused):
+			delay = projectionIdleCheck
+		default:
+			logger.Error("error following projection", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// followProjection applies the partition's messages from the checkpoint of
+// the current generation until that generation is replaced or paused.
+func followProjection(ctx context.Context, p projection, topic string, partition int) error {
+	control, err := getProjectionControl(ctx, redisClient, p.name)
+	if err != nil {
+		return err
+	}
+	if control.idle(false) {
+		return errProjectionPaused
+	}
+	scope := projectionScope{name: p.name, generation: control.Generation}
+
+	offset, err := redisClient.HGet(ctx, scope.checkpointKey(), topicPartitionName(topic, partition)).Int64()
+	if err == redis.Nil {
+		offset = kafka.FirstOffset
+	} else if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	r := bus.PartitionReader(topic, partition)
+	defer r.Close()
+	err = r.SetOffset(offset)
+	if err != nil {
+		return err
+	}
+
+	for {
+		readCtx, cancel := context.WithTimeout(ctx, projectionIdleCheck)
+		msg, err := r.ReadMessage(readCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			current, err := getProjectionControl(ctx, redisClient, p.name)
+			if err != nil {
+				return err
+			}
+			if current.Generation != scope.generation {
+				return errProjectionReset
+			}
+			if current.idle(false) {
+				return errProjectionPaused
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = applyProjectionMessage(ctx, p, scope, msg, false)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// applyProjectionMessage folds msg into the projection and advances the
+// checkpoint in one transaction. Watching the control key aborts it if the
+// projection is rebuilt or paused meanwhile, and watching the checkpoint if
+// another instance got there first; after a conflict the message is looked
+// at afresh. backfill says whether a backfill or a runner is applying it.
+func applyProjectionMessage(ctx context.Context, p projection, scope projectionScope, msg kafka.Message, backfill bool) error {
+	field := topicPartitionName(msg.Topic, msg.Partition)
+	for {
+		err := redisClient.Watch(ctx, func(tx *redis.Tx) error {
+			control, err := getProjectionControl(ctx, tx, p.name)
+			if err != nil {
+				return err
+			}
+			if control.Generation != scope.generation {
+				return errProjectionReset
+			}
+			if control.idle(backfill) {
+				return errProjectionPaused
+			}
+
+			next, err := tx.HGet(ctx, scope.checkpointKey(), field).Int64()
+			if err != nil && err != redis.Nil {
+				return fmt.Errorf("redis error: %v", err)
+			}
+			if err == nil && msg.Offset < next {
+				return nil
+			}
+
+			// A message that is not an event for this region is skipped, but
+			// its offset still counts, so every replay skips it too.
+			event, decodeErr := decodeOrderEvent(msg.Value)
+			valid := decodeErr == nil && event.Type != "" && (event.Region == "" || event.Region == appConfig.Region)
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if valid {
+					if err := p.apply(ctx, tx, pipe, scope, event); err != nil {
+						return err
+					}
+				}
+				pipe.HSet(ctx, scope.checkpointKey(), field, msg.Offset+1)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if valid {
+				projectionEventsApplied.WithLabelValues(p.name).Inc()
+			}
+			return nil
+		}, projectionControlKey(p.name), scope.checkpointKey())
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
+
+// rebuildProjection starts the projection over in a new generation and
+// deletes the old one. Runners on every instance notice the new generation
+// and replay the topic from the start, unless it is for a backfill to build.
+func rebuildProjection(ctx context.Context, name string, backfill bool) (int64, error) {
+	old, err := currentProjectionScope(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	pipe := redisClient.TxPipeline()
+	generation := pipe.HIncrBy(ctx, projectionControlKey(name), "generation", 1)
+	pipe.HDel(ctx, projectionControlKey(name), "paused")
+	if backfill {
+		pipe.HSet(ctx, projectionControlKey(name), "backfill", 1)
+	} else {
+		pipe.HDel(ctx, projectionControlKey(name), "backfill")
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("redis error: %v", err)
+	}
+
+	// Nothing writes to the old generation once the new one exists, so its
+	// keys can be removed at leisure.
+	var cursor uint64
+	for {
+		var keys []string
+		keys, cursor, err = redisClient.Scan(ctx, cursor, old.key("*"), projectionScanCount).Result()
+		if err != nil {
+			slog.Warn("error scanning old projection generation", "projection", name, "generation", old.generation, "error", err)
+			break
+		}
+		if len(keys) > 0 {
+			err = redisClient.Del(ctx, keys...).Err()
+			if err != nil {
+				slog.Warn("error deleting old projection generation", "projection", name, "generation", old.generation, "error", err)
+				break
+			}
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	return generation.Val(), nil
+}
+
+type ProjectionStatus struct {
+	Name        string           `json:"name"`
+	Generation  int64            `json:"generation"`
+	Paused      bool             `json:"paused"`
+	Backfilling bool             `json:"backfilling"`
+	Checkpoints map[string]int64 `json:"checkpoints"`
+}
+
+func getProjectionStatus(ctx context.Context, name string) (ProjectionStatus, error) {
+	control, err := getProjectionControl(ctx, redisClient, name)
+	if err != nil {
+		return ProjectionStatus{}, err
+	}
+	scope := projectionScope{name: name, generation: control.Generation}
+
+	entries, err := redisClient.HGetAll(ctx, scope.checkpointKey()).Result()
+	if err != nil {
+		return ProjectionStatus{}, fmt.Errorf("redis error: %v", err)
+	}
+	checkpoints := make(map[string]int64, len(entries))
+	for partition, offset := range entries {
+		checkpoints[partition], _ = strconv.ParseInt(offset, 10, 64)
+	}
+	return ProjectionStatus{Name: name, Generation: control.Generation, Paused: control.Paused, Backfilling: control.Backfilling, Checkpoints: checkpoints}, nil
+}
+
+// listProjections serves GET /admin/projections: each projection's
+// generation, whether it is paused, and the next offset it will apply on
+// each partition.
+func listProjections(c echo.Context) error {
+	statuses := make([]ProjectionStatus, 0, len(projections))
+	for _, p := range projections {
+		status, err := getProjectionStatus(c.Request().Context(), p.name)
+		if err != nil {
+			requestLogger(c).Error("error fetching projection status", "projection", p.name, "error", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch projections"})
+		}
+		statuses = append(statuses, status)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"projections": statuses})
+}
+
+// rebuildProjectionHandler serves POST /admin/projections/:name/rebuild.
+func rebuildProjectionHandler(c echo.Context) error {
+	name := c.Param("name")
+	if _, ok := findProjection(name); !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Projection not found"})
+	}
+
+	generation, err := rebuildProjection(c.Request().Context(), name, false)
+	if err != nil {
+		requestLogger(c).Error("error rebuilding projection", "projection", name, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to rebuild projection"})
+	}
+
+	requestLogger(c).Info("projection rebuild started", "projection", name, "generation", generation)
+	return c.JSON(http.StatusAccepted, map[string]interface{}{"name": name, "generation": generation, "status": "rebuilding"})
+}
+
+// pauseProjection serves POST /admin/projections/:name/pause and
+// resumeProjection POST /admin/projections/:name/resume.
+func pauseProjection(c echo.Context) error {
+	return setProjectionPaused(c, true)
+}
+
+func resumeProjection(c echo.Context) error {
+	return setProjectionPaused(c, false)
+}
+
+func setProjectionPaused(c echo.Context, paused bool) error {
+	ctx := c.Request().Context()
+
+	name := c.Param("name")
+	if _, ok := findProjection(name); !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Projection not found"})
+	}
+
+	var err error
+	if paused {
+		err = redisClient.HSet(ctx, projectionControlKey(name), "paused", 1).Err()
+	} else {
+		err = redisClient.HDel(ctx, projectionControlKey(name), "paused").Err()
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update projection"})
+	}
+
+	status, err := getProjectionStatus(c.Request().Context(), name)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch projection"})
+	}
+	requestLogger(c).Info("projection updated", "projection", name, "paused", paused)
+	return c.JSON(http.StatusOK, status)
+}
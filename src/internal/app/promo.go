@@ -0,0 +1,198 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	promoPercent      = "percent"
+	promoFixed        = "fixed"
+	promoFreeDelivery = "free_delivery"
+
+	promosKey = "promos"
+)
+
+var (
+	errPromoNotFound  = errors.New("promo code not found")
+	errPromoExhausted = errors.New("promo code has been used up")
+)
+
+// redeemPromoUse counts one use of a promo unless that would pass its limit.
+// KEYS[1] use counter; ARGV[1] limit, 0 for none.
+var redeemPromoUse = redis.NewScript(`
+local uses = redis.call('INCR', KEYS[1])
+local limit = tonumber(ARGV[1])
+if limit > 0 and uses > limit then
+	redis.call('DECR', KEYS[1])
+	return 0
+end
+return 1
+`)
+
+// Promo is a discount code. Value is a percentage for percent promos and an
+// amount for fixed ones; free_delivery promos ignore it. A use is counted when
+// an order is placed with the code.
+type Promo struct {
+	Code         string     `json:"code"`
+	Type         string     `json:"type" validate:"required,oneof=percent fixed free_delivery"`
+	Value        float64    `json:"value" validate:"gte=0"`
+	MinSubtotal  float64    `json:"min_subtotal" validate:"gte=0"`
+	MaxDiscount  float64    `json:"max_discount,omitempty" validate:"gte=0"`
+	RestaurantID string     `json:"restaurant_id,omitempty"`
+	BrandID      string     `json:"brand_id,omitempty"`
+	UsageLimit   int64      `json:"usage_limit,omitempty" validate:"gte=0"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	Uses         int64      `json:"uses"`
+}
+
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func promoUsesKey(code string) string {
+	return "promo:" + code + ":uses"
+}
+
+func getPromo(ctx context.Context, code string) (Promo, error) {
+	pipe := redisClient.Pipeline()
+	promoCmd := pipe.HGet(ctx, promosKey, code)
+	usesCmd := pipe.Get(ctx, promoUsesKey(code))
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return Promo{}, fmt.Errorf("redis error: %v", err)
+	}
+	if promoCmd.Err() == redis.Nil {
+		return Promo{}, errPromoNotFound
+	}
+
+	var promo Promo
+	err = json.Unmarshal([]byte(promoCmd.Val()), &promo)
+	if err != nil {
+		return Promo{}, fmt.Errorf("failed to parse promo: %v", err)
+	}
+	promo.Uses, _ = usesCmd.Int64()
+	return promo, nil
+}
+
+// checkPromo returns why promo cannot be used on an order for subtotal at
+// restaurantID, or "" if it can.
+func checkPromo(promo Promo, restaurantID string, subtotal float64, now time.Time) string {
+	switch {
+	case promo.ExpiresAt != nil && now.After(*promo.ExpiresAt):
+		return "has expired"
+	case promo.RestaurantID != "" && promo.RestaurantID != restaurantID:
+		return "is not valid at this restaurant"
+	case subtotal < promo.MinSubtotal:
+		return fmt.Sprintf("requires a subtotal of at least %.2f", promo.MinSubtotal)
+	case promo.UsageLimit > 0 && promo.Uses >= promo.UsageLimit:
+		return "has been used up"
+	}
+	return ""
+}
+
+// redeemPromo counts a use of code, failing with errPromoExhausted if an
+// order placed meanwhile took the last one.
+func redeemPromo(ctx context.Context, rdb redis.Cmdable, promo Promo) error {
+	redeemed, err := redeemPromoUse.Run(ctx, rdb, []string{promoUsesKey(promo.Code)}, promo.UsageLimit).Int()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if redeemed == 0 {
+		return errPromoExhausted
+	}
+	return nil
+}
+
+// releasePromo gives back a use counted for an order that was not placed.
+func releasePromo(ctx context.Context, rdb redis.Cmdable, code string) error {
+	err := rdb.Decr(ctx, promoUsesKey(code)).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+func setPromo(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var promo Promo
+	if err := bindAndValidate(c, &promo); err != nil {
+		return respondRequestError(c, err)
+	}
+	if promo.Type == promoPercent && promo.Value > 100 {
+		return validationFailed(c, "value", "must be at most 100 for percent promos")
+	}
+
+	promo.Code = normalizePromoCode(c.Param("code"))
+	if promo.Code == "" {
+		return validationFailed(c, "code", "is required")
+	}
+	if promo.BrandID != "" {
+		if promo.RestaurantID != "" {
+			return validationFailed(c, "brand_id", "cannot be combined with restaurant_id")
+		}
+		_, err := getBrand(ctx, promo.BrandID)
+		if err == errBrandNotFound {
+			return validationFailed(c, "brand_id", "is not a known brand")
+		} else if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch brand"})
+		}
+	}
+	promo.Uses = 0
+
+	promoJSON, _ := json.Marshal(promo)
+	err := redisClient.HSet(ctx, promosKey, promo.Code, promoJSON).Err()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save promo"})
+	}
+
+	requestLogger(c).Info("promo saved", "code", promo.Code, "type", promo.Type, "value", promo.Value)
+	return c.JSON(http.StatusOK, promo)
+}
+
+func listPromos(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	entries, err := redisClient.HGetAll(ctx, promosKey).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch promos"})
+	}
+
+	promos := make([]Promo, 0, len(entries))
+	for code := range entries {
+		promo, err := getPromo(ctx, code)
+		if err != nil {
+			continue
+		}
+		promos = append(promos, promo)
+	}
+	sort.Slice(promos, func(i, j int) bool { return promos[i].Code < promos[j].Code })
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"promos": promos})
+}
+
+func deletePromo(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	code := normalizePromoCode(c.Param("code"))
+	removed, err := redisClient.HDel(ctx, promosKey, code).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete promo"})
+	}
+	if removed == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Promo not found"})
+	}
+
+	redisClient.Del(ctx, promoUsesKey(code))
+	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+}
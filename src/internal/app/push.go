@@ -0,0 +1,557 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"myproject/src/clock"
+)
+
+// Push notifications. Customer and rider apps register the token FCM or
+// APNs gave the device; the push channel sends every notification for the
+// recipient to each of their devices. A token the platform reports as no
+// longer registered, because the app was uninstalled say, is dropped.
+//
+// FCM is sent through the HTTP v1 API with a service account; APNs with a
+// token-based provider key. Either can be left unconfigured, and the channel
+// is only available if one is configured.
+
+const (
+	platformFCM  = "fcm"
+	platformAPNs = "apns"
+)
+
+var errDeviceUnregistered = errors.New("device token is no longer registered")
+
+var pushNotificationsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "push_notifications_sent_total",
+	Help: "Push notifications sent to devices, partitioned by platform and result (delivered, unregistered, failed).",
+}, []string{"platform", "result"})
+
+func init() {
+	prometheus.MustRegister(pushNotificationsSent)
+}
+
+// PushMessage is a notification as shown on a device. Data is passed to the
+// app alongside it.
+type PushMessage struct {
+	Title string
+	Body  string
+	Data  map[string]string
+}
+
+// PushSender delivers to devices of one platform. It returns
+// errDeviceUnregistered for tokens the platform no longer knows, and wraps
+// other errors that resending cannot fix with permanent.
+type PushSender interface {
+	Push(ctx context.Context, token string, msg PushMessage) error
+}
+
+// PushNotifier sends notifications to the recipient's devices, with the
+// sender for each device's platform.
+type PushNotifier struct {
+	Senders map[string]PushSender
+}
+
+// newPushNotifier sets up the senders configured, returning nil if none is.
+func newPushNotifier() (*PushNotifier, error) {
+	senders := map[string]PushSender{}
+	if appConfig.FCMCredentialsFile != "" {
+		sender, err := newFCMSender(appConfig.FCMCredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		senders[platformFCM] = sender
+	}
+	if appConfig.APNsKeyFile != "" {
+		sender, err := newAPNsSender(appConfig.APNsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		senders[platformAPNs] = sender
+	}
+	if len(senders) == 0 {
+		return nil, nil
+	}
+	return &PushNotifier{Senders: senders}, nil
+}
+
+func (*PushNotifier) Name() string { return "push" }
+
+// Send pushes n to every device of the recipient. It succeeds if any device
+// received it; retrying when none did resends to them all.
+func (p *PushNotifier) Send(ctx context.Context, contact Contact, n Notification) error {
+	if len(contact.Devices) == 0 {
+		return errNoContact
+	}
+
+	msg := PushMessage{
+		Title: n.Subject,
+		Body:  n.Message,
+		Data: map[string]string{
+			"notification_id": n.ID,
+			"template":        n.Template,
+		},
+	}
+	if n.OrderID != "" {
+		msg.Data["order_id"] = n.OrderID
+	}
+
+	var errs []error
+	retryable := false
+	for _, device := range contact.Devices {
+		sender, ok := p.Senders[device.Platform]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: push is not configured", device.Platform))
+			continue
+		}
+
+		err := sender.Push(ctx, device.Token, msg)
+		switch {
+		case err == nil:
+			pushNotificationsSent.WithLabelValues(device.Platform, "delivered").Inc()
+			return nil
+		case errors.Is(err, errDeviceUnregistered):
+			pushNotificationsSent.WithLabelValues(device.Platform, "unregistered").Inc()
+			removeDevice(ctx, n.RecipientType, n.RecipientID, device.Token)
+		default:
+			pushNotificationsSent.WithLabelValues(device.Platform, "failed").Inc()
+			retryable = retryable || !isPermanent(err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", device.Platform, err))
+	}
+
+	err := errors.Join(errs...)
+	if !retryable {
+		return permanent(err)
+	}
+	return err
+}
+
+func devicesKey(recipientType, recipientID string) string {
+	return "devices:" + recipientType + ":" + recipientID
+}
+
+// getDevices returns the recipient's devices, most recently registered first.
+func getDevices(ctx context.Context, recipientType, recipientID string) ([]Device, error) {
+	raw, err := redisClient.HGetAll(ctx, devicesKey(recipientType, recipientID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	return parseDevices(raw), nil
+}
+
+func parseDevices(raw map[string]string) []Device {
+	devices := make([]Device, 0, len(raw))
+	for _, data := range raw {
+		var device Device
+		if json.Unmarshal([]byte(data), &device) == nil {
+			devices = append(devices, device)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].RegisteredAt.After(devices[j].RegisteredAt.Time)
+	})
+	return devices
+}
+
+// removeDevice drops a token the platform no longer accepts. Failing to is
+// only logged; the token is tried, and dropped, again next time.
+func removeDevice(ctx context.Context, recipientType, recipientID, token string) {
+	err := redisClient.HDel(ctx, devicesKey(recipientType, recipientID), token).Err()
+	if err != nil {
+		slog.Warn("error removing unregistered device", "recipient_type", recipientType, "recipient_id", recipientID, "error", err)
+		return
+	}
+	slog.Info("removed unregistered device", "recipient_type", recipientType, "recipient_id", recipientID)
+}
+
+// notificationRecipient is who the caller receives notifications as: a
+// customer by their subject, a rider by their rider ID.
+func notificationRecipient(c echo.Context) (string, string) {
+	claims := authClaims(c)
+	if claims.Role == roleRider {
+		return "rider", claims.RiderID
+	}
+	return "customer", claims.Subject
+}
+
+// registerDevice serves POST /notification/devices. Registering a token
+// again refreshes it; past PushMaxDevices the longest registered are
+// dropped.
+func registerDevice(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var device Device
+	if err := bindAndValidate(c, &device); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	recipientType, recipientID := notificationRecipient(c)
+	if recipientID == "" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token does not name a recipient"})
+	}
+
+	device.RegisteredAt = timestampNow()
+	deviceJSON, _ := json.Marshal(device)
+	key := devicesKey(recipientType, recipientID)
+	err := redisClient.HSet(ctx, key, device.Token, deviceJSON).Err()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to register device"})
+	}
+
+	devices, err := getDevices(ctx, recipientType, recipientID)
+	if err == nil && len(devices) > appConfig.PushMaxDevices {
+		stale := make([]string, 0, len(devices)-appConfig.PushMaxDevices)
+		for _, old := range devices[appConfig.PushMaxDevices:] {
+			stale = append(stale, old.Token)
+		}
+		err = redisClient.HDel(ctx, key, stale...).Err()
+	}
+	if err != nil {
+		requestLogger(c).Warn("error dropping old devices", "recipient_type", recipientType, "recipient_id", recipientID, "error", err)
+	}
+
+	requestLogger(c).Info("device registered", "recipient_type", recipientType, "recipient_id", recipientID, "platform", device.Platform)
+	return c.JSON(http.StatusOK, device)
+}
+
+// unregisterDevice serves DELETE /notification/devices/:token, for an app
+// signing out.
+func unregisterDevice(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	recipientType, recipientID := notificationRecipient(c)
+	removed, err := redisClient.HDel(ctx, devicesKey(recipientType, recipientID), c.Param("token")).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove device"})
+	}
+	if removed == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Device not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "removed"})
+}
+
+// FCMSender sends through Firebase Cloud Messaging's HTTP v1 API, with an
+// access token it gets for a service account and keeps until it expires.
+type FCMSender struct {
+	ProjectID   string
+	ClientEmail string
+	PrivateKey  *rsa.PrivateKey
+	TokenURL    string
+	BaseURL     string
+	Client      *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// fcmServiceAccount is the part of a Google service account key file FCM
+// needs.
+type fcmServiceAccount struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+func newFCMSender(credentialsFile string) (*FCMSender, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read FCM credentials: %w", err)
+	}
+	var account fcmServiceAccount
+	err = json.Unmarshal(data, &account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse FCM credentials: %w", err)
+	}
+	if account.ProjectID == "" || account.ClientEmail == "" {
+		return nil, fmt.Errorf("FCM credentials have no project_id or client_email")
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse FCM private key: %w", err)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	return &FCMSender{
+		ProjectID:   account.ProjectID,
+		ClientEmail: account.ClientEmail,
+		PrivateKey:  key,
+		TokenURL:    account.TokenURI,
+		BaseURL:     "https://fcm.googleapis.com",
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type fcmError struct {
+	Error struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Details []struct {
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+func (s *FCMSender) Push(ctx context.Context, token string, msg PushMessage) error {
+	accessToken, err := s.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token":        token,
+			"notification": map[string]string{"title": msg.Title, "body": msg.Body},
+			"data":         msg.Data,
+		},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.BaseURL+"/v1/projects/"+s.ProjectID+"/messages:send", bytes.NewReader(body))
+	if err != nil {
+		return permanent(fmt.Errorf("failed to build FCM request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("FCM request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return nil
+	}
+
+	var reply fcmError
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&reply)
+	for _, detail := range reply.Error.Details {
+		if detail.ErrorCode == "UNREGISTERED" {
+			return errDeviceUnregistered
+		}
+	}
+	err = fmt.Errorf("FCM returned status %d: %s %s", resp.StatusCode, reply.Error.Status, reply.Error.Message)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errDeviceUnregistered
+	case resp.StatusCode == http.StatusUnauthorized:
+		// The access token was revoked early; get another next time.
+		s.mu.Lock()
+		s.accessToken = ""
+		s.mu.Unlock()
+		return err
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return permanent(err)
+	}
+	return err
+}
+
+// token returns an access token for the service account, exchanging a
+// signed assertion for a new one when the last is about to expire.
+func (s *FCMSender) token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := clock.Now()
+	if s.accessToken != "" && now.Before(s.expiresAt.Add(-time.Minute)) {
+		return s.accessToken, nil
+	}
+
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   s.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/firebase.messaging",
+		"aud":   s.TokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(s.PrivateKey)
+	if err != nil {
+		return "", permanent(fmt.Errorf("failed to sign FCM assertion: %w", err))
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", permanent(fmt.Errorf("failed to build FCM token request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("FCM token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("FCM token request returned status %d", resp.StatusCode)
+	}
+
+	var grant struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&grant)
+	if err != nil || grant.AccessToken == "" {
+		return "", fmt.Errorf("FCM token response has no access token")
+	}
+
+	s.accessToken = grant.AccessToken
+	s.expiresAt = now.Add(time.Duration(grant.ExpiresIn) * time.Second)
+	return s.accessToken, nil
+}
+
+// apnsTokenLifetime is how long an APNs provider token is reused. Apple
+// rejects tokens older than an hour and throttles new ones more often than
+// every 20 minutes.
+const apnsTokenLifetime = 50 * time.Minute
+
+// APNsSender sends through the Apple Push Notification service over HTTP/2,
+// authenticating with a provider token signed by the team's .p8 key.
+type APNsSender struct {
+	KeyID      string
+	TeamID     string
+	Topic      string
+	PrivateKey *ecdsa.PrivateKey
+	BaseURL    string
+	Client     *http.Client
+
+	mu       sync.Mutex
+	jwt      string
+	issuedAt time.Time
+}
+
+func newAPNsSender(keyFile string) (*APNsSender, error) {
+	if appConfig.APNsKeyID == "" || appConfig.APNsTeamID == "" || appConfig.APNsTopic == "" {
+		return nil, fmt.Errorf("APNs needs APNS_KEY_ID, APNS_TEAM_ID and APNS_TOPIC")
+	}
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read APNs key: %w", err)
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse APNs key: %w", err)
+	}
+
+	baseURL := "https://api.push.apple.com"
+	if appConfig.APNsSandbox {
+		baseURL = "https://api.sandbox.push.apple.com"
+	}
+	return &APNsSender{
+		KeyID:      appConfig.APNsKeyID,
+		TeamID:     appConfig.APNsTeamID,
+		Topic:      appConfig.APNsTopic,
+		PrivateKey: key,
+		BaseURL:    baseURL,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// apnsUnregistered are the reasons APNs gives for a token that will never
+// be accepted again.
+var apnsUnregistered = map[string]bool{
+	"BadDeviceToken":         true,
+	"DeviceTokenNotForTopic": true,
+	"Unregistered":           true,
+}
+
+func (s *APNsSender) Push(ctx context.Context, token string, msg PushMessage) error {
+	providerToken, err := s.providerToken()
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{"title": msg.Title, "body": msg.Body},
+			"sound": "default",
+		},
+	}
+	for k, v := range msg.Data {
+		payload[k] = v
+	}
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.BaseURL+"/3/device/"+url.PathEscape(token), bytes.NewReader(body))
+	if err != nil {
+		return permanent(fmt.Errorf("failed to build APNs request: %w", err))
+	}
+	req.Header.Set("Authorization", "bearer "+providerToken)
+	req.Header.Set("apns-topic", s.Topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("APNs request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return nil
+	}
+
+	var reply struct {
+		Reason string `json:"reason"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&reply)
+	if resp.StatusCode == http.StatusGone || apnsUnregistered[reply.Reason] {
+		return errDeviceUnregistered
+	}
+	err = fmt.Errorf("APNs returned status %d: %s", resp.StatusCode, reply.Reason)
+	switch {
+	case reply.Reason == "ExpiredProviderToken":
+		s.mu.Lock()
+		s.jwt = ""
+		s.mu.Unlock()
+		return err
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return permanent(err)
+	}
+	return err
+}
+
+// providerToken returns the signed token APNs requests carry, signing a new
+// one once apnsTokenLifetime has passed.
+func (s *APNsSender) providerToken() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := clock.Now()
+	if s.jwt != "" && now.Sub(s.issuedAt) < apnsTokenLifetime {
+		return s.jwt, nil
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": s.TeamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = s.KeyID
+	signed, err := token.SignedString(s.PrivateKey)
+	if err != nil {
+		return "", permanent(fmt.Errorf("failed to sign APNs provider token: %w", err))
+	}
+
+	s.jwt = signed
+	s.issuedAt = now
+	return s.jwt, nil
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+// quoteLoadMaxAge is how old a zone load snapshot may be before it is
+// ignored; a dispatcher that has stopped running says nothing about surge.
+const quoteLoadMaxAge = time.Minute
+
+type QuoteRequest struct {
+	RestaurantID     string   `json:"restaurant_id" validate:"required"`
+	DeliveryLocation GeoPoint `json:"delivery_location"`
+}
+
+type Quote struct {
+	RestaurantID    string  `json:"restaurant_id"`
+	Open            bool    `json:"open"`
+	DistanceMeters  float64 `json:"distance_m"`
+	DeliveryFee     float64 `json:"delivery_fee"`
+	Currency        string  `json:"currency"`
+	ETAMinMinutes   int     `json:"eta_min_minutes"`
+	ETAMaxMinutes   int     `json:"eta_max_minutes"`
+	Surge           bool    `json:"surge"`
+	SurgeMultiplier float64 `json:"surge_multiplier"`
+}
+
+// quoteDelivery serves POST /quote: the delivery fee, ETA range and surge
+// status for delivering from a restaurant to a location, without creating
+// an order.
+func quoteDelivery(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req QuoteRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	restaurant, err := findRestaurant(ctx, req.RestaurantID)
+	if err == errRestaurantNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Restaurant not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant"})
+	}
+
+	quote, err := deliveryQuote(ctx, restaurant, req.DeliveryLocation)
+	var pe *pricingError
+	if errors.As(err, &pe) {
+		return validationFailed(c, pe.Field, pe.Message)
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to quote delivery"})
+	}
+	return c.JSON(http.StatusOK, quote)
+}
+
+// deliveryQuote prices delivery from restaurant to a location within its
+// delivery area; see delivery_area.go. Surge applies while the restaurant's
+// zone has at least SurgeDemandRatio waiting orders per free rider.
+func deliveryQuote(ctx context.Context, restaurant Restaurant, to GeoPoint) (Quote, error) {
+	distance := distanceMeters(restaurant.Lat, restaurant.Lng, to.Lat, to.Lng)
+	switch deliveryRefusal(restaurant, to, distance) {
+	case notServedTooFar:
+		return Quote{}, &pricingError{Field: "delivery_location", Message: "is outside the restaurant's delivery range"}
+	case notServedOutsideArea:
+		return Quote{}, &pricingError{Field: "delivery_location", Message: "is outside the restaurant's delivery area"}
+	}
+
+	load, err := getZoneLoad(ctx, zoneOrDefault(restaurant.Zone))
+	if err != nil {
+		slog.Warn("error fetching zone load, quoting without surge", "restaurant_id", restaurant.ID, "error", err)
+	}
+	surge := isSurging(load) && featureEnabled(flagSurgePricing, restaurant.ID)
+
+	multiplier := 1.0
+	if surge {
+		multiplier = appConfig.SurgeMultiplier
+	}
+	fee := (appConfig.DeliveryBaseFee + appConfig.DeliveryFeePerKm*distance/1000) * multiplier
+
+	// The low end assumes the food is ready on time and a rider is waiting;
+	// the high end allows for a slower route and, under surge, a wait for a
+	// rider.
+	travel := travelTime(distance)
+	etaMin := appConfig.RestaurantPrepTime + travel
+	etaMax := appConfig.RestaurantPrepTime + travel*3/2
+	if surge {
+		etaMax += appConfig.DispatchOfferTTL
+	}
+
+	return Quote{
+		RestaurantID:    restaurant.ID,
+		Open:            restaurantOpenAt(restaurant, clock.Now()),
+		DistanceMeters:  math.Round(distance),
+		DeliveryFee:     roundMoney(fee),
+		Currency:        appConfig.PaymentCurrency,
+		ETAMinMinutes:   int(math.Ceil(etaMin.Minutes())),
+		ETAMaxMinutes:   int(math.Ceil(etaMax.Minutes())),
+		Surge:           surge,
+		SurgeMultiplier: multiplier,
+	}, nil
+}
+
+func isSurging(load ZoneLoad) bool {
+	if load.Pending == 0 || clock.Since(load.At) > quoteLoadMaxAge {
+		return false
+	}
+	if load.FreeRiders == 0 {
+		return true
+	}
+	return float64(load.Pending)/float64(load.FreeRiders) >= appConfig.SurgeDemandRatio
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Rate limiting. Every request takes a token from its client IP's bucket and,
+// if it carries an X-API-Key header, from that key's bucket too; a request
+// either bucket cannot pay for is refused with 429 and a Retry-After. Reads
+// and writes have separate buckets and limits, so a client polling menus
+// cannot use up its allowance to place orders. A key issued with limits of
+// its own (see apikeys.go) has them for both. The buckets live in Redis and
+// are shared by every instance.
+
+const apiKeyHeader = "X-API-Key"
+
+// rateLimitExempt paths are hit by infrastructure, not clients.
+var rateLimitExempt = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+var rateLimitedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "rate_limited_requests_total",
+	Help: "Requests refused for exceeding a rate limit, by request class and what was limited.",
+}, []string{"class", "scope"})
+
+func init() {
+	prometheus.MustRegister(rateLimitedRequests)
+}
+
+// tokenBucket is a bucket of burst tokens refilled at perMinute a minute.
+type tokenBucket struct {
+	perMinute int
+	burst     int
+}
+
+// rateLimitClass is "read" for GET and HEAD requests and "write" otherwise.
+func rateLimitClass(method string) string {
+	if method == http.MethodGet || method == http.MethodHead {
+		return "read"
+	}
+	return "write"
+}
+
+func rateLimitBucket(class string) tokenBucket {
+	if class == "read" {
+		return tokenBucket{perMinute: appConfig.RateLimitReadPerMinute, burst: appConfig.RateLimitReadBurst}
+	}
+	return tokenBucket{perMinute: appConfig.RateLimitWritePerMinute, burst: appConfig.RateLimitWriteBurst}
+}
+
+func validateRateLimits() error {
+	if !appConfig.RateLimitEnabled {
+		return nil
+	}
+	for _, class := range []string{"read", "write"} {
+		bucket := rateLimitBucket(class)
+		if bucket.perMinute < 1 || bucket.burst < 1 {
+			return fmt.Errorf("%s rate limit of %d a minute in bursts of %d must be at least 1 for both", class, bucket.perMinute, bucket.burst)
+		}
+	}
+	return nil
+}
+
+// rateLimitScope is a bucket a request takes a token from: its client IP's
+// or its API key's.
+type rateLimitScope struct {
+	name   string
+	id     string
+	bucket tokenBucket
+}
+
+// apiKeyRateLimitScope is the bucket of the request's API key. An issued key
+// is limited by its ID, so its bucket outlives rotation, and by its own
+// limits if it has them; any other key, by its hash and the default ones.
+func apiKeyRateLimitScope(c echo.Context, secret string, bucket tokenBucket) rateLimitScope {
+	key, err := requestAPIKey(c)
+	if err != nil && err != errAPIKeyInvalid {
+		requestLogger(c).Warn("error looking up api key for rate limit", "error", err)
+	}
+	if key == nil {
+		// Keys are hashed so they are not left readable in Redis.
+		sum := sha256.Sum256([]byte(secret))
+		return rateLimitScope{name: "key", id: hex.EncodeToString(sum[:16]), bucket: bucket}
+	}
+	if key.RateLimitPerMinute > 0 {
+		bucket = tokenBucket{perMinute: key.RateLimitPerMinute, burst: key.RateLimitBurst}
+	}
+	return rateLimitScope{name: "key", id: key.ID, bucket: bucket}
+}
+
+func rateLimitKey(class, scope, id string) string {
+	return "ratelimit:" + class + ":" + scope + ":" + id
+}
+
+// takeToken takes one token from a bucket, refilling it first for the time
+// since it was last touched, and returns 1 and 0 if there was one, or 0 and
+// how many milliseconds until there will be. Time comes from Redis so every
+// instance refills alike. An untouched bucket starts full, and expires once
+// it would be full again.
+// KEYS[1] bucket; ARGV[1] burst, ARGV[2] tokens added per millisecond.
+var takeToken = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "at")
+local tokens = tonumber(bucket[1]) or burst
+local at = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - at) * rate)
+
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "at", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate))
+return {allowed, wait}
+`)
+
+// allow takes a token from key's bucket and reports whether there was one
+// and, if not, how long until there will be, in whole seconds.
+func (b tokenBucket) allow(ctx context.Context, key string) (bool, int, error) {
+	rate := float64(b.perMinute) / 60000
+	result, err := takeToken.Run(ctx, redisClient, []string{key}, b.burst, rate).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("redis error: %v", err)
+	}
+	if result[0] == 1 {
+		return true, 0, nil
+	}
+	return false, int((result[1] + 999) / 1000), nil
+}
+
+// rateLimiting refuses requests over their client's limits. If Redis cannot
+// be reached requests are let through: an outage should not also take the
+// API down.
+func rateLimiting(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !appConfig.RateLimitEnabled || rateLimitExempt[c.Path()] {
+			return next(c)
+		}
+
+		class := rateLimitClass(c.Request().Method)
+		bucket := rateLimitBucket(class)
+
+		scopes := []rateLimitScope{{name: "ip", id: c.RealIP(), bucket: bucket}}
+		if secret := c.Request().Header.Get(apiKeyHeader); secret != "" {
+			scopes = append(scopes, apiKeyRateLimitScope(c, secret, bucket))
+		}
+
+		for _, scope := range scopes {
+			allowed, retryAfter, err := scope.bucket.allow(c.Request().Context(), rateLimitKey(class, scope.name, scope.id))
+			if err != nil {
+				requestLogger(c).Warn("error checking rate limit, allowing request", "error", err)
+				return next(c)
+			}
+			if !allowed {
+				rateLimitedRequests.WithLabelValues(class, scope.name).Inc()
+				requestLogger(c).Info("request rate limited", "class", class, "scope", scope.name)
+				c.Response().Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+				return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "Too many requests"})
+			}
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,211 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"myproject/src/clock"
+)
+
+// Ready-time commitments. A restaurant accepting an order says when it will
+// be ready for pickup. Riders are only sought once the order is nearly
+// ready: an OrderReadySoon event is emitted DispatchReadyLead before the
+// ready-by time, and the dispatcher queues the order on it, or straight away
+// if the order is accepted with less notice than that. If the order has not
+// been picked up OrderReadyLateAfter past its ready-by time, OrderRunningLate
+// tells the customer. Both events wait in a sorted set, scored by when they
+// are due, until a sweep emits them.
+
+const (
+	readyCountdownKey   = "orders:ready_countdown"
+	readyCountdownLock  = "orders:ready_countdown:lock"
+	readyCountdownBatch = 100
+
+	countdownReadySoon   = "soon"
+	countdownRunningLate = "late"
+)
+
+// commitReadyBy is the ready-by time req commits to for order, or the field
+// that is wrong with it.
+func commitReadyBy(order Order, req AcceptOrderRequest) (time.Time, error) {
+	now := clock.Now()
+	var readyBy time.Time
+	switch {
+	case req.ReadyBy != nil && req.PrepMinutes > 0:
+		return time.Time{}, invalidField("ready_by", "give ready_by or prep_minutes, not both")
+	case req.ReadyBy != nil:
+		readyBy = req.ReadyBy.Time
+	case req.PrepMinutes > 0:
+		readyBy = now.Add(time.Duration(req.PrepMinutes) * time.Minute)
+	default:
+		return time.Time{}, invalidField("ready_by", "is required, or prep_minutes")
+	}
+
+	if !readyBy.After(now) {
+		return time.Time{}, invalidField("ready_by", "must be in the future")
+	}
+	// A scheduled order may be accepted well before it is wanted.
+	latest := now.Add(appConfig.OrderReadyByMax)
+	if order.ScheduledAt != nil && order.ScheduledAt.After(latest) {
+		latest = order.ScheduledAt.Time
+	}
+	if readyBy.After(latest) {
+		return time.Time{}, invalidField("ready_by", "is too far ahead")
+	}
+	return readyBy.UTC().Truncate(time.Second), nil
+}
+
+// scheduleReadyCountdown queues the order's ready-soon and running-late
+// events. It runs before the order is saved as accepted, so an accepted
+// order always has them; the sweep ignores them for an order that was not.
+func scheduleReadyCountdown(ctx context.Context, rdb redis.Cmdable, orderID string, readyBy time.Time) error {
+	members := []*redis.Z{
+		{Score: float64(readyBy.Add(appConfig.OrderReadyLateAfter).UnixMilli()), Member: countdownRunningLate + ":" + orderID},
+	}
+	if soon := readyBy.Add(-appConfig.DispatchReadyLead); soon.After(clock.Now()) {
+		members = append(members, &redis.Z{Score: float64(soon.UnixMilli()), Member: countdownReadySoon + ":" + orderID})
+	}
+	err := rdb.ZAdd(ctx, readyCountdownKey, members...).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// rescheduleReadyCountdown moves the countdown of an accepted order to a new
+// ready-by time. A ready-soon event not yet emitted is brought forward to
+// now at the earliest, so an order now due sooner is still dispatched; one
+// already emitted is not repeated.
+func rescheduleReadyCountdown(ctx context.Context, orderID string, readyBy time.Time) error {
+	pending, err := redisClient.ZRem(ctx, readyCountdownKey, countdownReadySoon+":"+orderID).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	members := []*redis.Z{
+		{Score: float64(readyBy.Add(appConfig.OrderReadyLateAfter).UnixMilli()), Member: countdownRunningLate + ":" + orderID},
+	}
+	if pending > 0 {
+		soon := max(readyBy.Add(-appConfig.DispatchReadyLead).UnixMilli(), clock.Now().UnixMilli())
+		members = append(members, &redis.Z{Score: float64(soon), Member: countdownReadySoon + ":" + orderID})
+	}
+	err = redisClient.ZAdd(ctx, readyCountdownKey, members...).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// nearlyReady reports whether the order in event is due to be ready within
+// DispatchReadyLead. Orders accepted without a ready-by time always are.
+func nearlyReady(event OrderEvent) bool {
+	return event.ReadyBy == nil || !clock.Now().Before(event.ReadyBy.Add(-appConfig.DispatchReadyLead))
+}
+
+// queueWhenNearlyReady sends an accepted order to dispatch now if it is
+// nearly ready; otherwise its OrderReadySoon event will.
+func queueWhenNearlyReady(ctx context.Context, event OrderEvent) error {
+	if !nearlyReady(event) {
+		eventLogger(event).Info("dispatch waits for order to be nearly ready", "ready_by", event.ReadyBy.Time)
+		return nil
+	}
+	return queueForDispatch(ctx, event)
+}
+
+func notifyOrderRunningLate(ctx context.Context, event OrderEvent) error {
+	return notifyTracking(ctx, event, "order_running_late", "", nil)
+}
+
+// runReadyCountdown emits countdown events as they fall due, polling at
+// interval until ctx is cancelled.
+func runReadyCountdown(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := emitDueCountdowns(ctx, interval)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("emitting ready countdown events failed", "error", err)
+		}
+	}
+}
+
+// emitDueCountdowns emits each due countdown event whose order is still
+// waiting to be picked up. One that fails is tried again next time.
+func emitDueCountdowns(ctx context.Context, interval time.Duration) error {
+	locked, err := redisClient.SetNX(ctx, readyCountdownLock, 1, interval).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if !locked {
+		return nil
+	}
+	defer redisClient.Del(ctx, readyCountdownLock)
+
+	due, err := redisClient.ZRangeByScore(ctx, readyCountdownKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(clock.Now().UnixMilli(), 10),
+		Count: readyCountdownBatch,
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	for _, member := range due {
+		kind, orderID, _ := strings.Cut(member, ":")
+		err := emitCountdown(ctx, kind, orderID)
+		if err != nil {
+			slog.Error("error emitting ready countdown event", "order_id", orderID, "countdown", kind, "error", err)
+			continue
+		}
+		redisClient.ZRem(ctx, readyCountdownKey, member)
+	}
+	return nil
+}
+
+func emitCountdown(ctx context.Context, kind, orderID string) error {
+	order, err := getOrder(ctx, orderID)
+	if err == errOrderNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// Only an accepted order not yet picked up is waited on.
+	if order.Status != "accepted" || order.ReadyBy == nil {
+		return nil
+	}
+
+	var event OrderEvent
+	switch kind {
+	case countdownReadySoon:
+		event = newOrderEvent(ctx, eventOrderReadySoon, order)
+	case countdownRunningLate:
+		event = newOrderEvent(ctx, eventOrderRunningLate, order)
+		event.Reason = "Not picked up by the time the restaurant committed to"
+	default:
+		return nil
+	}
+	return queueOrderEvents(ctx, event)
+}
+
+// queueOrderEvents adds events about an order to the outbox without
+// rewriting the order, for events that do not change it.
+func queueOrderEvents(ctx context.Context, events ...OrderEvent) error {
+	err := repositories.Orders.AddEvents(ctx, events...)
+	if err != nil {
+		return err
+	}
+	wakeOutboxRelay()
+	return nil
+}
@@ -0,0 +1,214 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"myproject/src/rng"
+)
+
+// Redis retries. A command that fails for want of a connection, or because
+// Redis is loading, failing over or out of clients, is sent again up to
+// RedisMaxRetries times, backing off exponentially with jitter from
+// RedisRetryBackoff to RedisRetryBackoffMax, so a blip costs a request a
+// few milliseconds instead of a 500. Each attempt has RedisOpTimeout.
+//
+// Every attempt and backoff also keeps to the deadline of the context the
+// command was given. For API requests that is the request's, which
+// RequestTimeout sets (see requestDeadline), so retries never outlast the
+// request: a backoff that would run past the deadline is not waited out.
+//
+// A command is only sent again if the first attempt cannot have been
+// applied, or applying it twice does no harm. Redis refusing it, or the
+// connection failing before it was written, is safe to retry for any
+// command. A connection that fails or times out afterwards may have carried
+// the command to Redis, which applied it and could not answer, so only
+// read-only commands are retried then; a write such as a SETNX or a stock
+// script would otherwise be applied twice.
+//
+// Only single commands are retried. A pipeline or transaction may have been
+// partly applied before its connection failed, so it fails as it is, and a
+// redisClient.Watch caller retries it as a whole. go-redis's own retries
+// are switched off in redisOptions, so a command is not retried twice over.
+
+var (
+	redisRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_retries_total",
+		Help: "Redis commands sent again after a transient failure, partitioned by command.",
+	}, []string{"command"})
+
+	redisRetryOutcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_retry_outcomes_total",
+		Help: "Redis commands that were retried, partitioned by outcome (recovered, exhausted, deadline).",
+	}, []string{"outcome"})
+)
+
+func init() {
+	prometheus.MustRegister(redisRetries, redisRetryOutcomes)
+}
+
+// redisOptions are the options of the client for the Redis at addr.
+func redisOptions(addr string) *redis.Options {
+	return &redis.Options{
+		Addr:       addr,
+		MaxRetries: -1,
+	}
+}
+
+// redisRefusals start the errors Redis answers with while it cannot serve
+// a command for now. It has not run the command when it answers with one.
+var redisRefusals = []string{"LOADING ", "READONLY ", "TRYAGAIN ", "CLUSTERDOWN ", "ERR max number of clients reached"}
+
+// redisPoolTimeout is go-redis's error for a command that got no
+// connection, which it does not export.
+const redisPoolTimeout = "redis: connection pool timeout"
+
+// redisReadOnly are the commands that change nothing, so may be sent again
+// however the first attempt failed.
+var redisReadOnly = map[string]bool{
+	"dbsize": true, "exists": true, "get": true, "getrange": true, "mget": true, "strlen": true,
+	"ttl": true, "pttl": true, "type": true, "ping": true, "scan": true, "keys": true,
+	"hexists": true, "hget": true, "hgetall": true, "hkeys": true, "hlen": true, "hmget": true, "hscan": true, "hvals": true,
+	"lindex": true, "llen": true, "lrange": true,
+	"scard": true, "sismember": true, "smembers": true, "smismember": true, "srandmember": true, "sscan": true,
+	"zcard": true, "zcount": true, "zrange": true, "zrangebyscore": true, "zrank": true, "zrevrange": true,
+	"zrevrangebyscore": true, "zrevrank": true, "zscan": true, "zscore": true, "zmscore": true,
+	"geodist": true, "geopos": true, "georadius_ro": true, "georadiusbymember_ro": true, "geosearch": true,
+	"xlen": true, "xrange": true, "xrevrange": true,
+}
+
+// redisRetryable reports whether cmd, having failed with err, may succeed
+// if sent again without risk of being applied twice.
+func redisRetryable(cmd redis.Cmder, err error) bool {
+	if errors.Is(err, errCircuitOpen) {
+		return false
+	}
+	var replyErr redis.Error
+	if errors.As(err, &replyErr) {
+		for _, prefix := range redisRefusals {
+			if strings.HasPrefix(replyErr.Error(), prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	if !redisFailed(err) {
+		return false
+	}
+	return redisUnsent(err) || redisReadOnly[cmd.Name()]
+}
+
+// redisUnsent reports whether err means a command never reached a
+// connection to Redis: dialing failed, or the pool had no connection.
+func redisUnsent(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return err.Error() == redisPoolTimeout
+}
+
+// redisRetryBackoff is how long to wait before the given retry, counting
+// from 1: half the exponential delay for that retry plus up to as much
+// again at random, so clients that failed together do not retry together.
+func redisRetryBackoff(retry int) time.Duration {
+	delay := appConfig.RedisRetryBackoff << (retry - 1)
+	if delay <= 0 || delay > appConfig.RedisRetryBackoffMax {
+		delay = appConfig.RedisRetryBackoffMax
+	}
+	half := delay / 2
+	return half + time.Duration(rng.Float64()*float64(delay-half))
+}
+
+type redisAttemptKey struct{}
+
+// redisAttempt is a command's first attempt, as redisRetryHook started it.
+type redisAttempt struct {
+	parent context.Context
+	cancel context.CancelFunc
+}
+
+// redisRetryHook times out and retries single commands. It must be the last
+// hook added, so the hooks before it see only the final result.
+type redisRetryHook struct {
+	client *redis.Client
+}
+
+func newRedisRetryHook(client *redis.Client) redis.Hook {
+	return redisRetryHook{client: client}
+}
+
+func (h redisRetryHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if appConfig.RedisOpTimeout <= 0 {
+		return context.WithValue(ctx, redisAttemptKey{}, redisAttempt{parent: ctx}), nil
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, appConfig.RedisOpTimeout)
+	return context.WithValue(attemptCtx, redisAttemptKey{}, redisAttempt{parent: ctx, cancel: cancel}), nil
+}
+
+func (h redisRetryHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	attempt, ok := ctx.Value(redisAttemptKey{}).(redisAttempt)
+	if !ok {
+		return nil
+	}
+	if attempt.cancel != nil {
+		attempt.cancel()
+	}
+	if !redisRetryable(cmd, cmd.Err()) || attempt.parent.Err() != nil {
+		return nil
+	}
+
+	parent := attempt.parent
+	for retry := 1; retry <= appConfig.RedisMaxRetries; retry++ {
+		backoff := redisRetryBackoff(retry)
+		if deadline, ok := parent.Deadline(); ok && time.Until(deadline) <= backoff {
+			redisRetryOutcomes.WithLabelValues("deadline").Inc()
+			return nil
+		}
+		select {
+		case <-parent.Done():
+			redisRetryOutcomes.WithLabelValues("deadline").Inc()
+			return nil
+		case <-time.After(backoff):
+		}
+
+		redisRetries.WithLabelValues(cmd.Name()).Inc()
+		h.resend(parent, cmd)
+		if !redisRetryable(cmd, cmd.Err()) {
+			if cmd.Err() == nil || cmd.Err() == redis.Nil {
+				redisRetryOutcomes.WithLabelValues("recovered").Inc()
+			}
+			return nil
+		}
+	}
+	redisRetryOutcomes.WithLabelValues("exhausted").Inc()
+	return nil
+}
+
+// resend sends cmd again on a connection of its own, past the hooks, which
+// have already run for it.
+func (h redisRetryHook) resend(parent context.Context, cmd redis.Cmder) {
+	attemptCtx := parent
+	if appConfig.RedisOpTimeout > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithTimeout(parent, appConfig.RedisOpTimeout)
+		defer cancel()
+	}
+	conn := h.client.Conn(attemptCtx)
+	defer conn.Close()
+	conn.Process(attemptCtx, cmd)
+}
+
+func (h redisRetryHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h redisRetryHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Data residency. A deployment serves one region, named by REGION. Its Kafka
+// topics and consumer groups, Redis keys and pub/sub channels, and media
+// files all carry the region, so two regions sharing a broker, a Redis or a
+// bucket never see each other's data. Without REGION nothing is prefixed,
+// which keeps single-region deployments as they were.
+
+var regionPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+func validateRegion(region string) error {
+	if region != "" && !regionPattern.MatchString(region) {
+		return fmt.Errorf("region %q must be lowercase letters, digits and dashes", region)
+	}
+	return nil
+}
+
+// regionTopic names a Kafka topic or consumer group for this region.
+func regionTopic(name string) string {
+	if appConfig.Region == "" {
+		return name
+	}
+	return appConfig.Region + "." + name
+}
+
+func regionKeyPrefix() string {
+	if appConfig.Region == "" {
+		return ""
+	}
+	return appConfig.Region + ":"
+}
+
+// regionKey prefixes a Redis key or channel. Commands sent through
+// redisClient are prefixed by regionKeyHook; only pub/sub subscriptions,
+// which bypass hooks, need to call this themselves.
+func regionKey(key string) string {
+	return regionKeyPrefix() + key
+}
+
+// regionMediaPath places a media file under this region's directory.
+func regionMediaPath(key string) string {
+	if appConfig.Region == "" {
+		return key
+	}
+	return path.Join(appConfig.Region, key)
+}
+
+// keylessCommands take no keys; every other command is assumed to take a key
+// as its first argument unless listed in keyPositions.
+var keylessCommands = map[string]bool{
+	"auth": true, "client": true, "command": true, "config": true, "dbsize": true,
+	"discard": true, "echo": true, "exec": true, "hello": true, "info": true,
+	"multi": true, "ping": true, "quit": true, "readonly": true, "script": true,
+	"select": true, "time": true, "unwatch": true,
+}
+
+// keyPositions returns the indexes of the key arguments of commands whose
+// keys are not just their first argument.
+func keyPositions(args []interface{}) []int {
+	var positions []int
+	switch strings.ToLower(fmt.Sprint(args[0])) {
+	case "del", "exists", "mget", "sinter", "sunion", "sdiff", "touch", "unlink", "watch":
+		for i := 1; i < len(args); i++ {
+			positions = append(positions, i)
+		}
+	case "mset", "msetnx":
+		for i := 1; i < len(args); i += 2 {
+			positions = append(positions, i)
+		}
+	case "rename", "renamenx", "smove", "lmove", "rpoplpush", "geosearchstore":
+		positions = []int{1, 2}
+	case "eval", "evalsha":
+		numKeys, _ := strconv.Atoi(fmt.Sprint(args[2]))
+		for i := 3; i < 3+numKeys && i < len(args); i++ {
+			positions = append(positions, i)
+		}
+	case "memory":
+		// MEMORY USAGE key; the other subcommands take no key.
+		if strings.EqualFold(fmt.Sprint(args[1]), "usage") && len(args) > 2 {
+			positions = []int{2}
+		}
+	case "zunionstore", "zinterstore":
+		positions = []int{1}
+		numKeys, _ := strconv.Atoi(fmt.Sprint(args[2]))
+		for i := 3; i < 3+numKeys && i < len(args); i++ {
+			positions = append(positions, i)
+		}
+	default:
+		positions = []int{1}
+	}
+	return positions
+}
+
+// regionKeyHook prefixes the keys of every command with the region, and
+// strips the prefix from keys returned by SCAN so they can be used again.
+type regionKeyHook struct {
+	prefix string
+}
+
+func newRegionKeyHook(region string) redis.Hook {
+	return regionKeyHook{prefix: region + ":"}
+}
+
+// prefixArgs rewrites cmd's arguments in place. SCAN must come with a MATCH
+// pattern, which is confined to the region; without one it would walk every
+// region's keys.
+func (h regionKeyHook) prefixArgs(cmd redis.Cmder) error {
+	args := cmd.Args()
+	name := strings.ToLower(fmt.Sprint(args[0]))
+	if keylessCommands[name] || len(args) < 2 {
+		return nil
+	}
+
+	if name == "scan" {
+		for i := 1; i < len(args)-1; i++ {
+			if strings.EqualFold(fmt.Sprint(args[i]), "match") {
+				args[i+1] = h.prefix + fmt.Sprint(args[i+1])
+				return nil
+			}
+		}
+		return fmt.Errorf("SCAN without MATCH is not allowed with a region set")
+	}
+
+	for _, i := range keyPositions(args) {
+		if key, ok := args[i].(string); ok {
+			args[i] = h.prefix + key
+		}
+	}
+	return nil
+}
+
+func (h regionKeyHook) stripScan(cmd redis.Cmder) {
+	scan, ok := cmd.(*redis.ScanCmd)
+	if !ok {
+		return
+	}
+	keys, _ := scan.Val()
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, h.prefix)
+	}
+}
+
+func (h regionKeyHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, h.prefixArgs(cmd)
+}
+
+func (h regionKeyHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.stripScan(cmd)
+	return nil
+}
+
+func (h regionKeyHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	for _, cmd := range cmds {
+		if err := h.prefixArgs(cmd); err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+func (h regionKeyHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		h.stripScan(cmd)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"myproject/src/clock"
+)
+
+const (
+	statOrdersCreated   = "orders_created"
+	statOrdersCancelled = "orders_cancelled"
+	statSLABreaches     = "sla_breaches"
+	statLagIncidents    = "consumer_lag_incidents"
+
+	dailyStatRetention = 8 * 24 * time.Hour
+)
+
+type DailyReport struct {
+	Date             string
+	OrdersCreated    int64
+	OrdersCancelled  int64
+	CancellationRate float64
+	SLABreaches      int64
+	LagIncidents     int64
+}
+
+// dailyReportKey marks the day's report as taken by one instance, so
+// operators get one email however many replicas run the job.
+func dailyReportKey(day time.Time) string {
+	return "report:daily:" + day.UTC().Format("2006-01-02")
+}
+
+func dailyStatKey(day time.Time, name string) string {
+	return fmt.Sprintf("stats:%s:%s", day.UTC().Format("2006-01-02"), name)
+}
+
+// recordDailyStat bumps a per-day counter used by the operations report.
+// Failures are logged rather than returned so reporting never blocks the
+// order flow.
+func recordDailyStat(ctx context.Context, rdb redis.Cmdable, name string) {
+	key := dailyStatKey(clock.Now(), name)
+	pipe := rdb.TxPipeline()
+	pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, dailyStatRetention)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		slog.Error("error recording stat", "stat", name, "error", err)
+	}
+}
+
+func buildDailyReport(ctx context.Context, day time.Time) (DailyReport, error) {
+	report := DailyReport{Date: day.UTC().Format("2006-01-02")}
+
+	counters := map[string]*int64{
+		statOrdersCreated:   &report.OrdersCreated,
+		statOrdersCancelled: &report.OrdersCancelled,
+		statSLABreaches:     &report.SLABreaches,
+		statLagIncidents:    &report.LagIncidents,
+	}
+	for name, dst := range counters {
+		value, err := redisClient.Get(ctx, dailyStatKey(day, name)).Int64()
+		if err != nil && err != redis.Nil {
+			return DailyReport{}, fmt.Errorf("failed to read stat %s: %v", name, err)
+		}
+		*dst = value
+	}
+
+	if report.OrdersCreated > 0 {
+		report.CancellationRate = float64(report.OrdersCancelled) / float64(report.OrdersCreated)
+	}
+	return report, nil
+}
+
+func (r DailyReport) Body() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Daily operations report for %s\n\n", r.Date)
+	fmt.Fprintf(&b, "Orders created:          %d\n", r.OrdersCreated)
+	fmt.Fprintf(&b, "Orders cancelled:        %d\n", r.OrdersCancelled)
+	fmt.Fprintf(&b, "Cancellation rate:       %.1f%%\n", r.CancellationRate*100)
+	fmt.Fprintf(&b, "Delivery SLA breaches:   %d\n", r.SLABreaches)
+	fmt.Fprintf(&b, "Consumer lag incidents:  %d\n", r.LagIncidents)
+	return b.String()
+}
+
+// runDailyReportJob sends the previous day's report to the operator list at
+// the configured hour (UTC) until ctx is cancelled. Only the instance that
+// claims the day sends it.
+func runDailyReportJob(ctx context.Context, sender EmailSender, recipients []string, hour int) {
+	if len(recipients) == 0 {
+		slog.Info("no report recipients configured, daily report job disabled")
+		return
+	}
+
+	for {
+		next := nextReportTime(clock.Now().UTC(), hour)
+		timer := time.NewTimer(clock.Until(next))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		day := next.Add(-24 * time.Hour)
+		claimed, err := redisClient.SetNX(ctx, dailyReportKey(day), 1, dailyStatRetention).Result()
+		if err != nil {
+			slog.Error("error claiming daily report", "error", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		report, err := buildDailyReport(ctx, day)
+		if err != nil {
+			slog.Error("error building daily report", "error", err)
+			continue
+		}
+
+		err = sender.Send(recipients, "Daily operations report "+report.Date, report.Body())
+		if err != nil {
+			slog.Error("error sending daily report", "error", err)
+			continue
+		}
+		slog.Info("daily report sent", "date", report.Date, "recipients", len(recipients))
+	}
+}
+
+func nextReportTime(now time.Time, hour int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.Add(24 * time.Hour)
+	}
+	return next
+}
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Repositories. Orders, and the restaurants, riders and menus they are
+// placed against, are kept behind the interfaces below; STORE picks where:
+//
+//   - redis, the default: orders in Redis, and restaurants, riders and menus
+//     read from restaurants.json, rider.json and menu.json.
+//   - memory: everything in process, the catalog seeded from those files if
+//     they exist. Nothing survives a restart; for development and tests.
+//   - postgres: everything in DATABASE_URL, migrated on startup. With
+//     STORE_SEED=true the files are imported first, which is how a
+//     deployment moves off them.
+//
+// Redis still holds the caches, indexes and short-lived state built around
+// these records, whichever store is in use.
+
+const (
+	storeRedis    = "redis"
+	storeMemory   = "memory"
+	storePostgres = "postgres"
+)
+
+// OrderRepository stores orders and the outbox of events their changes
+// raise. An order and the events saved with it are written together or not
+// at all, so the relay never publishes a change that was not made nor misses
+// one that was.
+type OrderRepository interface {
+	// Create stores a new order with its events unless its ID is taken,
+	// reporting whether it did.
+	Create(ctx context.Context, order Order, events ...OrderEvent) (bool, error)
+	// Get returns the order, or errOrderNotFound.
+	Get(ctx context.Context, orderID string) (Order, error)
+	// Save stores the order with its events in place of the version before
+	// it, failing with errOrderChanged if another version is stored, or
+	// errOrderNotFound if none is.
+	Save(ctx context.Context, order Order, events ...OrderEvent) error
+	// Update applies change to the stored order and saves the result with
+	// the events change returns. It fails with errOrderChanged if the order
+	// is written, or a payment started on it, between the two. change is
+	// left to advance the version.
+	Update(ctx context.Context, orderID string, change func(order *Order) ([]OrderEvent, error)) error
+	// CustomerOrders returns up to limit of the customer's orders, newest
+	// first, created before the Unix millisecond time before, or the newest
+	// if before is 0.
+	CustomerOrders(ctx context.Context, customerID string, before int64, limit int) ([]Order, error)
+	// RestaurantOrders returns the restaurant's orders created from from
+	// until to, oldest first.
+	RestaurantOrders(ctx context.Context, restaurantID string, from, to time.Time) ([]Order, error)
+	// RiderOrders returns the orders the rider is carrying, oldest first.
+	RiderOrders(ctx context.Context, riderID string) ([]Order, error)
+	// Scan returns a batch of about count orders as stored, in JSON,
+	// starting at cursor, with the cursor of the next batch: 0 once every
+	// order has been returned.
+	Scan(ctx context.Context, cursor uint64, count int) ([]string, uint64, error)
+	// Delete removes the order, its place in the customer's, restaurant's
+	// and rider's orders, and what is kept in Redis beside it: its code,
+	// issues, messages, event log and proof photos. Deleting during a Scan
+	// does not make it skip orders.
+	Delete(ctx context.Context, order Order) error
+
+	// AddEvents queues events not tied to a change of order.
+	AddEvents(ctx context.Context, events ...OrderEvent) error
+	// PendingEvents returns up to limit queued events, oldest first.
+	PendingEvents(ctx context.Context, limit int) ([]outboxEvent, error)
+	// RemoveEvents takes published events off the queue.
+	RemoveEvents(ctx context.Context, events ...outboxEvent) error
+}
+
+// outboxEvent is a queued OrderEvent as the store keeps it.
+type outboxEvent struct {
+	ID   string
+	Data string
+}
+
+type RestaurantRepository interface {
+	// Restaurants returns every restaurant. The slice is the caller's to
+	// change.
+	Restaurants(ctx context.Context) ([]Restaurant, error)
+}
+
+type RiderRepository interface {
+	Riders(ctx context.Context) ([]Rider, error)
+}
+
+type MenuRepository interface {
+	// Menu returns the restaurant's menu, or errMenuNotFound.
+	Menu(ctx context.Context, restaurantID string) (RestaurantMenu, error)
+}
+
+// Repositories is the store STORE selected.
+type Repositories struct {
+	Orders      OrderRepository
+	Restaurants RestaurantRepository
+	Riders      RiderRepository
+	Menus       MenuRepository
+
+	// ping checks the store is reachable, for stores other than Redis,
+	// which readiness checks already.
+	ping  func(ctx context.Context) error
+	close func()
+}
+
+var repositories Repositories
+
+func validateStore(store string) error {
+	switch store {
+	case storeRedis, storeMemory:
+		return nil
+	case storePostgres:
+		if appConfig.DatabaseURL == "" {
+			return fmt.Errorf("store %s needs DATABASE_URL", store)
+		}
+		return nil
+	}
+	return fmt.Errorf("store %q must be %s, %s or %s", store, storeRedis, storeMemory, storePostgres)
+}
+
+// openRepositories opens the store STORE names.
+func openRepositories(ctx context.Context) (Repositories, error) {
+	switch appConfig.Store {
+	case storeMemory:
+		catalog, err := readCatalogFiles()
+		if err != nil {
+			return Repositories{}, err
+		}
+		return newMemoryRepositories(catalog), nil
+	case storePostgres:
+		return openPostgresRepositories(ctx, appConfig.DatabaseURL, appConfig.StoreSeed)
+	}
+	return newRedisRepositories(), nil
+}
+
+// catalogFiles is what the JSON files hold, for seeding other stores.
+type catalogFiles struct {
+	Restaurants []Restaurant
+	Riders      []Rider
+	Menus       menuCatalog
+}
+
+// readCatalogFiles reads whichever of the catalog files exist.
+func readCatalogFiles() (catalogFiles, error) {
+	var catalog catalogFiles
+	var err error
+	catalog.Restaurants, err = fetchRestaurantFromJSON(restaurantsFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return catalogFiles{}, err
+	}
+	catalog.Riders, err = fetchRidersFromJSON(ridersFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return catalogFiles{}, err
+	}
+	catalog.Menus, err = loadJSONFile[menuCatalog](menuFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return catalogFiles{}, fmt.Errorf("failed to load menu file: %v", err)
+	}
+	return catalog, nil
+}
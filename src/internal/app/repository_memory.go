@@ -0,0 +1,261 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The memory store keeps orders and the catalog in process, behind one lock.
+// Orders are held encoded, so no caller shares one with another.
+
+type memoryStore struct {
+	mu sync.RWMutex
+
+	restaurants []Restaurant
+	riders      []Rider
+	menus       menuCatalog
+
+	orders map[string][]byte
+	// orderIDs lists orders in the order they were created; Scan's cursor
+	// is a position in it. Deleted orders keep their place, so cursors
+	// handed out stay where they were.
+	orderIDs []string
+
+	outbox   []outboxEvent
+	outboxID int
+}
+
+func newMemoryRepositories(catalog catalogFiles) Repositories {
+	store := &memoryStore{
+		restaurants: catalog.Restaurants,
+		riders:      catalog.Riders,
+		menus:       catalog.Menus,
+		orders:      map[string][]byte{},
+	}
+	return Repositories{
+		Orders:      store,
+		Restaurants: store,
+		Riders:      store,
+		Menus:       store,
+		close:       func() {},
+	}
+}
+
+func (s *memoryStore) Restaurants(ctx context.Context) ([]Restaurant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Restaurant(nil), s.restaurants...), nil
+}
+
+func (s *memoryStore) Riders(ctx context.Context) ([]Rider, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Rider(nil), s.riders...), nil
+}
+
+func (s *memoryStore) Menu(ctx context.Context, restaurantID string) (RestaurantMenu, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	menu, ok := s.menus[restaurantID]
+	if !ok {
+		return RestaurantMenu{}, errMenuNotFound
+	}
+	menu.Menu = slices.Clone(menu.Menu)
+	return menu, nil
+}
+
+// queue adds events to the outbox. The caller holds the lock.
+func (s *memoryStore) queue(events []OrderEvent) error {
+	encoded := make([]outboxEvent, 0, len(events))
+	for _, event := range events {
+		data, err := encodeOutboxEvent(&event)
+		if err != nil {
+			return err
+		}
+		s.outboxID++
+		encoded = append(encoded, outboxEvent{ID: strconv.Itoa(s.outboxID), Data: data})
+	}
+	s.outbox = append(s.outbox, encoded...)
+	return nil
+}
+
+// put stores order, queueing events with it. The caller holds the lock.
+func (s *memoryStore) put(order Order, events []OrderEvent) error {
+	data, err := json.Marshal(order)
+	if err != nil {
+		return fmt.Errorf("failed to marshal order: %v", err)
+	}
+	if err := s.queue(events); err != nil {
+		return err
+	}
+	if _, ok := s.orders[order.OrderID]; !ok {
+		s.orderIDs = append(s.orderIDs, order.OrderID)
+	}
+	s.orders[order.OrderID] = data
+	return nil
+}
+
+// get decodes a stored order. The caller holds the lock.
+func (s *memoryStore) get(orderID string) (Order, error) {
+	data, ok := s.orders[orderID]
+	if !ok {
+		return Order{}, errOrderNotFound
+	}
+	var order Order
+	err := json.Unmarshal(data, &order)
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to parse order: %v", err)
+	}
+	return order, nil
+}
+
+func (s *memoryStore) Create(ctx context.Context, order Order, events ...OrderEvent) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, taken := s.orders[order.OrderID]; taken {
+		return false, nil
+	}
+	return true, s.put(order, events)
+}
+
+func (s *memoryStore) Get(ctx context.Context, orderID string) (Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.get(orderID)
+}
+
+func (s *memoryStore) Save(ctx context.Context, order Order, events ...OrderEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, err := s.get(order.OrderID)
+	if err != nil {
+		return err
+	}
+	if stored.Version != order.Version-1 {
+		return errOrderChanged
+	}
+	return s.put(order, events)
+}
+
+// Update holds the lock throughout, so nothing can write the order between
+// change and the save. Payments are locked in Redis, out of its sight.
+func (s *memoryStore) Update(ctx context.Context, orderID string, change func(order *Order) ([]OrderEvent, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, err := s.get(orderID)
+	if err != nil {
+		return err
+	}
+	events, err := change(&order)
+	if err != nil {
+		return err
+	}
+	return s.put(order, events)
+}
+
+// matching decodes every order keep accepts. The caller holds the lock.
+func (s *memoryStore) matching(keep func(order Order) bool) ([]Order, error) {
+	var orders []Order
+	for _, id := range s.orderIDs {
+		order, err := s.get(id)
+		if err != nil {
+			return nil, err
+		}
+		if keep(order) {
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+func (s *memoryStore) CustomerOrders(ctx context.Context, customerID string, before int64, limit int) ([]Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	orders, err := s.matching(func(order Order) bool {
+		return order.CustomerID == customerID && (before == 0 || order.CreatedAt.UnixMilli() < before)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].CreatedAt.After(orders[j].CreatedAt.Time) })
+	return orders[:min(limit, len(orders))], nil
+}
+
+func (s *memoryStore) RestaurantOrders(ctx context.Context, restaurantID string, from, to time.Time) ([]Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	orders, err := s.matching(func(order Order) bool {
+		return order.RestaurantID == restaurantID && !order.CreatedAt.Before(from) && order.CreatedAt.Before(to)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].CreatedAt.Before(orders[j].CreatedAt.Time) })
+	return orders, nil
+}
+
+func (s *memoryStore) RiderOrders(ctx context.Context, riderID string) ([]Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	orders, err := s.matching(func(order Order) bool {
+		return order.RiderID == riderID && riderActiveStatuses[order.Status]
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].CreatedAt.Before(orders[j].CreatedAt.Time) })
+	return orders, nil
+}
+
+func (s *memoryStore) Scan(ctx context.Context, cursor uint64, count int) ([]string, uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	start := min(int(cursor), len(s.orderIDs))
+	end := min(start+count, len(s.orderIDs))
+	orders := make([]string, 0, end-start)
+	for _, id := range s.orderIDs[start:end] {
+		if data, ok := s.orders[id]; ok {
+			orders = append(orders, string(data))
+		}
+	}
+	if end == len(s.orderIDs) {
+		return orders, 0, nil
+	}
+	return orders, uint64(end), nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, order Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.orders, order.OrderID)
+	return execOrderSidecarDeletes(ctx, order)
+}
+
+func (s *memoryStore) AddEvents(ctx context.Context, events ...OrderEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queue(events)
+}
+
+func (s *memoryStore) PendingEvents(ctx context.Context, limit int) ([]outboxEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.outbox[:min(limit, len(s.outbox))]), nil
+}
+
+func (s *memoryStore) RemoveEvents(ctx context.Context, events ...outboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sent := make(map[string]bool, len(events))
+	for _, event := range events {
+		sent[event.ID] = true
+	}
+	s.outbox = slices.DeleteFunc(s.outbox, func(event outboxEvent) bool { return sent[event.ID] })
+	return nil
+}
@@ -0,0 +1,381 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	migratepgx "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// The postgres store keeps each record as JSON, with the fields it is looked
+// up by in columns of their own; see migrations/. The schema is migrated
+// before the store opens, by whichever command starts first: migrate locks
+// the database while it works.
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+type postgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func openPostgresRepositories(ctx context.Context, databaseURL string, seed bool) (Repositories, error) {
+	err := migrateDatabase(databaseURL)
+	if err != nil {
+		return Repositories{}, err
+	}
+
+	pool, err := pgxpool.New(ctx, databaseURL)
+	if err != nil {
+		return Repositories{}, fmt.Errorf("failed to connect to postgres: %v", err)
+	}
+	store := &postgresStore{pool: pool}
+
+	if seed {
+		catalog, err := readCatalogFiles()
+		if err == nil {
+			err = store.seed(ctx, catalog)
+		}
+		if err != nil {
+			pool.Close()
+			return Repositories{}, err
+		}
+	}
+
+	return Repositories{
+		Orders:      store,
+		Restaurants: store,
+		Riders:      store,
+		Menus:       store,
+		ping:        pool.Ping,
+		close:       pool.Close,
+	}, nil
+}
+
+// migrateDatabase brings the schema up to the latest migration.
+func migrateDatabase(databaseURL string) error {
+	source, err := iofs.New(migrations, "migrations")
+	if err != nil {
+		return fmt.Errorf("failed to read migrations: %v", err)
+	}
+	db, err := sql.Open("pgx", databaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to postgres: %v", err)
+	}
+	driver, err := migratepgx.WithInstance(db, &migratepgx.Config{})
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to connect to postgres: %v", err)
+	}
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		driver.Close()
+		return fmt.Errorf("failed to set up migrations: %v", err)
+	}
+	defer m.Close()
+
+	err = m.Up()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("failed to migrate database: %v", err)
+	}
+	version, _, _ := m.Version()
+	slog.Info("database schema up to date", "version", version)
+	return nil
+}
+
+// seed imports the catalog files, leaving alone any record already in the
+// database.
+func (s *postgresStore) seed(ctx context.Context, catalog catalogFiles) error {
+	batch := &pgx.Batch{}
+	for _, restaurant := range catalog.Restaurants {
+		data, _ := json.Marshal(restaurant)
+		batch.Queue(`INSERT INTO restaurants (id, data) VALUES ($1, $2) ON CONFLICT DO NOTHING`, restaurant.ID, data)
+	}
+	for _, rider := range catalog.Riders {
+		data, _ := json.Marshal(rider)
+		batch.Queue(`INSERT INTO riders (id, data) VALUES ($1, $2) ON CONFLICT DO NOTHING`, rider.ID, data)
+	}
+	for id, menu := range catalog.Menus {
+		data, _ := json.Marshal(menu)
+		batch.Queue(`INSERT INTO menus (restaurant_id, data) VALUES ($1, $2) ON CONFLICT DO NOTHING`, id, data)
+	}
+	err := s.pool.SendBatch(ctx, batch).Close()
+	if err != nil {
+		return fmt.Errorf("failed to seed database: %v", err)
+	}
+	slog.Info("database seeded from files", "restaurants", len(catalog.Restaurants), "riders", len(catalog.Riders), "menus", len(catalog.Menus))
+	return nil
+}
+
+// queryJSON decodes the one JSON column each row of query returns.
+func queryJSON[T any](ctx context.Context, pool *pgxpool.Pool, query string, args ...any) ([]T, error) {
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("postgres error: %v", err)
+	}
+	records, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (T, error) {
+		var record T
+		var data []byte
+		if err := row.Scan(&data); err != nil {
+			return record, err
+		}
+		return record, json.Unmarshal(data, &record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("postgres error: %v", err)
+	}
+	return records, nil
+}
+
+func (s *postgresStore) Restaurants(ctx context.Context) ([]Restaurant, error) {
+	return queryJSON[Restaurant](ctx, s.pool, `SELECT data FROM restaurants ORDER BY id`)
+}
+
+func (s *postgresStore) Riders(ctx context.Context) ([]Rider, error) {
+	return queryJSON[Rider](ctx, s.pool, `SELECT data FROM riders ORDER BY id`)
+}
+
+func (s *postgresStore) Menu(ctx context.Context, restaurantID string) (RestaurantMenu, error) {
+	menus, err := queryJSON[RestaurantMenu](ctx, s.pool, `SELECT data FROM menus WHERE restaurant_id = $1`, restaurantID)
+	if err != nil {
+		return RestaurantMenu{}, err
+	}
+	if len(menus) == 0 {
+		return RestaurantMenu{}, errMenuNotFound
+	}
+	return menus[0], nil
+}
+
+// queueEvents adds events to the outbox as part of tx.
+func queueEvents(ctx context.Context, tx pgx.Tx, events []OrderEvent) error {
+	for _, event := range events {
+		data, err := encodeOutboxEvent(&event)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO order_outbox (event) VALUES ($1)`, data)
+		if err != nil {
+			return fmt.Errorf("postgres error: %v", err)
+		}
+	}
+	return nil
+}
+
+// writeOrder inserts order as part of tx or, if upsert is set, replaces the
+// stored one if that is the version before it. It reports whether the order
+// was written.
+func writeOrder(ctx context.Context, tx pgx.Tx, order Order, upsert bool) (bool, error) {
+	data, err := json.Marshal(order)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal order: %v", err)
+	}
+	conflict := `DO NOTHING`
+	if upsert {
+		conflict = `DO UPDATE SET customer_id = excluded.customer_id, rider_id = excluded.rider_id, status = excluded.status, version = excluded.version, data = excluded.data
+			WHERE orders.version = excluded.version - 1`
+	}
+	tag, err := tx.Exec(ctx, `INSERT INTO orders (id, restaurant_id, customer_id, rider_id, status, created_at, version, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (id) `+conflict,
+		order.OrderID, order.RestaurantID, order.CustomerID, order.RiderID, order.Status, order.CreatedAt.Time, order.Version, data)
+	if err != nil {
+		return false, fmt.Errorf("failed to store order: %v", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// inTx runs write in a transaction, committing it if write succeeds.
+func (s *postgresStore) inTx(ctx context.Context, write func(tx pgx.Tx) error) error {
+	return pgx.BeginFunc(ctx, s.pool, write)
+}
+
+func (s *postgresStore) Create(ctx context.Context, order Order, events ...OrderEvent) (bool, error) {
+	var stored bool
+	err := s.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		stored, err = writeOrder(ctx, tx, order, false)
+		if err != nil || !stored {
+			return err
+		}
+		return queueEvents(ctx, tx, events)
+	})
+	return stored, err
+}
+
+func (s *postgresStore) Get(ctx context.Context, orderID string) (Order, error) {
+	orders, err := queryJSON[Order](ctx, s.pool, `SELECT data FROM orders WHERE id = $1`, orderID)
+	if err != nil {
+		return Order{}, err
+	}
+	if len(orders) == 0 {
+		return Order{}, errOrderNotFound
+	}
+	return orders[0], nil
+}
+
+func (s *postgresStore) Save(ctx context.Context, order Order, events ...OrderEvent) error {
+	return s.inTx(ctx, func(tx pgx.Tx) error {
+		written, err := writeOrder(ctx, tx, order, true)
+		if err != nil {
+			return err
+		}
+		if !written {
+			return unwrittenOrder(ctx, tx, order.OrderID)
+		}
+		return queueEvents(ctx, tx, events)
+	})
+}
+
+// unwrittenOrder explains why an upsert of the order wrote nothing: it is gone,
+// or another version is stored.
+func unwrittenOrder(ctx context.Context, tx pgx.Tx, orderID string) error {
+	var exists bool
+	err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE id = $1)`, orderID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("postgres error: %v", err)
+	}
+	if !exists {
+		return errOrderNotFound
+	}
+	return errOrderChanged
+}
+
+// Update locks the order's row until it is saved. Payments are locked in
+// Redis, out of its sight: one starting after change has checked for it is
+// not noticed.
+func (s *postgresStore) Update(ctx context.Context, orderID string, change func(order *Order) ([]OrderEvent, error)) error {
+	return s.inTx(ctx, func(tx pgx.Tx) error {
+		var data []byte
+		err := tx.QueryRow(ctx, `SELECT data FROM orders WHERE id = $1 FOR UPDATE`, orderID).Scan(&data)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errOrderNotFound
+		} else if err != nil {
+			return fmt.Errorf("postgres error: %v", err)
+		}
+		var order Order
+		err = json.Unmarshal(data, &order)
+		if err != nil {
+			return fmt.Errorf("failed to parse order: %v", err)
+		}
+
+		events, err := change(&order)
+		if err != nil {
+			return err
+		}
+		written, err := writeOrder(ctx, tx, order, true)
+		if err != nil {
+			return err
+		}
+		if !written {
+			return errOrderChanged
+		}
+		return queueEvents(ctx, tx, events)
+	})
+}
+
+func (s *postgresStore) CustomerOrders(ctx context.Context, customerID string, before int64, limit int) ([]Order, error) {
+	if before == 0 {
+		return queryJSON[Order](ctx, s.pool, `SELECT data FROM orders WHERE customer_id = $1
+			ORDER BY created_at DESC LIMIT $2`, customerID, limit)
+	}
+	return queryJSON[Order](ctx, s.pool, `SELECT data FROM orders WHERE customer_id = $1 AND created_at < $2
+		ORDER BY created_at DESC LIMIT $3`, customerID, time.UnixMilli(before), limit)
+}
+
+func (s *postgresStore) RestaurantOrders(ctx context.Context, restaurantID string, from, to time.Time) ([]Order, error) {
+	return queryJSON[Order](ctx, s.pool, `SELECT data FROM orders WHERE restaurant_id = $1
+		AND created_at >= $2 AND created_at < $3 ORDER BY created_at`, restaurantID, from, to)
+}
+
+func (s *postgresStore) RiderOrders(ctx context.Context, riderID string) ([]Order, error) {
+	statuses := make([]string, 0, len(riderActiveStatuses))
+	for status := range riderActiveStatuses {
+		statuses = append(statuses, status)
+	}
+	return queryJSON[Order](ctx, s.pool, `SELECT data FROM orders WHERE rider_id = $1 AND status = ANY($2)
+		ORDER BY created_at`, riderID, statuses)
+}
+
+// Scan's cursor is the seq of the last order returned.
+func (s *postgresStore) Scan(ctx context.Context, cursor uint64, count int) ([]string, uint64, error) {
+	rows, err := s.pool.Query(ctx, `SELECT seq, data::text FROM orders WHERE seq > $1 ORDER BY seq LIMIT $2`, int64(cursor), count)
+	if err != nil {
+		return nil, cursor, fmt.Errorf("postgres error: %v", err)
+	}
+	var (
+		orders []string
+		seq    int64
+		data   string
+	)
+	_, err = pgx.ForEachRow(rows, []any{&seq, &data}, func() error {
+		orders = append(orders, data)
+		return nil
+	})
+	if err != nil {
+		return nil, cursor, fmt.Errorf("postgres error: %v", err)
+	}
+	if len(orders) < count {
+		return orders, 0, nil
+	}
+	return orders, uint64(seq), nil
+}
+
+// Delete leaves the indexes to Postgres, which keeps them with the row.
+func (s *postgresStore) Delete(ctx context.Context, order Order) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM orders WHERE id = $1`, order.OrderID)
+	if err != nil {
+		return fmt.Errorf("postgres error: %v", err)
+	}
+	return execOrderSidecarDeletes(ctx, order)
+}
+
+func (s *postgresStore) AddEvents(ctx context.Context, events ...OrderEvent) error {
+	return s.inTx(ctx, func(tx pgx.Tx) error {
+		return queueEvents(ctx, tx, events)
+	})
+}
+
+func (s *postgresStore) PendingEvents(ctx context.Context, limit int) ([]outboxEvent, error) {
+	rows, err := s.pool.Query(ctx, `SELECT id, event FROM order_outbox ORDER BY id LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("postgres error: %v", err)
+	}
+	events, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (outboxEvent, error) {
+		var id int64
+		var event outboxEvent
+		err := row.Scan(&id, &event.Data)
+		event.ID = strconv.FormatInt(id, 10)
+		return event, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("postgres error: %v", err)
+	}
+	return events, nil
+}
+
+func (s *postgresStore) RemoveEvents(ctx context.Context, events ...outboxEvent) error {
+	ids := make([]int64, 0, len(events))
+	for _, event := range events {
+		id, err := strconv.ParseInt(event.ID, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid outbox event id %q", event.ID)
+		}
+		ids = append(ids, id)
+	}
+	_, err := s.pool.Exec(ctx, `DELETE FROM order_outbox WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return fmt.Errorf("postgres error: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,436 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// The redis store, the default: orders in Redis and the catalog in the JSON
+// files, as the service has always kept them.
+
+const (
+	restaurantsFile = "restaurants.json"
+	ridersFile      = "rider.json"
+)
+
+func newRedisRepositories() Repositories {
+	return Repositories{
+		Orders:      redisOrders{},
+		Restaurants: fileCatalog{},
+		Riders:      fileCatalog{},
+		Menus:       fileCatalog{},
+		close:       func() {},
+	}
+}
+
+// fileCatalog reads restaurants, riders and menus from their JSON files,
+// which are reread when they change.
+type fileCatalog struct{}
+
+func (fileCatalog) Restaurants(ctx context.Context) ([]Restaurant, error) {
+	return fetchRestaurantFromJSON(restaurantsFile)
+}
+
+func (fileCatalog) Riders(ctx context.Context) ([]Rider, error) {
+	return fetchRidersFromJSON(ridersFile)
+}
+
+func (fileCatalog) Menu(ctx context.Context, restaurantID string) (RestaurantMenu, error) {
+	return fetchMenuFromJSON(restaurantID)
+}
+
+// redisOrders keeps each order as JSON under order:{id}, indexes customers'
+// and restaurants' orders and riders' active ones in sorted sets, and queues events in the
+// outbox sorted set scored by when they occurred. The order and its events
+// go in one transaction.
+type redisOrders struct{}
+
+// storeOrderIfAbsent stores the order ARGV[1] at KEYS[1] only if the key is
+// free and, in the same step, adds each score and member pair from ARGV[4]
+// on to the outbox KEYS[2]. The indexes in KEYS[3] on, the restaurant's
+// and the customer's orders, also get the order ID ARGV[3] with score
+// ARGV[2].
+var storeOrderIfAbsent = redis.NewScript(`
+if redis.call('SETNX', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+for i = 3, #KEYS do
+	redis.call('ZADD', KEYS[i], ARGV[2], ARGV[3])
+end
+for i = 4, #ARGV, 2 do
+	redis.call('ZADD', KEYS[2], ARGV[i], ARGV[i + 1])
+end
+return 1
+`)
+
+// outboxEntries encodes events as outbox members scored by when they
+// occurred.
+func outboxEntries(events []OrderEvent) ([]*redis.Z, error) {
+	entries := make([]*redis.Z, 0, len(events))
+	for _, event := range events {
+		data, err := encodeOutboxEvent(&event)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &redis.Z{Score: float64(event.OccurredAt.UnixMilli()), Member: data})
+	}
+	return entries, nil
+}
+
+func (redisOrders) Create(ctx context.Context, order Order, events ...OrderEvent) (bool, error) {
+	orderJSON, err := json.Marshal(order)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal order: %v", err)
+	}
+	entries, err := outboxEntries(events)
+	if err != nil {
+		return false, err
+	}
+
+	keys := []string{orderKey(order.OrderID), outboxKey, restaurantOrdersKey(order.RestaurantID)}
+	args := []interface{}{orderJSON, order.CreatedAt.UnixMilli(), order.OrderID}
+	if order.CustomerID != "" {
+		keys = append(keys, customerOrdersKey(order.CustomerID))
+	}
+	for _, entry := range entries {
+		args = append(args, entry.Score, entry.Member)
+	}
+	stored, err := storeOrderIfAbsent.Run(ctx, redisClient, keys, args...).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to store order: %v", err)
+	}
+	return stored == 1, nil
+}
+
+func (redisOrders) Get(ctx context.Context, orderID string) (Order, error) {
+	orderData, err := redisClient.Get(ctx, orderKey(orderID)).Result()
+	if err == redis.Nil {
+		return Order{}, errOrderNotFound
+	} else if err != nil {
+		return Order{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	var order Order
+	err = json.Unmarshal([]byte(orderData), &order)
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to parse order: %v", err)
+	}
+	return order, nil
+}
+
+// Save watches the order, so a write after the stored version is checked
+// fails the save.
+func (redisOrders) Save(ctx context.Context, order Order, events ...OrderEvent) error {
+	orderJSON, err := json.Marshal(order)
+	if err != nil {
+		return fmt.Errorf("failed to marshal order: %v", err)
+	}
+	entries, err := outboxEntries(events)
+	if err != nil {
+		return err
+	}
+
+	err = redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, orderKey(order.OrderID)).Result()
+		if err == redis.Nil {
+			return errOrderNotFound
+		} else if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+		var stored struct {
+			Version int64 `json:"version"`
+		}
+		err = json.Unmarshal([]byte(data), &stored)
+		if err != nil {
+			return fmt.Errorf("failed to parse order: %v", err)
+		}
+		if stored.Version != order.Version-1 {
+			return errOrderChanged
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, orderKey(order.OrderID), orderJSON, 0)
+			indexRiderOrder(ctx, pipe, order)
+			if len(entries) > 0 {
+				pipe.ZAdd(ctx, outboxKey, entries...)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to store order: %v", err)
+		}
+		return nil
+	}, orderKey(order.OrderID))
+	if err == redis.TxFailedErr {
+		return errOrderChanged
+	}
+	return err
+}
+
+// Update watches the order and its payment lock, so payment starting after
+// change has looked at the order fails the save.
+func (redisOrders) Update(ctx context.Context, orderID string, change func(order *Order) ([]OrderEvent, error)) error {
+	err := redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, orderKey(orderID)).Result()
+		if err == redis.Nil {
+			return errOrderNotFound
+		} else if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+		var order Order
+		err = json.Unmarshal([]byte(data), &order)
+		if err != nil {
+			return fmt.Errorf("failed to parse order: %v", err)
+		}
+
+		events, err := change(&order)
+		if err != nil {
+			return err
+		}
+		orderJSON, err := json.Marshal(order)
+		if err != nil {
+			return fmt.Errorf("failed to marshal order: %v", err)
+		}
+		entries, err := outboxEntries(events)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, orderKey(orderID), orderJSON, 0)
+			indexRiderOrder(ctx, pipe, order)
+			if len(entries) > 0 {
+				pipe.ZAdd(ctx, outboxKey, entries...)
+			}
+			return nil
+		})
+		return err
+	}, orderKey(orderID), paymentLockKey(orderID))
+	if err == redis.TxFailedErr {
+		return errOrderChanged
+	}
+	return err
+}
+
+// getOrders reads the orders stored under ids, in the same order, returning
+// the IDs of any that are gone as well.
+func (redisOrders) getOrders(ctx context.Context, ids []string) ([]Order, []interface{}, error) {
+	if len(ids) == 0 {
+		return nil, nil, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = orderKey(id)
+	}
+	values, err := redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, nil, fmt.Errorf("redis error: %v", err)
+	}
+
+	orders := make([]Order, 0, len(values))
+	var missing []interface{}
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			missing = append(missing, ids[i])
+			continue
+		}
+		var order Order
+		if err := json.Unmarshal([]byte(raw), &order); err != nil {
+			slog.Warn("skipping unreadable order", "order_id", ids[i], "error", err)
+			continue
+		}
+		orders = append(orders, order)
+	}
+	return orders, missing, nil
+}
+
+func (r redisOrders) CustomerOrders(ctx context.Context, customerID string, before int64, limit int) ([]Order, error) {
+	max := "+inf"
+	if before != 0 {
+		max = fmt.Sprintf("(%d", before)
+	}
+	ids, err := redisClient.ZRevRangeByScore(ctx, customerOrdersKey(customerID), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   max,
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	orders, _, err := r.getOrders(ctx, ids)
+	return orders, err
+}
+
+func (r redisOrders) RestaurantOrders(ctx context.Context, restaurantID string, from, to time.Time) ([]Order, error) {
+	ids, err := redisClient.ZRangeByScore(ctx, restaurantOrdersKey(restaurantID), &redis.ZRangeBy{
+		Min: strconv.FormatInt(from.UnixMilli(), 10),
+		Max: "(" + strconv.FormatInt(to.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	orders, _, err := r.getOrders(ctx, ids)
+	return orders, err
+}
+
+// RiderOrders also drops from the rider's index any order no longer stored
+// or no longer the rider's to carry. An order reassigned to another rider is
+// dropped from this one's index here rather than when it moved.
+func (r redisOrders) RiderOrders(ctx context.Context, riderID string) ([]Order, error) {
+	ids, err := redisClient.ZRange(ctx, riderOrdersKey(riderID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	stored, stale, err := r.getOrders(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	orders := stored[:0]
+	for _, order := range stored {
+		if order.RiderID != riderID || !riderActiveStatuses[order.Status] {
+			stale = append(stale, order.OrderID)
+			continue
+		}
+		orders = append(orders, order)
+	}
+	if len(stale) > 0 {
+		if err := redisClient.ZRem(ctx, riderOrdersKey(riderID), stale...).Err(); err != nil {
+			slog.Warn("error pruning rider's active orders", "rider_id", riderID, "error", err)
+		}
+	}
+	return orders, nil
+}
+
+// Scan walks the keyspace with SCAN, so its cursors are Redis's own.
+func (redisOrders) Scan(ctx context.Context, cursor uint64, count int) ([]string, uint64, error) {
+	keys, next, err := redisClient.Scan(ctx, cursor, "order:*", int64(count)).Result()
+	if err != nil {
+		return nil, cursor, fmt.Errorf("redis error: %v", err)
+	}
+
+	keys = orderKeysOnly(keys)
+	if len(keys) == 0 {
+		return nil, next, nil
+	}
+	values, err := redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, cursor, fmt.Errorf("redis error: %v", err)
+	}
+	orders := make([]string, 0, len(values))
+	for _, value := range values {
+		if raw, ok := value.(string); ok {
+			orders = append(orders, raw)
+		}
+	}
+	return orders, next, nil
+}
+
+func (redisOrders) Delete(ctx context.Context, order Order) error {
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx, orderKey(order.OrderID))
+	pipe.ZRem(ctx, restaurantOrdersKey(order.RestaurantID), order.OrderID)
+	if order.CustomerID != "" {
+		pipe.ZRem(ctx, customerOrdersKey(order.CustomerID), order.OrderID)
+	}
+	if order.RiderID != "" {
+		pipe.ZRem(ctx, riderOrdersKey(order.RiderID), order.OrderID)
+	}
+	deleteOrderSidecars(ctx, pipe, order)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// deleteOrderSidecars queues on pipe the deletion of what is kept in Redis
+// beside order, whichever store holds the order itself. Its code goes only
+// if no other order has taken it since.
+func deleteOrderSidecars(ctx context.Context, pipe redis.Pipeliner, order Order) {
+	pipe.Del(ctx,
+		orderIssuesKey(order.OrderID),
+		orderMessagesKey(order.OrderID),
+		orderEventsKey(order.OrderID),
+		orderEventsIndexKey(order.OrderID),
+		proofPhotosKey(order.OrderID),
+		orderRestockedKey(order.OrderID),
+		orderSagasKey(order.OrderID),
+	)
+	if order.Code != "" {
+		releaseOrderCodeOf.Eval(ctx, pipe, []string{orderCodeKey(order.Code)}, order.OrderID)
+	}
+}
+
+// execOrderSidecarDeletes deletes what is kept in Redis beside order, for
+// the stores that keep the order elsewhere.
+func execOrderSidecarDeletes(ctx context.Context, order Order) error {
+	pipe := redisClient.TxPipeline()
+	deleteOrderSidecars(ctx, pipe, order)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// orderKeysOnly drops keys that share the order: prefix but hold something
+// else, such as order:{id}:issues.
+func orderKeysOnly(keys []string) []string {
+	kept := keys[:0]
+	for _, key := range keys {
+		if strings.Count(key, ":") == 1 {
+			kept = append(kept, key)
+		}
+	}
+	return kept
+}
+
+func (redisOrders) AddEvents(ctx context.Context, events ...OrderEvent) error {
+	entries, err := outboxEntries(events)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	err = redisClient.ZAdd(ctx, outboxKey, entries...).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+func (redisOrders) PendingEvents(ctx context.Context, limit int) ([]outboxEvent, error) {
+	members, err := redisClient.ZRange(ctx, outboxKey, 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]outboxEvent, len(members))
+	for i, member := range members {
+		events[i] = outboxEvent{ID: member, Data: member}
+	}
+	return events, nil
+}
+
+func (redisOrders) RemoveEvents(ctx context.Context, events ...outboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	members := make([]interface{}, len(events))
+	for i, event := range events {
+		members[i] = event.ID
+	}
+	err := redisClient.ZRem(ctx, outboxKey, members...).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
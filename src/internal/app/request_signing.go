@@ -0,0 +1,410 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"myproject/src/clock"
+	"myproject/src/rng"
+)
+
+// Request signing. A bearer token stolen from a rider's or restaurant's app
+// is enough to confirm pickups and deliveries that never happened, so those
+// routes also take a signature made with a secret an admin provisions for
+// the rider or restaurant alone. A signed request carries
+//
+//	X-Signature-Key:       the signing key's ID
+//	X-Signature-Timestamp: Unix seconds when it was signed
+//	X-Signature-Nonce:     8 to 128 characters, never reused with the key
+//	X-Signature:           sha256= and the hex HMAC-SHA256, under the key's
+//	                       secret, of the timestamp, nonce, method, path
+//	                       (without a /v1 or /v2 prefix) and hex SHA-256 of
+//	                       the body, joined by newlines
+//
+// It is refused if its timestamp is more than RequestSignatureMaxSkew from
+// now, or if its nonce has been seen with the key within that window, so a
+// captured request cannot be sent again. The key must belong to whoever
+// the bearer token or API key says is calling.
+//
+// Unsigned requests are let through until REQUEST_SIGNING_REQUIRED is set,
+// so the apps can be given keys first; a signed one is always checked.
+// Unlike an API key, the secret has to be stored as it is, to check
+// signatures with.
+
+const (
+	headerSignature          = "X-Signature"
+	headerSignatureKey       = "X-Signature-Key"
+	headerSignatureTimestamp = "X-Signature-Timestamp"
+	headerSignatureNonce     = "X-Signature-Nonce"
+
+	signingKeyIndexKey = "signingkeys"
+	// signingSecretPrefix starts every secret, like apiKeyPrefix.
+	signingSecretPrefix = "sk_"
+
+	signatureNonceMin = 8
+	signatureNonceMax = 128
+)
+
+var errSigningKeyNotFound = errors.New("signing key not found")
+
+// signedRoutes confirm an order's progress on behalf of a rider or
+// restaurant, and take a signature.
+var signedRoutes = map[string]bool{
+	"POST /restaurant/order/accept":      true,
+	"POST /restaurant/order/reject":      true,
+	"PUT /restaurant/order/:id/ready-by": true,
+	"POST /restaurant/:id/orders/batch":  true,
+	"POST /rider/order/pickup":           true,
+	"POST /rider/order/deliver":          true,
+}
+
+var signedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "signed_requests_total",
+	Help: "Requests to signed routes partitioned by result (valid, unsigned, invalid, expired, replayed).",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(signedRequests)
+}
+
+// SigningKey is a rider's or restaurant's signing key, without its secret.
+// Exactly one of RiderID and RestaurantID is set.
+type SigningKey struct {
+	ID           string     `json:"id"`
+	RiderID      string     `json:"rider_id,omitempty"`
+	RestaurantID string     `json:"restaurant_id,omitempty"`
+	Name         string     `json:"name,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+}
+
+// IssuedSigningKey is a key as returned when it is provisioned, the only
+// time Secret is shown.
+type IssuedSigningKey struct {
+	SigningKey
+	Secret string `json:"secret"`
+}
+
+type SigningKeyRequest struct {
+	RiderID      string `json:"rider_id" validate:"required_without=RestaurantID,excluded_with=RestaurantID"`
+	RestaurantID string `json:"restaurant_id" validate:"required_without=RiderID"`
+	Name         string `json:"name" validate:"max=100"`
+}
+
+// storedSigningKey is a key as kept in Redis, with its secret.
+type storedSigningKey struct {
+	SigningKey
+	Secret string `json:"secret"`
+}
+
+func signingKeyKey(keyID string) string {
+	return "signingkey:" + keyID
+}
+
+// signingKeyOwnerKey lists the keys of a rider or restaurant.
+func signingKeyOwnerKey(key SigningKey) string {
+	if key.RiderID != "" {
+		return "rider:" + key.RiderID + ":signingkeys"
+	}
+	return "restaurant:" + key.RestaurantID + ":signingkeys"
+}
+
+// signatureNonceKey remembers a nonce used with a key.
+func signatureNonceKey(keyID, nonce string) string {
+	return "signingkey:" + keyID + ":nonce:" + nonce
+}
+
+// owns reports whether claims are the rider or restaurant the key was
+// provisioned for.
+func (k SigningKey) owns(ctx context.Context, claims *AuthClaims) bool {
+	if k.RiderID != "" {
+		return claims.ActsForRider(k.RiderID)
+	}
+	return staffFor(ctx, claims, roleRestaurant, k.RestaurantID)
+}
+
+func getSigningKey(ctx context.Context, keyID string) (storedSigningKey, error) {
+	data, err := redisClient.Get(ctx, signingKeyKey(keyID)).Result()
+	if err == redis.Nil {
+		return storedSigningKey{}, errSigningKeyNotFound
+	} else if err != nil {
+		return storedSigningKey{}, fmt.Errorf("redis error: %v", err)
+	}
+	var key storedSigningKey
+	if err := json.Unmarshal([]byte(data), &key); err != nil {
+		return storedSigningKey{}, fmt.Errorf("malformed signing key %s: %v", keyID, err)
+	}
+	return key, nil
+}
+
+// requestSignature is what a request signs: its timestamp and nonce, and
+// what it asks for.
+func requestSignature(secret, timestamp, nonce, method, path string, body []byte) []byte {
+	bodySum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{timestamp, nonce, method, path, hex.EncodeToString(bodySum[:])}, "\n")))
+	return mac.Sum(nil)
+}
+
+// signatureRefusal is a signature refused, with the result it is counted
+// under in signedRequests.
+type signatureRefusal struct {
+	result string
+	reason string
+}
+
+func (r *signatureRefusal) Error() string {
+	return r.reason
+}
+
+// signedCall is the signature a request was sent with.
+type signedCall struct {
+	keyID     string
+	signature string
+	timestamp string
+	nonce     string
+}
+
+func (call signedCall) unsigned() bool {
+	return call.keyID == "" && call.signature == ""
+}
+
+// verifySignature checks call is a signature of method, path and body by a
+// live key of claims, and uses up its nonce. A signature that is refused
+// returns a *signatureRefusal; any other error is Redis failing.
+func verifySignature(ctx context.Context, claims *AuthClaims, call signedCall, method, path string, body []byte) error {
+	signature, ok := strings.CutPrefix(call.signature, "sha256=")
+	if !ok {
+		return &signatureRefusal{"invalid", "signature is not sha256=<hex>"}
+	}
+	mac, err := hex.DecodeString(signature)
+	if err != nil {
+		return &signatureRefusal{"invalid", "signature is not hex"}
+	}
+	seconds, err := strconv.ParseInt(call.timestamp, 10, 64)
+	if err != nil {
+		return &signatureRefusal{"invalid", "timestamp is not Unix seconds"}
+	}
+	if skew := clock.Now().Sub(time.Unix(seconds, 0)); skew > appConfig.RequestSignatureMaxSkew || -skew > appConfig.RequestSignatureMaxSkew {
+		return &signatureRefusal{"expired", "timestamp is " + skew.Round(time.Second).String() + " off"}
+	}
+	if len(call.nonce) < signatureNonceMin || len(call.nonce) > signatureNonceMax {
+		return &signatureRefusal{"invalid", "nonce length"}
+	}
+
+	key, err := getSigningKey(ctx, call.keyID)
+	if err == errSigningKeyNotFound || (err == nil && (key.RevokedAt != nil || !key.owns(ctx, claims))) {
+		return &signatureRefusal{"invalid", "key is unknown, revoked or not the caller's"}
+	} else if err != nil {
+		return fmt.Errorf("fetching signing key: %w", err)
+	}
+	if !hmac.Equal(mac, requestSignature(key.Secret, call.timestamp, call.nonce, method, path, body)) {
+		return &signatureRefusal{"invalid", "signature does not match"}
+	}
+
+	// Only a genuine request uses up its nonce. It is remembered for as
+	// long as a request with it could still be in time.
+	fresh, err := redisClient.SetNX(ctx, signatureNonceKey(call.keyID, call.nonce), 1, 2*appConfig.RequestSignatureMaxSkew).Result()
+	if err != nil {
+		return fmt.Errorf("recording signature nonce: %w", err)
+	}
+	if !fresh {
+		return &signatureRefusal{"replayed", "nonce already used"}
+	}
+	return nil
+}
+
+// signedRequest is middleware checking the signature of a request to one
+// of signedRoutes. requireRole runs it once it has authenticated the caller,
+// whom the key must belong to.
+func signedRequest(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		call := signedCall{
+			keyID:     req.Header.Get(headerSignatureKey),
+			signature: req.Header.Get(headerSignature),
+			timestamp: req.Header.Get(headerSignatureTimestamp),
+			nonce:     req.Header.Get(headerSignatureNonce),
+		}
+		if call.unsigned() {
+			signedRequests.WithLabelValues("unsigned").Inc()
+			if appConfig.RequestSigningRequired {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "This request must be signed"})
+			}
+			return next(c)
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			signedRequests.WithLabelValues("invalid").Inc()
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid request signature"})
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		err = verifySignature(req.Context(), authClaims(c), call, req.Method, req.URL.Path, body)
+		var refusal *signatureRefusal
+		if errors.As(err, &refusal) {
+			signedRequests.WithLabelValues(refusal.result).Inc()
+			requestLogger(c).Info("rejected signed request", "key_id", call.keyID, "reason", refusal.reason)
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid request signature"})
+		} else if err != nil {
+			requestLogger(c).Error("error checking request signature", "key_id", call.keyID, "error", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check request signature"})
+		}
+
+		signedRequests.WithLabelValues("valid").Inc()
+		return next(c)
+	}
+}
+
+func newSigningSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rng.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return signingSecretPrefix + hex.EncodeToString(secret), nil
+}
+
+// riderExists reports whether riderID is a known rider.
+func riderExists(ctx context.Context, riderID string) (bool, error) {
+	riders, err := repositories.Riders.Riders(ctx)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(riders, func(r Rider) bool { return r.ID == riderID }), nil
+}
+
+// createSigningKey serves POST /admin/signing-keys, provisioning a rider or
+// restaurant a signing key. Earlier keys keep working until revoked, so the
+// app can switch over.
+func createSigningKey(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req SigningKeyRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+	if req.RiderID != "" {
+		if ok, err := riderExists(ctx, req.RiderID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch rider"})
+		} else if !ok {
+			return validationFailed(c, "rider_id", "unknown rider")
+		}
+	} else if _, err := findRestaurant(ctx, req.RestaurantID); err == errRestaurantNotFound {
+		return validationFailed(c, "restaurant_id", "unknown restaurant")
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant"})
+	}
+
+	id, err := idGenerator.NewID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create signing key"})
+	}
+	secret, err := newSigningSecret()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create signing key"})
+	}
+	key := storedSigningKey{
+		SigningKey: SigningKey{
+			ID:           id,
+			RiderID:      req.RiderID,
+			RestaurantID: req.RestaurantID,
+			Name:         req.Name,
+			CreatedAt:    clock.Now().UTC(),
+		},
+		Secret: secret,
+	}
+	keyJSON, _ := json.Marshal(key)
+
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, signingKeyKey(key.ID), keyJSON, 0)
+	pipe.ZAdd(ctx, signingKeyIndexKey, &redis.Z{Score: float64(key.CreatedAt.UnixMilli()), Member: key.ID})
+	pipe.SAdd(ctx, signingKeyOwnerKey(key.SigningKey), key.ID)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		requestLogger(c).Error("error storing signing key", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save signing key"})
+	}
+
+	requestLogger(c).Info("signing key issued", "key_id", key.ID, "rider_id", key.RiderID, "restaurant_id", key.RestaurantID, "admin", authClaims(c).Subject)
+	return c.JSON(http.StatusCreated, IssuedSigningKey(key))
+}
+
+// listSigningKeys serves GET /admin/signing-keys, every key oldest first,
+// or a rider's or restaurant's with ?rider_id= or ?restaurant_id=.
+func listSigningKeys(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var ids []string
+	var err error
+	if riderID := c.QueryParam("rider_id"); riderID != "" {
+		ids, err = redisClient.SMembers(ctx, signingKeyOwnerKey(SigningKey{RiderID: riderID})).Result()
+	} else if restaurantID := c.QueryParam("restaurant_id"); restaurantID != "" {
+		ids, err = redisClient.SMembers(ctx, signingKeyOwnerKey(SigningKey{RestaurantID: restaurantID})).Result()
+	} else {
+		ids, err = redisClient.ZRange(ctx, signingKeyIndexKey, 0, -1).Result()
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch signing keys"})
+	}
+
+	keys := make([]SigningKey, 0, len(ids))
+	for _, id := range ids {
+		key, err := getSigningKey(ctx, id)
+		if err == errSigningKeyNotFound {
+			continue
+		} else if err != nil {
+			requestLogger(c).Error("error reading signing key", "key_id", id, "error", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch signing keys"})
+		}
+		keys = append(keys, key.SigningKey)
+	}
+	slices.SortFunc(keys, func(a, b SigningKey) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return c.JSON(http.StatusOK, map[string]interface{}{"signing_keys": keys})
+}
+
+// revokeSigningKey serves POST /admin/signing-keys/:id/revoke. Requests
+// signed with the key are refused from then on, and its secret is dropped.
+func revokeSigningKey(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	key, err := getSigningKey(ctx, c.Param("id"))
+	if err == errSigningKeyNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Signing key not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch signing key"})
+	}
+	if key.RevokedAt != nil {
+		return c.JSON(http.StatusOK, key.SigningKey)
+	}
+
+	now := clock.Now().UTC()
+	key.RevokedAt = &now
+	key.Secret = ""
+	keyJSON, _ := json.Marshal(key)
+	err = redisClient.Set(ctx, signingKeyKey(key.ID), keyJSON, 0).Err()
+	if err != nil {
+		requestLogger(c).Error("error revoking signing key", "key_id", key.ID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke signing key"})
+	}
+
+	requestLogger(c).Info("signing key revoked", "key_id", key.ID, "rider_id", key.RiderID, "restaurant_id", key.RestaurantID, "admin", authClaims(c).Subject)
+	return c.JSON(http.StatusOK, key.SigningKey)
+}
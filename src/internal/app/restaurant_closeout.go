@@ -0,0 +1,532 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/segmentio/kafka-go"
+
+	"myproject/src/clock"
+)
+
+// End-of-day closeout. At the end of a day a restaurant closes it out with
+// POST /restaurant/:id/closeout: its orders for the day are reconciled into
+// a summary of counts and takings, checked against the figures its POS
+// uploaded for the day with PUT /restaurant/:id/pos/:date, and the closeout
+// is kept and published as a RestaurantClosedOut event on the
+// restaurant-closeouts topic for the settlement job.
+//
+// An order belongs to the day it is due, in appConfig.Location: the day it
+// was scheduled for, or else the day it was placed. A day cannot be closed
+// out while any of its orders is still open, so every order in a closeout
+// is final, and once a day is closed out it stays as it was: no more orders
+// are taken for it, its POS figures can no longer be replaced, and it
+// cannot be closed out again. Neither can its orders be refunded or tipped,
+// which would change its takings.
+
+const (
+	closeoutsTopic = "restaurant-closeouts"
+
+	eventRestaurantClosedOut = "RestaurantClosedOut"
+
+	// Kinds of discrepancy between the platform's figures and the POS's.
+	discrepancyOrders         = "orders"
+	discrepancySales          = "sales"
+	discrepancyRefunds        = "refunds"
+	discrepancyMissingFromPOS = "missing_from_pos"
+	discrepancyUnknownOrder   = "unknown_order"
+	discrepancyAmountMismatch = "amount_mismatch"
+
+	closeoutDateMessage = "must be a date, YYYY-MM-DD, no later than today"
+)
+
+var closeoutWriter messageWriter
+
+// restaurantOrdersKey indexes the restaurant's orders by when they were
+// placed.
+func restaurantOrdersKey(restaurantID string) string {
+	return "restaurant:" + restaurantID + ":orders"
+}
+
+// closeoutsKey holds the restaurant's closeouts by date.
+func closeoutsKey(restaurantID string) string {
+	return "closeout:restaurant:" + restaurantID
+}
+
+// posFiguresKey holds the figures the restaurant's POS uploaded by date.
+func posFiguresKey(restaurantID string) string {
+	return "pos:restaurant:" + restaurantID
+}
+
+// POSFigures is what the restaurant's POS took over a day: the orders it
+// rang up, their sales and refunds, and optionally each order's receipt, to
+// be matched to the platform's orders by code.
+type POSFigures struct {
+	Orders     int          `json:"orders" validate:"gte=0"`
+	Sales      float64      `json:"sales" validate:"gte=0"`
+	Refunds    float64      `json:"refunds" validate:"gte=0"`
+	Receipts   []POSReceipt `json:"receipts,omitempty" validate:"max=2000,dive"`
+	UploadedAt Timestamp    `json:"uploaded_at"`
+}
+
+type POSReceipt struct {
+	OrderCode string  `json:"order_code" validate:"required"`
+	Total     float64 `json:"total" validate:"gte=0"`
+}
+
+type CloseoutRequest struct {
+	// Date is the day to close out, today if unset.
+	Date string `json:"date,omitempty"`
+}
+
+// Reconciliation sums up a day's orders. Sales are what customers were
+// charged; Refunds what has been given back, and RefundsPending what is
+// still to be, for orders cancelled, rejected or expired after being paid.
+// Net = Sales - Refunds - RefundsPending.
+type Reconciliation struct {
+	Orders           int            `json:"orders"`
+	ByStatus         map[string]int `json:"by_status"`
+	Paid             int            `json:"paid"`
+	Refunded         int            `json:"refunded"`
+	Subtotal         float64        `json:"subtotal"`
+	DeliveryFees     float64        `json:"delivery_fees"`
+	Discounts        float64        `json:"discounts"`
+	Tax              float64        `json:"tax"`
+	Tips             float64        `json:"tips"`
+	Sales            float64        `json:"sales"`
+	Refunds          float64        `json:"refunds"`
+	RefundsPending   float64        `json:"refunds_pending"`
+	CancellationFees float64        `json:"cancellation_fees"`
+	Net              float64        `json:"net"`
+}
+
+// CloseoutOrder is an order as it was closed out.
+type CloseoutOrder struct {
+	OrderID       string  `json:"order_id"`
+	Code          string  `json:"code"`
+	Status        string  `json:"status"`
+	PaymentStatus string  `json:"payment_status"`
+	Total         float64 `json:"total"`
+	Refund        float64 `json:"refund,omitempty"`
+}
+
+// Discrepancy is a figure on which the platform and the POS disagree. For
+// receipts it names the order; Difference is the POS's figure less the
+// platform's.
+type Discrepancy struct {
+	Kind       string  `json:"kind"`
+	OrderCode  string  `json:"order_code,omitempty"`
+	Platform   float64 `json:"platform"`
+	POS        float64 `json:"pos"`
+	Difference float64 `json:"difference"`
+}
+
+// RestaurantCloseout is a restaurant's day, closed out.
+type RestaurantCloseout struct {
+	CloseoutID   string    `json:"closeout_id"`
+	RestaurantID string    `json:"restaurant_id"`
+	Date         string    `json:"date"`
+	Start        Timestamp `json:"start"`
+	End          Timestamp `json:"end"`
+	Currency     string    `json:"currency"`
+	Reconciliation
+	POS           *POSFigures     `json:"pos,omitempty"`
+	Discrepancies []Discrepancy   `json:"discrepancies"`
+	Orders        []CloseoutOrder `json:"orders"`
+	ClosedBy      string          `json:"closed_by"`
+	ClosedAt      Timestamp       `json:"closed_at"`
+}
+
+// RestaurantClosedOut is the event published on the restaurant-closeouts
+// topic.
+type RestaurantClosedOut struct {
+	Type   string `json:"type"`
+	Region string `json:"region,omitempty"`
+	RestaurantCloseout
+}
+
+// closeoutDay reads a date to close out, or today for none, as the start of
+// the day. Days yet to come cannot be closed out.
+func closeoutDay(value string) (time.Time, bool) {
+	today := payoutPeriodStart(payoutPeriodDay, clock.Now())
+	if value == "" {
+		return today, true
+	}
+	day, err := time.ParseInLocation(time.DateOnly, value, appConfig.Location)
+	if err != nil || day.After(today) {
+		return time.Time{}, false
+	}
+	return day, true
+}
+
+// orderDay is the start of the day the order is due.
+func orderDay(order Order) time.Time {
+	due := order.CreatedAt.Time
+	if order.ScheduledAt != nil {
+		due = order.ScheduledAt.Time
+	}
+	return payoutPeriodStart(payoutPeriodDay, due)
+}
+
+// dayClosedOut reports whether the restaurant has closed out the day t
+// falls in.
+func dayClosedOut(ctx context.Context, rdb redis.Cmdable, restaurantID string, t time.Time) (bool, error) {
+	date := payoutPeriodStart(payoutPeriodDay, t).Format(time.DateOnly)
+	closed, err := rdb.HExists(ctx, closeoutsKey(restaurantID), date).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis error: %v", err)
+	}
+	return closed, nil
+}
+
+// checkOrderDayOpen fails with 409 if the restaurant has closed out the day
+// order belongs to, whose takings a change to its payment would alter.
+func checkOrderDayOpen(ctx context.Context, logger *slog.Logger, order Order) error {
+	closed, err := dayClosedOut(ctx, redisClient, order.RestaurantID, orderDay(order))
+	if err != nil {
+		logger.Error("error checking closeout", "restaurant_id", order.RestaurantID, "order_id", order.OrderID, "error", err)
+		return serviceFailure(http.StatusInternalServerError, "Failed to check restaurant closeout")
+	} else if closed {
+		return serviceFailure(http.StatusConflict, "Restaurant has closed out the day of this order")
+	}
+	return nil
+}
+
+// dayOrders returns the restaurant's orders due on the day starting at
+// start. Orders scheduled for the day may have been placed up to
+// ScheduledOrderMaxAhead before it.
+func dayOrders(ctx context.Context, restaurantID string, start time.Time) ([]Order, error) {
+	end := payoutPeriodEnd(payoutPeriodDay, start)
+	placed, err := repositories.Orders.RestaurantOrders(ctx, restaurantID, start.Add(-appConfig.ScheduledOrderMaxAhead), end)
+	if err != nil {
+		return nil, err
+	}
+	orders := placed[:0]
+	for _, order := range placed {
+		if orderDay(order).Equal(start) {
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+// reconcileOrders sums up a day's orders, all of them final. The sums are
+// kept in minor units so that they match the POS to the cent.
+func reconcileOrders(orders []Order) (Reconciliation, []CloseoutOrder) {
+	currency := appConfig.PaymentCurrency
+	minor := func(amount float64) minorUnits { return toMinor(amount, currency) }
+	var subtotal, deliveryFees, discounts, tax, tips, sales minorUnits
+	var refunds, refundsPending, cancellationFees minorUnits
+
+	r := Reconciliation{Orders: len(orders), ByStatus: map[string]int{}}
+	closed := make([]CloseoutOrder, 0, len(orders))
+	for _, order := range orders {
+		r.ByStatus[order.Status]++
+		entry := CloseoutOrder{
+			OrderID:       order.OrderID,
+			Code:          order.Code,
+			Status:        order.Status,
+			PaymentStatus: order.PaymentStatus,
+			Total:         order.TotalAmount,
+		}
+
+		charged := order.PaymentStatus == paymentPaid || order.PaymentStatus == paymentRefunded
+		if charged {
+			r.Paid++
+			sales += minor(order.TotalAmount)
+			if p := order.Pricing; p != nil {
+				subtotal += minor(p.Subtotal)
+				deliveryFees += minor(p.DeliveryFee)
+				discounts += minor(p.Discount)
+				tax += minor(p.Tax)
+				tips += minor(p.Tip)
+			}
+		}
+		// A refund keeps back the fee of a late cancellation, as
+		// refundOrderPayment does. Orders refunded before refunds were
+		// recorded on them have none.
+		refund := minor(order.TotalAmount) - minor(order.CancellationFee)
+		refunded := minor(order.RefundedAmount)
+		switch {
+		case order.PaymentStatus == paymentRefunded:
+			if len(order.Refunds) > 0 {
+				refund = refunded
+			}
+			r.Refunded++
+			refunds += refund
+			cancellationFees += minor(order.CancellationFee)
+			entry.Refund = refund.amount(currency)
+		case charged && order.Status != "delivered":
+			refunds += refunded
+			refundsPending += max(refund-refunded, 0)
+			cancellationFees += minor(order.CancellationFee)
+			entry.Refund = refund.amount(currency)
+		case charged:
+			// Delivered, and perhaps partly refunded.
+			refunds += refunded
+			entry.Refund = refunded.amount(currency)
+		}
+		closed = append(closed, entry)
+	}
+
+	r.Subtotal, r.DeliveryFees, r.Discounts = subtotal.amount(currency), deliveryFees.amount(currency), discounts.amount(currency)
+	r.Tax, r.Tips, r.Sales = tax.amount(currency), tips.amount(currency), sales.amount(currency)
+	r.Refunds, r.RefundsPending = refunds.amount(currency), refundsPending.amount(currency)
+	r.CancellationFees = cancellationFees.amount(currency)
+	r.Net = (sales - refunds - refundsPending).amount(currency)
+	return r, closed
+}
+
+// findDiscrepancies checks the day's figures against the POS's and, if it
+// sent receipts, each charged order against its receipt.
+func findDiscrepancies(r Reconciliation, orders []CloseoutOrder, pos *POSFigures) []Discrepancy {
+	discrepancies := []Discrepancy{}
+	if pos == nil {
+		return discrepancies
+	}
+	currency := appConfig.PaymentCurrency
+	check := func(kind, code string, platform, reported float64) {
+		if diff := toMinor(reported, currency) - toMinor(platform, currency); diff != 0 {
+			discrepancies = append(discrepancies, Discrepancy{Kind: kind, OrderCode: code, Platform: platform, POS: reported, Difference: diff.amount(currency)})
+		}
+	}
+	check(discrepancyOrders, "", float64(r.Paid), float64(pos.Orders))
+	check(discrepancySales, "", r.Sales, pos.Sales)
+	check(discrepancyRefunds, "", (toMinor(r.Refunds, currency) + toMinor(r.RefundsPending, currency)).amount(currency), pos.Refunds)
+	if len(pos.Receipts) == 0 {
+		return discrepancies
+	}
+
+	receipts := make(map[string]minorUnits, len(pos.Receipts))
+	for _, receipt := range pos.Receipts {
+		receipts[receipt.OrderCode] += toMinor(receipt.Total, currency)
+	}
+	for _, order := range orders {
+		if order.PaymentStatus != paymentPaid && order.PaymentStatus != paymentRefunded {
+			continue
+		}
+		total, ok := receipts[order.Code]
+		if !ok {
+			discrepancies = append(discrepancies, Discrepancy{Kind: discrepancyMissingFromPOS, OrderCode: order.Code, Platform: order.Total, Difference: -order.Total})
+			continue
+		}
+		delete(receipts, order.Code)
+		check(discrepancyAmountMismatch, order.Code, order.Total, total.amount(currency))
+	}
+	unknown := make([]string, 0, len(receipts))
+	for code := range receipts {
+		unknown = append(unknown, code)
+	}
+	sort.Strings(unknown)
+	for _, code := range unknown {
+		total := receipts[code].amount(currency)
+		discrepancies = append(discrepancies, Discrepancy{Kind: discrepancyUnknownOrder, OrderCode: code, POS: total, Difference: total})
+	}
+	return discrepancies
+}
+
+// readPOSFigures returns the POS's figures for the day, or nil if none were
+// uploaded.
+func readPOSFigures(ctx context.Context, restaurantID, date string) (*POSFigures, error) {
+	value, err := redisClient.HGet(ctx, posFiguresKey(restaurantID), date).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	var pos POSFigures
+	if err := json.Unmarshal([]byte(value), &pos); err != nil {
+		return nil, fmt.Errorf("failed to parse POS figures: %v", err)
+	}
+	return &pos, nil
+}
+
+// canCloseOut reports whether the caller may close out the restaurant's
+// days: its staff and owners, and admins.
+func canCloseOut(c echo.Context, restaurantID string) bool {
+	return authClaims(c).Role == roleAdmin || actsForRestaurant(c, restaurantID) || ownsRestaurant(c, restaurantID)
+}
+
+// uploadPOSFigures serves PUT /restaurant/:id/pos/:date, replacing the POS's
+// figures for a day not yet closed out.
+func uploadPOSFigures(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	if !canCloseOut(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+	day, ok := closeoutDay(c.Param("date"))
+	if !ok {
+		return validationFailed(c, "date", closeoutDateMessage)
+	}
+	var pos POSFigures
+	if err := bindAndValidate(c, &pos); err != nil {
+		return respondRequestError(c, err)
+	}
+	pos.UploadedAt = timestampNow()
+
+	logger := requestLogger(c).With("restaurant_id", restaurantID, "date", day.Format(time.DateOnly))
+	closed, err := dayClosedOut(ctx, redisClient, restaurantID, day)
+	if err != nil {
+		logger.Error("error checking closeout", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store POS figures"})
+	}
+	if closed {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Day is already closed out"})
+	}
+
+	value, err := json.Marshal(pos)
+	if err == nil {
+		err = redisClient.HSet(ctx, posFiguresKey(restaurantID), day.Format(time.DateOnly), value).Err()
+	}
+	if err != nil {
+		logger.Error("error storing POS figures", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store POS figures"})
+	}
+	logger.Info("POS figures uploaded", "orders", pos.Orders, "sales", pos.Sales, "receipts", len(pos.Receipts))
+	return c.JSON(http.StatusOK, pos)
+}
+
+// closeOutRestaurant serves POST /restaurant/:id/closeout.
+func closeOutRestaurant(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	if !canCloseOut(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+	var req CloseoutRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+	start, ok := closeoutDay(req.Date)
+	if !ok {
+		return validationFailed(c, "date", closeoutDateMessage)
+	}
+	date := start.Format(time.DateOnly)
+	logger := requestLogger(c).With("restaurant_id", restaurantID, "date", date)
+
+	_, err := findRestaurant(ctx, restaurantID)
+	if err == errRestaurantNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Restaurant not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant"})
+	}
+	closed, err := dayClosedOut(ctx, redisClient, restaurantID, start)
+	if err != nil {
+		logger.Error("error checking closeout", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to close out day"})
+	}
+	if closed {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Day is already closed out"})
+	}
+
+	orders, err := dayOrders(c.Request().Context(), restaurantID, start)
+	if err != nil {
+		logger.Error("error fetching day's orders", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to close out day"})
+	}
+	var open []string
+	for _, order := range orders {
+		if !terminalStatuses[order.Status] {
+			open = append(open, order.OrderID)
+		}
+	}
+	if len(open) > 0 {
+		return c.JSON(http.StatusConflict, map[string]interface{}{"error": "Orders are still open", "open_orders": open})
+	}
+	pos, err := readPOSFigures(ctx, restaurantID, date)
+	if err != nil {
+		logger.Error("error fetching POS figures", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to close out day"})
+	}
+
+	reconciliation, closedOrders := reconcileOrders(orders)
+	closeout := RestaurantCloseout{
+		CloseoutID:     restaurantID + ":" + date,
+		RestaurantID:   restaurantID,
+		Date:           date,
+		Start:          Timestamp{Time: start},
+		End:            Timestamp{Time: payoutPeriodEnd(payoutPeriodDay, start)},
+		Currency:       appConfig.PaymentCurrency,
+		Reconciliation: reconciliation,
+		POS:            pos,
+		Discrepancies:  findDiscrepancies(reconciliation, closedOrders, pos),
+		Orders:         closedOrders,
+		ClosedBy:       authClaims(c).Subject,
+		ClosedAt:       timestampNow(),
+	}
+	added, err := storeCloseout(c.Request().Context(), closeout)
+	if err != nil {
+		logger.Error("error closing out day", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to close out day"})
+	}
+	if !added {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Day is already closed out"})
+	}
+
+	logger.Info("restaurant day closed out", "orders", reconciliation.Orders, "net", reconciliation.Net, "discrepancies", len(closeout.Discrepancies))
+	return c.JSON(http.StatusCreated, closeout)
+}
+
+// storeCloseout keeps the closeout and publishes it, unless the day was
+// closed out already. A closeout that cannot be published is dropped again,
+// leaving the day open to be closed out once more.
+func storeCloseout(ctx context.Context, closeout RestaurantCloseout) (bool, error) {
+	value, err := json.Marshal(closeout)
+	if err != nil {
+		return false, err
+	}
+	key := closeoutsKey(closeout.RestaurantID)
+	added, err := redisClient.HSetNX(ctx, key, closeout.Date, value).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis error: %v", err)
+	}
+	if !added {
+		return false, nil
+	}
+
+	event, err := json.Marshal(RestaurantClosedOut{Type: eventRestaurantClosedOut, Region: appConfig.Region, RestaurantCloseout: closeout})
+	if err != nil {
+		return false, err
+	}
+	err = publishMessage(ctx, closeoutWriter, "restaurant_closed_out", kafka.Message{Key: []byte(closeout.RestaurantID), Value: event})
+	if err != nil {
+		if err := redisClient.HDel(ctx, key, closeout.Date).Err(); err != nil {
+			slog.Error("error dropping unpublished closeout", "closeout_id", closeout.CloseoutID, "error", err)
+		}
+		return false, fmt.Errorf("failed to publish closeout %s: %v", closeout.CloseoutID, err)
+	}
+	return true, nil
+}
+
+// getRestaurantCloseout serves GET /restaurant/:id/closeout/:date.
+func getRestaurantCloseout(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	if !canCloseOut(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+	value, err := redisClient.HGet(ctx, closeoutsKey(restaurantID), c.Param("date")).Result()
+	if err == redis.Nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Day is not closed out"})
+	} else if err != nil {
+		requestLogger(c).Error("error fetching closeout", "restaurant_id", restaurantID, "date", c.Param("date"), "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch closeout"})
+	}
+	var closeout RestaurantCloseout
+	if err := json.Unmarshal([]byte(value), &closeout); err != nil {
+		requestLogger(c).Error("error parsing closeout", "restaurant_id", restaurantID, "date", c.Param("date"), "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch closeout"})
+	}
+	return c.JSON(http.StatusOK, closeout)
+}
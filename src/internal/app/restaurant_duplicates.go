@@ -0,0 +1,138 @@
+package app
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Duplicate detection for onboarding. A restaurant registered under a name
+// close to one already listed nearby is most likely the same place entered
+// twice, so registerRestaurant refuses it unless told otherwise.
+
+// duplicateNameFillers are words left out when comparing names, as people
+// add or drop them freely.
+var duplicateNameFillers = map[string]bool{
+	"the": true, "and": true, "restaurant": true, "cafe": true, "kitchen": true,
+}
+
+// RestaurantMatch is a listed restaurant that a new one may duplicate.
+type RestaurantMatch struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Address   string  `json:"address,omitempty"`
+	DistanceM float64 `json:"distance_m,omitempty"`
+	// Similarity of the names, from 0 to 1.
+	Similarity float64 `json:"similarity"`
+}
+
+// findDuplicateRestaurants lists the restaurants that profile looks like,
+// closest first: those within DuplicateRestaurantRadiusMeters whose names are
+// at least DuplicateRestaurantSimilarity alike. When either side has no
+// location, only the same name counts.
+func findDuplicateRestaurants(ctx context.Context, profile RestaurantProfile) ([]RestaurantMatch, error) {
+	restaurants, err := loadRestaurants(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	name := restaurantNameTokens(profile.Name)
+	located := profile.Location != (GeoPoint{})
+	var matches []RestaurantMatch
+	for _, restaurant := range restaurants {
+		similarity := nameSimilarity(name, restaurantNameTokens(restaurant.Name))
+		match := RestaurantMatch{ID: restaurant.ID, Name: restaurant.Name, Address: restaurant.Address, Similarity: math.Round(similarity*100) / 100}
+
+		if !located || (restaurant.Lat == 0 && restaurant.Lng == 0) {
+			if similarity == 1 {
+				matches = append(matches, match)
+			}
+			continue
+		}
+		match.DistanceM = math.Round(distanceMeters(profile.Location.Lat, profile.Location.Lng, restaurant.Lat, restaurant.Lng))
+		if match.DistanceM <= appConfig.DuplicateRestaurantRadiusMeters && similarity >= appConfig.DuplicateRestaurantSimilarity {
+			matches = append(matches, match)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].DistanceM < matches[j].DistanceM })
+	return matches, nil
+}
+
+// restaurantNameTokens is name as compared: lower case, words only, without
+// fillers.
+func restaurantNameTokens(name string) []string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := words[:0]
+	for _, word := range words {
+		if !duplicateNameFillers[word] {
+			tokens = append(tokens, word)
+		}
+	}
+	if len(tokens) == 0 {
+		// A name made only of fillers is compared as it is.
+		return words
+	}
+	return tokens
+}
+
+// nameSimilarity scores two tokenised names from 0 to 1: the closer of their
+// edit distance, relative to the longer name, and the share of the shorter
+// name's words found in the longer, so "Pizza World" is alike to both
+// "Piza World" and "Pizza World Silom".
+func nameSimilarity(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	joinedA, joinedB := []rune(strings.Join(a, " ")), []rune(strings.Join(b, " "))
+	edit := 1 - float64(editDistance(joinedA, joinedB))/float64(max(len(joinedA), len(joinedB)))
+
+	shorter, longer := a, b
+	if len(shorter) > len(longer) {
+		shorter, longer = longer, shorter
+	}
+	inLonger := map[string]bool{}
+	for _, word := range longer {
+		inLonger[word] = true
+	}
+	shared := 0
+	for _, word := range shorter {
+		if inLonger[word] {
+			shared++
+		}
+	}
+	overlap := float64(shared) / float64(len(shorter))
+	// One shared word between one-word names is a match, but one shared word
+	// out of a longer name only says as much as the word does.
+	if len(shorter) == 1 && len(longer) > 1 {
+		overlap *= 0.5
+	}
+
+	return max(edit, overlap)
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,244 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+// Restaurants registered through the API are kept in a hash beside the ones
+// in restaurants.json. A registered entry with the ID of a file restaurant
+// replaces it, which is how file restaurants get their profile updated.
+const (
+	restaurantCacheKey      = "restaurant"
+	registeredRestaurantKey = "restaurants:registered"
+)
+
+type RestaurantContact struct {
+	Phone string `json:"phone,omitempty" validate:"omitempty,e164"`
+	Email string `json:"email,omitempty" validate:"omitempty,email"`
+}
+
+// RestaurantProfile is what a restaurant tells us about itself. Rating,
+// branding and zone are managed elsewhere.
+type RestaurantProfile struct {
+	Name         string            `json:"name" validate:"required,max=100"`
+	Address      string            `json:"address" validate:"required,max=300"`
+	Location     GeoPoint          `json:"location"`
+	OpeningHours *OpeningHours     `json:"opening_hours"`
+	Cuisines     []string          `json:"cuisines" validate:"max=10,dive,required"`
+	Contact      RestaurantContact `json:"contact"`
+	// DeliveryArea is where the restaurant delivers; without one it
+	// delivers as far as the platform allows.
+	DeliveryArea *DeliveryArea `json:"delivery_area"`
+}
+
+// mergeRegisteredRestaurants adds the registered restaurants to those from the
+// file, replacing file entries with the same ID.
+func mergeRegisteredRestaurants(ctx context.Context, restaurants []Restaurant) ([]Restaurant, error) {
+	entries, err := redisClient.HGetAll(ctx, registeredRestaurantKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	if len(entries) == 0 {
+		return restaurants, nil
+	}
+
+	registered := make(map[string]Restaurant, len(entries))
+	for id, data := range entries {
+		var restaurant Restaurant
+		if err := json.Unmarshal([]byte(data), &restaurant); err != nil {
+			return nil, fmt.Errorf("failed to parse registered restaurant %s: %v", id, err)
+		}
+		registered[id] = restaurant
+	}
+
+	for i, restaurant := range restaurants {
+		if r, ok := registered[restaurant.ID]; ok {
+			restaurants[i] = r
+			delete(registered, restaurant.ID)
+		}
+	}
+
+	added := make([]Restaurant, 0, len(registered))
+	for _, restaurant := range registered {
+		added = append(added, restaurant)
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].CreatedAt.Before(added[j].CreatedAt.Time) })
+	return append(restaurants, added...), nil
+}
+
+// validateProfile reports the first problem with a profile's opening hours,
+// delivery area or cuisines as a field and message.
+func validateProfile(ctx context.Context, profile RestaurantProfile) (string, string, error) {
+	if h := profile.OpeningHours; h != nil {
+		if _, err := h.IsOpenAt(clock.Now()); err != nil {
+			return "opening_hours", "must use HH:MM times", nil
+		}
+	}
+	if field, message := validateDeliveryArea(profile.DeliveryArea); field != "" {
+		return field, message, nil
+	}
+
+	unknown, err := unknownCuisine(ctx, profile.Cuisines)
+	if err != nil {
+		return "", "", err
+	}
+	if unknown != "" {
+		return "cuisines", fmt.Sprintf("unknown cuisine %q", unknown), nil
+	}
+	return "", "", nil
+}
+
+// saveRegisteredRestaurant stores restaurant with its cuisines and
+// invalidates the restaurant list and search index so the change is seen at
+// once.
+func saveRegisteredRestaurant(ctx context.Context, restaurant Restaurant, cuisines []string) error {
+	// Cuisines and branding live in their own keys and are attached on read.
+	restaurant.Cuisines = nil
+	restaurant.Branding = nil
+	restaurantJSON, _ := json.Marshal(restaurant)
+
+	err := redisClient.HSet(ctx, registeredRestaurantKey, restaurant.ID, restaurantJSON).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	err = replaceRestaurantCuisines(ctx, restaurant.ID, cuisines)
+	if err != nil {
+		return err
+	}
+	return invalidateRestaurants(ctx)
+}
+
+func applyProfile(restaurant *Restaurant, profile RestaurantProfile) {
+	restaurant.Name = profile.Name
+	restaurant.Address = profile.Address
+	restaurant.Lat = profile.Location.Lat
+	restaurant.Lng = profile.Location.Lng
+	restaurant.OpeningHours = profile.OpeningHours
+	restaurant.DeliveryArea = profile.DeliveryArea
+	restaurant.Contact = nil
+	if profile.Contact != (RestaurantContact{}) {
+		contact := profile.Contact
+		restaurant.Contact = &contact
+	}
+	restaurant.Cuisines = profile.Cuisines
+	if restaurant.Cuisines == nil {
+		restaurant.Cuisines = []string{}
+	}
+}
+
+// registerRestaurant serves POST /restaurant, onboarding a new restaurant.
+// Its contact email also becomes its notification address unless one is
+// already set. A restaurant that looks like one already listed is refused
+// with the lookalikes unless allow_duplicate=true.
+func registerRestaurant(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var profile RestaurantProfile
+	if err := bindAndValidate(c, &profile); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	field, message, err := validateProfile(ctx, profile)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check cuisines"})
+	}
+	if field != "" {
+		return validationFailed(c, field, message)
+	}
+
+	duplicates, err := findDuplicateRestaurants(ctx, profile)
+	if err != nil {
+		requestLogger(c).Error("error checking for duplicate restaurants", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check for duplicate restaurants"})
+	}
+	allowDuplicate := c.QueryParam("allow_duplicate") == "true"
+	if len(duplicates) > 0 && !allowDuplicate {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error":      "Restaurant looks like one already listed",
+			"detail":     "Register it with allow_duplicate=true if it is a different restaurant",
+			"duplicates": duplicates,
+		})
+	}
+
+	id, err := idGenerator.NewID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to register restaurant"})
+	}
+	restaurant := Restaurant{ID: id}
+	applyProfile(&restaurant, profile)
+	touch(&restaurant.CreatedAt, &restaurant.UpdatedAt)
+
+	err = saveRegisteredRestaurant(ctx, restaurant, profile.Cuisines)
+	if err != nil {
+		requestLogger(c).Error("error registering restaurant", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to register restaurant"})
+	}
+
+	if profile.Contact.Email != "" {
+		err = redisClient.HSetNX(ctx, contactKey(roleRestaurant, id), "email", profile.Contact.Email).Err()
+		if err != nil {
+			requestLogger(c).Warn("error saving restaurant contact", "restaurant_id", id, "error", err)
+		}
+	}
+
+	if len(duplicates) > 0 {
+		ids := make([]string, len(duplicates))
+		for i, duplicate := range duplicates {
+			ids[i] = duplicate.ID
+		}
+		requestLogger(c).Warn("restaurant registered despite lookalikes", "restaurant_id", id, "name", restaurant.Name, "lookalikes", ids)
+	}
+	requestLogger(c).Info("restaurant registered", "restaurant_id", id, "name", restaurant.Name)
+	return c.JSON(http.StatusCreated, restaurant)
+}
+
+// updateRestaurant serves PUT /restaurant/:id, replacing the restaurant's
+// profile.
+func updateRestaurant(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	if authClaims(c).Role != roleAdmin && !actsForRestaurant(c, restaurantID) && !ownsRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	var profile RestaurantProfile
+	if err := bindAndValidate(c, &profile); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	field, message, err := validateProfile(ctx, profile)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check cuisines"})
+	}
+	if field != "" {
+		return validationFailed(c, field, message)
+	}
+
+	restaurant, err := findRestaurant(ctx, restaurantID)
+	if err == errRestaurantNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Restaurant not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant"})
+	}
+
+	applyProfile(&restaurant, profile)
+	touch(&restaurant.CreatedAt, &restaurant.UpdatedAt)
+
+	err = saveRegisteredRestaurant(ctx, restaurant, profile.Cuisines)
+	if err != nil {
+		requestLogger(c).Error("error updating restaurant", "restaurant_id", restaurantID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update restaurant"})
+	}
+
+	requestLogger(c).Info("restaurant profile updated", "restaurant_id", restaurantID)
+	return c.JSON(http.StatusOK, restaurant)
+}
@@ -0,0 +1,382 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+	"myproject/src/handlers"
+)
+
+// Restaurant search. Restaurants are indexed in Redis so a query only
+// touches the restaurants that match it: a hash of the restaurants
+// themselves, a geo set of their locations, and a set per name prefix.
+// Cuisine filters use the cuisine assignment sets. The index is rebuilt from
+// the restaurant list when its marker expires or a restaurant changes.
+
+const (
+	restaurantIndexKey       = "restaurants:index"
+	restaurantGeoKey         = "restaurants:geo"
+	restaurantNameKeysKey    = "restaurants:name_keys"
+	restaurantIndexedKey     = "restaurants:indexed"
+	restaurantIndexTTL       = time.Hour
+	maxRestaurantPrefixRunes = 20
+
+	defaultRestaurantPageSize = 20
+	maxRestaurantPageSize     = 100
+
+	// earthHalfCircumferenceKm covers the whole globe as a search radius.
+	earthHalfCircumferenceKm = 20038
+)
+
+type RestaurantListing struct {
+	Restaurant
+	DistanceMeters *float64 `json:"distance_m,omitempty"`
+	OpenNow        bool     `json:"open_now"`
+}
+
+type RestaurantQuery struct {
+	Text        string
+	Cuisine     string
+	OpenNow     bool
+	HasLocation bool
+	Lat, Lng    float64
+	RadiusKm    float64
+	Sort        string
+	Limit       int
+	Offset      int
+}
+
+func restaurantNameKey(prefix string) string {
+	return "restaurants:name:" + prefix
+}
+
+// searchWords splits text into lowercase words, each cut to the longest
+// prefix the index holds.
+func searchWords(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		if runes := []rune(word); len(runes) > maxRestaurantPrefixRunes {
+			words[i] = string(runes[:maxRestaurantPrefixRunes])
+		}
+	}
+	return words
+}
+
+// namePrefixes returns every prefix of every word in name, so a search for
+// "piz" finds "Pizza World".
+func namePrefixes(name string) []string {
+	seen := map[string]bool{}
+	var prefixes []string
+	for _, word := range searchWords(name) {
+		runes := []rune(word)
+		for n := 1; n <= len(runes); n++ {
+			prefix := string(runes[:n])
+			if !seen[prefix] {
+				seen[prefix] = true
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+	return prefixes
+}
+
+// indexRestaurants replaces the search index with restaurants.
+func indexRestaurants(ctx context.Context, restaurants []Restaurant) error {
+	oldNameKeys, err := redisClient.SMembers(ctx, restaurantNameKeysKey).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx, restaurantIndexKey, restaurantGeoKey, restaurantNameKeysKey)
+	if len(oldNameKeys) > 0 {
+		pipe.Del(ctx, oldNameKeys...)
+	}
+	for _, restaurant := range restaurants {
+		restaurant.Cuisines = nil
+		restaurant.Branding = nil
+		restaurantJSON, _ := json.Marshal(restaurant)
+		pipe.HSet(ctx, restaurantIndexKey, restaurant.ID, restaurantJSON)
+		pipe.GeoAdd(ctx, restaurantGeoKey, &redis.GeoLocation{Name: restaurant.ID, Latitude: restaurant.Lat, Longitude: restaurant.Lng})
+		for _, prefix := range namePrefixes(restaurant.Name) {
+			pipe.SAdd(ctx, restaurantNameKey(prefix), restaurant.ID)
+			pipe.SAdd(ctx, restaurantNameKeysKey, restaurantNameKey(prefix))
+		}
+	}
+	pipe.Set(ctx, restaurantIndexedKey, 1, restaurantIndexTTL)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// ensureRestaurantIndex rebuilds the index if it has expired or was
+// invalidated.
+func ensureRestaurantIndex(ctx context.Context) error {
+	indexed, err := redisClient.Exists(ctx, restaurantIndexedKey).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if indexed == 1 {
+		return nil
+	}
+
+	restaurants, err := loadRestaurants(ctx)
+	if err != nil {
+		return err
+	}
+	return indexRestaurants(ctx, restaurants)
+}
+
+// invalidateRestaurants drops the cached restaurant list and marks the
+// search index for rebuilding, so a change is seen at once.
+func invalidateRestaurants(ctx context.Context) error {
+	err := redisClient.Del(ctx, restaurantCacheKey, restaurantIndexedKey).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+func parseRestaurantQuery(c echo.Context) (RestaurantQuery, string, string) {
+	q := RestaurantQuery{
+		Text:    strings.TrimSpace(c.QueryParam("q")),
+		Cuisine: c.QueryParam("cuisine"),
+		OpenNow: c.QueryParam("open_now") == "true",
+		Sort:    c.QueryParam("sort"),
+		Limit:   defaultRestaurantPageSize,
+	}
+
+	if q.Sort != "" && q.Sort != "name" && q.Sort != "rating" && q.Sort != "distance" {
+		return q, "sort", "must be one of: name rating distance"
+	}
+
+	if c.QueryParam("lat") != "" || c.QueryParam("lng") != "" {
+		var errLat, errLng error
+		q.Lat, errLat = strconv.ParseFloat(c.QueryParam("lat"), 64)
+		q.Lng, errLng = strconv.ParseFloat(c.QueryParam("lng"), 64)
+		if errLat != nil || errLng != nil || math.Abs(q.Lat) > 90 || math.Abs(q.Lng) > 180 {
+			return q, "lat", "lat and lng must both be valid coordinates"
+		}
+		q.HasLocation = true
+	}
+	if raw := c.QueryParam("radius_km"); raw != "" {
+		radius, err := strconv.ParseFloat(raw, 64)
+		if err != nil || radius <= 0 {
+			return q, "radius_km", "must be a positive number"
+		}
+		if !q.HasLocation {
+			return q, "lat", "lat and lng are required with radius_km"
+		}
+		q.RadiusKm = radius
+	}
+	if q.Sort == "distance" && !q.HasLocation {
+		return q, "lat", "lat and lng are required to sort by distance"
+	}
+	if q.Sort == "" {
+		q.Sort = "name"
+		if q.HasLocation {
+			q.Sort = "distance"
+		}
+	}
+
+	if raw := c.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxRestaurantPageSize {
+			return q, "limit", fmt.Sprintf("must be between 1 and %d", maxRestaurantPageSize)
+		}
+		q.Limit = n
+	}
+	if raw := c.QueryParam("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return q, "offset", "must be a non-negative integer"
+		}
+		q.Offset = n
+	}
+	return q, "", ""
+}
+
+// searchRestaurants returns one page of the restaurants matching q and how
+// many match in all.
+func searchRestaurants(ctx context.Context, q RestaurantQuery) ([]RestaurantListing, int, error) {
+	err := ensureRestaurantIndex(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var sets []string
+	for _, word := range searchWords(q.Text) {
+		sets = append(sets, restaurantNameKey(word))
+	}
+	if q.Cuisine != "" {
+		sets = append(sets, cuisineRestaurantsKey(q.Cuisine))
+	}
+
+	var ids []string
+	if len(sets) > 0 {
+		ids, err = redisClient.SInter(ctx, sets...).Result()
+	} else {
+		ids, err = redisClient.HKeys(ctx, restaurantIndexKey).Result()
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("redis error: %v", err)
+	}
+
+	var distances map[string]float64
+	if q.HasLocation {
+		radius := q.RadiusKm
+		if radius == 0 {
+			radius = earthHalfCircumferenceKm
+		}
+		locations, err := redisClient.GeoSearchLocation(ctx, restaurantGeoKey, &redis.GeoSearchLocationQuery{
+			GeoSearchQuery: redis.GeoSearchQuery{
+				Longitude:  q.Lng,
+				Latitude:   q.Lat,
+				Radius:     radius,
+				RadiusUnit: "km",
+			},
+			WithDist: true,
+		}).Result()
+		if err != nil {
+			return nil, 0, fmt.Errorf("redis error: %v", err)
+		}
+
+		distances = make(map[string]float64, len(locations))
+		for _, location := range locations {
+			distances[location.Name] = math.Round(location.Dist * 1000)
+		}
+		inRange := ids[:0]
+		for _, id := range ids {
+			if _, ok := distances[id]; ok {
+				inRange = append(inRange, id)
+			}
+		}
+		ids = inRange
+	}
+
+	if len(ids) == 0 {
+		return []RestaurantListing{}, 0, nil
+	}
+	values, err := redisClient.HMGet(ctx, restaurantIndexKey, ids...).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("redis error: %v", err)
+	}
+
+	now := clock.Now()
+	listings := make([]RestaurantListing, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var restaurant Restaurant
+		if err := json.Unmarshal([]byte(data), &restaurant); err != nil {
+			return nil, 0, fmt.Errorf("failed to parse indexed restaurant: %v", err)
+		}
+		listing := RestaurantListing{Restaurant: restaurant, OpenNow: restaurantOpenAt(restaurant, now)}
+		if q.OpenNow && !listing.OpenNow {
+			continue
+		}
+		if d, ok := distances[restaurant.ID]; ok {
+			listing.DistanceMeters = &d
+		}
+		listings = append(listings, listing)
+	}
+
+	sort.Slice(listings, func(i, j int) bool {
+		a, b := listings[i], listings[j]
+		switch {
+		case q.Sort == "rating" && a.Rating != b.Rating:
+			return a.Rating > b.Rating
+		case q.Sort == "distance" && *a.DistanceMeters != *b.DistanceMeters:
+			return *a.DistanceMeters < *b.DistanceMeters
+		}
+		if an, bn := strings.ToLower(a.Name), strings.ToLower(b.Name); an != bn {
+			return an < bn
+		}
+		return a.ID < b.ID
+	})
+
+	total := len(listings)
+	start := min(q.Offset, total)
+	end := min(start+q.Limit, total)
+	page := listings[start:end]
+
+	restaurants := make([]Restaurant, len(page))
+	for i, listing := range page {
+		restaurants[i] = listing.Restaurant
+	}
+	err = attachCuisines(ctx, restaurants)
+	if err != nil {
+		return nil, 0, err
+	}
+	restaurants, err = attachBranding(ctx, restaurants)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range page {
+		page[i].Restaurant = restaurants[i]
+	}
+	return page, total, nil
+}
+
+// getRestaurant serves GET /restaurant and listRestaurants GET /restaurants;
+// they differ only in the key the results are listed under. Both take q
+// (name search), cuisine, open_now, lat and lng with an optional radius_km,
+// sort (name, rating or distance), limit and offset. A page the client
+// already holds, by its ETag, is answered 304.
+func getRestaurant(c echo.Context) error {
+	return respondRestaurantSearch(c, "restaurant")
+}
+
+func listRestaurants(c echo.Context) error {
+	return respondRestaurantSearch(c, "restaurants")
+}
+
+func respondRestaurantSearch(c echo.Context, key string) error {
+	ctx := c.Request().Context()
+
+	q, field, message := parseRestaurantQuery(c)
+	if field != "" {
+		return validationFailed(c, field, message)
+	}
+
+	listings, total, err := searchRestaurants(ctx, q)
+	if err != nil {
+		requestLogger(c).Error("error searching restaurants", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurants"})
+	}
+
+	page := map[string]interface{}{
+		key:      listings,
+		"count":  len(listings),
+		"total":  total,
+		"limit":  q.Limit,
+		"offset": q.Offset,
+	}
+	notModified, err := handlers.NotModified(c, page)
+	if err != nil {
+		requestLogger(c).Error("error tagging restaurants", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurants"})
+	}
+	if notModified {
+		return nil
+	}
+	return c.JSON(http.StatusOK, page)
+}
@@ -0,0 +1,365 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"myproject/src/clock"
+)
+
+// Restaurant webhooks. A restaurant can register one endpoint, usually its
+// POS, to receive every new order in full so tickets print without anyone
+// watching the dashboard. Each restaurant has its own delivery queue, so
+// retries against a slow endpoint hold up only that restaurant, and a circuit
+// breaker stops trying an endpoint that keeps failing until it has had time
+// to recover. Endpoints are held to the same https and public address rules
+// as order event webhooks.
+
+const restaurantWebhookQueueSize = 100
+
+var restaurantWebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "restaurant_webhook_deliveries_total",
+	Help: "New-order restaurant webhook deliveries partitioned by result (success, failure, circuit_open, dropped).",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(restaurantWebhookDeliveries)
+}
+
+type RestaurantWebhook struct {
+	RestaurantID string    `json:"restaurant_id"`
+	URL          string    `json:"url" validate:"required,url"`
+	Secret       string    `json:"secret,omitempty"`
+	CreatedAt    Timestamp `json:"created_at"`
+	UpdatedAt    Timestamp `json:"updated_at"`
+}
+
+// NewOrderPayload is what a restaurant webhook receives.
+type NewOrderPayload struct {
+	Type         string `json:"type"`
+	EventID      string `json:"event_id"`
+	RestaurantID string `json:"restaurant_id"`
+	Order        Order  `json:"order"`
+}
+
+// WebhookBreaker is the circuit breaker state of a restaurant's endpoint.
+// While OpenUntil is in the future deliveries are skipped; after it one
+// trial delivery decides whether the breaker closes or opens again.
+type WebhookBreaker struct {
+	State     string     `json:"state"`
+	Failures  int        `json:"consecutive_failures"`
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+}
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+func restaurantWebhookKey(restaurantID string) string {
+	return "restaurant:" + restaurantID + ":webhook"
+}
+
+func restaurantWebhookBreakerKey(restaurantID string) string {
+	return "restaurant:" + restaurantID + ":webhook:breaker"
+}
+
+func restaurantWebhookTrialKey(restaurantID string) string {
+	return "restaurant:" + restaurantID + ":webhook:trial"
+}
+
+func getRestaurantWebhook(ctx context.Context, restaurantID string) (*RestaurantWebhook, error) {
+	data, err := redisClient.Get(ctx, restaurantWebhookKey(restaurantID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+
+	var webhook RestaurantWebhook
+	err = json.Unmarshal([]byte(data), &webhook)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse restaurant webhook: %v", err)
+	}
+	return &webhook, nil
+}
+
+func getWebhookBreaker(ctx context.Context, restaurantID string) (WebhookBreaker, error) {
+	fields, err := redisClient.HGetAll(ctx, restaurantWebhookBreakerKey(restaurantID)).Result()
+	if err != nil {
+		return WebhookBreaker{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	breaker := WebhookBreaker{State: breakerClosed}
+	breaker.Failures, _ = strconv.Atoi(fields["failures"])
+	if ms, err := strconv.ParseInt(fields["open_until"], 10, 64); err == nil {
+		openUntil := time.UnixMilli(ms).UTC()
+		breaker.OpenUntil = &openUntil
+		breaker.State = breakerOpen
+		if !clock.Now().Before(openUntil) {
+			breaker.State = breakerHalfOpen
+		}
+	}
+	return breaker, nil
+}
+
+// allowWebhookDelivery reports whether the breaker lets a delivery through.
+// Once the cooldown is over, only one delivery at a time gets to try.
+func allowWebhookDelivery(ctx context.Context, restaurantID string) (bool, error) {
+	breaker, err := getWebhookBreaker(ctx, restaurantID)
+	if err != nil {
+		return false, err
+	}
+
+	switch breaker.State {
+	case breakerOpen:
+		return false, nil
+	case breakerHalfOpen:
+		trial, err := redisClient.SetNX(ctx, restaurantWebhookTrialKey(restaurantID), 1, appConfig.RestaurantWebhookCooldown).Result()
+		if err != nil {
+			return false, fmt.Errorf("redis error: %v", err)
+		}
+		return trial, nil
+	}
+	return true, nil
+}
+
+// recordWebhookResult closes the breaker after a success. A failure counts
+// towards the threshold, and opens the breaker again straight away if it was
+// the trial delivery.
+func recordWebhookResult(ctx context.Context, restaurantID string, success bool) error {
+	breakerKey := restaurantWebhookBreakerKey(restaurantID)
+	if success {
+		err := redisClient.Del(ctx, breakerKey, restaurantWebhookTrialKey(restaurantID)).Err()
+		if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+		return nil
+	}
+
+	breaker, err := getWebhookBreaker(ctx, restaurantID)
+	if err != nil {
+		return err
+	}
+	failures, err := redisClient.HIncrBy(ctx, breakerKey, "failures", 1).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if breaker.State == breakerHalfOpen || failures >= int64(appConfig.RestaurantWebhookFailureThreshold) {
+		openUntil := clock.Now().Add(appConfig.RestaurantWebhookCooldown).UnixMilli()
+		pipe := redisClient.TxPipeline()
+		pipe.HSet(ctx, breakerKey, "open_until", openUntil)
+		pipe.Del(ctx, restaurantWebhookTrialKey(restaurantID))
+		_, err = pipe.Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+		slog.Warn("restaurant webhook circuit opened", "restaurant_id", restaurantID, "consecutive_failures", failures)
+	}
+	return nil
+}
+
+// restaurantWebhookQueues holds a delivery queue per restaurant, each drained
+// by its own worker.
+var restaurantWebhookQueues = struct {
+	sync.Mutex
+	queues map[string]chan OrderEvent
+}{queues: map[string]chan OrderEvent{}}
+
+// deliverRestaurantWebhook queues a new order for its restaurant's webhook.
+// A restaurant whose queue is full loses the delivery rather than slowing the
+// consumer down.
+func deliverRestaurantWebhook(ctx context.Context, event OrderEvent) {
+	if event.Type != eventOrderPaid || event.RestaurantID == "" {
+		return
+	}
+
+	restaurantWebhookQueues.Lock()
+	queue, ok := restaurantWebhookQueues.queues[event.RestaurantID]
+	if !ok {
+		queue = make(chan OrderEvent, restaurantWebhookQueueSize)
+		restaurantWebhookQueues.queues[event.RestaurantID] = queue
+		go runRestaurantWebhookWorker(ctx, event.RestaurantID, queue)
+	}
+	restaurantWebhookQueues.Unlock()
+
+	select {
+	case queue <- event:
+	default:
+		restaurantWebhookDeliveries.WithLabelValues("dropped").Inc()
+		eventLogger(event).Warn("restaurant webhook queue full, dropping delivery", "restaurant_id", event.RestaurantID)
+	}
+}
+
+func runRestaurantWebhookWorker(ctx context.Context, restaurantID string, queue chan OrderEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-queue:
+			sendRestaurantWebhook(ctx, restaurantID, event)
+		}
+	}
+}
+
+func sendRestaurantWebhook(ctx context.Context, restaurantID string, event OrderEvent) {
+	logger := eventLogger(event).With("restaurant_id", restaurantID)
+
+	webhook, err := getRestaurantWebhook(ctx, restaurantID)
+	if err != nil {
+		logger.Error("error loading restaurant webhook", "error", err)
+		return
+	}
+	if webhook == nil {
+		return
+	}
+
+	allowed, err := allowWebhookDelivery(ctx, restaurantID)
+	if err != nil {
+		logger.Error("error checking restaurant webhook circuit", "error", err)
+		return
+	}
+	if !allowed {
+		restaurantWebhookDeliveries.WithLabelValues("circuit_open").Inc()
+		logger.Warn("restaurant webhook circuit open, skipping delivery")
+		return
+	}
+
+	order, err := getOrder(ctx, event.OrderID)
+	if err != nil {
+		logger.Error("error loading order for restaurant webhook", "error", err)
+		return
+	}
+	body, err := json.Marshal(NewOrderPayload{
+		Type:         event.Type,
+		EventID:      event.EventID,
+		RestaurantID: restaurantID,
+		Order:        order,
+	})
+	if err != nil {
+		logger.Error("error rendering restaurant webhook payload", "error", err)
+		return
+	}
+
+	attempts, err := retryWebhook(ctx, func() error {
+		return postWebhook(ctx, webhook.URL, webhook.Secret, event, body)
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	if rerr := recordWebhookResult(ctx, restaurantID, err == nil); rerr != nil {
+		logger.Error("error recording restaurant webhook result", "error", rerr)
+	}
+	if err != nil {
+		restaurantWebhookDeliveries.WithLabelValues("failure").Inc()
+		logger.Warn("restaurant webhook delivery failed", "attempts", attempts, "error", err)
+		return
+	}
+	restaurantWebhookDeliveries.WithLabelValues("success").Inc()
+}
+
+// setRestaurantWebhook serves PUT /restaurant/:id/webhook. Replacing the
+// endpoint resets its circuit breaker. The secret, generated when not given,
+// is only returned here.
+func setRestaurantWebhook(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	if !actsForRestaurant(c, restaurantID) && !ownsRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	var webhook RestaurantWebhook
+	if err := bindAndValidate(c, &webhook); err != nil {
+		return respondRequestError(c, err)
+	}
+	webhook.RestaurantID = restaurantID
+	if problem := checkWebhookURL(ctx, webhook.URL); problem != "" {
+		return validationFailed(c, "url", problem)
+	}
+
+	existing, err := getRestaurantWebhook(ctx, restaurantID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch webhook"})
+	}
+	if existing != nil {
+		webhook.CreatedAt = existing.CreatedAt
+	}
+	touch(&webhook.CreatedAt, &webhook.UpdatedAt)
+
+	if webhook.Secret == "" {
+		webhook.Secret, err = newWebhookSecret()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save webhook"})
+		}
+	}
+
+	webhookJSON, _ := json.Marshal(webhook)
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, restaurantWebhookKey(restaurantID), webhookJSON, 0)
+	pipe.Del(ctx, restaurantWebhookBreakerKey(restaurantID), restaurantWebhookTrialKey(restaurantID))
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save webhook"})
+	}
+
+	requestLogger(c).Info("restaurant webhook set", "restaurant_id", restaurantID)
+	return c.JSON(http.StatusOK, webhook)
+}
+
+// getRestaurantWebhookHandler serves GET /restaurant/:id/webhook with the
+// endpoint's circuit breaker state.
+func getRestaurantWebhookHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	if !actsForRestaurant(c, restaurantID) && !ownsRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	webhook, err := getRestaurantWebhook(ctx, restaurantID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch webhook"})
+	}
+	if webhook == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Webhook not found"})
+	}
+	breaker, err := getWebhookBreaker(ctx, restaurantID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch webhook"})
+	}
+
+	webhook.Secret = ""
+	return c.JSON(http.StatusOK, map[string]interface{}{"webhook": webhook, "circuit": breaker})
+}
+
+func deleteRestaurantWebhook(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	restaurantID := c.Param("id")
+	if !actsForRestaurant(c, restaurantID) && !ownsRestaurant(c, restaurantID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this restaurant"})
+	}
+
+	removed, err := redisClient.Del(ctx, restaurantWebhookKey(restaurantID), restaurantWebhookBreakerKey(restaurantID), restaurantWebhookTrialKey(restaurantID)).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete webhook"})
+	}
+	if removed == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Webhook not found"})
+	}
+
+	requestLogger(c).Info("restaurant webhook deleted", "restaurant_id", restaurantID)
+	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+
+	"myproject/src/clock"
+)
+
+// Rider positions are also kept in a Redis geo set, so the riders near a
+// restaurant are found with one GEOSEARCH rather than by reading every
+// rider's position. A rider leaves the set on going offline, and a rider
+// whose last fix is older than RiderLocationTTL is taken out before each
+// search, as their position list has expired.
+
+const (
+	ridersGeoKey = "riders:geo"
+	// ridersGeoSeenKey holds when each rider in the geo set last reported,
+	// in Unix milliseconds.
+	ridersGeoSeenKey = "riders:geo:seen"
+)
+
+// riderDistance is a rider found by searchRiders.
+type riderDistance struct {
+	RiderID        string
+	DistanceMeters float64
+}
+
+// addRiderToGeo queues the rider's position on pipe.
+func addRiderToGeo(ctx context.Context, pipe redis.Pipeliner, riderID string, position RiderPosition) {
+	pipe.GeoAdd(ctx, ridersGeoKey, &redis.GeoLocation{Name: riderID, Latitude: position.Lat, Longitude: position.Lng})
+	pipe.ZAdd(ctx, ridersGeoSeenKey, &redis.Z{Score: float64(position.At.UnixMilli()), Member: riderID})
+}
+
+// removeRiderFromGeo takes the rider out of the geo set.
+func removeRiderFromGeo(ctx context.Context, riderID string) error {
+	pipe := redisClient.TxPipeline()
+	pipe.ZRem(ctx, ridersGeoKey, riderID)
+	pipe.ZRem(ctx, ridersGeoSeenKey, riderID)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// pruneRiderGeo takes out riders with no fix within RiderLocationTTL.
+func pruneRiderGeo(ctx context.Context) error {
+	cutoff := clock.Now().Add(-appConfig.RiderLocationTTL).UnixMilli()
+	stale, err := redisClient.ZRangeByScore(ctx, ridersGeoSeenKey, &redis.ZRangeBy{Min: "-inf", Max: "(" + strconv.FormatInt(cutoff, 10)}).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	members := make([]interface{}, len(stale))
+	for i, riderID := range stale {
+		members[i] = riderID
+	}
+	pipe := redisClient.TxPipeline()
+	pipe.ZRem(ctx, ridersGeoKey, members...)
+	pipe.ZRem(ctx, ridersGeoSeenKey, members...)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// searchRiders returns the riders with a recent position within radius
+// meters of center, closest first.
+func searchRiders(ctx context.Context, center GeoPoint, radius float64) ([]riderDistance, error) {
+	if err := pruneRiderGeo(ctx); err != nil {
+		return nil, err
+	}
+	locations, err := redisClient.GeoSearchLocation(ctx, ridersGeoKey, &redis.GeoSearchLocationQuery{
+		GeoSearchQuery: redis.GeoSearchQuery{
+			Longitude:  center.Lng,
+			Latitude:   center.Lat,
+			Radius:     radius,
+			RadiusUnit: "m",
+			Sort:       "ASC",
+		},
+		WithDist: true,
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+
+	riders := make([]riderDistance, len(locations))
+	for i, location := range locations {
+		riders[i] = riderDistance{RiderID: location.Name, DistanceMeters: location.Dist}
+	}
+	return riders, nil
+}
@@ -0,0 +1,217 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+// RiderPosition is one GPS fix reported by a rider.
+type RiderPosition struct {
+	Lat float64   `json:"lat"`
+	Lng float64   `json:"lng"`
+	At  time.Time `json:"at"`
+}
+
+type RiderLocationRequest struct {
+	RiderID string  `json:"rider_id" validate:"required"`
+	OrderID string  `json:"order_id" validate:"omitempty,uuid"`
+	Lat     float64 `json:"lat" validate:"gte=-90,lte=90"`
+	Lng     float64 `json:"lng" validate:"gte=-180,lte=180"`
+}
+
+func updateRiderLocation(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req RiderLocationRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	if !actsForRider(c, req.RiderID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this rider"})
+	}
+
+	err := recordRiderPosition(ctx, req.RiderID, RiderPosition{Lat: req.Lat, Lng: req.Lng, At: clock.Now().UTC()})
+	if err != nil {
+		requestLogger(c).Error("error storing rider position", "rider_id", req.RiderID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store rider location"})
+	}
+
+	resp := map[string]interface{}{"status": "updated"}
+	if req.OrderID == "" {
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	order, err := getOrder(ctx, req.OrderID)
+	if err == errOrderNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+
+	// Once the food is on its way the position is only of interest to the
+	// customer tracking the order.
+	if order.Status == "picked_up" {
+		if order.RiderID != req.RiderID {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Order is assigned to a different rider"})
+		}
+		publishRiderLocation(c.Request().Context(), order, req.Lat, req.Lng)
+
+		arriving, err := checkRiderArriving(c.Request().Context(), order, req)
+		if err != nil {
+			requestLogger(c).Error("error checking rider arrival", "rider_id", req.RiderID, "order_id", req.OrderID, "error", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to process rider location"})
+		}
+		resp["arriving"] = arriving
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	arrived, err := checkInAtRestaurant(c.Request().Context(), order, req)
+	if err != nil {
+		requestLogger(c).Error("error checking rider in", "rider_id", req.RiderID, "order_id", req.OrderID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to process rider location"})
+	}
+
+	resp["arrived_at_restaurant"] = arrived
+	return c.JSON(http.StatusOK, resp)
+}
+
+func riderLocationsKey(riderID string) string {
+	return "rider:" + riderID + ":locations"
+}
+
+// recordRiderPosition keeps the rider's last RiderLocationHistory positions,
+// newest first. The list expires RiderLocationTTL after the latest fix, so a
+// rider who stops reporting has no position rather than a stale one. The
+// latest also goes into the geo set; see rider_geo.go.
+func recordRiderPosition(ctx context.Context, riderID string, position RiderPosition) error {
+	positionJSON, err := json.Marshal(position)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rider position: %v", err)
+	}
+
+	key := riderLocationsKey(riderID)
+	pipe := redisClient.TxPipeline()
+	pipe.LPush(ctx, key, positionJSON)
+	pipe.LTrim(ctx, key, 0, int64(appConfig.RiderLocationHistory)-1)
+	pipe.Expire(ctx, key, appConfig.RiderLocationTTL)
+	addRiderToGeo(ctx, pipe, riderID, position)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// latestRiderPosition returns the rider's most recent position, or nil if
+// none was reported within RiderLocationTTL.
+func latestRiderPosition(ctx context.Context, riderID string) (*RiderPosition, error) {
+	positions, err := redisClient.LRange(ctx, riderLocationsKey(riderID), 0, 0).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	if len(positions) == 0 {
+		return nil, nil
+	}
+
+	var position RiderPosition
+	err = json.Unmarshal([]byte(positions[0]), &position)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rider position: %v", err)
+	}
+	return &position, nil
+}
+
+// publishRiderLocation sends the rider's position straight to the producer
+// rather than through the outbox: positions are frequent and only the latest
+// one matters, so a lost update is simply superseded by the next. The
+// request only waits if the producer is backed up.
+func publishRiderLocation(ctx context.Context, order Order, lat, lng float64) {
+	event := newOrderEvent(ctx, eventRiderLocation, order)
+	event.Lat = &lat
+	event.Lng = &lng
+
+	logError := func(err error) {
+		if err != nil {
+			slog.Warn("error publishing rider location", "order_id", order.OrderID, "rider_id", order.RiderID, "error", err)
+		}
+	}
+	logError(publishOrderEvent(ctx, event, logError))
+}
+
+// checkInAtRestaurant marks the rider as arrived once they are inside the
+// restaurant geofence. It reports whether the rider is checked in, and is a
+// no-op for orders that already have an arrival recorded.
+func checkInAtRestaurant(ctx context.Context, order Order, req RiderLocationRequest) (bool, error) {
+	if order.HasTimelineEvent(timelineArrivedAtRestaurant) {
+		return true, nil
+	}
+
+	restaurant, err := findRestaurant(ctx, order.RestaurantID)
+	if err != nil {
+		return false, err
+	}
+
+	distance := distanceMeters(req.Lat, req.Lng, restaurant.Lat, restaurant.Lng)
+	if distance > appConfig.RestaurantGeofenceMeters {
+		return false, nil
+	}
+
+	order.Timeline = append(order.Timeline, TimelineEvent{
+		Event: timelineArrivedAtRestaurant,
+		At:    timestampNow(),
+	})
+	event := newOrderEvent(ctx, eventRiderArrived, order)
+	event.RiderID = req.RiderID
+	// An order written meanwhile, such as by the pickup, is checked in by
+	// the rider's next location.
+	err = saveOrder(ctx, repositories.Orders, &order, event)
+	if err == errOrderChanged {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	slog.Info("rider arrived at restaurant", "rider_id", req.RiderID, "restaurant_id", order.RestaurantID, "order_id", order.OrderID, "distance_m", distance)
+	return true, nil
+}
+
+// checkRiderArriving records that the rider carrying the order is arriving
+// once they are within DeliveryGeofenceMeters of the drop-off, which tells
+// the customer. It reports whether the rider is arriving, and is a no-op for
+// orders with no drop-off location or an arrival already recorded.
+func checkRiderArriving(ctx context.Context, order Order, req RiderLocationRequest) (bool, error) {
+	if order.HasTimelineEvent(timelineRiderArriving) {
+		return true, nil
+	}
+	if order.DeliveryLocation == nil {
+		return false, nil
+	}
+
+	distance := distanceMeters(req.Lat, req.Lng, order.DeliveryLocation.Lat, order.DeliveryLocation.Lng)
+	if distance > appConfig.DeliveryGeofenceMeters {
+		return false, nil
+	}
+
+	order.Timeline = append(order.Timeline, TimelineEvent{
+		Event: timelineRiderArriving,
+		At:    timestampNow(),
+	})
+	err := saveOrder(ctx, repositories.Orders, &order, newOrderEvent(ctx, eventRiderArriving, order))
+	if err == errOrderChanged {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	slog.Info("rider arriving at drop-off", "rider_id", req.RiderID, "order_id", order.OrderID, "distance_m", distance)
+	return true, nil
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+// riderActiveStatuses are the statuses in which an assigned order is still
+// the rider's to carry.
+var riderActiveStatuses = map[string]bool{
+	"accepted":  true,
+	"picked_up": true,
+}
+
+// riderOrdersKey indexes the orders a rider is carrying by creation time in
+// milliseconds. saveOrder keeps it up to date.
+func riderOrdersKey(riderID string) string {
+	return "rider:" + riderID + ":orders"
+}
+
+// indexRiderOrder adds order to or removes it from its rider's active orders
+// as part of pipe.
+func indexRiderOrder(ctx context.Context, pipe redis.Pipeliner, order Order) {
+	if order.RiderID == "" {
+		return
+	}
+	if riderActiveStatuses[order.Status] {
+		pipe.ZAdd(ctx, riderOrdersKey(order.RiderID), &redis.Z{Score: float64(order.CreatedAt.UnixMilli()), Member: order.OrderID})
+	} else {
+		pipe.ZRem(ctx, riderOrdersKey(order.RiderID), order.OrderID)
+	}
+}
+
+// RiderStop is one place the rider has to go.
+type RiderStop struct {
+	Name    string   `json:"name,omitempty"`
+	Address string   `json:"address,omitempty"`
+	Phone   string   `json:"phone,omitempty"`
+	Lat     *float64 `json:"lat,omitempty"`
+	Lng     *float64 `json:"lng,omitempty"`
+}
+
+// RiderAssignment is an active order as the rider app shows it: where to
+// collect it, where to take it, and what to check at the counter.
+type RiderAssignment struct {
+	OrderID   string `json:"order_id"`
+	OrderCode string `json:"order_code"`
+	Status    string `json:"status"`
+	// NextStop is "pickup" until the rider has the food, then "dropoff".
+	NextStop        string              `json:"next_stop"`
+	Pickup          RiderStop           `json:"pickup"`
+	Dropoff         RiderStop           `json:"dropoff"`
+	DeliveryOptions DeliveryOptions     `json:"delivery_options"`
+	Checklist       *PickupChecklist    `json:"checklist,omitempty"`
+	Confirmation    *PickupConfirmation `json:"confirmation,omitempty"`
+	AcceptedAt      *Timestamp          `json:"accepted_at,omitempty"`
+}
+
+// maskPhone hides all but the last four digits of phone.
+func maskPhone(phone string) string {
+	if len(phone) <= 4 {
+		return phone
+	}
+	return strings.Repeat("*", len(phone)-4) + phone[len(phone)-4:]
+}
+
+// firstName is how the rider asks for the customer at the door.
+func firstName(name string) string {
+	first, _, _ := strings.Cut(strings.TrimSpace(name), " ")
+	return first
+}
+
+func riderAssignment(order Order) RiderAssignment {
+	assignment := RiderAssignment{
+		OrderID:         order.OrderID,
+		OrderCode:       order.Code,
+		Status:          order.Status,
+		NextStop:        "pickup",
+		DeliveryOptions: order.DeliveryOptions,
+		Checklist:       order.PickupChecklist,
+		Confirmation:    order.PickupConfirmation,
+	}
+	if order.Status == "picked_up" {
+		assignment.NextStop = "dropoff"
+	}
+	for _, e := range order.Timeline {
+		if e.Event == "accepted" {
+			at := e.At
+			assignment.AcceptedAt = &at
+		}
+	}
+	return assignment
+}
+
+// getRiderActiveOrders lists the orders assigned to the rider that are not
+// yet delivered, oldest first, with both stops filled in. Customers, or the
+// recipients of gifts, are named by first name and their phone masked; the
+// rider needs no more to find them.
+func getRiderActiveOrders(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	riderID := c.Param("id")
+	if !actsForRider(c, riderID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this rider"})
+	}
+
+	logger := requestLogger(c).With("rider_id", riderID)
+	orders, err := repositories.Orders.RiderOrders(ctx, riderID)
+	if err != nil {
+		logger.Error("error fetching active orders", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch orders"})
+	}
+
+	assignments := make([]RiderAssignment, 0, len(orders))
+	for _, order := range orders {
+		assignment := riderAssignment(order)
+
+		restaurant, err := findRestaurant(ctx, order.RestaurantID)
+		if err != nil {
+			logger.Warn("error fetching restaurant for active order", "order_id", order.OrderID, "error", err)
+		} else {
+			lat, lng := restaurant.Lat, restaurant.Lng
+			assignment.Pickup = RiderStop{Name: restaurant.Name, Address: restaurant.Address, Lat: &lat, Lng: &lng}
+			if restaurant.Contact != nil {
+				assignment.Pickup.Phone = restaurant.Contact.Phone
+			}
+		}
+
+		if order.DeliveryLocation != nil {
+			lat, lng := order.DeliveryLocation.Lat, order.DeliveryLocation.Lng
+			assignment.Dropoff.Lat, assignment.Dropoff.Lng = &lat, &lng
+		}
+		if gift := order.Gift; gift != nil {
+			assignment.Dropoff.Name = firstName(gift.RecipientName)
+			assignment.Dropoff.Phone = maskPhone(gift.RecipientPhone)
+		} else if order.CustomerID != "" {
+			customer, err := getCustomer(ctx, order.CustomerID)
+			if err == nil {
+				assignment.Dropoff.Name = firstName(customer.Name)
+				assignment.Dropoff.Phone = maskPhone(customer.Phone)
+			} else if err != errCustomerNotFound {
+				logger.Warn("error fetching customer for active order", "order_id", order.OrderID, "error", err)
+			}
+		}
+
+		assignments = append(assignments, assignment)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"orders": assignments})
+}
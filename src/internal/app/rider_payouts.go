@@ -0,0 +1,352 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/segmentio/kafka-go"
+
+	"myproject/src/clock"
+)
+
+// Rider earnings and payouts. Each delivery enters the rider's earnings in
+// their ledger (see fee_sharing.go): their share of the base, distance and
+// surge parts of the delivery fee, and the customer's tip. GET
+// /rider/:id/earnings lists them and, with by=day or by=week, totals them by
+// day or by week, Monday to Sunday, in appConfig.Location.
+//
+// Earnings are paid out by RiderPayoutPeriod, a day or a week. Once a period
+// has closed, and RiderPayoutDelay more has passed for its last deliveries
+// to be entered, every rider who earned anything in it is requested a
+// payout: it is kept for GET /rider/:id/payouts, and a PayoutRequested event
+// is published on the rider-payouts topic for the payments system. A rider
+// is requested one payout a period, whichever instance gets there first;
+// the payout ID is made from the rider and the period, so the payments
+// system can tell a repeated event from a new payout.
+
+const (
+	payoutPeriodDay  = "day"
+	payoutPeriodWeek = "week"
+
+	payoutRequested = "requested"
+
+	riderPayoutsTopic = "rider-payouts"
+	riderPayoutsLock  = "lock:rider-payouts"
+	// riderPayoutsNextKey holds the start, in milliseconds, of the next
+	// period to close.
+	riderPayoutsNextKey = "payouts:rider:next"
+
+	eventPayoutRequested = "PayoutRequested"
+)
+
+var riderPayoutWriter messageWriter
+
+func validPayoutPeriod(period string) bool {
+	return period == payoutPeriodDay || period == payoutPeriodWeek
+}
+
+// payoutPeriodStart is the start of the day or week t falls in.
+func payoutPeriodStart(period string, t time.Time) time.Time {
+	t = t.In(appConfig.Location)
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, appConfig.Location)
+	if period == payoutPeriodWeek {
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	}
+	return start
+}
+
+func payoutPeriodEnd(period string, start time.Time) time.Time {
+	if period == payoutPeriodWeek {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// EarningsPeriod totals a rider's earnings over a day or a week.
+type EarningsPeriod struct {
+	Start      Timestamp `json:"start"`
+	End        Timestamp `json:"end"`
+	Deliveries int       `json:"deliveries"`
+	Base       float64   `json:"base"`
+	Distance   float64   `json:"distance"`
+	Surge      float64   `json:"surge"`
+	Tips       float64   `json:"tips"`
+	Total      float64   `json:"total"`
+}
+
+// totalEarnings totals entries into periods of the given kind, newest
+// first, as the entries are. The totals are kept in minor units while they
+// are added up.
+func totalEarnings(period string, entries []LedgerEntry) []EarningsPeriod {
+	type periodSums struct{ base, distance, surge, tips, total minorUnits }
+	currency := appConfig.PaymentCurrency
+	periods := []EarningsPeriod{}
+	var sums []periodSums
+	for _, entry := range entries {
+		start := payoutPeriodStart(period, entry.At.Time)
+		if n := len(periods); n == 0 || !periods[n-1].Start.Equal(start) {
+			periods = append(periods, EarningsPeriod{
+				Start: Timestamp{Time: start},
+				End:   Timestamp{Time: payoutPeriodEnd(period, start)},
+			})
+			sums = append(sums, periodSums{})
+		}
+		if entry.Rule != ledgerRuleTip {
+			periods[len(periods)-1].Deliveries++
+		}
+		sum := &sums[len(sums)-1]
+		sum.base += toMinor(entry.Base, currency)
+		sum.distance += toMinor(entry.Distance, currency)
+		sum.surge += toMinor(entry.Surge, currency)
+		sum.tips += toMinor(entry.Tip, currency)
+		sum.total += toMinor(entry.Amount, currency)
+	}
+	for i, sum := range sums {
+		p := &periods[i]
+		p.Base, p.Distance, p.Surge = sum.base.amount(currency), sum.distance.amount(currency), sum.surge.amount(currency)
+		p.Tips, p.Total = sum.tips.amount(currency), sum.total.amount(currency)
+	}
+	return periods
+}
+
+type RiderEarnings struct {
+	Ledger
+	// By is day or week when the entries are also totalled by Periods.
+	By      string           `json:"by,omitempty"`
+	Periods []EarningsPeriod `json:"periods,omitempty"`
+}
+
+// getRiderEarnings serves GET /rider/:id/earnings.
+func getRiderEarnings(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	riderID := c.Param("id")
+	if authClaims(c).Role != roleAdmin && !actsForRider(c, riderID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this rider"})
+	}
+	days, ok := ledgerDays(c)
+	if !ok {
+		return validationFailed(c, "days", fmt.Sprintf("must be between 1 and %d", maxLedgerDays))
+	}
+	by := c.QueryParam("by")
+	if by != "" && !validPayoutPeriod(by) {
+		return validationFailed(c, "by", "must be day or week")
+	}
+
+	ledger, err := readLedger(ctx, partyRider, riderID, days)
+	if err != nil {
+		requestLogger(c).Error("error fetching rider earnings", "rider_id", riderID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch earnings"})
+	}
+	earnings := RiderEarnings{Ledger: ledger, By: by}
+	if by != "" {
+		earnings.Periods = totalEarnings(by, ledger.Entries)
+	}
+	return c.JSON(http.StatusOK, earnings)
+}
+
+// RiderPayout is a payout requested of the payments system for what a
+// rider earned over a period.
+type RiderPayout struct {
+	PayoutID string `json:"payout_id"`
+	RiderID  string `json:"rider_id"`
+	Period   string `json:"period"`
+	EarningsPeriod
+	Currency    string    `json:"currency"`
+	Status      string    `json:"status"`
+	RequestedAt Timestamp `json:"requested_at"`
+}
+
+// PayoutRequested is the event published on the rider-payouts topic.
+type PayoutRequested struct {
+	Type   string `json:"type"`
+	Region string `json:"region,omitempty"`
+	RiderPayout
+}
+
+// riderPayoutsKey holds a rider's payouts by the date their period starts.
+func riderPayoutsKey(riderID string) string {
+	return "payouts:rider:" + riderID
+}
+
+// runRiderPayouts closes payout periods as they end, checking every
+// interval, until ctx is cancelled.
+func runRiderPayouts(ctx context.Context, period string, interval time.Duration) {
+	if !validPayoutPeriod(period) {
+		slog.Error("rider payout period must be day or week, rider payouts disabled", "period", period)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := closePayoutPeriods(ctx, period, interval)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("closing rider payout periods failed", "error", err)
+		}
+	}
+}
+
+// closePayoutPeriods requests payouts for each period closed since the last
+// one was, or for the one just closed the first time round. Only one
+// instance closes periods at a time; a period that fails is tried again
+// next time.
+func closePayoutPeriods(ctx context.Context, period string, interval time.Duration) error {
+	locked, err := redisClient.SetNX(ctx, riderPayoutsLock, 1, interval).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if !locked {
+		return nil
+	}
+	defer redisClient.Del(ctx, riderPayoutsLock)
+
+	// Periods ending by due have closed and had time for their deliveries
+	// to be entered.
+	due := payoutPeriodStart(period, clock.Now().Add(-appConfig.RiderPayoutDelay))
+	var start time.Time
+	next, err := redisClient.Get(ctx, riderPayoutsNextKey).Int64()
+	if err == redis.Nil {
+		start = payoutPeriodStart(period, due.Add(-time.Nanosecond))
+	} else if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	} else {
+		start = time.UnixMilli(next).In(appConfig.Location)
+	}
+
+	for start.Before(due) {
+		end := payoutPeriodEnd(period, start)
+		err := requestRiderPayouts(ctx, period, start, end)
+		if err != nil {
+			return err
+		}
+		err = redisClient.Set(ctx, riderPayoutsNextKey, end.UnixMilli(), 0).Err()
+		if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+		start = end
+	}
+	return nil
+}
+
+// requestRiderPayouts requests a payout for each rider's earnings from
+// start to end.
+func requestRiderPayouts(ctx context.Context, period string, start, end time.Time) error {
+	riders, err := repositories.Riders.Riders(ctx)
+	if err != nil {
+		return err
+	}
+
+	requested := 0
+	for _, rider := range riders {
+		entries, err := ledgerEntries(ctx, partyRider, rider.ID, &redis.ZRangeBy{
+			Min: strconv.FormatInt(start.UnixMilli(), 10),
+			Max: "(" + strconv.FormatInt(end.UnixMilli(), 10),
+		})
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		earnings := totalEarnings(period, entries)[0]
+		earnings.Start, earnings.End = Timestamp{Time: start}, Timestamp{Time: end}
+		if earnings.Total <= 0 {
+			continue
+		}
+
+		payout := RiderPayout{
+			PayoutID:       rider.ID + ":" + start.Format(time.DateOnly),
+			RiderID:        rider.ID,
+			Period:         period,
+			EarningsPeriod: earnings,
+			Currency:       appConfig.PaymentCurrency,
+			Status:         payoutRequested,
+			RequestedAt:    timestampNow(),
+		}
+		ok, err := requestRiderPayout(ctx, payout)
+		if err != nil {
+			return err
+		}
+		if ok {
+			requested++
+		}
+	}
+	slog.Info("rider payout period closed", "period", period, "start", start, "end", end, "payouts", requested)
+	return nil
+}
+
+// requestRiderPayout keeps payout and publishes it, unless the rider's
+// payout for the period was requested already. A payout that cannot be
+// published is dropped again, to be requested next time.
+func requestRiderPayout(ctx context.Context, payout RiderPayout) (bool, error) {
+	value, err := json.Marshal(payout)
+	if err != nil {
+		return false, err
+	}
+	key, field := riderPayoutsKey(payout.RiderID), payout.Start.Format(time.DateOnly)
+	added, err := redisClient.HSetNX(ctx, key, field, value).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis error: %v", err)
+	}
+	if !added {
+		return false, nil
+	}
+
+	event, err := json.Marshal(PayoutRequested{Type: eventPayoutRequested, Region: appConfig.Region, RiderPayout: payout})
+	if err != nil {
+		return false, err
+	}
+	err = publishMessage(ctx, riderPayoutWriter, "payout_requested", kafka.Message{Key: []byte(payout.RiderID), Value: event})
+	if err != nil {
+		if err := redisClient.HDel(ctx, key, field).Err(); err != nil {
+			slog.Error("error dropping unpublished rider payout", "payout_id", payout.PayoutID, "error", err)
+		}
+		return false, fmt.Errorf("failed to publish payout %s: %v", payout.PayoutID, err)
+	}
+	slog.Info("rider payout requested", "payout_id", payout.PayoutID, "rider_id", payout.RiderID, "amount", payout.Total, "deliveries", payout.Deliveries)
+	return true, nil
+}
+
+// getRiderPayouts serves GET /rider/:id/payouts: the payouts requested for
+// the rider, newest first.
+func getRiderPayouts(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	riderID := c.Param("id")
+	if authClaims(c).Role != roleAdmin && !actsForRider(c, riderID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this rider"})
+	}
+
+	values, err := redisClient.HGetAll(ctx, riderPayoutsKey(riderID)).Result()
+	if err != nil {
+		requestLogger(c).Error("error fetching rider payouts", "rider_id", riderID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch payouts"})
+	}
+	payouts := make([]RiderPayout, 0, len(values))
+	for field, value := range values {
+		var payout RiderPayout
+		if err := json.Unmarshal([]byte(value), &payout); err != nil {
+			requestLogger(c).Warn("skipping unreadable rider payout", "rider_id", riderID, "period", field, "error", err)
+			continue
+		}
+		payouts = append(payouts, payout)
+	}
+	sort.Slice(payouts, func(i, j int) bool { return payouts[i].Start.After(payouts[j].Start.Time) })
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"rider_id": riderID, "payouts": payouts})
+}
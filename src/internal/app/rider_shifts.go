@@ -0,0 +1,212 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+)
+
+// Rider shifts. A rider is online, and offered orders, from going online
+// until going offline; that span is a shift. Open shifts are kept in
+// riders:online and finished ones in a short per-rider history.
+
+const (
+	ridersOnlineKey   = "riders:online"
+	riderShiftHistory = 50
+
+	riderOnline  = "online"
+	riderOffline = "offline"
+)
+
+// takeShift removes and returns the rider's open shift, so that only one of
+// two concurrent offline requests ends it.
+var takeShift = redis.NewScript(`
+local shift = redis.call('HGET', KEYS[1], ARGV[1])
+if shift then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return shift
+`)
+
+type RiderShift struct {
+	ID        string     `json:"id"`
+	RiderID   string     `json:"rider_id"`
+	StartedAt Timestamp  `json:"started_at"`
+	EndedAt   *Timestamp `json:"ended_at,omitempty"`
+}
+
+type RiderStatusRequest struct {
+	RiderID string `json:"rider_id" validate:"required"`
+	Status  string `json:"status" validate:"required,oneof=online offline"`
+}
+
+// AvailableRider is a rider as the dispatcher sees them.
+type AvailableRider struct {
+	RiderID   string         `json:"rider_id"`
+	Name      string         `json:"name"`
+	Zone      string         `json:"zone"`
+	Shift     RiderShift     `json:"shift"`
+	Position  *RiderPosition `json:"position,omitempty"`
+	Locatable bool           `json:"locatable"`
+	OfferedAt *time.Time     `json:"offer_pending_since,omitempty"`
+}
+
+func riderShiftsKey(riderID string) string {
+	return "rider:" + riderID + ":shifts"
+}
+
+// getOnlineRiders returns the open shift of every online rider by rider ID.
+func getOnlineRiders(ctx context.Context) (map[string]RiderShift, error) {
+	entries, err := redisClient.HGetAll(ctx, ridersOnlineKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+
+	shifts := make(map[string]RiderShift, len(entries))
+	for riderID, data := range entries {
+		var shift RiderShift
+		if err := json.Unmarshal([]byte(data), &shift); err != nil {
+			return nil, fmt.Errorf("failed to parse shift of rider %s: %v", riderID, err)
+		}
+		shifts[riderID] = shift
+	}
+	return shifts, nil
+}
+
+// setRiderStatus serves POST /rider/status. Going online starts a shift and
+// going offline ends it; repeating either returns the current state.
+func setRiderStatus(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req RiderStatusRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	if !actsForRider(c, req.RiderID) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Token is not valid for this rider"})
+	}
+
+	logger := requestLogger(c).With("rider_id", req.RiderID)
+	if req.Status == riderOnline {
+		id, err := idGenerator.NewID()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start shift"})
+		}
+		shift := RiderShift{ID: id, RiderID: req.RiderID, StartedAt: timestampNow()}
+		shiftJSON, _ := json.Marshal(shift)
+
+		started, err := redisClient.HSetNX(ctx, ridersOnlineKey, req.RiderID, shiftJSON).Result()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start shift"})
+		}
+		if !started {
+			shifts, err := getOnlineRiders(ctx)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch shift"})
+			}
+			shift = shifts[req.RiderID]
+		} else {
+			logger.Info("rider went online", "shift_id", shift.ID)
+			wakeDispatch()
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"status": riderOnline, "shift": shift})
+	}
+
+	data, err := takeShift.Run(ctx, redisClient, []string{ridersOnlineKey}, req.RiderID).Text()
+	if err == redis.Nil {
+		return c.JSON(http.StatusOK, map[string]interface{}{"status": riderOffline})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to end shift"})
+	}
+
+	var shift RiderShift
+	err = json.Unmarshal([]byte(data), &shift)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to end shift"})
+	}
+	ended := timestampNow()
+	shift.EndedAt = &ended
+
+	shiftJSON, _ := json.Marshal(shift)
+	pipe := redisClient.TxPipeline()
+	pipe.LPush(ctx, riderShiftsKey(req.RiderID), shiftJSON)
+	pipe.LTrim(ctx, riderShiftsKey(req.RiderID), 0, riderShiftHistory-1)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		logger.Warn("error recording finished shift", "shift_id", shift.ID, "error", err)
+	}
+	// Dispatch only offers orders to riders on shift, so a rider left in
+	// the geo set is skipped; removing them keeps searches short.
+	if err := removeRiderFromGeo(ctx, req.RiderID); err != nil {
+		logger.Warn("error removing rider from geo set", "error", err)
+	}
+
+	logger.Info("rider went offline", "shift_id", shift.ID, "duration", ended.Sub(shift.StartedAt.Time))
+	return c.JSON(http.StatusOK, map[string]interface{}{"status": riderOffline, "shift": shift})
+}
+
+// listAvailableRiders serves GET /riders/available: every online rider with
+// where they last were and whether an offer is waiting on them, oldest shift
+// first. Riders that are not locatable are online but cannot be dispatched
+// until they report a position.
+func listAvailableRiders(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	shifts, err := getOnlineRiders(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch riders"})
+	}
+	riders, err := repositories.Riders.Riders(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch riders"})
+	}
+	offers, err := getDispatchOffers(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch offers"})
+	}
+
+	offeredSince := map[string]time.Time{}
+	for _, offer := range offers {
+		if since, ok := offeredSince[offer.RiderID]; !ok || offer.OfferedAt.Before(since) {
+			offeredSince[offer.RiderID] = offer.OfferedAt
+		}
+	}
+
+	zone := c.QueryParam("zone")
+	available := []AvailableRider{}
+	for _, rider := range riders {
+		shift, online := shifts[rider.ID]
+		if !online || (zone != "" && zoneOrDefault(rider.Zone) != zone) {
+			continue
+		}
+
+		position, err := latestRiderPosition(ctx, rider.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch rider positions"})
+		}
+		entry := AvailableRider{
+			RiderID:   rider.ID,
+			Name:      rider.Name,
+			Zone:      zoneOrDefault(rider.Zone),
+			Shift:     shift,
+			Position:  position,
+			Locatable: position != nil,
+		}
+		if since, ok := offeredSince[rider.ID]; ok {
+			entry.OfferedAt = &since
+		}
+		available = append(available, entry)
+	}
+	sort.Slice(available, func(i, j int) bool {
+		return available[i].Shift.StartedAt.Before(available[j].Shift.StartedAt.Time)
+	})
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"riders": available})
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// NearbyRider is an online rider near a restaurant, with what decides
+// whether the dispatcher would offer them its orders right now.
+type NearbyRider struct {
+	RiderID        string        `json:"rider_id"`
+	Name           string        `json:"name"`
+	Zone           string        `json:"zone"`
+	DistanceMeters float64       `json:"distance_m"`
+	Position       RiderPosition `json:"position"`
+	// ActiveOrders is how many orders the rider is carrying.
+	ActiveOrders int  `json:"active_orders"`
+	OfferPending bool `json:"offer_pending"`
+	// Dispatchable is false when the rider is in another zone, holding an
+	// offer or outside the dispatch radius; the dispatcher skips them.
+	Dispatchable bool `json:"dispatchable"`
+}
+
+type NearbyRiders struct {
+	RestaurantID string  `json:"restaurant_id"`
+	Zone         string  `json:"zone"`
+	RadiusMeters float64 `json:"radius_m"`
+	// DispatchRadiusMeters is the radius the dispatcher uses; zero means
+	// the whole zone.
+	DispatchRadiusMeters float64       `json:"dispatch_radius_m"`
+	Riders               []NearbyRider `json:"riders"`
+	// Unlocated counts online riders with no recent position, who cannot
+	// be placed or dispatched.
+	Unlocated int `json:"unlocated"`
+}
+
+// listNearbyRiders serves GET /restaurant/:id/riders/nearby for operators
+// looking into orders no rider took: the online riders within the dispatch
+// radius of the restaurant, closest first. radius_m widens or narrows the
+// search, to see who is just out of reach.
+func listNearbyRiders(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	radius := appConfig.DispatchRadiusMeters
+	if raw := c.QueryParam("radius_m"); raw != "" {
+		r, err := strconv.ParseFloat(raw, 64)
+		if err != nil || r <= 0 {
+			return validationFailed(c, "radius_m", "must be a positive number")
+		}
+		radius = r
+	}
+
+	restaurant, err := findRestaurant(ctx, c.Param("id"))
+	if err == errRestaurantNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Restaurant not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch restaurant"})
+	}
+
+	shifts, err := getOnlineRiders(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch riders"})
+	}
+	riders, err := repositories.Riders.Riders(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch riders"})
+	}
+	offers, err := getDispatchOffers(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch offers"})
+	}
+	offered := map[string]bool{}
+	for _, offer := range offers {
+		offered[offer.RiderID] = true
+	}
+
+	zone := zoneOrDefault(restaurant.Zone)
+	result := NearbyRiders{
+		RestaurantID:         restaurant.ID,
+		Zone:                 zone,
+		RadiusMeters:         radius,
+		DispatchRadiusMeters: appConfig.DispatchRadiusMeters,
+		Riders:               []NearbyRider{},
+	}
+	for _, rider := range riders {
+		if _, online := shifts[rider.ID]; !online {
+			continue
+		}
+		position, err := latestRiderPosition(ctx, rider.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch rider positions"})
+		}
+		if position == nil {
+			result.Unlocated++
+			continue
+		}
+		distance := distanceMeters(restaurant.Lat, restaurant.Lng, position.Lat, position.Lng)
+		if radius > 0 && distance > radius {
+			continue
+		}
+
+		active, err := redisClient.ZCard(ctx, riderOrdersKey(rider.ID)).Result()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch rider orders"})
+		}
+		inReach := appConfig.DispatchRadiusMeters <= 0 || distance <= appConfig.DispatchRadiusMeters
+		result.Riders = append(result.Riders, NearbyRider{
+			RiderID:        rider.ID,
+			Name:           rider.Name,
+			Zone:           zoneOrDefault(rider.Zone),
+			DistanceMeters: math.Round(distance),
+			Position:       *position,
+			ActiveOrders:   int(active),
+			OfferPending:   offered[rider.ID],
+			Dispatchable:   zoneOrDefault(rider.Zone) == zone && !offered[rider.ID] && inReach,
+		})
+	}
+	sort.SliceStable(result.Riders, func(i, j int) bool {
+		return result.Riders[i].DistanceMeters < result.Riders[j].DistanceMeters
+	})
+	return c.JSON(http.StatusOK, result)
+}
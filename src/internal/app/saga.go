@@ -0,0 +1,535 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"myproject/src/clock"
+)
+
+// Compensating sagas. When an order fails after money or a rider has been
+// committed to it, the consumer starts a saga that undoes what it can:
+//
+//   - a rejected, cancelled or expired order has its payment refunded and its
+//     stock returned, and, when rejected, the customer is told why.
+//   - a rider who took an order but has not reached the restaurant
+//     RiderNoShowAfter later is taken off it, the order goes back to dispatch
+//     for someone else, and the customer and the rider are told.
+//
+// A saga is kept in Redis with each step's progress, so it survives a
+// restart. Steps that fail are retried by the saga sweep, SagaRetryDelay
+// after the first failure and twice as long after each one after that. A
+// step that fails for good, or SagaMaxAttempts times, is given up on; once
+// every step is done or given up on, the saga is finished, and a failed one
+// is handed to support with a ticket. GET /admin/orders/:id/sagas shows an
+// order's sagas.
+
+const (
+	sagaStatusCompensating = "compensating"
+	sagaStatusCompensated  = "compensated"
+	sagaStatusFailed       = "failed"
+
+	sagaStepPending = "pending"
+	sagaStepDone    = "done"
+	sagaStepFailed  = "failed"
+	// sagaStepSkipped is a step that could not run because the one it
+	// follows failed.
+	sagaStepSkipped = "skipped"
+
+	sagaStepRefund           = "refund_payment"
+	sagaStepRestock          = "restock"
+	sagaStepNotifyRejected   = "notify_rejected"
+	sagaStepReassignRider    = "reassign_rider"
+	sagaStepNotifyReassigned = "notify_reassigned"
+
+	// sagaTriggerRiderNoShow starts a saga for a rider who never reached
+	// the restaurant; the other sagas are started by the event named.
+	sagaTriggerRiderNoShow = "RiderNoShow"
+
+	// sagasDueKey scores each unfinished saga by when its pending steps are
+	// next tried, in Unix milliseconds.
+	sagasDueKey = "sagas:due"
+	sagasLock   = "sagas:lock"
+	// sagaPickupWatchKey scores each rider assignment, as
+	// "{order_id}:{rider_id}", by when the rider must have reached the
+	// restaurant, in Unix milliseconds.
+	sagaPickupWatchKey = "sagas:watch:pickup"
+	sagasBatch         = 100
+
+	// sagaLockTTL bounds how long one run of a saga's steps holds it.
+	sagaLockTTL = 2 * time.Minute
+	// sagaMaxBackoff caps the wait between attempts at a step.
+	sagaMaxBackoff = time.Hour
+)
+
+var sagasFinished = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "sagas_finished_total",
+	Help: "Compensating sagas finished, by trigger and status (compensated or failed).",
+}, []string{"trigger", "status"})
+
+func init() {
+	prometheus.MustRegister(sagasFinished)
+}
+
+// sagaAction is what a saga step does.
+type sagaAction struct {
+	run orderEventHandler
+	// afterPrevious holds the step back until the step before it is done.
+	afterPrevious bool
+}
+
+var sagaActions = map[string]sagaAction{
+	sagaStepRefund:           {run: refundOrderPayment},
+	sagaStepRestock:          {run: restockOrder},
+	sagaStepNotifyRejected:   {run: notifyOrderRejected},
+	sagaStepReassignRider:    {run: reassignRider},
+	sagaStepNotifyReassigned: {run: notifyRiderReassigned, afterPrevious: true},
+}
+
+// sagaPlans is the steps each trigger's saga takes, in order.
+var sagaPlans = map[string][]string{
+	eventOrderRejected:     {sagaStepRefund, sagaStepRestock, sagaStepNotifyRejected},
+	eventOrderCancelled:    {sagaStepRefund, sagaStepRestock},
+	eventOrderExpired:      {sagaStepRefund, sagaStepRestock},
+	sagaTriggerRiderNoShow: {sagaStepReassignRider, sagaStepNotifyReassigned},
+}
+
+type SagaStep struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	DoneAt    Timestamp `json:"done_at"`
+}
+
+// Saga is the compensation for one failure of an order. Its ID is the ID of
+// the event that started it, so an event delivered twice starts one saga.
+type Saga struct {
+	ID            string     `json:"id"`
+	OrderID       string     `json:"order_id"`
+	Trigger       string     `json:"trigger"`
+	Event         OrderEvent `json:"event"`
+	Status        string     `json:"status"`
+	Steps         []SagaStep `json:"steps"`
+	StartedAt     Timestamp  `json:"started_at"`
+	UpdatedAt     Timestamp  `json:"updated_at"`
+	NextAttemptAt Timestamp  `json:"next_attempt_at"`
+	TicketID      string     `json:"ticket_id,omitempty"`
+}
+
+func sagaKey(sagaID string) string {
+	return "saga:" + sagaID
+}
+
+func sagaLockKey(sagaID string) string {
+	return "saga:" + sagaID + ":lock"
+}
+
+func orderSagasKey(orderID string) string {
+	return "order:" + orderID + ":sagas"
+}
+
+// createSaga stores a new saga, indexes it under its order and marks it due,
+// unless a saga with its ID exists already. It returns 1 if it stored it.
+var createSaga = redis.NewScript(`
+if not redis.call("SET", KEYS[1], ARGV[1], "NX") then
+	return 0
+end
+redis.call("SADD", KEYS[2], ARGV[2])
+redis.call("ZADD", KEYS[3], ARGV[3], ARGV[2])
+return 1
+`)
+
+// compensate handles the events of orders that can no longer be fulfilled
+// by starting their saga and running its steps once. Steps that fail are
+// left to the saga sweep, so the event is only retried if the saga cannot
+// be stored.
+func compensate(ctx context.Context, event OrderEvent) error {
+	started, err := startSaga(ctx, event.Type, event)
+	if err != nil || !started {
+		return err
+	}
+	return runSaga(ctx, event.EventID)
+}
+
+// startSaga stores a saga for trigger, started by event. It reports false if
+// the event has started one already.
+func startSaga(ctx context.Context, trigger string, event OrderEvent) (bool, error) {
+	if event.EventID == "" {
+		return false, permanent(fmt.Errorf("%s event has no event id", event.Type))
+	}
+	plan := sagaPlans[trigger]
+	now := timestampNow()
+	saga := Saga{
+		ID:        event.EventID,
+		OrderID:   event.OrderID,
+		Trigger:   trigger,
+		Event:     event,
+		Status:    sagaStatusCompensating,
+		Steps:     make([]SagaStep, len(plan)),
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+	for i, name := range plan {
+		saga.Steps[i] = SagaStep{Name: name, Status: sagaStepPending}
+	}
+	data, err := json.Marshal(saga)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode saga: %v", err)
+	}
+
+	keys := []string{sagaKey(saga.ID), orderSagasKey(saga.OrderID), sagasDueKey}
+	created, err := createSaga.Run(ctx, redisClient, keys, data, saga.ID, now.UnixMilli()).Int()
+	if err != nil {
+		return false, fmt.Errorf("redis error: %v", err)
+	}
+	if created == 1 {
+		eventLogger(event).Info("saga started", "saga_id", saga.ID, "trigger", trigger)
+	}
+	return created == 1, nil
+}
+
+// getSaga returns the saga, or redis.Nil if there is none.
+func getSaga(ctx context.Context, sagaID string) (Saga, error) {
+	data, err := redisClient.Get(ctx, sagaKey(sagaID)).Result()
+	if err == redis.Nil {
+		return Saga{}, err
+	} else if err != nil {
+		return Saga{}, fmt.Errorf("redis error: %v", err)
+	}
+	var saga Saga
+	if err := json.Unmarshal([]byte(data), &saga); err != nil {
+		return Saga{}, fmt.Errorf("malformed saga %s: %v", sagaID, err)
+	}
+	return saga, nil
+}
+
+// runSaga tries each of the saga's pending steps once, then either finishes
+// the saga or schedules its next attempt. A saga already being run elsewhere
+// is left alone.
+func runSaga(ctx context.Context, sagaID string) error {
+	locked, err := redisClient.SetNX(ctx, sagaLockKey(sagaID), 1, sagaLockTTL).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if !locked {
+		return nil
+	}
+	defer redisClient.Del(ctx, sagaLockKey(sagaID))
+
+	saga, err := getSaga(ctx, sagaID)
+	if err == redis.Nil {
+		return redisClient.ZRem(ctx, sagasDueKey, sagaID).Err()
+	} else if err != nil {
+		return err
+	}
+	if saga.Status != sagaStatusCompensating {
+		return redisClient.ZRem(ctx, sagasDueKey, sagaID).Err()
+	}
+
+	logger := eventLogger(saga.Event).With("saga_id", saga.ID, "trigger", saga.Trigger)
+	for i := range saga.Steps {
+		step := &saga.Steps[i]
+		if step.Status != sagaStepPending {
+			continue
+		}
+		action, ok := sagaActions[step.Name]
+		if !ok {
+			step.Status, step.LastError = sagaStepFailed, "unknown step"
+			continue
+		}
+		if action.afterPrevious && i > 0 {
+			switch saga.Steps[i-1].Status {
+			case sagaStepPending:
+				continue
+			case sagaStepFailed, sagaStepSkipped:
+				step.Status = sagaStepSkipped
+				continue
+			}
+		}
+
+		step.Attempts++
+		err := action.run(ctx, saga.Event)
+		if err == nil {
+			step.Status, step.LastError, step.DoneAt = sagaStepDone, "", timestampNow()
+			continue
+		}
+		step.LastError = err.Error()
+		if isPermanent(err) || step.Attempts >= appConfig.SagaMaxAttempts {
+			step.Status = sagaStepFailed
+			logger.Error("saga step failed", "step", step.Name, "attempts", step.Attempts, "error", err)
+		} else {
+			logger.Warn("saga step will be retried", "step", step.Name, "attempts", step.Attempts, "error", err)
+		}
+	}
+
+	return saveSaga(ctx, logger, saga)
+}
+
+// saveSaga stores the saga after a run: unfinished, it is scheduled for its
+// next attempt; finished, it is taken off the schedule and, if anything was
+// given up on, support gets a ticket.
+func saveSaga(ctx context.Context, logger *slog.Logger, saga Saga) error {
+	now := clock.Now()
+	saga.UpdatedAt = Timestamp{Time: now.UTC()}
+	pipe := redisClient.TxPipeline()
+
+	attempts, failed := 0, false
+	for _, step := range saga.Steps {
+		switch step.Status {
+		case sagaStepPending:
+			attempts = max(attempts, step.Attempts)
+		case sagaStepFailed, sagaStepSkipped:
+			failed = true
+		}
+	}
+
+	switch {
+	case attempts > 0:
+		next := now.Add(sagaBackoff(attempts))
+		saga.NextAttemptAt = Timestamp{Time: next.UTC()}
+		pipe.ZAdd(ctx, sagasDueKey, &redis.Z{Score: float64(next.UnixMilli()), Member: saga.ID})
+	case failed:
+		saga.Status, saga.NextAttemptAt = sagaStatusFailed, Timestamp{}
+		order, err := getOrder(ctx, saga.OrderID)
+		if err != nil && err != errOrderNotFound {
+			return err
+		}
+		if err == errOrderNotFound {
+			order = Order{OrderID: saga.OrderID, CustomerID: saga.Event.CustomerID}
+		}
+		ticket, err := newTicket(ticketSourceCompensationFailed, order, "Compensation failed for order "+saga.OrderID,
+			sagaFailureDescription(saga), saga.Event.TotalAmount)
+		if err != nil {
+			return err
+		}
+		saga.TicketID = ticket.ID
+		queueTicket(ctx, pipe, ticket)
+		pipe.ZRem(ctx, sagasDueKey, saga.ID)
+	default:
+		saga.Status, saga.NextAttemptAt = sagaStatusCompensated, Timestamp{}
+		pipe.ZRem(ctx, sagasDueKey, saga.ID)
+	}
+
+	data, err := json.Marshal(saga)
+	if err != nil {
+		return fmt.Errorf("failed to encode saga: %v", err)
+	}
+	pipe.Set(ctx, sagaKey(saga.ID), data, 0)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	if saga.Status != sagaStatusCompensating {
+		sagasFinished.WithLabelValues(saga.Trigger, saga.Status).Inc()
+		logger.Info("saga finished", "status", saga.Status, "ticket_id", saga.TicketID)
+	}
+	return nil
+}
+
+// sagaBackoff is how long to wait after a step's attempts-th failure.
+func sagaBackoff(attempts int) time.Duration {
+	delay := appConfig.SagaRetryDelay
+	for i := 1; i < attempts && delay < sagaMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, sagaMaxBackoff)
+}
+
+// sagaFailureDescription lists what a failed saga could not do, for the
+// support ticket.
+func sagaFailureDescription(saga Saga) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "The %s compensation for order %s did not finish:", saga.Trigger, saga.OrderID)
+	for _, step := range saga.Steps {
+		switch step.Status {
+		case sagaStepFailed:
+			fmt.Fprintf(&b, "\n- %s failed after %d attempts: %s", step.Name, step.Attempts, step.LastError)
+		case sagaStepSkipped:
+			fmt.Fprintf(&b, "\n- %s was skipped", step.Name)
+		}
+	}
+	return b.String()
+}
+
+// watchRiderPickup handles RiderAssigned: the rider has RiderNoShowAfter to
+// reach the restaurant.
+func watchRiderPickup(ctx context.Context, event OrderEvent) error {
+	if appConfig.RiderNoShowAfter <= 0 || event.RiderID == "" {
+		return nil
+	}
+	deadline := event.OccurredAt.Add(appConfig.RiderNoShowAfter)
+	err := redisClient.ZAdd(ctx, sagaPickupWatchKey, &redis.Z{
+		Score:  float64(deadline.UnixMilli()),
+		Member: event.OrderID + ":" + event.RiderID,
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// checkRiderPickup starts a no-show saga if the rider is still assigned to
+// the order and has not reached the restaurant.
+func checkRiderPickup(ctx context.Context, orderID, riderID string) error {
+	order, err := getOrder(ctx, orderID)
+	if err == errOrderNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if order.Status != "accepted" || order.RiderID != riderID || order.HasTimelineEvent(timelineArrivedAtRestaurant) {
+		return nil
+	}
+
+	event := newOrderEvent(ctx, sagaTriggerRiderNoShow, order)
+	event.EventID = orderID + ":" + sagaTriggerRiderNoShow + ":" + riderID
+	started, err := startSaga(ctx, sagaTriggerRiderNoShow, event)
+	if err != nil || !started {
+		return err
+	}
+	slog.Warn("rider did not reach the restaurant", "order_id", orderID, "rider_id", riderID)
+	return runSaga(ctx, event.EventID)
+}
+
+// reassignRider takes the order off the rider who did not turn up and puts
+// it back in the dispatch queue, where the rider is not offered it again.
+func reassignRider(ctx context.Context, event OrderEvent) error {
+	order, err := updateOrder(ctx, repositories.Orders, event.OrderID, func(order *Order) ([]OrderEvent, error) {
+		if order.Status != "accepted" || order.RiderID != event.RiderID {
+			return nil, nil
+		}
+		order.RiderID = ""
+		order.Timeline = append(order.Timeline, TimelineEvent{Event: timelineRiderUnassigned, At: timestampNow()})
+		unassigned := newOrderEvent(ctx, eventRiderUnassigned, *order)
+		unassigned.Reason = "Rider " + event.RiderID + " did not reach the restaurant"
+		return []OrderEvent{unassigned}, nil
+	})
+	if err == errOrderNotFound {
+		return permanent(err)
+	} else if err != nil {
+		return err
+	}
+
+	pipe := redisClient.TxPipeline()
+	pipe.ZRem(ctx, riderOrdersKey(event.RiderID), order.OrderID)
+	pipe.SAdd(ctx, dispatchDeclinedKey(order.OrderID), event.RiderID)
+	pipe.Expire(ctx, dispatchDeclinedKey(order.OrderID), 24*time.Hour)
+	if order.Status == "accepted" && order.RiderID == "" {
+		pipe.ZAddNX(ctx, dispatchPendingKey, &redis.Z{Score: float64(clock.Now().Unix()), Member: order.OrderID})
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	wakeDispatch()
+	return nil
+}
+
+// notifyRiderReassigned tells whoever is waiting for the order that a new
+// rider is being found, and the rider that the order was taken off them.
+func notifyRiderReassigned(ctx context.Context, event OrderEvent) error {
+	return errors.Join(
+		notifyTracking(ctx, event, "rider_reassigned", "", nil),
+		notifyParty(ctx, event, "rider", event.RiderID, "rider_unassigned", nil),
+	)
+}
+
+// runSagas runs due saga steps and checks rider pickups every interval
+// until ctx is cancelled.
+func runSagas(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := sweepSagas(ctx, interval)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("saga sweep failed", "error", err)
+		}
+	}
+}
+
+// sweepSagas runs the sagas due a retry and looks for riders past their
+// pickup deadline. Only one instance sweeps at a time. A saga or pickup that
+// fails is left for the next sweep.
+func sweepSagas(ctx context.Context, interval time.Duration) error {
+	locked, err := redisClient.SetNX(ctx, sagasLock, 1, interval).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if !locked {
+		return nil
+	}
+	defer redisClient.Del(ctx, sagasLock)
+
+	now := strconv.FormatInt(clock.Now().UnixMilli(), 10)
+	due, err := redisClient.ZRangeByScore(ctx, sagasDueKey, &redis.ZRangeBy{Min: "-inf", Max: now, Count: sagasBatch}).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	for _, sagaID := range due {
+		if err := runSaga(ctx, sagaID); err != nil {
+			slog.Error("error running saga", "saga_id", sagaID, "error", err)
+		}
+	}
+
+	watches, err := redisClient.ZRangeByScore(ctx, sagaPickupWatchKey, &redis.ZRangeBy{Min: "-inf", Max: now, Count: sagasBatch}).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	for _, watch := range watches {
+		orderID, riderID, _ := strings.Cut(watch, ":")
+		if err := checkRiderPickup(ctx, orderID, riderID); err != nil {
+			slog.Error("error checking rider pickup", "order_id", orderID, "rider_id", riderID, "error", err)
+			continue
+		}
+		if err := redisClient.ZRem(ctx, sagaPickupWatchKey, watch).Err(); err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+	}
+	return nil
+}
+
+// listOrderSagas serves GET /admin/orders/:id/sagas, the order's sagas
+// oldest first.
+func listOrderSagas(c echo.Context) error {
+	reqCtx := c.Request().Context()
+	orderID := c.Param("id")
+	ids, err := redisClient.SMembers(reqCtx, orderSagasKey(orderID)).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch sagas"})
+	}
+
+	sagas := make([]Saga, 0, len(ids))
+	for _, id := range ids {
+		saga, err := getSaga(reqCtx, id)
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			requestLogger(c).Error("error reading saga", "order_id", orderID, "saga_id", id, "error", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch sagas"})
+		}
+		sagas = append(sagas, saga)
+	}
+	sort.Slice(sagas, func(i, j int) bool { return sagas[i].StartedAt.Before(sagas[j].StartedAt.Time) })
+	return c.JSON(http.StatusOK, map[string]interface{}{"order_id": orderID, "sagas": sagas})
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"myproject/src/clock"
+)
+
+// Scheduled orders. An order placed with scheduled_at is paid for up front
+// but not offered to the restaurant until ScheduledOrderLead before that
+// time, enough to cook and deliver it. Until then its OrderPaid event waits
+// in the scheduledOrdersKey sorted set; a poller, run by one instance at a
+// time, offers each order when it falls due, as offerHeldOrder does for
+// orders held for their cancel grace window.
+
+const (
+	// scheduledOrdersKey scores the OrderPaid event of each held order by
+	// when it is to be released, in Unix milliseconds.
+	scheduledOrdersKey   = "orders:scheduled"
+	scheduledOrdersLock  = "orders:scheduled:lock"
+	scheduledOrdersBatch = 100
+)
+
+// releaseTime is when the order is offered to its restaurant; zero for orders
+// not scheduled.
+func releaseTime(order Order) time.Time {
+	if order.ScheduledAt == nil {
+		return time.Time{}
+	}
+	return order.ScheduledAt.Add(-appConfig.ScheduledOrderLead)
+}
+
+// checkSchedule checks the time an order is scheduled for: far enough ahead
+// to prepare it, not too far, and with the restaurant open both to take it
+// and when it is due.
+func checkSchedule(order Order, restaurant *Restaurant, now time.Time) error {
+	at := order.ScheduledAt.Time
+	if at.Before(now.Add(appConfig.ScheduledOrderMinLead)) {
+		return invalidField("scheduled_at", fmt.Sprintf("must be at least %s from now", appConfig.ScheduledOrderMinLead))
+	}
+	if at.After(now.Add(appConfig.ScheduledOrderMaxAhead)) {
+		return invalidField("scheduled_at", fmt.Sprintf("must be within %s from now", appConfig.ScheduledOrderMaxAhead))
+	}
+	if restaurant != nil && (!restaurantOpenAt(*restaurant, at) || !restaurantOpenAt(*restaurant, releaseTime(order))) {
+		return &serviceError{
+			Status:  http.StatusConflict,
+			Message: "Restaurant is closed at the scheduled time",
+			Details: map[string]interface{}{"opening_hours": restaurant.OpeningHours},
+		}
+	}
+	return nil
+}
+
+// holdScheduledOrder keeps a newly paid scheduled order from its restaurant
+// until its release time, and reports whether it did.
+func holdScheduledOrder(ctx context.Context, event OrderEvent) bool {
+	if event.Type != eventOrderPaid {
+		return false
+	}
+
+	order, err := getOrder(ctx, event.OrderID)
+	if err != nil {
+		slog.Warn("error fetching order to hold until scheduled", "order_id", event.OrderID, "error", err)
+		return false
+	}
+	release := releaseTime(order)
+	if release.IsZero() || !release.After(clock.Now()) {
+		return false
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("error encoding scheduled order event", "order_id", order.OrderID, "error", err)
+		return false
+	}
+	err = redisClient.ZAddNX(ctx, scheduledOrdersKey, &redis.Z{Score: float64(release.UnixMilli()), Member: string(data)}).Err()
+	if err != nil {
+		slog.Error("error holding scheduled order", "order_id", order.OrderID, "error", err)
+		return false
+	}
+
+	eventLogger(event).Info("scheduled order held", "release_at", release)
+	return true
+}
+
+// runScheduledOrders releases scheduled orders as they fall due, polling at
+// interval until ctx is cancelled.
+func runScheduledOrders(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := releaseScheduledOrders(ctx, interval)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("releasing scheduled orders failed", "error", err)
+		}
+	}
+}
+
+// releaseScheduledOrders offers each due order to its restaurant. An order
+// that fails stays held and is tried again next time.
+func releaseScheduledOrders(ctx context.Context, interval time.Duration) error {
+	locked, err := redisClient.SetNX(ctx, scheduledOrdersLock, 1, interval).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if !locked {
+		return nil
+	}
+	defer redisClient.Del(ctx, scheduledOrdersLock)
+
+	due, err := redisClient.ZRangeByScore(ctx, scheduledOrdersKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(clock.Now().UnixMilli(), 10),
+		Count: scheduledOrdersBatch,
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+
+	for _, member := range due {
+		var event OrderEvent
+		err := json.Unmarshal([]byte(member), &event)
+		if err != nil {
+			slog.Error("dropping unreadable scheduled order", "entry", member, "error", err)
+			redisClient.ZRem(ctx, scheduledOrdersKey, member)
+			continue
+		}
+
+		err = releaseScheduledOrder(ctx, event)
+		if err != nil {
+			eventLogger(event).Error("error releasing scheduled order", "error", err)
+			continue
+		}
+		redisClient.ZRem(ctx, scheduledOrdersKey, member)
+	}
+	return nil
+}
+
+// releaseScheduledOrder offers the restaurant a scheduled order, unless it
+// was cancelled meanwhile.
+func releaseScheduledOrder(ctx context.Context, event OrderEvent) error {
+	order, err := getOrder(ctx, event.OrderID)
+	if err == errOrderNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if order.Status != "created" {
+		slog.Info("scheduled order no longer awaiting restaurant, not offered", "order_id", order.OrderID, "status", order.Status)
+		return nil
+	}
+
+	// The webhook goes last so a retried notification does not queue it twice.
+	err = notifyOrderPaid(ctx, event)
+	if err != nil {
+		return err
+	}
+	offerToRestaurant(ctx, event)
+	eventLogger(event).Info("scheduled order released")
+	return nil
+}
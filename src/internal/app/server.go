@@ -0,0 +1,733 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo-contrib/echoprometheus"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"google.golang.org/grpc"
+
+	"myproject/src/events"
+	"myproject/src/handlers"
+)
+
+var redisClient *redis.Client
+var kafkaNotiWriter messageWriter
+var appConfig Config
+var jobQueue *JobQueue
+
+var errRestaurantNotFound = errors.New("restaurant not found")
+
+type Restaurant struct {
+	ID           string              `json:"id"`
+	Name         string              `json:"name"`
+	Address      string              `json:"address,omitempty"`
+	Contact      *RestaurantContact  `json:"contact,omitempty"`
+	Lat          float64             `json:"lat"`
+	Lng          float64             `json:"lng"`
+	Rating       float64             `json:"rating"`
+	OpeningHours *OpeningHours       `json:"opening_hours,omitempty"`
+	Cuisines     []string            `json:"cuisines,omitempty"`
+	Branding     *RestaurantBranding `json:"branding,omitempty"`
+	Zone         string              `json:"zone,omitempty"`
+	DeliveryArea *DeliveryArea       `json:"delivery_area,omitempty"`
+	CreatedAt    Timestamp           `json:"created_at"`
+	UpdatedAt    Timestamp           `json:"updated_at"`
+}
+
+type Rider struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Zone      string    `json:"zone,omitempty"`
+	CreatedAt Timestamp `json:"created_at"`
+	UpdatedAt Timestamp `json:"updated_at"`
+}
+
+// backends are a Redis client and event bus for setup to run on in place
+// of the configured ones, as the integration tests do on theirs.
+type backends struct {
+	redis *redis.Client
+	bus   eventBus
+}
+
+// setup loads the configuration and connects to Redis and the event bus,
+// everything both commands need before they start work, or takes injected
+// ones if not nil. It exits the process on invalid configuration. The
+// returned func releases what setup started that shutdown does not.
+func setup(service string, injected *backends) func() {
+	appConfig = loadConfig()
+	slog.SetDefault(newLogger(appConfig.LogLevel, appConfig.LogFormat).With("service", service, "version", buildInfo.Version, "commit", buildInfo.GitCommit))
+	slog.Info("starting food delivery "+service,
+		"build_time", buildInfo.BuildTime,
+		"go_version", buildInfo.GoVersion,
+		"http_addr", appConfig.HTTPAddr,
+		"backend", appConfig.Backend,
+		"store", appConfig.Store,
+		"kafka_brokers", appConfig.KafkaBrokers,
+		"redis_addr", appConfig.RedisAddr,
+	)
+
+	var err error
+	paymentProvider, err = newPaymentProvider(appConfig.PaymentProvider)
+	if err != nil {
+		slog.Error("invalid payment configuration", "error", err)
+		os.Exit(1)
+	}
+
+	err = validateDispatchConfig()
+	if err != nil {
+		slog.Error("invalid dispatch configuration", "error", err)
+		os.Exit(1)
+	}
+
+	err = validateRegion(appConfig.Region)
+	if err != nil {
+		slog.Error("invalid region", "error", err)
+		os.Exit(1)
+	}
+
+	err = validateBackend(appConfig.Backend)
+	if err != nil {
+		slog.Error("invalid backend", "error", err)
+		os.Exit(1)
+	}
+
+	err = validateStore(appConfig.Store)
+	if err != nil {
+		slog.Error("invalid store", "error", err)
+		os.Exit(1)
+	}
+
+	err = validateBlobStore(appConfig.BlobStore)
+	if err != nil {
+		slog.Error("invalid blob store", "error", err)
+		os.Exit(1)
+	}
+
+	err = validateRateLimits()
+	if err != nil {
+		slog.Error("invalid rate limits", "error", err)
+		os.Exit(1)
+	}
+
+	err = validateFeeShare()
+	if err != nil {
+		slog.Error("invalid fee sharing", "error", err)
+		os.Exit(1)
+	}
+
+	err = validateHTTPMiddleware()
+	if err != nil {
+		slog.Error("invalid http middleware configuration", "error", err)
+		os.Exit(1)
+	}
+
+	err = validateFeatureFlags()
+	if err != nil {
+		slog.Error("invalid feature flags", "error", err)
+		os.Exit(1)
+	}
+
+	err = events.ValidSchema(appConfig.EventSchemaVersion)
+	if err != nil {
+		slog.Error("invalid event schema version", "error", err)
+		os.Exit(1)
+	}
+
+	err = validateOrderArchive()
+	if err != nil {
+		slog.Error("invalid order archive", "error", err)
+		os.Exit(1)
+	}
+
+	err = initTracing()
+	if err != nil {
+		slog.Error("invalid tracing configuration", "error", err)
+		os.Exit(1)
+	}
+
+	profanity := appConfig.ProfanityWords
+	if len(profanity) == 0 {
+		profanity = defaultProfanity
+	}
+	registerTextFilter(newProfanityFilter(profanity))
+	registerTextFilter(piiFilter{})
+	err = validateTextFilters()
+	if err != nil {
+		slog.Error("invalid text filters", "error", err)
+		os.Exit(1)
+	}
+
+	setupBreakers()
+	setupExportSlots()
+	release := func() {}
+	if injected != nil {
+		redisClient = injected.redis
+		bus = injected.bus
+	} else if appConfig.Backend == backendMemory {
+		memoryRedis, client, err := startMemoryRedis()
+		if err != nil {
+			slog.Error("invalid backend", "error", err)
+			os.Exit(1)
+		}
+		release = memoryRedis.Close
+		redisClient = client
+		bus = newMemoryBus()
+		slog.Warn("running on in-memory redis and event bus; nothing is kept across restarts")
+	} else {
+		redisClient = redis.NewClient(redisOptions(appConfig.RedisAddr))
+		bus = newKafkaBus(appConfig.KafkaBrokers)
+	}
+	redisClient.AddHook(redisBreakerHook{})
+	if appConfig.Region != "" {
+		redisClient.AddHook(newRegionKeyHook(appConfig.Region))
+	}
+	redisClient.AddHook(newRedisRetryHook(redisClient))
+
+	repositories, err = openRepositories(context.Background())
+	if err != nil {
+		slog.Error("failed to open store", "store", appConfig.Store, "error", err)
+		os.Exit(1)
+	}
+	blobs = openBlobStore()
+	orderArchive = openOrderArchive()
+	closeRedis := release
+	release = func() {
+		repositories.close()
+		closeRedis()
+	}
+
+	orderEventRouter = newTopicRouter(bus, ordersTopic, appConfig.EventTopics)
+	kafkaNotiWriter = bus.Writer(regionTopic("order-delivered"))
+	kafkaDLQWriter = bus.Writer(regionTopic(dlqTopic))
+	opsIncidentWriter = bus.Writer(regionTopic(opsIncidentTopic))
+	riderPayoutWriter = bus.Writer(regionTopic(riderPayoutsTopic))
+	closeoutWriter = bus.Writer(regionTopic(closeoutsTopic))
+	analyticsWriter = bus.Writer(regionTopic(analyticsTopic))
+	analyticsProducer = newEventProducer(appConfig.ProducerBufferSize, appConfig.ProducerBatchSize, appConfig.ProducerFlushInterval)
+
+	registerNotifier(WebhookNotifier{Client: &http.Client{Timeout: 10 * time.Second}})
+	registerNotifier(EmailNotifier{Sender: appConfig.Email})
+	push, err := newPushNotifier()
+	if err != nil {
+		slog.Error("invalid push notification configuration", "error", err)
+		os.Exit(1)
+	}
+	if push != nil {
+		registerNotifier(push)
+	}
+	return release
+}
+
+// Clients are the Redis client, analytics writer, stores and configuration
+// Setup connected to, for a command's main to build the services on.
+type Clients struct {
+	Redis        *redis.Client
+	Analytics    messageWriter
+	Repositories Repositories
+	Config       Config
+
+	// Release releases what Setup started that shutdown does not.
+	Release func()
+}
+
+// Setup runs setup on the configured backends for service and returns what
+// it connected to.
+func Setup(service string) Clients {
+	release := setup(service, nil)
+	return Clients{
+		Redis:        redisClient,
+		Analytics:    analyticsWriter,
+		Repositories: repositories,
+		Config:       appConfig,
+		Release:      release,
+	}
+}
+
+// newOrderConsumer returns the consumer of order events, in the group the
+// API and notification workers share.
+func newOrderConsumer() *Consumer {
+	return NewConsumer(regionTopic(consumerGroup), orderEventRouter.topics(), handleOrderMessage)
+}
+
+// RunAPI serves the REST and gRPC APIs on the given services and runs the
+// background jobs that move orders along, until it is interrupted. Setup
+// must have been called first. Order and analytics events are
+// consumed by the notification worker, or here as well if CONSUMER_IN_API is
+// set; the in-memory backend has no bus to share, so with it they always are.
+func RunAPI(menus MenuService, orders OrderService, notifications NotificationService) {
+	if appConfig.JWTSecret == "" {
+		slog.Error("JWT_SECRET must be set")
+		os.Exit(1)
+	}
+
+	h := handlers.New(menus, orders, notifications)
+	e := newRouter(h, orders)
+
+	appCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var consumers []*Consumer
+	if appConfig.ConsumerInAPI || appConfig.Backend == backendMemory {
+		consumers = []*Consumer{newOrderConsumer(), newAnalyticsConsumer()}
+		for _, consumer := range consumers {
+			consumer.Start(appCtx)
+		}
+	}
+
+	if appConfig.JobQueueEnabled {
+		jobQueue = NewJobQueue(redisClient, appConfig.JobLease, appConfig.JobPollInterval, appConfig.JobMaxAttempts)
+		jobQueue.Register(jobOrderExpiry, expireOrder)
+		jobQueue.Register(jobRestaurantOffer, offerHeldOrder)
+		go jobQueue.Run(appCtx)
+	} else if appConfig.CancelGraceWindow > 0 || len(appConfig.CancelGraceWindows) > 0 {
+		slog.Warn("job queue disabled, restaurants are offered orders without waiting out the cancel grace window")
+	}
+
+	go runOutboxRelay(appCtx, appConfig.OutboxPollInterval)
+	go runDispatcher(appCtx, appConfig.DispatchInterval)
+	go runOrderTimeouts(appCtx, appConfig.OrderTimeoutInterval)
+	go runSagas(appCtx, appConfig.SagaInterval)
+	go runScheduledOrders(appCtx, appConfig.ScheduledOrderPollInterval)
+	go runReadyCountdown(appCtx, appConfig.ReadyCountdownInterval)
+	go runProjections(appCtx)
+	go runMemoryBudgets(appCtx, appConfig.MemoryBudgets, appConfig.MemoryBudgetInterval)
+	go runAlerting(appCtx, appConfig.AlertCheckInterval)
+	go runRiderPayouts(appCtx, appConfig.RiderPayoutPeriod, appConfig.RiderPayoutInterval)
+	go runFeatureFlags(appCtx, appConfig.FeatureFlagRefreshInterval)
+	if appConfig.OrderRetention > 0 {
+		go runOrderArchiver(appCtx, appConfig.OrderArchiveInterval)
+	}
+
+	go runDailyReportJob(appCtx, appConfig.Email, appConfig.ReportRecipients, appConfig.ReportHour)
+	if appConfig.PprofAddr != "" {
+		go servePprof(appCtx, appConfig.PprofAddr)
+	}
+
+	go func() {
+		err := e.Start(appConfig.HTTPAddr)
+		if err != nil && err != http.ErrServerClosed {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	var grpcServer *grpc.Server
+	if appConfig.GRPCAddr != "" {
+		grpcServer = newGRPCServer(menus, orders)
+		go func() {
+			slog.Info("grpc server listening", "addr", appConfig.GRPCAddr)
+			err := serveGRPC(grpcServer, appConfig.GRPCAddr)
+			if err != nil {
+				slog.Error("grpc server failed", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	<-appCtx.Done()
+	shutdown(e, grpcServer, consumers, appConfig.ShutdownTimeout)
+}
+
+// RunNotificationWorker consumes order events, sending the notifications,
+// refunds and other follow-ups they call for, and aggregates analytics
+// events, until it is interrupted. It serves only health checks and metrics,
+// on NOTIFICATION_WORKER_HTTP_ADDR. Any number of workers can run side by
+// side: they share the consumer groups. Setup must have been called first.
+func RunNotificationWorker() {
+	if appConfig.Backend == backendMemory {
+		slog.Error("the notification worker needs the kafka backend; the in-memory bus is only seen by the api, which consumes its events itself")
+		os.Exit(1)
+	}
+
+	appCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	consumers := []*Consumer{newOrderConsumer(), newAnalyticsConsumer()}
+	for _, consumer := range consumers {
+		consumer.Start(appCtx)
+	}
+
+	if appConfig.PprofAddr != "" {
+		go servePprof(appCtx, appConfig.PprofAddr)
+	}
+
+	e := newOpsRouter()
+	go func() {
+		err := e.Start(appConfig.WorkerHTTPAddr)
+		if err != nil && err != http.ErrServerClosed {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	<-appCtx.Done()
+	shutdown(e, nil, consumers, appConfig.ShutdownTimeout)
+}
+
+// newOpsRouter serves the health checks and metrics of a command that has
+// no API of its own.
+func newOpsRouter() *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	if appConfig.RecoverEnabled {
+		e.Use(recovery)
+	}
+	e.GET("/healthz", healthz)
+	e.GET("/readyz", readyz)
+	e.GET("/metrics", echoprometheus.NewHandler())
+	e.GET("/version", getVersion)
+	return e
+}
+
+// newRouter builds the HTTP API on h, orders for the cart and kitchen
+// routes, and the package-level stores.
+func newRouter(h *handlers.Handlers, orders OrderService) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	e.IPExtractor = clientIPExtractor()
+	e.Validator = newRequestValidator()
+	e.JSONSerializer = handlers.CasingSerializer{}
+	e.Pre(apiVersioning)
+	e.Use(middleware.RequestIDWithConfig(middleware.RequestIDConfig{Generator: newRequestID}))
+	e.Use(requestTracing)
+	e.Use(requestLogging)
+	useHTTPMiddleware(e)
+	e.Use(jsonCasing)
+	e.Use(rateLimiting)
+	e.Use(orderVersioning)
+	e.Use(echoprometheus.NewMiddleware("food_delivery"))
+
+	e.GET("/healthz", healthz)
+	e.GET("/metrics", echoprometheus.NewHandler())
+	e.GET("/readyz", readyz)
+	e.GET("/version", getVersion)
+	e.GET("/menu", h.GetMenu, optionalAuth)
+	e.GET("/restaurant", getRestaurant)
+	e.GET("/restaurants", listRestaurants)
+	e.GET("/cuisines", listCuisines)
+	e.GET("/rider", getRider)
+	e.GET("/restaurant/:id/serviceable", checkServiceability)
+	e.POST("/quote", quoteDelivery)
+	if appConfig.BlobStore == blobLocal {
+		e.Static("/media", appConfig.MediaDir)
+	}
+
+	customerOnly := requireRole(roleCustomer)
+	restaurantOnly := requireRole(roleRestaurant)
+	riderOnly := requireRole(roleRider)
+	adminOnly := requireRole(roleAdmin)
+
+	e.POST("/order", h.PlaceOrder, customerOnly)
+	e.POST("/orders/bulk", h.PlaceBulkOrders, customerOnly)
+	e.POST("/cart", orders.createCart, customerOnly)
+	e.GET("/cart/:id", orders.getCartHandler, customerOnly)
+	e.PATCH("/cart/:id/items", orders.updateCartItems, customerOnly)
+	e.POST("/cart/:id/checkout", orders.checkoutCart, customerOnly)
+	e.GET("/order/:id", getOrderHandler, requireRole(roleCustomer, roleRestaurant, roleRider, roleAdmin))
+	e.PATCH("/order/:id", h.ModifyOrder, customerOnly)
+	e.POST("/order/cancel", cancelOrder, customerOnly)
+	e.POST("/order/pay", payOrder, customerOnly)
+	e.POST("/order/:id/tip", tipOrder, customerOnly)
+	e.GET("/order/:id/stream", streamOrder, trackingAuth)
+	e.POST("/tracking/ticket", issueTrackingTicket, requireRole(trackingRoles...))
+	e.GET("/tracking/ws", trackOrders, trackingAuth)
+	e.GET("/order/:id/status", getOrderStatus, customerOnly)
+	e.POST("/order/:id/message", sendOrderMessage, requireRole(roleCustomer, roleRider))
+	e.GET("/order/:id/messages", listOrderMessages, requireRole(roleCustomer, roleRider, roleAdmin))
+	e.GET("/order/:id/eta", getOrderETA, requireRole(roleCustomer, roleRestaurant, roleRider, roleAdmin))
+	e.POST("/customer", registerCustomer, customerOnly)
+	e.GET("/customer/favorites", listFavorites, customerOnly)
+	e.GET("/customer/:id", getCustomerHandler, requireRole(roleCustomer, roleAdmin))
+	e.GET("/customer/:id/orders", listCustomerOrders, requireRole(roleCustomer, roleAdmin))
+	e.POST("/customer/favorites", addFavorite, customerOnly)
+	e.DELETE("/customer/favorites/:restaurantId", removeFavorite, customerOnly)
+	e.POST("/order/:id/issues", reportOrderIssue, customerOnly)
+	e.GET("/order/:id/issues", listOrderIssues, customerOnly)
+	e.POST("/order/:id/refund-request", requestRefund, customerOnly)
+	e.POST("/order/:id/refund", refundOrder, adminOnly)
+	e.GET("/order/code/:code", getOrderByCodeHandler, requireRole(roleCustomer, roleRestaurant, roleRider, roleAdmin))
+	e.GET("/order/:id/events", getOrderEvents, requireRole(roleCustomer, roleRestaurant, roleRider, roleAdmin))
+	e.POST("/restaurant/order/accept", h.AcceptOrder, restaurantOnly)
+	e.POST("/restaurant/order/reject", h.RejectOrder, restaurantOnly)
+	e.GET("/restaurant/order/:id/package-note", getPackageNote, restaurantOnly)
+	e.PUT("/restaurant/order/:id/ready-by", setOrderReadyBy, restaurantOnly)
+	e.GET("/restaurant/:id/orders/pending", streamPendingOrders, trackingAuth)
+	e.POST("/restaurant/:id/orders/batch", orders.batchKitchenOrders, restaurantOnly)
+	e.PATCH("/menu/item/:id/availability", setItemAvailability, restaurantOnly)
+	e.PUT("/menu/item/:id/price", changeMenuPrice, requireRole(roleRestaurant, roleOwner))
+	e.PUT("/menu/item/:id/pairings", setItemPairings, requireRole(roleRestaurant, roleOwner))
+	e.POST("/menu/item/:id/image", uploadMenuItemImage, requireRole(roleRestaurant, roleOwner))
+	e.POST("/menu/suggestions", suggestMenuItems, optionalAuth)
+	e.POST("/menu/suggestions/click", recordSuggestionClick, optionalAuth)
+	e.GET("/restaurant/:id/menu/suggestions/stats", getSuggestionStats, requireRole(roleRestaurant, roleOwner))
+	e.GET("/restaurant/:id/price-changes", listPriceChanges, requireRole(roleRestaurant, roleOwner))
+	e.POST("/restaurant/:id/price-changes/:changeId/approve", approvePriceChange, requireRole(roleOwner))
+	e.POST("/restaurant/:id/price-changes/:changeId/reject", rejectPriceChange, requireRole(roleOwner))
+	e.POST("/restaurant/:id/menu/clone", cloneMenu, requireRole(roleOwner, roleAdmin))
+	e.POST("/restaurant/:id/menu/publish", publishMenu, requireRole(roleRestaurant, roleOwner, roleAdmin))
+	e.GET("/restaurant/:id/menu/compliance", getMenuCompliance, requireRole(roleRestaurant, roleOwner, roleAdmin))
+	e.POST("/rider/order/pickup", h.ConfirmPickup, riderOnly)
+	e.POST("/rider/order/deliver", h.ConfirmDelivery, riderOnly)
+	e.POST("/rider/order/:id/proof-photo", uploadProofPhoto, riderOnly)
+	e.GET("/rider/order/:id/checklist", getPickupChecklist, riderOnly)
+	e.GET("/rider/:id/orders/active", getRiderActiveOrders, riderOnly)
+	e.POST("/rider/location", updateRiderLocation, riderOnly)
+	e.POST("/rider/status", setRiderStatus, riderOnly)
+	e.GET("/rider/offers", getRiderOffers, riderOnly)
+	e.POST("/rider/offers/accept", acceptOffer, riderOnly)
+	e.POST("/rider/offers/decline", declineOffer, riderOnly)
+	e.POST("/notification/send", h.SendNotification, adminOnly)
+	e.PUT("/notification/contacts/:type/:id", setContact, adminOnly)
+	e.POST("/notification/devices", registerDevice, requireRole(roleCustomer, roleRider))
+	e.DELETE("/notification/devices/:token", unregisterDevice, requireRole(roleCustomer, roleRider))
+	e.GET("/notification/preferences", getMyNotificationPreferences, requireRole(roleCustomer, roleRider))
+	e.PUT("/notification/preferences", setMyNotificationPreferences, requireRole(roleCustomer, roleRider))
+	e.GET("/admin/notifications/:id", getNotification, adminOnly)
+	e.POST("/admin/notifications/:id/retry", retryNotification, adminOnly)
+	e.POST("/restaurant/customer/block", restaurantBlockCustomer, restaurantOnly)
+	e.POST("/restaurant/customer/unblock", restaurantUnblockCustomer, restaurantOnly)
+	e.PUT("/restaurant/:id/branding/logo", uploadRestaurantLogo, restaurantOnly)
+	e.PUT("/restaurant/:id/branding/cover", uploadRestaurantCover, restaurantOnly)
+	e.POST("/restaurant/:id/gallery", addGalleryPhoto, restaurantOnly)
+	e.POST("/restaurant/:id/announcements", publishAnnouncement, restaurantOnly)
+	e.POST("/restaurant", registerRestaurant, adminOnly)
+	e.PUT("/restaurant/:id", updateRestaurant, requireRole(roleRestaurant, roleOwner, roleAdmin))
+	e.GET("/restaurant/:id/webhook", getRestaurantWebhookHandler, requireRole(roleRestaurant, roleOwner))
+	e.PUT("/restaurant/:id/webhook", setRestaurantWebhook, requireRole(roleRestaurant, roleOwner))
+	e.DELETE("/restaurant/:id/webhook", deleteRestaurantWebhook, requireRole(roleRestaurant, roleOwner))
+	e.GET("/restaurant/:id/dashboard", getRestaurantDashboard, requireRole(roleRestaurant, roleOwner, roleAdmin))
+	e.GET("/dashboard/restaurants", listRestaurantDashboards, adminOnly)
+	e.GET("/dashboard/restaurants/:id", getRestaurantDashboard, requireRole(roleRestaurant, roleOwner, roleAdmin))
+	e.GET("/dashboard/restaurants/:id/revenue", getRestaurantRevenue, requireRole(roleRestaurant, roleOwner, roleAdmin))
+	e.GET("/dashboard/riders", listRiderDeliveries, adminOnly)
+	e.GET("/dashboard/riders/:id", getRiderDeliveries, requireRole(roleRider, roleAdmin))
+	e.GET("/dashboard/revenue", getDailyRevenue, adminOnly)
+	e.PUT("/restaurant/:id/cuisines", assignRestaurantCuisines, requireRole(roleRestaurant, roleAdmin))
+	e.DELETE("/restaurant/:id/gallery/:photoId", deleteGalleryPhoto, restaurantOnly)
+	e.POST("/admin/customer/block", adminBlockCustomer, adminOnly)
+	e.POST("/admin/customer/unblock", adminUnblockCustomer, adminOnly)
+	e.GET("/admin/blocklist/audit", getBlocklistAudit, adminOnly)
+	e.POST("/admin/cache/warm", warmMenuCache, adminOnly)
+	e.POST("/admin/cuisines", upsertCuisine, adminOnly)
+	e.DELETE("/admin/cuisines/:slug", deleteCuisine, adminOnly)
+	e.POST("/admin/dlq/redrive", redriveDLQ, adminOnly)
+	e.GET("/admin/promos", listPromos, adminOnly)
+	e.PUT("/admin/promos/:code", setPromo, adminOnly)
+	e.DELETE("/admin/promos/:code", deletePromo, adminOnly)
+	e.GET("/admin/fee-sharing", getFeeSharing, adminOnly)
+	e.PUT("/admin/fee-sharing/zones/:zone", setZoneFeeShare, adminOnly)
+	e.DELETE("/admin/fee-sharing/zones/:zone", deleteZoneFeeShare, adminOnly)
+	e.PUT("/admin/fee-sharing/restaurants/:id", setRestaurantFeeShare, adminOnly)
+	e.DELETE("/admin/fee-sharing/restaurants/:id", deleteRestaurantFeeShare, adminOnly)
+	e.GET("/admin/ledger/platform", getPlatformLedger, adminOnly)
+	e.GET("/rider/:id/earnings", getRiderEarnings, requireRole(roleRider, roleAdmin))
+	e.GET("/rider/:id/payouts", getRiderPayouts, requireRole(roleRider, roleAdmin))
+	e.GET("/restaurant/:id/payouts", getRestaurantPayouts, requireRole(roleRestaurant, roleOwner, roleAdmin))
+	e.PUT("/restaurant/:id/pos/:date", uploadPOSFigures, requireRole(roleRestaurant, roleOwner, roleAdmin))
+	e.POST("/restaurant/:id/closeout", closeOutRestaurant, requireRole(roleRestaurant, roleOwner, roleAdmin))
+	e.GET("/restaurant/:id/closeout/:date", getRestaurantCloseout, requireRole(roleRestaurant, roleOwner, roleAdmin))
+	e.GET("/admin/brands", listBrands, adminOnly)
+	e.PUT("/admin/brands/:id", setBrand, adminOnly)
+	e.DELETE("/admin/brands/:id", deleteBrand, adminOnly)
+	e.GET("/brand/:id", getBrandHandler, requireRole(roleRestaurant, roleOwner, roleAdmin))
+	e.GET("/brand/:id/dashboard", getBrandDashboard, requireRole(roleRestaurant, roleOwner, roleAdmin))
+	e.GET("/riders/available", listAvailableRiders, adminOnly)
+	e.GET("/restaurant/:id/riders/nearby", listNearbyRiders, adminOnly)
+	e.GET("/admin/dispatch", dispatchQueueStatus, adminOnly)
+	e.GET("/admin/webhooks", listWebhooks, adminOnly)
+	e.POST("/admin/webhooks", createWebhook, adminOnly)
+	e.DELETE("/admin/webhooks/:id", deleteWebhook, adminOnly)
+	e.GET("/admin/webhooks/:id/deliveries", getWebhookDeliveries, adminOnly)
+	e.POST("/webhooks", createPartnerWebhook, requireRole(rolePartner))
+	e.GET("/webhooks", listPartnerWebhooks, requireRole(rolePartner))
+	e.DELETE("/webhooks/:id", deleteWebhook, requireRole(rolePartner))
+	e.GET("/webhooks/:id/deliveries", getWebhookDeliveries, requireRole(rolePartner))
+	e.POST("/admin/apikeys", createAPIKey, adminOnly)
+	e.GET("/admin/apikeys", listAPIKeys, adminOnly)
+	e.POST("/admin/apikeys/:id/rotate", rotateAPIKey, adminOnly)
+	e.POST("/admin/apikeys/:id/revoke", revokeAPIKey, adminOnly)
+	e.POST("/admin/signing-keys", createSigningKey, adminOnly)
+	e.GET("/admin/signing-keys", listSigningKeys, adminOnly)
+	e.POST("/admin/signing-keys/:id/revoke", revokeSigningKey, adminOnly)
+	e.GET("/admin/orders/export", exportOrders, adminOnly)
+	e.POST("/admin/orders/:id/events/:event_id/replay", replayOrderEvent, adminOnly)
+	e.GET("/admin/orders/:id/sagas", listOrderSagas, adminOnly)
+	e.GET("/admin/analytics", getAnalytics, adminOnly)
+	e.GET("/admin/flags", listFeatureFlags, adminOnly)
+	e.PUT("/admin/flags/:name", setFeatureFlag, adminOnly)
+	e.DELETE("/admin/flags/:name", clearFeatureFlag, adminOnly)
+	e.GET("/admin/orders/archive", getArchivedOrders, adminOnly)
+	e.GET("/admin/projections", listProjections, adminOnly)
+	e.POST("/admin/projections/:name/rebuild", rebuildProjectionHandler, adminOnly)
+	e.POST("/admin/projections/:name/pause", pauseProjection, adminOnly)
+	e.POST("/admin/projections/:name/resume", resumeProjection, adminOnly)
+	e.GET("/admin/tickets", listTickets, adminOnly)
+	e.GET("/admin/tickets/canned", listCannedReplies, adminOnly)
+	e.PUT("/admin/tickets/canned/:name", setCannedReply, adminOnly)
+	e.DELETE("/admin/tickets/canned/:name", deleteCannedReply, adminOnly)
+	e.GET("/admin/tickets/:id", getTicketHandler, adminOnly)
+	e.PUT("/admin/tickets/:id/assign", assignTicket, adminOnly)
+	e.PUT("/admin/tickets/:id/status", updateTicketStatus, adminOnly)
+	e.POST("/admin/tickets/:id/replies", replyToTicket, adminOnly)
+	registerOpenAPIRoutes(e)
+	return e
+}
+
+// loadRestaurants returns the restaurant list from the cache, falling back on
+// a miss to the store's restaurants merged with the restaurants registered
+// through the API.
+func loadRestaurants(ctx context.Context) ([]Restaurant, error) {
+	restaurantData, err := redisClient.Get(ctx, restaurantCacheKey).Result()
+	if err == redis.Nil {
+		restaurants, err := repositories.Restaurants.Restaurants(ctx)
+		if err != nil {
+			return nil, err
+		}
+		restaurants, err = mergeRegisteredRestaurants(ctx, restaurants)
+		if err != nil {
+			return nil, err
+		}
+
+		restaurantJSON, _ := json.Marshal(restaurants)
+		redisClient.Set(ctx, restaurantCacheKey, restaurantJSON, time.Hour)
+
+		slog.Debug("restaurants loaded from store")
+		return restaurants, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+
+	var cachedRestaurants []Restaurant
+	err = json.Unmarshal([]byte(restaurantData), &cachedRestaurants)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cached restaurants: %v", err)
+	}
+
+	slog.Debug("restaurants loaded from cache")
+	return cachedRestaurants, nil
+}
+
+type restaurantFile struct {
+	Restaurant []Restaurant `json:"restaurant"`
+}
+
+func (f *restaurantFile) stampModTime(modTime time.Time) {
+	for i := range f.Restaurant {
+		defaultTimestamps(&f.Restaurant[i].CreatedAt, &f.Restaurant[i].UpdatedAt, modTime)
+	}
+}
+
+func fetchRestaurantFromJSON(filePath string) ([]Restaurant, error) {
+	data, err := loadJSONFile[restaurantFile](filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error loading file: %w", err)
+	}
+
+	// Callers fill in per-request fields such as branding and cuisines, so
+	// hand out a copy rather than the cached slice.
+	return append([]Restaurant(nil), data.Restaurant...), nil
+}
+
+func findRestaurant(ctx context.Context, restaurantID string) (Restaurant, error) {
+	restaurants, err := loadRestaurants(ctx)
+	if err != nil {
+		return Restaurant{}, err
+	}
+
+	for _, restaurant := range restaurants {
+		if restaurant.ID == restaurantID {
+			return restaurant, nil
+		}
+	}
+	return Restaurant{}, errRestaurantNotFound
+}
+
+func getRider(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	logger := requestLogger(c)
+	logger.Debug("view rider called")
+	riderData, err := redisClient.Get(ctx, "rider").Result()
+	if err == redis.Nil {
+		riders, err := repositories.Riders.Riders(ctx)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch rider"})
+		}
+
+		riderJSON, _ := json.Marshal(riders)
+		redisClient.Set(ctx, "rider", riderJSON, time.Hour)
+
+		logger.Debug("view rider from store")
+
+		return c.JSON(http.StatusOK, map[string]interface{}{"rider": riders})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Redis error"})
+	}
+
+	logger.Debug("view rider from cache")
+	var cachedRiders []Rider
+	err = json.Unmarshal([]byte(riderData), &cachedRiders)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to parse cached rider"})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"rider": cachedRiders})
+}
+
+type riderFile struct {
+	Rider []Rider `json:"rider"`
+}
+
+func (f *riderFile) stampModTime(modTime time.Time) {
+	for i := range f.Rider {
+		defaultTimestamps(&f.Rider[i].CreatedAt, &f.Rider[i].UpdatedAt, modTime)
+	}
+}
+
+func fetchRidersFromJSON(filePath string) ([]Rider, error) {
+	data, err := loadJSONFile[riderFile](filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error loading file: %w", err)
+	}
+
+	return data.Rider, nil
+}
+
+func setContact(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	recipientType := c.Param("type")
+	if recipientType != "customer" && recipientType != "restaurant" && recipientType != "rider" && recipientType != "owner" {
+		return validationFailed(c, "type", "must be one of: customer restaurant rider owner")
+	}
+
+	var contact Contact
+	if err := bindAndValidate(c, &contact); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	key := contactKey(recipientType, c.Param("id"))
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx, key)
+	if contact.Email != "" {
+		pipe.HSet(ctx, key, "email", contact.Email)
+	}
+	if contact.WebhookURL != "" {
+		pipe.HSet(ctx, key, "webhook_url", contact.WebhookURL)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store contact"})
+	}
+
+	return c.JSON(http.StatusOK, contact)
+}
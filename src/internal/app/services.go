@@ -0,0 +1,642 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/errgroup"
+
+	"myproject/src/clock"
+	"myproject/src/handlers"
+	"myproject/src/model"
+)
+
+// The service layer: menus, the order lifecycle and notifications,
+// independent of the transport. The handlers package and the gRPC server
+// both call into it through the interfaces in handlers/services.go, so the
+// rules are the same whichever way a request arrives. Each service holds
+// the clients and configuration it runs on, which main builds it with.
+
+func serviceFailure(status int, message string) *serviceError {
+	return &serviceError{Status: status, Message: message}
+}
+
+func invalidField(field, message string) *serviceError {
+	return &serviceError{Status: http.StatusUnprocessableEntity, Field: field, Message: message}
+}
+
+// MenuService serves restaurants' menus.
+type MenuService struct {
+	redis     *redis.Client
+	analytics messageWriter
+	repos     Repositories
+}
+
+// NewMenuService returns the menu service on the given clients; analytics
+// receives the menu views.
+func NewMenuService(rdb *redis.Client, analytics messageWriter, repos Repositories) MenuService {
+	return MenuService{redis: rdb, analytics: analytics, repos: repos}
+}
+
+// Menu applies availability, pairings and images to every response rather
+// than caching them with the menu, since they change by the minute, and
+// localizes it to the caller.
+func (s MenuService) Menu(ctx context.Context, logger *slog.Logger, restaurantID string) (RestaurantMenu, error) {
+	getMenu := getMenuFromCache
+	if handlers.CacheBypassed(ctx) {
+		getMenu = getMenuUncached
+	}
+
+	menu, err := getMenu(ctx, restaurantID)
+	if errors.Is(err, errMenuCacheDown) {
+		// Menus stay up while Redis is down, read from the store as listed
+		// there: published prices, stock and cloned menus are in Redis too.
+		logger.Warn("menu cache unavailable, serving stored menu", "error", err)
+		menuCacheRequests.WithLabelValues("fallback").Inc()
+		menu, err = s.repos.Menus.Menu(ctx, restaurantID)
+		if err == nil {
+			localizeMenu(&menu, handlers.Locales(ctx))
+			emitAnalytics(s.analytics, AnalyticsEvent{Type: analyticsMenuViewed, RestaurantID: restaurantID})
+			return menu, nil
+		}
+	}
+	if err == errMenuNotFound {
+		return menu, serviceFailure(http.StatusNotFound, "Restaurant not found")
+	} else if err != nil {
+		logger.Error("error fetching menu", "error", err)
+		return menu, serviceFailure(http.StatusInternalServerError, "Failed to fetch menu")
+	}
+
+	err = applyAvailability(ctx, s.redis, &menu)
+	if err == nil {
+		err = applyPairings(ctx, &menu)
+	}
+	if err == nil {
+		err = applyMenuImages(ctx, s.redis, &menu)
+	}
+	if err != nil {
+		logger.Error("error fetching menu availability", "error", err)
+		return menu, serviceFailure(http.StatusInternalServerError, "Failed to fetch menu")
+	}
+	localizeMenu(&menu, handlers.Locales(ctx))
+	emitAnalytics(s.analytics, AnalyticsEvent{Type: analyticsMenuViewed, RestaurantID: restaurantID})
+	return menu, nil
+}
+
+// orderChecks are the lookups an order needs before it can be priced. None
+// depends on another, so checkOrder makes them all at once.
+type orderChecks struct {
+	Block *BlockEntry
+	// Restaurant is nil for restaurants missing from the list, which have
+	// no hours and are treated as always open, as elsewhere.
+	Restaurant *Restaurant
+	Delivery   deliveryPrice
+	Menu       RestaurantMenu
+	Promo      *Promo
+
+	// Problems with the order itself, reported in the order above once every
+	// lookup is back.
+	MenuMissing bool
+	DeliveryErr *pricingError
+	PromoErr    *pricingError
+}
+
+// checkOrder runs order's lookups concurrently, giving up on them all after
+// OrderCheckTimeout. A lookup that fails outright fails the order.
+func (s OrderService) checkOrder(ctx context.Context, logger *slog.Logger, order Order) (*orderChecks, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.OrderCheckTimeout)
+	defer cancel()
+
+	checks := &orderChecks{}
+	var g errgroup.Group
+
+	if order.CustomerID != "" {
+		g.Go(func() error {
+			block, err := findCustomerBlock(ctx, s.redis, order.CustomerID, order.RestaurantID)
+			if err != nil {
+				return serviceFailure(http.StatusInternalServerError, "Failed to check customer status")
+			}
+			checks.Block = block
+			return nil
+		})
+	}
+
+	// The delivery quote needs the restaurant's location, so it follows the
+	// restaurant lookup rather than running alongside it.
+	g.Go(func() error {
+		restaurant, err := findRestaurant(ctx, order.RestaurantID)
+		if err == nil {
+			checks.Restaurant = &restaurant
+		} else if err != errRestaurantNotFound {
+			return serviceFailure(http.StatusInternalServerError, "Failed to fetch restaurant")
+		}
+
+		checks.Delivery, err = orderDeliveryFee(ctx, order, checks.Restaurant)
+		if !errors.As(err, &checks.DeliveryErr) && err != nil {
+			logger.Error("error pricing delivery", "restaurant_id", order.RestaurantID, "error", err)
+			return serviceFailure(http.StatusInternalServerError, "Failed to price order")
+		}
+		return nil
+	})
+
+	g.Go(func() error {
+		menu, err := getMenuFromCache(ctx, order.RestaurantID)
+		if err == errMenuNotFound {
+			checks.MenuMissing = true
+			return nil
+		} else if err != nil {
+			return serviceFailure(http.StatusInternalServerError, "Failed to fetch restaurant menu")
+		}
+		checks.Menu = menu
+		return nil
+	})
+
+	g.Go(func() error {
+		promo, err := lookupOrderPromo(ctx, order)
+		if !errors.As(err, &checks.PromoErr) && err != nil {
+			logger.Error("error fetching promo", "promo_code", order.PromoCode, "error", err)
+			return serviceFailure(http.StatusInternalServerError, "Failed to price order")
+		}
+		checks.Promo = promo
+		return nil
+	})
+
+	// Lookups left running past the deadline finish in the background and
+	// their results are dropped.
+	done := make(chan error, 1)
+	go func() { done <- g.Wait() }()
+	select {
+	case err := <-done:
+		return checks, err
+	case <-ctx.Done():
+		logger.Warn("order checks timed out", "restaurant_id", order.RestaurantID, "timeout", s.cfg.OrderCheckTimeout)
+		return nil, serviceFailure(http.StatusGatewayTimeout, "Timed out checking order")
+	}
+}
+
+// OrderService places orders and moves them through their lifecycle.
+type OrderService struct {
+	redis     *redis.Client
+	analytics messageWriter
+	repos     Repositories
+	cfg       Config
+}
+
+// NewOrderService returns the order service on the given clients and
+// configuration; analytics receives the orders placed and delivered.
+func NewOrderService(rdb *redis.Client, analytics messageWriter, repos Repositories, cfg Config) OrderService {
+	return OrderService{redis: rdb, analytics: analytics, repos: repos, cfg: cfg}
+}
+
+// PlaceOrder prices, reserves and creates order for the calling customer.
+// Stock and promo uses taken along the way are given back if a later step
+// fails.
+func (s OrderService) PlaceOrder(ctx context.Context, logger *slog.Logger, claims *AuthClaims, order Order) (Order, error) {
+	return s.placeOrderAs(ctx, logger, claims, "", order)
+}
+
+// placeOrderAs is PlaceOrder creating the order as orderID, or under a fresh
+// ID if that is empty. If orderID is taken the order is not placed.
+func (s OrderService) placeOrderAs(ctx context.Context, logger *slog.Logger, claims *AuthClaims, orderID string, order Order) (Order, error) {
+	p, err := s.prepareOrder(ctx, logger, claims, order)
+	if err != nil {
+		return p.Order, err
+	}
+	p.Order.OrderID = orderID
+	err = s.reserveOrder(ctx, logger, p)
+	if err != nil {
+		return p.Order, err
+	}
+	return s.placeOrder(ctx, logger, p, true)
+}
+
+// preparedOrder is an order checked and priced, with the promo it redeems.
+type preparedOrder struct {
+	Order Order
+	Promo *Promo
+}
+
+// prepareOrder checks order for the calling customer and prices it, taking
+// nothing yet.
+func (s OrderService) prepareOrder(ctx context.Context, logger *slog.Logger, claims *AuthClaims, order Order) (preparedOrder, error) {
+	p := preparedOrder{Order: order}
+	order.OrderID, order.CustomerID = "", claims.Subject
+	order.LateTip, order.TipPaymentID = 0, ""
+	if order.Gift != nil {
+		message, ok := cleanText(claims, "gift_message", order.Gift.Message)
+		if !ok {
+			return p, invalidField("gift.message", textRejectedMessage)
+		}
+		order.Gift.Message = message
+	}
+	p.Order = order
+
+	checks, err := s.checkOrder(ctx, logger, order)
+	if err != nil {
+		return p, err
+	}
+
+	if block := checks.Block; block != nil {
+		return p, &serviceError{
+			Status:  http.StatusForbidden,
+			Message: "Customer is not allowed to place orders",
+			Details: map[string]interface{}{
+				"reason":         block.Reason,
+				"appeal_contact": s.cfg.AppealContact,
+			},
+		}
+	}
+
+	if order.ScheduledAt != nil {
+		err := checkSchedule(order, checks.Restaurant, clock.Now())
+		if err != nil {
+			return p, err
+		}
+	} else if restaurant := checks.Restaurant; restaurant != nil && !restaurantOpenAt(*restaurant, clock.Now()) {
+		return p, &serviceError{
+			Status:  http.StatusConflict,
+			Message: "Restaurant is closed",
+			Details: map[string]interface{}{"opening_hours": restaurant.OpeningHours},
+		}
+	}
+
+	due := clock.Now()
+	if order.ScheduledAt != nil {
+		due = order.ScheduledAt.Time
+	}
+	closed, err := dayClosedOut(ctx, s.redis, order.RestaurantID, due)
+	if err != nil {
+		logger.Error("error checking closeout", "restaurant_id", order.RestaurantID, "error", err)
+		return p, serviceFailure(http.StatusInternalServerError, "Failed to check restaurant closeout")
+	} else if closed {
+		return p, serviceFailure(http.StatusConflict, "Restaurant has closed out the day")
+	}
+
+	if checks.MenuMissing {
+		return p, serviceFailure(http.StatusNotFound, "Restaurant not found")
+	}
+	menu := checks.Menu
+
+	for _, pe := range []*pricingError{checks.DeliveryErr, checks.PromoErr} {
+		if pe != nil {
+			return p, invalidField(pe.Field, pe.Message)
+		}
+	}
+
+	pricing, err := priceOrder(order, menu, checks.Delivery, checks.Promo)
+	var pe *pricingError
+	if errors.As(err, &pe) {
+		return p, invalidField(pe.Field, pe.Message)
+	} else if err != nil {
+		logger.Error("error pricing order", "restaurant_id", order.RestaurantID, "error", err)
+		return p, serviceFailure(http.StatusInternalServerError, "Failed to price order")
+	}
+
+	order.Pricing = &pricing
+	order.PromoCode = pricing.PromoCode
+	order.TotalAmount = pricing.Total
+	order.Currency = pricing.Currency
+
+	split, err := orderFeeSplit(ctx, order.RestaurantID, checks.Restaurant, checks.Delivery)
+	if err != nil {
+		logger.Error("error splitting delivery fee", "restaurant_id", order.RestaurantID, "error", err)
+		return p, serviceFailure(http.StatusInternalServerError, "Failed to price order")
+	}
+	order.FeeSplit = &split
+
+	checklist := buildPickupChecklist(order, menu)
+	order.PickupChecklist = &checklist
+	order.PickupConfirmation = nil
+
+	return preparedOrder{Order: order, Promo: checks.Promo}, nil
+}
+
+// reserveOrder takes the stock and promo use a prepared order needs, all or
+// nothing.
+func (s OrderService) reserveOrder(ctx context.Context, logger *slog.Logger, p preparedOrder) error {
+	order := p.Order
+	err := reserveOrderStock(ctx, s.redis, order)
+	var se *stockError
+	if errors.As(err, &se) {
+		return &serviceError{
+			Status:  http.StatusConflict,
+			Message: "Item cannot be ordered",
+			Details: map[string]interface{}{
+				"detail":    se.Error(),
+				"menu_id":   se.MenuID,
+				"remaining": se.Remaining,
+			},
+		}
+	} else if err != nil {
+		return serviceFailure(http.StatusInternalServerError, "Failed to reserve items")
+	}
+
+	if p.Promo != nil {
+		err = redeemPromo(ctx, s.redis, *p.Promo)
+		if err != nil {
+			if err := releaseOrderStock(ctx, s.redis, order); err != nil {
+				logger.Error("error releasing reserved stock", "restaurant_id", order.RestaurantID, "error", err)
+			}
+			if err == errPromoExhausted {
+				return invalidField("promo_code", "has been used up")
+			}
+			return serviceFailure(http.StatusInternalServerError, "Failed to redeem promo code")
+		}
+	}
+	return nil
+}
+
+// releaseOrder gives back what reserveOrder took.
+func (s OrderService) releaseOrder(ctx context.Context, logger *slog.Logger, p preparedOrder) {
+	if err := releaseOrderStock(ctx, s.redis, p.Order); err != nil {
+		logger.Error("error releasing reserved stock", "restaurant_id", p.Order.RestaurantID, "error", err)
+	}
+	if p.Promo != nil {
+		if err := releasePromo(ctx, s.redis, p.Promo.Code); err != nil {
+			logger.Error("error releasing promo use", "promo_code", p.Promo.Code, "error", err)
+		}
+	}
+}
+
+// placeOrder creates a reserved order, giving back its reservations if it
+// cannot be stored. Unless wake is set, the outbox relay is left for the
+// caller to wake.
+func (s OrderService) placeOrder(ctx context.Context, logger *slog.Logger, p preparedOrder, wake bool) (Order, error) {
+	order := p.Order
+	order.Status = "created"
+	order.PaymentStatus = paymentPending
+	order.PaymentID = ""
+	order.Refunds, order.RefundedAmount = nil, 0
+	order.Timeline = []TimelineEvent{{Event: timelineCreated, At: timestampNow()}}
+
+	err := insertOrder(ctx, s.redis, s.repos.Orders, &order)
+	if err != nil {
+		logger.Error("error creating order", "restaurant_id", order.RestaurantID, "error", err)
+		s.releaseOrder(ctx, logger, p)
+		return order, serviceFailure(http.StatusInternalServerError, "Failed to create order")
+	}
+	if wake {
+		wakeOutboxRelay()
+	}
+
+	recordDailyStat(ctx, s.redis, statOrdersCreated)
+	emitAnalytics(s.analytics, AnalyticsEvent{Type: analyticsOrderPlaced, RestaurantID: order.RestaurantID, OrderID: order.OrderID})
+	scheduleOrderExpiry(ctx, s.redis, order)
+
+	logger = logger.With("order_id", order.OrderID, "restaurant_id", order.RestaurantID)
+	if err := saveGiftContact(ctx, s.redis, order); err != nil {
+		logger.Error("error saving gift recipient contact", "error", err)
+	}
+	logger.Info("order created", "items", order.Items, "total_amount", order.TotalAmount)
+	logger.Info("order placed")
+	return order, nil
+}
+
+// fetchOrder loads an order, reporting a missing one as not found.
+func fetchOrder(ctx context.Context, orders OrderRepository, orderID string) (Order, error) {
+	order, err := orders.Get(ctx, orderID)
+	if err == errOrderNotFound {
+		return order, serviceFailure(http.StatusNotFound, "Order not found")
+	} else if err != nil {
+		return order, serviceFailure(http.StatusInternalServerError, "Failed to fetch order")
+	}
+	return order, nil
+}
+
+// moveOrder transitions order to status, reporting an order in the wrong
+// status as a conflict described by verb.
+func (s OrderService) moveOrder(ctx context.Context, order *Order, status, from, verb string) error {
+	err := transitionOrder(ctx, s.repos.Orders, order, status, from)
+	if err == errInvalidTransition {
+		return serviceFailure(http.StatusConflict, "Order cannot be "+verb+" in status "+order.Status)
+	} else if err == errOrderChanged {
+		return orderChangedFailure(ctx, order.OrderID)
+	} else if err != nil {
+		return serviceFailure(http.StatusInternalServerError, "Failed to update order")
+	}
+	return nil
+}
+
+// AcceptOrder is the restaurant accepting one of its paid orders.
+func (s OrderService) AcceptOrder(ctx context.Context, logger *slog.Logger, claims *AuthClaims, req AcceptOrderRequest) (Order, error) {
+	if !staffFor(ctx, claims, roleRestaurant, req.RestaurantID) {
+		return Order{}, serviceFailure(http.StatusForbidden, "Token is not valid for this restaurant")
+	}
+
+	order, err := fetchOrder(ctx, s.repos.Orders, req.OrderID)
+	if err != nil {
+		return order, err
+	}
+
+	if order.RestaurantID != req.RestaurantID {
+		return order, serviceFailure(http.StatusForbidden, "Order belongs to a different restaurant")
+	}
+	if err := checkOrderVersion(ctx, order); err != nil {
+		return order, err
+	}
+
+	if order.PaymentStatus != paymentPaid {
+		return order, serviceFailure(http.StatusConflict, "Order has not been paid")
+	}
+
+	readyBy, err := commitReadyBy(order, req)
+	if err != nil {
+		return order, err
+	}
+
+	logger.Info("accepting order", "order_id", req.OrderID, "restaurant_id", req.RestaurantID, "ready_by", readyBy)
+
+	if order.Status == "created" {
+		err = scheduleReadyCountdown(ctx, s.redis, order.OrderID, readyBy)
+		if err != nil {
+			logger.Error("error scheduling ready countdown", "order_id", order.OrderID, "error", err)
+			return order, serviceFailure(http.StatusInternalServerError, "Failed to update order")
+		}
+	}
+	order.ReadyBy = &Timestamp{Time: readyBy}
+	err = s.moveOrder(ctx, &order, "accepted", "created", "accepted")
+	return order, err
+}
+
+// RejectOrder is the restaurant turning down one of its new orders.
+func (s OrderService) RejectOrder(ctx context.Context, logger *slog.Logger, claims *AuthClaims, req RejectOrderRequest) (Order, error) {
+	if !staffFor(ctx, claims, roleRestaurant, req.RestaurantID) {
+		return Order{}, serviceFailure(http.StatusForbidden, "Token is not valid for this restaurant")
+	}
+
+	order, err := fetchOrder(ctx, s.repos.Orders, req.OrderID)
+	if err != nil {
+		return order, err
+	}
+
+	if order.RestaurantID != req.RestaurantID {
+		return order, serviceFailure(http.StatusForbidden, "Order belongs to a different restaurant")
+	}
+	if err := checkOrderVersion(ctx, order); err != nil {
+		return order, err
+	}
+
+	reason, ok := cleanText(claims, "reason", req.Reason)
+	if !ok {
+		return order, invalidField("reason", textRejectedMessage)
+	}
+
+	logger.Info("rejecting order", "order_id", req.OrderID, "restaurant_id", req.RestaurantID, "reason", reason)
+
+	order.StatusReason = reason
+	err = s.moveOrder(ctx, &order, "rejected", "created", "rejected")
+	return order, err
+}
+
+// ConfirmPickup is the rider collecting an accepted order, after checking
+// the order code and the pickup checklist.
+func (s OrderService) ConfirmPickup(ctx context.Context, logger *slog.Logger, claims *AuthClaims, req PickupRequest) (Order, error) {
+	if !claims.ActsForRider(req.RiderID) {
+		return Order{}, serviceFailure(http.StatusForbidden, "Token is not valid for this rider")
+	}
+
+	order, err := fetchOrder(ctx, s.repos.Orders, req.OrderID)
+	if err != nil {
+		return order, err
+	}
+
+	// The restaurant reads the code off the order and the rider repeats it,
+	// so a mismatch means the rider is about to take someone else's food.
+	if req.OrderCode != "" && normalizeOrderCode(req.OrderCode) != order.Code {
+		return order, invalidField("order_code", "does not match this order")
+	}
+
+	// Orders taken through dispatch belong to that rider; unassigned ones
+	// can still be picked up by whoever arrives.
+	if order.RiderID != "" && order.RiderID != req.RiderID {
+		return order, serviceFailure(http.StatusForbidden, "Order is assigned to a different rider")
+	}
+	if err := checkOrderVersion(ctx, order); err != nil {
+		return order, err
+	}
+
+	if order.PickupChecklist != nil {
+		if req.Checklist == nil {
+			return order, invalidField("checklist", "is required for this order")
+		}
+		if mismatches := checklistMismatches(*order.PickupChecklist, *req.Checklist); len(mismatches) > 0 {
+			return order, &serviceError{
+				Status:  http.StatusConflict,
+				Message: "Pickup checklist does not match the order",
+				Details: map[string]interface{}{
+					"mismatches": mismatches,
+					"checklist":  order.PickupChecklist,
+				},
+			}
+		}
+		order.PickupConfirmation = &PickupConfirmation{
+			ChecklistConfirmation: *req.Checklist,
+			RiderID:               req.RiderID,
+			ConfirmedAt:           timestampNow(),
+		}
+	}
+
+	logger.Info("rider confirmed pickup", "order_id", req.OrderID, "rider_id", req.RiderID)
+
+	order.RiderID = req.RiderID
+	err = s.moveOrder(ctx, &order, "picked_up", "accepted", "picked up")
+	return order, err
+}
+
+// ConfirmDelivery is the rider handing a picked-up order over.
+func (s OrderService) ConfirmDelivery(ctx context.Context, logger *slog.Logger, claims *AuthClaims, req DeliverRequest) (Order, error) {
+	if !claims.ActsForRider(req.RiderID) {
+		return Order{}, serviceFailure(http.StatusForbidden, "Token is not valid for this rider")
+	}
+
+	order, err := fetchOrder(ctx, s.repos.Orders, req.OrderID)
+	if err != nil {
+		return order, err
+	}
+	if order.RiderID != req.RiderID {
+		return order, serviceFailure(http.StatusForbidden, "Order is assigned to a different rider")
+	}
+	if err := checkOrderVersion(ctx, order); err != nil {
+		return order, err
+	}
+
+	// Contactless drops have nobody to sign, so only hand-to-hand deliveries
+	// require a customer signature.
+	if !order.DeliveryOptions.Contactless && req.SignatureHash == "" {
+		return order, invalidField("signature_hash", "is required for non-contactless delivery")
+	}
+
+	proof, err := deliveryProof(ctx, order, req)
+	if err != nil {
+		return order, err
+	}
+	order.DeliveryProof = proof
+
+	logger.Info("rider delivering order", "order_id", req.OrderID, "rider_id", req.RiderID, "photo_ref", req.PhotoRef)
+
+	err = s.moveOrder(ctx, &order, "delivered", "picked_up", "delivered")
+	if err != nil {
+		return order, err
+	}
+
+	deliveryTime := order.DeliveryTime(clock.Now())
+	if deliveryTime > s.cfg.DeliverySLA {
+		recordDailyStat(ctx, s.redis, statSLABreaches)
+	}
+	emitAnalytics(s.analytics, AnalyticsEvent{
+		Type:            analyticsDeliveryCompleted,
+		RestaurantID:    order.RestaurantID,
+		OrderID:         order.OrderID,
+		DurationSeconds: deliveryTime.Seconds(),
+	})
+	return order, nil
+}
+
+// NotificationService sends notifications about orders.
+type NotificationService struct {
+	redis *redis.Client
+	repos Repositories
+}
+
+// NewNotificationService returns the notification service on the given
+// clients.
+func NewNotificationService(rdb *redis.Client, repos Repositories) NotificationService {
+	return NotificationService{redis: rdb, repos: repos}
+}
+
+// NotifyOrder sends req's message to the order's customer, restaurant or
+// rider through their configured channels.
+func (s NotificationService) NotifyOrder(ctx context.Context, logger *slog.Logger, req SendNotificationRequest) (model.NotificationReceipt, error) {
+	order, err := fetchOrder(ctx, s.repos.Orders, req.OrderID)
+	if err != nil {
+		return model.NotificationReceipt{}, err
+	}
+
+	recipientID := resolveRecipientID(order, req.Recipient)
+	if recipientID == "" {
+		return model.NotificationReceipt{}, invalidField("recipient", "order has no "+req.Recipient+" to notify")
+	}
+
+	logger = logger.With("recipient", req.Recipient, "recipient_id", recipientID, "order_id", req.OrderID)
+	logger.Info("sending notification", "message", req.Message)
+
+	record, err := dispatchNotification(ctx, s.redis, Notification{
+		RecipientType: req.Recipient,
+		RecipientID:   recipientID,
+		OrderID:       req.OrderID,
+		OrderCode:     order.Code,
+		Template:      "order_update",
+		Vars: map[string]string{
+			"order_ref": orderReference(order.OrderID, order.Code),
+			"message":   req.Message,
+		},
+	})
+	if err != nil {
+		logger.Error("error dispatching notification", "error", err)
+		return model.NotificationReceipt{}, serviceFailure(http.StatusInternalServerError, "Failed to send notification")
+	}
+	return model.NotificationReceipt{ID: record.ID, Status: record.Status, Channels: record.Channels}, nil
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"google.golang.org/grpc"
+)
+
+// shutdown stops the service in dependency order: the HTTP and gRPC servers
+// first so no new events are produced, then the consumers this process
+// runs, if any, waiting for the events they are handling, and finally the
+// Kafka writers so any buffered messages are flushed. The whole sequence
+// shares one deadline.
+func shutdown(e *echo.Echo, grpcServer *grpc.Server, consumers []*Consumer, timeout time.Duration) {
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	slog.Info("shutting down, waiting for in-flight work", "timeout", timeout)
+
+	err := e.Shutdown(shutdownCtx)
+	if err != nil {
+		slog.Error("error shutting down http server", "error", err)
+	}
+	if grpcServer != nil {
+		stopGRPC(shutdownCtx, grpcServer)
+	}
+
+	for _, consumer := range consumers {
+		if err := consumer.Stop(shutdownCtx); err != nil {
+			slog.Warn("timed out waiting for consumer to stop", "group", consumer.groupID)
+		}
+	}
+
+	closeWriters(shutdownCtx)
+	shutdownTracing(shutdownCtx)
+
+	err = redisClient.Close()
+	if err != nil {
+		slog.Error("error closing redis client", "error", err)
+	}
+
+	slog.Info("shutdown complete")
+}
+
+// closeWriters flushes and closes the Kafka writers setup opened.
+func closeWriters(ctx context.Context) {
+	orderEventRouter.close(ctx)
+	closeKafkaWriter(ctx, regionTopic("order-delivered"), kafkaNotiWriter)
+	closeKafkaWriter(ctx, regionTopic(dlqTopic), kafkaDLQWriter)
+	closeKafkaWriter(ctx, regionTopic(opsIncidentTopic), opsIncidentWriter)
+	closeKafkaWriter(ctx, regionTopic(riderPayoutsTopic), riderPayoutWriter)
+	closeKafkaWriter(ctx, regionTopic(closeoutsTopic), closeoutWriter)
+	analyticsProducer.close(ctx)
+	closeKafkaWriter(ctx, regionTopic(analyticsTopic), analyticsWriter)
+}
+
+func closeKafkaWriter(ctx context.Context, name string, w messageWriter) {
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Close()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			slog.Error("error closing kafka writer", "writer", name, "error", err)
+		}
+	case <-ctx.Done():
+		slog.Warn("timed out flushing kafka writer", "writer", name)
+	}
+}
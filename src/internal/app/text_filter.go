@@ -0,0 +1,146 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Free-text filtering. Text one party writes for another to read, such as a
+// rejection reason or an announcement, is run through the TextFilters named
+// in appConfig.TextFilters before it is stored or sent. In "mask" mode what
+// they find is blanked out and the rest kept; in "reject" mode the request
+// is refused. Roles in TextFilterBypassRoles are trusted to write what they
+// mean.
+
+const (
+	textFilterOff    = "off"
+	textFilterMask   = "mask"
+	textFilterReject = "reject"
+
+	textRejectedMessage = "must not contain offensive language or contact details"
+)
+
+// defaultProfanity is used when PROFANITY_WORDS is not set.
+var defaultProfanity = []string{"fuck", "fucking", "shit", "bitch", "bastard", "asshole", "cunt", "dick", "wanker"}
+
+var textFilterMatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "text_filter_matches_total",
+	Help: "Free text a filter changed, by filter and field.",
+}, []string{"filter", "field"})
+
+func init() {
+	prometheus.MustRegister(textFilterMatches)
+}
+
+// TextFilter finds what should not be shown in free text. Filter returns the
+// text with it masked and whether there was any.
+type TextFilter interface {
+	Name() string
+	Filter(text string) (string, bool)
+}
+
+var textFilters = map[string]TextFilter{}
+
+func registerTextFilter(f TextFilter) {
+	textFilters[f.Name()] = f
+}
+
+// profanityFilter masks listed words, matched whole and ignoring case.
+type profanityFilter struct {
+	pattern *regexp.Regexp
+}
+
+func newProfanityFilter(words []string) profanityFilter {
+	if len(words) == 0 {
+		return profanityFilter{}
+	}
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = regexp.QuoteMeta(word)
+	}
+	return profanityFilter{pattern: regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)}
+}
+
+func (profanityFilter) Name() string { return "profanity" }
+
+func (f profanityFilter) Filter(text string) (string, bool) {
+	if f.pattern == nil || !f.pattern.MatchString(text) {
+		return text, false
+	}
+	return f.pattern.ReplaceAllStringFunc(text, func(word string) string {
+		return strings.Repeat("*", len([]rune(word)))
+	}), true
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// phonePattern catches phone and card numbers: nine or more digits,
+	// possibly grouped by spaces, dots or dashes.
+	phonePattern = regexp.MustCompile(`\+?\d(?:[ .-]?\d){8,}`)
+)
+
+// piiFilter masks email addresses and phone numbers, so parties reach each
+// other through the platform.
+type piiFilter struct{}
+
+func (piiFilter) Name() string { return "pii" }
+
+func (piiFilter) Filter(text string) (string, bool) {
+	found := false
+	for _, p := range []struct {
+		pattern *regexp.Regexp
+		mask    string
+	}{{emailPattern, "[email removed]"}, {phonePattern, "[number removed]"}} {
+		if p.pattern.MatchString(text) {
+			text = p.pattern.ReplaceAllString(text, p.mask)
+			found = true
+		}
+	}
+	return text, found
+}
+
+func validateTextFilters() error {
+	switch appConfig.TextFilterMode {
+	case textFilterOff, textFilterMask, textFilterReject:
+	default:
+		return fmt.Errorf("unknown text filter mode %q", appConfig.TextFilterMode)
+	}
+	for _, name := range appConfig.TextFilters {
+		if _, ok := textFilters[name]; !ok {
+			return fmt.Errorf("unknown text filter %q", name)
+		}
+	}
+	return nil
+}
+
+// cleanText runs text, written by claims for field, through the configured
+// filters. It returns the text to keep, or false if the request is to be
+// refused with textRejectedMessage.
+func cleanText(claims *AuthClaims, field, text string) (string, bool) {
+	if text == "" || appConfig.TextFilterMode == textFilterOff {
+		return text, true
+	}
+	if claims != nil {
+		for _, role := range appConfig.TextFilterBypassRoles {
+			if claims.Role == role {
+				return text, true
+			}
+		}
+	}
+
+	for _, name := range appConfig.TextFilters {
+		filtered, found := textFilters[name].Filter(text)
+		if !found {
+			continue
+		}
+		textFilterMatches.WithLabelValues(name, field).Inc()
+		if appConfig.TextFilterMode == textFilterReject {
+			return text, false
+		}
+		text = filtered
+	}
+	return text, true
+}
@@ -0,0 +1,472 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+const (
+	ticketSourceOrderIssue    = "order_issue"
+	ticketSourceRefundRequest = "refund_request"
+	// ticketSourceDeliveryEscalation is an accepted order no rider took in
+	// time; see order_expiry.go.
+	ticketSourceDeliveryEscalation = "delivery_escalation"
+	// ticketSourceCompensationFailed is a saga that could not undo all of
+	// an order's failure; see saga.go.
+	ticketSourceCompensationFailed = "compensation_failed"
+
+	ticketStatusOpen     = "open"
+	ticketStatusAssigned = "assigned"
+	ticketStatusResolved = "resolved"
+	ticketStatusClosed   = "closed"
+
+	ticketIndexKey   = "tickets"
+	cannedRepliesKey = "support:canned"
+)
+
+var errTicketNotFound = errors.New("ticket not found")
+
+type TicketReply struct {
+	AgentID string    `json:"agent_id"`
+	Message string    `json:"message"`
+	At      time.Time `json:"at"`
+}
+
+// Ticket is a support case raised from an order. The two SLA deadlines are
+// fixed at creation; the breach flags are computed whenever a ticket is read.
+type Ticket struct {
+	ID          string        `json:"id"`
+	Source      string        `json:"source"`
+	OrderID     string        `json:"order_id"`
+	CustomerID  string        `json:"customer_id"`
+	IssueID     string        `json:"issue_id,omitempty"`
+	Subject     string        `json:"subject"`
+	Description string        `json:"description"`
+	Amount      float64       `json:"amount"`
+	Status      string        `json:"status"`
+	AssigneeID  string        `json:"assignee_id,omitempty"`
+	Replies     []TicketReply `json:"replies"`
+
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	FirstResponseDue time.Time  `json:"first_response_due"`
+	ResolutionDue    time.Time  `json:"resolution_due"`
+	FirstRespondedAt *time.Time `json:"first_responded_at,omitempty"`
+	ResolvedAt       *time.Time `json:"resolved_at,omitempty"`
+
+	FirstResponseBreached bool `json:"first_response_breached"`
+	ResolutionBreached    bool `json:"resolution_breached"`
+}
+
+type RefundRequest struct {
+	Amount float64 `json:"amount" validate:"gt=0"`
+	Reason string  `json:"reason" validate:"required,max=1000"`
+}
+
+type AssignTicketRequest struct {
+	AgentID string `json:"agent_id" validate:"required"`
+}
+
+type TicketStatusRequest struct {
+	Status string `json:"status" validate:"required,oneof=open assigned resolved closed"`
+}
+
+type TicketReplyRequest struct {
+	Message string `json:"message" validate:"required_without=Canned,max=2000"`
+	Canned  string `json:"canned"`
+	Resolve bool   `json:"resolve"`
+}
+
+type CannedReply struct {
+	Name string `json:"name"`
+	Text string `json:"text" validate:"required,max=2000"`
+}
+
+func ticketKey(ticketID string) string {
+	return "ticket:" + ticketID
+}
+
+func newTicket(source string, order Order, subject, description string, amount float64) (Ticket, error) {
+	id, err := idGenerator.NewID()
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	now := clock.Now().UTC()
+	return Ticket{
+		ID:               id,
+		Source:           source,
+		OrderID:          order.OrderID,
+		CustomerID:       order.CustomerID,
+		Subject:          subject,
+		Description:      description,
+		Amount:           amount,
+		Status:           ticketStatusOpen,
+		Replies:          []TicketReply{},
+		CreatedAt:        now,
+		UpdatedAt:        now,
+		FirstResponseDue: now.Add(appConfig.TicketFirstResponseSLA),
+		ResolutionDue:    now.Add(appConfig.TicketResolutionSLA),
+	}, nil
+}
+
+// queueTicket adds the ticket writes to pipe so callers can store a ticket
+// atomically with whatever raised it.
+func queueTicket(ctx context.Context, pipe redis.Pipeliner, ticket Ticket) {
+	ticketJSON, _ := json.Marshal(ticket)
+	pipe.Set(ctx, ticketKey(ticket.ID), ticketJSON, 0)
+	pipe.ZAdd(ctx, ticketIndexKey, &redis.Z{Score: float64(ticket.CreatedAt.Unix()), Member: ticket.ID})
+}
+
+func getTicket(ctx context.Context, ticketID string) (Ticket, error) {
+	data, err := redisClient.Get(ctx, ticketKey(ticketID)).Result()
+	if err == redis.Nil {
+		return Ticket{}, errTicketNotFound
+	} else if err != nil {
+		return Ticket{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	var ticket Ticket
+	err = json.Unmarshal([]byte(data), &ticket)
+	if err != nil {
+		return Ticket{}, fmt.Errorf("failed to parse ticket: %v", err)
+	}
+	ticket.refreshSLA(clock.Now())
+	return ticket, nil
+}
+
+func saveTicket(ctx context.Context, ticket *Ticket) error {
+	ticket.UpdatedAt = clock.Now().UTC()
+	ticketJSON, err := json.Marshal(ticket)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ticket: %v", err)
+	}
+	err = redisClient.Set(ctx, ticketKey(ticket.ID), ticketJSON, 0).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	ticket.refreshSLA(clock.Now())
+	return nil
+}
+
+// refreshSLA flags a deadline as breached if it passed before the ticket
+// reached that milestone, or has passed with the milestone still pending.
+func (t *Ticket) refreshSLA(now time.Time) {
+	responded := now
+	if t.FirstRespondedAt != nil {
+		responded = *t.FirstRespondedAt
+	}
+	t.FirstResponseBreached = responded.After(t.FirstResponseDue)
+
+	resolved := now
+	if t.ResolvedAt != nil {
+		resolved = *t.ResolvedAt
+	}
+	t.ResolutionBreached = resolved.After(t.ResolutionDue)
+}
+
+// requestRefund lets a customer ask for money back on an order. Refunds are
+// always reviewed by an agent, so the request becomes a ticket.
+func requestRefund(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	order, err := getOrder(ctx, c.Param("id"))
+	if err == errOrderNotFound || (err == nil && order.CustomerID != authClaims(c).Subject) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+
+	var req RefundRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+	if req.Amount > order.TotalAmount {
+		return validationFailed(c, "amount", fmt.Sprintf("must be at most the order total of %.2f", order.TotalAmount))
+	}
+
+	ticket, err := newTicket(ticketSourceRefundRequest, order, "Refund request for order "+order.OrderID, req.Reason, req.Amount)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create ticket"})
+	}
+
+	pipe := redisClient.TxPipeline()
+	queueTicket(ctx, pipe, ticket)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		requestLogger(c).Error("error storing refund ticket", "order_id", order.OrderID, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create ticket"})
+	}
+
+	requestLogger(c).Info("refund requested", "order_id", order.OrderID, "ticket_id", ticket.ID, "amount", req.Amount)
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"ticket_id": ticket.ID,
+		"status":    ticket.Status,
+	})
+}
+
+// listTickets serves GET /admin/tickets, newest first, with optional status,
+// assignee and breached=true filters.
+func listTickets(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	ids, err := redisClient.ZRevRange(ctx, ticketIndexKey, 0, -1).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch tickets"})
+	}
+
+	status := c.QueryParam("status")
+	assignee := c.QueryParam("assignee")
+	breachedOnly := c.QueryParam("breached") == "true"
+
+	tickets := make([]Ticket, 0, len(ids))
+	for _, id := range ids {
+		ticket, err := getTicket(ctx, id)
+		if err != nil {
+			continue
+		}
+		if status != "" && ticket.Status != status {
+			continue
+		}
+		if assignee != "" && ticket.AssigneeID != assignee {
+			continue
+		}
+		if breachedOnly && !ticket.FirstResponseBreached && !ticket.ResolutionBreached {
+			continue
+		}
+		tickets = append(tickets, ticket)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"count":   len(tickets),
+		"tickets": tickets,
+	})
+}
+
+func getTicketHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	ticket, err := getTicket(ctx, c.Param("id"))
+	if err == errTicketNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Ticket not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch ticket"})
+	}
+	return c.JSON(http.StatusOK, ticket)
+}
+
+func assignTicket(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req AssignTicketRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	ticket, err := getTicket(ctx, c.Param("id"))
+	if err == errTicketNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Ticket not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch ticket"})
+	}
+	if ticket.Status == ticketStatusClosed {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Ticket is closed"})
+	}
+
+	ticket.AssigneeID = req.AgentID
+	if ticket.Status == ticketStatusOpen {
+		ticket.Status = ticketStatusAssigned
+	}
+	err = saveTicket(ctx, &ticket)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update ticket"})
+	}
+
+	requestLogger(c).Info("ticket assigned", "ticket_id", ticket.ID, "agent_id", req.AgentID)
+	return c.JSON(http.StatusOK, ticket)
+}
+
+func updateTicketStatus(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req TicketStatusRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	ticket, err := getTicket(ctx, c.Param("id"))
+	if err == errTicketNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Ticket not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch ticket"})
+	}
+	if req.Status == ticketStatusAssigned && ticket.AssigneeID == "" {
+		return validationFailed(c, "status", "ticket has no assignee")
+	}
+
+	setTicketStatus(&ticket, req.Status)
+	err = saveTicket(ctx, &ticket)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update ticket"})
+	}
+
+	requestLogger(c).Info("ticket status updated", "ticket_id", ticket.ID, "status", ticket.Status)
+	return c.JSON(http.StatusOK, ticket)
+}
+
+// setTicketStatus keeps ResolvedAt in step with the status: resolving or
+// closing stamps it once, reopening clears it.
+func setTicketStatus(ticket *Ticket, status string) {
+	ticket.Status = status
+	switch status {
+	case ticketStatusResolved, ticketStatusClosed:
+		if ticket.ResolvedAt == nil {
+			now := clock.Now().UTC()
+			ticket.ResolvedAt = &now
+		}
+	default:
+		ticket.ResolvedAt = nil
+	}
+}
+
+// replyToTicket records an agent reply, either free text or a canned reply
+// by name, and forwards it to the customer.
+func replyToTicket(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req TicketReplyRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	ticket, err := getTicket(ctx, c.Param("id"))
+	if err == errTicketNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Ticket not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch ticket"})
+	}
+	if ticket.Status == ticketStatusClosed {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Ticket is closed"})
+	}
+
+	message := req.Message
+	if message == "" {
+		text, err := redisClient.HGet(ctx, cannedRepliesKey, req.Canned).Result()
+		if err == redis.Nil {
+			return validationFailed(c, "canned", fmt.Sprintf("unknown canned reply %q", req.Canned))
+		} else if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch canned reply"})
+		}
+		message = renderCannedReply(text, ticket)
+	}
+
+	now := clock.Now().UTC()
+	agentID := authClaims(c).Subject
+	ticket.Replies = append(ticket.Replies, TicketReply{AgentID: agentID, Message: message, At: now})
+	if ticket.FirstRespondedAt == nil {
+		ticket.FirstRespondedAt = &now
+	}
+	if ticket.AssigneeID == "" {
+		ticket.AssigneeID = agentID
+		ticket.Status = ticketStatusAssigned
+	}
+	if req.Resolve {
+		setTicketStatus(&ticket, ticketStatusResolved)
+	}
+
+	err = saveTicket(ctx, &ticket)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update ticket"})
+	}
+
+	go notifyTicketReply(context.WithoutCancel(c.Request().Context()), ticket, message)
+
+	requestLogger(c).Info("ticket replied", "ticket_id", ticket.ID, "agent_id", agentID, "status", ticket.Status)
+	return c.JSON(http.StatusOK, ticket)
+}
+
+// renderCannedReply fills the {order_id}, {customer_id} and {amount}
+// placeholders a canned reply may contain.
+func renderCannedReply(text string, ticket Ticket) string {
+	return strings.NewReplacer(
+		"{order_id}", ticket.OrderID,
+		"{customer_id}", ticket.CustomerID,
+		"{amount}", fmt.Sprintf("%.2f", ticket.Amount),
+	).Replace(text)
+}
+
+func notifyTicketReply(ctx context.Context, ticket Ticket, message string) {
+	_, err := dispatchNotification(ctx, redisClient, Notification{
+		RecipientType: "customer",
+		RecipientID:   ticket.CustomerID,
+		OrderID:       ticket.OrderID,
+		Template:      "ticket_reply",
+		Vars: map[string]string{
+			"subject": ticket.Subject,
+			"message": message,
+		},
+	})
+	if err != nil {
+		slog.Error("error notifying customer about ticket reply", "ticket_id", ticket.ID, "error", err)
+	}
+}
+
+func listCannedReplies(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	replies, err := redisClient.HGetAll(ctx, cannedRepliesKey).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch canned replies"})
+	}
+
+	canned := make([]CannedReply, 0, len(replies))
+	for name, text := range replies {
+		canned = append(canned, CannedReply{Name: name, Text: text})
+	}
+	sort.Slice(canned, func(i, j int) bool { return canned[i].Name < canned[j].Name })
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"canned_replies": canned})
+}
+
+func setCannedReply(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req CannedReply
+	if err := bindAndValidate(c, &req); err != nil {
+		return respondRequestError(c, err)
+	}
+	req.Name = c.Param("name")
+
+	err := redisClient.HSet(ctx, cannedRepliesKey, req.Name, req.Text).Err()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store canned reply"})
+	}
+
+	return c.JSON(http.StatusOK, req)
+}
+
+func deleteCannedReply(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	removed, err := redisClient.HDel(ctx, cannedRepliesKey, c.Param("name")).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete canned reply"})
+	}
+	if removed == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Canned reply not found"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,12 @@
+package app
+
+import "myproject/src/model"
+
+const (
+	timelineCreated             = model.TimelineCreated
+	timelineArrivedAtRestaurant = "arrived_at_restaurant"
+	timelineRiderAssigned       = "rider_assigned"
+	timelineItemsChanged        = "items_changed"
+	timelineRiderUnassigned     = "rider_unassigned"
+	timelineRiderArriving       = "rider_arriving"
+)
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Topic routing. Order events go to the orders topic unless
+// appConfig.EventTopics gives their type a topic of its own, so a service
+// that only cares about, say, deliveries can read just those. Every message
+// is keyed by its order ID, and writers place messages by key, so one
+// order's events keep their order within each topic. The consumer and the
+// projections read every topic the router writes to. Events are written
+// through the router's eventProducer, in batches; see event_producer.go.
+
+const ordersTopic = "orders"
+
+// orderEventRouter publishes order events; it is set up in setup.
+var orderEventRouter *topicRouter
+
+// topicRouter holds a writer for each topic order events are published to.
+type topicRouter struct {
+	producer *eventProducer
+	fallback messageWriter
+	// byType is the writer for each event type routed away from fallback.
+	byType map[string]messageWriter
+	// writers lists each writer once, fallback first.
+	writers []messageWriter
+}
+
+// newTopicRouter opens writers on b for fallback and for each topic in
+// routes, which maps event types to topics, and starts the producer that
+// writes to them. Topic names are for this region; several types may share
+// a topic and its writer.
+func newTopicRouter(b eventBus, fallback string, routes map[string]string) *topicRouter {
+	r := &topicRouter{
+		producer: newEventProducer(appConfig.ProducerBufferSize, appConfig.ProducerBatchSize, appConfig.ProducerFlushInterval),
+		fallback: b.Writer(regionTopic(fallback)),
+		byType:   map[string]messageWriter{},
+	}
+	r.writers = append(r.writers, r.fallback)
+	byTopic := map[string]messageWriter{fallback: r.fallback}
+	// Opened in orderEventTypes order, so the topics are listed the same
+	// way every time.
+	for _, eventType := range orderEventTypes {
+		topic, ok := routes[eventType]
+		if !ok || topic == "" {
+			continue
+		}
+		w, ok := byTopic[topic]
+		if !ok {
+			w = b.Writer(regionTopic(topic))
+			byTopic[topic] = w
+			r.writers = append(r.writers, w)
+		}
+		r.byType[eventType] = w
+	}
+	return r
+}
+
+// writer is the writer for events of eventType.
+func (r *topicRouter) writer(eventType string) messageWriter {
+	if w, ok := r.byType[eventType]; ok {
+		return w
+	}
+	return r.fallback
+}
+
+// publish hands msg to the producer for the topic of eventType; see
+// eventProducer.enqueue.
+func (r *topicRouter) publish(ctx context.Context, eventType string, msg kafka.Message, done func(error)) error {
+	return r.producer.enqueue(ctx, r.writer(eventType), eventType, msg, done)
+}
+
+// topics lists the topics written to, the fallback first.
+func (r *topicRouter) topics() []string {
+	topics := make([]string, len(r.writers))
+	for i, w := range r.writers {
+		topics[i] = w.Topic()
+	}
+	return topics
+}
+
+// close writes out the events still buffered and closes the writers.
+func (r *topicRouter) close(ctx context.Context) {
+	r.producer.close(ctx)
+	for _, w := range r.writers {
+		closeKafkaWriter(ctx, w.Topic(), w)
+	}
+}
+
+// topicPartitionName names a partition of topic. The orders topic's
+// partitions go by their number alone, as they did when it was the only
+// topic, so checkpoints and lag series recorded then still apply.
+func topicPartitionName(topic string, partition int) string {
+	if topic == regionTopic(ordersTopic) {
+		return strconv.Itoa(partition)
+	}
+	return topic + ":" + strconv.Itoa(partition)
+}
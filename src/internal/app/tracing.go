@@ -0,0 +1,382 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Request tracing. Each HTTP request and gRPC call is a span, continuing the
+// trace of a caller that sends a W3C traceparent header. Which traces are
+// exported is decided twice:
+//
+//   - Head sampling, as a request starts: TraceSampleRate of requests, or
+//     the route's entry in TraceRouteSampleRates, are kept whatever becomes
+//     of them. A caller's decision to sample is followed.
+//   - Tail sampling, once the request has finished: a trace not kept at the
+//     head is kept anyway if traceTailHook says so, which by default keeps
+//     traces with an error in them and traces slower than
+//     TraceSlowThreshold.
+//
+// So that tail sampling has something to keep, with TraceTailSampling on
+// every request's spans are recorded and held until its root span ends.
+// TraceExporter picks where kept traces go; "none" turns tracing off.
+
+const (
+	traceExporterNone   = "none"
+	traceExporterStdout = "stdout"
+
+	// traceRouteKey carries the route a span serves, "GET /menu" or a gRPC
+	// method, for the sampler to look up.
+	traceRouteKey = attribute.Key("route")
+
+	// tailSamplingMaxTraces caps how many unfinished traces are held; spans
+	// of traces beyond it are not held and so cannot be kept by the tail.
+	tailSamplingMaxTraces = 10000
+	// tailSamplingMaxSpans caps the spans held for one trace.
+	tailSamplingMaxSpans = 512
+	// traceExportQueueSize is how many kept traces may wait for the
+	// exporter before more are dropped.
+	traceExportQueueSize = 256
+)
+
+var tracer = otel.Tracer("food-delivery")
+
+// tracerProvider is nil while tracing is off.
+var tracerProvider *sdktrace.TracerProvider
+
+// traceTailHook decides, once a trace's root span has ended, whether a trace
+// not sampled at the head is kept after all.
+var traceTailHook = keepFailedOrSlowTrace
+
+var tracesSampled = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "traces_sampled_total",
+	Help: "Finished traces by sampling decision (head, tail, dropped, export_queue_full).",
+}, []string{"decision"})
+
+func init() {
+	prometheus.MustRegister(tracesSampled)
+}
+
+// initTracing sets up the tracer provider TraceExporter calls for.
+func initTracing() error {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var exporter sdktrace.SpanExporter
+	switch appConfig.TraceExporter {
+	case traceExporterNone:
+		return nil
+	case traceExporterStdout:
+		var err error
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown trace exporter %q, want %s or %s", appConfig.TraceExporter, traceExporterNone, traceExporterStdout)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", "food-delivery"),
+		attribute.String("service.version", buildInfo.Version),
+		attribute.String("deployment.region", appConfig.Region),
+	))
+	if err != nil {
+		return err
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(newTraceSampler(appConfig.TraceSampleRate, appConfig.TraceRouteSampleRates, appConfig.TraceTailSampling)),
+		sdktrace.WithSpanProcessor(newTailSampler(exporter)),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return nil
+}
+
+// shutdownTracing exports what has been kept and stops the exporter.
+func shutdownTracing(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+	err := tracerProvider.Shutdown(ctx)
+	if err != nil {
+		slog.Error("error shutting down tracing", "error", err)
+	}
+}
+
+// newTraceSampler head-samples new traces by route and follows the caller's
+// decision for traces started elsewhere. With tail sampling, traces not
+// sampled are still recorded.
+func newTraceSampler(rate float64, routeRates map[string]float64, tail bool) sdktrace.Sampler {
+	routes := make(map[string]sdktrace.Sampler, len(routeRates))
+	for route, routeRate := range routeRates {
+		routes[route] = sdktrace.TraceIDRatioBased(routeRate)
+	}
+	root := routeSampler{fallback: sdktrace.TraceIDRatioBased(rate), routes: routes, tail: tail}
+
+	notSampled := sdktrace.NeverSample()
+	if tail {
+		notSampled = recordOnlySampler{}
+	}
+	return sdktrace.ParentBased(root,
+		sdktrace.WithRemoteParentNotSampled(notSampled),
+		sdktrace.WithLocalParentNotSampled(notSampled),
+	)
+}
+
+// routeSampler samples a trace by the route of its first span.
+type routeSampler struct {
+	fallback sdktrace.Sampler
+	routes   map[string]sdktrace.Sampler
+	tail     bool
+}
+
+func (s routeSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	sampler := s.fallback
+	for _, attr := range p.Attributes {
+		if attr.Key == traceRouteKey {
+			if forRoute, ok := s.routes[attr.Value.AsString()]; ok {
+				sampler = forRoute
+			}
+			break
+		}
+	}
+
+	result := sampler.ShouldSample(p)
+	if result.Decision == sdktrace.Drop && s.tail {
+		result.Decision = sdktrace.RecordOnly
+	}
+	return result
+}
+
+func (s routeSampler) Description() string {
+	return fmt.Sprintf("RouteSampler{default=%s,routes=%d,tail=%t}", s.fallback.Description(), len(s.routes), s.tail)
+}
+
+// recordOnlySampler records spans without sampling them, for tail sampling
+// to decide on.
+type recordOnlySampler struct{}
+
+func (recordOnlySampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	return sdktrace.SamplingResult{
+		Decision:   sdktrace.RecordOnly,
+		Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}
+}
+
+func (recordOnlySampler) Description() string { return "RecordOnly" }
+
+// tailSampler holds each trace's spans until its local root span ends, then
+// exports the trace if it was sampled at the head or traceTailHook keeps it.
+type tailSampler struct {
+	exporter sdktrace.SpanExporter
+
+	// mu guards traces, and closed so nothing is queued once Shutdown has
+	// closed queue.
+	mu     sync.Mutex
+	traces map[trace.TraceID][]sdktrace.ReadOnlySpan
+	closed bool
+
+	queue   chan []sdktrace.ReadOnlySpan
+	stopped chan struct{}
+}
+
+func newTailSampler(exporter sdktrace.SpanExporter) *tailSampler {
+	t := &tailSampler{
+		exporter: exporter,
+		traces:   map[trace.TraceID][]sdktrace.ReadOnlySpan{},
+		queue:    make(chan []sdktrace.ReadOnlySpan, traceExportQueueSize),
+		stopped:  make(chan struct{}),
+	}
+	go t.export()
+	return t
+}
+
+func (t *tailSampler) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+func (t *tailSampler) OnEnd(span sdktrace.ReadOnlySpan) {
+	traceID := span.SpanContext().TraceID()
+	localRoot := !span.Parent().IsValid() || span.Parent().IsRemote()
+
+	t.mu.Lock()
+	spans, held := t.traces[traceID]
+	if !localRoot {
+		if (held || len(t.traces) < tailSamplingMaxTraces) && len(spans) < tailSamplingMaxSpans {
+			t.traces[traceID] = append(spans, span)
+		}
+		t.mu.Unlock()
+		return
+	}
+	delete(t.traces, traceID)
+	t.mu.Unlock()
+
+	spans = append(spans, span)
+	switch {
+	case span.SpanContext().IsSampled():
+		tracesSampled.WithLabelValues("head").Inc()
+	case traceTailHook != nil && traceTailHook(spans):
+		tracesSampled.WithLabelValues("tail").Inc()
+	default:
+		tracesSampled.WithLabelValues("dropped").Inc()
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- spans:
+	default:
+		tracesSampled.WithLabelValues("export_queue_full").Inc()
+	}
+}
+
+func (t *tailSampler) export() {
+	defer close(t.stopped)
+	for spans := range t.queue {
+		err := t.exporter.ExportSpans(context.Background(), spans)
+		if err != nil {
+			slog.Warn("error exporting trace", "trace_id", spans[0].SpanContext().TraceID().String(), "error", err)
+		}
+	}
+}
+
+// Shutdown exports the traces already kept, waiting until ctx ends at most.
+// Spans of traces still unfinished are dropped.
+func (t *tailSampler) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-t.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+func (t *tailSampler) ForceFlush(context.Context) error { return nil }
+
+// keepFailedOrSlowTrace keeps traces with a span that failed or took at
+// least TraceSlowThreshold.
+func keepFailedOrSlowTrace(spans []sdktrace.ReadOnlySpan) bool {
+	for _, span := range spans {
+		if span.Status().Code == otelcodes.Error {
+			return true
+		}
+		if span.EndTime().Sub(span.StartTime()) >= appConfig.TraceSlowThreshold {
+			return true
+		}
+	}
+	return false
+}
+
+// requestTracing wraps each request in a server span named for its route.
+// Responses of 500 and up mark the span as failed.
+func requestTracing(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		route := req.Method + " " + c.Path()
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := tracer.Start(ctx, route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				traceRouteKey.String(route),
+				attribute.String("http.request.method", req.Method),
+				attribute.String("url.path", req.URL.Path),
+				attribute.String("request_id", c.Response().Header().Get(echo.HeaderXRequestID)),
+			),
+		)
+		defer span.End()
+		c.SetRequest(req.WithContext(ctx))
+
+		err := next(c)
+		if err != nil {
+			c.Error(err)
+		}
+
+		status := c.Response().Status
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(otelcodes.Error, http.StatusText(status))
+		}
+		return nil
+	}
+}
+
+// grpcTracing wraps each call in a server span named for its method.
+// Codes that mean the server failed mark the span as failed.
+func grpcTracing(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	}
+	ctx, span := tracer.Start(ctx, info.FullMethod,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(traceRouteKey.String(info.FullMethod), attribute.String("rpc.system", "grpc")),
+	)
+	defer span.End()
+
+	resp, err := handler(ctx, req)
+	code := status.Code(err)
+	span.SetAttributes(attribute.String("rpc.grpc.status_code", code.String()))
+	switch code {
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DataLoss, codes.DeadlineExceeded, codes.Unimplemented:
+		span.SetStatus(otelcodes.Error, code.String())
+	}
+	return resp, err
+}
+
+// metadataCarrier lets the propagator read gRPC metadata.
+type metadataCarrier metadata.MD
+
+func (m metadataCarrier) Get(key string) string {
+	values := metadata.MD(m).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (m metadataCarrier) Set(key, value string) { metadata.MD(m).Set(key, value) }
+
+func (m metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// traceID is the ID of the trace ctx is part of, or "" outside one.
+func traceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
@@ -0,0 +1,174 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/handlers"
+)
+
+// Live order tracking. The Kafka consumer republishes each order event on a
+// per-order Redis channel; every instance serving a stream subscribes there,
+// so a customer connected to any instance sees events consumed by any other.
+
+const trackingHeartbeat = 15 * time.Second
+
+// terminalStatuses end a tracking stream: nothing more will happen.
+var terminalStatuses = map[string]bool{
+	"delivered": true,
+	"rejected":  true,
+	"cancelled": true,
+	"expired":   true,
+}
+
+type TrackingUpdate struct {
+	Type    string   `json:"type"`
+	OrderID string   `json:"order_id"`
+	Status  string   `json:"status"`
+	RiderID string   `json:"rider_id,omitempty"`
+	Lat     *float64 `json:"lat,omitempty"`
+	Lng     *float64 `json:"lng,omitempty"`
+	// ReadyBy is when the restaurant committed to have the order ready.
+	ReadyBy *Timestamp `json:"ready_by,omitempty"`
+	// Message is a chat message, on updates of type message; see
+	// order_chat.go.
+	Message *OrderMessage `json:"message,omitempty"`
+	At      Timestamp     `json:"at"`
+}
+
+// hidesUpdate reports whether claims are kept from seeing update: chat
+// messages are only for the customer and rider.
+func hidesUpdate(claims *AuthClaims, update TrackingUpdate) bool {
+	return update.Type == trackingMessage && claims.Role != roleCustomer && claims.Role != roleRider
+}
+
+func orderTrackingChannel(orderID string) string {
+	return "order:" + orderID + ":tracking"
+}
+
+// publishTrackingUpdate forwards event to anyone streaming the order. It is
+// best effort: a missed update is corrected by the next one.
+func publishTrackingUpdate(ctx context.Context, event OrderEvent) {
+	update, _ := json.Marshal(TrackingUpdate{
+		Type:    event.Type,
+		OrderID: event.OrderID,
+		Status:  event.Status,
+		RiderID: event.RiderID,
+		Lat:     event.Lat,
+		Lng:     event.Lng,
+		ReadyBy: event.ReadyBy,
+		At:      event.OccurredAt,
+	})
+
+	err := redisClient.Publish(ctx, orderTrackingChannel(event.OrderID), update).Err()
+	if err != nil {
+		eventLogger(event).Warn("error publishing tracking update", "error", err)
+	}
+}
+
+// streamOrder serves GET /order/:id/stream as Server-Sent Events. The first
+// event is the order's current status; later events follow its lifecycle,
+// the rider's position and the chat, and the stream ends once the order is
+// finished.
+func streamOrder(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	order, err := getOrder(ctx, c.Param("id"))
+	if err == errOrderNotFound || (err == nil && !canTrackOrder(ctx, authClaims(c), order)) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Order not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+
+	// Subscribe before taking the snapshot so nothing published in between
+	// is lost. Subscriptions bypass the region hook, so the channel is
+	// prefixed here to match what publishTrackingUpdate sends to.
+	sub := redisClient.Subscribe(ctx, regionKey(orderTrackingChannel(order.OrderID)))
+	defer sub.Close()
+	_, err = sub.Receive(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to subscribe to order updates"})
+	}
+
+	order, err = getOrder(ctx, order.OrderID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch order"})
+	}
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set(echo.HeaderCacheControl, "no-cache")
+	resp.Header().Set(echo.HeaderConnection, "keep-alive")
+	resp.Header().Set("X-Accel-Buffering", "no")
+	resp.WriteHeader(http.StatusOK)
+
+	snapshot, _ := json.Marshal(TrackingUpdate{
+		Type:    "snapshot",
+		OrderID: order.OrderID,
+		Status:  order.Status,
+		RiderID: order.RiderID,
+		At:      timestampNow(),
+	})
+	if err := writeSSE(c, "snapshot", snapshot); err != nil || terminalStatuses[order.Status] {
+		return nil
+	}
+
+	logger := requestLogger(c).With("order_id", order.OrderID)
+	logger.Debug("order stream opened")
+
+	heartbeat := time.NewTicker(trackingHeartbeat)
+	defer heartbeat.Stop()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Debug("order stream closed by client")
+			return nil
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(resp, ": keep-alive\n\n")
+			if err != nil {
+				return nil
+			}
+			resp.Flush()
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+
+			var update TrackingUpdate
+			err := json.Unmarshal([]byte(msg.Payload), &update)
+			if err != nil || hidesUpdate(authClaims(c), update) {
+				continue
+			}
+			err = writeSSE(c, update.Type, []byte(msg.Payload))
+			if err != nil {
+				return nil
+			}
+			if terminalStatuses[update.Status] {
+				logger.Debug("order stream finished", "status", update.Status)
+				return nil
+			}
+		}
+	}
+}
+
+// writeSSE sends data, an update as JSON, in the caller's casing.
+func writeSSE(c echo.Context, event string, data []byte) error {
+	data, err := handlers.RecaseJSON(c, data)
+	if err != nil {
+		return err
+	}
+	resp := c.Response()
+	_, err = fmt.Fprintf(resp, "event: %s\ndata: %s\n\n", event, data)
+	if err != nil {
+		return err
+	}
+	resp.Flush()
+	return nil
+}
@@ -0,0 +1,397 @@
+package app
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+
+	"myproject/src/clock"
+	"myproject/src/handlers"
+	"myproject/src/rng"
+)
+
+// Tracking channels. An order's live updates go only to its parties: the
+// customer who placed it, the rider assigned to it and its restaurant's
+// staff. Browsers cannot put a bearer token on an EventSource or WebSocket
+// handshake, so a client first trades its token for a ticket with POST
+// /tracking/ticket and opens the channel with ?ticket=. A ticket opens one
+// channel, within TrackingTicketTTL. Clients that can send the
+// Authorization header may do that instead.
+//
+// GET /order/:id/stream follows one order over Server-Sent Events. GET
+// /tracking/ws is a WebSocket over which a client follows up to
+// TrackingMaxSubscriptions orders at once, subscribing and unsubscribing as
+// it goes:
+//
+//	-> {"action": "subscribe", "order_id": "..."}
+//	<- {"type": "subscribed", "order_id": "..."}
+//	<- {"type": "snapshot", "order_id": "...", "status": "...", ...}
+//	<- {"type": "order.accepted", "order_id": "...", ...}
+//	<- {"type": "message", "order_id": "...", "message": {...}, ...}
+//	-> {"action": "unsubscribe", "order_id": "..."}
+//	<- {"type": "unsubscribed", "order_id": "..."}
+//
+// A request refused is answered {"type": "error", "order_id": "...",
+// "error": "..."}. A subscription ends by itself, with "unsubscribed", once
+// its order is finished, and for a rider once the order is given to another
+// rider. The socket is closed when the token behind it expires.
+
+const (
+	trackingSubscribe   = "subscribe"
+	trackingUnsubscribe = "unsubscribe"
+
+	// trackingMaxRequest bounds the size of a message from a client.
+	trackingMaxRequest = 1024
+)
+
+// trackingRoles are the roles that can be party to an order.
+var trackingRoles = []string{roleCustomer, roleRestaurant, roleRider}
+
+var errTrackingTicketInvalid = errors.New("tracking ticket is unknown, used or expired")
+
+func trackingTicketKey(ticket string) string {
+	return "tracking:ticket:" + ticket
+}
+
+type TrackingTicket struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt Timestamp `json:"expires_at"`
+}
+
+// issueTrackingTicket serves POST /tracking/ticket: a ticket standing in for
+// the caller's token when opening a tracking channel.
+func issueTrackingTicket(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	claims, err := json.Marshal(authClaims(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to issue ticket"})
+	}
+	raw := make([]byte, 32)
+	_, err = rng.Read(raw)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to issue ticket"})
+	}
+	ticket := hex.EncodeToString(raw)
+
+	err = redisClient.Set(ctx, trackingTicketKey(ticket), claims, appConfig.TrackingTicketTTL).Err()
+	if err != nil {
+		requestLogger(c).Error("error storing tracking ticket", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to issue ticket"})
+	}
+	return c.JSON(http.StatusCreated, TrackingTicket{
+		Ticket:    ticket,
+		ExpiresAt: Timestamp{Time: clock.Now().Add(appConfig.TrackingTicketTTL)},
+	})
+}
+
+// redeemTrackingTicket returns the claims ticket was issued for, and spends
+// it.
+func redeemTrackingTicket(ctx context.Context, ticket string) (*AuthClaims, error) {
+	data, err := redisClient.GetDel(ctx, trackingTicketKey(ticket)).Result()
+	if err == redis.Nil {
+		return nil, errTrackingTicketInvalid
+	} else if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+
+	var claims AuthClaims
+	err = json.Unmarshal([]byte(data), &claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tracking ticket: %v", err)
+	}
+	if claims.ExpiresAt != nil && !clock.Now().Before(claims.ExpiresAt.Time) {
+		return nil, errTrackingTicketInvalid
+	}
+	return &claims, nil
+}
+
+// trackingAuth authenticates a tracking channel by its ticket, or else its
+// bearer token, admitting only the roles that can be party to an order.
+func trackingAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var claims *AuthClaims
+		var err error
+		if ticket := c.QueryParam("ticket"); ticket != "" {
+			claims, err = redeemTrackingTicket(c.Request().Context(), ticket)
+			if err != nil && !errors.Is(err, errTrackingTicketInvalid) {
+				requestLogger(c).Error("error redeeming tracking ticket", "error", err)
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check ticket"})
+			}
+		} else {
+			claims, err = parseBearerToken(c.Request().Header.Get(echo.HeaderAuthorization))
+		}
+		if err != nil {
+			requestLogger(c).Info("rejected unauthenticated tracking channel", "error", err)
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		}
+		if !slices.Contains(trackingRoles, claims.Role) {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Forbidden"})
+		}
+
+		c.Set(handlers.ClaimsContextKey, claims)
+		return next(c)
+	}
+}
+
+// canTrackOrder reports whether claims belong to a party to order: the
+// customer who placed it, its assigned rider or its restaurant's staff.
+func canTrackOrder(ctx context.Context, claims *AuthClaims, order Order) bool {
+	switch {
+	case claims == nil:
+		return false
+	case claims.Role == roleCustomer:
+		return order.CustomerID == claims.Subject
+	case claims.Role == roleRider:
+		return order.RiderID != "" && claims.ActsForRider(order.RiderID)
+	default:
+		return staffFor(ctx, claims, roleRestaurant, order.RestaurantID)
+	}
+}
+
+type trackingRequest struct {
+	Action  string `json:"action"`
+	OrderID string `json:"order_id"`
+}
+
+type trackingReply struct {
+	Type    string `json:"type"`
+	OrderID string `json:"order_id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// trackOrders serves GET /tracking/ws.
+func trackOrders(c echo.Context) error {
+	conn := &trackingConn{
+		c:      c,
+		claims: authClaims(c),
+		logger: requestLogger(c),
+		orders: map[string]string{},
+	}
+	server := websocket.Server{
+		// The ticket or token admits the client, wherever it was served from.
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			ws.MaxPayloadBytes = trackingMaxRequest
+			conn.ws = ws
+			conn.run(c.Request().Context())
+		},
+	}
+	server.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// trackingConn is one WebSocket client following orders. Only run's
+// goroutine writes to the socket.
+type trackingConn struct {
+	// c is the request that opened the socket, whose casing it is served in.
+	c      echo.Context
+	ws     *websocket.Conn
+	claims *AuthClaims
+	logger *slog.Logger
+	sub    *redis.PubSub
+	// orders maps the channel of each order followed to the order.
+	orders map[string]string
+}
+
+func (t *trackingConn) run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	t.sub = redisClient.Subscribe(ctx)
+	defer t.sub.Close()
+	t.logger.Debug("tracking channel opened")
+	defer t.logger.Debug("tracking channel closed")
+
+	requests := make(chan trackingRequest)
+	go func() {
+		defer cancel()
+		for {
+			var data []byte
+			err := websocket.Message.Receive(t.ws, &data)
+			if err != nil {
+				return
+			}
+			var req trackingRequest
+			data, err = handlers.ModelCaseJSON(t.c, data)
+			if err != nil || json.Unmarshal(data, &req) != nil {
+				req = trackingRequest{}
+			}
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(trackingHeartbeat)
+	defer heartbeat.Stop()
+	var expired <-chan time.Time
+	if t.claims.ExpiresAt != nil {
+		expiry := time.NewTimer(t.claims.ExpiresAt.Sub(clock.Now()))
+		defer expiry.Stop()
+		expired = expiry.C
+	}
+
+	messages := t.sub.Channel()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-expired:
+			t.reply(trackingReply{Type: "error", Error: "Token expired"})
+			return
+		case <-heartbeat.C:
+			err = t.reply(trackingReply{Type: "heartbeat"})
+		case req := <-requests:
+			err = t.handle(ctx, req)
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			err = t.forward(ctx, msg)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (t *trackingConn) reply(reply trackingReply) error {
+	return t.send(reply)
+}
+
+// send writes v as JSON in the client's casing.
+func (t *trackingConn) send(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return t.write(data)
+}
+
+func (t *trackingConn) write(data []byte) error {
+	data, err := handlers.RecaseJSON(t.c, data)
+	if err != nil {
+		return err
+	}
+	return websocket.Message.Send(t.ws, string(data))
+}
+
+func (t *trackingConn) refuse(orderID, message string) error {
+	return t.reply(trackingReply{Type: "error", OrderID: orderID, Error: message})
+}
+
+func (t *trackingConn) handle(ctx context.Context, req trackingRequest) error {
+	switch {
+	case req.Action != trackingSubscribe && req.Action != trackingUnsubscribe:
+		return t.refuse(req.OrderID, "action must be subscribe or unsubscribe")
+	case req.OrderID == "":
+		return t.refuse("", "order_id is required")
+	case req.Action == trackingUnsubscribe:
+		return t.unsubscribe(ctx, req.OrderID)
+	default:
+		return t.subscribe(ctx, req.OrderID)
+	}
+}
+
+// subscribe follows the order, if the client is a party to it, and sends
+// its current status. Like streamOrder it subscribes before taking the
+// snapshot, so nothing published in between is lost.
+func (t *trackingConn) subscribe(ctx context.Context, orderID string) error {
+	channel := regionKey(orderTrackingChannel(orderID))
+	if _, ok := t.orders[channel]; ok {
+		return t.reply(trackingReply{Type: "subscribed", OrderID: orderID})
+	}
+	if len(t.orders) >= appConfig.TrackingMaxSubscriptions {
+		return t.refuse(orderID, fmt.Sprintf("At most %d orders can be followed at once", appConfig.TrackingMaxSubscriptions))
+	}
+
+	order, err := getOrder(ctx, orderID)
+	if err == errOrderNotFound || (err == nil && !canTrackOrder(ctx, t.claims, order)) {
+		return t.refuse(orderID, "Order not found")
+	} else if err != nil {
+		t.logger.Error("error fetching tracked order", "order_id", orderID, "error", err)
+		return t.refuse(orderID, "Failed to fetch order")
+	}
+
+	err = t.sub.Subscribe(ctx, channel)
+	if err != nil {
+		t.logger.Error("error subscribing to order updates", "order_id", orderID, "error", err)
+		return t.refuse(orderID, "Failed to subscribe to order updates")
+	}
+	t.orders[channel] = orderID
+
+	order, err = getOrder(ctx, orderID)
+	if err != nil {
+		t.unsubscribe(ctx, orderID)
+		return t.refuse(orderID, "Failed to fetch order")
+	}
+	err = t.reply(trackingReply{Type: "subscribed", OrderID: orderID})
+	if err != nil {
+		return err
+	}
+	err = t.send(TrackingUpdate{
+		Type:    "snapshot",
+		OrderID: order.OrderID,
+		Status:  order.Status,
+		RiderID: order.RiderID,
+		At:      timestampNow(),
+	})
+	if err != nil || !terminalStatuses[order.Status] {
+		return err
+	}
+	return t.unsubscribe(ctx, orderID)
+}
+
+func (t *trackingConn) unsubscribe(ctx context.Context, orderID string) error {
+	channel := regionKey(orderTrackingChannel(orderID))
+	if _, ok := t.orders[channel]; ok {
+		delete(t.orders, channel)
+		err := t.sub.Unsubscribe(ctx, channel)
+		if err != nil {
+			t.logger.Warn("error unsubscribing from order updates", "order_id", orderID, "error", err)
+		}
+	}
+	return t.reply(trackingReply{Type: "unsubscribed", OrderID: orderID})
+}
+
+// forward sends an update on to the client, ending the subscription once
+// the order is finished or, for a rider, given to someone else.
+func (t *trackingConn) forward(ctx context.Context, msg *redis.Message) error {
+	orderID, ok := t.orders[msg.Channel]
+	if !ok {
+		return nil
+	}
+	var update TrackingUpdate
+	err := json.Unmarshal([]byte(msg.Payload), &update)
+	if err != nil || hidesUpdate(t.claims, update) {
+		return nil
+	}
+
+	if t.claims.Role == roleRider && update.RiderID != "" && !t.claims.ActsForRider(update.RiderID) {
+		err = t.refuse(orderID, "Order is assigned to a different rider")
+		if err != nil {
+			return err
+		}
+		return t.unsubscribe(ctx, orderID)
+	}
+
+	err = t.write([]byte(msg.Payload))
+	if err != nil || !terminalStatuses[update.Status] {
+		return err
+	}
+	return t.unsubscribe(ctx, orderID)
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/labstack/echo/v4"
+	"github.com/segmentio/kafka-go"
+)
+
+// Build information, set at link time:
+//
+//	go build -ldflags "-X myproject/src/internal/app.version=1.4.0 \
+//	  -X myproject/src/internal/app.gitCommit=$(git rev-parse --short HEAD) \
+//	  -X myproject/src/internal/app.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./src/cmd/...
+//
+// Without ldflags the commit and time fall back to the VCS stamp Go embeds
+// when building from a checkout.
+var (
+	version   = "dev"
+	gitCommit = ""
+	buildTime = ""
+)
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+var buildInfo = readBuildInfo()
+
+func readBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		GitCommit: gitCommit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.GitCommit == "":
+				info.GitCommit = s.Value
+			case s.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = s.Value
+			}
+		}
+	}
+
+	if info.GitCommit == "" {
+		info.GitCommit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
+
+func getVersion(c echo.Context) error {
+	return c.JSON(http.StatusOK, buildInfo)
+}
+
+// withBuildHeaders stamps a message with this build's version, commit and
+// build time. Messages that already carry them, such as dead-lettered or
+// re-driven events, keep the stamp of the build that first produced them.
+func withBuildHeaders(headers []kafka.Header) []kafka.Header {
+	for _, h := range headers {
+		if h.Key == "producer-version" {
+			return headers
+		}
+	}
+	return append(headers,
+		kafka.Header{Key: "producer-version", Value: []byte(buildInfo.Version)},
+		kafka.Header{Key: "producer-commit", Value: []byte(buildInfo.GitCommit)},
+		kafka.Header{Key: "producer-build-time", Value: []byte(buildInfo.BuildTime)},
+	)
+}
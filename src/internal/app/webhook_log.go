@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"myproject/src/clock"
+)
+
+// Webhook delivery log. Each attempt to deliver an event to a subscription is
+// recorded: when, what came back and how long it took. The last
+// WebhookDeliveryLogSize attempts are kept, for WebhookDeliveryLogTTL after
+// the latest, so a subscriber whose endpoint misbehaves can see what it did.
+
+// WebhookDelivery is one attempt to deliver an event to a subscription.
+// DeliveryID is the event ID, sent as X-Webhook-Delivery, so the attempts of
+// one delivery share it.
+type WebhookDelivery struct {
+	DeliveryID string    `json:"delivery_id"`
+	EventType  string    `json:"event_type"`
+	OrderID    string    `json:"order_id"`
+	Attempt    int       `json:"attempt"`
+	Success    bool      `json:"success"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	At         Timestamp `json:"at"`
+}
+
+func webhookDeliveriesKey(webhookID string) string {
+	return "webhooks:" + webhookID + ":deliveries"
+}
+
+// logWebhookDelivery records an attempt, err being how it failed if it did.
+// The log is best effort: an attempt that cannot be recorded is still made.
+func logWebhookDelivery(ctx context.Context, subscription WebhookSubscription, event OrderEvent, attempt int, took time.Duration, err error) {
+	if appConfig.WebhookDeliveryLogSize <= 0 {
+		return
+	}
+	delivery := WebhookDelivery{
+		DeliveryID: event.EventID,
+		EventType:  event.Type,
+		OrderID:    event.OrderID,
+		Attempt:    attempt,
+		Success:    err == nil,
+		DurationMS: took.Milliseconds(),
+		At:         Timestamp{Time: clock.Now()},
+	}
+	if subscription.PartnerID != "" {
+		delivery.EventType = webhookEventName(event.Type)
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+		var statusErr *webhookStatusError
+		if errors.As(err, &statusErr) {
+			delivery.StatusCode = statusErr.Status
+		}
+	} else {
+		delivery.StatusCode = http.StatusOK
+	}
+
+	deliveryJSON, err := json.Marshal(delivery)
+	if err != nil {
+		return
+	}
+	key := webhookDeliveriesKey(subscription.ID)
+	pipe := redisClient.TxPipeline()
+	pipe.LPush(ctx, key, deliveryJSON)
+	pipe.LTrim(ctx, key, 0, int64(appConfig.WebhookDeliveryLogSize)-1)
+	pipe.Expire(ctx, key, appConfig.WebhookDeliveryLogTTL)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		slog.Warn("error logging webhook delivery", "webhook_id", subscription.ID, "event_id", event.EventID, "error", err)
+	}
+}
+
+// getWebhookDeliveries lists a subscription's logged delivery attempts,
+// newest first. ?failed=true leaves out those that succeeded.
+func getWebhookDeliveries(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	subscription, err := callerWebhook(c)
+	if err == errWebhookNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Webhook not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch webhook"})
+	}
+	failedOnly := c.QueryParam("failed") == "true"
+
+	records, err := redisClient.LRange(ctx, webhookDeliveriesKey(subscription.ID), 0, -1).Result()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch deliveries"})
+	}
+	deliveries := make([]WebhookDelivery, 0, len(records))
+	for _, record := range records {
+		var delivery WebhookDelivery
+		if err := json.Unmarshal([]byte(record), &delivery); err != nil {
+			requestLogger(c).Warn("skipping malformed webhook delivery record", "webhook_id", subscription.ID, "error", err)
+			continue
+		}
+		if failedOnly && delivery.Success {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"webhook_id": subscription.ID, "deliveries": deliveries})
+}
@@ -0,0 +1,575 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"myproject/src/clock"
+	"myproject/src/rng"
+)
+
+// Order event webhooks. Subscribers, typically restaurant POS systems,
+// receive the order events they asked for, shaped the way they expect: the
+// payload can be reduced to a field mapping and event types renamed, so a
+// legacy system can take events without a translation service in between.
+//
+// Admins subscribe anyone. Integration partners subscribe themselves through
+// /webhooks and see only their own subscriptions; events reach them under
+// public names such as "order.created". Every delivery attempt to a
+// subscription is logged, see webhook_log.go, so a partner can debug failures.
+//
+// Webhooks are only posted over https to public addresses. A URL is checked
+// when it is registered, and the address it resolves to again on every
+// connection, so a host that later resolves somewhere private is refused
+// too.
+
+const webhooksKey = "webhooks"
+
+const webhookSignatureHeader = "X-Webhook-Signature"
+
+var errWebhookNotFound = errors.New("webhook not found")
+
+var webhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "webhook_deliveries_total",
+	Help: "Order event webhook deliveries partitioned by event type and result (success, failure).",
+}, []string{"type", "result"})
+
+func init() {
+	prometheus.MustRegister(webhookDeliveries)
+}
+
+var errWebhookAddressBlocked = errors.New("webhook address is not public")
+
+// webhookClient posts webhooks. It dials only public addresses and goes
+// straight to them, not through a proxy, and follows redirects only to
+// https.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+					return fmt.Errorf("%w: %s", errWebhookAddressBlocked, host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return errors.New("webhook redirected away from https")
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	},
+}
+
+// publicIP reports whether ip may be posted to: not loopback, private,
+// link-local, multicast or unspecified.
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// checkWebhookURL returns why a webhook cannot be posted to rawURL, or "" if
+// it can: it must be https, to a host whose every address is public.
+func checkWebhookURL(ctx context.Context, rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return "must be an absolute URL"
+	}
+	if u.Scheme != "https" {
+		return "must be an https URL"
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return "host " + u.Hostname() + " does not resolve"
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return "host " + u.Hostname() + " resolves to a private address"
+		}
+	}
+	return ""
+}
+
+// WebhookSubscription receives order events at URL. An empty EventTypes or
+// RestaurantID matches everything.
+//
+// Fields maps each output field to the event field it is taken from; dots in
+// an output name nest it, so {"order.ref": "order_code"} sends
+// {"order":{"ref":"A7X-42"}}. Without Fields the whole event is sent.
+// EventNames renames event types in the payload's type field. Event types
+// may be given by their public names, such as "order.created", throughout.
+type WebhookSubscription struct {
+	ID           string   `json:"id"`
+	URL          string   `json:"url" validate:"required,url"`
+	Secret       string   `json:"secret,omitempty"`
+	EventTypes   []string `json:"event_types,omitempty" validate:"dive,required"`
+	RestaurantID string   `json:"restaurant_id,omitempty"`
+	// PartnerID is the partner who registered the subscription; empty for
+	// those set up by an admin.
+	PartnerID  string            `json:"partner_id,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty" validate:"dive,keys,required,endkeys,required"`
+	EventNames map[string]string `json:"event_names,omitempty" validate:"dive,keys,required,endkeys,required"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+// orderEventFields lists the JSON names of OrderEvent's fields, which are
+// what a subscription's Fields may refer to.
+var orderEventFields = func() map[string]bool {
+	fields := map[string]bool{}
+	t := reflect.TypeOf(OrderEvent{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}()
+
+func (s WebhookSubscription) matches(event OrderEvent) bool {
+	if s.RestaurantID != "" && s.RestaurantID != event.RestaurantID {
+		return false
+	}
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, eventType := range s.EventTypes {
+		if eventType == event.Type || eventType == webhookEventName(event.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// payload renders event in the shape the subscriber asked for.
+func (s WebhookSubscription) payload(event OrderEvent) ([]byte, error) {
+	if name, ok := s.EventNames[event.Type]; ok {
+		event.Type = name
+	} else if name, ok := s.EventNames[webhookEventName(event.Type)]; ok {
+		event.Type = name
+	} else if s.PartnerID != "" {
+		event.Type = webhookEventName(event.Type)
+	}
+
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Fields) == 0 {
+		return raw, nil
+	}
+
+	var source map[string]interface{}
+	err = json.Unmarshal(raw, &source)
+	if err != nil {
+		return nil, err
+	}
+
+	out := map[string]interface{}{}
+	for target, from := range s.Fields {
+		setPath(out, strings.Split(target, "."), source[from])
+	}
+	return json.Marshal(out)
+}
+
+// setPath sets value at the nested path in m, creating objects on the way.
+func setPath(m map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[key] = next
+		}
+		m = next
+	}
+	m[path[len(path)-1]] = value
+}
+
+func getWebhookSubscriptions(ctx context.Context) ([]WebhookSubscription, error) {
+	entries, err := redisClient.HGetAll(ctx, webhooksKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+
+	subscriptions := make([]WebhookSubscription, 0, len(entries))
+	for id, data := range entries {
+		var subscription WebhookSubscription
+		err := json.Unmarshal([]byte(data), &subscription)
+		if err != nil {
+			slog.Warn("skipping unreadable webhook subscription", "webhook_id", id, "error", err)
+			continue
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+	})
+	return subscriptions, nil
+}
+
+// deliverOrderEventWebhooks posts event to every matching subscriber. Each
+// delivery is retried on its own; a subscriber that stays down only loses
+// its own copy and never holds up the consumer.
+func deliverOrderEventWebhooks(ctx context.Context, event OrderEvent) {
+	subscriptions, err := getWebhookSubscriptions(ctx)
+	if err != nil {
+		eventLogger(event).Error("error loading webhook subscriptions", "error", err)
+		return
+	}
+
+	for _, subscription := range subscriptions {
+		if !subscription.matches(event) {
+			continue
+		}
+		go func(subscription WebhookSubscription) {
+			attempts, err := postWebhookWithRetry(ctx, subscription, event)
+			if err != nil {
+				webhookDeliveries.WithLabelValues(event.Type, "failure").Inc()
+				eventLogger(event).Warn("webhook delivery failed", "webhook_id", subscription.ID, "attempts", attempts, "error", err)
+				return
+			}
+			webhookDeliveries.WithLabelValues(event.Type, "success").Inc()
+		}(subscription)
+	}
+}
+
+func postWebhookWithRetry(ctx context.Context, subscription WebhookSubscription, event OrderEvent) (int, error) {
+	body, err := subscription.payload(event)
+	if err != nil {
+		return 0, fmt.Errorf("failed to render payload: %v", err)
+	}
+
+	attempt := 0
+	return retryWebhook(ctx, func() error {
+		attempt++
+		start := time.Now()
+		err := postWebhook(ctx, subscription.URL, subscription.Secret, event, body)
+		logWebhookDelivery(ctx, subscription, event, attempt, time.Since(start), err)
+		return err
+	})
+}
+
+// retryWebhook calls post until it succeeds, fails permanently or runs out of
+// attempts, backing off exponentially in between.
+func retryWebhook(ctx context.Context, post func() error) (int, error) {
+	var err error
+	backoff := appConfig.NotifyRetryBackoff
+	for attempt := 1; attempt <= appConfig.NotifyMaxAttempts; attempt++ {
+		err = post()
+		if err == nil || isPermanent(err) || attempt == appConfig.NotifyMaxAttempts {
+			return attempt, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return appConfig.NotifyMaxAttempts, err
+}
+
+// postWebhook sends one delivery. The body is signed with secret as hex
+// HMAC-SHA256 so the receiver can check where it came from; the event ID
+// lets it drop duplicates left by retries.
+func postWebhook(ctx context.Context, url, secret string, event OrderEvent, body []byte) error {
+	if !strings.HasPrefix(url, "https://") {
+		return permanent(errors.New("webhook URL is not https"))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanent(fmt.Errorf("failed to build webhook request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-Delivery", event.EventID)
+	if event.RequestID != "" {
+		req.Header.Set("X-Request-ID", event.RequestID)
+	}
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if errors.Is(err, errWebhookAddressBlocked) {
+		return permanent(fmt.Errorf("webhook request refused: %w", err))
+	} else if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return &webhookStatusError{Status: resp.StatusCode}
+	}
+	return nil
+}
+
+// webhookStatusError is a delivery the receiver answered with a status other
+// than success.
+type webhookStatusError struct {
+	Status int
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook returned status %d", e.Status)
+}
+
+func createWebhook(c echo.Context) error {
+	var subscription WebhookSubscription
+	if err := bindAndValidate(c, &subscription); err != nil {
+		return respondRequestError(c, err)
+	}
+	return saveWebhookSubscription(c, subscription)
+}
+
+// createPartnerWebhook subscribes the calling partner. A partner token bound
+// to a restaurant only ever receives that restaurant's events.
+func createPartnerWebhook(c echo.Context) error {
+	var subscription WebhookSubscription
+	if err := bindAndValidate(c, &subscription); err != nil {
+		return respondRequestError(c, err)
+	}
+
+	claims := authClaims(c)
+	if claims.Subject == "" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Forbidden"})
+	}
+	subscription.PartnerID = claims.Subject
+	if claims.RestaurantID != "" {
+		if subscription.RestaurantID != "" && subscription.RestaurantID != claims.RestaurantID {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Forbidden"})
+		}
+		subscription.RestaurantID = claims.RestaurantID
+	}
+	return saveWebhookSubscription(c, subscription)
+}
+
+// saveWebhookSubscription checks a new subscription, gives it an ID and a
+// secret unless it brought its own, and stores it.
+func saveWebhookSubscription(c echo.Context, subscription WebhookSubscription) error {
+	ctx := c.Request().Context()
+
+	if problem := checkWebhookURL(ctx, subscription.URL); problem != "" {
+		return validationFailed(c, "url", problem)
+	}
+	for _, eventType := range subscription.EventTypes {
+		if !isOrderEventType(eventType) {
+			return validationFailed(c, "event_types", "unknown event type "+eventType)
+		}
+	}
+	for eventType := range subscription.EventNames {
+		if !isOrderEventType(eventType) {
+			return validationFailed(c, "event_names", "unknown event type "+eventType)
+		}
+	}
+	for target, from := range subscription.Fields {
+		if !orderEventFields[from] {
+			return validationFailed(c, "fields", fmt.Sprintf("%s maps from unknown event field %s", target, from))
+		}
+	}
+	if conflict := conflictingFieldPath(subscription.Fields); conflict != "" {
+		return validationFailed(c, "fields", conflict+" is used both as a value and as an object")
+	}
+
+	id, err := idGenerator.NewID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create webhook"})
+	}
+	subscription.ID = id
+	subscription.CreatedAt = clock.Now().UTC()
+
+	if subscription.Secret == "" {
+		subscription.Secret, err = newWebhookSecret()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create webhook"})
+		}
+	}
+
+	subscriptionJSON, _ := json.Marshal(subscription)
+	err = redisClient.HSet(ctx, webhooksKey, subscription.ID, subscriptionJSON).Err()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save webhook"})
+	}
+
+	requestLogger(c).Info("webhook created", "webhook_id", subscription.ID, "event_types", subscription.EventTypes, "restaurant_id", subscription.RestaurantID, "partner_id", subscription.PartnerID)
+
+	// The secret is only ever returned here.
+	return c.JSON(http.StatusCreated, subscription)
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rng.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// conflictingFieldPath returns an output field that is also the parent of
+// another, such as "order" alongside "order.ref", or "" if there is none.
+func conflictingFieldPath(fields map[string]string) string {
+	for target := range fields {
+		for other := range fields {
+			if strings.HasPrefix(other, target+".") {
+				return target
+			}
+		}
+	}
+	return ""
+}
+
+// isOrderEventType reports whether eventType names an order event, either
+// as itself or by its public name.
+func isOrderEventType(eventType string) bool {
+	for _, known := range orderEventTypes {
+		if known == eventType || webhookEventName(known) == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookEventName is the public name partners know an event type by: its
+// subject, a dot and what happened, in snake case. OrderCreated is
+// "order.created" and RiderLocationUpdated "rider.location_updated".
+func webhookEventName(eventType string) string {
+	var words []string
+	start := 0
+	for i, r := range eventType {
+		if i > 0 && unicode.IsUpper(r) {
+			words = append(words, strings.ToLower(eventType[start:i]))
+			start = i
+		}
+	}
+	words = append(words, strings.ToLower(eventType[start:]))
+	if len(words) == 1 {
+		return words[0]
+	}
+	return words[0] + "." + strings.Join(words[1:], "_")
+}
+
+func listWebhooks(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	subscriptions, err := getWebhookSubscriptions(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch webhooks"})
+	}
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"webhooks": subscriptions})
+}
+
+// listPartnerWebhooks lists the calling partner's subscriptions.
+func listPartnerWebhooks(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	subscriptions, err := getWebhookSubscriptions(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch webhooks"})
+	}
+	partnerID := authClaims(c).Subject
+	mine := make([]WebhookSubscription, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		if partnerID != "" && subscription.PartnerID == partnerID {
+			subscription.Secret = ""
+			mine = append(mine, subscription)
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"webhooks": mine})
+}
+
+// getWebhookSubscription returns the subscription with id, or
+// errWebhookNotFound.
+func getWebhookSubscription(ctx context.Context, id string) (WebhookSubscription, error) {
+	data, err := redisClient.HGet(ctx, webhooksKey, id).Result()
+	if err == redis.Nil {
+		return WebhookSubscription{}, errWebhookNotFound
+	} else if err != nil {
+		return WebhookSubscription{}, fmt.Errorf("redis error: %v", err)
+	}
+	var subscription WebhookSubscription
+	err = json.Unmarshal([]byte(data), &subscription)
+	if err != nil {
+		return WebhookSubscription{}, fmt.Errorf("failed to parse webhook: %v", err)
+	}
+	return subscription, nil
+}
+
+// callerWebhook loads the subscription named in the path for the caller:
+// any of them for an admin, only their own for a partner. Another partner's
+// subscription is reported as not found.
+func callerWebhook(c echo.Context) (WebhookSubscription, error) {
+	ctx := c.Request().Context()
+
+	subscription, err := getWebhookSubscription(ctx, c.Param("id"))
+	if err != nil {
+		return subscription, err
+	}
+	claims := authClaims(c)
+	if claims.Role != roleAdmin && (claims.Subject == "" || subscription.PartnerID != claims.Subject) {
+		return WebhookSubscription{}, errWebhookNotFound
+	}
+	return subscription, nil
+}
+
+func deleteWebhook(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	subscription, err := callerWebhook(c)
+	if err == errWebhookNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Webhook not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete webhook"})
+	}
+
+	pipe := redisClient.TxPipeline()
+	pipe.HDel(ctx, webhooksKey, subscription.ID)
+	pipe.Del(ctx, webhookDeliveriesKey(subscription.ID))
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete webhook"})
+	}
+
+	requestLogger(c).Info("webhook deleted", "webhook_id", subscription.ID, "partner_id", subscription.PartnerID)
+	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,41 @@
+package model
+
+import "github.com/golang-jwt/jwt/v5"
+
+const (
+	RoleCustomer   = "customer"
+	RoleRestaurant = "restaurant"
+	RoleRider      = "rider"
+	RoleAdmin      = "admin"
+	// RoleOwner is a restaurant's owner. Owner tokens are bound to their
+	// restaurant by RestaurantID, like restaurant tokens.
+	RoleOwner = "owner"
+	// RolePartner is a third-party integration, identified by the token's
+	// subject. A partner token may be bound to one restaurant by
+	// RestaurantID.
+	RolePartner = "partner"
+)
+
+// AuthClaims are the claims carried by API tokens. RestaurantID and RiderID
+// bind restaurant and rider tokens to the entity they may act for. A
+// restaurant or owner token may instead carry a BrandID, making it a staff
+// account shared by every branch of the brand.
+type AuthClaims struct {
+	Role         string `json:"role"`
+	RestaurantID string `json:"restaurant_id,omitempty"`
+	RiderID      string `json:"rider_id,omitempty"`
+	BrandID      string `json:"brand_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// ActsForRestaurant reports whether the token is bound to restaurantID
+// itself. Brand staff tokens are checked against the restaurant's brand by
+// the caller.
+func (claims *AuthClaims) ActsForRestaurant(restaurantID string) bool {
+	return claims != nil && claims.Role == RoleRestaurant && claims.RestaurantID == restaurantID
+}
+
+// ActsForRider reports whether the token is bound to riderID.
+func (claims *AuthClaims) ActsForRider(riderID string) bool {
+	return claims != nil && claims.Role == RoleRider && claims.RiderID == riderID
+}
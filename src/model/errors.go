@@ -0,0 +1,18 @@
+package model
+
+// ServiceError is a failure the caller can act on, with the HTTP status it
+// maps to. Field is set for a problem with one input field, and Details adds
+// fields to the REST error body.
+type ServiceError struct {
+	Status  int
+	Message string
+	Field   string
+	Details map[string]interface{}
+}
+
+func (e *ServiceError) Error() string {
+	if e.Field != "" {
+		return e.Field + " " + e.Message
+	}
+	return e.Message
+}
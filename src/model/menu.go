@@ -0,0 +1,40 @@
+package model
+
+// MenuItem is an item as listed in menu.json. Available and Quantity are
+// live state, filled in from Redis by applyAvailability; Quantity is only set
+// for items whose stock is tracked. GoesWellWith lists the items the
+// restaurant suggests alongside this one, filled in by applyPairings.
+type MenuItem struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Price       float64 `json:"price"`
+	Currency    string  `json:"currency,omitempty"`
+	Description string  `json:"description"`
+	ImageURL    string  `json:"image_url,omitempty"`
+	// Translations holds the name and description in other languages, keyed
+	// by BCP 47 tag such as "th" or "pt-BR". A menu served in a language
+	// has them swapped in for Name and Description, with Locale saying which
+	// was used.
+	Translations map[string]MenuItemText `json:"translations,omitempty"`
+	Locale       string                  `json:"locale,omitempty"`
+	// Category is free-form except for "drink", which the pickup checklist
+	// counts separately.
+	Category  string `json:"category,omitempty"`
+	Available bool   `json:"available"`
+	Quantity  *int   `json:"quantity,omitempty"`
+
+	GoesWellWith []string `json:"goes_well_with,omitempty"`
+}
+
+// MenuItemText is a menu item's name and description in one language.
+type MenuItemText struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type RestaurantMenu struct {
+	RestaurantID string     `json:"restaurant_id"`
+	Menu         []MenuItem `json:"menu"`
+	CreatedAt    Timestamp  `json:"created_at"`
+	UpdatedAt    Timestamp  `json:"updated_at"`
+}
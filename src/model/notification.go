@@ -0,0 +1,55 @@
+package model
+
+const (
+	NotificationSent    = "sent"
+	NotificationPartial = "partial"
+	NotificationFailed  = "failed"
+)
+
+// Notification is rendered from Template and Vars; both are kept so a failed
+// notification can be rendered again and resent later.
+type Notification struct {
+	ID            string            `json:"id"`
+	RecipientType string            `json:"recipient_type"`
+	RecipientID   string            `json:"recipient_id"`
+	OrderID       string            `json:"order_id"`
+	OrderCode     string            `json:"order_code,omitempty"`
+	RequestID     string            `json:"request_id,omitempty"`
+	Template      string            `json:"template"`
+	Vars          map[string]string `json:"vars"`
+	Subject       string            `json:"subject"`
+	Message       string            `json:"message"`
+}
+
+// Contact holds the addresses a recipient can be reached at. Channels skip
+// recipients whose address for that channel is empty.
+type Contact struct {
+	Email      string `json:"email,omitempty" redis:"email" validate:"omitempty,email"`
+	WebhookURL string `json:"webhook_url,omitempty" redis:"webhook_url" validate:"omitempty,url"`
+	// Devices are registered by the recipient's app, not set with the rest
+	// of the contact.
+	Devices []Device `json:"-" redis:"-"`
+}
+
+// Device is a phone registered by the app for push notifications, by the
+// token FCM or APNs issued it.
+type Device struct {
+	Token        string    `json:"token" validate:"required,max=4096"`
+	Platform     string    `json:"platform" validate:"required,oneof=fcm apns"`
+	RegisteredAt Timestamp `json:"registered_at" validate:"-"`
+}
+
+type ChannelResult struct {
+	Channel  string `json:"channel"`
+	Success  bool   `json:"success"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+	Terminal bool   `json:"terminal,omitempty"`
+}
+
+// NotificationReceipt is the outcome of sending one notification.
+type NotificationReceipt struct {
+	ID       string          `json:"notification_id"`
+	Status   string          `json:"status"`
+	Channels []ChannelResult `json:"channels"`
+}
@@ -0,0 +1,218 @@
+// Package model holds the types passed between the HTTP handlers and the
+// services behind them, so neither has to import the other.
+package model
+
+import "time"
+
+// TimelineCreated is the timeline event every order starts with.
+const TimelineCreated = "created"
+
+type OrderItem struct {
+	MenuID   string `json:"menu_id" validate:"required"`
+	Quantity int    `json:"quantity" validate:"gt=0,lte=100"`
+}
+
+type DeliveryOptions struct {
+	LeaveAtDoor   bool   `json:"leave_at_door"`
+	CallOnArrival bool   `json:"call_on_arrival"`
+	GateCode      string `json:"gate_code,omitempty" validate:"max=32"`
+	Contactless   bool   `json:"contactless"`
+}
+
+// GiftDetails are who a gift order is for. The customer who places and pays
+// for it is not the person it is delivered to.
+type GiftDetails struct {
+	RecipientName  string `json:"recipient_name" validate:"required,max=100"`
+	RecipientPhone string `json:"recipient_phone" validate:"required,e164"`
+	// RecipientEmail is where the recipient is sent tracking updates; they
+	// get none without it.
+	RecipientEmail string `json:"recipient_email,omitempty" validate:"omitempty,email"`
+	// Message is printed on the package note.
+	Message string `json:"message,omitempty" validate:"max=280"`
+}
+
+type Order struct {
+	OrderID         string          `json:"order_id"`
+	Code            string          `json:"code"`
+	RestaurantID    string          `json:"restaurant_id" validate:"required"`
+	CustomerID      string          `json:"customer_id,omitempty"`
+	Items           []OrderItem     `json:"items" validate:"required,min=1,dive"`
+	TotalAmount     float64         `json:"total_amount"`
+	Currency        string          `json:"currency,omitempty"`
+	Status          string          `json:"status"`
+	StatusReason    string          `json:"status_reason,omitempty"`
+	PaymentStatus   string          `json:"payment_status"`
+	PaymentID       string          `json:"payment_id,omitempty"`
+	RiderID         string          `json:"rider_id,omitempty"`
+	DeliveryOptions DeliveryOptions `json:"delivery_options"`
+	// DeliveryLocation is where the order is going. It is optional; without
+	// it no ETA can be given.
+	DeliveryLocation *GeoPoint `json:"delivery_location,omitempty"`
+	PromoCode        string    `json:"promo_code,omitempty" validate:"max=32"`
+	// ScheduledAt is when the customer wants the order delivered; unset for
+	// as soon as possible.
+	ScheduledAt *Timestamp `json:"scheduled_at,omitempty"`
+	// ReadyBy is when the restaurant committed, on accepting, to have the
+	// order ready for pickup.
+	ReadyBy *Timestamp `json:"ready_by,omitempty"`
+	// Gift, if set, sends the order to someone other than the customer.
+	Gift *GiftDetails `json:"gift,omitempty"`
+	// Tip is added to the total and goes to the rider in full. The customer
+	// may add LateTip to it once the order is delivered, charged as
+	// TipPaymentID.
+	Tip          float64 `json:"tip,omitempty" validate:"gte=0,lte=500"`
+	LateTip      float64 `json:"late_tip,omitempty" validate:"-"`
+	TipPaymentID string  `json:"tip_payment_id,omitempty" validate:"-"`
+	// Utensils asks the restaurant to include cutlery.
+	Utensils           bool                `json:"utensils"`
+	Pricing            *PriceBreakdown     `json:"pricing,omitempty"`
+	PickupChecklist    *PickupChecklist    `json:"pickup_checklist,omitempty"`
+	PickupConfirmation *PickupConfirmation `json:"pickup_confirmation,omitempty"`
+	DeliveryProof      *DeliveryProof      `json:"delivery_proof,omitempty"`
+	CancellationFee    float64             `json:"cancellation_fee,omitempty"`
+	FeeSplit           *FeeSplit           `json:"fee_split,omitempty"`
+	Timeline           []TimelineEvent     `json:"timeline"`
+	CreatedAt          Timestamp           `json:"created_at"`
+	UpdatedAt          Timestamp           `json:"updated_at"`
+	// Version counts the writes to the order, from 1 when it is placed.
+	// Clients send it back in If-Match to change the order only as they
+	// last saw it.
+	Version int64 `json:"version"`
+	// Refunds are the money given back on the order, oldest first, and
+	// RefundedAmount their sum. The order stays paid until all of its
+	// payment is given back.
+	Refunds        []Refund `json:"refunds,omitempty" validate:"-"`
+	RefundedAmount float64  `json:"refunded_amount,omitempty" validate:"-"`
+}
+
+// Refund is money given back on an order's payment: all that was left of
+// it, or the price of Items.
+type Refund struct {
+	ID        string       `json:"id"`
+	PaymentID string       `json:"payment_id"`
+	Amount    float64      `json:"amount"`
+	Reason    string       `json:"reason"`
+	Note      string       `json:"note,omitempty"`
+	Items     []RefundItem `json:"items,omitempty"`
+	// IssuedBy is the agent who refunded the order; empty for refunds made
+	// automatically, such as when it is cancelled.
+	IssuedBy  string    `json:"issued_by,omitempty"`
+	CreatedAt Timestamp `json:"created_at"`
+}
+
+type RefundItem struct {
+	MenuID   string  `json:"menu_id" validate:"required"`
+	Quantity int     `json:"quantity" validate:"gt=0,lte=100"`
+	Amount   float64 `json:"amount" validate:"-"`
+}
+
+type TimelineEvent struct {
+	Event string    `json:"event"`
+	At    Timestamp `json:"at"`
+}
+
+func (o Order) HasTimelineEvent(event string) bool {
+	for _, e := range o.Timeline {
+		if e.Event == event {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliveryTime is how long the order has been in flight as of now, measured
+// from its creation event.
+func (o Order) DeliveryTime(now time.Time) time.Duration {
+	for _, e := range o.Timeline {
+		if e.Event == TimelineCreated {
+			return now.Sub(e.At.Time)
+		}
+	}
+	return 0
+}
+
+type PricedItem struct {
+	MenuID    string  `json:"menu_id"`
+	Name      string  `json:"name"`
+	UnitPrice float64 `json:"unit_price"`
+	Quantity  int     `json:"quantity"`
+	LineTotal float64 `json:"line_total"`
+}
+
+// PriceBreakdown itemizes what an order costs. Tax is charged on the
+// discounted subtotal plus the delivery fee, and not on the tip:
+// Total = Subtotal + DeliveryFee - Discount + Tax + Tip.
+type PriceBreakdown struct {
+	Items       []PricedItem `json:"items"`
+	Subtotal    float64      `json:"subtotal"`
+	DeliveryFee float64      `json:"delivery_fee"`
+	Surge       bool         `json:"surge,omitempty"`
+	PromoCode   string       `json:"promo_code,omitempty"`
+	Discount    float64      `json:"discount"`
+	TaxRate     float64      `json:"tax_rate"`
+	Tax         float64      `json:"tax"`
+	Tip         float64      `json:"tip,omitempty"`
+	Total       float64      `json:"total"`
+	Currency    string       `json:"currency"`
+}
+
+// FeeSplit shares an order's delivery fee between its rider, its restaurant
+// and the platform, under the rule in force when the order was placed. The
+// three shares add up to the fee. A promo that waives the fee leaves the
+// split as it was; the platform bears the discount.
+type FeeSplit struct {
+	// Rule names where the shares came from: "restaurant:{id}" for a
+	// restaurant's contract, "zone:{zone}", or "default".
+	Rule        string  `json:"rule"`
+	DeliveryFee float64 `json:"delivery_fee"`
+	// SurgeFee is the part of DeliveryFee added by surge pricing, and
+	// DistanceFee the part charged by distance; the rest is the base fee.
+	SurgeFee    float64 `json:"surge_fee"`
+	DistanceFee float64 `json:"distance_fee,omitempty"`
+	Rider       float64 `json:"rider"`
+	// RiderDistance and RiderSurge are the parts of Rider's share that came
+	// from DistanceFee and SurgeFee.
+	RiderDistance float64 `json:"rider_distance,omitempty"`
+	RiderSurge    float64 `json:"rider_surge,omitempty"`
+	Restaurant    float64 `json:"restaurant"`
+	Platform      float64 `json:"platform"`
+}
+
+type ChecklistItem struct {
+	MenuID   string `json:"menu_id"`
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+}
+
+type PickupChecklist struct {
+	Items    []ChecklistItem `json:"items"`
+	Bags     int             `json:"bags"`
+	Drinks   int             `json:"drinks"`
+	Utensils bool            `json:"utensils"`
+}
+
+// ChecklistConfirmation is what the rider counted at pickup.
+type ChecklistConfirmation struct {
+	Bags     int  `json:"bags" validate:"gte=0"`
+	Drinks   int  `json:"drinks" validate:"gte=0"`
+	Utensils bool `json:"utensils"`
+}
+
+// PickupConfirmation is a rider's checklist response, kept with the order.
+type PickupConfirmation struct {
+	ChecklistConfirmation
+	RiderID     string    `json:"rider_id"`
+	ConfirmedAt Timestamp `json:"confirmed_at"`
+}
+
+// DeliveryProof is what the rider handed the order over with, kept with the
+// order: a photo of the drop-off, the customer's signature and where the
+// rider was. Each is there only if the rider gave it.
+type DeliveryProof struct {
+	PhotoRef      string    `json:"photo_ref,omitempty"`
+	PhotoURL      string    `json:"photo_url,omitempty"`
+	SignatureHash string    `json:"signature_hash,omitempty"`
+	Location      *GeoPoint `json:"location,omitempty"`
+	RiderID       string    `json:"rider_id"`
+	DeliveredAt   Timestamp `json:"delivered_at"`
+}
@@ -0,0 +1,113 @@
+package model
+
+// AcceptOrderRequest commits the restaurant to a time the order will be
+// ready for pickup: ReadyBy, or PrepMinutes from now.
+type AcceptOrderRequest struct {
+	OrderID      string     `json:"order_id" validate:"required,uuid"`
+	RestaurantID string     `json:"restaurant_id" validate:"required"`
+	ReadyBy      *Timestamp `json:"ready_by,omitempty"`
+	PrepMinutes  int        `json:"prep_minutes,omitempty" validate:"omitempty,gte=1,lte=240"`
+}
+
+type AcceptOrderResponse struct {
+	Status  string    `json:"status"`
+	ReadyBy Timestamp `json:"ready_by"`
+}
+
+// ModifyOrderRequest replaces the items of an order not yet accepted. The
+// order is named by the route rather than the body.
+type ModifyOrderRequest struct {
+	OrderID string      `json:"-" validate:"required"`
+	Items   []OrderItem `json:"items" validate:"required,min=1,dive"`
+}
+
+// BulkOrderRequest places many orders at once, for corporate and catering
+// customers: either Orders, each complete, or Order delivered in Slots,
+// each slot becoming an order of its own with the slot's items and time.
+// Either every order is placed or none is.
+type BulkOrderRequest struct {
+	Orders []Order         `json:"orders,omitempty" validate:"required,min=1,max=50,dive"`
+	Order  *Order          `json:"order,omitempty" validate:"-"`
+	Slots  []BulkOrderSlot `json:"slots,omitempty" validate:"omitempty,max=50,dive"`
+}
+
+// BulkOrderSlot is one delivery of a bulk order given in slots. Its items
+// are checked as those of the order it becomes.
+type BulkOrderSlot struct {
+	ScheduledAt *Timestamp  `json:"scheduled_at" validate:"required"`
+	Items       []OrderItem `json:"items" validate:"-"`
+}
+
+// SlotOrders returns an order for each slot: Order with the slot's items,
+// scheduled for the slot.
+func (r BulkOrderRequest) SlotOrders() []Order {
+	orders := make([]Order, len(r.Slots))
+	for i, slot := range r.Slots {
+		order := *r.Order
+		if order.Gift != nil {
+			gift := *order.Gift
+			order.Gift = &gift
+		}
+		order.Items = slot.Items
+		order.ScheduledAt = slot.ScheduledAt
+		orders[i] = order
+	}
+	return orders
+}
+
+// What became of each order of a bulk request: created, rejected by the
+// checks, not placed because another was rejected, or failed to be stored.
+const (
+	BulkOrderCreated   = "created"
+	BulkOrderRejected  = "rejected"
+	BulkOrderNotPlaced = "not_placed"
+	BulkOrderFailed    = "failed"
+)
+
+type BulkOrderResult struct {
+	Index       int                    `json:"index"`
+	Status      string                 `json:"status"`
+	OrderID     string                 `json:"order_id,omitempty"`
+	OrderCode   string                 `json:"order_code,omitempty"`
+	ScheduledAt *Timestamp             `json:"scheduled_at,omitempty"`
+	Pricing     *PriceBreakdown        `json:"pricing,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	Field       string                 `json:"field,omitempty"`
+	Details     map[string]interface{} `json:"details,omitempty"`
+}
+
+type BulkOrderResponse struct {
+	Created int               `json:"created"`
+	Orders  []BulkOrderResult `json:"orders"`
+}
+
+type RejectOrderRequest struct {
+	OrderID      string `json:"order_id" validate:"required,uuid"`
+	RestaurantID string `json:"restaurant_id" validate:"required"`
+	Reason       string `json:"reason" validate:"required,max=500"`
+}
+
+type PickupRequest struct {
+	OrderID   string `json:"order_id" validate:"required,uuid"`
+	RiderID   string `json:"rider_id" validate:"required"`
+	OrderCode string `json:"order_code,omitempty"`
+	// Checklist is required for orders placed with a pickup checklist.
+	Checklist *ChecklistConfirmation `json:"checklist"`
+}
+
+type DeliverRequest struct {
+	OrderID       string `json:"order_id" validate:"required,uuid"`
+	RiderID       string `json:"rider_id" validate:"required"`
+	SignatureHash string `json:"signature_hash"`
+	// PhotoRef is the id of a photo of the drop-off, uploaded beforehand to
+	// POST /rider/order/:id/proof-photo.
+	PhotoRef string `json:"photo_ref,omitempty"`
+	// Location is where the rider handed the order over.
+	Location *GeoPoint `json:"location,omitempty"`
+}
+
+type SendNotificationRequest struct {
+	Recipient string `json:"recipient" validate:"required,oneof=customer restaurant rider gift_recipient"`
+	OrderID   string `json:"order_id" validate:"required"`
+	Message   string `json:"message" validate:"required,max=1000"`
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"myproject/src/clock"
+)
+
+// timestampLayout is RFC 3339 in UTC with fixed millisecond precision, so
+// timestamps sort as strings.
+const timestampLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// Timestamp is a time.Time that always marshals as UTC RFC 3339. The zero
+// value marshals as null. Any RFC 3339 time is accepted when unmarshaling.
+type Timestamp struct {
+	time.Time
+}
+
+func NewTimestamp(t time.Time) Timestamp {
+	return Timestamp{t.UTC()}
+}
+
+func Now() Timestamp {
+	return NewTimestamp(clock.Now())
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.UTC().Format(timestampLayout))
+}
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) || bytes.Equal(data, []byte(`""`)) {
+		*t = Timestamp{}
+		return nil
+	}
+
+	var parsed time.Time
+	err := json.Unmarshal(data, &parsed)
+	if err != nil {
+		return err
+	}
+	*t = NewTimestamp(parsed)
+	return nil
+}
+
+func (t Timestamp) String() string {
+	return t.UTC().Format(timestampLayout)
+}
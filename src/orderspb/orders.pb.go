@@ -0,0 +1,1488 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        v28.3.0
+// source: orderspb/orders.proto
+
+// The order service's gRPC API, for internal callers. It serves the same
+// menus and order lifecycle as the REST API and is authorised with the same
+// bearer tokens, sent as "authorization" metadata.
+
+package orderspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type MenuItem struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Id          string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name        string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Price       float64                `protobuf:"fixed64,3,opt,name=price,proto3" json:"price,omitempty"`
+	Description string                 `protobuf:"bytes,4,opt,name=description,proto3" json:"description,omitempty"`
+	Category    string                 `protobuf:"bytes,5,opt,name=category,proto3" json:"category,omitempty"`
+	Available   bool                   `protobuf:"varint,6,opt,name=available,proto3" json:"available,omitempty"`
+	// quantity is the stock left, for items with limited stock.
+	Quantity *int32 `protobuf:"varint,7,opt,name=quantity,proto3,oneof" json:"quantity,omitempty"`
+	Currency string `protobuf:"bytes,8,opt,name=currency,proto3" json:"currency,omitempty"`
+	// locale is the language name and description are in, when translated.
+	Locale        string `protobuf:"bytes,9,opt,name=locale,proto3" json:"locale,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MenuItem) Reset() {
+	*x = MenuItem{}
+	mi := &file_orderspb_orders_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MenuItem) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MenuItem) ProtoMessage() {}
+
+func (x *MenuItem) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MenuItem.ProtoReflect.Descriptor instead.
+func (*MenuItem) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *MenuItem) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *MenuItem) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *MenuItem) GetPrice() float64 {
+	if x != nil {
+		return x.Price
+	}
+	return 0
+}
+
+func (x *MenuItem) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *MenuItem) GetCategory() string {
+	if x != nil {
+		return x.Category
+	}
+	return ""
+}
+
+func (x *MenuItem) GetAvailable() bool {
+	if x != nil {
+		return x.Available
+	}
+	return false
+}
+
+func (x *MenuItem) GetQuantity() int32 {
+	if x != nil && x.Quantity != nil {
+		return *x.Quantity
+	}
+	return 0
+}
+
+func (x *MenuItem) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
+func (x *MenuItem) GetLocale() string {
+	if x != nil {
+		return x.Locale
+	}
+	return ""
+}
+
+type Menu struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RestaurantId  string                 `protobuf:"bytes,1,opt,name=restaurant_id,json=restaurantId,proto3" json:"restaurant_id,omitempty"`
+	Items         []*MenuItem            `protobuf:"bytes,2,rep,name=items,proto3" json:"items,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Menu) Reset() {
+	*x = Menu{}
+	mi := &file_orderspb_orders_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Menu) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Menu) ProtoMessage() {}
+
+func (x *Menu) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Menu.ProtoReflect.Descriptor instead.
+func (*Menu) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Menu) GetRestaurantId() string {
+	if x != nil {
+		return x.RestaurantId
+	}
+	return ""
+}
+
+func (x *Menu) GetItems() []*MenuItem {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+type GetMenuRequest struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	RestaurantId string                 `protobuf:"bytes,1,opt,name=restaurant_id,json=restaurantId,proto3" json:"restaurant_id,omitempty"`
+	// locale asks for names and descriptions in a language, such as "th".
+	Locale        string `protobuf:"bytes,2,opt,name=locale,proto3" json:"locale,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMenuRequest) Reset() {
+	*x = GetMenuRequest{}
+	mi := &file_orderspb_orders_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMenuRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMenuRequest) ProtoMessage() {}
+
+func (x *GetMenuRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMenuRequest.ProtoReflect.Descriptor instead.
+func (*GetMenuRequest) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetMenuRequest) GetRestaurantId() string {
+	if x != nil {
+		return x.RestaurantId
+	}
+	return ""
+}
+
+func (x *GetMenuRequest) GetLocale() string {
+	if x != nil {
+		return x.Locale
+	}
+	return ""
+}
+
+type OrderItem struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MenuId        string                 `protobuf:"bytes,1,opt,name=menu_id,json=menuId,proto3" json:"menu_id,omitempty"`
+	Quantity      int32                  `protobuf:"varint,2,opt,name=quantity,proto3" json:"quantity,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OrderItem) Reset() {
+	*x = OrderItem{}
+	mi := &file_orderspb_orders_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OrderItem) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OrderItem) ProtoMessage() {}
+
+func (x *OrderItem) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OrderItem.ProtoReflect.Descriptor instead.
+func (*OrderItem) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *OrderItem) GetMenuId() string {
+	if x != nil {
+		return x.MenuId
+	}
+	return ""
+}
+
+func (x *OrderItem) GetQuantity() int32 {
+	if x != nil {
+		return x.Quantity
+	}
+	return 0
+}
+
+type DeliveryOptions struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	LeaveAtDoor   bool                   `protobuf:"varint,1,opt,name=leave_at_door,json=leaveAtDoor,proto3" json:"leave_at_door,omitempty"`
+	CallOnArrival bool                   `protobuf:"varint,2,opt,name=call_on_arrival,json=callOnArrival,proto3" json:"call_on_arrival,omitempty"`
+	GateCode      string                 `protobuf:"bytes,3,opt,name=gate_code,json=gateCode,proto3" json:"gate_code,omitempty"`
+	Contactless   bool                   `protobuf:"varint,4,opt,name=contactless,proto3" json:"contactless,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeliveryOptions) Reset() {
+	*x = DeliveryOptions{}
+	mi := &file_orderspb_orders_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeliveryOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeliveryOptions) ProtoMessage() {}
+
+func (x *DeliveryOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeliveryOptions.ProtoReflect.Descriptor instead.
+func (*DeliveryOptions) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *DeliveryOptions) GetLeaveAtDoor() bool {
+	if x != nil {
+		return x.LeaveAtDoor
+	}
+	return false
+}
+
+func (x *DeliveryOptions) GetCallOnArrival() bool {
+	if x != nil {
+		return x.CallOnArrival
+	}
+	return false
+}
+
+func (x *DeliveryOptions) GetGateCode() string {
+	if x != nil {
+		return x.GateCode
+	}
+	return ""
+}
+
+func (x *DeliveryOptions) GetContactless() bool {
+	if x != nil {
+		return x.Contactless
+	}
+	return false
+}
+
+type GeoPoint struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lat           float64                `protobuf:"fixed64,1,opt,name=lat,proto3" json:"lat,omitempty"`
+	Lng           float64                `protobuf:"fixed64,2,opt,name=lng,proto3" json:"lng,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GeoPoint) Reset() {
+	*x = GeoPoint{}
+	mi := &file_orderspb_orders_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GeoPoint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GeoPoint) ProtoMessage() {}
+
+func (x *GeoPoint) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GeoPoint.ProtoReflect.Descriptor instead.
+func (*GeoPoint) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GeoPoint) GetLat() float64 {
+	if x != nil {
+		return x.Lat
+	}
+	return 0
+}
+
+func (x *GeoPoint) GetLng() float64 {
+	if x != nil {
+		return x.Lng
+	}
+	return 0
+}
+
+// Who a gift order is delivered to, when that is not the customer.
+type GiftDetails struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	RecipientName  string                 `protobuf:"bytes,1,opt,name=recipient_name,json=recipientName,proto3" json:"recipient_name,omitempty"`
+	RecipientPhone string                 `protobuf:"bytes,2,opt,name=recipient_phone,json=recipientPhone,proto3" json:"recipient_phone,omitempty"`
+	RecipientEmail string                 `protobuf:"bytes,3,opt,name=recipient_email,json=recipientEmail,proto3" json:"recipient_email,omitempty"`
+	Message        string                 `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *GiftDetails) Reset() {
+	*x = GiftDetails{}
+	mi := &file_orderspb_orders_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GiftDetails) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GiftDetails) ProtoMessage() {}
+
+func (x *GiftDetails) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GiftDetails.ProtoReflect.Descriptor instead.
+func (*GiftDetails) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GiftDetails) GetRecipientName() string {
+	if x != nil {
+		return x.RecipientName
+	}
+	return ""
+}
+
+func (x *GiftDetails) GetRecipientPhone() string {
+	if x != nil {
+		return x.RecipientPhone
+	}
+	return ""
+}
+
+func (x *GiftDetails) GetRecipientEmail() string {
+	if x != nil {
+		return x.RecipientEmail
+	}
+	return ""
+}
+
+func (x *GiftDetails) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type PriceBreakdown struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Subtotal      float64                `protobuf:"fixed64,1,opt,name=subtotal,proto3" json:"subtotal,omitempty"`
+	DeliveryFee   float64                `protobuf:"fixed64,2,opt,name=delivery_fee,json=deliveryFee,proto3" json:"delivery_fee,omitempty"`
+	Surge         bool                   `protobuf:"varint,3,opt,name=surge,proto3" json:"surge,omitempty"`
+	PromoCode     string                 `protobuf:"bytes,4,opt,name=promo_code,json=promoCode,proto3" json:"promo_code,omitempty"`
+	Discount      float64                `protobuf:"fixed64,5,opt,name=discount,proto3" json:"discount,omitempty"`
+	TaxRate       float64                `protobuf:"fixed64,6,opt,name=tax_rate,json=taxRate,proto3" json:"tax_rate,omitempty"`
+	Tax           float64                `protobuf:"fixed64,7,opt,name=tax,proto3" json:"tax,omitempty"`
+	Total         float64                `protobuf:"fixed64,8,opt,name=total,proto3" json:"total,omitempty"`
+	Currency      string                 `protobuf:"bytes,9,opt,name=currency,proto3" json:"currency,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PriceBreakdown) Reset() {
+	*x = PriceBreakdown{}
+	mi := &file_orderspb_orders_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PriceBreakdown) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PriceBreakdown) ProtoMessage() {}
+
+func (x *PriceBreakdown) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PriceBreakdown.ProtoReflect.Descriptor instead.
+func (*PriceBreakdown) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *PriceBreakdown) GetSubtotal() float64 {
+	if x != nil {
+		return x.Subtotal
+	}
+	return 0
+}
+
+func (x *PriceBreakdown) GetDeliveryFee() float64 {
+	if x != nil {
+		return x.DeliveryFee
+	}
+	return 0
+}
+
+func (x *PriceBreakdown) GetSurge() bool {
+	if x != nil {
+		return x.Surge
+	}
+	return false
+}
+
+func (x *PriceBreakdown) GetPromoCode() string {
+	if x != nil {
+		return x.PromoCode
+	}
+	return ""
+}
+
+func (x *PriceBreakdown) GetDiscount() float64 {
+	if x != nil {
+		return x.Discount
+	}
+	return 0
+}
+
+func (x *PriceBreakdown) GetTaxRate() float64 {
+	if x != nil {
+		return x.TaxRate
+	}
+	return 0
+}
+
+func (x *PriceBreakdown) GetTax() float64 {
+	if x != nil {
+		return x.Tax
+	}
+	return 0
+}
+
+func (x *PriceBreakdown) GetTotal() float64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *PriceBreakdown) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
+type ChecklistItem struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MenuId        string                 `protobuf:"bytes,1,opt,name=menu_id,json=menuId,proto3" json:"menu_id,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Quantity      int32                  `protobuf:"varint,3,opt,name=quantity,proto3" json:"quantity,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChecklistItem) Reset() {
+	*x = ChecklistItem{}
+	mi := &file_orderspb_orders_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChecklistItem) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChecklistItem) ProtoMessage() {}
+
+func (x *ChecklistItem) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChecklistItem.ProtoReflect.Descriptor instead.
+func (*ChecklistItem) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ChecklistItem) GetMenuId() string {
+	if x != nil {
+		return x.MenuId
+	}
+	return ""
+}
+
+func (x *ChecklistItem) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ChecklistItem) GetQuantity() int32 {
+	if x != nil {
+		return x.Quantity
+	}
+	return 0
+}
+
+type PickupChecklist struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Items         []*ChecklistItem       `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+	Bags          int32                  `protobuf:"varint,2,opt,name=bags,proto3" json:"bags,omitempty"`
+	Drinks        int32                  `protobuf:"varint,3,opt,name=drinks,proto3" json:"drinks,omitempty"`
+	Utensils      bool                   `protobuf:"varint,4,opt,name=utensils,proto3" json:"utensils,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PickupChecklist) Reset() {
+	*x = PickupChecklist{}
+	mi := &file_orderspb_orders_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PickupChecklist) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PickupChecklist) ProtoMessage() {}
+
+func (x *PickupChecklist) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PickupChecklist.ProtoReflect.Descriptor instead.
+func (*PickupChecklist) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *PickupChecklist) GetItems() []*ChecklistItem {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+func (x *PickupChecklist) GetBags() int32 {
+	if x != nil {
+		return x.Bags
+	}
+	return 0
+}
+
+func (x *PickupChecklist) GetDrinks() int32 {
+	if x != nil {
+		return x.Drinks
+	}
+	return 0
+}
+
+func (x *PickupChecklist) GetUtensils() bool {
+	if x != nil {
+		return x.Utensils
+	}
+	return false
+}
+
+// ChecklistConfirmation is what the rider counted at pickup.
+type ChecklistConfirmation struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Bags          int32                  `protobuf:"varint,1,opt,name=bags,proto3" json:"bags,omitempty"`
+	Drinks        int32                  `protobuf:"varint,2,opt,name=drinks,proto3" json:"drinks,omitempty"`
+	Utensils      bool                   `protobuf:"varint,3,opt,name=utensils,proto3" json:"utensils,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChecklistConfirmation) Reset() {
+	*x = ChecklistConfirmation{}
+	mi := &file_orderspb_orders_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChecklistConfirmation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChecklistConfirmation) ProtoMessage() {}
+
+func (x *ChecklistConfirmation) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChecklistConfirmation.ProtoReflect.Descriptor instead.
+func (*ChecklistConfirmation) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ChecklistConfirmation) GetBags() int32 {
+	if x != nil {
+		return x.Bags
+	}
+	return 0
+}
+
+func (x *ChecklistConfirmation) GetDrinks() int32 {
+	if x != nil {
+		return x.Drinks
+	}
+	return 0
+}
+
+func (x *ChecklistConfirmation) GetUtensils() bool {
+	if x != nil {
+		return x.Utensils
+	}
+	return false
+}
+
+type Order struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	OrderId          string                 `protobuf:"bytes,1,opt,name=order_id,json=orderId,proto3" json:"order_id,omitempty"`
+	Code             string                 `protobuf:"bytes,2,opt,name=code,proto3" json:"code,omitempty"`
+	RestaurantId     string                 `protobuf:"bytes,3,opt,name=restaurant_id,json=restaurantId,proto3" json:"restaurant_id,omitempty"`
+	CustomerId       string                 `protobuf:"bytes,4,opt,name=customer_id,json=customerId,proto3" json:"customer_id,omitempty"`
+	Items            []*OrderItem           `protobuf:"bytes,5,rep,name=items,proto3" json:"items,omitempty"`
+	TotalAmount      float64                `protobuf:"fixed64,6,opt,name=total_amount,json=totalAmount,proto3" json:"total_amount,omitempty"`
+	Status           string                 `protobuf:"bytes,7,opt,name=status,proto3" json:"status,omitempty"`
+	StatusReason     string                 `protobuf:"bytes,8,opt,name=status_reason,json=statusReason,proto3" json:"status_reason,omitempty"`
+	PaymentStatus    string                 `protobuf:"bytes,9,opt,name=payment_status,json=paymentStatus,proto3" json:"payment_status,omitempty"`
+	RiderId          string                 `protobuf:"bytes,10,opt,name=rider_id,json=riderId,proto3" json:"rider_id,omitempty"`
+	DeliveryOptions  *DeliveryOptions       `protobuf:"bytes,11,opt,name=delivery_options,json=deliveryOptions,proto3" json:"delivery_options,omitempty"`
+	DeliveryLocation *GeoPoint              `protobuf:"bytes,12,opt,name=delivery_location,json=deliveryLocation,proto3" json:"delivery_location,omitempty"`
+	PromoCode        string                 `protobuf:"bytes,13,opt,name=promo_code,json=promoCode,proto3" json:"promo_code,omitempty"`
+	Utensils         bool                   `protobuf:"varint,14,opt,name=utensils,proto3" json:"utensils,omitempty"`
+	Pricing          *PriceBreakdown        `protobuf:"bytes,15,opt,name=pricing,proto3" json:"pricing,omitempty"`
+	PickupChecklist  *PickupChecklist       `protobuf:"bytes,16,opt,name=pickup_checklist,json=pickupChecklist,proto3" json:"pickup_checklist,omitempty"`
+	CreatedAt        *timestamppb.Timestamp `protobuf:"bytes,17,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt        *timestamppb.Timestamp `protobuf:"bytes,18,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	// When the restaurant committed to have the order ready, once accepted.
+	ReadyBy       *timestamppb.Timestamp `protobuf:"bytes,19,opt,name=ready_by,json=readyBy,proto3" json:"ready_by,omitempty"`
+	Currency      string                 `protobuf:"bytes,20,opt,name=currency,proto3" json:"currency,omitempty"`
+	Gift          *GiftDetails           `protobuf:"bytes,21,opt,name=gift,proto3" json:"gift,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Order) Reset() {
+	*x = Order{}
+	mi := &file_orderspb_orders_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Order) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Order) ProtoMessage() {}
+
+func (x *Order) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Order.ProtoReflect.Descriptor instead.
+func (*Order) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Order) GetOrderId() string {
+	if x != nil {
+		return x.OrderId
+	}
+	return ""
+}
+
+func (x *Order) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *Order) GetRestaurantId() string {
+	if x != nil {
+		return x.RestaurantId
+	}
+	return ""
+}
+
+func (x *Order) GetCustomerId() string {
+	if x != nil {
+		return x.CustomerId
+	}
+	return ""
+}
+
+func (x *Order) GetItems() []*OrderItem {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+func (x *Order) GetTotalAmount() float64 {
+	if x != nil {
+		return x.TotalAmount
+	}
+	return 0
+}
+
+func (x *Order) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Order) GetStatusReason() string {
+	if x != nil {
+		return x.StatusReason
+	}
+	return ""
+}
+
+func (x *Order) GetPaymentStatus() string {
+	if x != nil {
+		return x.PaymentStatus
+	}
+	return ""
+}
+
+func (x *Order) GetRiderId() string {
+	if x != nil {
+		return x.RiderId
+	}
+	return ""
+}
+
+func (x *Order) GetDeliveryOptions() *DeliveryOptions {
+	if x != nil {
+		return x.DeliveryOptions
+	}
+	return nil
+}
+
+func (x *Order) GetDeliveryLocation() *GeoPoint {
+	if x != nil {
+		return x.DeliveryLocation
+	}
+	return nil
+}
+
+func (x *Order) GetPromoCode() string {
+	if x != nil {
+		return x.PromoCode
+	}
+	return ""
+}
+
+func (x *Order) GetUtensils() bool {
+	if x != nil {
+		return x.Utensils
+	}
+	return false
+}
+
+func (x *Order) GetPricing() *PriceBreakdown {
+	if x != nil {
+		return x.Pricing
+	}
+	return nil
+}
+
+func (x *Order) GetPickupChecklist() *PickupChecklist {
+	if x != nil {
+		return x.PickupChecklist
+	}
+	return nil
+}
+
+func (x *Order) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Order) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *Order) GetReadyBy() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ReadyBy
+	}
+	return nil
+}
+
+func (x *Order) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
+func (x *Order) GetGift() *GiftDetails {
+	if x != nil {
+		return x.Gift
+	}
+	return nil
+}
+
+type PlaceOrderRequest struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	RestaurantId     string                 `protobuf:"bytes,1,opt,name=restaurant_id,json=restaurantId,proto3" json:"restaurant_id,omitempty"`
+	Items            []*OrderItem           `protobuf:"bytes,2,rep,name=items,proto3" json:"items,omitempty"`
+	DeliveryOptions  *DeliveryOptions       `protobuf:"bytes,3,opt,name=delivery_options,json=deliveryOptions,proto3" json:"delivery_options,omitempty"`
+	DeliveryLocation *GeoPoint              `protobuf:"bytes,4,opt,name=delivery_location,json=deliveryLocation,proto3" json:"delivery_location,omitempty"`
+	PromoCode        string                 `protobuf:"bytes,5,opt,name=promo_code,json=promoCode,proto3" json:"promo_code,omitempty"`
+	Utensils         bool                   `protobuf:"varint,6,opt,name=utensils,proto3" json:"utensils,omitempty"`
+	Gift             *GiftDetails           `protobuf:"bytes,7,opt,name=gift,proto3" json:"gift,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *PlaceOrderRequest) Reset() {
+	*x = PlaceOrderRequest{}
+	mi := &file_orderspb_orders_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PlaceOrderRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PlaceOrderRequest) ProtoMessage() {}
+
+func (x *PlaceOrderRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PlaceOrderRequest.ProtoReflect.Descriptor instead.
+func (*PlaceOrderRequest) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *PlaceOrderRequest) GetRestaurantId() string {
+	if x != nil {
+		return x.RestaurantId
+	}
+	return ""
+}
+
+func (x *PlaceOrderRequest) GetItems() []*OrderItem {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+func (x *PlaceOrderRequest) GetDeliveryOptions() *DeliveryOptions {
+	if x != nil {
+		return x.DeliveryOptions
+	}
+	return nil
+}
+
+func (x *PlaceOrderRequest) GetDeliveryLocation() *GeoPoint {
+	if x != nil {
+		return x.DeliveryLocation
+	}
+	return nil
+}
+
+func (x *PlaceOrderRequest) GetPromoCode() string {
+	if x != nil {
+		return x.PromoCode
+	}
+	return ""
+}
+
+func (x *PlaceOrderRequest) GetUtensils() bool {
+	if x != nil {
+		return x.Utensils
+	}
+	return false
+}
+
+func (x *PlaceOrderRequest) GetGift() *GiftDetails {
+	if x != nil {
+		return x.Gift
+	}
+	return nil
+}
+
+type AcceptOrderRequest struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	OrderId      string                 `protobuf:"bytes,1,opt,name=order_id,json=orderId,proto3" json:"order_id,omitempty"`
+	RestaurantId string                 `protobuf:"bytes,2,opt,name=restaurant_id,json=restaurantId,proto3" json:"restaurant_id,omitempty"`
+	// When the order will be ready for pickup: either ready_by, or
+	// prep_minutes from now.
+	ReadyBy       *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=ready_by,json=readyBy,proto3" json:"ready_by,omitempty"`
+	PrepMinutes   int32                  `protobuf:"varint,4,opt,name=prep_minutes,json=prepMinutes,proto3" json:"prep_minutes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AcceptOrderRequest) Reset() {
+	*x = AcceptOrderRequest{}
+	mi := &file_orderspb_orders_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AcceptOrderRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AcceptOrderRequest) ProtoMessage() {}
+
+func (x *AcceptOrderRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AcceptOrderRequest.ProtoReflect.Descriptor instead.
+func (*AcceptOrderRequest) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *AcceptOrderRequest) GetOrderId() string {
+	if x != nil {
+		return x.OrderId
+	}
+	return ""
+}
+
+func (x *AcceptOrderRequest) GetRestaurantId() string {
+	if x != nil {
+		return x.RestaurantId
+	}
+	return ""
+}
+
+func (x *AcceptOrderRequest) GetReadyBy() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ReadyBy
+	}
+	return nil
+}
+
+func (x *AcceptOrderRequest) GetPrepMinutes() int32 {
+	if x != nil {
+		return x.PrepMinutes
+	}
+	return 0
+}
+
+type ConfirmPickupRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	OrderId       string                 `protobuf:"bytes,1,opt,name=order_id,json=orderId,proto3" json:"order_id,omitempty"`
+	RiderId       string                 `protobuf:"bytes,2,opt,name=rider_id,json=riderId,proto3" json:"rider_id,omitempty"`
+	OrderCode     string                 `protobuf:"bytes,3,opt,name=order_code,json=orderCode,proto3" json:"order_code,omitempty"`
+	Checklist     *ChecklistConfirmation `protobuf:"bytes,4,opt,name=checklist,proto3" json:"checklist,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConfirmPickupRequest) Reset() {
+	*x = ConfirmPickupRequest{}
+	mi := &file_orderspb_orders_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConfirmPickupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConfirmPickupRequest) ProtoMessage() {}
+
+func (x *ConfirmPickupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConfirmPickupRequest.ProtoReflect.Descriptor instead.
+func (*ConfirmPickupRequest) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ConfirmPickupRequest) GetOrderId() string {
+	if x != nil {
+		return x.OrderId
+	}
+	return ""
+}
+
+func (x *ConfirmPickupRequest) GetRiderId() string {
+	if x != nil {
+		return x.RiderId
+	}
+	return ""
+}
+
+func (x *ConfirmPickupRequest) GetOrderCode() string {
+	if x != nil {
+		return x.OrderCode
+	}
+	return ""
+}
+
+func (x *ConfirmPickupRequest) GetChecklist() *ChecklistConfirmation {
+	if x != nil {
+		return x.Checklist
+	}
+	return nil
+}
+
+type ConfirmDeliveryRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	OrderId       string                 `protobuf:"bytes,1,opt,name=order_id,json=orderId,proto3" json:"order_id,omitempty"`
+	RiderId       string                 `protobuf:"bytes,2,opt,name=rider_id,json=riderId,proto3" json:"rider_id,omitempty"`
+	SignatureHash string                 `protobuf:"bytes,3,opt,name=signature_hash,json=signatureHash,proto3" json:"signature_hash,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConfirmDeliveryRequest) Reset() {
+	*x = ConfirmDeliveryRequest{}
+	mi := &file_orderspb_orders_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConfirmDeliveryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConfirmDeliveryRequest) ProtoMessage() {}
+
+func (x *ConfirmDeliveryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_orderspb_orders_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConfirmDeliveryRequest.ProtoReflect.Descriptor instead.
+func (*ConfirmDeliveryRequest) Descriptor() ([]byte, []int) {
+	return file_orderspb_orders_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *ConfirmDeliveryRequest) GetOrderId() string {
+	if x != nil {
+		return x.OrderId
+	}
+	return ""
+}
+
+func (x *ConfirmDeliveryRequest) GetRiderId() string {
+	if x != nil {
+		return x.RiderId
+	}
+	return ""
+}
+
+func (x *ConfirmDeliveryRequest) GetSignatureHash() string {
+	if x != nil {
+		return x.SignatureHash
+	}
+	return ""
+}
+
+var File_orderspb_orders_proto protoreflect.FileDescriptor
+
+const file_orderspb_orders_proto_rawDesc = "" +
+	"\n" +
+	"\x15orderspb/orders.proto\x12\torders.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x82\x02\n" +
+	"\bMenuItem\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x14\n" +
+	"\x05price\x18\x03 \x01(\x01R\x05price\x12 \n" +
+	"\vdescription\x18\x04 \x01(\tR\vdescription\x12\x1a\n" +
+	"\bcategory\x18\x05 \x01(\tR\bcategory\x12\x1c\n" +
+	"\tavailable\x18\x06 \x01(\bR\tavailable\x12\x1f\n" +
+	"\bquantity\x18\a \x01(\x05H\x00R\bquantity\x88\x01\x01\x12\x1a\n" +
+	"\bcurrency\x18\b \x01(\tR\bcurrency\x12\x16\n" +
+	"\x06locale\x18\t \x01(\tR\x06localeB\v\n" +
+	"\t_quantity\"V\n" +
+	"\x04Menu\x12#\n" +
+	"\rrestaurant_id\x18\x01 \x01(\tR\frestaurantId\x12)\n" +
+	"\x05items\x18\x02 \x03(\v2\x13.orders.v1.MenuItemR\x05items\"M\n" +
+	"\x0eGetMenuRequest\x12#\n" +
+	"\rrestaurant_id\x18\x01 \x01(\tR\frestaurantId\x12\x16\n" +
+	"\x06locale\x18\x02 \x01(\tR\x06locale\"@\n" +
+	"\tOrderItem\x12\x17\n" +
+	"\amenu_id\x18\x01 \x01(\tR\x06menuId\x12\x1a\n" +
+	"\bquantity\x18\x02 \x01(\x05R\bquantity\"\x9c\x01\n" +
+	"\x0fDeliveryOptions\x12\"\n" +
+	"\rleave_at_door\x18\x01 \x01(\bR\vleaveAtDoor\x12&\n" +
+	"\x0fcall_on_arrival\x18\x02 \x01(\bR\rcallOnArrival\x12\x1b\n" +
+	"\tgate_code\x18\x03 \x01(\tR\bgateCode\x12 \n" +
+	"\vcontactless\x18\x04 \x01(\bR\vcontactless\".\n" +
+	"\bGeoPoint\x12\x10\n" +
+	"\x03lat\x18\x01 \x01(\x01R\x03lat\x12\x10\n" +
+	"\x03lng\x18\x02 \x01(\x01R\x03lng\"\xa0\x01\n" +
+	"\vGiftDetails\x12%\n" +
+	"\x0erecipient_name\x18\x01 \x01(\tR\rrecipientName\x12'\n" +
+	"\x0frecipient_phone\x18\x02 \x01(\tR\x0erecipientPhone\x12'\n" +
+	"\x0frecipient_email\x18\x03 \x01(\tR\x0erecipientEmail\x12\x18\n" +
+	"\amessage\x18\x04 \x01(\tR\amessage\"\xff\x01\n" +
+	"\x0ePriceBreakdown\x12\x1a\n" +
+	"\bsubtotal\x18\x01 \x01(\x01R\bsubtotal\x12!\n" +
+	"\fdelivery_fee\x18\x02 \x01(\x01R\vdeliveryFee\x12\x14\n" +
+	"\x05surge\x18\x03 \x01(\bR\x05surge\x12\x1d\n" +
+	"\n" +
+	"promo_code\x18\x04 \x01(\tR\tpromoCode\x12\x1a\n" +
+	"\bdiscount\x18\x05 \x01(\x01R\bdiscount\x12\x19\n" +
+	"\btax_rate\x18\x06 \x01(\x01R\ataxRate\x12\x10\n" +
+	"\x03tax\x18\a \x01(\x01R\x03tax\x12\x14\n" +
+	"\x05total\x18\b \x01(\x01R\x05total\x12\x1a\n" +
+	"\bcurrency\x18\t \x01(\tR\bcurrency\"X\n" +
+	"\rChecklistItem\x12\x17\n" +
+	"\amenu_id\x18\x01 \x01(\tR\x06menuId\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x1a\n" +
+	"\bquantity\x18\x03 \x01(\x05R\bquantity\"\x89\x01\n" +
+	"\x0fPickupChecklist\x12.\n" +
+	"\x05items\x18\x01 \x03(\v2\x18.orders.v1.ChecklistItemR\x05items\x12\x12\n" +
+	"\x04bags\x18\x02 \x01(\x05R\x04bags\x12\x16\n" +
+	"\x06drinks\x18\x03 \x01(\x05R\x06drinks\x12\x1a\n" +
+	"\butensils\x18\x04 \x01(\bR\butensils\"_\n" +
+	"\x15ChecklistConfirmation\x12\x12\n" +
+	"\x04bags\x18\x01 \x01(\x05R\x04bags\x12\x16\n" +
+	"\x06drinks\x18\x02 \x01(\x05R\x06drinks\x12\x1a\n" +
+	"\butensils\x18\x03 \x01(\bR\butensils\"\xff\x06\n" +
+	"\x05Order\x12\x19\n" +
+	"\border_id\x18\x01 \x01(\tR\aorderId\x12\x12\n" +
+	"\x04code\x18\x02 \x01(\tR\x04code\x12#\n" +
+	"\rrestaurant_id\x18\x03 \x01(\tR\frestaurantId\x12\x1f\n" +
+	"\vcustomer_id\x18\x04 \x01(\tR\n" +
+	"customerId\x12*\n" +
+	"\x05items\x18\x05 \x03(\v2\x14.orders.v1.OrderItemR\x05items\x12!\n" +
+	"\ftotal_amount\x18\x06 \x01(\x01R\vtotalAmount\x12\x16\n" +
+	"\x06status\x18\a \x01(\tR\x06status\x12#\n" +
+	"\rstatus_reason\x18\b \x01(\tR\fstatusReason\x12%\n" +
+	"\x0epayment_status\x18\t \x01(\tR\rpaymentStatus\x12\x19\n" +
+	"\brider_id\x18\n" +
+	" \x01(\tR\ariderId\x12E\n" +
+	"\x10delivery_options\x18\v \x01(\v2\x1a.orders.v1.DeliveryOptionsR\x0fdeliveryOptions\x12@\n" +
+	"\x11delivery_location\x18\f \x01(\v2\x13.orders.v1.GeoPointR\x10deliveryLocation\x12\x1d\n" +
+	"\n" +
+	"promo_code\x18\r \x01(\tR\tpromoCode\x12\x1a\n" +
+	"\butensils\x18\x0e \x01(\bR\butensils\x123\n" +
+	"\apricing\x18\x0f \x01(\v2\x19.orders.v1.PriceBreakdownR\apricing\x12E\n" +
+	"\x10pickup_checklist\x18\x10 \x01(\v2\x1a.orders.v1.PickupChecklistR\x0fpickupChecklist\x129\n" +
+	"\n" +
+	"created_at\x18\x11 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"updated_at\x18\x12 \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\x125\n" +
+	"\bready_by\x18\x13 \x01(\v2\x1a.google.protobuf.TimestampR\areadyBy\x12\x1a\n" +
+	"\bcurrency\x18\x14 \x01(\tR\bcurrency\x12*\n" +
+	"\x04gift\x18\x15 \x01(\v2\x16.orders.v1.GiftDetailsR\x04gift\"\xd4\x02\n" +
+	"\x11PlaceOrderRequest\x12#\n" +
+	"\rrestaurant_id\x18\x01 \x01(\tR\frestaurantId\x12*\n" +
+	"\x05items\x18\x02 \x03(\v2\x14.orders.v1.OrderItemR\x05items\x12E\n" +
+	"\x10delivery_options\x18\x03 \x01(\v2\x1a.orders.v1.DeliveryOptionsR\x0fdeliveryOptions\x12@\n" +
+	"\x11delivery_location\x18\x04 \x01(\v2\x13.orders.v1.GeoPointR\x10deliveryLocation\x12\x1d\n" +
+	"\n" +
+	"promo_code\x18\x05 \x01(\tR\tpromoCode\x12\x1a\n" +
+	"\butensils\x18\x06 \x01(\bR\butensils\x12*\n" +
+	"\x04gift\x18\a \x01(\v2\x16.orders.v1.GiftDetailsR\x04gift\"\xae\x01\n" +
+	"\x12AcceptOrderRequest\x12\x19\n" +
+	"\border_id\x18\x01 \x01(\tR\aorderId\x12#\n" +
+	"\rrestaurant_id\x18\x02 \x01(\tR\frestaurantId\x125\n" +
+	"\bready_by\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\areadyBy\x12!\n" +
+	"\fprep_minutes\x18\x04 \x01(\x05R\vprepMinutes\"\xab\x01\n" +
+	"\x14ConfirmPickupRequest\x12\x19\n" +
+	"\border_id\x18\x01 \x01(\tR\aorderId\x12\x19\n" +
+	"\brider_id\x18\x02 \x01(\tR\ariderId\x12\x1d\n" +
+	"\n" +
+	"order_code\x18\x03 \x01(\tR\torderCode\x12>\n" +
+	"\tchecklist\x18\x04 \x01(\v2 .orders.v1.ChecklistConfirmationR\tchecklist\"u\n" +
+	"\x16ConfirmDeliveryRequest\x12\x19\n" +
+	"\border_id\x18\x01 \x01(\tR\aorderId\x12\x19\n" +
+	"\brider_id\x18\x02 \x01(\tR\ariderId\x12%\n" +
+	"\x0esignature_hash\x18\x03 \x01(\tR\rsignatureHash2\xcf\x02\n" +
+	"\fOrderService\x125\n" +
+	"\aGetMenu\x12\x19.orders.v1.GetMenuRequest\x1a\x0f.orders.v1.Menu\x12<\n" +
+	"\n" +
+	"PlaceOrder\x12\x1c.orders.v1.PlaceOrderRequest\x1a\x10.orders.v1.Order\x12>\n" +
+	"\vAcceptOrder\x12\x1d.orders.v1.AcceptOrderRequest\x1a\x10.orders.v1.Order\x12B\n" +
+	"\rConfirmPickup\x12\x1f.orders.v1.ConfirmPickupRequest\x1a\x10.orders.v1.Order\x12F\n" +
+	"\x0fConfirmDelivery\x12!.orders.v1.ConfirmDeliveryRequest\x1a\x10.orders.v1.OrderB\x18Z\x16myproject/src/orderspbb\x06proto3"
+
+var (
+	file_orderspb_orders_proto_rawDescOnce sync.Once
+	file_orderspb_orders_proto_rawDescData []byte
+)
+
+func file_orderspb_orders_proto_rawDescGZIP() []byte {
+	file_orderspb_orders_proto_rawDescOnce.Do(func() {
+		file_orderspb_orders_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_orderspb_orders_proto_rawDesc), len(file_orderspb_orders_proto_rawDesc)))
+	})
+	return file_orderspb_orders_proto_rawDescData
+}
+
+var file_orderspb_orders_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
+var file_orderspb_orders_proto_goTypes = []any{
+	(*MenuItem)(nil),               // 0: orders.v1.MenuItem
+	(*Menu)(nil),                   // 1: orders.v1.Menu
+	(*GetMenuRequest)(nil),         // 2: orders.v1.GetMenuRequest
+	(*OrderItem)(nil),              // 3: orders.v1.OrderItem
+	(*DeliveryOptions)(nil),        // 4: orders.v1.DeliveryOptions
+	(*GeoPoint)(nil),               // 5: orders.v1.GeoPoint
+	(*GiftDetails)(nil),            // 6: orders.v1.GiftDetails
+	(*PriceBreakdown)(nil),         // 7: orders.v1.PriceBreakdown
+	(*ChecklistItem)(nil),          // 8: orders.v1.ChecklistItem
+	(*PickupChecklist)(nil),        // 9: orders.v1.PickupChecklist
+	(*ChecklistConfirmation)(nil),  // 10: orders.v1.ChecklistConfirmation
+	(*Order)(nil),                  // 11: orders.v1.Order
+	(*PlaceOrderRequest)(nil),      // 12: orders.v1.PlaceOrderRequest
+	(*AcceptOrderRequest)(nil),     // 13: orders.v1.AcceptOrderRequest
+	(*ConfirmPickupRequest)(nil),   // 14: orders.v1.ConfirmPickupRequest
+	(*ConfirmDeliveryRequest)(nil), // 15: orders.v1.ConfirmDeliveryRequest
+	(*timestamppb.Timestamp)(nil),  // 16: google.protobuf.Timestamp
+}
+var file_orderspb_orders_proto_depIdxs = []int32{
+	0,  // 0: orders.v1.Menu.items:type_name -> orders.v1.MenuItem
+	8,  // 1: orders.v1.PickupChecklist.items:type_name -> orders.v1.ChecklistItem
+	3,  // 2: orders.v1.Order.items:type_name -> orders.v1.OrderItem
+	4,  // 3: orders.v1.Order.delivery_options:type_name -> orders.v1.DeliveryOptions
+	5,  // 4: orders.v1.Order.delivery_location:type_name -> orders.v1.GeoPoint
+	7,  // 5: orders.v1.Order.pricing:type_name -> orders.v1.PriceBreakdown
+	9,  // 6: orders.v1.Order.pickup_checklist:type_name -> orders.v1.PickupChecklist
+	16, // 7: orders.v1.Order.created_at:type_name -> google.protobuf.Timestamp
+	16, // 8: orders.v1.Order.updated_at:type_name -> google.protobuf.Timestamp
+	16, // 9: orders.v1.Order.ready_by:type_name -> google.protobuf.Timestamp
+	6,  // 10: orders.v1.Order.gift:type_name -> orders.v1.GiftDetails
+	3,  // 11: orders.v1.PlaceOrderRequest.items:type_name -> orders.v1.OrderItem
+	4,  // 12: orders.v1.PlaceOrderRequest.delivery_options:type_name -> orders.v1.DeliveryOptions
+	5,  // 13: orders.v1.PlaceOrderRequest.delivery_location:type_name -> orders.v1.GeoPoint
+	6,  // 14: orders.v1.PlaceOrderRequest.gift:type_name -> orders.v1.GiftDetails
+	16, // 15: orders.v1.AcceptOrderRequest.ready_by:type_name -> google.protobuf.Timestamp
+	10, // 16: orders.v1.ConfirmPickupRequest.checklist:type_name -> orders.v1.ChecklistConfirmation
+	2,  // 17: orders.v1.OrderService.GetMenu:input_type -> orders.v1.GetMenuRequest
+	12, // 18: orders.v1.OrderService.PlaceOrder:input_type -> orders.v1.PlaceOrderRequest
+	13, // 19: orders.v1.OrderService.AcceptOrder:input_type -> orders.v1.AcceptOrderRequest
+	14, // 20: orders.v1.OrderService.ConfirmPickup:input_type -> orders.v1.ConfirmPickupRequest
+	15, // 21: orders.v1.OrderService.ConfirmDelivery:input_type -> orders.v1.ConfirmDeliveryRequest
+	1,  // 22: orders.v1.OrderService.GetMenu:output_type -> orders.v1.Menu
+	11, // 23: orders.v1.OrderService.PlaceOrder:output_type -> orders.v1.Order
+	11, // 24: orders.v1.OrderService.AcceptOrder:output_type -> orders.v1.Order
+	11, // 25: orders.v1.OrderService.ConfirmPickup:output_type -> orders.v1.Order
+	11, // 26: orders.v1.OrderService.ConfirmDelivery:output_type -> orders.v1.Order
+	22, // [22:27] is the sub-list for method output_type
+	17, // [17:22] is the sub-list for method input_type
+	17, // [17:17] is the sub-list for extension type_name
+	17, // [17:17] is the sub-list for extension extendee
+	0,  // [0:17] is the sub-list for field type_name
+}
+
+func init() { file_orderspb_orders_proto_init() }
+func file_orderspb_orders_proto_init() {
+	if File_orderspb_orders_proto != nil {
+		return
+	}
+	file_orderspb_orders_proto_msgTypes[0].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_orderspb_orders_proto_rawDesc), len(file_orderspb_orders_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   16,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_orderspb_orders_proto_goTypes,
+		DependencyIndexes: file_orderspb_orders_proto_depIdxs,
+		MessageInfos:      file_orderspb_orders_proto_msgTypes,
+	}.Build()
+	File_orderspb_orders_proto = out.File
+	file_orderspb_orders_proto_goTypes = nil
+	file_orderspb_orders_proto_depIdxs = nil
+}